@@ -0,0 +1,112 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+
+	"github.com/gorilla/mux"
+	"github.com/maxsupermanhd/WebChunk/credentials"
+	"github.com/maxsupermanhd/WebChunk/proxy"
+)
+
+// accounts give access to someone's game profile, same as purge
+// they are only managed with reveal token if one is configured
+func accountsAllowed(r *http.Request) bool {
+	return cfg.GetDSString("", "privacy", "reveal_token") == "" || privacyCanReveal(r)
+}
+
+func apiListAccounts(w http.ResponseWriter, r *http.Request) (int, string) {
+	if !accountsAllowed(r) {
+		return http.StatusForbidden, "Reveal token required"
+	}
+	accounts, err := proxy.NewCredentialsManager(cfg.SubTree("proxy")).ListAccounts()
+	if err != nil {
+		return 500, "Failed to list accounts: " + err.Error()
+	}
+	setContentTypeJson(w)
+	return marshalOrFail(200, accounts)
+}
+
+// answers with code user has to enter on Microsoft page, account
+// appears in the list after they do, progress is at the login status endpoint
+func apiStartAccountLogin(w http.ResponseWriter, r *http.Request) (int, string) {
+	if !accountsAllowed(r) {
+		return http.StatusForbidden, "Reveal token required"
+	}
+	l, err := proxy.NewCredentialsManager(cfg.SubTree("proxy")).StartDeviceLogin()
+	if err != nil {
+		return 502, "Failed to start login: " + err.Error()
+	}
+	setContentTypeJson(w)
+	return marshalOrFail(201, l)
+}
+
+func apiAccountLoginStatus(w http.ResponseWriter, r *http.Request) (int, string) {
+	if !accountsAllowed(r) {
+		return http.StatusForbidden, "Reveal token required"
+	}
+	l, ok := credentials.GetDeviceLogin(mux.Vars(r)["code"])
+	if !ok {
+		return 404, "Login not found"
+	}
+	setContentTypeJson(w)
+	return marshalOrFail(200, l)
+}
+
+func apiRefreshAccount(w http.ResponseWriter, r *http.Request) (int, string) {
+	if !accountsAllowed(r) {
+		return http.StatusForbidden, "Reveal token required"
+	}
+	name := mux.Vars(r)["name"]
+	if !credentials.ValidUsername(name) {
+		return 400, "Bad account name"
+	}
+	auth, err := proxy.NewCredentialsManager(cfg.SubTree("proxy")).RefreshAuth(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return 404, "Account not found"
+	}
+	if err != nil {
+		return 502, "Failed to refresh account: " + err.Error()
+	}
+	setContentTypeJson(w)
+	return marshalOrFail(200, map[string]string{"Name": auth.Name, "UUID": auth.UUID})
+}
+
+func apiRemoveAccount(w http.ResponseWriter, r *http.Request) (int, string) {
+	if !accountsAllowed(r) {
+		return http.StatusForbidden, "Reveal token required"
+	}
+	name := mux.Vars(r)["name"]
+	if !credentials.ValidUsername(name) {
+		return 400, "Bad account name"
+	}
+	err := proxy.NewCredentialsManager(cfg.SubTree("proxy")).RemoveAccount(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return 404, "Account not found"
+	}
+	if err != nil {
+		return 500, "Failed to remove account: " + err.Error()
+	}
+	return 200, "Account removed"
+}
@@ -0,0 +1,292 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/maxsupermanhd/WebChunk/chunkStorage"
+)
+
+// review note on a chunk or on a whole region file when Region is set,
+// last record for the place wins same as with markers
+type annotationRecord struct {
+	Region  bool `json:",omitempty"`
+	X, Z    int
+	Status  string `json:",omitempty"`
+	Note    string `json:",omitempty"`
+	Author  string `json:",omitempty"`
+	Deleted bool   `json:",omitempty"`
+	Time    time.Time
+}
+
+type annotationPlace struct {
+	region bool
+	x, z   int
+}
+
+var (
+	annotations     = map[entityDensityKey]map[annotationPlace]annotationRecord{}
+	annotationsLock sync.Mutex
+)
+
+var defaultAnnotationStatuses = map[string]string{
+	"reviewed":        "#2e9e44",
+	"needs_recapture": "#d9412b",
+	"in_progress":     "#e0a800",
+}
+
+// status name to color, free text notes without status are gray
+func annotationStatuses() map[string]string {
+	ret := map[string]string{}
+	if err := cfg.GetToStruct(&ret, "annotations", "statuses"); err != nil || len(ret) == 0 {
+		return defaultAnnotationStatuses
+	}
+	return ret
+}
+
+// editor name for token in X-Annotation-Token header or bearer authorization
+func annotationEditor(r *http.Request) string {
+	editors := map[string]string{}
+	if err := cfg.GetToStruct(&editors, "annotations", "editors"); err != nil {
+		return ""
+	}
+	given := r.Header.Get("X-Annotation-Token")
+	if given == "" {
+		given = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	if given == "" {
+		return ""
+	}
+	for token, name := range editors {
+		if subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1 {
+			return name
+		}
+	}
+	return ""
+}
+
+// must be called with annotationsLock held, loads index from records on first use
+func getAnnotations(wname, dname string) map[annotationPlace]annotationRecord {
+	k := entityDensityKey{world: wname, dimension: dname}
+	idx, ok := annotations[k]
+	if ok {
+		return idx
+	}
+	idx = map[annotationPlace]annotationRecord{}
+	err := recs.Read(wname, dname, "annotations", func(m json.RawMessage) error {
+		var r annotationRecord
+		if json.Unmarshal(m, &r) != nil {
+			return nil
+		}
+		p := annotationPlace{region: r.Region, x: r.X, z: r.Z}
+		if r.Deleted {
+			delete(idx, p)
+		} else {
+			idx[p] = r
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to load annotations of %s %s: %s", wname, dname, err.Error())
+		return nil
+	}
+	annotations[k] = idx
+	return idx
+}
+
+func saveAnnotation(wname, dname string, r annotationRecord) error {
+	annotationsLock.Lock()
+	defer annotationsLock.Unlock()
+	idx := getAnnotations(wname, dname)
+	if err := recs.Append(wname, dname, "annotations", r); err != nil {
+		return err
+	}
+	p := annotationPlace{region: r.Region, x: r.X, z: r.Z}
+	if idx != nil {
+		if r.Deleted {
+			delete(idx, p)
+		} else {
+			idx[p] = r
+		}
+	}
+	cx0, cz0, cx1, cz1 := r.X, r.Z, r.X+1, r.Z+1
+	if r.Region {
+		cx0, cz0, cx1, cz1 = r.X*32, r.Z*32, r.X*32+32, r.Z*32+32
+	}
+	for x := cx0; x < cx1; x++ {
+		for z := cz0; z < cz1; z++ {
+			markChunkVariantDirty(chunkKey{world: wname, dim: dname, x: x, z: z}, "annotations")
+		}
+	}
+	return nil
+}
+
+// chunk annotation hides the one of its region
+func getAnnotationsRegion(wname, dname string, cx0, cz0, cx1, cz1 int) ([]chunkStorage.ChunkData, error) {
+	annotationsLock.Lock()
+	defer annotationsLock.Unlock()
+	ret := []chunkStorage.ChunkData{}
+	idx := getAnnotations(wname, dname)
+	if len(idx) == 0 {
+		return ret, nil
+	}
+	for x := cx0; x < cx1; x++ {
+		for z := cz0; z < cz1; z++ {
+			a, ok := idx[annotationPlace{x: x, z: z}]
+			if !ok {
+				a, ok = idx[annotationPlace{region: true, x: floorDiv(x, 32), z: floorDiv(z, 32)}]
+			}
+			if ok {
+				ret = append(ret, chunkStorage.ChunkData{X: x, Z: z, Data: a.Status})
+			}
+		}
+	}
+	return ret, nil
+}
+
+func drawAnnotation(status string) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	c := color.RGBA{128, 128, 128, 255}
+	if s, ok := annotationStatuses()[status]; ok {
+		fmt.Sscanf(s, "#%02x%02x%02x", &c.R, &c.G, &c.B)
+	}
+	c.R, c.G, c.B, c.A = c.R/2, c.G/2, c.B/2, 128 // premultiplied
+	draw.Draw(img, img.Bounds(), &image.Uniform{c}, image.Point{}, draw.Src)
+	// outline so neighbouring chunks with same status are still told apart
+	edge := color.RGBA{c.R, c.G, c.B, 192}
+	for i := 0; i < 16; i++ {
+		img.SetRGBA(i, 0, edge)
+		img.SetRGBA(0, i, edge)
+	}
+	return img
+}
+
+// filters are status, author, region (true or false) and bounding box
+// in chunk coordinates cx0, cz0, cx1, cz1, region annotations match if they touch it
+func apiListAnnotations(w http.ResponseWriter, r *http.Request) (int, string) {
+	params := mux.Vars(r)
+	status, author := r.FormValue("status"), r.FormValue("author")
+	var onlyRegion *bool
+	if s := r.FormValue("region"); s != "" {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return 400, "Bad region: " + err.Error()
+		}
+		onlyRegion = &b
+	}
+	var box []int
+	if r.FormValue("cx0") != "" {
+		var err error
+		box, err = parseFormInts(r, "cx0", "cz0", "cx1", "cz1")
+		if err != nil {
+			return 400, err.Error()
+		}
+	}
+	annotationsLock.Lock()
+	ret := []annotationRecord{}
+	for _, a := range getAnnotations(params["world"], params["dim"]) {
+		if status != "" && a.Status != status ||
+			author != "" && !strings.EqualFold(a.Author, author) ||
+			onlyRegion != nil && a.Region != *onlyRegion {
+			continue
+		}
+		if box != nil {
+			x0, z0, x1, z1 := a.X, a.Z, a.X+1, a.Z+1
+			if a.Region {
+				x0, z0, x1, z1 = a.X*32, a.Z*32, a.X*32+32, a.Z*32+32
+			}
+			if x1 <= box[0] || x0 >= box[2] || z1 <= box[1] || z0 >= box[3] {
+				continue
+			}
+		}
+		ret = append(ret, a)
+	}
+	annotationsLock.Unlock()
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Time.After(ret[j].Time) })
+	setContentTypeJson(w)
+	return marshalOrFail(200, ret)
+}
+
+func apiSetAnnotation(w http.ResponseWriter, r *http.Request) (int, string) {
+	params := mux.Vars(r)
+	editor := annotationEditor(r)
+	if editor == "" {
+		return 403, "Annotation token required"
+	}
+	var a annotationRecord
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		return bodyReadErrorStatus(err), "Bad request: " + err.Error()
+	}
+	if a.Status != "" {
+		if _, ok := annotationStatuses()[a.Status]; !ok {
+			return 400, "Unknown status " + a.Status
+		}
+	}
+	if a.Status == "" && a.Note == "" {
+		return 400, "Either status or note is required"
+	}
+	a.Author, a.Time, a.Deleted = editor, time.Now(), false
+	if err := saveAnnotation(params["world"], params["dim"], a); err != nil {
+		return 500, err.Error()
+	}
+	setContentTypeJson(w)
+	return marshalOrFail(200, a)
+}
+
+// place is "chunk" or "region"
+func apiDeleteAnnotation(w http.ResponseWriter, r *http.Request) (int, string) {
+	params := mux.Vars(r)
+	editor := annotationEditor(r)
+	if editor == "" {
+		return 403, "Annotation token required"
+	}
+	x, errx := strconv.Atoi(params["x"])
+	z, errz := strconv.Atoi(params["z"])
+	if errx != nil || errz != nil {
+		return 400, "Bad coordinates"
+	}
+	err := saveAnnotation(params["world"], params["dim"], annotationRecord{
+		Region:  params["place"] == "region",
+		X:       x,
+		Z:       z,
+		Author:  editor,
+		Deleted: true,
+		Time:    time.Now(),
+	})
+	if err != nil {
+		return 500, err.Error()
+	}
+	return 200, "Annotation deleted"
+}
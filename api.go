@@ -79,19 +79,19 @@ func apiAddChunkHandler(w http.ResponseWriter, r *http.Request) (int, string) {
 	wname := params["world"]
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		return http.StatusBadRequest, fmt.Sprintf("Error reading request: %s", err)
+		return bodyReadErrorStatus(err), fmt.Sprintf("Error reading request: %s", err)
 	}
 	col, err := chunkStorage.ConvFlexibleNBTtoSave(body)
 	if err != nil {
 		return http.StatusBadRequest, fmt.Sprintf("Error parsing chunk data: %s", err)
 	}
-	world, s, err := chunkStorage.GetWorldStorage(storages, wname)
+	world, s, err := storages.World(wname)
 	if err != nil {
 		return http.StatusInternalServerError, fmt.Sprintf("Error checking world: %s", err)
 	}
 	if s == nil {
 		pref := cfg.GetDSString("", "preferred_storage")
-		s = findCapableStorage(storages, pref)
+		s = storages.Capable(pref)
 		if s == nil {
 			return http.StatusNotFound, fmt.Sprintf("Failed to find storage that has world [%s], named [%s] or has ability to add chunks, chunk [%d:%d] is LOST.", wname, pref, col.XPos, col.ZPos)
 		}
@@ -146,23 +146,22 @@ func apiAddChunkHandler(w http.ResponseWriter, r *http.Request) (int, string) {
 		log.Printf("Failed to submit chunk %v:%v world %v dimension %v: %v", col.XPos, col.ZPos, wname, dname, err.Error())
 		return http.StatusInternalServerError, fmt.Sprintf("Failed to add chunk to storage: %s", err.Error())
 	}
+	captureBlockMarkers(wname, dname, int(col.XPos), int(col.ZPos), col)
 	log.Print("Submitted chunk ", col.XPos, col.ZPos, " world ", wname, " dimension ", dname)
 	dTTYPE := r.Header.Get("WebChunk-DrawTTYPE")
 	if dTTYPE != "" {
 		var dPainter chunkPainterFunc
 		if dTTYPE == "default" {
-			for i := range ttypes {
+			for i, drawTTYPE := range ttypes.All() {
 				if i.IsDefault {
 					dTTYPE = i.Name
-					drawTTYPE := ttypes[i]
 					_, dPainter = drawTTYPE(s)
 					break
 				}
 			}
 		} else {
-			for i := range ttypes {
+			for i, drawTTYPE := range ttypes.All() {
 				if i.Name == dTTYPE {
-					drawTTYPE := ttypes[i]
 					_, dPainter = drawTTYPE(s)
 					break
 				}
@@ -173,7 +172,7 @@ func apiAddChunkHandler(w http.ResponseWriter, r *http.Request) (int, string) {
 		}
 		w.WriteHeader(http.StatusOK)
 		img := dPainter(col)
-		writeImage(w, "png", img)
+		writeImage(w, r, "png", img)
 		imageCacheSave(img, wname, dname, dTTYPE, 0, int(col.XPos), int(col.ZPos))
 		return -1, ""
 	}
@@ -266,9 +265,7 @@ func apiStoragesGET(_ http.ResponseWriter, _ *http.Request) (int, string) {
 		Type   string
 		Status string
 	}{}
-	storagesLock.Lock()
-	defer storagesLock.Unlock()
-	for sn, s := range storages {
+	for sn, s := range storages.Snapshot() {
 		status, err := s.Driver.GetStatus()
 		if err != nil {
 			status = err.Error()
@@ -288,30 +285,36 @@ func apiStoragesGET(_ http.ResponseWriter, _ *http.Request) (int, string) {
 
 func apiStorageReinit(_ http.ResponseWriter, r *http.Request) (int, string) {
 	sname := mux.Vars(r)["storage"]
-	storagesLock.Lock()
-	defer storagesLock.Unlock()
-	s, ok := storages[sname]
-	if !ok {
-		return 204, "No such storage"
-	}
-	var err error
-	if s.Driver != nil {
-		err = s.Driver.Close()
-	}
-	if err != nil {
-		return 500, "Failed to close storage: " + err.Error()
-	}
-	d, err := newStorage(s.Type, s.Address)
-	if err != nil {
-		return 500, err.Error()
-	}
-	c, err := d.GetStatus()
-	if err != nil {
-		return 500, err.Error()
-	}
-	s.Driver = d
-	storages[sname] = s
-	return 200, c
+	code, c := 200, ""
+	storages.Modify(func(m map[string]chunkStorage.Storage) error {
+		s, ok := m[sname]
+		if !ok {
+			code, c = 204, "No such storage"
+			return nil
+		}
+		var err error
+		if s.Driver != nil {
+			err = s.Driver.Close()
+		}
+		if err != nil {
+			code, c = 500, "Failed to close storage: "+err.Error()
+			return nil
+		}
+		d, err := newStorage(s.Type, s.Address)
+		if err != nil {
+			code, c = 500, err.Error()
+			return nil
+		}
+		c, err = d.GetStatus()
+		if err != nil {
+			code, c = 500, err.Error()
+			return nil
+		}
+		s.Driver = d
+		m[sname] = s
+		return nil
+	})
+	return code, c
 }
 
 func apiStorageAdd(_ http.ResponseWriter, r *http.Request) (int, string) {
@@ -327,36 +330,46 @@ func apiStorageAdd(_ http.ResponseWriter, r *http.Request) (int, string) {
 	if t == "" {
 		return 400, "Empty type"
 	}
-	storagesLock.Lock()
-	defer storagesLock.Unlock()
-	_, ok := storages[name]
-	if ok {
-		return 400, "Storage with that name already exists"
-	}
-	driver, err := newStorage(t, address)
-	if err != nil {
-		if err == errStorageTypeNotImplemented {
-			return 400, err.Error()
+	code, ver := 200, ""
+	storages.Modify(func(m map[string]chunkStorage.Storage) error {
+		_, ok := m[name]
+		if ok {
+			code, ver = 400, "Storage with that name already exists"
+			return nil
 		}
-		return 500, err.Error()
-	}
-	ver, err := driver.GetStatus()
-	if err != nil {
-		return 500, err.Error()
-	}
-	storages[name] = chunkStorage.Storage{
-		Type:    t,
-		Address: address,
-		Driver:  driver,
-	}
-	return 200, ver
+		driver, err := newStorage(t, address)
+		if err != nil {
+			if err == errStorageTypeNotImplemented {
+				code, ver = 400, err.Error()
+			} else {
+				code, ver = 500, err.Error()
+			}
+			return nil
+		}
+		ver, err = driver.GetStatus()
+		if err != nil {
+			code, ver = 500, err.Error()
+			return nil
+		}
+		m[name] = chunkStorage.Storage{
+			Type:    t,
+			Address: address,
+			Driver:  driver,
+		}
+		return nil
+	})
+	return code, ver
 }
 
-func apiListRenderers(_ http.ResponseWriter, _ *http.Request) (int, string) {
-	keys := make([]ttype, 0, len(ttypes))
-	for t := range ttypes {
+// with world and dim query parameters only layers requester can see there are listed
+func apiListRenderers(_ http.ResponseWriter, r *http.Request) (int, string) {
+	keys := make([]ttype, 0, ttypes.Len())
+	for t := range ttypes.All() {
 		keys = append(keys, t)
 	}
 	sort.Slice(keys, func(i, j int) bool { return strings.Compare(keys[i].Name, keys[j].Name) > 0 })
+	if wname := r.URL.Query().Get("world"); wname != "" {
+		keys = allowedLayers(r, wname, r.URL.Query().Get("dim"), keys)
+	}
 	return marshalOrFail(200, keys)
 }
@@ -22,8 +22,10 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 )
 
 func apiHandle(f func(http.ResponseWriter, *http.Request) (int, string)) func(http.ResponseWriter, *http.Request) {
@@ -33,7 +35,7 @@ func apiHandle(f func(http.ResponseWriter, *http.Request) (int, string)) func(ht
 			return
 		}
 		if code == 500 {
-			log.Println("500 error code: " + content)
+			log.Printf("500 error code [%s]: %s", requestID(r), content)
 		}
 		w.Header().Set("Server", "WebChunk webserver "+CommitHash)
 		w.Header().Set("Cache-Control", "no-cache")
@@ -57,3 +59,21 @@ func marshalOrFail(code int, content interface{}) (int, string) {
 func setContentTypeJson(w http.ResponseWriter) {
 	w.Header().Set("Content-Type", "application/json")
 }
+
+func parseFormInts(r *http.Request, keys ...string) ([]int, error) {
+	ret := make([]int, len(keys))
+	for i, k := range keys {
+		v, err := strconv.Atoi(r.FormValue(k))
+		if err != nil {
+			return nil, fmt.Errorf("bad %s: %w", k, err)
+		}
+		ret[i] = v
+	}
+	return ret, nil
+}
+
+// destructive endpoints only report what they would do when dry_run is set
+func isDryRun(r *http.Request) bool {
+	v, err := strconv.ParseBool(r.FormValue("dry_run"))
+	return err == nil && v
+}
@@ -0,0 +1,140 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const avifEncodeTimeout = 30 * time.Second
+
+// number of running avifenc processes, limit is read every time so it can
+// be changed without restart
+var avifEncoding atomic.Int32
+
+func avifAcquire() bool {
+	if avifEncoding.Add(1) > int32(cfg.GetDSInt(2, "avif", "max_concurrent")) {
+		avifEncoding.Add(-1)
+		return false
+	}
+	return true
+}
+
+// avif is made by avifenc, it can not write to a pipe so temporary files are used
+func encodeAvif(img image.Image) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "webchunk-avif")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	in, out := filepath.Join(dir, "tile.png"), filepath.Join(dir, "tile.avif")
+	f, err := os.Create(in)
+	if err != nil {
+		return nil, err
+	}
+	err = png.Encode(f, img)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), avifEncodeTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, cfg.GetDSString("avifenc", "avif", "avifenc"),
+		"-q", strconv.Itoa(cfg.GetDSInt(60, "avif", "quality")),
+		"-s", strconv.Itoa(cfg.GetDSInt(8, "avif", "speed")),
+		in, out)
+	stderr := bytes.Buffer{}
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("avifenc: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return os.ReadFile(out)
+}
+
+// falls back to png when encoder fails so map does not get holes, when
+// too many tiles are encoded already webp or png is sent instead of waiting
+// depending on Accept, tilingParams sets Vary for that
+func writeImageAvif(w http.ResponseWriter, r *http.Request, img *image.RGBA) {
+	if !avifAcquire() {
+		if acceptsImage(r, "image/webp") {
+			writeImageWebp(w, img)
+		} else {
+			writeImagePng(w, img)
+		}
+		return
+	}
+	b, err := encodeAvif(img)
+	avifEncoding.Add(-1)
+	if err != nil {
+		log.Printf("Unable to encode image: %s", err.Error())
+		writeImagePng(w, img)
+		return
+	}
+	w.Header().Set("Content-Type", "image/avif")
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	if _, err := w.Write(b); err != nil {
+		log.Printf("Unable to write image: %s", err.Error())
+	}
+}
+
+// negotiateImageFormat picks the smallest format client accepts for "auto"
+// format segment, browsers list avif and webp in Accept of image requests
+func negotiateImageFormat(r *http.Request) string {
+	switch {
+	case cfg.GetDSBool(false, "avif", "enabled") && acceptsImage(r, "image/avif"):
+		return "avif"
+	case acceptsImage(r, "image/webp"):
+		return "webp"
+	}
+	return "png"
+}
+
+func acceptsImage(r *http.Request, t string) bool {
+	for _, a := range strings.Split(r.Header.Get("Accept"), ",") {
+		mt, params, _ := strings.Cut(a, ";")
+		if strings.TrimSpace(mt) != t {
+			continue
+		}
+		for _, p := range strings.Split(params, ";") {
+			if k, v, _ := strings.Cut(strings.TrimSpace(p), "="); k == "q" {
+				q, err := strconv.ParseFloat(v, 64)
+				return err == nil && q > 0
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"image"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAvifBusyFallback(t *testing.T) {
+	defer cfg.Set(2, "avif", "max_concurrent")
+	cfg.Set(0, "avif", "max_concurrent")
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for _, c := range []struct {
+		accept, want string
+	}{
+		{"image/avif,image/webp,*/*", "image/webp"},
+		{"image/avif,image/webp;q=0,*/*", "image/png"},
+		{"image/avif,*/*", "image/png"},
+		{"", "image/png"},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept", c.accept)
+		w := httptest.NewRecorder()
+		writeImageAvif(w, r, img)
+		if got := w.Header().Get("Content-Type"); got != c.want {
+			t.Errorf("accept %q: got %s, want %s", c.accept, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,436 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/maxsupermanhd/WebChunk/chunkStorage"
+)
+
+// index lists backups in order they were made, each one has a manifest
+// at <ID>/manifest.json and a chunks file per dimension next to it
+const indexName = "index.json"
+
+type IndexEntry struct {
+	ID     string
+	Time   time.Time
+	Since  time.Time // zero for full backups
+	Chunks int
+	Size   int64
+}
+
+type DimensionManifest struct {
+	World     string
+	Dimension chunkStorage.SDim
+	File      string `json:",omitempty"`
+	Chunks    int
+	Size      int64
+	SHA256    string `json:",omitempty"`
+}
+
+type Manifest struct {
+	ID         string
+	Time       time.Time
+	Since      time.Time
+	Worlds     []chunkStorage.SWorld
+	Metadata   map[string]chunkStorage.WorldMetadata `json:",omitempty"`
+	Dimensions []DimensionManifest
+}
+
+func readJSON(t Target, name string, v any) error {
+	r, err := t.Get(name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return json.NewDecoder(r).Decode(v)
+}
+
+func writeJSON(t Target, name string, v any) error {
+	b, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+	return t.Put(name, bytes.NewReader(b), int64(len(b)))
+}
+
+// empty target has empty index
+func ReadIndex(t Target) ([]IndexEntry, error) {
+	ret := []IndexEntry{}
+	err := readJSON(t, indexName, &ret)
+	if errors.Is(err, ErrNotFound) {
+		err = nil
+	}
+	return ret, err
+}
+
+func ReadManifest(t Target, id string) (*Manifest, error) {
+	var m Manifest
+	return &m, readJSON(t, id+"/manifest.json", &m)
+}
+
+// chunks file is a sequence of x, z, modification unix time,
+// data length and raw chunk data as storage returns it
+type chunkHeader struct {
+	X, Z   int32
+	Mod    int64
+	Length uint32
+}
+
+// Run backs up chunks changed since the last backup on the target,
+// first backup made to a target has everything, meta is copied to the manifest
+func Run(storages map[string]chunkStorage.Storage, t Target, meta map[string]chunkStorage.WorldMetadata) (*IndexEntry, error) {
+	index, err := ReadIndex(t)
+	if err != nil {
+		return nil, fmt.Errorf("reading index: %w", err)
+	}
+	started := time.Now()
+	m := Manifest{
+		ID:         started.UTC().Format("20060102T150405Z"),
+		Time:       started,
+		Worlds:     chunkStorage.ListWorlds(storages),
+		Metadata:   meta,
+		Dimensions: []DimensionManifest{},
+	}
+	if len(index) > 0 {
+		last := index[len(index)-1]
+		if last.ID == m.ID {
+			return nil, errors.New("previous backup was made less than a second ago")
+		}
+		m.Since = last.Time
+	}
+	entry := IndexEntry{ID: m.ID, Time: m.Time, Since: m.Since}
+	for _, w := range m.Worlds {
+		_, s, err := chunkStorage.GetWorldStorage(storages, w.Name)
+		if err != nil || s == nil {
+			return nil, fmt.Errorf("getting storage of world %q: %v", w.Name, err)
+		}
+		dims, err := s.ListWorldDimensions(w.Name)
+		if err != nil {
+			return nil, fmt.Errorf("listing dimensions of %q: %w", w.Name, err)
+		}
+		for _, d := range dims {
+			dm := DimensionManifest{World: w.Name, Dimension: d}
+			err = backupDimension(s, t, &m, &dm, len(m.Dimensions))
+			if err != nil {
+				return nil, fmt.Errorf("backing up %q %q: %w", w.Name, d.Name, err)
+			}
+			entry.Chunks += dm.Chunks
+			entry.Size += dm.Size
+			m.Dimensions = append(m.Dimensions, dm)
+		}
+	}
+	if err := writeJSON(t, m.ID+"/manifest.json", m); err != nil {
+		return nil, fmt.Errorf("writing manifest: %w", err)
+	}
+	// backup only counts once it is in the index
+	index = append(index, entry)
+	if err := writeJSON(t, indexName, index); err != nil {
+		return nil, fmt.Errorf("writing index: %w", err)
+	}
+	log.Printf("Backup %s done: %d chunks, %d bytes in %s", m.ID, entry.Chunks, entry.Size, time.Since(started))
+	return &entry, nil
+}
+
+func backupDimension(s chunkStorage.ChunkStorage, t Target, m *Manifest, dm *DimensionManifest, n int) error {
+	changed, err := s.ListChunksModifiedSince(dm.World, dm.Dimension.Name, m.Since)
+	if err != nil {
+		return err
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	f, err := os.CreateTemp("", "webchunk-backup-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	h := sha256.New()
+	bw := bufio.NewWriter(io.MultiWriter(f, h))
+	for _, c := range changed {
+		raw, err := s.GetChunkRaw(dm.World, dm.Dimension.Name, c.X, c.Z)
+		if err != nil {
+			return err
+		}
+		if len(raw) == 0 {
+			continue
+		}
+		mod, _ := c.Data.(time.Time)
+		err = binary.Write(bw, binary.BigEndian, chunkHeader{
+			X:      int32(c.X),
+			Z:      int32(c.Z),
+			Mod:    mod.Unix(),
+			Length: uint32(len(raw)),
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := bw.Write(raw); err != nil {
+			return err
+		}
+		dm.Chunks++
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	dm.File = m.ID + "/" + strconv.Itoa(n) + ".chunks"
+	dm.Size = size
+	dm.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return t.Put(dm.File, f, size)
+}
+
+type RestoreOptions struct {
+	// zero restores latest backup
+	Until time.Time
+	// empty restores all worlds
+	World string
+	// name of storage for worlds that do not exist anymore
+	Storage string
+}
+
+// last full backup made before Until and all incremental ones after it
+func restoreChain(t Target, o RestoreOptions) ([]IndexEntry, error) {
+	index, err := ReadIndex(t)
+	if err != nil {
+		return nil, fmt.Errorf("reading index: %w", err)
+	}
+	chain := []IndexEntry{}
+	for _, e := range index {
+		if !o.Until.IsZero() && e.Time.After(o.Until) {
+			break
+		}
+		if e.Since.IsZero() {
+			chain = chain[:0]
+		}
+		chain = append(chain, e)
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("no backups found to restore from")
+	}
+	return chain, nil
+}
+
+// Restore replays the last full backup made before Until and all
+// incremental ones after it, chunks are added on top of whatever is stored
+func Restore(storages map[string]chunkStorage.Storage, t Target, o RestoreOptions) (int, error) {
+	chain, err := restoreChain(t, o)
+	if err != nil {
+		return 0, err
+	}
+	restored := 0
+	for _, e := range chain {
+		m, err := ReadManifest(t, e.ID)
+		if err != nil {
+			return restored, fmt.Errorf("reading manifest of %s: %w", e.ID, err)
+		}
+		log.Printf("Restoring backup %s (%d chunks)", e.ID, e.Chunks)
+		for _, dm := range m.Dimensions {
+			if o.World != "" && dm.World != o.World {
+				continue
+			}
+			s, err := restoreTargetStorage(storages, m, dm, o.Storage)
+			if err != nil {
+				return restored, err
+			}
+			if dm.File == "" {
+				continue
+			}
+			n, err := readChunksFile(t, dm, func(ch chunkHeader, raw []byte) error {
+				return s.AddChunkRaw(dm.World, dm.Dimension.Name, int(ch.X), int(ch.Z), raw)
+			})
+			restored += n
+			if err != nil {
+				return restored, fmt.Errorf("restoring %q %q from %s: %w", dm.World, dm.Dimension.Name, e.ID, err)
+			}
+		}
+	}
+	return restored, nil
+}
+
+// RestorePlan is what Restore would write to one dimension, chunks are
+// counted once even if several backups have them, bounds are in chunks
+type RestorePlan struct {
+	World, Dimension       string
+	Chunks                 int
+	MinX, MinZ, MaxX, MaxZ int
+}
+
+// PlanRestore reads and checks the same files as Restore without writing
+// anything to storages
+func PlanRestore(t Target, o RestoreOptions) ([]RestorePlan, error) {
+	chain, err := restoreChain(t, o)
+	if err != nil {
+		return nil, err
+	}
+	plans := []RestorePlan{}
+	index := map[[2]string]int{}
+	seen := map[[2]string]map[[2]int32]struct{}{}
+	for _, e := range chain {
+		m, err := ReadManifest(t, e.ID)
+		if err != nil {
+			return nil, fmt.Errorf("reading manifest of %s: %w", e.ID, err)
+		}
+		for _, dm := range m.Dimensions {
+			if (o.World != "" && dm.World != o.World) || dm.File == "" {
+				continue
+			}
+			k := [2]string{dm.World, dm.Dimension.Name}
+			i, ok := index[k]
+			if !ok {
+				i = len(plans)
+				index[k] = i
+				plans = append(plans, RestorePlan{World: dm.World, Dimension: dm.Dimension.Name})
+				seen[k] = map[[2]int32]struct{}{}
+			}
+			p := &plans[i]
+			_, err := readChunksFile(t, dm, func(ch chunkHeader, _ []byte) error {
+				if _, ok := seen[k][[2]int32{ch.X, ch.Z}]; ok {
+					return nil
+				}
+				seen[k][[2]int32{ch.X, ch.Z}] = struct{}{}
+				x, z := int(ch.X), int(ch.Z)
+				if p.Chunks == 0 {
+					p.MinX, p.MinZ, p.MaxX, p.MaxZ = x, z, x, z
+				}
+				if x < p.MinX {
+					p.MinX = x
+				}
+				if z < p.MinZ {
+					p.MinZ = z
+				}
+				if x > p.MaxX {
+					p.MaxX = x
+				}
+				if z > p.MaxZ {
+					p.MaxZ = z
+				}
+				p.Chunks++
+				return nil
+			})
+			if err != nil {
+				return nil, fmt.Errorf("reading %q %q from %s: %w", dm.World, dm.Dimension.Name, e.ID, err)
+			}
+		}
+	}
+	return plans, nil
+}
+
+// makes sure world and dimension exist somewhere
+func restoreTargetStorage(storages map[string]chunkStorage.Storage, m *Manifest, dm DimensionManifest, fallback string) (chunkStorage.ChunkStorage, error) {
+	_, s, err := chunkStorage.GetWorldStorage(storages, dm.World)
+	if err != nil {
+		return nil, err
+	}
+	if s == nil {
+		fs, ok := storages[fallback]
+		if !ok || fs.Driver == nil {
+			return nil, fmt.Errorf("world %q does not exist and storage %q to create it in is not found", dm.World, fallback)
+		}
+		s = fs.Driver
+		w := chunkStorage.SWorld{Name: dm.World, Alias: dm.World}
+		for _, mw := range m.Worlds {
+			if mw.Name == dm.World {
+				w = mw
+			}
+		}
+		if err := s.AddWorld(w); err != nil {
+			return nil, fmt.Errorf("creating world %q: %w", dm.World, err)
+		}
+	}
+	d, err := s.GetDimension(dm.World, dm.Dimension.Name)
+	if err != nil && !errors.Is(err, chunkStorage.ErrNoDim) {
+		return nil, err
+	}
+	if d == nil {
+		if err := s.AddDimension(dm.World, dm.Dimension); err != nil {
+			return nil, fmt.Errorf("creating dimension %q of %q: %w", dm.Dimension.Name, dm.World, err)
+		}
+	}
+	return s, nil
+}
+
+// chunks file is downloaded and checked before any chunk is given to cb
+func readChunksFile(t Target, dm DimensionManifest, cb func(ch chunkHeader, raw []byte) error) (int, error) {
+	r, err := t.Get(dm.File)
+	if err != nil {
+		return 0, err
+	}
+	f, err := os.CreateTemp("", "webchunk-restore-*")
+	if err != nil {
+		r.Close()
+		return 0, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, h), r)
+	r.Close()
+	if err != nil {
+		return 0, err
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); sum != dm.SHA256 {
+		return 0, fmt.Errorf("checksum mismatch, expected %s got %s", dm.SHA256, sum)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	br := bufio.NewReader(f)
+	n := 0
+	for {
+		var ch chunkHeader
+		err := binary.Read(br, binary.BigEndian, &ch)
+		if errors.Is(err, io.EOF) {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+		raw := make([]byte, ch.Length)
+		if _, err := io.ReadFull(br, raw); err != nil {
+			return n, err
+		}
+		if err := cb(ch, raw); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
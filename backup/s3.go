@@ -0,0 +1,198 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package backup
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// just enough of S3 to put and get objects, signed with SigV4
+// and unsigned payload so uploads can be streamed from disk
+type s3Target struct {
+	endpoint  *url.URL
+	region    string
+	bucket    string
+	prefix    string
+	accessKey string
+	secretKey string
+	pathStyle bool
+	client    *http.Client
+}
+
+func newS3Target(c TargetConfig) (*s3Target, error) {
+	if c.Bucket == "" || c.AccessKey == "" || c.SecretKey == "" {
+		return nil, errors.New("s3 target needs bucket, access_key and secret_key")
+	}
+	if c.Region == "" {
+		c.Region = "us-east-1"
+	}
+	if c.Endpoint == "" {
+		c.Endpoint = "https://s3." + c.Region + ".amazonaws.com"
+	}
+	u, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &s3Target{
+		endpoint:  u,
+		region:    c.Region,
+		bucket:    c.Bucket,
+		prefix:    strings.Trim(c.Path, "/"),
+		accessKey: c.AccessKey,
+		secretKey: c.SecretKey,
+		pathStyle: c.PathStyle,
+		client:    &http.Client{Timeout: 30 * time.Minute},
+	}, nil
+}
+
+// S3 wants everything except unreserved characters escaped, slashes are kept
+func s3EscapePath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func (t *s3Target) objectURL(name string) (*url.URL, error) {
+	name, err := cleanObjectName(name)
+	if err != nil {
+		return nil, err
+	}
+	if t.prefix != "" {
+		name = t.prefix + "/" + name
+	}
+	u := *t.endpoint
+	if t.pathStyle {
+		u.Path = "/" + t.bucket + "/" + name
+	} else {
+		u.Host = t.bucket + "." + u.Host
+		u.Path = "/" + name
+	}
+	u.RawPath = s3EscapePath(u.Path)
+	return &u, nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func (t *s3Target) sign(req *http.Request) {
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", "UNSIGNED-PAYLOAD")
+	signed := "host;x-amz-content-sha256;x-amz-date"
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		"",
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:UNSIGNED-PAYLOAD",
+		"x-amz-date:" + amzDate,
+		"",
+		signed,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	scope := day + "/" + t.region + "/s3/aws4_request"
+	crh := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(crh[:])
+	key := hmacSHA256([]byte("AWS4"+t.secretKey), day)
+	key = hmacSHA256(key, t.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		t.accessKey, scope, signed, hex.EncodeToString(hmacSHA256(key, toSign))))
+}
+
+func s3Error(resp *http.Response) error {
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return fmt.Errorf("s3 responded %s: %s", resp.Status, strings.TrimSpace(string(b)))
+}
+
+func (t *s3Target) Put(name string, r io.ReadSeeker, size int64) error {
+	u, err := t.objectURL(name)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, u.String(), io.NopCloser(r))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	t.sign(req)
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+func (t *s3Target) Get(name string) (io.ReadCloser, error) {
+	u, err := t.objectURL(name)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	t.sign(req)
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		return nil, s3Error(resp)
+	}
+	return resp.Body, nil
+}
+
+func (t *s3Target) Close() error {
+	t.client.CloseIdleConnections()
+	return nil
+}
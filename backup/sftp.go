@@ -0,0 +1,489 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package backup
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// minimal SFTP v3 client, requests are sent one at a time
+// which is slow but backups are not latency sensitive
+const (
+	sftpInit    = 1
+	sftpVersion = 2
+	sftpOpen    = 3
+	sftpClose   = 4
+	sftpRead    = 5
+	sftpWrite   = 6
+	sftpOpendir = 11
+	sftpReaddir = 12
+	sftpRemove  = 13
+	sftpMkdir   = 14
+	sftpRename  = 18
+	sftpStatus  = 101
+	sftpHandle  = 102
+	sftpData    = 103
+	sftpName    = 104
+
+	sftpFlagRead  = 0x01
+	sftpFlagWrite = 0x02
+	sftpFlagCreat = 0x08
+	sftpFlagTrunc = 0x10
+
+	sftpStatusOK     = 0
+	sftpStatusEOF    = 1
+	sftpStatusNoFile = 2
+
+	sftpChunk = 32 * 1024
+)
+
+type sftpStatusError struct {
+	code uint32
+	msg  string
+}
+
+func (e sftpStatusError) Error() string {
+	return fmt.Sprintf("sftp status %d: %s", e.code, e.msg)
+}
+
+type sftpTarget struct {
+	root   string
+	conn   *ssh.Client
+	sess   *ssh.Session
+	w      io.WriteCloser
+	r      io.Reader
+	lock   sync.Mutex
+	nextID uint32
+}
+
+func newSFTPTarget(c TargetConfig) (*sftpTarget, error) {
+	if c.Address == "" || c.User == "" {
+		return nil, errors.New("sftp target needs address and user")
+	}
+	auth := []ssh.AuthMethod{}
+	if c.KeyPath != "" {
+		k, err := os.ReadFile(c.KeyPath)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if c.Password != "" {
+		auth = append(auth, ssh.Password(c.Password))
+	}
+	var hostKey ssh.HostKeyCallback
+	if c.HostKey != "" {
+		k, _, _, _, err := ssh.ParseAuthorizedKey([]byte(c.HostKey))
+		if err != nil {
+			return nil, fmt.Errorf("bad host_key: %w", err)
+		}
+		hostKey = ssh.FixedHostKey(k)
+	} else if c.InsecureIgnoreHostKey {
+		hostKey = ssh.InsecureIgnoreHostKey()
+	} else {
+		return nil, errors.New("sftp target needs host_key")
+	}
+	addr := c.Address
+	if !strings.Contains(addr, ":") {
+		addr += ":22"
+	}
+	conn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            c.User,
+		Auth:            auth,
+		HostKeyCallback: hostKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+	t := &sftpTarget{root: c.Path, conn: conn}
+	if err := t.start(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *sftpTarget) start() error {
+	sess, err := t.conn.NewSession()
+	if err != nil {
+		return err
+	}
+	t.sess = sess
+	t.w, err = sess.StdinPipe()
+	if err != nil {
+		return err
+	}
+	t.r, err = sess.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := sess.RequestSubsystem("sftp"); err != nil {
+		return err
+	}
+	// init has no request id, version is the only field we need
+	if err := t.writePacket(sftpInit, binary.BigEndian.AppendUint32(nil, 3)); err != nil {
+		return err
+	}
+	typ, _, err := t.readPacket()
+	if err != nil {
+		return err
+	}
+	if typ != sftpVersion {
+		return fmt.Errorf("sftp server responded with %d instead of version", typ)
+	}
+	return nil
+}
+
+func (t *sftpTarget) writePacket(typ byte, payload []byte) error {
+	b := make([]byte, 5, 5+len(payload))
+	binary.BigEndian.PutUint32(b, uint32(len(payload)+1))
+	b[4] = typ
+	_, err := t.w.Write(append(b, payload...))
+	return err
+}
+
+func (t *sftpTarget) readPacket() (byte, []byte, error) {
+	var l uint32
+	if err := binary.Read(t.r, binary.BigEndian, &l); err != nil {
+		return 0, nil, err
+	}
+	if l == 0 || l > 1<<20 {
+		return 0, nil, fmt.Errorf("bad sftp packet length %d", l)
+	}
+	b := make([]byte, l)
+	if _, err := io.ReadFull(t.r, b); err != nil {
+		return 0, nil, err
+	}
+	return b[0], b[1:], nil
+}
+
+func sftpString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+func sftpReadString(b []byte) (string, []byte, error) {
+	if len(b) < 4 {
+		return "", nil, io.ErrUnexpectedEOF
+	}
+	l := binary.BigEndian.Uint32(b)
+	if uint32(len(b)-4) < l {
+		return "", nil, io.ErrUnexpectedEOF
+	}
+	return string(b[4 : 4+l]), b[4+l:], nil
+}
+
+// sends request with fresh id and waits for its response, body starts after id
+func (t *sftpTarget) request(typ byte, body []byte) (byte, []byte, error) {
+	t.nextID++
+	id := t.nextID
+	if err := t.writePacket(typ, append(binary.BigEndian.AppendUint32(nil, id), body...)); err != nil {
+		return 0, nil, err
+	}
+	rtyp, resp, err := t.readPacket()
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(resp) < 4 || binary.BigEndian.Uint32(resp) != id {
+		return 0, nil, errors.New("sftp response id mismatch")
+	}
+	resp = resp[4:]
+	if rtyp == sftpStatus {
+		if len(resp) < 4 {
+			return 0, nil, io.ErrUnexpectedEOF
+		}
+		code := binary.BigEndian.Uint32(resp)
+		if code == sftpStatusOK {
+			return rtyp, nil, nil
+		}
+		msg, _, _ := sftpReadString(resp[4:])
+		return rtyp, nil, sftpStatusError{code: code, msg: msg}
+	}
+	return rtyp, resp, nil
+}
+
+func (t *sftpTarget) open(p string, flags uint32) (string, error) {
+	body := sftpString(nil, p)
+	body = binary.BigEndian.AppendUint32(body, flags)
+	body = binary.BigEndian.AppendUint32(body, 0) // no attributes
+	typ, resp, err := t.request(sftpOpen, body)
+	if err != nil {
+		return "", err
+	}
+	if typ != sftpHandle {
+		return "", fmt.Errorf("sftp open responded with %d", typ)
+	}
+	h, _, err := sftpReadString(resp)
+	return h, err
+}
+
+func (t *sftpTarget) closeHandle(h string) error {
+	_, _, err := t.request(sftpClose, sftpString(nil, h))
+	return err
+}
+
+// errors are ignored because there is no way to tell "exists" apart portably
+func (t *sftpTarget) mkdirAll(dir string) {
+	cur := ""
+	for _, p := range strings.Split(dir, "/") {
+		if p == "" {
+			cur = "/"
+			continue
+		}
+		cur = path.Join(cur, p)
+		t.request(sftpMkdir, binary.BigEndian.AppendUint32(sftpString(nil, cur), 0))
+	}
+}
+
+func (t *sftpTarget) Put(name string, r io.ReadSeeker, size int64) error {
+	name, err := cleanObjectName(name)
+	if err != nil {
+		return err
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	p := path.Join(t.root, name)
+	tmp := path.Join(path.Dir(p), ".upload-"+path.Base(p))
+	t.mkdirAll(path.Dir(p))
+	h, err := t.open(tmp, sftpFlagWrite|sftpFlagCreat|sftpFlagTrunc)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, sftpChunk)
+	var off uint64
+	for {
+		n, rerr := io.ReadFull(r, buf)
+		if n > 0 {
+			body := sftpString(nil, h)
+			body = binary.BigEndian.AppendUint64(body, off)
+			body = sftpString(body, string(buf[:n]))
+			if _, _, err := t.request(sftpWrite, body); err != nil {
+				t.closeHandle(h)
+				return err
+			}
+			off += uint64(n)
+		}
+		if rerr == io.EOF || rerr == io.ErrUnexpectedEOF {
+			break
+		}
+		if rerr != nil {
+			t.closeHandle(h)
+			return rerr
+		}
+	}
+	if err := t.closeHandle(h); err != nil {
+		return err
+	}
+	// v3 rename fails if target exists
+	t.request(sftpRemove, sftpString(nil, p))
+	_, _, err = t.request(sftpRename, sftpString(sftpString(nil, tmp), p))
+	return err
+}
+
+// target stays locked until returned reader is closed
+func (t *sftpTarget) Get(name string) (io.ReadCloser, error) {
+	name, err := cleanObjectName(name)
+	if err != nil {
+		return nil, err
+	}
+	t.lock.Lock()
+	h, err := t.open(path.Join(t.root, name), sftpFlagRead)
+	if err != nil {
+		t.lock.Unlock()
+		var serr sftpStatusError
+		if errors.As(err, &serr) && serr.code == sftpStatusNoFile {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &sftpFile{t: t, h: h}, nil
+}
+
+type sftpFile struct {
+	t   *sftpTarget
+	h   string
+	off uint64
+	buf []byte
+	eof bool
+}
+
+func (f *sftpFile) Read(p []byte) (int, error) {
+	for len(f.buf) == 0 {
+		if f.eof {
+			return 0, io.EOF
+		}
+		body := sftpString(nil, f.h)
+		body = binary.BigEndian.AppendUint64(body, f.off)
+		body = binary.BigEndian.AppendUint32(body, sftpChunk)
+		typ, resp, err := f.t.request(sftpRead, body)
+		var serr sftpStatusError
+		if errors.As(err, &serr) && serr.code == sftpStatusEOF {
+			f.eof = true
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		if typ != sftpData {
+			return 0, fmt.Errorf("sftp read responded with %d", typ)
+		}
+		d, _, err := sftpReadString(resp)
+		if err != nil {
+			return 0, err
+		}
+		if len(d) == 0 {
+			f.eof = true
+		}
+		f.buf = []byte(d)
+		f.off += uint64(len(d))
+	}
+	n := copy(p, f.buf)
+	f.buf = f.buf[n:]
+	return n, nil
+}
+
+func (f *sftpFile) Close() error {
+	err := f.t.closeHandle(f.h)
+	f.t.lock.Unlock()
+	return err
+}
+
+func (t *sftpTarget) List(dir string) ([]string, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	p := t.root
+	if dir != "" {
+		name, err := cleanObjectName(dir)
+		if err != nil {
+			return nil, err
+		}
+		p = path.Join(t.root, name)
+	}
+	typ, resp, err := t.request(sftpOpendir, sftpString(nil, p))
+	if err != nil {
+		var serr sftpStatusError
+		if errors.As(err, &serr) && serr.code == sftpStatusNoFile {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if typ != sftpHandle {
+		return nil, fmt.Errorf("sftp opendir responded with %d", typ)
+	}
+	h, _, err := sftpReadString(resp)
+	if err != nil {
+		return nil, err
+	}
+	defer t.closeHandle(h)
+	ret := []string{}
+	for {
+		typ, resp, err := t.request(sftpReaddir, sftpString(nil, h))
+		var serr sftpStatusError
+		if errors.As(err, &serr) && serr.code == sftpStatusEOF {
+			return ret, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if typ != sftpName || len(resp) < 4 {
+			return nil, fmt.Errorf("sftp readdir responded with %d", typ)
+		}
+		count := binary.BigEndian.Uint32(resp)
+		resp = resp[4:]
+		for i := uint32(0); i < count; i++ {
+			var name string
+			name, resp, err = sftpReadString(resp)
+			if err != nil {
+				return nil, err
+			}
+			_, resp, err = sftpReadString(resp) // long name
+			if err != nil {
+				return nil, err
+			}
+			resp, err = sftpSkipAttrs(resp)
+			if err != nil {
+				return nil, err
+			}
+			if name != "." && name != ".." {
+				ret = append(ret, name)
+			}
+		}
+	}
+}
+
+func sftpSkipAttrs(b []byte) ([]byte, error) {
+	if len(b) < 4 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	flags := binary.BigEndian.Uint32(b)
+	b = b[4:]
+	skip := 0
+	if flags&0x1 != 0 {
+		skip += 8 // size
+	}
+	if flags&0x2 != 0 {
+		skip += 8 // uid, gid
+	}
+	if flags&0x4 != 0 {
+		skip += 4 // permissions
+	}
+	if flags&0x8 != 0 {
+		skip += 8 // atime, mtime
+	}
+	if len(b) < skip {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b = b[skip:]
+	if flags&0x80000000 != 0 {
+		if len(b) < 4 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		n := binary.BigEndian.Uint32(b)
+		b = b[4:]
+		for i := uint32(0); i < n*2; i++ {
+			var err error
+			_, b, err = sftpReadString(b)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return b, nil
+}
+
+func (t *sftpTarget) Close() error {
+	t.w.Close()
+	t.sess.Close()
+	return t.conn.Close()
+}
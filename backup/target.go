@@ -0,0 +1,168 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+// Package backup uploads chunks changed since the previous backup
+// to a remote target and restores them back to a point in time
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrNotFound = errors.New("backup object not found")
+
+// Target is where backup objects are stored, names are slash separated
+// and relative to whatever root target was configured with
+type Target interface {
+	Put(name string, r io.ReadSeeker, size int64) error
+	// returns ErrNotFound if there is no such object
+	Get(name string) (io.ReadCloser, error)
+	Close() error
+}
+
+// Lister is implemented by targets that can list directories,
+// names are returned without the directory
+type Lister interface {
+	List(dir string) ([]string, error)
+}
+
+type TargetConfig struct {
+	// "dir", "s3", "sftp" or "webdav"
+	Type string `json:"type" mapstructure:"type"`
+	// directory for dir and sftp, key prefix for s3
+	Path string `json:"path" mapstructure:"path"`
+
+	Endpoint  string `json:"endpoint" mapstructure:"endpoint"`
+	Region    string `json:"region" mapstructure:"region"`
+	Bucket    string `json:"bucket" mapstructure:"bucket"`
+	AccessKey string `json:"access_key" mapstructure:"access_key"`
+	SecretKey string `json:"secret_key" mapstructure:"secret_key"`
+	PathStyle bool   `json:"path_style" mapstructure:"path_style"`
+
+	Address               string `json:"address" mapstructure:"address"`
+	User                  string `json:"user" mapstructure:"user"`
+	Password              string `json:"password" mapstructure:"password"`
+	KeyPath               string `json:"key_path" mapstructure:"key_path"`
+	HostKey               string `json:"host_key" mapstructure:"host_key"`
+	InsecureIgnoreHostKey bool   `json:"insecure_ignore_host_key" mapstructure:"insecure_ignore_host_key"`
+}
+
+func OpenTarget(c TargetConfig) (Target, error) {
+	switch c.Type {
+	case "dir":
+		if c.Path == "" {
+			return nil, errors.New("dir target needs path")
+		}
+		return dirTarget{root: c.Path}, nil
+	case "s3":
+		return newS3Target(c)
+	case "sftp":
+		return newSFTPTarget(c)
+	case "webdav":
+		return newWebDAVTarget(c)
+	}
+	return nil, fmt.Errorf("unknown backup target type %q", c.Type)
+}
+
+func cleanObjectName(name string) (string, error) {
+	if name == "" || strings.HasPrefix(name, "/") {
+		return "", fmt.Errorf("bad object name %q", name)
+	}
+	for _, p := range strings.Split(name, "/") {
+		if p == "" || p == "." || p == ".." {
+			return "", fmt.Errorf("bad object name %q", name)
+		}
+	}
+	return name, nil
+}
+
+// local directory, also works for anything mounted
+type dirTarget struct {
+	root string
+}
+
+func (t dirTarget) Put(name string, r io.ReadSeeker, size int64) error {
+	name, err := cleanObjectName(name)
+	if err != nil {
+		return err
+	}
+	p := filepath.Join(t.root, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(p), 0764); err != nil {
+		return err
+	}
+	// write aside and rename so half-written object never looks complete
+	f, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), p)
+}
+
+func (t dirTarget) Get(name string) (io.ReadCloser, error) {
+	name, err := cleanObjectName(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filepath.Join(t.root, filepath.FromSlash(name)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (t dirTarget) List(dir string) ([]string, error) {
+	p := t.root
+	if dir != "" {
+		name, err := cleanObjectName(dir)
+		if err != nil {
+			return nil, err
+		}
+		p = filepath.Join(t.root, filepath.FromSlash(name))
+	}
+	de, err := os.ReadDir(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]string, 0, len(de))
+	for _, d := range de {
+		ret = append(ret, d.Name())
+	}
+	return ret, nil
+}
+
+func (t dirTarget) Close() error {
+	return nil
+}
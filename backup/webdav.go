@@ -0,0 +1,199 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package backup
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// WebDAV server at endpoint, path is a directory inside of it
+type webdavTarget struct {
+	base     *url.URL
+	user     string
+	password string
+	client   *http.Client
+}
+
+func newWebDAVTarget(c TargetConfig) (*webdavTarget, error) {
+	if c.Endpoint == "" {
+		return nil, errors.New("webdav target needs endpoint")
+	}
+	u, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = path.Join("/", u.Path, c.Path)
+	return &webdavTarget{
+		base:     u,
+		user:     c.User,
+		password: c.Password,
+		client:   &http.Client{Timeout: 30 * time.Minute},
+	}, nil
+}
+
+func (t *webdavTarget) url(name string) string {
+	u := *t.base
+	u.Path = path.Join(u.Path, name)
+	return u.String()
+}
+
+func (t *webdavTarget) do(method, u string, body io.Reader, size int64, header map[string]string) (*http.Response, error) {
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	if t.user != "" {
+		req.SetBasicAuth(t.user, t.password)
+	}
+	return t.client.Do(req)
+}
+
+func webdavError(resp *http.Response) error {
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return fmt.Errorf("webdav responded %s: %s", resp.Status, strings.TrimSpace(string(b)))
+}
+
+// existing collections answer with 405, that is fine
+func (t *webdavTarget) mkcolAll(dir string) {
+	cur := ""
+	for _, p := range strings.Split(dir, "/") {
+		if p == "" {
+			continue
+		}
+		cur = path.Join(cur, p)
+		resp, err := t.do("MKCOL", t.url(cur)+"/", nil, 0, nil)
+		if err == nil {
+			resp.Body.Close()
+		}
+	}
+}
+
+func (t *webdavTarget) Put(name string, r io.ReadSeeker, size int64) error {
+	name, err := cleanObjectName(name)
+	if err != nil {
+		return err
+	}
+	t.mkcolAll(path.Dir(name))
+	tmp := path.Join(path.Dir(name), ".upload-"+path.Base(name))
+	resp, err := t.do(http.MethodPut, t.url(tmp), io.NopCloser(r), size, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return webdavError(resp)
+	}
+	resp, err = t.do("MOVE", t.url(tmp), nil, 0, map[string]string{"Destination": t.url(name), "Overwrite": "T"})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return webdavError(resp)
+	}
+	return nil
+}
+
+func (t *webdavTarget) Get(name string) (io.ReadCloser, error) {
+	name, err := cleanObjectName(name)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.do(http.MethodGet, t.url(name), nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		return nil, webdavError(resp)
+	}
+	return resp.Body, nil
+}
+
+type webdavMultistatus struct {
+	Responses []struct {
+		Href string `xml:"href"`
+	} `xml:"response"`
+}
+
+func (t *webdavTarget) List(dir string) ([]string, error) {
+	u := t.url("") + "/"
+	if dir != "" {
+		name, err := cleanObjectName(dir)
+		if err != nil {
+			return nil, err
+		}
+		u = t.url(name) + "/"
+	}
+	body := `<?xml version="1.0" encoding="utf-8"?><propfind xmlns="DAV:"><prop><resourcetype/></prop></propfind>`
+	resp, err := t.do("PROPFIND", u, strings.NewReader(body), int64(len(body)), map[string]string{"Depth": "1", "Content-Type": "application/xml"})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, webdavError(resp)
+	}
+	var ms webdavMultistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, err
+	}
+	self, _ := url.Parse(u)
+	ret := []string{}
+	for _, r := range ms.Responses {
+		h, err := url.Parse(r.Href)
+		if err != nil {
+			continue
+		}
+		// first response is the collection itself
+		if strings.TrimSuffix(h.Path, "/") == strings.TrimSuffix(self.Path, "/") {
+			continue
+		}
+		ret = append(ret, path.Base(strings.TrimSuffix(h.Path, "/")))
+	}
+	return ret, nil
+}
+
+func (t *webdavTarget) Close() error {
+	t.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,142 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/maxsupermanhd/WebChunk/backup"
+	"github.com/maxsupermanhd/WebChunk/chunkStorage"
+)
+
+// only one backup can run at a time, scheduled or requested
+var backupLock sync.Mutex
+
+func openBackupTarget() (backup.Target, error) {
+	c := backup.TargetConfig{}
+	if err := cfg.GetToStruct(&c, "backup", "target"); err != nil {
+		return nil, err
+	}
+	return backup.OpenTarget(c)
+}
+
+func runBackup() (*backup.IndexEntry, error) {
+	if !backupLock.TryLock() {
+		return nil, errors.New("backup is already running")
+	}
+	defer backupLock.Unlock()
+	t, err := openBackupTarget()
+	if err != nil {
+		return nil, err
+	}
+	defer t.Close()
+	snap := storages.Snapshot()
+	return backup.Run(snap, t, worldsMetadata(chunkStorage.ListWorlds(snap)))
+}
+
+// interval is re-read every minute so it can be changed without restart
+func backupScheduler(exitchan <-chan struct{}) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-exitchan:
+			return
+		case <-ticker.C:
+			interval := time.Duration(cfg.GetDSInt(0, "backup", "interval")) * time.Minute
+			if interval <= 0 || time.Since(last) < interval {
+				continue
+			}
+			last = time.Now()
+			if _, err := runBackup(); err != nil {
+				log.Printf("Scheduled backup failed: %s", err.Error())
+			}
+		}
+	}
+}
+
+func apiListBackups(w http.ResponseWriter, _ *http.Request) (int, string) {
+	t, err := openBackupTarget()
+	if err != nil {
+		return 500, err.Error()
+	}
+	defer t.Close()
+	index, err := backup.ReadIndex(t)
+	if err != nil {
+		return 500, err.Error()
+	}
+	setContentTypeJson(w)
+	return marshalOrFail(200, index)
+}
+
+func apiRunBackup(w http.ResponseWriter, _ *http.Request) (int, string) {
+	entry, err := runBackup()
+	if err != nil {
+		return 500, err.Error()
+	}
+	setContentTypeJson(w)
+	return marshalOrFail(200, entry)
+}
+
+// webchunk restore [-until time] [-world name] [-storage name] [-dry-run]
+func restoreCommand(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ContinueOnError)
+	until := fs.String("until", "", "restore state as of this RFC3339 time, latest backup if empty")
+	world := fs.String("world", "", "restore only this world")
+	storage := fs.String("storage", cfg.GetDSString("", "preferred_storage"), "storage to create missing worlds in")
+	dryRun := fs.Bool("dry-run", false, "only report chunk count and bounds of every dimension that would be restored")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	o := backup.RestoreOptions{World: *world, Storage: *storage}
+	if *until != "" {
+		var err error
+		o.Until, err = time.Parse(time.RFC3339, *until)
+		if err != nil {
+			return fmt.Errorf("parsing until: %w", err)
+		}
+	}
+	t, err := openBackupTarget()
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+	if *dryRun {
+		plans, err := backup.PlanRestore(t, o)
+		if err != nil {
+			return err
+		}
+		for _, p := range plans {
+			fmt.Printf("%s %s: %d chunks from %d %d to %d %d\n", p.World, p.Dimension, p.Chunks, p.MinX, p.MinZ, p.MaxX, p.MaxZ)
+		}
+		return nil
+	}
+	n, err := backup.Restore(storages.Snapshot(), t, o)
+	log.Printf("Restored %d chunks", n)
+	return err
+}
@@ -0,0 +1,228 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+	"log"
+	"strings"
+	"sync"
+
+	imagecache "github.com/maxsupermanhd/WebChunk/imageCache"
+	"github.com/maxsupermanhd/WebChunk/primitives"
+	"github.com/maxsupermanhd/WebChunk/proxy"
+	"github.com/maxsupermanhd/go-vmc/v764/level"
+	"github.com/maxsupermanhd/go-vmc/v764/level/block"
+	"github.com/maxsupermanhd/go-vmc/v764/save"
+	xdraw "golang.org/x/image/draw"
+)
+
+type chunkKey struct {
+	world, dim string
+	x, z       int
+}
+
+// block changes are collected for a while before touching storage,
+// some storages keep every version of the chunk
+type pendingBlockChanges map[chunkKey][]proxy.BlockChange
+
+func (p pendingBlockChanges) add(r *proxy.ProxiedChunk) {
+	k := chunkKey{world: r.Server, dim: strings.TrimPrefix(r.Dimension, "minecraft:"), x: int(r.Pos[0]), z: int(r.Pos[1])}
+	p[k] = append(p[k], r.Changes...)
+}
+
+// full chunk supersedes changes received before it
+func (p pendingBlockChanges) forget(r *proxy.ProxiedChunk) {
+	delete(p, chunkKey{world: r.Server, dim: strings.TrimPrefix(r.Dimension, "minecraft:"), x: int(r.Pos[0]), z: int(r.Pos[1])})
+}
+
+func (p pendingBlockChanges) flush() {
+	for k, changes := range p {
+		err := applyBlockChanges(k, changes)
+		if err != nil {
+			log.Printf("Failed to apply %d block changes to chunk %d:%d of %s %s: %s", len(changes), k.x, k.z, k.world, k.dim, err.Error())
+		}
+		delete(p, k)
+	}
+}
+
+func applyBlockChanges(k chunkKey, changes []proxy.BlockChange) error {
+	_, s, err := storages.World(k.world)
+	if err != nil {
+		return err
+	}
+	if s == nil {
+		return errors.New("world not found")
+	}
+	chunk, err := s.GetChunk(k.world, k.dim, k.x, k.z)
+	if err != nil {
+		return err
+	}
+	if chunk == nil {
+		return nil // nothing to update, full chunk will come later
+	}
+	touched := map[int]*level.PaletteContainer[block.StateID]{}
+	for _, c := range changes {
+		si := -1
+		for i := range chunk.Sections {
+			if int(chunk.Sections[i].Y) == c.Y>>4 {
+				si = i
+				break
+			}
+		}
+		if si < 0 {
+			continue
+		}
+		states, ok := touched[si]
+		if !ok {
+			states = prepareSectionBlockstates(&chunk.Sections[si])
+			if states == nil {
+				log.Printf("Chunk %d:%d section %d has broken pallete, block change dropped", k.x, k.z, chunk.Sections[si].Y)
+				continue
+			}
+			touched[si] = states
+		}
+		states.Set((c.Y&15)*16*16+(c.Z&15)*16+(c.X&15), block.StateID(c.State))
+	}
+	if len(touched) == 0 {
+		return nil
+	}
+	for si, states := range touched {
+		// only block states are taken from converted section, biomes are left as stored
+		tmp := save.Chunk{YPos: int32(chunk.Sections[si].Y)}
+		err = level.ChunkToSave(&level.Chunk{Sections: []level.Section{{
+			States: states,
+			Biomes: level.NewBiomesPaletteContainer(4*4*4, 0),
+		}}}, &tmp)
+		if err != nil {
+			return err
+		}
+		chunk.Sections[si].BlockStates = tmp.Sections[0].BlockStates
+	}
+	err = s.AddChunk(k.world, k.dim, k.x, k.z, *chunk)
+	if err != nil {
+		return err
+	}
+	captureBlockMarkers(k.world, k.dim, k.x, k.z, chunk)
+	markChunkDirty(k)
+	return nil
+}
+
+// chunks that changed since their cached tiles were rendered, grouped by
+// storage level region and keeping variants that are yet to be re-rendered
+var (
+	dirtyChunks     = map[chunkKey]map[chunkKey]map[string]bool{}
+	dirtyChunksLock sync.Mutex
+)
+
+func markChunkDirty(k chunkKey) {
+	for t := range ttypes.All() {
+		markChunkVariantDirty(k, t.Name)
+	}
+}
+
+// for layers that are not drawn from chunk data
+func markChunkVariantDirty(k chunkKey, variant string) {
+	rx, rz := imagecache.AT(k.x, k.z)
+	rk := chunkKey{world: k.world, dim: k.dim, x: rx, z: rz}
+	dirtyChunksLock.Lock()
+	defer dirtyChunksLock.Unlock()
+	region, ok := dirtyChunks[rk]
+	if !ok {
+		region = map[chunkKey]map[string]bool{}
+		dirtyChunks[rk] = region
+	}
+	variants, ok := region[k]
+	if !ok {
+		variants = map[string]bool{}
+		region[k] = variants
+	}
+	variants[variant] = true
+}
+
+// takes dirty chunks of variant inside of [cx0, cx1) [cz0, cz1)
+func takeDirtyChunks(wname, dname, variant string, cx0, cz0, cx1, cz1 int) []chunkKey {
+	return collectDirtyChunks(wname, dname, variant, cx0, cz0, cx1, cz1, true)
+}
+
+func hasDirtyChunks(wname, dname, variant string, cx0, cz0, cx1, cz1 int) bool {
+	return len(collectDirtyChunks(wname, dname, variant, cx0, cz0, cx1, cz1, false)) > 0
+}
+
+func collectDirtyChunks(wname, dname, variant string, cx0, cz0, cx1, cz1 int, take bool) []chunkKey {
+	rx, rz := imagecache.AT(cx0, cz0)
+	rk := chunkKey{world: wname, dim: dname, x: rx, z: rz}
+	dirtyChunksLock.Lock()
+	defer dirtyChunksLock.Unlock()
+	region, ok := dirtyChunks[rk]
+	if !ok {
+		return nil
+	}
+	ret := []chunkKey{}
+	for k, variants := range region {
+		if k.x < cx0 || k.x >= cx1 || k.z < cz0 || k.z >= cz1 || !variants[variant] {
+			continue
+		}
+		ret = append(ret, k)
+		if !take {
+			continue
+		}
+		delete(variants, variant)
+		if len(variants) == 0 {
+			delete(region, k)
+		}
+	}
+	if len(region) == 0 {
+		delete(dirtyChunks, rk)
+	}
+	return ret
+}
+
+func rerenderChunkTile(k chunkKey, variant string) {
+	_, s, err := storages.World(k.world)
+	if err != nil || s == nil {
+		return
+	}
+	ff := findTTypeProviderFunc(primitives.ImageLocation{Variant: variant})
+	if ff == nil {
+		return
+	}
+	getter, painter := (*ff)(s)
+	cc, err := getter(k.world, k.dim, k.x, k.z, k.x+1, k.z+1)
+	if err != nil {
+		log.Printf("Failed to get chunk %d:%d for re-render: %s", k.x, k.z, err.Error())
+		return
+	}
+	if len(cc) == 0 {
+		return
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("Failed to re-render chunk %d:%d %s: %v", k.x, k.z, variant, err)
+		}
+	}()
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	src := painter(cc[0].Data)
+	xdraw.NearestNeighbor.Scale(img, img.Rect, src, src.Bounds(), draw.Src, nil)
+	imageCacheSave(img, k.world, k.dim, variant, 0, k.x, k.z)
+}
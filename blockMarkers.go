@@ -0,0 +1,311 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/maxsupermanhd/WebChunk/primitives"
+	"github.com/maxsupermanhd/go-vmc/v764/save"
+)
+
+type blockMarker struct {
+	Kind    string
+	X, Y, Z int
+}
+
+// all markers of a chunk are recorded at once, last record of the chunk wins
+type blockMarkerChunk struct {
+	Time    time.Time
+	X, Z    int
+	Markers []blockMarker
+}
+
+// found by blocks instead of block entity data, proxied chunks do not keep it
+var blockMarkerKinds = func() map[string]string {
+	ret := map[string]string{
+		"minecraft:spawner":       "spawner",
+		"minecraft:nether_portal": "nether_portal",
+		"minecraft:end_portal":    "end_portal",
+		"minecraft:end_gateway":   "end_gateway",
+		"minecraft:beacon":        "beacon",
+		"minecraft:lodestone":     "lodestone",
+	}
+	for _, c := range []string{"white", "orange", "magenta", "light_blue", "yellow", "lime", "pink", "gray",
+		"light_gray", "cyan", "purple", "blue", "brown", "green", "red", "black"} {
+		ret["minecraft:"+c+"_bed"] = "bed"
+	}
+	return ret
+}()
+
+// portals are made of many blocks, one marker per chunk is enough
+var blockMarkerSingle = map[string]bool{
+	"nether_portal": true,
+	"end_portal":    true,
+}
+
+type blockMarkerIndexKey struct {
+	world, dimension string
+}
+
+var (
+	blockMarkerIndex     = map[blockMarkerIndexKey]map[[2]int][]blockMarker{}
+	blockMarkerIndexLock sync.Mutex
+)
+
+func findBlockMarkers(chunk *save.Chunk) []blockMarker {
+	ret := []blockMarker{}
+	single := map[string]bool{}
+	for _, s := range chunk.Sections {
+		if len(s.BlockStates.Palette) == 0 {
+			continue
+		}
+		has := false
+		for _, p := range s.BlockStates.Palette {
+			if blockMarkerKinds[p.Name] != "" || blockMarkerKinds["minecraft:"+p.Name] != "" {
+				has = true
+				break
+			}
+		}
+		if !has {
+			continue
+		}
+		states := prepareSectionBlockstates(&s)
+		if states == nil {
+			if os.Getenv("REPORT_CHUNK_PROBLEMS") == "yes" || os.Getenv("REPORT_CHUNK_PROBLEMS") == "all" {
+				log.Printf("Chunk %d:%d section %d has broken pallete", chunk.XPos, chunk.ZPos, s.Y)
+			}
+			continue
+		}
+		for i := 0; i < 16*16*16; i++ {
+			kind := blockMarkerKinds[stateName(states.Get(i))]
+			if kind == "" || single[kind] {
+				continue
+			}
+			m := blockMarker{
+				Kind: kind,
+				X:    int(chunk.XPos)*16 + i%16,
+				Y:    int(s.Y)*16 + i/(16*16),
+				Z:    int(chunk.ZPos)*16 + i/16%16,
+			}
+			// other half of the bed is already there
+			if kind == "bed" && blockMarkerNear(ret, m) {
+				continue
+			}
+			if blockMarkerSingle[kind] {
+				single[kind] = true
+			}
+			ret = append(ret, m)
+		}
+	}
+	return ret
+}
+
+func blockMarkerNear(markers []blockMarker, m blockMarker) bool {
+	for _, o := range markers {
+		if o.Kind == m.Kind && o.Y == m.Y && absInt(o.X-m.X)+absInt(o.Z-m.Z) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func blockMarkersEqual(a, b []blockMarker) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// must be called with blockMarkerIndexLock held
+func getBlockMarkerIndex(wname, dname string) map[[2]int][]blockMarker {
+	k := blockMarkerIndexKey{world: wname, dimension: dname}
+	idx, ok := blockMarkerIndex[k]
+	if ok {
+		return idx
+	}
+	idx = map[[2]int][]blockMarker{}
+	err := recs.Read(wname, dname, "blockmarkers", func(m json.RawMessage) error {
+		var c blockMarkerChunk
+		if json.Unmarshal(m, &c) != nil {
+			return nil
+		}
+		if len(c.Markers) == 0 {
+			delete(idx, [2]int{c.X, c.Z})
+		} else {
+			idx[[2]int{c.X, c.Z}] = c.Markers
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to load block markers of %s %s: %s", wname, dname, err.Error())
+	}
+	blockMarkerIndex[k] = idx
+	return idx
+}
+
+// called for every stored chunk, records only chunks whose markers changed
+func captureBlockMarkers(wname, dname string, cx, cz int, chunk *save.Chunk) {
+	if !cfg.GetDSBool(true, "block_markers", "enabled") {
+		return
+	}
+	found := findBlockMarkers(chunk)
+	blockMarkerIndexLock.Lock()
+	defer blockMarkerIndexLock.Unlock()
+	idx := getBlockMarkerIndex(wname, dname)
+	pos := [2]int{cx, cz}
+	if blockMarkersEqual(idx[pos], found) {
+		return
+	}
+	if len(found) == 0 {
+		delete(idx, pos)
+	} else {
+		idx[pos] = found
+	}
+	if err := recs.Append(wname, dname, "blockmarkers", blockMarkerChunk{Time: time.Now(), X: cx, Z: cz, Markers: found}); err != nil {
+		log.Printf("Failed to record block markers: %s", err.Error())
+	}
+}
+
+// markers in block area, whole dimension when area is empty
+func listBlockMarkers(wname, dname, kind string, x0, z0, x1, z1 int) []blockMarker {
+	all := x0 == x1 || z0 == z1
+	ret := []blockMarker{}
+	blockMarkerIndexLock.Lock()
+	defer blockMarkerIndexLock.Unlock()
+	for pos, markers := range getBlockMarkerIndex(wname, dname) {
+		if !all && (pos[0]*16+16 <= x0 || pos[0]*16 >= x1 || pos[1]*16+16 <= z0 || pos[1]*16 >= z1) {
+			continue
+		}
+		for _, m := range markers {
+			if kind != "" && m.Kind != kind {
+				continue
+			}
+			if !all && (m.X < x0 || m.X >= x1 || m.Z < z0 || m.Z >= z1) {
+				continue
+			}
+			ret = append(ret, m)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].X != ret[j].X {
+			return ret[i].X < ret[j].X
+		}
+		if ret[i].Z != ret[j].Z {
+			return ret[i].Z < ret[j].Z
+		}
+		return ret[i].Y < ret[j].Y
+	})
+	return ret
+}
+
+func apiListBlockMarkers(w http.ResponseWriter, r *http.Request) (int, string) {
+	params := mux.Vars(r)
+	if _, code, err := lookupDim(params["world"], params["dim"]); err != nil {
+		return code, err.Error()
+	}
+	// chunk area is optional, whole dimension is listed without it
+	var x0, z0, x1, z1 int
+	if r.FormValue("cx0") != "" {
+		q, err := parseFormInts(r, "cx0", "cz0", "cx1", "cz1")
+		if err != nil {
+			return 400, err.Error()
+		}
+		x0, z0 = minInt(q[0], q[2])*16, minInt(q[1], q[3])*16
+		x1, z1 = (maxInt(q[0], q[2])+1)*16, (maxInt(q[1], q[3])+1)*16
+	}
+	setContentTypeJson(w)
+	return marshalOrFail(200, listBlockMarkers(params["world"], params["dim"], r.FormValue("kind"), x0, z0, x1, z1))
+}
+
+type blockMarkerIcon struct {
+	c     color.RGBA
+	glyph [7]string
+}
+
+var blockMarkerIcons = map[string]blockMarkerIcon{
+	"spawner": {color.RGBA{0x40, 0x40, 0x40, 0xff}, [7]string{
+		"1111111", "1010101", "1111111", "1010101", "1111111", "1010101", "1111111"}},
+	"nether_portal": {color.RGBA{0x9b, 0x30, 0xff, 0xff}, [7]string{
+		"1111111", "1000001", "1011101", "1010101", "1011101", "1000001", "1111111"}},
+	"end_portal": {color.RGBA{0x1a, 0x7a, 0x5a, 0xff}, [7]string{
+		"0111110", "1100011", "1011101", "1010101", "1011101", "1100011", "0111110"}},
+	"end_gateway": {color.RGBA{0x10, 0x10, 0x30, 0xff}, [7]string{
+		"0001000", "0011100", "0110110", "1100011", "0110110", "0011100", "0001000"}},
+	"beacon": {color.RGBA{0x5f, 0xe8, 0xe0, 0xff}, [7]string{
+		"0001000", "0011100", "0001000", "0011100", "0111110", "1111111", "1111111"}},
+	"lodestone": {color.RGBA{0xa0, 0xa0, 0xb0, 0xff}, [7]string{
+		"0011100", "0111110", "1101011", "1110111", "1101011", "0111110", "0011100"}},
+	"bed": {color.RGBA{0xc0, 0x20, 0x20, 0xff}, [7]string{
+		"0000000", "1000000", "1011111", "1111111", "1111111", "1000001", "1000001"}},
+}
+
+// icons keep their size at every zoom, far out they would only cover the map
+func drawBlockMarkersTile(loc primitives.ImageLocation) *image.RGBA {
+	if loc.S > cfg.GetDSInt(5, "block_markers", "max_scale") {
+		return nil
+	}
+	bpp := labelBlocksPerPixel(loc.S)
+	size := minInt(16<<loc.S, 512)
+	tx, tz := loc.X*size, loc.Z*size
+	// icons near the edge of the tile reach into it
+	markers := listBlockMarkers(loc.World, loc.Dimension, "", (tx-4)*bpp, (tz-4)*bpp, (tx+size+4)*bpp, (tz+size+4)*bpp)
+	if len(markers) == 0 {
+		return nil
+	}
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	halo := color.RGBA{0, 0, 0, 200}
+	for _, m := range markers {
+		icon, ok := blockMarkerIcons[m.Kind]
+		if !ok {
+			continue
+		}
+		px, pz := floorDiv(m.X, bpp)-tx-3, floorDiv(m.Z, bpp)-tz-3
+		for row := -1; row <= 7; row++ {
+			for col := -1; col <= 7; col++ {
+				x, z := px+col, pz+row
+				if x < 0 || z < 0 || x >= size || z >= size {
+					continue
+				}
+				if row >= 0 && row < 7 && col >= 0 && col < 7 && icon.glyph[row][col] == '1' {
+					img.SetRGBA(x, z, icon.c)
+				} else if img.RGBAAt(x, z).A == 0 {
+					img.SetRGBA(x, z, halo)
+				}
+			}
+		}
+	}
+	return img
+}
@@ -0,0 +1,311 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image/color"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/maxsupermanhd/go-vmc/v764/level/block"
+)
+
+// Compiled in block registry only knows blocks of the version go-vmc was
+// generated for. Blocks of newer versions are described in registry files
+// (one per game version) and get state ids after the compiled ones, so color
+// palette and painters treat them as any other block.
+
+type registryEntry struct {
+	// #rrggbbaa, color of render_as block is used if empty
+	Color string `json:"color,omitempty"`
+	// known block it behaves like for painters that look at block types
+	RenderAs string `json:"render_as,omitempty"`
+}
+
+type registryFile struct {
+	Version string                   `json:"version"`
+	Blocks  map[string]registryEntry `json:"blocks"`
+}
+
+type registryBlock struct {
+	name  string
+	as    block.StateID
+	color *color.RGBA64
+}
+
+var (
+	registryBlocks   []registryBlock
+	registryBlockIDs = map[string]block.StateID{}
+	firstStates      map[string]block.StateID
+)
+
+const registryFallbackBlock = "minecraft:stone"
+
+func namespacedBlock(name string) string {
+	if strings.Contains(name, ":") {
+		return name
+	}
+	return "minecraft:" + name
+}
+
+// state ids of blocks are grouped, first one is a valid state unlike zero value of block
+func firstStateOf(name string) (block.StateID, bool) {
+	if firstStates == nil {
+		firstStates = map[string]block.StateID{}
+		for i, b := range block.StateList {
+			if _, ok := firstStates[b.ID()]; !ok {
+				firstStates[b.ID()] = block.StateID(i)
+			}
+		}
+	}
+	s, ok := firstStates[namespacedBlock(name)]
+	return s, ok
+}
+
+// compiled block for registry ones is the one they render as
+func stateBlock(s block.StateID) block.Block {
+	if int(s) < len(block.StateList) {
+		return block.StateList[s]
+	}
+	if i := int(s) - len(block.StateList); i < len(registryBlocks) {
+		return block.StateList[registryBlocks[i].as]
+	}
+	return block.Air{}
+}
+
+func stateName(s block.StateID) string {
+	if int(s) < len(block.StateList) {
+		return block.StateList[s].ID()
+	}
+	if i := int(s) - len(block.StateList); i < len(registryBlocks) {
+		return registryBlocks[i].name
+	}
+	return "minecraft:air"
+}
+
+func registryColored(s block.StateID) bool {
+	i := int(s) - len(block.StateList)
+	return i >= 0 && i < len(registryBlocks) && registryBlocks[i].color != nil
+}
+
+// state of block unknown to compiled registry, properties are not kept
+func registryState(name string) (block.StateID, bool) {
+	s, ok := registryBlockIDs[namespacedBlock(name)]
+	return s, ok
+}
+
+// files named <version>.json, with version set only that one is loaded
+// (and downloaded from block_registry_url if missing), all of them otherwise
+func loadBlockRegistry() error {
+	dir := cfg.GetDSString("./blockregistry", "block_registry_path")
+	version := cfg.GetDSString("", "block_registry_version")
+	var files []string
+	if version != "" {
+		p := filepath.Join(dir, version+".json")
+		if _, err := os.Stat(p); errors.Is(err, os.ErrNotExist) {
+			url := cfg.GetDSString("", "block_registry_url")
+			if url == "" {
+				return fmt.Errorf("block registry of version %s not found at %s", version, p)
+			}
+			if err := downloadBlockRegistry(strings.TrimSuffix(url, "/")+"/"+version+".json", p); err != nil {
+				return fmt.Errorf("downloading block registry: %w", err)
+			}
+		}
+		files = []string{p}
+	} else {
+		var err error
+		files, err = filepath.Glob(filepath.Join(dir, "*.json"))
+		if err != nil {
+			return err
+		}
+		sort.Strings(files)
+	}
+	entries := map[string]registryEntry{}
+	for _, p := range files {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		var f registryFile
+		if err := json.Unmarshal(b, &f); err != nil {
+			return fmt.Errorf("parsing %s: %w", p, err)
+		}
+		for n, e := range f.Blocks {
+			entries[namespacedBlock(n)] = e
+		}
+	}
+	setBlockRegistry(entries)
+	if len(registryBlocks) > 0 {
+		log.Printf("Loaded %d blocks from block registry", len(registryBlocks))
+	}
+	return nil
+}
+
+func setBlockRegistry(entries map[string]registryEntry) {
+	names := make([]string, 0, len(entries))
+	for n := range entries {
+		if _, ok := block.FromID[n]; !ok {
+			names = append(names, n)
+		}
+	}
+	sort.Strings(names)
+	registryBlocks = make([]registryBlock, 0, len(names))
+	registryBlockIDs = map[string]block.StateID{}
+	for _, n := range names {
+		e := entries[n]
+		b := registryBlock{name: n}
+		as, ok := firstStateOf(e.RenderAs)
+		if e.RenderAs == "" || !ok {
+			if e.RenderAs != "" {
+				log.Printf("Block registry entry [%s] renders as unknown block [%s]", n, e.RenderAs)
+			}
+			as, _ = firstStateOf(registryFallbackBlock)
+		}
+		b.as = as
+		if e.Color != "" {
+			c, err := ParseHexColor(e.Color)
+			if err != nil {
+				log.Printf("Block registry entry [%s] has bad color [%s]", n, e.Color)
+			} else {
+				b.color = &c
+			}
+		}
+		registryBlockIDs[n] = block.StateID(len(block.StateList) + len(registryBlocks))
+		registryBlocks = append(registryBlocks, b)
+	}
+}
+
+// palette of compiled states with colors of registry blocks appended
+func withRegistryColors(p []color.RGBA64) []color.RGBA64 {
+	if len(p) > len(block.StateList) {
+		p = p[:len(block.StateList)]
+	}
+	ret := make([]color.RGBA64, len(p), len(p)+len(registryBlocks))
+	copy(ret, p)
+	for _, b := range registryBlocks {
+		switch {
+		case b.color != nil:
+			ret = append(ret, *b.color)
+		case int(b.as) < len(p):
+			ret = append(ret, p[b.as])
+		default:
+			ret = append(ret, color.RGBA64{0x8000, 0x8000, 0x8000, 0xffff})
+		}
+	}
+	return ret
+}
+
+func downloadBlockRegistry(url, dst string) error {
+	c := http.Client{Timeout: 30 * time.Second}
+	resp, err := c.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s responded with %s", url, resp.Status)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return err
+	}
+	var f registryFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return fmt.Errorf("downloaded registry is broken: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(dst, b, 0644)
+}
+
+// new blocks usually are variants of existing ones, guessed by name ending
+var registryGuesses = []struct{ suffix, as string }{
+	{"_leaves", "oak_leaves"}, {"_log", "oak_log"}, {"_wood", "oak_wood"}, {"_planks", "oak_planks"},
+	{"_sapling", "oak_sapling"}, {"_slab", "stone_slab"}, {"_stairs", "stone_stairs"}, {"_wall", "cobblestone_wall"},
+	{"_fence", "oak_fence"}, {"_fence_gate", "oak_fence_gate"}, {"_door", "oak_door"}, {"_trapdoor", "oak_trapdoor"},
+	{"_button", "stone_button"}, {"_pressure_plate", "stone_pressure_plate"}, {"_sign", "oak_sign"},
+	{"_ore", "stone"}, {"_bricks", "stone_bricks"}, {"_tiles", "deepslate_tiles"}, {"_carpet", "white_carpet"},
+	{"_glass", "glass"}, {"_glass_pane", "glass_pane"}, {"_lantern", "lantern"}, {"_candle", "candle"},
+}
+
+func guessRenderAs(name string) string {
+	n := strings.TrimPrefix(name, "minecraft:")
+	best, ret := "", registryFallbackBlock
+	for _, g := range registryGuesses {
+		// longest matching ending wins, _fence_gate over _fence
+		if strings.HasSuffix(n, g.suffix) && len(g.suffix) > len(best) {
+			best, ret = g.suffix, "minecraft:"+g.as
+		}
+	}
+	return ret
+}
+
+// webchunk registry -version 1.21 -report generated/reports/blocks.json [-out dir]
+// generates registry file out of vanilla data generator report, only blocks
+// compiled registry does not have are written, colors are left to be filled in
+func registryCommand(args []string) error {
+	fs := flag.NewFlagSet("registry", flag.ContinueOnError)
+	version := fs.String("version", "", "game version registry is generated for")
+	report := fs.String("report", "", "blocks.json report of vanilla data generator")
+	out := fs.String("out", cfg.GetDSString("./blockregistry", "block_registry_path"), "directory to write registry file to")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *version == "" || *report == "" {
+		return errors.New("version and report are required")
+	}
+	b, err := os.ReadFile(*report)
+	if err != nil {
+		return err
+	}
+	blocks := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &blocks); err != nil {
+		return fmt.Errorf("parsing report: %w", err)
+	}
+	f := registryFile{Version: *version, Blocks: map[string]registryEntry{}}
+	for n := range blocks {
+		if _, ok := block.FromID[n]; ok {
+			continue
+		}
+		f.Blocks[n] = registryEntry{RenderAs: guessRenderAs(n)}
+	}
+	if err := os.MkdirAll(*out, 0755); err != nil {
+		return err
+	}
+	b, err = json.MarshalIndent(f, "", "\t")
+	if err != nil {
+		return err
+	}
+	p := filepath.Join(*out, *version+".json")
+	log.Printf("Writing %d new blocks to %s", len(f.Blocks), p)
+	return os.WriteFile(p, b, 0644)
+}
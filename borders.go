@@ -0,0 +1,201 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"time"
+
+	"github.com/maxsupermanhd/go-vmc/v764/save"
+)
+
+type bordersStyle struct {
+	biomes, chunks         bool
+	biomeColor, chunkColor color.RGBA
+}
+
+func getBordersStyle() bordersStyle {
+	parse := func(def string, path ...string) color.RGBA {
+		c, err := ParseHexColor(cfg.GetDSString(def, path...))
+		if err != nil {
+			c, _ = ParseHexColor(def)
+		}
+		return color.RGBA{uint8(c.R >> 8), uint8(c.G >> 8), uint8(c.B >> 8), uint8(c.A >> 8)}
+	}
+	return bordersStyle{
+		biomes:     cfg.GetDSBool(true, "layers", "borders", "biomes"),
+		chunks:     cfg.GetDSBool(true, "layers", "borders", "chunks"),
+		biomeColor: parse("#ffff00e0", "layers", "borders", "biome_color"),
+		chunkColor: parse("#00000060", "layers", "borders", "chunk_color"),
+	}
+}
+
+// biome ids of 4x4 cells of the topmost section, nil if there is no biome data
+func chunkTopBiomes(chunk *save.Chunk) []int {
+	if chunk == nil || len(chunk.Sections) == 0 {
+		return nil
+	}
+	topI := 0
+	for i, v := range chunk.Sections {
+		if v.Y > chunk.Sections[topI].Y {
+			topI = i
+		}
+	}
+	s := chunk.Sections[topI]
+	if len(s.Biomes.Palette) == 0 {
+		return nil
+	}
+	c := prepareSectionBiomes(&s)
+	if c == nil {
+		return nil
+	}
+	ret := make([]int, 4*4)
+	for i := range ret {
+		ret[i] = int(c.Get(i))
+	}
+	return ret
+}
+
+// blends c over pixel with given coverage, lines are split between
+// two neighboring pixels so each side gets half of the color
+func blendBorderPixel(img *image.RGBA, x, y int, c color.RGBA, coverage float64) {
+	if coverage <= 0 {
+		return
+	}
+	a := float64(c.A) * coverage / 255
+	p := img.RGBAAt(x, y)
+	pa := float64(p.A) / 255
+	oa := a + pa*(1-a)
+	if oa == 0 {
+		return
+	}
+	mix := func(s, d uint8) uint8 {
+		return uint8((float64(s)*a + float64(d)*pa*(1-a)) / oa)
+	}
+	img.SetRGBA(x, y, color.RGBA{mix(c.R, p.R), mix(c.G, p.G), mix(c.B, p.B), uint8(oa * 255)})
+}
+
+func drawChunkBorders(chunkContext ContextedChunkData) *image.RGBA {
+	t := time.Now()
+	style := getBordersStyle()
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	if style.biomes {
+		drawBiomeBorders(img, chunkContext, style.biomeColor)
+	}
+	if style.chunks {
+		for i := 0; i < 16; i++ {
+			blendBorderPixel(img, i, 0, style.chunkColor, 0.5)
+			blendBorderPixel(img, i, 15, style.chunkColor, 0.5)
+			if i != 0 && i != 15 {
+				blendBorderPixel(img, 0, i, style.chunkColor, 0.5)
+				blendBorderPixel(img, 15, i, style.chunkColor, 0.5)
+			}
+		}
+	}
+	appendMetrics(time.Since(t), "borders")
+	return img
+}
+
+func drawBiomeBorders(img *image.RGBA, chunkContext ContextedChunkData, c color.RGBA) {
+	center := chunkTopBiomes(chunkContext.center)
+	if center == nil {
+		return
+	}
+	top := chunkTopBiomes(chunkContext.top)
+	bottom := chunkTopBiomes(chunkContext.bottom)
+	left := chunkTopBiomes(chunkContext.left)
+	right := chunkTopBiomes(chunkContext.right)
+	diagonal := func(c *save.Chunk, cell int) int {
+		b := chunkTopBiomes(c)
+		if b == nil {
+			return -1
+		}
+		return b[cell]
+	}
+	// biome of the cell next to the given one, -1 if unknown
+	cellAt := func(cx, cz int) int {
+		switch {
+		case cx < 0 && cz < 0:
+			return diagonal(chunkContext.topLeft, 15)
+		case cx > 3 && cz < 0:
+			return diagonal(chunkContext.topRight, 12)
+		case cx < 0 && cz > 3:
+			return diagonal(chunkContext.bottomLeft, 3)
+		case cx > 3 && cz > 3:
+			return diagonal(chunkContext.bottomRight, 0)
+		case cx < 0:
+			if left == nil {
+				return -1
+			}
+			return left[cz*4+3]
+		case cx > 3:
+			if right == nil {
+				return -1
+			}
+			return right[cz*4]
+		case cz < 0:
+			if top == nil {
+				return -1
+			}
+			return top[12+cx]
+		case cz > 3:
+			if bottom == nil {
+				return -1
+			}
+			return bottom[cx]
+		}
+		return center[cz*4+cx]
+	}
+	differs := func(b, cx, cz int) bool {
+		n := cellAt(cx, cz)
+		return n >= 0 && n != b
+	}
+	for cz := 0; cz < 4; cz++ {
+		for cx := 0; cx < 4; cx++ {
+			b := center[cz*4+cx]
+			l, r := differs(b, cx-1, cz), differs(b, cx+1, cz)
+			u, d := differs(b, cx, cz-1), differs(b, cx, cz+1)
+			x0, z0 := cx*4, cz*4
+			for i := 0; i < 4; i++ {
+				if l {
+					blendBorderPixel(img, x0, z0+i, c, 0.5)
+				}
+				if r {
+					blendBorderPixel(img, x0+3, z0+i, c, 0.5)
+				}
+				if u {
+					blendBorderPixel(img, x0+i, z0, c, 0.5)
+				}
+				if d {
+					blendBorderPixel(img, x0+i, z0+3, c, 0.5)
+				}
+			}
+			// soften stair steps where border turns around inner corner of diagonal neighbor
+			corners := [4][4]int{{-1, -1, x0, z0}, {1, -1, x0 + 3, z0}, {-1, 1, x0, z0 + 3}, {1, 1, x0 + 3, z0 + 3}}
+			for _, k := range corners {
+				if !differs(b, cx+k[0], cz) && !differs(b, cx, cz+k[1]) && differs(b, cx+k[0], cz+k[1]) {
+					blendBorderPixel(img, k[2], k[3], c, 0.25)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,206 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/maxsupermanhd/WebChunk/proxy"
+)
+
+type chatRecord struct {
+	Time      time.Time
+	Player    string
+	Dimension string
+	Kind      string
+	Sender    string `json:",omitempty"`
+	Text      string
+}
+
+type chatDedupKey struct {
+	world, kind, sender, text string
+}
+
+// same message is received by every proxied player on the server
+const chatDedupWindow = 5 * time.Second
+
+var (
+	chatRecent     = map[chatDedupKey]time.Time{}
+	chatRecentLock sync.Mutex
+)
+
+func chatIsDuplicate(k chatDedupKey, t time.Time) bool {
+	chatRecentLock.Lock()
+	defer chatRecentLock.Unlock()
+	for kk, tt := range chatRecent {
+		if t.Sub(tt) > chatDedupWindow {
+			delete(chatRecent, kk)
+		}
+	}
+	if _, ok := chatRecent[k]; ok {
+		return true
+	}
+	chatRecent[k] = t
+	return false
+}
+
+func chatReceived(e *proxy.ProxiedEvent, d proxy.EventChat) {
+	if strings.TrimSpace(d.Text) == "" {
+		return
+	}
+	if chatIsDuplicate(chatDedupKey{world: e.Server, kind: d.Kind, sender: d.Sender, text: d.Text}, e.Time) {
+		return
+	}
+	err := recs.Append(e.Server, "", "chat", chatRecord{
+		Time:      e.Time,
+		Player:    e.Username,
+		Dimension: strings.TrimPrefix(e.Dimension, "minecraft:"),
+		Kind:      d.Kind,
+		Sender:    d.Sender,
+		Text:      d.Text,
+	})
+	if err != nil {
+		log.Printf("Failed to record chat message: %s", err.Error())
+	}
+}
+
+type chatQuery struct {
+	Text   string
+	Sender string
+	Kind   string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+func parseChatQuery(r *http.Request) chatQuery {
+	q := chatQuery{
+		Text:   strings.ToLower(r.FormValue("q")),
+		Sender: strings.ToLower(r.FormValue("sender")),
+		Kind:   r.FormValue("kind"),
+		Limit:  200,
+	}
+	if l, err := strconv.Atoi(r.FormValue("limit")); err == nil && l > 0 {
+		q.Limit = l
+	}
+	if t, err := time.Parse(time.RFC3339, r.FormValue("since")); err == nil {
+		q.Since = t
+	}
+	if t, err := time.Parse(time.RFC3339, r.FormValue("until")); err == nil {
+		q.Until = t
+	}
+	return q
+}
+
+func (q chatQuery) matches(c chatRecord) bool {
+	if q.Kind != "" && c.Kind != q.Kind {
+		return false
+	}
+	if q.Sender != "" && !strings.Contains(strings.ToLower(c.Sender), q.Sender) {
+		return false
+	}
+	if q.Text != "" && !strings.Contains(strings.ToLower(c.Text), q.Text) {
+		return false
+	}
+	if !q.Since.IsZero() && c.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && c.Time.After(q.Until) {
+		return false
+	}
+	return true
+}
+
+func (c *chatRecord) anonymize(names playerNamer) {
+	c.Text = anonymizeText(anonymizeText(c.Text, c.Player, names), c.Sender, names)
+	c.Player = names(c.Player)
+	c.Sender = names(c.Sender)
+}
+
+// newest messages first, names are replaced before matching so sender filter works on what is shown
+func searchChat(wname string, q chatQuery, names playerNamer) ([]chatRecord, error) {
+	ret := []chatRecord{}
+	err := recs.Read(wname, "", "chat", func(m json.RawMessage) error {
+		var c chatRecord
+		if json.Unmarshal(m, &c) != nil {
+			return nil
+		}
+		c.anonymize(names)
+		if q.matches(c) {
+			ret = append(ret, c)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].Time.After(ret[j].Time)
+	})
+	if len(ret) > q.Limit {
+		ret = ret[:q.Limit]
+	}
+	return ret, nil
+}
+
+func apiSearchChat(w http.ResponseWriter, r *http.Request) (int, string) {
+	msgs, err := searchChat(mux.Vars(r)["world"], parseChatQuery(r), playerNamerFor(r))
+	if err != nil {
+		return 500, err.Error()
+	}
+	setContentTypeJson(w)
+	return marshalOrFail(200, msgs)
+}
+
+func chatHandler(w http.ResponseWriter, r *http.Request) {
+	worlds := []string{}
+	for wname := range listNamesWnD() {
+		worlds = append(worlds, wname)
+	}
+	sort.Strings(worlds)
+	wname := r.FormValue("world")
+	q := parseChatQuery(r)
+	var msgs []chatRecord
+	if wname != "" {
+		var err error
+		msgs, err = searchChat(wname, q, playerNamerFor(r))
+		if err != nil {
+			plainmsg(w, r, plainmsgColorRed, "Failed to read chat log: "+err.Error())
+			return
+		}
+	}
+	templateRespond("chat", w, r, map[string]any{
+		"Worlds":   worlds,
+		"World":    wname,
+		"Query":    r.FormValue("q"),
+		"Sender":   r.FormValue("sender"),
+		"Kind":     q.Kind,
+		"Messages": msgs,
+	})
+}
@@ -9,24 +9,36 @@ import (
 	"time"
 
 	"github.com/maxsupermanhd/WebChunk/chunkStorage"
+	"github.com/maxsupermanhd/WebChunk/proxy"
 	"github.com/maxsupermanhd/go-vmc/v764/level"
 	"github.com/maxsupermanhd/go-vmc/v764/nbt"
 	"github.com/maxsupermanhd/go-vmc/v764/save"
 )
 
 func chunkConsumer(exitchan <-chan struct{}) {
+	pending := pendingBlockChanges{}
+	flushTicker := time.NewTicker(time.Duration(cfg.GetDSInt(2000, "block_changes_flush_interval")) * time.Millisecond)
+	defer flushTicker.Stop()
 	for {
 		select {
 		case <-exitchan:
+			pending.flush()
 			return
+		case <-flushTicker.C:
+			pending.flush()
 		case r := <-chunkChannel:
 			if r.Dimension == "" || r.Server == "" {
 				log.Printf("Got chunk [%v](%v) from [%v] by [%v] with empty params, DROPPING", r.Pos, r.Dimension, r.Server, r.Username)
 				continue
 			}
-			log.Printf("Got chunk %v %#v from [%v] by [%v] (%2d s) (%3d be)", r.Pos, r.Dimension, r.Server, r.Username, len(r.Data.Sections), len(r.Data.BlockEntity))
+			if len(r.Changes) > 0 {
+				pending.add(r)
+				continue
+			}
+			pending.forget(r)
+			log.Printf("Got chunk %v %#v from [%v] by [%v] (%2d s) (%3d be) [%s]", r.Pos, r.Dimension, r.Server, r.Username, len(r.Data.Sections), len(r.Data.BlockEntity), r.BatchID)
 			r.Dimension = strings.TrimPrefix(r.Dimension, "minecraft:")
-			w, s, err := chunkStorage.GetWorldStorage(storages, r.Server)
+			w, s, err := storages.World(r.Server)
 			if err != nil {
 				log.Println("Failed to lookup world storage: ", err)
 				break
@@ -34,9 +46,9 @@ func chunkConsumer(exitchan <-chan struct{}) {
 			var d *chunkStorage.SDim
 			if w == nil || s == nil {
 				pref := cfg.GetDSString("", "preferred_storage")
-				s = findCapableStorage(storages, pref)
+				s = storages.Capable(pref)
 				if s == nil {
-					log.Printf("Failed to find storage that has world [%s], named [%s] or has ability to add chunks, chunk [%v] from [%v] by [%v] is LOST.", r.Server, pref, r.Pos, r.Server, r.Username)
+					log.Printf("Failed to find storage that has world [%s], named [%s] or has ability to add chunks, chunk [%v] from [%v] by [%v] [%s] is LOST.", r.Server, pref, r.Pos, r.Server, r.Username, r.BatchID)
 					continue
 				}
 				w = &chunkStorage.SWorld{
@@ -66,11 +78,17 @@ func chunkConsumer(exitchan <-chan struct{}) {
 					ModifiedAt: time.Now(),
 					Data:       chunkStorage.GuessDimTypeFromName(r.Dimension),
 				}
+				proxiedDimensionHeight(r, &d.Data)
 				err = s.AddDimension(w.Name, *d)
 				if err != nil {
 					log.Printf("Failed to add dim: %s", err.Error())
 					continue
 				}
+			} else if proxiedDimensionHeight(r, &d.Data) {
+				err = s.SetDimensionData(w.Name, d.Name, d.Data)
+				if err != nil {
+					log.Printf("Failed to update dim height: %s", err.Error())
+				}
 			}
 			if d == nil {
 				log.Println("d is nill")
@@ -134,18 +152,22 @@ func chunkConsumer(exitchan <-chan struct{}) {
 			chunkBytesWriter := gzip.NewWriter(&chunkBytes)
 			err = nbt.NewEncoder(chunkBytesWriter).Encode(data, "")
 			if err != nil {
-				log.Printf("Failed to marshal chunk: %s", err.Error())
+				log.Printf("Failed to marshal chunk [%s]: %s", r.BatchID, err.Error())
 				continue
 			}
 			err = chunkBytesWriter.Close()
 			if err != nil {
-				log.Printf("Failed to flush chunk buffer: %s", err.Error())
+				log.Printf("Failed to flush chunk buffer [%s]: %s", r.BatchID, err.Error())
 				continue
 			}
 			err = s.AddChunkRaw(w.Name, d.Name, int(r.Pos[0]), int(r.Pos[1]), chunkBytes.Bytes())
 			if err != nil {
-				log.Printf("Failed to save chunk: %s", err.Error())
+				log.Printf("Failed to save chunk [%s]: %s", r.BatchID, err.Error())
+			} else {
+				chunkDiscovered(w.Name, d.Name, r.Username, int(r.Pos[0]), int(r.Pos[1]))
+				captureBlockMarkers(w.Name, d.Name, int(r.Pos[0]), int(r.Pos[1]), &data)
 			}
+			captureChunkSigns(r)
 			if cfg.GetDSBool(true, "render_received") {
 				go func() {
 					i := drawChunk(&data)
@@ -155,3 +177,18 @@ func chunkConsumer(exitchan <-chan struct{}) {
 		}
 	}
 }
+
+// server knows height of the dimension better than name based guess,
+// returns true if dimension data was changed
+func proxiedDimensionHeight(r *proxy.ProxiedChunk, dt *save.DimensionType) bool {
+	height := int32(r.DimensionBuildLimit) - r.DimensionLowestY
+	if height <= 0 || (dt.MinY == r.DimensionLowestY && dt.Height == height) {
+		return false
+	}
+	dt.MinY = r.DimensionLowestY
+	dt.Height = height
+	if dt.LogicalHeight > height {
+		dt.LogicalHeight = height
+	}
+	return true
+}
@@ -1,13 +1,16 @@
 package main
 
 import (
+	"sort"
+
 	"github.com/maxsupermanhd/WebChunk/chunkStorage"
 	"github.com/maxsupermanhd/go-vmc/v764/save"
 )
 
 type ContextedChunkData struct {
-	center                   *save.Chunk
-	top, bottom, left, right *save.Chunk
+	center                                     *save.Chunk
+	top, bottom, left, right                   *save.Chunk
+	topLeft, topRight, bottomLeft, bottomRight *save.Chunk
 }
 
 func getChunksRegionWithContextFN(cs chunkStorage.ChunkStorage) chunkDataProviderFunc {
@@ -30,6 +33,8 @@ func getChunksRegionWithContext(cs chunkStorage.ChunkStorage, wname, dname strin
 		if !ok {
 			continue
 		}
+		// neighbours are shared between chunks painted at once
+		sortSectionsTopDown(&c)
 		bunch[chunkpos{v.X, v.Z}] = &c
 	}
 	ret := []chunkStorage.ChunkData{}
@@ -46,8 +51,22 @@ func getChunksRegionWithContext(cs chunkStorage.ChunkStorage, wname, dname strin
 				bottom: bunch[chunkpos{X: k.X, Z: k.Z + 1}],
 				left:   bunch[chunkpos{X: k.X - 1, Z: k.Z}],
 				right:  bunch[chunkpos{X: k.X + 1, Z: k.Z}],
+
+				topLeft:     bunch[chunkpos{X: k.X - 1, Z: k.Z - 1}],
+				topRight:    bunch[chunkpos{X: k.X + 1, Z: k.Z - 1}],
+				bottomLeft:  bunch[chunkpos{X: k.X - 1, Z: k.Z + 1}],
+				bottomRight: bunch[chunkpos{X: k.X + 1, Z: k.Z + 1}],
 			},
 		})
 	}
 	return ret, nil
 }
+
+// painters look at sections top down, chunks that are already sorted are
+// only read so painters running at once can share them
+func sortSectionsTopDown(chunk *save.Chunk) {
+	less := func(i, j int) bool { return int8(chunk.Sections[i].Y) > int8(chunk.Sections[j].Y) }
+	if !sort.SliceIsSorted(chunk.Sections, less) {
+		sort.Slice(chunk.Sections, less)
+	}
+}
@@ -0,0 +1,255 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"container/list"
+	"encoding/binary"
+	"hash/maphash"
+	"image"
+	"sync"
+	"sync/atomic"
+
+	"github.com/maxsupermanhd/WebChunk/chunkStorage"
+	"github.com/maxsupermanhd/go-vmc/v764/nbt"
+	"github.com/maxsupermanhd/go-vmc/v764/save"
+)
+
+// painted chunks are remembered by layer and hash of everything painter
+// could look at, so re-rendering tile with few changed chunks only paints those,
+// painters also look at config so generation changes every time it is saved
+type chunkMemoKey struct {
+	layer string
+	gen   uint64
+	sum   uint64
+}
+
+type chunkMemoEntry struct {
+	key chunkMemoKey
+	img *image.RGBA
+}
+
+var (
+	chunkMemoSeed  = maphash.MakeSeed()
+	chunkMemoLock  sync.Mutex
+	chunkMemoOrder = list.New()
+	chunkMemoIndex = map[chunkMemoKey]*list.Element{}
+	chunkMemoGen   atomic.Uint64
+)
+
+func chunkMemoSize() int {
+	return cfg.GetDSInt(16384, "chunk_memo", "size")
+}
+
+func chunkMemoGet(key chunkMemoKey) (*image.RGBA, bool) {
+	chunkMemoLock.Lock()
+	defer chunkMemoLock.Unlock()
+	e, ok := chunkMemoIndex[key]
+	if !ok {
+		return nil, false
+	}
+	chunkMemoOrder.MoveToFront(e)
+	return e.Value.(*chunkMemoEntry).img, true
+}
+
+func chunkMemoPut(key chunkMemoKey, img *image.RGBA, size int) {
+	chunkMemoLock.Lock()
+	defer chunkMemoLock.Unlock()
+	if e, ok := chunkMemoIndex[key]; ok {
+		e.Value.(*chunkMemoEntry).img = img
+		chunkMemoOrder.MoveToFront(e)
+		return
+	}
+	chunkMemoIndex[key] = chunkMemoOrder.PushFront(&chunkMemoEntry{key: key, img: img})
+	for chunkMemoOrder.Len() > size {
+		e := chunkMemoOrder.Back()
+		chunkMemoOrder.Remove(e)
+		delete(chunkMemoIndex, e.Value.(*chunkMemoEntry).key)
+	}
+}
+
+// drops remembered chunks of layer and all its variants, for when
+// painter itself changes
+func forgetChunkMemo(layer string) {
+	chunkMemoLock.Lock()
+	defer chunkMemoLock.Unlock()
+	for e := chunkMemoOrder.Front(); e != nil; {
+		next := e.Next()
+		k := e.Value.(*chunkMemoEntry).key
+		if k.layer == layer || layerBaseName(k.layer) == layer {
+			chunkMemoOrder.Remove(e)
+			delete(chunkMemoIndex, k)
+		}
+		e = next
+	}
+}
+
+// drops everything, paints that are still running with old config
+// put their chunks under old generation that nobody asks for
+func flushChunkMemo() {
+	chunkMemoGen.Add(1)
+	chunkMemoLock.Lock()
+	defer chunkMemoLock.Unlock()
+	chunkMemoOrder.Init()
+	chunkMemoIndex = map[chunkMemoKey]*list.Element{}
+}
+
+func withChunkMemo(variant string, f ttypeProviderFunc) ttypeProviderFunc {
+	return func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
+		gen := chunkMemoGen.Load()
+		getter, painter := f(s)
+		size := chunkMemoSize()
+		if size <= 0 || !cfg.GetDSBool(true, "layers", layerBaseName(variant), "memoize") {
+			return getter, painter
+		}
+		return getter, func(i interface{}) *image.RGBA {
+			h := maphash.Hash{}
+			h.SetSeed(chunkMemoSeed)
+			if !hashChunkData(&h, i) {
+				return painter(i)
+			}
+			key := chunkMemoKey{layer: variant, gen: gen, sum: h.Sum64()}
+			if img, ok := chunkMemoGet(key); ok {
+				return img
+			}
+			img := painter(i)
+			chunkMemoPut(key, img, size)
+			return img
+		}
+	}
+}
+
+// only data that is chunks (with neighbours) is hashed, anything else
+// is cheap to paint or can not be told apart reliably
+func hashChunkData(h *maphash.Hash, i interface{}) bool {
+	switch d := i.(type) {
+	case save.Chunk:
+		hashChunk(h, &d)
+	case *save.Chunk:
+		hashChunk(h, d)
+	case ContextedChunkData:
+		for _, c := range []*save.Chunk{d.center, d.top, d.bottom, d.left, d.right, d.topLeft, d.topRight, d.bottomLeft, d.bottomRight} {
+			hashChunk(h, c)
+		}
+	case fallbackChunk:
+		for _, s := range d {
+			h.WriteString(s.layer)
+			h.WriteByte(0)
+			if !hashChunkData(h, s.data) {
+				return false
+			}
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+func hashChunk(h *maphash.Hash, c *save.Chunk) {
+	if c == nil {
+		h.WriteByte(0)
+		return
+	}
+	h.WriteByte(1)
+	buf := make([]byte, 0, 4096)
+	num := func(v int64) {
+		buf = binary.LittleEndian.AppendUint64(buf[:0], uint64(v))
+		h.Write(buf)
+	}
+	longs := func(l []uint64) {
+		num(int64(len(l)))
+		buf = buf[:0]
+		for _, v := range l {
+			buf = binary.LittleEndian.AppendUint64(buf, v)
+			if len(buf) >= 4096 {
+				h.Write(buf)
+				buf = buf[:0]
+			}
+		}
+		h.Write(buf)
+	}
+	blob := func(b []byte) {
+		num(int64(len(b)))
+		h.Write(b)
+	}
+	str := func(s string) {
+		num(int64(len(s)))
+		h.WriteString(s)
+	}
+	raw := func(m nbt.RawMessage) {
+		h.WriteByte(m.Type)
+		blob(m.Data)
+	}
+	raws := func(l []nbt.RawMessage) {
+		num(int64(len(l)))
+		for _, m := range l {
+			raw(m)
+		}
+	}
+	longMap := func(m map[string][]uint64) {
+		// map order is random, sum of per-key hashes does not care
+		var sum uint64
+		for k, v := range m {
+			sub := maphash.Hash{}
+			sub.SetSeed(chunkMemoSeed)
+			sub.WriteString(k)
+			for _, l := range v {
+				sub.Write(binary.LittleEndian.AppendUint64(nil, l))
+			}
+			sum += sub.Sum64()
+		}
+		num(int64(sum))
+	}
+	num(int64(c.XPos))
+	num(int64(c.YPos))
+	num(int64(c.ZPos))
+	num(int64(c.DataVersion))
+	num(c.InhabitedTime)
+	num(c.LastUpdate)
+	h.WriteByte(c.IsLightOn)
+	str(c.Status)
+	longMap(c.Heightmaps)
+	longMap(c.CarvingMasks)
+	raws(c.BlockEntities)
+	raws(c.Entities)
+	raws(c.Lights)
+	raw(c.BlockTicks)
+	raw(c.FluidTicks)
+	raw(c.PostProcessing)
+	raw(c.Structures)
+	num(int64(len(c.Sections)))
+	for _, s := range c.Sections {
+		num(int64(s.Y))
+		num(int64(len(s.BlockStates.Palette)))
+		for _, b := range s.BlockStates.Palette {
+			str(b.Name)
+			raw(b.Properties)
+		}
+		longs(s.BlockStates.Data)
+		num(int64(len(s.Biomes.Palette)))
+		for _, b := range s.Biomes.Palette {
+			str(string(b))
+		}
+		longs(s.Biomes.Data)
+		blob(s.SkyLight)
+		blob(s.BlockLight)
+	}
+}
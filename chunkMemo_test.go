@@ -0,0 +1,61 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/maxsupermanhd/WebChunk/chunkStorage"
+	"github.com/maxsupermanhd/go-vmc/v764/save"
+)
+
+func TestChunkMemoPaletteChange(t *testing.T) {
+	colors.Set([]color.RGBA64{{}, {R: 0xffff, A: 0xffff}})
+	painted := 0
+	f := withChunkMemo("memotest", func(chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
+		return nil, func(interface{}) *image.RGBA {
+			painted++
+			img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+			img.Set(0, 0, colors.Get()[1])
+			return img
+		}
+	})
+	paint := func() color.RGBA {
+		_, p := f(nil)
+		return p(save.Chunk{}).RGBAAt(0, 0)
+	}
+	if c := paint(); c.R != 0xff || c.B != 0 {
+		t.Fatalf("first paint is %v", c)
+	}
+	paint()
+	if painted != 1 {
+		t.Fatalf("same chunk painted %d times, want memoized", painted)
+	}
+	colors.SetColor(1, color.RGBA64{B: 0xffff, A: 0xffff})
+	if c := paint(); c.R != 0 || c.B != 0xff {
+		t.Fatalf("paint after palette change is %v", c)
+	}
+	if painted != 2 {
+		t.Fatalf("chunk painted %d times, want 2", painted)
+	}
+}
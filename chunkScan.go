@@ -0,0 +1,62 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"github.com/maxsupermanhd/go-vmc/v764/level"
+	"github.com/maxsupermanhd/go-vmc/v764/level/block"
+	"github.com/maxsupermanhd/go-vmc/v764/save"
+)
+
+// scanChunkBlocks calls found with world coordinates of every block which palette
+// entry was accepted by match, match is called once per palette entry and
+// result is passed along so it does not have to be figured out again per block
+func scanChunkBlocks[T any](chunk *save.Chunk, match func(save.BlockState) (T, bool), found func(x, y, z int, tag T)) {
+	for _, s := range chunk.Sections {
+		palette := s.BlockStates.Palette
+		if len(palette) == 0 {
+			continue
+		}
+		tags := make([]T, len(palette))
+		wanted := make([]bool, len(palette))
+		anyWanted := false
+		for i, v := range palette {
+			tags[i], wanted[i] = match(v)
+			anyWanted = anyWanted || wanted[i]
+		}
+		if !anyWanted {
+			continue
+		}
+		// palette indexes instead of states to not resolve every entry
+		indexes := make([]block.StateID, len(palette))
+		for i := range indexes {
+			indexes[i] = block.StateID(i)
+		}
+		states := level.NewStatesPaletteContainerWithData(16*16*16, s.BlockStates.Data, indexes)
+		for i := 0; i < 16*16*16; i++ {
+			p := int(states.Get(i))
+			if p >= len(wanted) || !wanted[p] {
+				continue
+			}
+			found(int(chunk.XPos)*16+i%16, int(s.Y)*16+i/256, int(chunk.ZPos)*16+(i/16)%16, tags[p])
+		}
+	}
+}
@@ -0,0 +1,79 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+type chunkStats struct {
+	X             int            `json:"x"`
+	Z             int            `json:"z"`
+	Redstone      map[string]int `json:"redstone"`
+	RedstoneTotal int            `json:"redstone_total"`
+	Containers    int            `json:"containers"`
+	Portals       int            `json:"portals"`
+	Crops         cropCounts     `json:"crops"`
+}
+
+func apiChunkStats(w http.ResponseWriter, r *http.Request) (int, string) {
+	params := mux.Vars(r)
+	wname := params["world"]
+	dname := params["dim"]
+	cx, err := strconv.Atoi(params["cx"])
+	if err != nil {
+		return 400, "Bad cx: " + err.Error()
+	}
+	cz, err := strconv.Atoi(params["cz"])
+	if err != nil {
+		return 400, "Bad cz: " + err.Error()
+	}
+	_, s, err := storages.World(wname)
+	if err != nil {
+		return 500, err.Error()
+	}
+	if s == nil {
+		return 404, "World not found"
+	}
+	chunk, err := s.GetChunk(wname, dname, cx, cz)
+	if err != nil {
+		return 500, "Chunk query error: " + err.Error()
+	}
+	if chunk == nil {
+		return 404, "Chunk not found"
+	}
+	ret := chunkStats{
+		X:          cx,
+		Z:          cz,
+		Redstone:   countRedstone(chunk),
+		Containers: countChestBlocks(chunk),
+		Portals:    countPortalBlocks(chunk),
+		Crops:      countCrops(chunk),
+	}
+	for _, v := range ret.Redstone {
+		ret.RedstoneTotal += v
+	}
+	setContentTypeJson(w)
+	return marshalOrFail(200, ret)
+}
@@ -8,7 +8,7 @@ import (
 
 func ConvFlexibleNBTtoSave(d []byte) (ret *save.Chunk, err error) {
 	ret = &save.Chunk{}
-	err = ret.Load(d)
+	err = LoadFlexibleChunk(ret, d)
 	if err != nil {
 		log.Print(err)
 	}
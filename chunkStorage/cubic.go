@@ -0,0 +1,94 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package chunkStorage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"math"
+
+	"github.com/maxsupermanhd/go-vmc/v764/nbt"
+	"github.com/maxsupermanhd/go-vmc/v764/save"
+)
+
+// Cubic Chunks mod stores the world as 16x16x16 cubes, each one has a single
+// pre-1.13 section, cubes of a column are stacked into a regular chunk.
+// Sections are indexed with int8 so only cubes within Y -2048..2047 fit.
+
+type cubicCube struct {
+	Level struct {
+		X            int32 `nbt:"x"`
+		Y            int32 `nbt:"y"`
+		Z            int32 `nbt:"z"`
+		Sections     []legacySection
+		Entities     []nbt.RawMessage
+		TileEntities []nbt.RawMessage
+	}
+}
+
+// DecodeCubicCube reads cube position, data can be gzip compressed or plain NBT
+func DecodeCubicCube(d []byte) (x, y, z int32, err error) {
+	c, err := decodeCubicCube(d)
+	return c.Level.X, c.Level.Y, c.Level.Z, err
+}
+
+func decodeCubicCube(d []byte) (c cubicCube, err error) {
+	var r io.Reader = bytes.NewReader(d)
+	if len(d) > 2 && d[0] == 0x1f && d[1] == 0x8b {
+		if r, err = gzip.NewReader(r); err != nil {
+			return
+		}
+	}
+	_, err = nbt.NewDecoder(r).Decode(&c)
+	return
+}
+
+// CubicColumn stacks cubes of one column into a chunk, returns number of
+// cubes that were left out because they are too high or too low
+func CubicColumn(cx, cz int32, cubes [][]byte) (save.Chunk, int, error) {
+	l := legacyChunk{}
+	l.Level.XPos, l.Level.ZPos = cx, cz
+	l.Level.Sections = []legacySection{}
+	skipped := 0
+	for _, d := range cubes {
+		c, err := decodeCubicCube(d)
+		if err != nil {
+			return save.Chunk{}, skipped, err
+		}
+		if c.Level.Y < math.MinInt8 || c.Level.Y > math.MaxInt8 || len(c.Level.Sections) == 0 {
+			skipped++
+			continue
+		}
+		s := c.Level.Sections[0]
+		s.Y = int8(c.Level.Y)
+		l.Level.Sections = append(l.Level.Sections, s)
+		l.Level.Entities = append(l.Level.Entities, c.Level.Entities...)
+		l.Level.TileEntities = append(l.Level.TileEntities, c.Level.TileEntities...)
+	}
+	ret := l.toSave()
+	for i, s := range ret.Sections {
+		if i == 0 || int32(s.Y) < ret.YPos {
+			ret.YPos = int32(s.Y)
+		}
+	}
+	return ret, skipped, nil
+}
@@ -288,6 +288,9 @@ func (s *FilesystemChunkStorage) regionWorker(loc regionLocator, ch <-chan regio
 		case regionRouterSetChunk:
 			x, z := region.In(r.cx1, r.cz1)
 			err = reg.WriteSector(x, z, r.data)
+			if err == nil {
+				err = touchRegionTimestamp(s.getRegionPath(loc), reg, x, z)
+			}
 			if err != nil {
 				sendClose(err)
 				return
@@ -403,10 +406,17 @@ func (s *FilesystemChunkStorage) GetChunkModDate(wname, dname string, cx, cz int
 	}
 	for ret := range r {
 		switch v := ret.(type) {
+		case nil:
+			return nil, nil
 		case error:
 			return nil, v
-		case time.Time:
-			return &v, nil
+		case int32:
+			// region header has zero timestamp for missing chunks
+			if v == 0 {
+				return nil, nil
+			}
+			t := time.Unix(int64(v), 0)
+			return &t, nil
 		}
 	}
 	return nil, errors.New("no response from region worker")
@@ -421,7 +431,7 @@ func (s *FilesystemChunkStorage) GetChunk(wname, dname string, cx, cz int) (*sav
 		return nil, nil
 	}
 	var c save.Chunk
-	err = c.Load(d)
+	err = chunkStorage.LoadFlexibleChunk(&c, d)
 	return &c, err
 }
 
@@ -691,3 +701,158 @@ func (s *FilesystemChunkStorage) GetDimensionChunksSize(wname, dname string) (r
 	}
 	return r, nil
 }
+
+// reads chunk timestamps straight from region header, like CountRegionChunks
+// it does not go through the worker so it can see header mid-write
+func readRegionTimestamps(fname string) (offsets, timestamps [1024]int32, err error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return offsets, timestamps, err
+	}
+	defer f.Close()
+	err = binary.Read(f, binary.BigEndian, &offsets)
+	if err != nil {
+		return offsets, timestamps, err
+	}
+	err = binary.Read(f, binary.BigEndian, &timestamps)
+	return offsets, timestamps, err
+}
+
+// WriteSector only updates header timestamp when chunk is moved to other sectors,
+// chunks overwritten in place have to be touched to be found by ListChunksModifiedSince
+func touchRegionTimestamp(fname string, reg *region.Region, x, z int) error {
+	now := int32(time.Now().Unix())
+	if reg.Timestamps[x][z] == now {
+		return nil
+	}
+	f, err := os.OpenFile(fname, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(now))
+	if _, err := f.WriteAt(b[:], 4096+4*int64(z*32+x)); err != nil {
+		return err
+	}
+	reg.Timestamps[x][z] = now
+	return nil
+}
+
+// region header has timestamps in seconds so everything modified
+// during the second of since is returned too
+func (s *FilesystemChunkStorage) ListChunksModifiedSince(wname, dname string, since time.Time) ([]chunkStorage.ChunkData, error) {
+	ret := []chunkStorage.ChunkData{}
+	dirloc := s.getRegionFolder(regionLocator{
+		world:     wname,
+		dimension: dname,
+	})
+	d, err := os.ReadDir(dirloc)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ret, nil
+		}
+		return ret, err
+	}
+	sinceSec := since.Truncate(time.Second)
+	for _, i := range d {
+		var rx, rz int
+		if i.IsDir() || !ExtractRegionPath(i.Name(), &rx, &rz) {
+			continue
+		}
+		// file is written on every chunk change, no need to read old ones
+		if info, err := i.Info(); err == nil && info.ModTime().Before(sinceSec) {
+			continue
+		}
+		offsets, timestamps, err := readRegionTimestamps(path.Join(dirloc, i.Name()))
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				continue
+			}
+			return ret, err
+		}
+		for lz := 0; lz < 32; lz++ {
+			for lx := 0; lx < 32; lx++ {
+				idx := lz*32 + lx
+				if offsets[idx] == 0 || timestamps[idx] == 0 || int64(timestamps[idx]) < sinceSec.Unix() {
+					continue
+				}
+				ret = append(ret, chunkStorage.ChunkData{
+					X:    rx*32 + lx,
+					Z:    rz*32 + lz,
+					Data: time.Unix(int64(timestamps[idx]), 0),
+				})
+			}
+		}
+	}
+	return ret, nil
+}
+
+// timestamps are taken from region headers, chunks are not touched
+func (s *FilesystemChunkStorage) GetChunksModDateRegion(wname, dname string, cx0, cz0, cx1, cz1 int) ([]chunkStorage.ChunkData, error) {
+	cx0, cz0, cx1, cz1 = normalizeCoords(cx0, cz0, cx1, cz1)
+	ret := []chunkStorage.ChunkData{}
+	rx0, rz0 := region.At(cx0, cz0)
+	rx1, rz1 := region.At(cx1-1, cz1-1)
+	for rz := rz0; rz <= rz1; rz++ {
+		for rx := rx0; rx <= rx1; rx++ {
+			fname := s.getRegionPath(regionLocator{world: wname, dimension: dname, rx: rx, rz: rz})
+			offsets, timestamps, err := readRegionTimestamps(fname)
+			if err != nil {
+				if os.IsNotExist(err) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+					continue
+				}
+				return ret, err
+			}
+			for lz := 0; lz < 32; lz++ {
+				for lx := 0; lx < 32; lx++ {
+					x, z := rx*32+lx, rz*32+lz
+					idx := lz*32 + lx
+					if x < cx0 || x >= cx1 || z < cz0 || z >= cz1 || offsets[idx] == 0 || timestamps[idx] == 0 {
+						continue
+					}
+					ret = append(ret, chunkStorage.ChunkData{X: x, Z: z, Data: time.Unix(int64(timestamps[idx]), 0)})
+				}
+			}
+		}
+	}
+	return ret, nil
+}
+
+// reads only offset tables of region files, chunks are not touched
+func (s *FilesystemChunkStorage) GetRegionsChunksCount(wname, dname string, rx0, rz0, rx1, rz1 int) ([]chunkStorage.ChunkData, error) {
+	rx0, rz0, rx1, rz1 = normalizeCoords(rx0, rz0, rx1, rz1)
+	ret := []chunkStorage.ChunkData{}
+	dirloc := s.getRegionFolder(regionLocator{
+		world:     wname,
+		dimension: dname,
+	})
+	d, err := os.ReadDir(dirloc)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ret, nil
+		}
+		return ret, err
+	}
+	for _, i := range d {
+		var rx, rz int
+		if i.IsDir() || !ExtractRegionPath(i.Name(), &rx, &rz) || rx < rx0 || rx >= rx1 || rz < rz0 || rz >= rz1 {
+			continue
+		}
+		offsets, _, err := readRegionTimestamps(path.Join(dirloc, i.Name()))
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				continue
+			}
+			return ret, err
+		}
+		c := 0
+		for _, o := range offsets {
+			if o != 0 {
+				c++
+			}
+		}
+		ret = append(ret, chunkStorage.ChunkData{X: rx, Z: rz, Data: c})
+	}
+	return ret, nil
+}
@@ -0,0 +1,301 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package chunkStorage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/maxsupermanhd/WebChunk/data/biomes"
+	"github.com/maxsupermanhd/go-vmc/v764/level"
+	"github.com/maxsupermanhd/go-vmc/v764/level/block"
+	"github.com/maxsupermanhd/go-vmc/v764/nbt"
+	"github.com/maxsupermanhd/go-vmc/v764/save"
+)
+
+// chunks saved before 1.13 (the flattening) have numeric block ids,
+// converted ones are marked with this version so they are not converted again
+const legacyDataVersion = 1451
+
+type legacySection struct {
+	Y          int8
+	Blocks     []byte
+	Add        []byte
+	Data       []byte
+	BlockLight []byte
+	SkyLight   []byte
+}
+
+type legacyChunk struct {
+	DataVersion int32
+	Level       struct {
+		XPos          int32 `nbt:"xPos"`
+		ZPos          int32 `nbt:"zPos"`
+		LastUpdate    int64
+		InhabitedTime int64
+		Biomes        []byte
+		Sections      []legacySection
+		Entities      []nbt.RawMessage
+		TileEntities  []nbt.RawMessage
+	}
+}
+
+func decompressChunk(d []byte) ([]byte, error) {
+	if len(d) == 0 {
+		return nil, errors.New("data is zero length")
+	}
+	var r io.Reader = bytes.NewReader(d[1:])
+	var err error
+	switch d[0] {
+	default:
+		return nil, errors.New("unknown compression")
+	case 1:
+		r, err = gzip.NewReader(r)
+	case 2:
+		r, err = zlib.NewReader(r)
+	case 3:
+	}
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// LoadFlexibleChunk decodes stored chunk converting pre-1.13 ones on the fly
+func LoadFlexibleChunk(c *save.Chunk, d []byte) error {
+	raw, err := decompressChunk(d)
+	if err != nil {
+		return err
+	}
+	if _, err = nbt.NewDecoder(bytes.NewReader(raw)).Decode(c); err != nil {
+		return err
+	}
+	if c.DataVersion >= legacyDataVersion {
+		return nil
+	}
+	var l legacyChunk
+	if _, err = nbt.NewDecoder(bytes.NewReader(raw)).Decode(&l); err != nil {
+		return err
+	}
+	if l.Level.Sections == nil {
+		return nil
+	}
+	*c = l.toSave()
+	return nil
+}
+
+// UpgradeLegacyChunkRaw returns chunk converted to modern format if it was
+// saved before 1.13, data is returned as is otherwise
+func UpgradeLegacyChunkRaw(d []byte) ([]byte, bool, error) {
+	raw, err := decompressChunk(d)
+	if err != nil {
+		return d, false, err
+	}
+	var l legacyChunk
+	if _, err = nbt.NewDecoder(bytes.NewReader(raw)).Decode(&l); err != nil {
+		return d, false, err
+	}
+	if l.DataVersion >= legacyDataVersion || l.Level.Sections == nil {
+		return d, false, nil
+	}
+	c := l.toSave()
+	// save.Chunk.Data does not flush the compressor
+	var buf bytes.Buffer
+	buf.WriteByte(2)
+	w := zlib.NewWriter(&buf)
+	if err = nbt.NewEncoder(w).Encode(c, ""); err != nil {
+		return d, false, err
+	}
+	if err = w.Close(); err != nil {
+		return d, false, err
+	}
+	return buf.Bytes(), true, nil
+}
+
+func (l *legacyChunk) toSave() save.Chunk {
+	emptyList := nbt.RawMessage{Type: nbt.TagList, Data: []byte{nbt.TagEnd, 0, 0, 0, 0}}
+	ret := save.Chunk{
+		DataVersion:    legacyDataVersion,
+		XPos:           l.Level.XPos,
+		ZPos:           l.Level.ZPos,
+		LastUpdate:     l.Level.LastUpdate,
+		InhabitedTime:  l.Level.InhabitedTime,
+		BlockEntities:  l.Level.TileEntities,
+		Entities:       l.Level.Entities,
+		Heightmaps:     map[string][]uint64{},
+		Status:         "full",
+		Sections:       []save.Section{},
+		BlockTicks:     emptyList,
+		FluidTicks:     emptyList,
+		PostProcessing: emptyList,
+		Structures:     nbt.RawMessage{Type: nbt.TagCompound, Data: []byte{nbt.TagEnd}},
+	}
+	if ret.BlockEntities == nil {
+		ret.BlockEntities = []nbt.RawMessage{}
+	}
+	if ret.Entities == nil {
+		ret.Entities = []nbt.RawMessage{}
+	}
+	biomes := legacyBiomes(l.Level.Biomes)
+	for i := range l.Level.Sections {
+		s := &l.Level.Sections[i]
+		if len(s.Blocks) != 16*16*16 {
+			continue
+		}
+		ret.Sections = append(ret.Sections, save.Section{
+			Y:           s.Y,
+			BlockStates: legacyStates(s),
+			Biomes:      biomes,
+			SkyLight:    s.SkyLight,
+			BlockLight:  s.BlockLight,
+		})
+	}
+	return ret
+}
+
+func legacyNibble(arr []byte, i int) int {
+	if len(arr) != 16*16*16/2 {
+		return 0
+	}
+	return int(arr[i/2]>>((i%2)*4)) & 0xf
+}
+
+var (
+	legacyStatesOnce sync.Once
+	legacyStatesList map[string]save.BlockState
+)
+
+// palette entry of the first state of the block, empty properties
+// do not make a valid state for blocks like leaves
+func legacyState(name string) save.BlockState {
+	legacyStatesOnce.Do(func() {
+		legacyStatesList = map[string]save.BlockState{}
+		var buf bytes.Buffer
+		for _, b := range block.StateList {
+			if _, ok := legacyStatesList[b.ID()]; ok {
+				continue
+			}
+			s := save.BlockState{Name: b.ID()}
+			buf.Reset()
+			if err := nbt.NewEncoder(&buf).Encode(b, ""); err == nil {
+				_, _ = nbt.NewDecoder(&buf).Decode(&s.Properties)
+			}
+			legacyStatesList[b.ID()] = s
+		}
+	})
+	s, ok := legacyStatesList["minecraft:"+name]
+	if !ok {
+		return save.BlockState{Name: "minecraft:air"}
+	}
+	return s
+}
+
+func legacyStates(s *legacySection) save.PaletteContainer[save.BlockState] {
+	ret := save.PaletteContainer[save.BlockState]{Palette: []save.BlockState{}}
+	byKey := map[int]int{}
+	byName := map[string]int{}
+	idx := make([]int, 16*16*16)
+	for i := range idx {
+		id := int(s.Blocks[i]) | legacyNibble(s.Add, i)<<8
+		key := id<<4 | legacyNibble(s.Data, i)
+		p, ok := byKey[key]
+		if !ok {
+			st := legacyState(legacyBlockName(id, key&0xf))
+			p, ok = byName[st.Name]
+			if !ok {
+				p = len(ret.Palette)
+				byName[st.Name] = p
+				ret.Palette = append(ret.Palette, st)
+			}
+			byKey[key] = p
+		}
+		idx[i] = p
+	}
+	if len(ret.Palette) == 1 {
+		return ret
+	}
+	bits := 4
+	for 1<<bits < len(ret.Palette) {
+		bits++
+	}
+	b := level.NewBitStorage(bits, len(idx), nil)
+	for i, p := range idx {
+		b.Set(i, p)
+	}
+	ret.Data = b.Raw()
+	return ret
+}
+
+// old biomes are per column, they are sampled at corners of 4x4 cells
+// and repeated for every section
+func legacyBiomes(arr []byte) save.PaletteContainer[save.BiomeState] {
+	ret := save.PaletteContainer[save.BiomeState]{Palette: []save.BiomeState{}}
+	idx := make([]int, 4*4)
+	byName := map[string]int{}
+	for i := range idx {
+		name := "plains"
+		if len(arr) == 16*16 {
+			if n, ok := legacyBiomeNames()[int(arr[(i/4)*4*16+(i%4)*4])]; ok {
+				name = n
+			}
+		}
+		p, ok := byName[name]
+		if !ok {
+			p = len(ret.Palette)
+			byName[name] = p
+			ret.Palette = append(ret.Palette, save.BiomeState("minecraft:"+name))
+		}
+		idx[i] = p
+	}
+	if len(ret.Palette) == 1 {
+		return ret
+	}
+	bits := 1
+	for 1<<bits < len(ret.Palette) {
+		bits++
+	}
+	b := level.NewBitStorage(bits, 4*4*4, nil)
+	for i := 0; i < 4*4*4; i++ {
+		b.Set(i, idx[i%16])
+	}
+	ret.Data = b.Raw()
+	return ret
+}
+
+var (
+	legacyBiomesOnce sync.Once
+	legacyBiomesList map[int]string
+)
+
+// numeric biome ids did not change until 1.13 removed them from saves
+func legacyBiomeNames() map[int]string {
+	legacyBiomesOnce.Do(func() {
+		legacyBiomesList = map[int]string{}
+		for n, id := range biomes.BiomeID {
+			legacyBiomesList[id] = n
+		}
+	})
+	return legacyBiomesList
+}
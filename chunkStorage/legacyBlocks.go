@@ -0,0 +1,126 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package chunkStorage
+
+// block names of pre-flattening numeric ids, data value picks variant
+// where it makes a difference on the map, orientation and other states are dropped
+var legacyBlockNames = [256]string{
+	0: "air", 1: "stone", 2: "grass_block", 3: "dirt", 4: "cobblestone", 5: "oak_planks", 6: "oak_sapling", 7: "bedrock",
+	8: "water", 9: "water", 10: "lava", 11: "lava", 12: "sand", 13: "gravel", 14: "gold_ore", 15: "iron_ore",
+	16: "coal_ore", 17: "oak_log", 18: "oak_leaves", 19: "sponge", 20: "glass", 21: "lapis_ore", 22: "lapis_block", 23: "dispenser",
+	24: "sandstone", 25: "note_block", 26: "red_bed", 27: "powered_rail", 28: "detector_rail", 29: "sticky_piston", 30: "cobweb", 31: "grass",
+	32: "dead_bush", 33: "piston", 34: "piston_head", 35: "white_wool", 36: "moving_piston", 37: "dandelion", 38: "poppy", 39: "brown_mushroom",
+	40: "red_mushroom", 41: "gold_block", 42: "iron_block", 43: "smooth_stone", 44: "smooth_stone_slab", 45: "bricks", 46: "tnt", 47: "bookshelf",
+	48: "mossy_cobblestone", 49: "obsidian", 50: "torch", 51: "fire", 52: "spawner", 53: "oak_stairs", 54: "chest", 55: "redstone_wire",
+	56: "diamond_ore", 57: "diamond_block", 58: "crafting_table", 59: "wheat", 60: "farmland", 61: "furnace", 62: "furnace", 63: "oak_sign",
+	64: "oak_door", 65: "ladder", 66: "rail", 67: "cobblestone_stairs", 68: "oak_wall_sign", 69: "lever", 70: "stone_pressure_plate", 71: "iron_door",
+	72: "oak_pressure_plate", 73: "redstone_ore", 74: "redstone_ore", 75: "redstone_torch", 76: "redstone_torch", 77: "stone_button", 78: "snow", 79: "ice",
+	80: "snow_block", 81: "cactus", 82: "clay", 83: "sugar_cane", 84: "jukebox", 85: "oak_fence", 86: "carved_pumpkin", 87: "netherrack",
+	88: "soul_sand", 89: "glowstone", 90: "nether_portal", 91: "jack_o_lantern", 92: "cake", 93: "repeater", 94: "repeater", 95: "white_stained_glass",
+	96: "oak_trapdoor", 97: "infested_stone", 98: "stone_bricks", 99: "brown_mushroom_block", 100: "red_mushroom_block", 101: "iron_bars", 102: "glass_pane", 103: "melon",
+	104: "pumpkin_stem", 105: "melon_stem", 106: "vine", 107: "oak_fence_gate", 108: "brick_stairs", 109: "stone_brick_stairs", 110: "mycelium", 111: "lily_pad",
+	112: "nether_bricks", 113: "nether_brick_fence", 114: "nether_brick_stairs", 115: "nether_wart", 116: "enchanting_table", 117: "brewing_stand", 118: "cauldron", 119: "end_portal",
+	120: "end_portal_frame", 121: "end_stone", 122: "dragon_egg", 123: "redstone_lamp", 124: "redstone_lamp", 125: "oak_planks", 126: "oak_slab", 127: "cocoa",
+	128: "sandstone_stairs", 129: "emerald_ore", 130: "ender_chest", 131: "tripwire_hook", 132: "tripwire", 133: "emerald_block", 134: "spruce_stairs", 135: "birch_stairs",
+	136: "jungle_stairs", 137: "command_block", 138: "beacon", 139: "cobblestone_wall", 140: "flower_pot", 141: "carrots", 142: "potatoes", 143: "oak_button",
+	144: "skeleton_skull", 145: "anvil", 146: "trapped_chest", 147: "light_weighted_pressure_plate", 148: "heavy_weighted_pressure_plate", 149: "comparator", 150: "comparator", 151: "daylight_detector",
+	152: "redstone_block", 153: "nether_quartz_ore", 154: "hopper", 155: "quartz_block", 156: "quartz_stairs", 157: "activator_rail", 158: "dropper", 159: "white_terracotta",
+	160: "white_stained_glass_pane", 161: "acacia_leaves", 162: "acacia_log", 163: "acacia_stairs", 164: "dark_oak_stairs", 165: "slime_block", 166: "barrier", 167: "iron_trapdoor",
+	168: "prismarine", 169: "sea_lantern", 170: "hay_block", 171: "white_carpet", 172: "terracotta", 173: "coal_block", 174: "packed_ice", 175: "sunflower",
+	176: "white_banner", 177: "white_wall_banner", 178: "daylight_detector", 179: "red_sandstone", 180: "red_sandstone_stairs", 181: "red_sandstone", 182: "red_sandstone_slab", 183: "spruce_fence_gate",
+	184: "birch_fence_gate", 185: "jungle_fence_gate", 186: "dark_oak_fence_gate", 187: "acacia_fence_gate", 188: "spruce_fence", 189: "birch_fence", 190: "jungle_fence", 191: "dark_oak_fence",
+	192: "acacia_fence", 193: "spruce_door", 194: "birch_door", 195: "jungle_door", 196: "acacia_door", 197: "dark_oak_door", 198: "end_rod", 199: "chorus_plant",
+	200: "chorus_flower", 201: "purpur_block", 202: "purpur_pillar", 203: "purpur_stairs", 204: "purpur_block", 205: "purpur_slab", 206: "end_stone_bricks", 207: "beetroots",
+	208: "dirt_path", 209: "end_gateway", 210: "repeating_command_block", 211: "chain_command_block", 212: "frosted_ice", 213: "magma_block", 214: "nether_wart_block", 215: "red_nether_bricks",
+	216: "bone_block", 217: "structure_void", 218: "observer", 251: "white_concrete", 252: "white_concrete_powder", 255: "structure_block",
+}
+
+var legacyColors = [16]string{
+	"white", "orange", "magenta", "light_blue", "yellow", "lime", "pink", "gray",
+	"light_gray", "cyan", "purple", "blue", "brown", "green", "red", "black",
+}
+
+var legacyWoods = [6]string{"oak", "spruce", "birch", "jungle", "acacia", "dark_oak"}
+
+// id<<4|data of variants that differ from the base block
+var legacyBlockVariants = func() map[uint16]string {
+	ret := map[uint16]string{}
+	set := func(id int, names ...string) {
+		for d, n := range names {
+			if n != "" {
+				ret[uint16(id<<4|d)] = n
+			}
+		}
+	}
+	set(1, "", "granite", "polished_granite", "diorite", "polished_diorite", "andesite", "polished_andesite")
+	set(3, "", "coarse_dirt", "podzol")
+	set(12, "", "red_sand")
+	set(17, "", "spruce_log", "birch_log", "jungle_log")
+	set(18, "", "spruce_leaves", "birch_leaves", "jungle_leaves")
+	set(19, "", "wet_sponge")
+	set(24, "", "chiseled_sandstone", "cut_sandstone")
+	set(31, "dead_bush", "grass", "fern")
+	set(38, "", "blue_orchid", "allium", "azure_bluet", "red_tulip", "orange_tulip", "white_tulip", "pink_tulip", "oxeye_daisy")
+	set(43, "", "sandstone", "oak_planks", "cobblestone", "bricks", "stone_bricks", "nether_bricks", "quartz_block")
+	set(44, "", "sandstone_slab", "petrified_oak_slab", "cobblestone_slab", "brick_slab", "stone_brick_slab", "nether_brick_slab", "quartz_slab")
+	set(98, "", "mossy_stone_bricks", "cracked_stone_bricks", "chiseled_stone_bricks")
+	set(139, "", "mossy_cobblestone_wall")
+	set(155, "", "chiseled_quartz_block", "quartz_pillar")
+	set(161, "", "dark_oak_leaves")
+	set(162, "", "dark_oak_log")
+	set(168, "", "prismarine_bricks", "dark_prismarine")
+	set(175, "", "lilac", "tall_grass", "large_fern", "rose_bush", "peony")
+	set(179, "", "chiseled_red_sandstone", "cut_red_sandstone")
+	for i, w := range legacyWoods {
+		ret[uint16(5<<4|i)] = w + "_planks"
+		ret[uint16(6<<4|i)] = w + "_sapling"
+		ret[uint16(125<<4|i)] = w + "_planks"
+		ret[uint16(126<<4|i)] = w + "_slab"
+	}
+	for i, c := range legacyColors {
+		ret[uint16(35<<4|i)] = c + "_wool"
+		ret[uint16(95<<4|i)] = c + "_stained_glass"
+		ret[uint16(159<<4|i)] = c + "_terracotta"
+		ret[uint16(160<<4|i)] = c + "_stained_glass_pane"
+		ret[uint16(171<<4|i)] = c + "_carpet"
+		ret[uint16(251<<4|i)] = c + "_concrete"
+		ret[uint16(252<<4|i)] = c + "_concrete_powder"
+		ret[uint16((219+i)<<4)] = c + "_shulker_box"
+		ret[uint16((235+i)<<4)] = c + "_glazed_terracotta"
+	}
+	return ret
+}()
+
+// data values often carry orientation in upper bits, variant is in lower ones
+func legacyBlockName(id, data int) string {
+	for _, d := range []int{data, data & 7, data & 3} {
+		if n, ok := legacyBlockVariants[uint16(id<<4|d)]; ok {
+			return n
+		}
+	}
+	if n, ok := legacyBlockVariants[uint16(id<<4)]; ok {
+		return n
+	}
+	if id >= 0 && id < len(legacyBlockNames) && legacyBlockNames[id] != "" {
+		return legacyBlockNames[id]
+	}
+	return "air"
+}
@@ -39,7 +39,7 @@ func (s *PostgresChunkStorage) GetChunk(wname, dname string, cx, cz int) (*save.
 	}
 	var c save.Chunk
 	if len(d) > 1 {
-		err = c.Load(d)
+		err = chunkStorage.LoadFlexibleChunk(&c, d)
 	} else {
 		err = errors.New("data is zero length")
 	}
@@ -75,7 +75,7 @@ func ConnGetChunkByDID(conn *pgxpool.Conn, did int, cx, cz int) (*save.Chunk, er
 	}
 	var c save.Chunk
 	if len(d) > 1 {
-		err = c.Load(d)
+		err = chunkStorage.LoadFlexibleChunk(&c, d)
 	} else {
 		err = errors.New("data is zero length")
 	}
@@ -108,7 +108,7 @@ func (s *PostgresChunkStorage) GetChunkByDID(did int, cx, cz int) (*save.Chunk,
 	}
 	var c save.Chunk
 	if len(d) > 1 {
-		err = c.Load(d)
+		err = chunkStorage.LoadFlexibleChunk(&c, d)
 	} else {
 		err = errors.New("data is zero length")
 	}
@@ -204,6 +204,38 @@ func (s *PostgresChunkStorage) GetChunksRegionRaw(wname, dname string, cx0, cz0,
 	return c, perr
 }
 
+func (s *PostgresChunkStorage) GetChunksRegionAt(wname, dname string, cx0, cz0, cx1, cz1 int, at time.Time) ([]chunkStorage.ChunkData, error) {
+	ret := []chunkStorage.ChunkData{}
+	rows, err := s.DBPool.Query(context.Background(), `
+		select distinct on (x, z) x, z, data
+		from chunks
+		where dim = (select dimensions.id from dimensions
+					 where dimensions.world = $5 and dimensions.name = $6) AND
+			  x >= $1 AND z >= $2 AND x < $3 AND z < $4 AND created_at <= $7
+		order by x, z, created_at desc`, cx0, cz0, cx1, cz1, wname, dname, at)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			err = nil
+		}
+		return ret, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var x, z int
+		var d []byte
+		if err := rows.Scan(&x, &z, &d); err != nil {
+			return ret, err
+		}
+		c, err := chunkStorage.ConvFlexibleNBTtoSave(d)
+		if err != nil {
+			log.Printf("Failed to parse chunk data (%s), chunk x%d z%d", err.Error(), x, z)
+			continue
+		}
+		ret = append(ret, chunkStorage.ChunkData{X: x, Z: z, Data: *c})
+	}
+	return ret, rows.Err()
+}
+
 func (s *PostgresChunkStorage) GetChunksCountRegion(wname, dname string, cx0, cz0, cx1, cz1 int) ([]chunkStorage.ChunkData, error) {
 	cc := []chunkStorage.ChunkData{}
 	rows, derr := s.DBPool.Query(context.Background(), `
@@ -270,3 +302,84 @@ func (s *PostgresChunkStorage) GetChunkModDate(wname, dname string, cx, cz int)
 	}
 	return &t, nil
 }
+
+func (s *PostgresChunkStorage) GetChunksModDateRegion(wname, dname string, cx0, cz0, cx1, cz1 int) ([]chunkStorage.ChunkData, error) {
+	ret := []chunkStorage.ChunkData{}
+	rows, err := s.DBPool.Query(context.Background(), `
+		select x, z, max(created_at)
+		from chunks
+		where dim = (select dimensions.id from dimensions
+					 where dimensions.world = $5 and dimensions.name = $6) AND
+			  x >= $1 AND z >= $2 AND x < $3 AND z < $4
+		group by x, z`, cx0, cz0, cx1, cz1, wname, dname)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			err = nil
+		}
+		return ret, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var x, z int
+		var t time.Time
+		if err := rows.Scan(&x, &z, &t); err != nil {
+			return ret, err
+		}
+		ret = append(ret, chunkStorage.ChunkData{X: x, Z: z, Data: t})
+	}
+	return ret, rows.Err()
+}
+
+func (s *PostgresChunkStorage) ListChunksModifiedSince(wname, dname string, since time.Time) ([]chunkStorage.ChunkData, error) {
+	ret := []chunkStorage.ChunkData{}
+	rows, err := s.DBPool.Query(context.Background(), `
+		select x, z, max(created_at)
+		from chunks
+		where dim = (select dimensions.id from dimensions
+					 where dimensions.world = $1 and dimensions.name = $2)
+		group by x, z
+		having max(created_at) >= $3`, wname, dname, since)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			err = nil
+		}
+		return ret, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var x, z int
+		var t time.Time
+		if err := rows.Scan(&x, &z, &t); err != nil {
+			return ret, err
+		}
+		ret = append(ret, chunkStorage.ChunkData{X: x, Z: z, Data: t})
+	}
+	return ret, rows.Err()
+}
+
+// shift keeps negative coordinates in the right region
+func (s *PostgresChunkStorage) GetRegionsChunksCount(wname, dname string, rx0, rz0, rx1, rz1 int) ([]chunkStorage.ChunkData, error) {
+	ret := []chunkStorage.ChunkData{}
+	rows, err := s.DBPool.Query(context.Background(), `
+		select x >> 5 as rx, z >> 5 as rz, count(distinct (x, z))
+		from chunks
+		where dim = (select dimensions.id from dimensions
+					 where dimensions.world = $5 and dimensions.name = $6) AND
+			  x >= $1 * 32 AND z >= $2 * 32 AND x < $3 * 32 AND z < $4 * 32
+		group by rx, rz`, rx0, rz0, rx1, rz1, wname, dname)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			err = nil
+		}
+		return ret, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var rx, rz, c int
+		if err := rows.Scan(&rx, &rz, &c); err != nil {
+			return ret, err
+		}
+		ret = append(ret, chunkStorage.ChunkData{X: rx, Z: rz, Data: c})
+	}
+	return ret, rows.Err()
+}
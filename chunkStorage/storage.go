@@ -47,6 +47,21 @@ type SWorld struct {
 	Data       save.LevelData
 }
 
+// provenance and usage terms of captured world, not kept by storages
+type WorldMetadata struct {
+	Description  string      `json:",omitempty"`
+	CaptureRules string      `json:",omitempty"`
+	License      string      `json:",omitempty"`
+	Attribution  string      `json:",omitempty"`
+	Links        []WorldLink `json:",omitempty"`
+	Updated      time.Time
+}
+
+type WorldLink struct {
+	Title string
+	URL   string
+}
+
 type SDim struct {
 	Name       string // unique per world
 	World      string // name of the world
@@ -101,12 +116,27 @@ type ChunkStorage interface {
 	GetChunksRegionRaw(wname, dname string, cx0, cz0, cx1, cz1 int) ([]ChunkData, error)
 	// Warning, chunk data array may be real big!
 	GetChunksCountRegion(wname, dname string, cx0, cz0, cx1, cz1 int) ([]ChunkData, error)
+	// Region coordinates, Data of returned entries is int number of chunks stored in region X:Z,
+	// regions without chunks may be left out
+	GetRegionsChunksCount(wname, dname string, rx0, rz0, rx1, rz1 int) ([]ChunkData, error)
 
 	GetChunkModDate(wname, dname string, cx, cz int) (*time.Time, error)
+	// Data of returned chunks is time.Time of the last modification,
+	// precision is up to the storage so it may return chunks modified just before since
+	ListChunksModifiedSince(wname, dname string, since time.Time) ([]ChunkData, error)
+	// Data of returned chunks is time.Time of the last modification, missing chunks are left out
+	GetChunksModDateRegion(wname, dname string, cx0, cz0, cx1, cz1 int) ([]ChunkData, error)
 
 	Close() error
 }
 
+// Implemented by storages that keep old chunks (CanPreserveOldChunks)
+type ChunkHistoryStorage interface {
+	// Chunks as they were at given time, ones first stored after it are left out
+	// Warning, chunk data array may be real big!
+	GetChunksRegionAt(wname, dname string, cx0, cz0, cx1, cz1 int, at time.Time) ([]ChunkData, error)
+}
+
 type Storage struct {
 	Type    string       `json:"type"`
 	Address string       `json:"address"`
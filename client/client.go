@@ -0,0 +1,214 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+// Package client talks to WebChunk server over its HTTP and websocket API
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maxsupermanhd/WebChunk/chunkStorage"
+)
+
+type Client struct {
+	BaseURL string
+	// set on every request, including websocket handshake
+	Headers map[string]string
+	HTTP    *http.Client
+}
+
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		Headers: map[string]string{},
+		HTTP:    &http.Client{Timeout: 5 * time.Minute},
+	}
+}
+
+// token of private mode (web.access_token)
+func (c *Client) SetAccessToken(token string) {
+	c.Headers["Authorization"] = "Bearer " + token
+}
+
+// token that lifts player name hiding (privacy.reveal_token)
+func (c *Client) SetRevealToken(token string) {
+	c.Headers["X-Reveal-Token"] = token
+}
+
+// APIError is returned for any response that is not 2xx
+type APIError struct {
+	Method  string
+	Path    string
+	Status  int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s %s: %d %s: %s", e.Method, e.Path, e.Status, http.StatusText(e.Status), e.Message)
+}
+
+func IsNotFound(err error) bool {
+	var e *APIError
+	return errors.As(err, &e) && e.Status == http.StatusNotFound
+}
+
+func pathJoin(parts ...string) string {
+	for i := range parts {
+		parts[i] = url.PathEscape(parts[i])
+	}
+	return strings.Join(parts, "/")
+}
+
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body io.Reader, contentType string) (*http.Response, error) {
+	u := c.BaseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range c.Headers {
+		req.Header.Set(k, v)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	hc := c.HTTP
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return nil, &APIError{Method: method, Path: path, Status: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	return resp, nil
+}
+
+// body is marshaled to JSON unless it is nil, response is decoded into ret unless it is nil
+func (c *Client) doJSON(ctx context.Context, method, path string, query url.Values, body any, ret any) error {
+	var rbody io.Reader
+	ctype := ""
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		rbody = bytes.NewReader(b)
+		ctype = "application/json"
+	}
+	resp, err := c.do(ctx, method, path, query, rbody, ctype)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if ret == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(ret)
+}
+
+func (c *Client) Worlds(ctx context.Context) ([]chunkStorage.SWorld, error) {
+	ret := []chunkStorage.SWorld{}
+	return ret, c.doJSON(ctx, "GET", "/api/v1/worlds", nil, nil, &ret)
+}
+
+// empty world lists dimensions of all worlds
+func (c *Client) Dimensions(ctx context.Context, world string) ([]chunkStorage.SDim, error) {
+	q := url.Values{}
+	if world != "" {
+		q.Set("world", world)
+	}
+	ret := []chunkStorage.SDim{}
+	return ret, c.doJSON(ctx, "GET", "/api/v1/dims", q, nil, &ret)
+}
+
+// returns error that IsNotFound is true for when world has no metadata
+func (c *Client) WorldMetadata(ctx context.Context, world string) (*chunkStorage.WorldMetadata, error) {
+	ret := &chunkStorage.WorldMetadata{}
+	return ret, c.doJSON(ctx, "GET", "/api/v1/worlds/"+pathJoin(world)+"/metadata", nil, nil, ret)
+}
+
+// replaces all metadata fields, returns what was stored
+func (c *Client) SetWorldMetadata(ctx context.Context, world string, m chunkStorage.WorldMetadata) (*chunkStorage.WorldMetadata, error) {
+	ret := &chunkStorage.WorldMetadata{}
+	return ret, c.doJSON(ctx, "PUT", "/api/v1/worlds/"+pathJoin(world)+"/metadata", nil, m, ret)
+}
+
+func (c *Client) Layers(ctx context.Context) ([]Layer, error) {
+	ret := []Layer{}
+	return ret, c.doJSON(ctx, "GET", "/api/v1/renderers", nil, nil, &ret)
+}
+
+// data is chunk NBT in any form server accepts, world and dimension
+// are created if they do not exist
+func (c *Client) SubmitChunk(ctx context.Context, world, dim string, data []byte) error {
+	resp, err := c.do(ctx, "POST", "/api/v1/submit/chunk/"+pathJoin(world, dim), nil, bytes.NewReader(data), "application/octet-stream")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Tile returns nil image without error when there is nothing to draw,
+// s is the scale same as in tile urls, x and z are in tiles of that scale
+func (c *Client) Tile(ctx context.Context, world, dim, layer string, s, x, z int) (image.Image, error) {
+	b, err := c.TilePNG(ctx, world, dim, layer, s, x, z)
+	if err != nil || b == nil {
+		return nil, err
+	}
+	return png.Decode(bytes.NewReader(b))
+}
+
+func (c *Client) TilePNG(ctx context.Context, world, dim, layer string, s, x, z int) ([]byte, error) {
+	path := "/worlds/" + pathJoin(world, dim) + "/tiles/" + pathJoin(layer, strconv.Itoa(s), strconv.Itoa(x), strconv.Itoa(z)) + "/png"
+	resp, err := c.do(ctx, "GET", path, nil, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+	// tile errors are rendered as html pages
+	if resp.Header.Get("Content-Type") != "image/png" {
+		return nil, &APIError{Method: "GET", Path: path, Status: resp.StatusCode, Message: "server did not answer with an image"}
+	}
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,116 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package client
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/maxsupermanhd/WebChunk/backup"
+)
+
+func (c *Client) Backups(ctx context.Context) ([]backup.IndexEntry, error) {
+	ret := []backup.IndexEntry{}
+	return ret, c.doJSON(ctx, "GET", "/api/v1/backups", nil, nil, &ret)
+}
+
+// runs backup and waits for it to finish
+func (c *Client) RunBackup(ctx context.Context) (*backup.IndexEntry, error) {
+	ret := &backup.IndexEntry{}
+	return ret, c.doJSON(ctx, "POST", "/api/v1/backups", nil, nil, ret)
+}
+
+// pulls from configured peers, empty peer syncs with all of them,
+// reports are keyed by peer name
+func (c *Client) RunSync(ctx context.Context, peer string) (map[string][]SyncDimReport, error) {
+	q := url.Values{}
+	if peer != "" {
+		q.Set("peer", peer)
+	}
+	ret := map[string][]SyncDimReport{}
+	return ret, c.doJSON(ctx, "POST", "/api/v1/sync", q, nil, &ret)
+}
+
+// chunks modified after since, zero since lists everything
+func (c *Client) SyncList(ctx context.Context, world, dim string, since time.Time, hashes bool) ([]SyncListEntry, error) {
+	q := url.Values{}
+	if !since.IsZero() {
+		q.Set("since", since.Format(time.RFC3339))
+	}
+	if !hashes {
+		q.Set("hashes", "false")
+	}
+	ret := []SyncListEntry{}
+	return ret, c.doJSON(ctx, "GET", "/api/v1/sync/"+pathJoin(world, dim)+"/chunks", q, nil, &ret)
+}
+
+// server limits how many chunks can be asked at once, chunks it does not have are left out
+func (c *Client) SyncChunks(ctx context.Context, world, dim string, want []SyncListEntry) ([]SyncChunk, error) {
+	ret := []SyncChunk{}
+	return ret, c.doJSON(ctx, "POST", "/api/v1/sync/"+pathJoin(world, dim)+"/chunks", nil, want, &ret)
+}
+
+// removes everything stored about the player, server refuses it unless it
+// has reveal token set and client gives it
+func (c *Client) PurgePlayer(ctx context.Context, player string) (*SignedPurgeReport, error) {
+	ret := &SignedPurgeReport{}
+	return ret, c.doJSON(ctx, "DELETE", "/api/v1/players/"+pathJoin(player)+"/data", nil, nil, ret)
+}
+
+// report of what PurgePlayer would remove, it is not signed
+func (c *Client) PurgePlayerDryRun(ctx context.Context, player string) (json.RawMessage, error) {
+	ret := json.RawMessage{}
+	return ret, c.doJSON(ctx, "DELETE", "/api/v1/players/"+pathJoin(player)+"/data", url.Values{"dry_run": {"true"}}, nil, &ret)
+}
+
+// key server signs purge reports with, fetch it once and pin it
+func (c *Client) PurgeKey(ctx context.Context) (ed25519.PublicKey, error) {
+	ret := struct{ PublicKey string }{}
+	if err := c.doJSON(ctx, "GET", "/api/v1/purge/key", nil, nil, &ret); err != nil {
+		return nil, err
+	}
+	key, err := hex.DecodeString(ret.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("wrong purge key size")
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// checks signature against pinned key, key inside of the report is not
+// trusted since anyone can put theirs there
+func (r SignedPurgeReport) Verify(pub ed25519.PublicKey) bool {
+	if len(pub) != ed25519.PublicKeySize {
+		return false
+	}
+	sig, err := hex.DecodeString(r.Signature)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(pub, r.Report, sig)
+}
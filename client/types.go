@@ -0,0 +1,94 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package client
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// websocket text message actions, see docs/websocket.md
+const (
+	ActionBulkPlayerUpdate    = "bulkPlayerUpdate"
+	ActionUpdateWorldsAndDims = "updateWorldsAndDims"
+	ActionUpdateLayers        = "updateLayers"
+	ActionMessage             = "message"
+	ActionTileSubscribe       = "tileSubscribe"
+	ActionTileUnsubscribe     = "tileUnsubscribe"
+	ActionResubWorldDimension = "resubWorldDimension"
+)
+
+// first byte of binary websocket messages
+const (
+	OpTileUpdate = 0x01
+)
+
+// Layer is a tile type server can render
+type Layer struct {
+	Name        string
+	DisplayName string
+	IsOverlay   bool
+	IsDefault   bool
+}
+
+// Player is a player connected through proxy or websocket,
+// Gamemode is -1 until player shows up in the tab list
+type Player struct {
+	X, Y, Z    float64
+	Yaw, Pitch float32
+	World      string
+	Dimension  string
+	LastUpdate time.Time
+	UUID       string
+	Gamemode   int32
+	Latency    int32
+}
+
+// SyncListEntry is a chunk stored on the server, Hash is only set when asked for
+type SyncListEntry struct {
+	X        int       `json:"x"`
+	Z        int       `json:"z"`
+	Modified time.Time `json:"modified"`
+	Hash     string    `json:"hash,omitempty"`
+}
+
+// SyncChunk is raw chunk as it is stored, starting with compression byte
+type SyncChunk struct {
+	X    int    `json:"x"`
+	Z    int    `json:"z"`
+	Data []byte `json:"data"`
+}
+
+type SyncDimReport struct {
+	World   string `json:"world"`
+	Dim     string `json:"dim"`
+	Listed  int    `json:"listed"`
+	Pulled  int    `json:"pulled"`
+	Skipped int    `json:"skipped"`
+	Error   string `json:"error,omitempty"`
+}
+
+// SignedPurgeReport is ed25519 signature of Report bytes, both hex encoded
+type SignedPurgeReport struct {
+	Report    json.RawMessage
+	PublicKey string
+	Signature string
+}
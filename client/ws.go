@@ -0,0 +1,164 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/maxsupermanhd/WebChunk/primitives"
+)
+
+// Event is a text message from the server, Data depends on Action
+type Event struct {
+	Action string
+	Data   json.RawMessage
+}
+
+func (e *Event) Decode(v any) error {
+	return json.Unmarshal(e.Data, v)
+}
+
+// TileUpdate is a re-rendered tile that was subscribed to, empty PNG means
+// server failed to render it
+type TileUpdate struct {
+	Location primitives.ImageLocation
+	PNG      []byte
+}
+
+// Stream is a websocket connection to the server, it is safe to send from
+// multiple goroutines but Next must be called from one
+type Stream struct {
+	conn  *websocket.Conn
+	wlock sync.Mutex
+}
+
+func (c *Client) Dial(ctx context.Context) (*Stream, error) {
+	u := c.BaseURL + "/api/v1/ws"
+	if strings.HasPrefix(u, "https://") {
+		u = "wss://" + strings.TrimPrefix(u, "https://")
+	} else {
+		u = "ws://" + strings.TrimPrefix(u, "http://")
+	}
+	h := http.Header{}
+	for k, v := range c.Headers {
+		h.Set(k, v)
+	}
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, u, h)
+	if err != nil {
+		return nil, err
+	}
+	return &Stream{conn: conn}, nil
+}
+
+func (s *Stream) Close() error {
+	s.wlock.Lock()
+	s.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	s.wlock.Unlock()
+	return s.conn.Close()
+}
+
+// Next blocks until server sends something, only one of returned values is set
+func (s *Stream) Next() (*Event, *TileUpdate, error) {
+	for {
+		t, b, err := s.conn.ReadMessage()
+		if err != nil {
+			return nil, nil, err
+		}
+		switch t {
+		case websocket.TextMessage:
+			e := &Event{}
+			return e, nil, json.Unmarshal(b, e)
+		case websocket.BinaryMessage:
+			if len(b) == 0 || b[0] != OpTileUpdate {
+				continue
+			}
+			u, err := decodeTileUpdate(b[1:])
+			return nil, u, err
+		}
+	}
+}
+
+var errShortTileUpdate = errors.New("tile update message is too short")
+
+func decodeTileUpdate(b []byte) (*TileUpdate, error) {
+	readString := func() (string, bool) {
+		if len(b) < 4 {
+			return "", false
+		}
+		l := int(binary.BigEndian.Uint32(b))
+		if len(b) < 4+l {
+			return "", false
+		}
+		s := string(b[4 : 4+l])
+		b = b[4+l:]
+		return s, true
+	}
+	u := &TileUpdate{}
+	var ok bool
+	if u.Location.World, ok = readString(); !ok {
+		return nil, errShortTileUpdate
+	}
+	if u.Location.Dimension, ok = readString(); !ok {
+		return nil, errShortTileUpdate
+	}
+	if u.Location.Variant, ok = readString(); !ok {
+		return nil, errShortTileUpdate
+	}
+	if len(b) < 9 {
+		return nil, errShortTileUpdate
+	}
+	u.Location.S = int(b[0])
+	u.Location.X = int(int32(binary.BigEndian.Uint32(b[1:])))
+	u.Location.Z = int(int32(binary.BigEndian.Uint32(b[5:])))
+	u.PNG = b[9:]
+	return u, nil
+}
+
+func (s *Stream) send(action string, data any) error {
+	b, err := json.Marshal(map[string]any{"Action": action, "Data": data})
+	if err != nil {
+		return err
+	}
+	s.wlock.Lock()
+	defer s.wlock.Unlock()
+	return s.conn.WriteMessage(websocket.TextMessage, b)
+}
+
+// server sends tile right away and then every time it is re-rendered
+func (s *Stream) SubscribeTile(loc primitives.ImageLocation) error {
+	return s.send(ActionTileSubscribe, loc)
+}
+
+func (s *Stream) UnsubscribeTile(loc primitives.ImageLocation) error {
+	return s.send(ActionTileUnsubscribe, loc)
+}
+
+// moves all subscribed tiles to another world and dimension
+func (s *Stream) ResubscribeWorldDimension(world, dim string) error {
+	return s.send(ActionResubWorldDimension, map[string]string{"World": world, "Dimension": dim})
+}
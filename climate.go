@@ -0,0 +1,61 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"image"
+	"time"
+
+	"github.com/maxsupermanhd/WebChunk/data/biomes"
+	"github.com/maxsupermanhd/go-vmc/v764/save"
+)
+
+// biome temperature or downfall of the surface, temperature below 0.15
+// is where it snows, downfall of 0 is where it never rains
+func drawChunkClimate(chunk *save.Chunk, layer string, defPalette string, value func(biomes.Climate) float64) (img *image.RGBA) {
+	t := time.Now()
+	img = image.NewRGBA(image.Rect(0, 0, 16, 16))
+	p, ok := getHeatPalette(cfg.GetDSString(defPalette, "layers", layer, "palette"))
+	if !ok {
+		p, _ = getHeatPalette(defPalette)
+	}
+	for i, id := range surfaceBiomes(chunk) {
+		if id < 0 || id >= len(biomes.ClimatesByID) {
+			continue
+		}
+		img.SetRGBA(i%16, i/16, heatPaletteAt(p, value(biomes.ClimatesByID[id])))
+	}
+	appendMetrics(time.Since(t), layer)
+	return img
+}
+
+// vanilla temperatures go from -0.7 to 2
+func drawChunkTemperature(chunk *save.Chunk) *image.RGBA {
+	return drawChunkClimate(chunk, "temperature", "heat", func(c biomes.Climate) float64 {
+		return (c.Temperature + 0.7) / 2.7
+	})
+}
+
+func drawChunkHumidity(chunk *save.Chunk) *image.RGBA {
+	return drawChunkClimate(chunk, "humidity", "viridis", func(c biomes.Climate) float64 {
+		return c.Downfall
+	})
+}
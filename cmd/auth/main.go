@@ -31,7 +31,7 @@ import (
 
 var (
 	out = flag.String("out", "./", "Where to write retrieved credentials, will be written as \"username.json\"")
-	cid = flag.String("cid", "88650e7e-efee-4857-b9a9-cf580a00ef43", "Azure AppID")
+	cid = flag.String("cid", credentials.DefaultAppID, "Azure AppID")
 )
 
 func main() {
@@ -46,29 +46,11 @@ func main() {
 		Minecraft:     gmma.MCauth{},
 		MinecraftUUID: "",
 	}
-	log.Println("Getting XBOX Live token...")
-	XBLa, err := gmma.AuthXBL(s.Microsoft.AccessToken)
+	log.Println("Getting Minecraft token and profile...")
+	resauth, err := credentials.LoginMinecraft(s)
 	if err != nil {
 		log.Fatal(err)
 	}
-	log.Println("Getting XSTS token...")
-	XSTSa, err := gmma.AuthXSTS(XBLa)
-	if err != nil {
-		log.Fatal(err)
-	}
-	log.Println("Getting Minecraft token...")
-	MCa, err := gmma.AuthMC(XSTSa)
-	if err != nil {
-		log.Fatal(err)
-	}
-	s.Minecraft = MCa
-	log.Println("Getting Minecraft profile...")
-	resauth, err := gmma.GetMCprofile(MCa.Token)
-	if err != nil {
-		log.Fatal(err)
-	}
-	resauth.AsTk = MCa.Token
-	s.MinecraftUUID = resauth.UUID
 	err = credentials.WriteCredentials(path.Join(*out, resauth.Name+".json"), s)
 	if err != nil {
 		log.Fatal(err)
@@ -23,13 +23,19 @@ package main
 import (
 	"bytes"
 	"encoding/gob"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"image/color"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 
+	"github.com/maxsupermanhd/WebChunk/data/biomes"
 	"github.com/maxsupermanhd/go-vmc/v764/level/block"
 )
 
@@ -67,17 +73,18 @@ func colorsHandlerGET(w http.ResponseWriter, r *http.Request) {
 		Color            string
 	}
 	c := map[int]BlockColor{}
+	palette := colors.Get()
 	for i, b := range block.StateList {
 		if i < offset {
 			continue
 		}
-		if i > offset+count {
+		if i > offset+count || i >= len(palette) {
 			continue
 		}
 		s := BlockColor{
 			b.ID(),
 			fmt.Sprintf("%##v", b),
-			hexColor(colors[uint32(i)])}
+			hexColor(palette[i])}
 		c[i] = s
 	}
 	templateRespond("colors", w, r, map[string]interface{}{"Colors": c, "Offset": offset, "Count": count})
@@ -109,10 +116,6 @@ func colorsHandlerPOST(w http.ResponseWriter, r *http.Request) {
 		plainmsg(w, r, plainmsgColorRed, "Failed to parse colorid: "+err.Error())
 		return
 	}
-	if colorid < 0 || colorid > len(colors) {
-		plainmsg(w, r, plainmsgColorRed, "Bad colorid in request")
-		return
-	}
 	colorvalue := r.PostFormValue("colorvalue")
 	if colorvalue == "" {
 		plainmsg(w, r, plainmsgColorRed, "No colorvalue in request")
@@ -123,12 +126,52 @@ func colorsHandlerPOST(w http.ResponseWriter, r *http.Request) {
 		plainmsg(w, r, plainmsgColorRed, "Failed to parse colorvalue")
 		return
 	}
-	colors[uint32(colorid)] = newColor
+	if !colors.SetColor(colorid, newColor) {
+		plainmsg(w, r, plainmsgColorRed, "Bad colorid in request")
+		return
+	}
 	plainmsg(w, r, plainmsgColorGreen, fmt.Sprint("Color ", colorid, " was changed to ", newColor))
 	colorsHandlerGET(w, r)
 }
 
-var colors []color.RGBA64
+// block colors by state id, painters take the whole palette once per chunk
+// and edits swap in an updated copy so they never see it half written,
+// chunks painted with the old one are dropped from memo
+type colorPalette struct {
+	lock sync.Mutex
+	p    atomic.Pointer[[]color.RGBA64]
+}
+
+var colors colorPalette
+
+func (c *colorPalette) Get() []color.RGBA64 {
+	p := c.p.Load()
+	if p == nil {
+		return nil
+	}
+	return *p
+}
+
+func (c *colorPalette) Set(p []color.RGBA64) {
+	c.lock.Lock()
+	c.p.Store(&p)
+	c.lock.Unlock()
+	flushChunkMemo()
+}
+
+func (c *colorPalette) SetColor(i int, v color.RGBA64) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	old := c.Get()
+	if i < 0 || i >= len(old) {
+		return false
+	}
+	p := append([]color.RGBA64{}, old...)
+	p[i] = v
+	c.p.Store(&p)
+	flushChunkMemo()
+	return true
+}
 
 func colorsSaveHandler(w http.ResponseWriter, r *http.Request) {
 	f, err := os.Create(cfg.GetDSString("./colors.gob", "colors_path"))
@@ -137,7 +180,12 @@ func colorsSaveHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer f.Close()
-	if err := gob.NewEncoder(f).Encode(colors); err != nil {
+	// registry blocks get their colors from registry files, only compiled states are saved
+	p := colors.Get()
+	if len(p) > len(block.StateList) {
+		p = p[:len(block.StateList)]
+	}
+	if err := gob.NewEncoder(f).Encode(p); err != nil {
 		plainmsg(w, r, plainmsgColorRed, "Error saving color palette to disk: "+err.Error())
 		return
 	}
@@ -150,5 +198,43 @@ func loadColors(path string) error {
 	if err != nil {
 		return err
 	}
-	return gob.NewDecoder(bytes.NewReader(b)).Decode(&colors)
+	p := []color.RGBA64{}
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&p); err != nil {
+		return err
+	}
+	colors.Set(withRegistryColors(p))
+	return nil
+}
+
+// biome colors by biome id, generated ones with overrides from palette file
+var biomeColors = append([]color.RGBA{}, biomes.BiomeColors...)
+
+// palette file is JSON object of biome names and #rrggbb colors, missing file keeps defaults
+func loadBiomeColors(path string) error {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	palette := map[string]string{}
+	if err := json.Unmarshal(b, &palette); err != nil {
+		return err
+	}
+	for name, hex := range palette {
+		id, ok := biomes.BiomeID[strings.TrimPrefix(name, "minecraft:")]
+		if !ok || id < 0 || id >= len(biomeColors) {
+			log.Printf("Biome palette has unknown biome [%s]", name)
+			continue
+		}
+		c := color.RGBA{A: 255}
+		if _, err := fmt.Sscanf(hex, "#%02x%02x%02x", &c.R, &c.G, &c.B); err != nil {
+			log.Printf("Biome palette has bad color [%s] for [%s]", hex, name)
+			continue
+		}
+		biomeColors[id] = c
+	}
+	log.Printf("Loaded %d biome colors from palette", len(palette))
+	return nil
 }
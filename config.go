@@ -21,6 +21,8 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"net/http"
 	"os"
 
@@ -35,6 +37,7 @@ func saveConfig() error {
 	if path == "" {
 		path = "config.json"
 	}
+	flushChunkMemo()
 	return cfg.ToFileIndentJSON(path, 0644)
 }
 
@@ -46,13 +49,87 @@ func loadConfig() error {
 	return cfg.SetFromFileJSON(path)
 }
 
+// values that let someone in or forge something are not shown on /cfg,
+// keys with these names are hidden anywhere in the tree
+var cfgSecretKeys = map[string]bool{
+	"secret":       true,
+	"reveal_token": true,
+	"signing_key":  true,
+	"access_token": true,
+	"access_key":   true,
+	"secret_key":   true,
+	"password":     true,
+	"token":        true,
+}
+
+// and these are hidden as a whole, * matches any key
+var cfgSecretPaths = [][]string{
+	{"annotations", "editors"},
+	{"imageCache", "redis", "url"},
+	{"storages", "*", "address"},
+	{"sync", "peers", "*", "headers"},
+}
+
+func cfgSecretPath(path []string) bool {
+	for _, p := range cfgSecretPaths {
+		if len(p) != len(path) {
+			continue
+		}
+		match := true
+		for i := range p {
+			if p[i] != "*" && p[i] != path[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+func redactConfig(v any, path []string) any {
+	switch v := v.(type) {
+	case map[string]any:
+		ret := make(map[string]any, len(v))
+		for k, e := range v {
+			p := append(path[:len(path):len(path)], k)
+			if cfgSecretKeys[k] || cfgSecretPath(p) {
+				ret[k] = "<redacted>"
+			} else {
+				ret[k] = redactConfig(e, p)
+			}
+		}
+		return ret
+	case []any:
+		ret := make([]any, len(v))
+		for i, e := range v {
+			ret[i] = redactConfig(e, path)
+		}
+		return ret
+	}
+	return v
+}
+
 func cfgHandler(w http.ResponseWriter, r *http.Request) {
-	b, err := cfg.ToBytesIndentJSON()
+	var tree any
+	buf := bytes.Buffer{}
+	b, err := cfg.ToBytesJSON()
+	if err == nil {
+		err = json.Unmarshal(b, &tree)
+	}
+	if err == nil {
+		e := json.NewEncoder(&buf)
+		e.SetEscapeHTML(false)
+		e.SetIndent("", "\t")
+		err = e.Encode(redactConfig(tree, nil))
+	}
 	if err != nil {
 		templateRespond("plainmsg", w, r, map[string]any{"msg": err.Error()})
 		return
 	}
-	templateRespond("cfg", w, r, map[string]any{"cfg": string(b)})
+	templateRespond("cfg", w, r, map[string]any{"cfg": buf.String()})
 }
 
 func apiSaveConfig(_ http.ResponseWriter, _ *http.Request) (int, string) {
@@ -0,0 +1,58 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/maxsupermanhd/WebChunk/proxy"
+	"github.com/maxsupermanhd/go-vmc/v764/data/item"
+)
+
+type containerRecord struct {
+	Time    time.Time
+	Player  string
+	X, Y, Z int
+	Items   map[string]int
+}
+
+func containerSampled(e *proxy.ProxiedEvent, d proxy.EventContainerContents) {
+	rec := containerRecord{
+		Time:   e.Time,
+		Player: e.Username,
+		X:      d.X,
+		Y:      d.Y,
+		Z:      d.Z,
+		Items:  map[string]int{},
+	}
+	for id, count := range d.Items {
+		name := "unknown"
+		if it, ok := item.ByID[item.ID(id)]; ok {
+			name = it.Name
+		}
+		rec.Items[name] += count
+	}
+	if err := recs.Append(e.Server, strings.TrimPrefix(e.Dimension, "minecraft:"), "containers", rec); err != nil {
+		log.Printf("Failed to record container contents: %s", err.Error())
+	}
+}
@@ -0,0 +1,66 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"time"
+)
+
+// line is drawn on the higher side of every step over a multiple of
+// interval, every fifth line is drawn twice as opaque
+func drawChunkContours(chunkContext ContextedChunkData) (img *image.RGBA) {
+	t := time.Now()
+	img = image.NewRGBA(image.Rect(0, 0, 16, 16))
+	interval := maxInt(cfg.GetDSInt(8, "layers", "contours", "interval"), 1)
+	c, err := ParseHexColor(cfg.GetDSString("#40200080", "layers", "contours", "color"))
+	if err != nil {
+		c, _ = ParseHexColor("#40200080")
+	}
+	line := color.RGBA{uint8(c.R >> 8), uint8(c.G >> 8), uint8(c.B >> 8), uint8(c.A >> 8)}
+	major := line
+	major.A = uint8(minInt(int(line.A)*2, 255))
+	h := contextHeights(chunkContext, 1)
+	level := func(x, z int) int {
+		return floorDiv(h.at(x, z), interval)
+	}
+	for z := 0; z < 16; z++ {
+		for x := 0; x < 16; x++ {
+			l := level(x, z)
+			lowest := l
+			for _, d := range [][2]int{{-1, 0}, {1, 0}, {0, -1}, {0, 1}} {
+				lowest = minInt(lowest, level(x+d[0], z+d[1]))
+			}
+			if lowest == l {
+				continue
+			}
+			// any multiple of 5 between neighbour and this column
+			if floorDiv(l, 5) != floorDiv(lowest, 5) {
+				img.SetRGBA(x, z, major)
+			} else {
+				img.SetRGBA(x, z, line)
+			}
+		}
+	}
+	appendMetrics(time.Since(t), "contours")
+	return img
+}
@@ -0,0 +1,247 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/maxsupermanhd/WebChunk/chunkStorage"
+	"github.com/maxsupermanhd/lac"
+)
+
+const (
+	coordModeBlock  = "block"
+	coordModeChunk  = "chunk"
+	coordModeRegion = "region"
+	// position in the dimension connected with nether portals
+	coordModePortal = "portal"
+)
+
+// how coordinates are shown for a dimension, Scale is the dimension
+// coordinate scale, Portal is the label of the translated coordinates
+type coordDisplay struct {
+	Modes   []string `json:"modes"`
+	Default string   `json:"default"`
+	Scale   float64  `json:"scale"`
+	Portal  string   `json:"portal,omitempty"`
+}
+
+type coordPoint struct {
+	Mode  string  `json:"mode"`
+	Label string  `json:"label"`
+	X     float64 `json:"x"`
+	Z     float64 `json:"z"`
+	Text  string  `json:"text"`
+}
+
+// nether scales coordinates by 8 when leaving it, overworld-like dimensions
+// with scale 1 translate the other way, the end has no portal counterpart
+func dimCoordDisplay(wname string, dim chunkStorage.SDim) coordDisplay {
+	ret := coordDisplay{
+		Modes:   []string{coordModeBlock, coordModeChunk, coordModeRegion},
+		Default: coordModeBlock,
+		Scale:   dim.Data.CoordinatesScale,
+	}
+	if ret.Scale <= 0 {
+		ret.Scale = 1
+	}
+	if ret.Scale != 1 {
+		ret.Portal = "Overworld"
+	} else if dim.Data.Natural {
+		ret.Portal = "Nether"
+	}
+	if ret.Portal != "" {
+		ret.Modes = append(ret.Modes, coordModePortal)
+	}
+	conf := coordDisplay{}
+	err := cfg.GetToStruct(&conf, "coordinates", wname, dim.Name)
+	if errors.Is(err, lac.ErrNoKey) {
+		err = cfg.GetToStruct(&conf, "coordinates", "default")
+	}
+	if err != nil && !errors.Is(err, lac.ErrNoKey) {
+		log.Printf("Failed to parse coordinate display for [%s] [%s]: %s", wname, dim.Name, err.Error())
+	}
+	if len(conf.Modes) > 0 {
+		modes := []string{}
+		for _, m := range conf.Modes {
+			if containsString(ret.Modes, m) {
+				modes = append(modes, m)
+			}
+		}
+		if len(modes) > 0 {
+			ret.Modes = modes
+		}
+	}
+	ret.Default = ret.Modes[0]
+	if containsString(ret.Modes, conf.Default) {
+		ret.Default = conf.Default
+	}
+	return ret
+}
+
+// block coordinates to the given notation, chunk and region ones are
+// whole numbers, portal ones are block coordinates of the other side
+func (cd coordDisplay) convert(mode string, x, z float64) (coordPoint, error) {
+	p := coordPoint{Mode: mode}
+	switch mode {
+	case coordModeBlock:
+		p.Label = "Block"
+		p.X, p.Z = math.Floor(x), math.Floor(z)
+	case coordModeChunk:
+		p.Label = "Chunk"
+		p.X, p.Z = math.Floor(x/16), math.Floor(z/16)
+	case coordModeRegion:
+		p.Label = "Region"
+		p.X, p.Z = math.Floor(x/512), math.Floor(z/512)
+	case coordModePortal:
+		if cd.Portal == "" {
+			return p, fmt.Errorf("dimension has no portal counterpart")
+		}
+		p.Label = cd.Portal
+		scale := cd.Scale
+		if scale == 1 {
+			scale = 1.0 / 8
+		}
+		p.X, p.Z = math.Floor(x*scale), math.Floor(z*scale)
+	default:
+		return p, fmt.Errorf("unknown coordinate mode %q", mode)
+	}
+	p.Text = fmt.Sprintf("%s %d %d", p.Label, int64(p.X), int64(p.Z))
+	if mode == coordModeRegion {
+		p.Text += fmt.Sprintf(" (r.%d.%d.mca)", int64(p.X), int64(p.Z))
+	}
+	return p, nil
+}
+
+func (cd coordDisplay) convertAll(x, z float64) []coordPoint {
+	ret := []coordPoint{}
+	for _, m := range cd.Modes {
+		if p, err := cd.convert(m, x, z); err == nil {
+			ret = append(ret, p)
+		}
+	}
+	return ret
+}
+
+func lookupDim(wname, dname string) (*chunkStorage.SDim, int, error) {
+	_, s, err := storages.World(wname)
+	if err != nil {
+		return nil, 500, err
+	}
+	if s == nil {
+		return nil, 404, chunkStorage.ErrNoWorld
+	}
+	dim, err := s.GetDimension(wname, dname)
+	if err != nil && !errors.Is(err, chunkStorage.ErrNoDim) {
+		return nil, 500, err
+	}
+	if dim == nil {
+		return nil, 404, chunkStorage.ErrNoDim
+	}
+	return dim, 200, nil
+}
+
+type mapLayerDescriptor struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	Overlay     bool   `json:"overlay"`
+	Default     bool   `json:"default"`
+}
+
+// everything frontend needs to set up the map of a dimension
+type mapDescriptor struct {
+	World       string               `json:"world"`
+	Dim         string               `json:"dim"`
+	MinY        int32                `json:"min_y"`
+	Height      int32                `json:"height"`
+	Tiles       string               `json:"tiles"`
+	Layers      []mapLayerDescriptor `json:"layers"`
+	Coordinates coordDisplay         `json:"coordinates"`
+	View        mapView              `json:"view"`
+}
+
+func apiMapDescriptor(w http.ResponseWriter, r *http.Request) (int, string) {
+	params := mux.Vars(r)
+	wname, dname := params["world"], params["dim"]
+	dim, code, err := lookupDim(wname, dname)
+	if err != nil {
+		return code, err.Error()
+	}
+	ret := mapDescriptor{
+		World:       wname,
+		Dim:         dname,
+		MinY:        dim.Data.MinY,
+		Height:      dim.Data.Height,
+		Tiles:       "/worlds/" + wname + "/" + dname + "/tiles/{layer}/{z}/{x}/{y}/png",
+		Layers:      []mapLayerDescriptor{},
+		Coordinates: dimCoordDisplay(wname, *dim),
+		View:        dimMapView(wname, dname),
+	}
+	for t := range ttypes.All() {
+		if !layerAllowed(r, wname, dname, t.Name) {
+			continue
+		}
+		ret.Layers = append(ret.Layers, mapLayerDescriptor{
+			Name:        t.Name,
+			DisplayName: t.DisplayName,
+			Overlay:     t.IsOverlay,
+			Default:     t.IsDefault,
+		})
+	}
+	sort.Slice(ret.Layers, func(i, j int) bool { return ret.Layers[i].Name < ret.Layers[j].Name })
+	setContentTypeJson(w)
+	return marshalOrFail(200, ret)
+}
+
+// x and z are block coordinates, mode narrows output to one notation
+func apiConvertCoords(w http.ResponseWriter, r *http.Request) (int, string) {
+	params := mux.Vars(r)
+	dim, code, err := lookupDim(params["world"], params["dim"])
+	if err != nil {
+		return code, err.Error()
+	}
+	x, err := strconv.ParseFloat(r.FormValue("x"), 64)
+	if err != nil {
+		return 400, "Bad x: " + err.Error()
+	}
+	z, err := strconv.ParseFloat(r.FormValue("z"), 64)
+	if err != nil {
+		return 400, "Bad z: " + err.Error()
+	}
+	cd := dimCoordDisplay(params["world"], *dim)
+	setContentTypeJson(w)
+	if mode := strings.TrimSpace(r.FormValue("mode")); mode != "" {
+		p, err := cd.convert(mode, x, z)
+		if err != nil {
+			return 400, err.Error()
+		}
+		return marshalOrFail(200, p)
+	}
+	return marshalOrFail(200, cd.convertAll(x, z))
+}
@@ -24,11 +24,15 @@ import (
 	"encoding/json"
 	"os"
 	"path"
+	"sync"
 	"time"
 
 	gmma "github.com/maxsupermanhd/go-mc-ms-auth"
 )
 
+// DefaultAppID is Azure application used when none is configured
+const DefaultAppID = "88650e7e-efee-4857-b9a9-cf580a00ef43"
+
 type MicrosoftCredentialsManager struct {
 	Root  string
 	AppID string
@@ -66,41 +70,63 @@ func WriteCredentials(path string, cred *StoredMicrosoftCredentials) error {
 	return os.WriteFile(path, filebytes, 0600)
 }
 
+// refreshes of the same account from proxy and bots should not race
+var refreshLock sync.Mutex
+
 func (c *MicrosoftCredentialsManager) GetAuthForUsername(username string) (*gmma.BotAuth, error) {
+	return c.getAuth(username, false)
+}
+
+// RefreshAuth gets new Minecraft token even if stored one did not expire yet
+func (c *MicrosoftCredentialsManager) RefreshAuth(username string) (*gmma.BotAuth, error) {
+	return c.getAuth(username, true)
+}
+
+func (c *MicrosoftCredentialsManager) getAuth(username string, force bool) (*gmma.BotAuth, error) {
+	refreshLock.Lock()
+	defer refreshLock.Unlock()
 	s, err := ReadCredentials(c.GetFilePath(username))
 	if err != nil {
 		return nil, err
 	}
-	if s.Minecraft.ExpiresAfter-3 < time.Now().Unix() {
-		err = gmma.CheckRefreshMS(&s.Microsoft, c.AppID)
-		if err != nil {
-			return nil, err
-		}
-		XBLa, err := gmma.AuthXBL(s.Microsoft.AccessToken)
-		if err != nil {
-			return nil, err
-		}
-		XSTSa, err := gmma.AuthXSTS(XBLa)
-		if err != nil {
-			return nil, err
-		}
-		MCa, err := gmma.AuthMC(XSTSa)
-		if err != nil {
-			return nil, err
-		}
-		s.Minecraft = MCa
-		resauth, err := gmma.GetMCprofile(MCa.Token)
-		if err != nil {
-			return nil, err
-		}
-		resauth.AsTk = MCa.Token
-		s.MinecraftUUID = resauth.UUID
-		return &resauth, WriteCredentials(c.GetFilePath(resauth.Name), s)
-	} else {
+	if !force && s.Minecraft.ExpiresAfter-3 >= time.Now().Unix() {
 		return &gmma.BotAuth{
 			Name: username,
 			UUID: s.MinecraftUUID,
 			AsTk: s.Minecraft.Token,
 		}, nil
 	}
+	err = gmma.CheckRefreshMS(&s.Microsoft, c.AppID)
+	if err != nil {
+		return nil, err
+	}
+	resauth, err := LoginMinecraft(s)
+	if err != nil {
+		return nil, err
+	}
+	return resauth, WriteCredentials(c.GetFilePath(resauth.Name), s)
+}
+
+// LoginMinecraft goes from Microsoft token to Minecraft one, filling in s
+func LoginMinecraft(s *StoredMicrosoftCredentials) (*gmma.BotAuth, error) {
+	XBLa, err := gmma.AuthXBL(s.Microsoft.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+	XSTSa, err := gmma.AuthXSTS(XBLa)
+	if err != nil {
+		return nil, err
+	}
+	MCa, err := gmma.AuthMC(XSTSa)
+	if err != nil {
+		return nil, err
+	}
+	s.Minecraft = MCa
+	resauth, err := gmma.GetMCprofile(MCa.Token)
+	if err != nil {
+		return nil, err
+	}
+	resauth.AsTk = MCa.Token
+	s.MinecraftUUID = resauth.UUID
+	return &resauth, nil
 }
@@ -0,0 +1,232 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package credentials
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var usernameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_]{1,16}$`)
+
+// ValidUsername tells if name can be used as account file name
+func ValidUsername(name string) bool {
+	return usernameRegexp.MatchString(name)
+}
+
+// Account is what is safe to show about stored credentials, no tokens
+type Account struct {
+	Name         string
+	UUID         string
+	TokenExpires time.Time
+	CanRefresh   bool
+	ModifiedAt   time.Time
+	ReadError    string `json:",omitempty"`
+}
+
+func (c *MicrosoftCredentialsManager) ListAccounts() ([]Account, error) {
+	entries, err := os.ReadDir(c.Root)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Account{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ret := []Account{}
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".json")
+		if e.IsDir() || !ok || !ValidUsername(name) {
+			continue
+		}
+		a := Account{Name: name}
+		if i, err := e.Info(); err == nil {
+			a.ModifiedAt = i.ModTime()
+		}
+		s, err := ReadCredentials(c.GetFilePath(name))
+		if err != nil {
+			a.ReadError = err.Error()
+		} else {
+			a.UUID = s.MinecraftUUID
+			a.TokenExpires = time.Unix(s.Minecraft.ExpiresAfter, 0)
+			a.CanRefresh = s.Microsoft.RefreshToken != ""
+		}
+		ret = append(ret, a)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret, nil
+}
+
+func (c *MicrosoftCredentialsManager) RemoveAccount(username string) error {
+	if !ValidUsername(username) {
+		return errors.New("invalid username")
+	}
+	refreshLock.Lock()
+	defer refreshLock.Unlock()
+	return os.Remove(c.GetFilePath(username))
+}
+
+// DeviceLogin is Microsoft device code flow in progress, user has to open
+// VerificationURI and enter UserCode, account is saved once they do
+type DeviceLogin struct {
+	UserCode        string
+	VerificationURI string
+	Message         string
+	ExpiresAt       time.Time
+	Done            bool
+	Account         string `json:",omitempty"`
+	Error           string `json:",omitempty"`
+}
+
+// logins are kept by user code for a while after they are done so result can be seen
+var (
+	deviceLogins     = map[string]*DeviceLogin{}
+	deviceLoginsLock sync.Mutex
+)
+
+const deviceLoginKeep = time.Hour
+
+func GetDeviceLogin(code string) (DeviceLogin, bool) {
+	deviceLoginsLock.Lock()
+	defer deviceLoginsLock.Unlock()
+	l, ok := deviceLogins[code]
+	if !ok {
+		return DeviceLogin{}, false
+	}
+	return *l, true
+}
+
+func finishDeviceLogin(code, account string, err error) {
+	deviceLoginsLock.Lock()
+	defer deviceLoginsLock.Unlock()
+	l := deviceLogins[code]
+	l.Done = true
+	l.Account = account
+	if err != nil {
+		l.Error = err.Error()
+	}
+}
+
+func postMicrosoft(u string, v url.Values) (int, map[string]any, error) {
+	resp, err := http.PostForm(u, v)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	ret := map[string]any{}
+	err = json.NewDecoder(resp.Body).Decode(&ret)
+	return resp.StatusCode, ret, err
+}
+
+// StartDeviceLogin asks Microsoft for a device code and returns it right away,
+// token is polled for in background, same as cmd/auth does it but without blocking
+func (c *MicrosoftCredentialsManager) StartDeviceLogin() (DeviceLogin, error) {
+	code, res, err := postMicrosoft("https://login.microsoftonline.com/consumers/oauth2/v2.0/devicecode", url.Values{
+		"client_id": {c.AppID},
+		"scope":     {"XboxLive.signin offline_access"},
+	})
+	if err != nil {
+		return DeviceLogin{}, err
+	}
+	if code != 200 {
+		return DeviceLogin{}, fmt.Errorf("device code request answered %d: %v", code, res["error_description"])
+	}
+	deviceCode, _ := res["device_code"].(string)
+	expiresIn, _ := res["expires_in"].(float64)
+	interval, _ := res["interval"].(float64)
+	l := &DeviceLogin{ExpiresAt: time.Now().Add(time.Duration(expiresIn) * time.Second)}
+	l.UserCode, _ = res["user_code"].(string)
+	l.VerificationURI, _ = res["verification_uri"].(string)
+	l.Message, _ = res["message"].(string)
+	if deviceCode == "" || l.UserCode == "" {
+		return DeviceLogin{}, errors.New("device code not found in response")
+	}
+	deviceLoginsLock.Lock()
+	for k, v := range deviceLogins {
+		if time.Since(v.ExpiresAt) > deviceLoginKeep {
+			delete(deviceLogins, k)
+		}
+	}
+	deviceLogins[l.UserCode] = l
+	ret := *l
+	deviceLoginsLock.Unlock()
+	go func() {
+		name, err := c.pollDeviceLogin(deviceCode, time.Duration(interval+1)*time.Second, l.ExpiresAt)
+		if err != nil {
+			log.Printf("Device login %s failed: %s", ret.UserCode, err.Error())
+		} else {
+			log.Printf("Device login %s added account %s", ret.UserCode, name)
+		}
+		finishDeviceLogin(ret.UserCode, name, err)
+	}()
+	return ret, nil
+}
+
+func (c *MicrosoftCredentialsManager) pollDeviceLogin(deviceCode string, interval time.Duration, deadline time.Time) (string, error) {
+	for time.Now().Before(deadline) {
+		time.Sleep(interval)
+		code, res, err := postMicrosoft("https://login.microsoftonline.com/consumers/oauth2/v2.0/token", url.Values{
+			"client_id":   {c.AppID},
+			"scope":       {"XboxLive.signin offline_access"},
+			"grant_type":  {"urn:ietf:params:oauth:grant-type:device_code"},
+			"device_code": {deviceCode},
+		})
+		if err != nil {
+			return "", err
+		}
+		if code != 200 {
+			switch res["error"] {
+			case "authorization_pending":
+				continue
+			case "slow_down":
+				interval += 5 * time.Second
+				continue
+			}
+			return "", fmt.Errorf("token request answered %d: %v", code, res["error_description"])
+		}
+		s := &StoredMicrosoftCredentials{}
+		s.Microsoft.AccessToken, _ = res["access_token"].(string)
+		s.Microsoft.RefreshToken, _ = res["refresh_token"].(string)
+		expiresIn, _ := res["expires_in"].(float64)
+		s.Microsoft.ExpiresAfter = time.Now().Unix() + int64(expiresIn)
+		auth, err := LoginMinecraft(s)
+		if err != nil {
+			return "", err
+		}
+		if err := os.MkdirAll(c.Root, 0700); err != nil {
+			return "", err
+		}
+		refreshLock.Lock()
+		err = WriteCredentials(c.GetFilePath(auth.Name), s)
+		refreshLock.Unlock()
+		return auth.Name, err
+	}
+	return "", errors.New("device code expired before user authorized it")
+}
@@ -0,0 +1,134 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maxsupermanhd/go-vmc/v764/save"
+)
+
+// age at which crop can be harvested
+var cropMaxAge = map[string]int{
+	"wheat":            7,
+	"carrots":          7,
+	"potatoes":         7,
+	"beetroots":        3,
+	"nether_wart":      3,
+	"melon_stem":       7,
+	"pumpkin_stem":     7,
+	"cocoa":            2,
+	"sweet_berry_bush": 3,
+	"torchflower_crop": 2,
+	"pitcher_crop":     4,
+}
+
+// farmland has negative maturity, moisture is from 0 to 7
+type cropInfo struct {
+	crop     string
+	maturity float64
+	moisture int
+}
+
+func cropMatch(b save.BlockState) (cropInfo, bool) {
+	name := strings.TrimPrefix(b.Name, "minecraft:")
+	var props struct {
+		Age      string `nbt:"age"`
+		Moisture string `nbt:"moisture"`
+	}
+	if b.Properties.Data != nil && b.Properties.Unmarshal(&props) != nil {
+		return cropInfo{}, false
+	}
+	if name == "farmland" {
+		m, _ := strconv.Atoi(props.Moisture)
+		return cropInfo{crop: name, maturity: -1, moisture: m}, true
+	}
+	max, ok := cropMaxAge[name]
+	if !ok {
+		return cropInfo{}, false
+	}
+	age, _ := strconv.Atoi(props.Age)
+	return cropInfo{crop: name, maturity: float64(minInt(age, max)) / float64(max)}, true
+}
+
+type cropCounts struct {
+	Farmland int            `json:"farmland"`
+	Crops    map[string]int `json:"crops"`
+	Mature   int            `json:"mature"`
+}
+
+func countCrops(chunk *save.Chunk) cropCounts {
+	ret := cropCounts{Crops: map[string]int{}}
+	scanChunkBlocks(chunk, cropMatch, func(_, _, _ int, c cropInfo) {
+		if c.maturity < 0 {
+			ret.Farmland++
+			return
+		}
+		ret.Crops[c.crop]++
+		if c.maturity >= 1 {
+			ret.Mature++
+		}
+	})
+	return ret
+}
+
+var (
+	cropYoung        = color.RGBA{120, 220, 60, 255}
+	cropMature       = color.RGBA{230, 190, 30, 255}
+	farmlandDry      = color.RGBA{150, 100, 60, 255}
+	farmlandHydrated = color.RGBA{90, 55, 30, 255}
+)
+
+// topmost crop or farmland of every column, crops go from green to gold as
+// they grow, farmland without crop on it gets darker when hydrated
+func drawChunkCrops(chunk *save.Chunk) (img *image.RGBA) {
+	t := time.Now()
+	img = image.NewRGBA(image.Rect(0, 0, 16, 16))
+	var top [16 * 16]int
+	var found [16 * 16]*cropInfo
+	scanChunkBlocks(chunk, cropMatch, func(x, y, z int, c cropInfo) {
+		i := (z-int(chunk.ZPos)*16)*16 + x - int(chunk.XPos)*16
+		if found[i] == nil || y > top[i] {
+			top[i] = y
+			found[i] = &c
+		}
+	})
+	lerp := func(a, b color.RGBA, f float64) color.RGBA {
+		l := func(x, y uint8) uint8 { return uint8(float64(x) + (float64(y)-float64(x))*f) }
+		return color.RGBA{l(a.R, b.R), l(a.G, b.G), l(a.B, b.B), 255}
+	}
+	for i, c := range found {
+		if c == nil {
+			continue
+		}
+		if c.maturity < 0 {
+			img.SetRGBA(i%16, i/16, lerp(farmlandDry, farmlandHydrated, float64(c.moisture)/7))
+		} else {
+			img.SetRGBA(i%16, i/16, lerp(cropYoung, cropMature, c.maturity))
+		}
+	}
+	appendMetrics(time.Since(t), "crops")
+	return img
+}
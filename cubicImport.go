@@ -0,0 +1,144 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/maxsupermanhd/WebChunk/backup"
+	"github.com/maxsupermanhd/WebChunk/chunkStorage"
+)
+
+// Cubic Chunks saves cubes into region3d/<x>.<y>.<z>.3dr files of 16x16x16
+// cubes, header is 4096 big-endian ints (sector offset << 8 | sector count),
+// every entry starts with its length
+const (
+	cubicRegionEntries = 16 * 16 * 16
+	cubicSectorSize    = 512
+)
+
+func readCubicRegion(data []byte) ([][]byte, error) {
+	if len(data) < cubicRegionEntries*4 {
+		return nil, errors.New("region is shorter than its header")
+	}
+	ret := [][]byte{}
+	for i := 0; i < cubicRegionEntries; i++ {
+		loc := binary.BigEndian.Uint32(data[i*4:])
+		if loc == 0 {
+			continue
+		}
+		off := int(loc>>8) * cubicSectorSize
+		if off+4 > len(data) {
+			return ret, fmt.Errorf("entry %d is outside of the file", i)
+		}
+		l := int(binary.BigEndian.Uint32(data[off:]))
+		if l <= 0 || off+4+l > len(data) || 4+l > int(loc&0xff)*cubicSectorSize {
+			return ret, fmt.Errorf("entry %d has bad length %d", i, l)
+		}
+		ret = append(ret, data[off+4:off+4+l])
+	}
+	return ret, nil
+}
+
+// regions of one column of regions are read together, cubes of a chunk
+// may be spread over all of them
+func importCubicRegions(s chunkStorage.ChunkStorage, src backup.Target, wname, dname, dir string, names []string) (int, error) {
+	cubes := map[[2]int32][][]byte{}
+	for _, n := range names {
+		r, err := src.Get(path.Join(dir, n))
+		if err != nil {
+			return 0, err
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return 0, err
+		}
+		entries, err := readCubicRegion(data)
+		if err != nil {
+			log.Printf("Region %s is damaged: %s", n, err.Error())
+		}
+		for _, e := range entries {
+			x, _, z, err := chunkStorage.DecodeCubicCube(e)
+			if err != nil {
+				log.Printf("Failed to read cube of %s: %s", n, err.Error())
+				continue
+			}
+			cubes[[2]int32{x, z}] = append(cubes[[2]int32{x, z}], e)
+		}
+	}
+	imported := 0
+	for k, cc := range cubes {
+		c, skipped, err := chunkStorage.CubicColumn(k[0], k[1], cc)
+		if err != nil {
+			log.Printf("Failed to stack cubes of %d:%d: %s", k[0], k[1], err.Error())
+			continue
+		}
+		if skipped > 0 {
+			log.Printf("Left out %d cubes of %d:%d outside of supported height", skipped, k[0], k[1])
+		}
+		if err := s.AddChunk(wname, dname, int(k[0]), int(k[1]), c); err != nil {
+			return imported, err
+		}
+		imported++
+	}
+	return imported, nil
+}
+
+func importCubic(s chunkStorage.ChunkStorage, src backup.Target, lister backup.Lister, wname, dname, dir string) (int, error) {
+	dir = strings.Trim(dir, "/")
+	names, err := lister.List(dir)
+	if err != nil {
+		return 0, fmt.Errorf("listing %q: %w", dir, err)
+	}
+	columns := map[[2]int][]string{}
+	for _, n := range names {
+		var rx, ry, rz int
+		if _, err := fmt.Sscanf(n, "%d.%d.%d.3dr", &rx, &ry, &rz); err != nil || !strings.HasSuffix(n, ".3dr") {
+			continue
+		}
+		columns[[2]int{rx, rz}] = append(columns[[2]int{rx, rz}], n)
+	}
+	keys := make([][2]int, 0, len(columns))
+	for k := range columns {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i][0] < keys[j][0] || keys[i][0] == keys[j][0] && keys[i][1] < keys[j][1]
+	})
+	total := 0
+	for _, k := range keys {
+		c, err := importCubicRegions(s, src, wname, dname, dir, columns[k])
+		total += c
+		if err != nil {
+			return total, fmt.Errorf("importing regions %d:%d: %w", k[0], k[1], err)
+		}
+		log.Printf("Imported %d chunks from %d regions at %d:%d", c, len(columns[k]), k[0], k[1])
+	}
+	return total, nil
+}
@@ -0,0 +1,93 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package biomes
+
+// vanilla temperature and downfall, biomes not listed here use DefaultClimate
+type Climate struct {
+	Temperature, Downfall float64
+}
+
+var DefaultClimate = Climate{0.5, 0.5}
+
+var Climates = map[string]Climate{
+	"plains":                   {0.8, 0.4},
+	"sunflower_plains":         {0.8, 0.4},
+	"snowy_plains":             {0, 0.5},
+	"ice_spikes":               {0, 0.5},
+	"desert":                   {2, 0},
+	"swamp":                    {0.8, 0.9},
+	"mangrove_swamp":           {0.8, 0.9},
+	"forest":                   {0.7, 0.8},
+	"flower_forest":            {0.7, 0.8},
+	"dark_forest":              {0.7, 0.8},
+	"birch_forest":             {0.6, 0.6},
+	"old_growth_birch_forest":  {0.6, 0.6},
+	"taiga":                    {0.25, 0.8},
+	"old_growth_spruce_taiga":  {0.25, 0.8},
+	"old_growth_pine_taiga":    {0.3, 0.8},
+	"snowy_taiga":              {-0.5, 0.4},
+	"savanna":                  {2, 0},
+	"savanna_plateau":          {2, 0},
+	"windswept_savanna":        {2, 0},
+	"windswept_hills":          {0.2, 0.3},
+	"windswept_gravelly_hills": {0.2, 0.3},
+	"windswept_forest":         {0.2, 0.3},
+	"stony_shore":              {0.2, 0.3},
+	"jungle":                   {0.95, 0.9},
+	"bamboo_jungle":            {0.95, 0.9},
+	"sparse_jungle":            {0.95, 0.8},
+	"badlands":                 {2, 0},
+	"eroded_badlands":          {2, 0},
+	"wooded_badlands":          {2, 0},
+	"meadow":                   {0.5, 0.8},
+	"cherry_grove":             {0.5, 0.8},
+	"grove":                    {-0.2, 0.8},
+	"snowy_slopes":             {-0.3, 0.9},
+	"frozen_peaks":             {-0.7, 0.9},
+	"jagged_peaks":             {-0.7, 0.9},
+	"stony_peaks":              {1, 0.3},
+	"frozen_river":             {0, 0.5},
+	"frozen_ocean":             {0, 0.5},
+	"beach":                    {0.8, 0.4},
+	"snowy_beach":              {0.05, 0.3},
+	"mushroom_fields":          {0.9, 1},
+	"dripstone_caves":          {0.8, 0.4},
+	"deep_dark":                {0.8, 0.4},
+	"nether_wastes":            {2, 0},
+	"soul_sand_valley":         {2, 0},
+	"crimson_forest":           {2, 0},
+	"warped_forest":            {2, 0},
+	"basalt_deltas":            {2, 0},
+}
+
+// climates by numeric biome id from BiomeID
+var ClimatesByID = func() []Climate {
+	ret := make([]Climate, 256)
+	for i := range ret {
+		ret[i] = DefaultClimate
+	}
+	for name, id := range BiomeID {
+		if c, ok := Climates[name]; ok && id >= 0 && id < len(ret) {
+			ret[id] = c
+		}
+	}
+	return ret
+}()
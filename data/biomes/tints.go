@@ -0,0 +1,99 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package biomes
+
+// vanilla grass, foliage and water colors as 0xRRGGBB,
+// biomes not listed here use DefaultTint
+type Tint struct {
+	Grass, Foliage, Water uint32
+}
+
+var DefaultTint = Tint{0x8EB971, 0x71A74D, 0x3F76E4}
+
+var Tints = map[string]Tint{
+	"plains":                   {0x91BD59, 0x77AB2F, 0x3F76E4},
+	"sunflower_plains":         {0x91BD59, 0x77AB2F, 0x3F76E4},
+	"beach":                    {0x91BD59, 0x77AB2F, 0x3F76E4},
+	"dripstone_caves":          {0x91BD59, 0x77AB2F, 0x3F76E4},
+	"snowy_beach":              {0x83B593, 0x64A278, 0x3D57D6},
+	"forest":                   {0x79C05A, 0x59AE30, 0x3F76E4},
+	"flower_forest":            {0x79C05A, 0x59AE30, 0x3F76E4},
+	"dark_forest":              {0x507A32, 0x59AE30, 0x3F76E4},
+	"birch_forest":             {0x88BB67, 0x6BA941, 0x3F76E4},
+	"old_growth_birch_forest":  {0x88BB67, 0x6BA941, 0x3F76E4},
+	"swamp":                    {0x6A7039, 0x6A7039, 0x617B64},
+	"mangrove_swamp":           {0x6A7039, 0x8DB127, 0x3A7A6A},
+	"jungle":                   {0x59C93C, 0x30BB0B, 0x3F76E4},
+	"bamboo_jungle":            {0x59C93C, 0x30BB0B, 0x3F76E4},
+	"sparse_jungle":            {0x64C73F, 0x3EB80F, 0x3F76E4},
+	"taiga":                    {0x86B783, 0x68A464, 0x3F76E4},
+	"old_growth_spruce_taiga":  {0x86B783, 0x68A464, 0x3F76E4},
+	"old_growth_pine_taiga":    {0x86B87F, 0x68A55F, 0x3F76E4},
+	"snowy_taiga":              {0x80B497, 0x60A17B, 0x3D57D6},
+	"snowy_plains":             {0x80B497, 0x60A17B, 0x3F76E4},
+	"ice_spikes":               {0x80B497, 0x60A17B, 0x3F76E4},
+	"grove":                    {0x80B497, 0x60A17B, 0x3F76E4},
+	"snowy_slopes":             {0x80B497, 0x60A17B, 0x3F76E4},
+	"frozen_peaks":             {0x80B497, 0x60A17B, 0x3F76E4},
+	"jagged_peaks":             {0x80B497, 0x60A17B, 0x3F76E4},
+	"frozen_river":             {0x80B497, 0x60A17B, 0x3938C9},
+	"stony_peaks":              {0x9ABE4B, 0x82AC1E, 0x3F76E4},
+	"meadow":                   {0x83BB6D, 0x63A948, 0x0E4ECF},
+	"cherry_grove":             {0xB6DB61, 0xB6DB61, 0x5DB7EF},
+	"windswept_hills":          {0x8AB689, 0x6DA36B, 0x3F76E4},
+	"windswept_gravelly_hills": {0x8AB689, 0x6DA36B, 0x3F76E4},
+	"windswept_forest":         {0x8AB689, 0x6DA36B, 0x3F76E4},
+	"stony_shore":              {0x8AB689, 0x6DA36B, 0x3F76E4},
+	"savanna":                  {0xBFB755, 0xAEA42A, 0x3F76E4},
+	"savanna_plateau":          {0xBFB755, 0xAEA42A, 0x3F76E4},
+	"windswept_savanna":        {0xBFB755, 0xAEA42A, 0x3F76E4},
+	"desert":                   {0xBFB755, 0xAEA42A, 0x3F76E4},
+	"badlands":                 {0x90814D, 0x9E814D, 0x3F76E4},
+	"eroded_badlands":          {0x90814D, 0x9E814D, 0x3F76E4},
+	"wooded_badlands":          {0x90814D, 0x9E814D, 0x3F76E4},
+	"mushroom_fields":          {0x55C93F, 0x2BBB0F, 0x3F76E4},
+	"warm_ocean":               {0x8EB971, 0x71A74D, 0x43D5EE},
+	"lukewarm_ocean":           {0x8EB971, 0x71A74D, 0x45ADF2},
+	"deep_lukewarm_ocean":      {0x8EB971, 0x71A74D, 0x45ADF2},
+	"cold_ocean":               {0x8EB971, 0x71A74D, 0x3D57D6},
+	"deep_cold_ocean":          {0x8EB971, 0x71A74D, 0x3D57D6},
+	"frozen_ocean":             {0x8EB971, 0x71A74D, 0x3938C9},
+	"deep_frozen_ocean":        {0x8EB971, 0x71A74D, 0x3938C9},
+	"nether_wastes":            {0xBFB755, 0xAEA42A, 0x3F76E4},
+	"soul_sand_valley":         {0xBFB755, 0xAEA42A, 0x3F76E4},
+	"crimson_forest":           {0xBFB755, 0xAEA42A, 0x3F76E4},
+	"warped_forest":            {0xBFB755, 0xAEA42A, 0x3F76E4},
+	"basalt_deltas":            {0xBFB755, 0xAEA42A, 0x3F76E4},
+}
+
+// tints by numeric biome id from BiomeID
+var TintsByID = func() []Tint {
+	ret := make([]Tint, 256)
+	for i := range ret {
+		ret[i] = DefaultTint
+	}
+	for name, id := range BiomeID {
+		if t, ok := Tints[name]; ok && id >= 0 && id < len(ret) {
+			ret[id] = t
+		}
+	}
+	return ret
+}()
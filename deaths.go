@@ -0,0 +1,109 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/maxsupermanhd/WebChunk/proxy"
+)
+
+type deathRecord struct {
+	Player  string
+	X, Y, Z int
+	Message string `json:",omitempty"`
+	Time    time.Time
+}
+
+func deathReceived(e *proxy.ProxiedEvent, d proxy.EventPlayerDeath) {
+	err := recs.Append(e.Server, strings.TrimPrefix(e.Dimension, "minecraft:"), "deaths", deathRecord{
+		Player:  e.Username,
+		X:       d.X,
+		Y:       d.Y,
+		Z:       d.Z,
+		Message: d.Message,
+		Time:    e.Time,
+	})
+	if err != nil {
+		log.Printf("Failed to save death of %s: %s", e.Username, err.Error())
+	}
+}
+
+// newest first, player is matched case-insensitively against name returned by names
+func listDeaths(wname, dname, player string, since time.Time, limit int, names playerNamer) ([]deathRecord, error) {
+	ret := []deathRecord{}
+	err := recs.Read(wname, dname, "deaths", func(m json.RawMessage) error {
+		var d deathRecord
+		if json.Unmarshal(m, &d) != nil {
+			return nil
+		}
+		d.Message = anonymizeText(d.Message, d.Player, names)
+		d.Player = names(d.Player)
+		if player != "" && !strings.EqualFold(d.Player, player) {
+			return nil
+		}
+		if d.Time.Before(since) {
+			return nil
+		}
+		ret = append(ret, d)
+		return nil
+	})
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].Time.After(ret[j].Time)
+	})
+	if limit > 0 && len(ret) > limit {
+		ret = ret[:limit]
+	}
+	return ret, err
+}
+
+func apiListDeaths(w http.ResponseWriter, r *http.Request) (int, string) {
+	params := mux.Vars(r)
+	since := time.Time{}
+	if s := r.FormValue("since"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return 400, "Bad since: " + err.Error()
+		}
+		since = t
+	}
+	limit := 200
+	if l := r.FormValue("limit"); l != "" {
+		v, err := strconv.Atoi(l)
+		if err != nil {
+			return 400, "Bad limit: " + err.Error()
+		}
+		limit = v
+	}
+	deaths, err := listDeaths(params["world"], params["dim"], r.FormValue("player"), since, limit, playerNamerFor(r))
+	if err != nil {
+		return 500, err.Error()
+	}
+	setContentTypeJson(w)
+	return marshalOrFail(200, deaths)
+}
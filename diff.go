@@ -0,0 +1,236 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maxsupermanhd/WebChunk/chunkStorage"
+	"github.com/maxsupermanhd/go-vmc/v764/level/block"
+	"github.com/maxsupermanhd/go-vmc/v764/save"
+)
+
+// changes between two moments of chunk history, variant is named
+// diff_<from> (up to latest chunks) or diff_<from>_<to> in unix seconds
+var diffParamLayer = paramLayer{
+	variant: func(r *http.Request) (string, error) {
+		from, err := parseSyncSince(r.URL.Query().Get("from"))
+		if err != nil {
+			return "", errors.New("bad from: " + err.Error())
+		}
+		to, err := parseSyncSince(r.URL.Query().Get("to"))
+		if err != nil {
+			return "", errors.New("bad to: " + err.Error())
+		}
+		if from.IsZero() {
+			from = diffDefaultFrom()
+		}
+		if to.IsZero() {
+			return "diff_" + strconv.FormatInt(from.Unix(), 10), nil
+		}
+		if !to.After(from) {
+			return "", errors.New("to is not after from")
+		}
+		return "diff_" + strconv.FormatInt(from.Unix(), 10) + "_" + strconv.FormatInt(to.Unix(), 10), nil
+	},
+	provider: func(variant string) (ttypeProviderFunc, bool) {
+		if variant == "diff" {
+			return diffLatestProvider, true
+		}
+		rest, ok := strings.CutPrefix(variant, "diff_")
+		if !ok {
+			return nil, false
+		}
+		froms, tos, hasTo := strings.Cut(rest, "_")
+		from, err := strconv.ParseInt(froms, 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		to := time.Time{}
+		if hasTo {
+			t, err := strconv.ParseInt(tos, 10, 64)
+			if err != nil {
+				return nil, false
+			}
+			to = time.Unix(t, 0)
+		}
+		return diffProvider(time.Unix(from, 0), to), true
+	},
+}
+
+func diffLatestProvider(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
+	return diffProvider(diffDefaultFrom(), time.Time{})(s)
+}
+
+// without from changes of the last day are shown, rounded to hour
+// so tiles can still be cached for a while
+func diffDefaultFrom() time.Time {
+	window := time.Duration(cfg.GetDSInt(24, "layers", "diff", "window")) * time.Hour
+	return time.Now().Add(-window).Truncate(time.Hour)
+}
+
+var diffAirState = block.ToStateID[block.Air{}]
+
+type diffChunk struct {
+	before, after *save.Chunk
+}
+
+func diffProvider(from, to time.Time) ttypeProviderFunc {
+	return func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
+		getter := func(wname, dname string, cx0, cz0, cx1, cz1 int) ([]chunkStorage.ChunkData, error) {
+			hs, ok := s.(chunkStorage.ChunkHistoryStorage)
+			if !ok {
+				return nil, errors.New("storage does not keep chunk history")
+			}
+			before, err := hs.GetChunksRegionAt(wname, dname, cx0, cz0, cx1, cz1, from)
+			if err != nil {
+				return nil, err
+			}
+			var after []chunkStorage.ChunkData
+			if to.IsZero() {
+				after, err = s.GetChunksRegion(wname, dname, cx0, cz0, cx1, cz1)
+			} else {
+				after, err = hs.GetChunksRegionAt(wname, dname, cx0, cz0, cx1, cz1, to)
+			}
+			if err != nil {
+				return nil, err
+			}
+			old := map[[2]int]*save.Chunk{}
+			for _, c := range before {
+				if d, ok := c.Data.(save.Chunk); ok {
+					old[[2]int{c.X, c.Z}] = &d
+				}
+			}
+			ret := []chunkStorage.ChunkData{}
+			for _, c := range after {
+				d, ok := c.Data.(save.Chunk)
+				if !ok {
+					continue
+				}
+				ret = append(ret, chunkStorage.ChunkData{X: c.X, Z: c.Z, Data: diffChunk{before: old[[2]int{c.X, c.Z}], after: &d}})
+			}
+			return ret, nil
+		}
+		return getter, func(i interface{}) *image.RGBA {
+			return drawChunkDiff(i.(diffChunk))
+		}
+	}
+}
+
+// columns are as red as many blocks changed in them, chunks that were
+// not captured yet at from are tinted blue as a whole
+func drawChunkDiff(d diffChunk) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	if d.before == nil {
+		for i := 0; i < 16*16; i++ {
+			img.SetRGBA(i%16, i/16, color.RGBA{R: 0x3a, G: 0x7b, B: 0xd5, A: 0x70})
+		}
+		return img
+	}
+	changed := diffChunkColumns(d.before, d.after)
+	empty := true
+	for i, n := range changed {
+		if n == 0 {
+			continue
+		}
+		empty = false
+		img.SetRGBA(i%16, i/16, color.RGBA{R: 0xe0, G: 0x20, B: 0x20, A: uint8(96 + minInt(n, 32)*159/32)})
+	}
+	if empty {
+		return nil
+	}
+	return img
+}
+
+// number of changed blocks in every column, sections with palettes
+// that can not be read count as single change per column if they differ
+func diffChunkColumns(before, after *save.Chunk) (ret [16 * 16]int) {
+	sections := map[int8][2]*save.Section{}
+	for i := range before.Sections {
+		p := sections[before.Sections[i].Y]
+		p[0] = &before.Sections[i]
+		sections[before.Sections[i].Y] = p
+	}
+	for i := range after.Sections {
+		p := sections[after.Sections[i].Y]
+		p[1] = &after.Sections[i]
+		sections[after.Sections[i].Y] = p
+	}
+	for _, p := range sections {
+		if diffSectionsEqual(p[0], p[1]) {
+			continue
+		}
+		a, aok := diffSectionStates(p[0])
+		b, bok := diffSectionStates(p[1])
+		if !aok || !bok {
+			for i := range ret {
+				ret[i]++
+			}
+			continue
+		}
+		for j := 0; j < 16*16*16; j++ {
+			if a(j) != b(j) && !(isAirState(a(j)) && isAirState(b(j))) {
+				ret[j%(16*16)]++
+			}
+		}
+	}
+	return
+}
+
+// missing and empty sections are all air
+func diffSectionStates(s *save.Section) (func(i int) block.StateID, bool) {
+	if s == nil || len(s.BlockStates.Palette) == 0 {
+		return func(int) block.StateID { return diffAirState }, true
+	}
+	states := prepareSectionBlockstates(s)
+	if states == nil {
+		return nil, false
+	}
+	return states.Get, true
+}
+
+func diffSectionsEqual(a, b *save.Section) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if len(a.BlockStates.Palette) != len(b.BlockStates.Palette) || len(a.BlockStates.Data) != len(b.BlockStates.Data) {
+		return false
+	}
+	for i := range a.BlockStates.Palette {
+		pa, pb := a.BlockStates.Palette[i], b.BlockStates.Palette[i]
+		if pa.Name != pb.Name || pa.Properties.Type != pb.Properties.Type || !bytes.Equal(pa.Properties.Data, pb.Properties.Data) {
+			return false
+		}
+	}
+	for i := range a.BlockStates.Data {
+		if a.BlockStates.Data[i] != b.BlockStates.Data[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -39,7 +39,7 @@ func dimensionHandler(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	wname := params["world"]
 	dname := params["dim"]
-	world, s, err := chunkStorage.GetWorldStorage(storages, wname)
+	world, s, err := storages.World(wname)
 	if err != nil {
 		plainmsg(w, r, plainmsgColorRed, "Error getting storage interface by world name: "+err.Error())
 		return
@@ -57,12 +57,22 @@ func dimensionHandler(w http.ResponseWriter, r *http.Request) {
 		plainmsg(w, r, plainmsgColorRed, "Dimension not found")
 		return
 	}
-	layers := make([]ttype, 0, len(ttypes))
-	for t := range ttypes {
+	layers := make([]ttype, 0, ttypes.Len())
+	for t := range ttypes.All() {
 		layers = append(layers, t)
 	}
 	sort.Slice(layers, func(i, j int) bool { return strings.Compare(layers[i].Name, layers[j].Name) > 0 })
-	templateRespond("dim", w, r, map[string]interface{}{"Dim": dim, "World": world, "Layers": layers})
+	layers = allowedLayers(r, wname, dname, layers)
+	view := dimMapView(wname, dname)
+	var worldTime *worldTimeView
+	if t, ok := currentWorldTime(wname, dname); ok {
+		worldTime = &t
+	}
+	var meta *chunkStorage.WorldMetadata
+	if m, ok := getWorldMetadata(wname); ok {
+		meta = &m
+	}
+	templateRespond("dim", w, r, map[string]interface{}{"Dim": dim, "World": world, "Layers": layers, "View": view, "InitialLayers": mapViewLayers(view, layers), "Explorers": listExplorationStats(wname, dname, playerNamerFor(r)), "Coordinates": dimCoordDisplay(wname, *dim), "Scoreboard": currentScoreboard(wname, playerNamerFor(r)), "WorldTime": worldTime, "Metadata": meta})
 }
 
 func apiAddDimension(w http.ResponseWriter, r *http.Request) (int, string) {
@@ -82,7 +92,7 @@ func apiAddDimension(w http.ResponseWriter, r *http.Request) (int, string) {
 	if !worldNameRegexp.Match([]byte(tdim.World)) {
 		return 400, "Invalid world name"
 	}
-	_, s, err := chunkStorage.GetWorldStorage(storages, tdim.World)
+	_, s, err := storages.World(tdim.World)
 	if err != nil {
 		return 500, "Error getting world storage: " + err.Error()
 	}
@@ -101,7 +111,7 @@ func apiListDimensions(w http.ResponseWriter, r *http.Request) (int, string) {
 	if r.ParseForm() != nil {
 		return 400, "Unable to parse form parameters"
 	}
-	dims, err := chunkStorage.ListDimensions(storages, r.Form.Get("world"))
+	dims, err := storages.Dimensions(r.Form.Get("world"))
 	if err != nil {
 		return 500, "Failed to list dimensions: " + err.Error()
 	}
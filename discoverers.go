@@ -0,0 +1,207 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/draw"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/maxsupermanhd/WebChunk/chunkStorage"
+)
+
+type discoveryRecord struct {
+	Time   time.Time
+	Player string
+	X, Z   int
+}
+
+type explorationStats struct {
+	Player string
+	Color  string // same as on the layer
+	Chunks int
+	First  time.Time
+	Last   time.Time
+}
+
+// who was the first to bring each chunk, chunks stored before
+// attribution was recorded have no discoverer
+type discoveryIndex struct {
+	players map[string]*explorationStats
+	chunks  map[[2]int]*explorationStats
+}
+
+var (
+	discoveries     = map[entityDensityKey]*discoveryIndex{}
+	discoveriesLock sync.Mutex
+)
+
+func (idx *discoveryIndex) add(rec discoveryRecord) bool {
+	pos := [2]int{rec.X, rec.Z}
+	if _, ok := idx.chunks[pos]; ok {
+		return false
+	}
+	p, ok := idx.players[rec.Player]
+	if !ok {
+		p = &explorationStats{Player: rec.Player, First: rec.Time}
+		idx.players[rec.Player] = p
+	}
+	p.Chunks++
+	if rec.Time.After(p.Last) {
+		p.Last = rec.Time
+	}
+	idx.chunks[pos] = p
+	return true
+}
+
+// must be called with discoveriesLock held, loads index from records on first use
+func getDiscoveryIndex(wname, dname string) *discoveryIndex {
+	k := entityDensityKey{world: wname, dimension: dname}
+	idx, ok := discoveries[k]
+	if ok {
+		return idx
+	}
+	idx = &discoveryIndex{
+		players: map[string]*explorationStats{},
+		chunks:  map[[2]int]*explorationStats{},
+	}
+	err := recs.Read(wname, dname, "discoveries", func(m json.RawMessage) error {
+		var rec discoveryRecord
+		if json.Unmarshal(m, &rec) == nil {
+			idx.add(rec)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to load discovery records of %s %s: %s", wname, dname, err.Error())
+		return nil
+	}
+	discoveries[k] = idx
+	return idx
+}
+
+func chunkDiscovered(wname, dname, player string, cx, cz int) {
+	if player == "" {
+		return
+	}
+	rec := discoveryRecord{Time: time.Now(), Player: player, X: cx, Z: cz}
+	discoveriesLock.Lock()
+	idx := getDiscoveryIndex(wname, dname)
+	isNew := idx != nil && idx.add(rec)
+	discoveriesLock.Unlock()
+	if !isNew {
+		return
+	}
+	if err := recs.Append(wname, dname, "discoveries", rec); err != nil {
+		log.Printf("Failed to record chunk discovery: %s", err.Error())
+	}
+}
+
+func getDiscoverersRegion(wname, dname string, cx0, cz0, cx1, cz1 int) ([]chunkStorage.ChunkData, error) {
+	discoveriesLock.Lock()
+	defer discoveriesLock.Unlock()
+	ret := []chunkStorage.ChunkData{}
+	idx := getDiscoveryIndex(wname, dname)
+	if idx == nil {
+		return ret, nil
+	}
+	for x := cx0; x < cx1; x++ {
+		for z := cz0; z < cz1; z++ {
+			if p, ok := idx.chunks[[2]int{x, z}]; ok {
+				ret = append(ret, chunkStorage.ChunkData{X: x, Z: z, Data: p.Player})
+			}
+		}
+	}
+	return ret, nil
+}
+
+// most chunks first
+func listExplorationStats(wname, dname string, names playerNamer) []explorationStats {
+	discoveriesLock.Lock()
+	defer discoveriesLock.Unlock()
+	ret := []explorationStats{}
+	idx := getDiscoveryIndex(wname, dname)
+	if idx == nil {
+		return ret
+	}
+	for _, p := range idx.players {
+		s := *p
+		c := discovererColor(s.Player)
+		s.Color = fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+		s.Player = names(s.Player)
+		ret = append(ret, s)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Chunks != ret[j].Chunks {
+			return ret[i].Chunks > ret[j].Chunks
+		}
+		return ret[i].Player < ret[j].Player
+	})
+	return ret
+}
+
+// stable color for the player name
+func discovererColor(player string) color.RGBA {
+	h := fnv.New32a()
+	h.Write([]byte(player))
+	hue := float64(h.Sum32()%360) / 60
+	x := 1 - math.Abs(math.Mod(hue, 2)-1)
+	var r, g, b float64
+	switch int(hue) {
+	case 0:
+		r, g = 1, x
+	case 1:
+		r, g = x, 1
+	case 2:
+		g, b = 1, x
+	case 3:
+		g, b = x, 1
+	case 4:
+		r, b = x, 1
+	default:
+		r, b = 1, x
+	}
+	return color.RGBA{uint8(r * 255), uint8(g * 255), uint8(b * 255), 255}
+}
+
+func drawDiscoverer(player string) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	c := discovererColor(player)
+	c.R, c.G, c.B, c.A = c.R/2, c.G/2, c.B/2, 128 // premultiplied
+	draw.Draw(img, img.Bounds(), &image.Uniform{c}, image.Point{}, draw.Src)
+	return img
+}
+
+func apiListExplorationStats(w http.ResponseWriter, r *http.Request) (int, string) {
+	params := mux.Vars(r)
+	setContentTypeJson(w)
+	return marshalOrFail(200, listExplorationStats(params["world"], params["dim"], playerNamerFor(r)))
+}
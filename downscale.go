@@ -0,0 +1,97 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"log"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// draws 16x16 chunk image shrunk into rectangle of zoomed out tile
+type chunkDownscaler func(dst *image.RGBA, at image.Rectangle, src *image.RGBA)
+
+// nearest keeps pixels sharp but gets noisy far out, box averages
+// every pixel, bilinear and lanczos are smoother and slower
+func layerDownscaler(variant string) chunkDownscaler {
+	name := layerBaseName(variant)
+	var scaler xdraw.Scaler
+	switch f := cfg.GetDSString("nearest", "layers", name, "downscale"); f {
+	case "nearest":
+		scaler = xdraw.NearestNeighbor
+	case "box":
+		return boxDownscale
+	case "bilinear":
+		scaler = xdraw.ApproxBiLinear
+	case "lanczos":
+		// closest kernel x/image has, just as sharp
+		scaler = xdraw.CatmullRom
+	default:
+		log.Printf("Unknown downscale filter [%s] of layer [%s], using nearest", f, name)
+		scaler = xdraw.NearestNeighbor
+	}
+	return func(dst *image.RGBA, at image.Rectangle, src *image.RGBA) {
+		if at.Dx() == src.Rect.Dx() && at.Dy() == src.Rect.Dy() {
+			draw.Draw(dst, at, src, src.Rect.Min, draw.Over)
+			return
+		}
+		scaler.Scale(dst, at, src, src.Rect, draw.Over, nil)
+	}
+}
+
+// average of all source pixels falling into destination one,
+// premultiplied alpha keeps transparent pixels from darkening edges
+func boxDownscale(dst *image.RGBA, at image.Rectangle, src *image.RGBA) {
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	dw, dh := at.Dx(), at.Dy()
+	for y := 0; y < dh; y++ {
+		sy0, sy1 := y*h/dh, maxInt((y+1)*h/dh, y*h/dh+1)
+		for x := 0; x < dw; x++ {
+			sx0, sx1 := x*w/dw, maxInt((x+1)*w/dw, x*w/dw+1)
+			var r, g, b, a, n int
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					c := src.RGBAAt(src.Rect.Min.X+sx, src.Rect.Min.Y+sy)
+					r += int(c.R)
+					g += int(c.G)
+					b += int(c.B)
+					a += int(c.A)
+					n++
+				}
+			}
+			if a == 0 {
+				continue
+			}
+			// drawn over what is already there like other filters do
+			under := dst.RGBAAt(at.Min.X+x, at.Min.Y+y)
+			k := 255 - a/n
+			dst.SetRGBA(at.Min.X+x, at.Min.Y+y, color.RGBA{
+				uint8(r/n + int(under.R)*k/255),
+				uint8(g/n + int(under.G)*k/255),
+				uint8(b/n + int(under.B)*k/255),
+				uint8(a/n + int(under.A)*k/255),
+			})
+		}
+	}
+}
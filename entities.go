@@ -0,0 +1,184 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/maxsupermanhd/WebChunk/chunkStorage"
+	"github.com/maxsupermanhd/WebChunk/proxy"
+	"github.com/maxsupermanhd/go-vmc/v764/data/entity"
+)
+
+type entityRecord struct {
+	Time    time.Time
+	Player  string
+	Action  string // "spawn" or "despawn"
+	UUID    uuid.UUID
+	Type    string
+	X, Y, Z float64
+}
+
+type liveEntityKey struct {
+	server string
+	id     int32
+}
+
+type liveEntity struct {
+	dimension string
+	rec       entityRecord
+}
+
+// only touched from proxy event consumer
+var liveEntities = map[liveEntityKey]liveEntity{}
+
+type entityDensityKey struct {
+	world, dimension string
+}
+
+// entities are counted once in the chunk they were first seen in
+type entityDensityIndex struct {
+	seen   map[uuid.UUID]bool
+	chunks map[[2]int]int
+}
+
+var (
+	entityDensity     = map[entityDensityKey]*entityDensityIndex{}
+	entityDensityLock sync.Mutex
+)
+
+func entityIsMob(t *entity.Entity) bool {
+	switch t.Type {
+	case "other", "projectile", "player":
+		return false
+	default:
+		return true
+	}
+}
+
+func entitySpawned(e *proxy.ProxiedEvent, d proxy.EventEntitySpawn) {
+	t, ok := entity.ByID[entity.ID(d.Type)]
+	if !ok || !entityIsMob(t) {
+		return
+	}
+	dim := strings.TrimPrefix(e.Dimension, "minecraft:")
+	rec := entityRecord{
+		Time:   e.Time,
+		Player: e.Username,
+		Action: "spawn",
+		UUID:   d.UUID,
+		Type:   t.Name,
+		X:      d.X,
+		Y:      d.Y,
+		Z:      d.Z,
+	}
+	liveEntities[liveEntityKey{server: e.Server, id: d.EntityID}] = liveEntity{dimension: dim, rec: rec}
+	if err := recs.Append(e.Server, dim, "entities", rec); err != nil {
+		log.Printf("Failed to record entity: %s", err.Error())
+	}
+	entityDensityLock.Lock()
+	if idx := getEntityDensityIndex(e.Server, dim); idx != nil {
+		idx.add(rec)
+	}
+	entityDensityLock.Unlock()
+}
+
+func entitiesRemoved(e *proxy.ProxiedEvent, d proxy.EventEntityRemove) {
+	for _, id := range d.EntityIDs {
+		k := liveEntityKey{server: e.Server, id: id}
+		l, ok := liveEntities[k]
+		if !ok {
+			continue
+		}
+		delete(liveEntities, k)
+		l.rec.Time = e.Time
+		l.rec.Player = e.Username
+		l.rec.Action = "despawn"
+		if err := recs.Append(e.Server, l.dimension, "entities", l.rec); err != nil {
+			log.Printf("Failed to record entity: %s", err.Error())
+		}
+	}
+}
+
+// server will not tell about despawns after player leaves
+func entitiesForgetPlayer(e *proxy.ProxiedEvent) {
+	for k, l := range liveEntities {
+		if k.server == e.Server && l.rec.Player == e.Username {
+			delete(liveEntities, k)
+		}
+	}
+}
+
+func (idx *entityDensityIndex) add(rec entityRecord) {
+	if rec.Action != "spawn" || idx.seen[rec.UUID] {
+		return
+	}
+	idx.seen[rec.UUID] = true
+	idx.chunks[[2]int{int(math.Floor(rec.X)) >> 4, int(math.Floor(rec.Z)) >> 4}]++
+}
+
+// must be called with entityDensityLock held, loads index from records on first use
+func getEntityDensityIndex(wname, dname string) *entityDensityIndex {
+	k := entityDensityKey{world: wname, dimension: dname}
+	idx, ok := entityDensity[k]
+	if ok {
+		return idx
+	}
+	idx = &entityDensityIndex{
+		seen:   map[uuid.UUID]bool{},
+		chunks: map[[2]int]int{},
+	}
+	err := recs.Read(wname, dname, "entities", func(m json.RawMessage) error {
+		var rec entityRecord
+		if json.Unmarshal(m, &rec) == nil {
+			idx.add(rec)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to load entity records of %s %s: %s", wname, dname, err.Error())
+		return nil
+	}
+	entityDensity[k] = idx
+	return idx
+}
+
+func getEntityDensityRegion(wname, dname string, cx0, cz0, cx1, cz1 int) ([]chunkStorage.ChunkData, error) {
+	entityDensityLock.Lock()
+	defer entityDensityLock.Unlock()
+	ret := []chunkStorage.ChunkData{}
+	idx := getEntityDensityIndex(wname, dname)
+	if idx == nil {
+		return ret, nil
+	}
+	for pos, count := range idx.chunks {
+		if pos[0] >= cx0 && pos[0] < cx1 && pos[1] >= cz0 && pos[1] < cz1 {
+			ret = append(ret, chunkStorage.ChunkData{X: pos[0], Z: pos[1], Data: count})
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,155 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+)
+
+// tiles with error chunks are not cached so they are retried on next request
+const errorTileHeader = "X-Tile-Error"
+
+func errorTilesEnabled() bool {
+	return cfg.GetDSBool(true, "error_tiles")
+}
+
+// 3x5 glyphs, anything else is drawn as a question mark
+var errorTileGlyphs = map[rune][5]string{
+	'A': {"010", "101", "111", "101", "101"},
+	'B': {"110", "101", "110", "101", "110"},
+	'C': {"011", "100", "100", "100", "011"},
+	'D': {"110", "101", "101", "101", "110"},
+	'E': {"111", "100", "110", "100", "111"},
+	'F': {"111", "100", "110", "100", "100"},
+	'G': {"011", "100", "101", "101", "011"},
+	'H': {"101", "101", "111", "101", "101"},
+	'I': {"111", "010", "010", "010", "111"},
+	'J': {"001", "001", "001", "101", "010"},
+	'K': {"101", "101", "110", "101", "101"},
+	'L': {"100", "100", "100", "100", "111"},
+	'M': {"101", "111", "111", "101", "101"},
+	'N': {"110", "101", "101", "101", "101"},
+	'O': {"010", "101", "101", "101", "010"},
+	'P': {"110", "101", "110", "100", "100"},
+	'Q': {"010", "101", "101", "110", "011"},
+	'R': {"110", "101", "110", "101", "101"},
+	'S': {"011", "100", "010", "001", "110"},
+	'T': {"111", "010", "010", "010", "010"},
+	'U': {"101", "101", "101", "101", "111"},
+	'V': {"101", "101", "101", "101", "010"},
+	'W': {"101", "101", "111", "111", "101"},
+	'X': {"101", "101", "010", "101", "101"},
+	'Y': {"101", "101", "010", "010", "010"},
+	'Z': {"111", "001", "010", "100", "111"},
+	'0': {"111", "101", "101", "101", "111"},
+	'1': {"010", "110", "010", "010", "111"},
+	'2': {"110", "001", "010", "100", "111"},
+	'3': {"110", "001", "010", "001", "110"},
+	'4': {"101", "101", "111", "001", "001"},
+	'5': {"111", "100", "110", "001", "110"},
+	'6': {"011", "100", "111", "101", "111"},
+	'7': {"111", "001", "010", "010", "010"},
+	'8': {"111", "101", "111", "101", "111"},
+	'9': {"111", "101", "111", "001", "110"},
+	' ': {"000", "000", "000", "000", "000"},
+	'.': {"000", "000", "000", "000", "010"},
+	',': {"000", "000", "000", "010", "100"},
+	':': {"000", "010", "000", "010", "000"},
+	'-': {"000", "000", "111", "000", "000"},
+	'_': {"000", "000", "000", "000", "111"},
+	'/': {"001", "001", "010", "100", "100"},
+	'(': {"001", "010", "010", "010", "001"},
+	')': {"100", "010", "010", "010", "100"},
+	'[': {"011", "010", "010", "010", "011"},
+	']': {"110", "010", "010", "010", "110"},
+	'=': {"000", "111", "000", "111", "000"},
+	'?': {"110", "001", "010", "000", "010"},
+	'!': {"010", "010", "010", "000", "010"},
+}
+
+var (
+	errorTileBackground = color.RGBA{0x60, 0x00, 0x00, 0xff}
+	errorTileHatch      = color.RGBA{0xe0, 0x20, 0x20, 0xff}
+	errorTileText       = color.RGBA{0xff, 0xff, 0xff, 0xff}
+)
+
+// red hatch with as much of the message as fits in the rectangle,
+// text is scaled up on big rectangles and wrapped by lines
+func drawErrorTile(img *image.RGBA, rect image.Rectangle, msg string) {
+	draw.Draw(img, rect, &image.Uniform{errorTileBackground}, image.Point{}, draw.Src)
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			if (x+y)%8 < 2 {
+				img.SetRGBA(x, y, errorTileHatch)
+			}
+		}
+	}
+	px := 1
+	for px < 4 && rect.Dx() >= 4*px*2*16 {
+		px *= 2
+	}
+	cols := (rect.Dx() - 1) / (4 * px)
+	rows := (rect.Dy() - 1) / (6 * px)
+	if cols < 1 || rows < 1 {
+		return
+	}
+	// single chunks on zoomed out tiles have no room for the message
+	if cols < 12 {
+		msg = "err"
+	}
+	lines := wrapErrorMessage(strings.ToUpper(msg), cols, rows)
+	for l, line := range lines {
+		for c, r := range line {
+			g, ok := errorTileGlyphs[r]
+			if !ok {
+				g = errorTileGlyphs['?']
+			}
+			x0, y0 := rect.Min.X+1+c*4*px, rect.Min.Y+1+l*6*px
+			// backdrop keeps text readable over the hatch
+			draw.Draw(img, image.Rect(x0-px/2, y0-px/2, x0+4*px, y0+6*px), &image.Uniform{errorTileBackground}, image.Point{}, draw.Src)
+			for gy := 0; gy < 5; gy++ {
+				for gx := 0; gx < 3; gx++ {
+					if g[gy][gx] == '1' {
+						draw.Draw(img, image.Rect(x0+gx*px, y0+gy*px, x0+gx*px+px, y0+gy*px+px), &image.Uniform{errorTileText}, image.Point{}, draw.Src)
+					}
+				}
+			}
+		}
+	}
+}
+
+func wrapErrorMessage(msg string, cols, rows int) []string {
+	ret := []string{}
+	for len(msg) > 0 && len(ret) < rows {
+		n := cols
+		if n >= len(msg) {
+			n = len(msg)
+		} else if i := strings.LastIndexByte(msg[:n+1], ' '); i > 0 {
+			n = i
+		}
+		ret = append(ret, strings.TrimSpace(msg[:n]))
+		msg = strings.TrimSpace(msg[n:])
+	}
+	return ret
+}
@@ -0,0 +1,224 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// farms are kept as a log of definitions, last one with the name wins
+type farmRecord struct {
+	Name             string
+	MinX, MinY, MinZ int
+	MaxX, MaxY, MaxZ int
+	Deleted          bool `json:",omitempty"`
+	Time             time.Time
+}
+
+type farmOutputBucket struct {
+	Time  time.Time
+	Items map[string]int
+}
+
+type farmOutput struct {
+	Farm       farmRecord
+	Containers int
+	Samples    int
+	From, To   time.Time
+	Total      map[string]int
+	PerHour    map[string]float64
+	Series     []farmOutputBucket
+}
+
+func listFarms(wname, dname string) ([]farmRecord, error) {
+	farms := map[string]farmRecord{}
+	err := recs.Read(wname, dname, "farms", func(m json.RawMessage) error {
+		var f farmRecord
+		if json.Unmarshal(m, &f) != nil {
+			return nil
+		}
+		if f.Deleted {
+			delete(farms, f.Name)
+		} else {
+			farms[f.Name] = f
+		}
+		return nil
+	})
+	ret := make([]farmRecord, 0, len(farms))
+	for _, f := range farms {
+		ret = append(ret, f)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	return ret, err
+}
+
+func (f farmRecord) contains(x, y, z int) bool {
+	return x >= f.MinX && x <= f.MaxX && y >= f.MinY && y <= f.MaxY && z >= f.MinZ && z <= f.MaxZ
+}
+
+// items disappearing from containers are taken by someone, only increases
+// between two samples of the same container are counted as produced
+func estimateFarmOutput(wname, dname string, f farmRecord, bucket time.Duration) (*farmOutput, error) {
+	ret := &farmOutput{
+		Farm:    f,
+		Total:   map[string]int{},
+		PerHour: map[string]float64{},
+		Series:  []farmOutputBucket{},
+	}
+	type cpos struct{ x, y, z int }
+	last := map[cpos]containerRecord{}
+	buckets := map[time.Time]map[string]int{}
+	err := recs.Read(wname, dname, "containers", func(m json.RawMessage) error {
+		var c containerRecord
+		if json.Unmarshal(m, &c) != nil || !f.contains(c.X, c.Y, c.Z) {
+			return nil
+		}
+		ret.Samples++
+		if ret.From.IsZero() || c.Time.Before(ret.From) {
+			ret.From = c.Time
+		}
+		if c.Time.After(ret.To) {
+			ret.To = c.Time
+		}
+		p := cpos{c.X, c.Y, c.Z}
+		prev, ok := last[p]
+		last[p] = c
+		if !ok {
+			return nil
+		}
+		bt := c.Time.Truncate(bucket)
+		for name, count := range c.Items {
+			delta := count - prev.Items[name]
+			if delta <= 0 {
+				continue
+			}
+			if buckets[bt] == nil {
+				buckets[bt] = map[string]int{}
+			}
+			buckets[bt][name] += delta
+			ret.Total[name] += delta
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	ret.Containers = len(last)
+	for t, items := range buckets {
+		ret.Series = append(ret.Series, farmOutputBucket{Time: t, Items: items})
+	}
+	sort.Slice(ret.Series, func(i, j int) bool {
+		return ret.Series[i].Time.Before(ret.Series[j].Time)
+	})
+	if hours := ret.To.Sub(ret.From).Hours(); hours > 0 {
+		for name, count := range ret.Total {
+			ret.PerHour[name] = float64(count) / hours
+		}
+	}
+	return ret, nil
+}
+
+func apiListFarms(w http.ResponseWriter, r *http.Request) (int, string) {
+	params := mux.Vars(r)
+	farms, err := listFarms(params["world"], params["dim"])
+	if err != nil {
+		return 500, err.Error()
+	}
+	setContentTypeJson(w)
+	return marshalOrFail(200, farms)
+}
+
+func apiAddFarm(w http.ResponseWriter, r *http.Request) (int, string) {
+	params := mux.Vars(r)
+	name := r.FormValue("name")
+	if name == "" {
+		return 400, "Empty name"
+	}
+	q, err := parseFormInts(r, "x0", "y0", "z0", "x1", "y1", "z1")
+	if err != nil {
+		return 400, err.Error()
+	}
+	f := farmRecord{
+		Name: name,
+		MinX: minInt(q[0], q[3]),
+		MinY: minInt(q[1], q[4]),
+		MinZ: minInt(q[2], q[5]),
+		MaxX: maxInt(q[0], q[3]),
+		MaxY: maxInt(q[1], q[4]),
+		MaxZ: maxInt(q[2], q[5]),
+		Time: time.Now(),
+	}
+	err = recs.Append(params["world"], params["dim"], "farms", f)
+	if err != nil {
+		return 500, err.Error()
+	}
+	setContentTypeJson(w)
+	return marshalOrFail(200, f)
+}
+
+func apiDeleteFarm(_ http.ResponseWriter, r *http.Request) (int, string) {
+	params := mux.Vars(r)
+	err := recs.Append(params["world"], params["dim"], "farms", farmRecord{
+		Name:    params["farm"],
+		Deleted: true,
+		Time:    time.Now(),
+	})
+	if err != nil {
+		return 500, err.Error()
+	}
+	return 200, "Farm deleted"
+}
+
+func apiFarmOutput(w http.ResponseWriter, r *http.Request) (int, string) {
+	params := mux.Vars(r)
+	wname, dname := params["world"], params["dim"]
+	bucket := time.Hour
+	if r.FormValue("bucket") != "" {
+		q, err := parseFormInts(r, "bucket")
+		if err != nil || q[0] <= 0 {
+			return 400, "Bad bucket size"
+		}
+		bucket = time.Duration(q[0]) * time.Second
+	}
+	farms, err := listFarms(wname, dname)
+	if err != nil {
+		return 500, err.Error()
+	}
+	for _, f := range farms {
+		if f.Name != params["farm"] {
+			continue
+		}
+		out, err := estimateFarmOutput(wname, dname, f, bucket)
+		if err != nil {
+			return 500, err.Error()
+		}
+		setContentTypeJson(w)
+		return marshalOrFail(200, out)
+	}
+	return 404, "Farm not found"
+}
@@ -0,0 +1,84 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// extra tile source shown on dimension pages, url is Leaflet template
+// where {world} and {dim} are filled in too, options go to L.tileLayer
+type frontendLayer struct {
+	Name    string         `json:"name"`
+	URL     string         `json:"url"`
+	Overlay bool           `json:"overlay"`
+	Worlds  []string       `json:"worlds,omitempty"`
+	Options map[string]any `json:"options,omitempty"`
+}
+
+// site specific additions to pages, hooks are bodies of functions called
+// with event detail when page fires webchunk:<name> event
+type frontendConfig struct {
+	Scripts []string          `json:"scripts"`
+	Styles  []string          `json:"styles"`
+	Layers  []frontendLayer   `json:"layers"`
+	Hooks   map[string]string `json:"hooks"`
+}
+
+var (
+	frontend        = &frontendConfig{}
+	frontendModTime time.Time
+	frontendLock    sync.Mutex
+)
+
+// file is read again when it changes so pages pick up edits without restart
+func getFrontendConfig() *frontendConfig {
+	frontendLock.Lock()
+	defer frontendLock.Unlock()
+	path := cfg.GetDSString("./frontend.json", "web", "frontend_path")
+	st, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		frontend = &frontendConfig{}
+		frontendModTime = time.Time{}
+		return frontend
+	}
+	if err != nil || st.ModTime().Equal(frontendModTime) {
+		return frontend
+	}
+	frontendModTime = st.ModTime()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		log.Printf("Failed to read frontend config: %s", err.Error())
+		return frontend
+	}
+	f := &frontendConfig{}
+	if err := json.Unmarshal(b, f); err != nil {
+		log.Printf("Failed to parse frontend config, keeping previous one: %s", err.Error())
+		return frontend
+	}
+	frontend = f
+	return frontend
+}
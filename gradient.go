@@ -0,0 +1,195 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"image/color"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/maxsupermanhd/lac"
+)
+
+type gradientStop struct {
+	Y     int    `json:"y" mapstructure:"y"`
+	Color string `json:"color" mapstructure:"color"`
+}
+
+var gradientPresets = map[string][]gradientStop{
+	"classic":   {{-64, "#0000ffff"}, {320, "#ffffffff"}},
+	"grayscale": {{-64, "#000000ff"}, {320, "#ffffffff"}},
+	"terrain": {
+		{-64, "#000033ff"}, {0, "#0033aaff"}, {62, "#3399ffff"}, {63, "#e8d8a0ff"},
+		{70, "#4caf50ff"}, {100, "#2e7d32ff"}, {140, "#8d6e63ff"}, {190, "#9e9e9eff"}, {240, "#ffffffff"},
+	},
+	"viridis": {
+		{-64, "#440154ff"}, {0, "#3b528bff"}, {64, "#21918cff"}, {128, "#5ec962ff"}, {320, "#fde725ff"},
+	},
+}
+
+const gradientDefaultPreset = "classic"
+
+// presets that are stretched from overworld height to height of the dimension,
+// terrain one is tied to sea level so it stays as is
+var gradientStretchedPresets = map[string]bool{
+	"classic":   true,
+	"grayscale": true,
+	"viridis":   true,
+}
+
+type heightGradient []struct {
+	y int
+	c color.RGBA
+}
+
+func parseGradientStops(stops []gradientStop) (heightGradient, error) {
+	if len(stops) == 0 {
+		return nil, errors.New("gradient needs at least one stop")
+	}
+	ret := heightGradient{}
+	for _, s := range stops {
+		c, err := ParseHexColor(s.Color)
+		if err != nil {
+			return nil, errors.New("bad color of stop at y " + strconv.Itoa(s.Y) + ": " + err.Error())
+		}
+		ret = append(ret, struct {
+			y int
+			c color.RGBA
+		}{s.Y, color.RGBA{uint8(c.R >> 8), uint8(c.G >> 8), uint8(c.B >> 8), uint8(c.A >> 8)}})
+	}
+	sort.SliceStable(ret, func(i, j int) bool { return ret[i].y < ret[j].y })
+	return ret, nil
+}
+
+// stops from config override preset
+func getHeightGradient(hr heightRange) heightGradient {
+	stops := []gradientStop{}
+	err := cfg.GetToStruct(&stops, "layers", "heightmap", "stops")
+	if err == nil && len(stops) > 0 {
+		g, err := parseGradientStops(stops)
+		if err == nil {
+			return g
+		}
+		log.Printf("Bad heightmap gradient stops, using preset: %s", err.Error())
+	} else if err != nil && !errors.Is(err, lac.ErrNoKey) {
+		log.Printf("Failed to parse heightmap gradient stops, using preset: %s", err.Error())
+	}
+	name := cfg.GetDSString(gradientDefaultPreset, "layers", "heightmap", "gradient")
+	preset, ok := gradientPresets[name]
+	if !ok {
+		name = gradientDefaultPreset
+		preset = gradientPresets[name]
+	}
+	g, _ := parseGradientStops(preset)
+	if gradientStretchedPresets[name] {
+		g = g.stretch(defaultHeightRange, hr)
+	}
+	return g
+}
+
+func (g heightGradient) stretch(from, to heightRange) heightGradient {
+	if from == to || from.top <= from.bottom || to.top <= to.bottom {
+		return g
+	}
+	ret := make(heightGradient, len(g))
+	for i, s := range g {
+		ret[i] = s
+		ret[i].y = to.bottom + (s.y-from.bottom)*(to.top-to.bottom)/(from.top-from.bottom)
+	}
+	return ret
+}
+
+func (g heightGradient) at(y int) color.RGBA {
+	if y <= g[0].y {
+		return g[0].c
+	}
+	for i := 1; i < len(g); i++ {
+		if y > g[i].y {
+			continue
+		}
+		a, b := g[i-1], g[i]
+		f := float64(y-a.y) / float64(b.y-a.y)
+		lerp := func(x, y uint8) uint8 { return uint8(float64(x) + (float64(y)-float64(x))*f) }
+		return color.RGBA{lerp(a.c.R, b.c.R), lerp(a.c.G, b.c.G), lerp(a.c.B, b.c.B), lerp(a.c.A, b.c.A)}
+	}
+	return g[len(g)-1].c
+}
+
+type heightGradientConfig struct {
+	Preset  string         `json:"preset"`
+	Stops   []gradientStop `json:"stops"`
+	Presets []string       `json:"presets,omitempty"`
+}
+
+func apiGetHeightGradient(w http.ResponseWriter, _ *http.Request) (int, string) {
+	ret := heightGradientConfig{
+		Preset: cfg.GetDSString(gradientDefaultPreset, "layers", "heightmap", "gradient"),
+		Stops:  []gradientStop{},
+	}
+	err := cfg.GetToStruct(&ret.Stops, "layers", "heightmap", "stops")
+	if err != nil && !errors.Is(err, lac.ErrNoKey) {
+		return 500, "Failed to read stops: " + err.Error()
+	}
+	for k := range gradientPresets {
+		ret.Presets = append(ret.Presets, k)
+	}
+	sort.Strings(ret.Presets)
+	setContentTypeJson(w)
+	return marshalOrFail(200, ret)
+}
+
+// empty stops make preset used again, already cached tiles are not redrawn
+func apiSetHeightGradient(w http.ResponseWriter, r *http.Request) (int, string) {
+	var req heightGradientConfig
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return bodyReadErrorStatus(err), "Bad gradient: " + err.Error()
+	}
+	if req.Preset == "" {
+		req.Preset = gradientDefaultPreset
+	}
+	if _, ok := gradientPresets[req.Preset]; !ok {
+		return 400, "Unknown preset"
+	}
+	if len(req.Stops) > 64 {
+		return 400, "Too many stops"
+	}
+	if len(req.Stops) > 0 {
+		if _, err := parseGradientStops(req.Stops); err != nil {
+			return 400, err.Error()
+		}
+	}
+	stops := []any{}
+	for _, s := range req.Stops {
+		stops = append(stops, map[string]any{"y": s.Y, "color": s.Color})
+	}
+	cfg.Set(req.Preset, "layers", "heightmap", "gradient")
+	cfg.Set(stops, "layers", "heightmap", "stops")
+	if err := saveConfig(); err != nil {
+		return 500, "Failed to save config: " + err.Error()
+	}
+	req.Presets = nil
+	setContentTypeJson(w)
+	return marshalOrFail(200, req)
+}
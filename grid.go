@@ -0,0 +1,109 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"strconv"
+
+	"github.com/maxsupermanhd/WebChunk/primitives"
+)
+
+type gridStyle struct {
+	chunkColor, regionColor, labelColor color.RGBA
+}
+
+func getGridStyle() gridStyle {
+	parse := func(def string, path ...string) color.RGBA {
+		c, err := ParseHexColor(cfg.GetDSString(def, path...))
+		if err != nil {
+			c, _ = ParseHexColor(def)
+		}
+		return color.RGBA{uint8(c.R >> 8), uint8(c.G >> 8), uint8(c.B >> 8), uint8(c.A >> 8)}
+	}
+	return gridStyle{
+		chunkColor:  parse("#00000040", "layers", "grid", "chunk_color"),
+		regionColor: parse("#000000c0", "layers", "grid", "region_color"),
+		labelColor:  parse("#ffffffff", "layers", "grid", "label_color"),
+	}
+}
+
+// chunk lines are drawn while chunk is at least 4 pixels wide and region
+// lines while region is, regions are labeled while they are at least 64
+// pixels wide and every fourth chunk at full zoom
+func drawGridTile(loc primitives.ImageLocation) *image.RGBA {
+	style := getGridStyle()
+	bpp := labelBlocksPerPixel(loc.S)
+	size := minInt(16<<loc.S, 512)
+	tx, tz := loc.X*size, loc.Z*size
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	drawLines := func(every int, c color.RGBA) {
+		if every/bpp < 4 {
+			return
+		}
+		for p := 0; p < size; p++ {
+			if ((tx+p)*bpp)%every == 0 {
+				for q := 0; q < size; q++ {
+					blendBorderPixel(img, p, q, c, 1)
+				}
+			}
+			if ((tz+p)*bpp)%every == 0 {
+				for q := 0; q < size; q++ {
+					if ((tx+q)*bpp)%every != 0 {
+						blendBorderPixel(img, q, p, c, 1)
+					}
+				}
+			}
+		}
+	}
+	drawLines(16, style.chunkColor)
+	drawLines(512, style.regionColor)
+	label := func(text string, bx, bz int) {
+		p := labelPlacement{
+			label:      mapLabel{Text: text},
+			x0:         floorDiv(bx, bpp) + 1,
+			z0:         floorDiv(bz, bpp) + 1,
+			fg:         style.labelColor,
+			pixelScale: 1,
+		}
+		drawLabel(img, p, tx, tz)
+	}
+	if 512/bpp >= 64 {
+		x0, z0 := floorDiv(tx*bpp, 512)*512, floorDiv(tz*bpp, 512)*512
+		for bz := z0; bz < (tz+size)*bpp; bz += 512 {
+			for bx := x0; bx < (tx+size)*bpp; bx += 512 {
+				label("R "+strconv.Itoa(bx/512)+" "+strconv.Itoa(bz/512), bx, bz)
+			}
+		}
+	}
+	if bpp == 1 {
+		for bz := floorDiv(tz, 64) * 64; bz < tz+size; bz += 64 {
+			for bx := floorDiv(tx, 64) * 64; bx < tx+size; bx += 64 {
+				if bx%512 == 0 && bz%512 == 0 {
+					continue
+				}
+				label(strconv.Itoa(bx/16)+" "+strconv.Itoa(bz/16), bx, bz)
+			}
+		}
+	}
+	return img
+}
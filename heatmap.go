@@ -0,0 +1,260 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/maxsupermanhd/WebChunk/chunkStorage"
+	"github.com/maxsupermanhd/go-vmc/v764/save"
+	"github.com/maxsupermanhd/lac"
+)
+
+// colors evenly spread from lowest to highest value
+var heatPalettes = map[string][]string{
+	"heat":      {"#0000ffa0", "#00ffffa0", "#ffff00a0", "#ff0000a0"},
+	"red":       {"#ff000000", "#ff0000ff"},
+	"magenta":   {"#ff00ff00", "#ff00ffff"},
+	"grayscale": {"#00000000", "#000000ff"},
+	"viridis":   {"#440154a0", "#3b528ba0", "#21918ca0", "#5ec962a0", "#fde725a0"},
+	"magma":     {"#000004a0", "#51127ca0", "#b73779a0", "#fc8961a0", "#fcfdbfa0"},
+	"age":       {"#00c853a0", "#ffeb3ba0", "#ff9800a0", "#d50000a0"},
+}
+
+// layer drawn as one color per chunk from a single value of it
+type heatLayer struct {
+	data    func(s chunkStorage.ChunkStorage) chunkDataProviderFunc
+	value   func(i interface{}) float64
+	palette string
+	log     bool
+	max     func() float64
+}
+
+func heatMax(layer string, def int) func() float64 {
+	return func() float64 {
+		return float64(cfg.GetDSInt(def, "layers", layer, "max"))
+	}
+}
+
+func chunkRegion(s chunkStorage.ChunkStorage) chunkDataProviderFunc {
+	return s.GetChunksRegion
+}
+
+func chunkCountRegion(s chunkStorage.ChunkStorage) chunkDataProviderFunc {
+	return s.GetChunksCountRegion
+}
+
+var heatLayers = map[string]heatLayer{
+	"counttilesheat": {
+		data:    chunkCountRegion,
+		value:   func(i interface{}) float64 { return float64(i.(int)) },
+		palette: "red",
+		max:     heatMax("counttilesheat", 8),
+	},
+	"portalsheat": {
+		data: chunkRegion,
+		value: func(i interface{}) float64 {
+			c := i.(save.Chunk)
+			return float64(countPortalBlocks(&c))
+		},
+		palette: "red",
+		max:     heatMax("portalsheat", 32),
+	},
+	"chestheat": {
+		data: chunkRegion,
+		value: func(i interface{}) float64 {
+			c := i.(save.Chunk)
+			return float64(countChestBlocks(&c))
+		},
+		palette: "heat",
+		log:     true,
+		max:     heatMax("chestheat", 32),
+	},
+	// log scale so briefly visited chunks are still visible next to bases,
+	// proxied chunks do not have inhabited time and stay empty
+	"inhabited": {
+		data: chunkRegion,
+		value: func(i interface{}) float64 {
+			return float64(i.(save.Chunk).InhabitedTime)
+		},
+		palette: "heat",
+		log:     true,
+		max: func() float64 {
+			return float64(cfg.GetDSInt(50, "layers", "inhabited", "max_hours")) * 20 * 60 * 60
+		},
+	},
+	// hours since chunk was last stored, log scale so recent days are told apart
+	"chunkage": {
+		data: func(s chunkStorage.ChunkStorage) chunkDataProviderFunc {
+			return s.GetChunksModDateRegion
+		},
+		value: func(i interface{}) float64 {
+			// chunks stored just now still get the first color
+			return math.Max(time.Since(i.(time.Time)).Hours(), 0.001)
+		},
+		palette: "age",
+		log:     true,
+		max: func() float64 {
+			return float64(cfg.GetDSInt(30, "layers", "chunkage", "max_days")) * 24
+		},
+	},
+	"mobheat": {
+		data: func(_ chunkStorage.ChunkStorage) chunkDataProviderFunc {
+			return getEntityDensityRegion
+		},
+		value:   func(i interface{}) float64 { return float64(i.(int)) },
+		palette: "magenta",
+		max:     heatMax("mobheat", 16),
+	},
+}
+
+// palettes from config are added to built in ones and can replace them
+func getHeatPalette(name string) ([]color.RGBA, bool) {
+	conf := map[string][]string{}
+	err := cfg.GetToStruct(&conf, "layers", "heat_palettes")
+	if err != nil && !errors.Is(err, lac.ErrNoKey) {
+		log.Printf("Failed to parse heat palettes: %s", err.Error())
+	}
+	stops, ok := conf[name]
+	if !ok {
+		stops, ok = heatPalettes[name]
+	}
+	if !ok || len(stops) == 0 {
+		return nil, false
+	}
+	ret := []color.RGBA{}
+	for _, v := range stops {
+		c, err := ParseHexColor(v)
+		if err != nil {
+			log.Printf("Bad color in heat palette [%s]: %s", name, err.Error())
+			return nil, false
+		}
+		ret = append(ret, color.RGBA{uint8(c.R >> 8), uint8(c.G >> 8), uint8(c.B >> 8), uint8(c.A >> 8)})
+	}
+	return ret, true
+}
+
+func heatPaletteAt(p []color.RGBA, t float64) color.RGBA {
+	t = math.Max(0, math.Min(1, t))
+	if len(p) == 1 {
+		return p[0]
+	}
+	f := t * float64(len(p)-1)
+	i := int(f)
+	if i >= len(p)-1 {
+		i = len(p) - 2
+	}
+	f -= float64(i)
+	a, b := p[i], p[i+1]
+	lerp := func(x, y uint8) uint8 { return uint8(float64(x) + (float64(y)-float64(x))*f) }
+	return color.RGBA{lerp(a.R, b.R), lerp(a.G, b.G), lerp(a.B, b.B), lerp(a.A, b.A)}
+}
+
+// empty palette or scale are taken from config and then layer defaults
+type heatOptions struct {
+	palette, scale string
+}
+
+func heatProvider(name string, opts heatOptions) ttypeProviderFunc {
+	l := heatLayers[name]
+	return func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
+		return l.data(s), func(i interface{}) *image.RGBA {
+			return drawHeat(name, l, opts, l.value(i))
+		}
+	}
+}
+
+func drawHeat(name string, l heatLayer, opts heatOptions, v float64) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	if v <= 0 {
+		return img
+	}
+	if opts.palette == "" {
+		opts.palette = cfg.GetDSString(l.palette, "layers", name, "palette")
+	}
+	p, ok := getHeatPalette(opts.palette)
+	if !ok {
+		p, _ = getHeatPalette(l.palette)
+	}
+	if opts.scale == "" {
+		opts.scale = "linear"
+		if l.log {
+			opts.scale = "log"
+		}
+		opts.scale = cfg.GetDSString(opts.scale, "layers", name, "scale")
+	}
+	max := math.Max(l.max(), 1)
+	t := v / max
+	if opts.scale == "log" {
+		t = math.Log1p(v) / math.Log1p(max)
+	}
+	draw.Draw(img, img.Bounds(), &image.Uniform{heatPaletteAt(p, t)}, image.Point{}, draw.Src)
+	return img
+}
+
+// every palette and scale given in query is cached as its own variant
+func heatParamLayer(name string) paramLayer {
+	return paramLayer{
+		variant: func(r *http.Request) (string, error) {
+			opts := heatOptions{
+				palette: r.URL.Query().Get("palette"),
+				scale:   r.URL.Query().Get("scale"),
+			}
+			if opts.palette == "" && opts.scale == "" {
+				return name, nil
+			}
+			if opts.palette != "" {
+				if strings.ContainsAny(opts.palette, "_/") {
+					return "", errors.New("bad palette name")
+				}
+				if _, ok := getHeatPalette(opts.palette); !ok {
+					return "", errors.New("unknown palette")
+				}
+			}
+			if opts.scale != "" && opts.scale != "linear" && opts.scale != "log" {
+				return "", errors.New("scale must be linear or log")
+			}
+			return name + "_p" + opts.palette + "_s" + opts.scale, nil
+		},
+		provider: func(variant string) (ttypeProviderFunc, bool) {
+			if variant == name {
+				return heatProvider(name, heatOptions{}), true
+			}
+			rest, ok := strings.CutPrefix(variant, name+"_p")
+			if !ok {
+				return nil, false
+			}
+			i := strings.LastIndex(rest, "_s")
+			if i < 0 {
+				return nil, false
+			}
+			return heatProvider(name, heatOptions{palette: rest[:i], scale: rest[i+2:]}), true
+		},
+	}
+}
@@ -2,20 +2,18 @@ package main
 
 import (
 	"log"
-	"sort"
 
 	"github.com/maxsupermanhd/go-vmc/v764/save"
 )
 
 func genHeightmap(chunk *save.Chunk) []int {
 	// TODO: this is a crutch, should be using MOTION_BLOCKING or WORLD_SURFACE heightmap from server if available
-	sort.Slice(chunk.Sections, func(i, j int) bool {
-		return int8(chunk.Sections[i].Y) > int8(chunk.Sections[j].Y)
-	})
+	sortSectionsTopDown(chunk)
 	var height [16 * 16]int
 	var set [16 * 16]bool
 	for _, s := range chunk.Sections {
-		if len(s.BlockStates.Data) == 0 {
+		// sections filled with one block have no data but still count
+		if len(s.BlockStates.Palette) == 0 || isAirPalette(s.BlockStates.Palette) {
 			continue
 		}
 		states := prepareSectionBlockIDs(&s)
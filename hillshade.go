@@ -0,0 +1,67 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"time"
+)
+
+// slopes facing the light are lightened and ones facing away darkened,
+// flat ground is left as is, azimuth is clockwise from north in degrees
+func drawChunkHillshade(chunkContext ContextedChunkData) (img *image.RGBA) {
+	t := time.Now()
+	img = image.NewRGBA(image.Rect(0, 0, 16, 16))
+	azimuth := float64(cfg.GetDSInt(315, "layers", "hillshade", "azimuth")) * math.Pi / 180
+	elevation := float64(clampInt(cfg.GetDSInt(45, "layers", "hillshade", "elevation"), 1, 89)) * math.Pi / 180
+	// north is -z on the map
+	lx := math.Sin(azimuth) * math.Cos(elevation)
+	lz := -math.Cos(azimuth) * math.Cos(elevation)
+	ly := math.Sin(elevation)
+	h := contextHeights(chunkContext, 1)
+	at := func(x, z int) float64 {
+		return float64(h.at(x, z))
+	}
+	for z := 0; z < 16; z++ {
+		for x := 0; x < 16; x++ {
+			dx := (at(x+1, z) - at(x-1, z)) / 2
+			dz := (at(x, z+1) - at(x, z-1)) / 2
+			shade := (-dx*lx - dz*lz + ly) / math.Sqrt(dx*dx+dz*dz+1)
+			if shade < ly {
+				img.Set(x, z, color.RGBA{0, 0, 0, uint8(160 * math.Min(1, (ly-shade)/ly))})
+			} else {
+				img.Set(x, z, color.RGBA{255, 255, 255, uint8(96 * (shade - ly) / (1 - ly))})
+			}
+		}
+	}
+	appendMetrics(time.Since(t), "hillshade")
+	return img
+}
+
+func drawHillshadedTerrain(chunkContext ContextedChunkData) *image.RGBA {
+	img := drawChunk(chunkContext.center)
+	sh := drawChunkHillshade(chunkContext)
+	draw.Draw(img, img.Rect, sh, image.Point{}, draw.Over)
+	return img
+}
@@ -1,17 +1,19 @@
 package main
 
 import (
+	"context"
 	"image"
-	"image/draw"
 	"log"
 	"runtime/debug"
 
 	"github.com/maxsupermanhd/WebChunk/chunkStorage"
 	"github.com/maxsupermanhd/WebChunk/primitives"
-	"github.com/nfnt/resize"
 )
 
 func imageGetSync(loc primitives.ImageLocation, ignoreCache bool) (*image.RGBA, error) {
+	if tp, ok := tilePainters[loc.Variant]; ok {
+		return tp(loc), nil
+	}
 	if !ignoreCache {
 		i := imageCacheGetBlockingLoc(loc)
 		if i != nil {
@@ -37,11 +39,14 @@ func renderTile(loc primitives.ImageLocation) (*image.RGBA, error) {
 	}
 	ff := *f
 
-	_, s, err := chunkStorage.GetWorldStorage(storages, loc.World)
+	_, s, err := storages.World(loc.World)
 	if err != nil {
 		return nil, nil
 	}
 	getter, painter := ff(s)
+	if tileIsSampled(loc.S) {
+		return renderSampledTile(context.Background(), loc, getter, painter)
+	}
 
 	scale := 1
 	if loc.S > 0 {
@@ -64,40 +69,44 @@ func renderTile(loc primitives.ImageLocation) (*image.RGBA, error) {
 	if len(cc) == 0 {
 		return nil, nil
 	}
-	for _, c := range cc {
-		// TODO: break on cancel
+	downscale := layerDownscaler(loc.Variant)
+	forEachChunkParallel(context.Background(), cc, func(c chunkStorage.ChunkData) {
 		placex := int(c.X - offsetx)
 		placey := int(c.Z - offsety)
-		var chunk *image.RGBA
-		chunk = func(d interface{}) *image.RGBA {
+		chunk := func(d interface{}) (ret *image.RGBA) {
 			defer func() {
 				if err := recover(); err != nil {
 					log.Println(loc.X, loc.Z, err) // TODO: pass error outwards
 					debug.PrintStack()
+					ret = nil
 				}
-				chunk = nil
 			}()
-			var ret *image.RGBA
-			ret = nil
-			ret = painter(d)
-			return ret
+			return painter(d)
 		}(c.Data)
 		if chunk == nil {
-			continue
+			return
 		}
-		tile := resize.Resize(uint(imagescale), uint(imagescale), chunk, resize.NearestNeighbor)
-		draw.Draw(img, image.Rect(placex*int(imagescale), placey*int(imagescale), placex*int(imagescale)+imagescale, placey*int(imagescale)+imagescale),
-			tile, image.Pt(0, 0), draw.Over)
-	}
+		downscale(img, image.Rect(placex*int(imagescale), placey*int(imagescale), placex*int(imagescale)+imagescale, placey*int(imagescale)+imagescale), chunk)
+	})
 	return img, nil
 }
 
 func findTTypeProviderFunc(loc primitives.ImageLocation) *ttypeProviderFunc {
-	for tt := range ttypes {
+	for tt, p := range ttypes.All() {
 		if tt.Name == loc.Variant {
-			f := ttypes[tt]
+			f := withChunkMemo(loc.Variant, withLayerFallbacks(tt.Name, p))
 			return &f // TODO: fix this ugly thing
 		}
 	}
+	for name, pl := range paramLayers {
+		if p, ok := pl.provider(loc.Variant); ok {
+			f := withChunkMemo(loc.Variant, withLayerFallbacks(name, p))
+			return &f
+		}
+	}
+	if p, ok := scriptLayerProvider(loc.Variant); ok {
+		p = withChunkMemo(loc.Variant, p)
+		return &p
+	}
 	return nil
 }
@@ -45,6 +45,7 @@ type CachedImage struct {
 	lastUse       time.Time
 	ModTime       time.Time
 	imageUnloaded bool
+	warmedUntil   time.Time
 }
 
 type cacheTask struct {
@@ -67,6 +68,11 @@ type ImageCache struct {
 	wg                  sync.WaitGroup
 	cacheStatLen        atomic.Int64
 	cacheStatUncommited atomic.Int64
+	redis               *redisTier
+	invalidations       chan primitives.ImageLocation
+	access              map[primitives.ImageLocation]int64
+	accessChanged       bool
+	warmed              chan *CachedImage
 }
 
 func NewImageCache(logger *log.Logger, cfg *lac.ConfSubtree, ctx context.Context) *ImageCache {
@@ -87,6 +93,14 @@ func NewImageCache(logger *log.Logger, cfg *lac.ConfSubtree, ctx context.Context
 		cache:       map[primitives.ImageLocation]*CachedImage{},
 		cacheReturn: map[primitives.ImageLocation][]*cacheTask{},
 		backlog:     list.New(),
+		access:      map[primitives.ImageLocation]int64{},
+		warmed:      make(chan *CachedImage),
+	}
+	c.redis = c.newRedisTier()
+	if c.redis != nil {
+		c.invalidations = make(chan primitives.ImageLocation, taskQueueLen)
+		c.wg.Add(1)
+		go c.redisSubscriber()
 	}
 	c.wg.Add(ioProcessors)
 	for i := 0; i < ioProcessors; i++ {
@@ -95,6 +109,8 @@ func NewImageCache(logger *log.Logger, cfg *lac.ConfSubtree, ctx context.Context
 			c.wg.Done()
 		}()
 	}
+	c.loadAccessHistory()
+	c.startWarming()
 	go c.processor()
 	return c
 }
@@ -113,17 +129,26 @@ processorLoop:
 		case <-c.ctx.Done():
 			break processorLoop
 		case task := <-c.tasks:
+			if task.img == nil && task.loc.S <= StorageLevel {
+				c.recordAccess(getStorageLevelLoc(task.loc))
+			}
 			c.processTask(task)
 		case ret := <-c.ioReturn:
 			c.processReturn(ret)
+		case loc := <-c.invalidations:
+			c.processInvalidation(loc)
+		case img := <-c.warmed:
+			c.processWarmed(img)
 		case <-autosaveTimer.C:
 			c.processSave()
+			c.processAccessSave()
 		case <-unloadTimer.C:
 			c.processUnload()
 		}
 	}
 
 	c.processSave()
+	c.processAccessSave()
 
 	close(c.ioTasks)
 
@@ -135,7 +160,7 @@ func (c *ImageCache) processUnload() {
 	notsynced := int64(0)
 	for k, v := range c.cache {
 		if v.SyncedToDisk {
-			if time.Since(v.lastUse) > interval {
+			if time.Since(v.lastUse) > interval && time.Now().After(v.warmedUntil) {
 				delete(c.cache, k)
 			}
 		} else {
@@ -289,20 +314,22 @@ func (c *ImageCache) processImageSet(task *cacheTask) {
 		c.logger.Printf("Set of non-native and non-zero scaled image %s", task.loc.String())
 		return
 	}
-	t, ok := c.cache[task.loc]
+	// chunk sized images are drawn into their storage level image
+	loc := getStorageLevelLoc(task.loc)
+	t, ok := c.cache[loc]
 	if !ok {
 		c.ioTasks <- &cacheTaskIO{
-			loc: task.loc,
+			loc: loc,
 			img: nil,
 			err: nil,
 		}
 		t = &CachedImage{
 			Img:           image.NewRGBA(image.Rect(0, 0, 512, 512)),
-			Loc:           getStorageLevelLoc(task.loc),
+			Loc:           loc,
 			lastUse:       time.Now(),
 			imageUnloaded: true,
 		}
-		c.cache[task.loc] = t
+		c.cache[loc] = t
 		c.cacheStatUncommited.Add(1)
 		c.cacheStatLen.Add(1)
 	}
@@ -316,6 +343,7 @@ func (c *ImageCache) processImageSet(task *cacheTask) {
 		draw.Draw(t.Img, r, task.img, image.Point{}, draw.Src)
 	} else if task.loc.S == StorageLevel {
 		draw.Draw(t.Img, t.Img.Rect, task.img, image.Point{}, draw.Src)
+		t.ModTime = time.Now()
 	}
 }
 
@@ -353,12 +381,14 @@ func (c *ImageCache) processCacheLoad(t *CachedImage, task *cacheTaskIO) {
 		c.logger.Printf("IO return at %s but already have loaded image in cache", task.loc.String())
 		return
 	}
+	t.SyncedToDisk = true
 	if t.Img != nil {
-		draw.Draw(task.img.Img, task.img.Img.Bounds(), t.Img, image.Point{}, draw.Src)
+		// keep what was set while image was loading, rest comes from disk
+		draw.Draw(task.img.Img, task.img.Img.Bounds(), t.Img, image.Point{}, draw.Over)
+		t.SyncedToDisk = false
 	}
 	t.Img = task.img.Img
 	t.imageUnloaded = false
-	t.SyncedToDisk = true
 }
 
 func (c *ImageCache) SetCachedImage(loc primitives.ImageLocation, img *image.RGBA) {
@@ -406,6 +436,7 @@ func (c *ImageCache) GetStats() map[string]any {
 		"task queue length":   len(c.tasks),
 		"cached images":       c.cacheStatLen.Load(),
 		"unwritten images":    c.cacheStatUncommited.Load(),
+		"redis tier":          c.redis != nil,
 	}
 }
 
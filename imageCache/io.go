@@ -1,6 +1,7 @@
 package imagecache
 
 import (
+	"bytes"
 	"image"
 	"image/draw"
 	"image/png"
@@ -57,23 +58,48 @@ func (c *ImageCache) cacheGetFilenameLoc(loc primitives.ImageLocation) string {
 }
 
 func (c *ImageCache) cacheSave(img *image.RGBA, loc primitives.ImageLocation) error {
-	storePath := c.cacheGetFilenameLoc(loc)
-	err := os.MkdirAll(path.Dir(storePath), 0764)
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
 	if err != nil {
 		return err
 	}
-	file, err := os.Create(storePath)
+	storePath := c.cacheGetFilenameLoc(loc)
+	err = os.MkdirAll(path.Dir(storePath), 0764)
 	if err != nil {
 		return err
 	}
-	err = png.Encode(file, img)
+	err = os.WriteFile(storePath, buf.Bytes(), 0666)
 	if err != nil {
 		return err
 	}
-	return file.Close()
+	if c.redis != nil {
+		// disk has it anyway, redis being down should not stop saving
+		if err := c.redis.put(loc, buf.Bytes(), time.Now()); err != nil {
+			c.logger.Printf("Failed to put %s to redis: %v", loc.String(), err)
+		}
+	}
+	return nil
 }
 
 func (c *ImageCache) cacheLoad(loc primitives.ImageLocation) (*CachedImage, error) {
+	if c.redis != nil {
+		data, mod, err := c.redis.get(loc)
+		if err != nil {
+			c.logger.Printf("Failed to get %s from redis: %v", loc.String(), err)
+		} else if data != nil {
+			ii, err := png.Decode(bytes.NewReader(data))
+			if err == nil {
+				return &CachedImage{
+					Img:          toRGBA(ii),
+					Loc:          loc,
+					SyncedToDisk: true,
+					lastUse:      time.Now(),
+					ModTime:      mod,
+				}, nil
+			}
+			c.logger.Printf("Failed to decode %s from redis: %v", loc.String(), err)
+		}
+	}
 	fp := c.cacheGetFilenameLoc(loc)
 	f, err := os.Open(fp)
 	if err != nil {
@@ -95,20 +121,8 @@ func (c *ImageCache) cacheLoad(loc primitives.ImageLocation) (*CachedImage, erro
 		os.Remove(fp)
 		return nil, err
 	}
-	if iirgba, ok := ii.(*image.RGBA); ok {
-		return &CachedImage{
-			Img:          iirgba,
-			Loc:          loc,
-			SyncedToDisk: true,
-			lastUse:      time.Now(),
-			ModTime:      c.getModTimeFp(fp),
-		}, nil
-	}
-	b := ii.Bounds()
-	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
-	draw.Draw(dst, dst.Bounds(), ii, b.Min, draw.Src)
 	return &CachedImage{
-		Img:          dst,
+		Img:          toRGBA(ii),
 		Loc:          loc,
 		SyncedToDisk: true,
 		lastUse:      time.Now(),
@@ -116,7 +130,22 @@ func (c *ImageCache) cacheLoad(loc primitives.ImageLocation) (*CachedImage, erro
 	}, nil
 }
 
+func toRGBA(ii image.Image) *image.RGBA {
+	if iirgba, ok := ii.(*image.RGBA); ok {
+		return iirgba
+	}
+	b := ii.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), ii, b.Min, draw.Src)
+	return dst
+}
+
 func (c *ImageCache) getModTimeLoc(loc primitives.ImageLocation) time.Time {
+	if c.redis != nil {
+		if mod, ok := c.redis.modTime(loc); ok {
+			return mod
+		}
+	}
 	return c.getModTimeFp(c.cacheGetFilenameLoc(loc))
 }
 
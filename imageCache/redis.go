@@ -0,0 +1,195 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package imagecache
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+	"github.com/maxsupermanhd/WebChunk/primitives"
+)
+
+// redisTier is shared between replicas, images are stored as hashes of
+// encoded png and modification time, every save is announced on the channel
+// so other replicas drop their copy and load the new one on next get
+type redisTier struct {
+	pool    *redis.Pool
+	prefix  string
+	channel string
+	ttl     int
+	id      string
+}
+
+type redisInvalidation struct {
+	From string
+	Loc  primitives.ImageLocation
+}
+
+func (c *ImageCache) newRedisTier() *redisTier {
+	url := c.cfg.GetDSString("", "redis", "url")
+	if url == "" {
+		return nil
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	return &redisTier{
+		pool: &redis.Pool{
+			MaxIdle:     c.cfg.GetDSInt(8, "redis", "maxIdle"),
+			IdleTimeout: 4 * time.Minute,
+			Dial: func() (redis.Conn, error) {
+				return redis.DialURL(url, redis.DialConnectTimeout(5*time.Second))
+			},
+		},
+		prefix:  c.cfg.GetDSString("webchunk:", "redis", "prefix"),
+		channel: c.cfg.GetDSString("webchunk:invalidate", "redis", "channel"),
+		ttl:     c.cfg.GetDSInt(3600, "redis", "ttl"),
+		id:      hex.EncodeToString(id),
+	}
+}
+
+func (r *redisTier) key(loc primitives.ImageLocation) string {
+	return fmt.Sprintf("%simg:%s:%s:%s:%d:%d:%d", r.prefix, loc.World, loc.Dimension, loc.Variant, loc.S, loc.X, loc.Z)
+}
+
+func (r *redisTier) put(loc primitives.ImageLocation, png []byte, mod time.Time) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+	k := r.key(loc)
+	inv, err := json.Marshal(redisInvalidation{From: r.id, Loc: loc})
+	if err != nil {
+		return err
+	}
+	conn.Send("MULTI")
+	conn.Send("HSET", k, "png", png, "mod", mod.UnixNano())
+	if r.ttl > 0 {
+		conn.Send("EXPIRE", k, r.ttl)
+	}
+	conn.Send("PUBLISH", r.channel, inv)
+	_, err = conn.Do("EXEC")
+	return err
+}
+
+// returns nil data if image is not there
+func (r *redisTier) get(loc primitives.ImageLocation) ([]byte, time.Time, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+	v, err := redis.Values(conn.Do("HMGET", r.key(loc), "png", "mod"))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	data, _ := redis.Bytes(v[0], nil)
+	mod, _ := redis.Int64(v[1], nil)
+	if data == nil {
+		return nil, time.Time{}, nil
+	}
+	return data, time.Unix(0, mod), nil
+}
+
+func (r *redisTier) modTime(loc primitives.ImageLocation) (time.Time, bool) {
+	conn := r.pool.Get()
+	defer conn.Close()
+	mod, err := redis.Int64(conn.Do("HGET", r.key(loc), "mod"))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, mod), true
+}
+
+// forwards invalidations from other replicas until cache shuts down,
+// reconnects if redis goes away
+func (c *ImageCache) redisSubscriber() {
+	defer c.wg.Done()
+	for c.ctx.Err() == nil {
+		err := c.redisSubscribe()
+		if c.ctx.Err() != nil {
+			return
+		}
+		c.logger.Printf("Redis subscription lost: %v", err)
+		select {
+		case <-c.ctx.Done():
+		case <-time.After(5 * time.Second):
+		}
+	}
+}
+
+func (c *ImageCache) redisSubscribe() error {
+	conn, err := c.redis.pool.Dial()
+	if err != nil {
+		return err
+	}
+	psc := redis.PubSubConn{Conn: conn}
+	defer psc.Close()
+	if err := psc.Subscribe(c.redis.channel); err != nil {
+		return err
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-c.ctx.Done():
+			psc.Unsubscribe()
+			psc.Close()
+		case <-stop:
+		}
+	}()
+	for {
+		switch v := psc.Receive().(type) {
+		case redis.Message:
+			var inv redisInvalidation
+			if err := json.Unmarshal(v.Data, &inv); err != nil {
+				c.logger.Printf("Bad redis invalidation message: %v", err)
+				continue
+			}
+			if inv.From == c.redis.id {
+				continue
+			}
+			select {
+			case c.invalidations <- inv.Loc:
+			case <-c.ctx.Done():
+				return nil
+			}
+		case redis.Subscription:
+			if v.Count == 0 {
+				return errors.New("unsubscribed")
+			}
+		case error:
+			return v
+		}
+	}
+}
+
+// unsaved changes are kept, whatever is saved last wins
+func (c *ImageCache) processInvalidation(loc primitives.ImageLocation) {
+	t, ok := c.cache[loc]
+	if !ok || !t.SyncedToDisk || t.imageUnloaded {
+		return
+	}
+	if _, waiting := c.cacheReturn[loc]; waiting {
+		return
+	}
+	delete(c.cache, loc)
+	c.cacheStatLen.Add(-1)
+}
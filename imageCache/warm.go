@@ -0,0 +1,155 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package imagecache
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/maxsupermanhd/WebChunk/primitives"
+)
+
+type accessRecord struct {
+	Loc   primitives.ImageLocation
+	Count int64
+}
+
+func (c *ImageCache) accessHistoryPath() string {
+	return path.Join(".", c.root, "access.json")
+}
+
+// counts gets of storage level images, called from processor only
+func (c *ImageCache) recordAccess(loc primitives.ImageLocation) {
+	c.access[loc]++
+	c.accessChanged = true
+}
+
+// keeps most requested images, counts from previous runs are halved
+// on load so what was popular long ago slowly goes away
+func (c *ImageCache) loadAccessHistory() {
+	b, err := os.ReadFile(c.accessHistoryPath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			c.logger.Printf("Failed to read image access history: %v", err)
+		}
+		return
+	}
+	recs := []accessRecord{}
+	if err := json.Unmarshal(b, &recs); err != nil {
+		c.logger.Printf("Failed to parse image access history: %v", err)
+		return
+	}
+	for _, v := range recs {
+		if v.Count/2 > 0 {
+			c.access[v.Loc] = v.Count / 2
+		}
+	}
+}
+
+func (c *ImageCache) sortedAccess() []accessRecord {
+	ret := make([]accessRecord, 0, len(c.access))
+	for k, v := range c.access {
+		ret = append(ret, accessRecord{Loc: k, Count: v})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Count > ret[j].Count
+	})
+	return ret
+}
+
+func (c *ImageCache) processAccessSave() {
+	if !c.accessChanged {
+		return
+	}
+	recs := c.sortedAccess()
+	if keep := c.cfg.GetDSInt(4096, "warm", "history"); len(recs) > keep {
+		recs = recs[:keep]
+		c.access = make(map[primitives.ImageLocation]int64, keep)
+		for _, v := range recs {
+			c.access[v.Loc] = v.Count
+		}
+	}
+	b, err := json.Marshal(recs)
+	if err != nil {
+		c.logger.Printf("Failed to marshal image access history: %v", err)
+		return
+	}
+	if err := os.MkdirAll(path.Dir(c.accessHistoryPath()), 0764); err != nil {
+		c.logger.Printf("Failed to save image access history: %v", err)
+		return
+	}
+	if err := os.WriteFile(c.accessHistoryPath(), b, 0666); err != nil {
+		c.logger.Printf("Failed to save image access history: %v", err)
+		return
+	}
+	c.accessChanged = false
+}
+
+// loads most viewed images in background so first requests after
+// restart hit memory, called before processor starts so access is not shared yet
+func (c *ImageCache) startWarming() {
+	count := c.cfg.GetDSInt(64, "warm", "images")
+	if count <= 0 {
+		return
+	}
+	recs := c.sortedAccess()
+	if len(recs) > count {
+		recs = recs[:count]
+	}
+	if len(recs) == 0 {
+		return
+	}
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		loaded := 0
+		for _, v := range recs {
+			if c.ctx.Err() != nil {
+				return
+			}
+			img, err := c.cacheLoad(v.Loc)
+			if err != nil || img == nil || img.Img == nil {
+				continue
+			}
+			select {
+			case c.warmed <- img:
+				loaded++
+			case <-c.ctx.Done():
+				return
+			}
+		}
+		c.logger.Printf("Warmed up image cache with %d most viewed images", loaded)
+	}()
+}
+
+// warmed image is kept for warm.keep seconds even if nobody asks for it,
+// anything already in cache is newer than what is on disk
+func (c *ImageCache) processWarmed(img *CachedImage) {
+	if _, ok := c.cache[img.Loc]; ok {
+		return
+	}
+	img.warmedUntil = time.Now().Add(time.Duration(c.cfg.GetDSInt(600, "warm", "keep")) * time.Second)
+	c.cache[img.Loc] = img
+	c.cacheStatLen.Add(1)
+}
@@ -64,7 +64,7 @@ func indexHandler(w http.ResponseWriter, r *http.Request) {
 		Online bool
 	}
 	st := []StorageData{}
-	for sn, s := range storages {
+	for sn, s := range storages.Snapshot() {
 		worlds := []WorldData{}
 		if s.Driver == nil {
 			st = append(st, StorageData{Name: sn, S: s, Worlds: worlds, Online: false})
@@ -0,0 +1,298 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/maxsupermanhd/WebChunk/primitives"
+	"github.com/maxsupermanhd/lac"
+)
+
+// text annotation baked into tiles of "labels" layer, shown while tile scale
+// is in [min_scale, max_scale] (0 is one chunk per tile)
+type mapLabel struct {
+	Text     string `json:"text" mapstructure:"text"`
+	X        int    `json:"x" mapstructure:"x"`
+	Z        int    `json:"z" mapstructure:"z"`
+	MinScale int    `json:"min_scale" mapstructure:"min_scale"`
+	MaxScale int    `json:"max_scale" mapstructure:"max_scale"`
+	Size     int    `json:"size" mapstructure:"size"`
+	Color    string `json:"color" mapstructure:"color"`
+	Priority int    `json:"priority" mapstructure:"priority"`
+}
+
+// placed label in pixels of the whole scale level
+type labelPlacement struct {
+	label      mapLabel
+	x0, z0     int
+	x1, z1     int
+	fg         color.RGBA
+	pixelScale int
+}
+
+const (
+	labelGlyphW = 5
+	labelGlyphH = 7
+)
+
+// 5x7 font, lowercase is drawn as uppercase and unknown runes as '?'
+var labelGlyphs = map[rune][labelGlyphH]string{
+	'A':  {"01110", "10001", "10001", "11111", "10001", "10001", "10001"},
+	'B':  {"11110", "10001", "10001", "11110", "10001", "10001", "11110"},
+	'C':  {"01110", "10001", "10000", "10000", "10000", "10001", "01110"},
+	'D':  {"11110", "10001", "10001", "10001", "10001", "10001", "11110"},
+	'E':  {"11111", "10000", "10000", "11110", "10000", "10000", "11111"},
+	'F':  {"11111", "10000", "10000", "11110", "10000", "10000", "10000"},
+	'G':  {"01110", "10001", "10000", "10111", "10001", "10001", "01111"},
+	'H':  {"10001", "10001", "10001", "11111", "10001", "10001", "10001"},
+	'I':  {"01110", "00100", "00100", "00100", "00100", "00100", "01110"},
+	'J':  {"00111", "00010", "00010", "00010", "00010", "10010", "01100"},
+	'K':  {"10001", "10010", "10100", "11000", "10100", "10010", "10001"},
+	'L':  {"10000", "10000", "10000", "10000", "10000", "10000", "11111"},
+	'M':  {"10001", "11011", "10101", "10101", "10001", "10001", "10001"},
+	'N':  {"10001", "10001", "11001", "10101", "10011", "10001", "10001"},
+	'O':  {"01110", "10001", "10001", "10001", "10001", "10001", "01110"},
+	'P':  {"11110", "10001", "10001", "11110", "10000", "10000", "10000"},
+	'Q':  {"01110", "10001", "10001", "10001", "10101", "10010", "01101"},
+	'R':  {"11110", "10001", "10001", "11110", "10100", "10010", "10001"},
+	'S':  {"01111", "10000", "10000", "01110", "00001", "00001", "11110"},
+	'T':  {"11111", "00100", "00100", "00100", "00100", "00100", "00100"},
+	'U':  {"10001", "10001", "10001", "10001", "10001", "10001", "01110"},
+	'V':  {"10001", "10001", "10001", "10001", "10001", "01010", "00100"},
+	'W':  {"10001", "10001", "10001", "10101", "10101", "10101", "01010"},
+	'X':  {"10001", "10001", "01010", "00100", "01010", "10001", "10001"},
+	'Y':  {"10001", "10001", "10001", "01010", "00100", "00100", "00100"},
+	'Z':  {"11111", "00001", "00010", "00100", "01000", "10000", "11111"},
+	'0':  {"01110", "10001", "10011", "10101", "11001", "10001", "01110"},
+	'1':  {"00100", "01100", "00100", "00100", "00100", "00100", "01110"},
+	'2':  {"01110", "10001", "00001", "00010", "00100", "01000", "11111"},
+	'3':  {"11111", "00010", "00100", "00010", "00001", "10001", "01110"},
+	'4':  {"00010", "00110", "01010", "10010", "11111", "00010", "00010"},
+	'5':  {"11111", "10000", "11110", "00001", "00001", "10001", "01110"},
+	'6':  {"00110", "01000", "10000", "11110", "10001", "10001", "01110"},
+	'7':  {"11111", "00001", "00010", "00100", "01000", "01000", "01000"},
+	'8':  {"01110", "10001", "10001", "01110", "10001", "10001", "01110"},
+	'9':  {"01110", "10001", "10001", "01111", "00001", "00010", "01100"},
+	' ':  {"00000", "00000", "00000", "00000", "00000", "00000", "00000"},
+	'.':  {"00000", "00000", "00000", "00000", "00000", "01100", "01100"},
+	',':  {"00000", "00000", "00000", "00000", "01100", "00100", "01000"},
+	':':  {"00000", "01100", "01100", "00000", "01100", "01100", "00000"},
+	'-':  {"00000", "00000", "00000", "11111", "00000", "00000", "00000"},
+	'_':  {"00000", "00000", "00000", "00000", "00000", "00000", "11111"},
+	'\'': {"01100", "00100", "01000", "00000", "00000", "00000", "00000"},
+	'!':  {"00100", "00100", "00100", "00100", "00100", "00000", "00100"},
+	'?':  {"01110", "10001", "00001", "00010", "00100", "00000", "00100"},
+	'(':  {"00010", "00100", "01000", "01000", "01000", "00100", "00010"},
+	')':  {"01000", "00100", "00010", "00010", "00010", "00100", "01000"},
+	'/':  {"00000", "00001", "00010", "00100", "01000", "10000", "00000"},
+	'&':  {"01100", "10010", "10100", "01000", "10101", "10010", "01101"},
+	'#':  {"01010", "01010", "11111", "01010", "11111", "01010", "01010"},
+	'+':  {"00000", "00100", "00100", "11111", "00100", "00100", "00000"},
+}
+
+func labelGlyph(r rune) [labelGlyphH]string {
+	if g, ok := labelGlyphs[r]; ok {
+		return g
+	}
+	if g, ok := labelGlyphs[[]rune(strings.ToUpper(string(r)))[0]]; ok {
+		return g
+	}
+	return labelGlyphs['?']
+}
+
+func loadMapLabels(wname, dname string) []mapLabel {
+	ret := []mapLabel{}
+	err := cfg.GetToStruct(&ret, "labels", wname, dname)
+	if err != nil && !errors.Is(err, lac.ErrNoKey) {
+		log.Printf("Failed to parse labels of [%s:%s]: %s", wname, dname, err.Error())
+		return []mapLabel{}
+	}
+	return ret
+}
+
+// tile is 512 pixels at most, further out one pixel covers more than one block
+func labelBlocksPerPixel(cs int) int {
+	if cs <= 5 {
+		return 1
+	}
+	return 1 << (cs - 5)
+}
+
+func parseLabelColor(s string) color.RGBA {
+	c := color.RGBA{255, 255, 255, 255}
+	s = strings.TrimPrefix(s, "#")
+	if len(s) != 6 {
+		return c
+	}
+	var v [3]uint8
+	for i := range v {
+		for _, r := range s[i*2 : i*2+2] {
+			var d uint8
+			switch {
+			case r >= '0' && r <= '9':
+				d = uint8(r - '0')
+			case r >= 'a' && r <= 'f':
+				d = uint8(r-'a') + 10
+			case r >= 'A' && r <= 'F':
+				d = uint8(r-'A') + 10
+			default:
+				return c
+			}
+			v[i] = v[i]*16 + d
+		}
+	}
+	return color.RGBA{v[0], v[1], v[2], 255}
+}
+
+// places labels of the whole dimension so that decision about overlapping
+// ones does not depend on what tile is being drawn, higher priority wins
+func placeMapLabels(labels []mapLabel, cs int) []labelPlacement {
+	sort.SliceStable(labels, func(i, j int) bool {
+		if labels[i].Priority != labels[j].Priority {
+			return labels[i].Priority > labels[j].Priority
+		}
+		if labels[i].X != labels[j].X {
+			return labels[i].X < labels[j].X
+		}
+		return labels[i].Z < labels[j].Z
+	})
+	bpp := labelBlocksPerPixel(cs)
+	padding := cfg.GetDSInt(4, "labels_padding")
+	placed := []labelPlacement{}
+	for _, l := range labels {
+		maxScale := l.MaxScale
+		if maxScale == 0 {
+			maxScale = 8
+		}
+		if cs < l.MinScale || cs > maxScale || l.Text == "" {
+			continue
+		}
+		ps := l.Size
+		if ps < 1 {
+			ps = 1
+		}
+		if ps > 4 {
+			ps = 4
+		}
+		// one pixel of halo around every glyph
+		w := (len([]rune(l.Text))*(labelGlyphW+1)+1)*ps + 2
+		h := labelGlyphH*ps + 2 + 2
+		cx := floorDiv(l.X, bpp)
+		cz := floorDiv(l.Z, bpp)
+		p := labelPlacement{
+			label:      l,
+			x0:         cx - w/2,
+			z0:         cz - h/2,
+			fg:         parseLabelColor(l.Color),
+			pixelScale: ps,
+		}
+		p.x1 = p.x0 + w
+		p.z1 = p.z0 + h
+		collides := false
+		for _, o := range placed {
+			if p.x0 < o.x1+padding && o.x0 < p.x1+padding && p.z0 < o.z1+padding && o.z0 < p.z1+padding {
+				collides = true
+				break
+			}
+		}
+		if !collides {
+			placed = append(placed, p)
+		}
+	}
+	return placed
+}
+
+func floorDiv(a, b int) int {
+	if a < 0 && a%b != 0 {
+		return a/b - 1
+	}
+	return a / b
+}
+
+func drawLabelsTile(loc primitives.ImageLocation) *image.RGBA {
+	labels := loadMapLabels(loc.World, loc.Dimension)
+	if len(labels) == 0 {
+		return nil
+	}
+	size := 16
+	if loc.S > 0 {
+		size = 16 * (2 << (loc.S - 1))
+	}
+	if size > 512 {
+		size = 512
+	}
+	tx, tz := loc.X*size, loc.Z*size
+	var img *image.RGBA
+	for _, p := range placeMapLabels(labels, loc.S) {
+		if p.x1 <= tx || p.x0 >= tx+size || p.z1 <= tz || p.z0 >= tz+size {
+			continue
+		}
+		if img == nil {
+			img = image.NewRGBA(image.Rect(0, 0, size, size))
+		}
+		drawLabel(img, p, tx, tz)
+	}
+	return img
+}
+
+func drawLabel(img *image.RGBA, p labelPlacement, tx, tz int) {
+	halo := color.RGBA{0, 0, 0, 200}
+	ps := p.pixelScale
+	set := func(x, z int, c color.RGBA) {
+		x -= tx
+		z -= tz
+		if x < 0 || z < 0 || x >= img.Rect.Dx() || z >= img.Rect.Dy() {
+			return
+		}
+		if c == halo && img.RGBAAt(x, z) != (color.RGBA{}) {
+			return
+		}
+		img.SetRGBA(x, z, c)
+	}
+	for i, r := range []rune(p.label.Text) {
+		g := labelGlyph(r)
+		gx := p.x0 + 1 + ps + i*(labelGlyphW+1)*ps
+		gz := p.z0 + 1 + ps
+		for row := 0; row < labelGlyphH; row++ {
+			for col := 0; col < labelGlyphW; col++ {
+				if g[row][col] != '1' {
+					continue
+				}
+				for dz := -1; dz <= ps; dz++ {
+					for dx := -1; dx <= ps; dx++ {
+						x, z := gx+col*ps+dx, gz+row*ps+dz
+						if dx >= 0 && dx < ps && dz >= 0 && dz < ps {
+							set(x, z, p.fg)
+						} else {
+							set(x, z, halo)
+						}
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,115 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/maxsupermanhd/lac"
+)
+
+const (
+	layerAccessEnabled       = "enabled"
+	layerAccessDisabled      = "disabled"
+	layerAccessAuthenticated = "authenticated"
+)
+
+// name of the layer param layer variant or script layer was made from
+func layerBaseName(variant string) string {
+	for name, pl := range paramLayers {
+		if _, ok := pl.provider(variant); ok {
+			return name
+		}
+	}
+	return variant
+}
+
+// layer access is set per world and dimension, default dimension key
+// applies to every dimension of the world and listed ones override it
+func layerAccess(wname, dname, layer string) string {
+	ret := layerAccessEnabled
+	for _, d := range []string{"default", dname} {
+		conf := map[string]string{}
+		err := cfg.GetToStruct(&conf, "layer_access", wname, d)
+		if err != nil {
+			if !errors.Is(err, lac.ErrNoKey) {
+				log.Printf("Failed to parse layer access for [%s] [%s]: %s", wname, d, err.Error())
+			}
+			continue
+		}
+		if a, ok := conf[layer]; ok {
+			ret = a
+		}
+	}
+	switch ret {
+	case layerAccessEnabled, layerAccessDisabled, layerAccessAuthenticated:
+		return ret
+	}
+	log.Printf("Unknown access [%s] of layer [%s] in [%s] [%s], disabling it", ret, layer, wname, dname)
+	return layerAccessDisabled
+}
+
+// authenticated is whoever has the reveal token
+func layerAllowed(r *http.Request, wname, dname, layer string) bool {
+	switch layerAccess(wname, dname, layerBaseName(layer)) {
+	case layerAccessEnabled:
+		return true
+	case layerAccessAuthenticated:
+		return privacyCanReveal(r)
+	}
+	return false
+}
+
+func allowedLayers(r *http.Request, wname, dname string, layers []ttype) []ttype {
+	ret := make([]ttype, 0, len(layers))
+	for _, l := range layers {
+		if layerAllowed(r, wname, dname, l.Name) {
+			ret = append(ret, l)
+		}
+	}
+	return ret
+}
+
+// for listings made before world is picked, layers allowed in at least one
+// dimension are kept and tiles are still checked one by one
+func allowedLayersAnywhere(r *http.Request, worlds map[string][]string, layers []ttype) []ttype {
+	ret := make([]ttype, 0, len(layers))
+	for _, l := range layers {
+		allowed := len(worlds) == 0
+		for wname, dims := range worlds {
+			for _, dname := range dims {
+				if layerAllowed(r, wname, dname, l.Name) {
+					allowed = true
+					break
+				}
+			}
+			if allowed {
+				break
+			}
+		}
+		if allowed {
+			ret = append(ret, l)
+		}
+	}
+	return ret
+}
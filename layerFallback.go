@@ -0,0 +1,153 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"errors"
+	"image"
+	"log"
+
+	"github.com/maxsupermanhd/WebChunk/chunkStorage"
+	"github.com/maxsupermanhd/lac"
+)
+
+// layers tried in order for chunks the layer itself can not draw,
+// chunks that failed to parse are left out by storage and show up
+// only in data of count layers
+var defaultLayerFallbacks = map[string][]string{
+	"terrain":           {"counttiles"},
+	"shadedterrain":     {"terrain", "counttiles"},
+	"hillshadedterrain": {"terrain", "counttiles"},
+}
+
+// layers that can draw chunk only partially, when check fails and there is
+// a fallback left it is used instead
+var layerDataComplete = map[string]func(interface{}) bool{
+	"shadedterrain": contextIsComplete,
+}
+
+// shading compares height with chunks towards the light (top and left)
+func contextIsComplete(i interface{}) bool {
+	c, ok := i.(ContextedChunkData)
+	return ok && c.center != nil && c.left != nil && c.top != nil && c.topLeft != nil
+}
+
+type fallbackStep struct {
+	layer   string
+	data    interface{}
+	painter chunkPainterFunc
+}
+
+// data of one chunk from every layer of the chain that had it
+type fallbackChunk []fallbackStep
+
+func layerFallbacks(name string) []string {
+	ret := []string{}
+	err := cfg.GetToStruct(&ret, "layers", name, "fallback")
+	if errors.Is(err, lac.ErrNoKey) {
+		return defaultLayerFallbacks[name]
+	}
+	if err != nil {
+		log.Printf("Failed to parse fallback of layer [%s]: %s", name, err.Error())
+		return defaultLayerFallbacks[name]
+	}
+	return ret
+}
+
+func paintRecovering(p chunkPainterFunc, data interface{}) (img *image.RGBA) {
+	defer func() {
+		if err := recover(); err != nil {
+			img = nil
+		}
+	}()
+	return p(data)
+}
+
+func withLayerFallbacks(name string, f ttypeProviderFunc) ttypeProviderFunc {
+	names := []string{name}
+	providers := []ttypeProviderFunc{f}
+	for _, n := range layerFallbacks(name) {
+		for tt, p := range ttypes.All() {
+			if tt.Name == n && n != name {
+				names = append(names, n)
+				providers = append(providers, p)
+			}
+		}
+	}
+	if len(providers) == 1 {
+		return f
+	}
+	return func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
+		getters := make([]chunkDataProviderFunc, len(providers))
+		painters := make([]chunkPainterFunc, len(providers))
+		for i, p := range providers {
+			getters[i], painters[i] = p(s)
+		}
+		getter := func(wname, dname string, cx0, cz0, cx1, cz1 int) ([]chunkStorage.ChunkData, error) {
+			merged := map[[2]int]fallbackChunk{}
+			order := [][2]int{}
+			var firstErr error
+			for i, g := range getters {
+				cc, err := g(wname, dname, cx0, cz0, cx1, cz1)
+				if err != nil {
+					if firstErr == nil {
+						firstErr = err
+					}
+					continue
+				}
+				for _, c := range cc {
+					if c.Data == nil {
+						continue
+					}
+					k := [2]int{c.X, c.Z}
+					if _, ok := merged[k]; !ok {
+						order = append(order, k)
+					}
+					merged[k] = append(merged[k], fallbackStep{layer: names[i], data: c.Data, painter: painters[i]})
+				}
+			}
+			if len(merged) == 0 && firstErr != nil {
+				return nil, firstErr
+			}
+			ret := make([]chunkStorage.ChunkData, 0, len(order))
+			for _, k := range order {
+				ret = append(ret, chunkStorage.ChunkData{X: k[0], Z: k[1], Data: merged[k]})
+			}
+			return ret, nil
+		}
+		painter := func(i interface{}) *image.RGBA {
+			steps, ok := i.(fallbackChunk)
+			if !ok {
+				return painters[0](i)
+			}
+			for n, st := range steps {
+				if check, ok := layerDataComplete[st.layer]; ok && n < len(steps)-1 && !check(st.data) {
+					continue
+				}
+				if img := paintRecovering(st.painter, st.data); img != nil {
+					return img
+				}
+			}
+			return nil
+		}
+		return getter, painter
+	}
+}
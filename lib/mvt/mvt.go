@@ -0,0 +1,213 @@
+package mvt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Mapbox vector tile encoder, format is described in
+// https://github.com/mapbox/vector-tile-spec/tree/master/2.1
+
+const DefaultExtent = 4096
+
+type GeomType uint32
+
+const (
+	Point      GeomType = 1
+	LineString GeomType = 2
+	Polygon    GeomType = 3
+)
+
+// Feature geometry is in tile coordinates with y pointing down, Point takes
+// one part with all of its points, LineString and Polygon take one part per
+// line or ring. Exterior rings go clockwise and are not closed.
+type Feature struct {
+	ID         uint64
+	Type       GeomType
+	Geometry   [][][2]int
+	Properties map[string]interface{}
+}
+
+type Layer struct {
+	Name string
+	// zero means DefaultExtent
+	Extent   uint32
+	Features []Feature
+}
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+func appendKey(b []byte, field, wire int) []byte {
+	return binary.AppendUvarint(b, uint64(field<<3|wire))
+}
+
+func appendVarintField(b []byte, field int, v uint64) []byte {
+	return binary.AppendUvarint(appendKey(b, field, wireVarint), v)
+}
+
+func appendBytesField(b []byte, field int, v []byte) []byte {
+	b = binary.AppendUvarint(appendKey(b, field, wireBytes), uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendPacked(b []byte, field int, v []uint32) []byte {
+	p := []byte{}
+	for _, u := range v {
+		p = binary.AppendUvarint(p, uint64(u))
+	}
+	return appendBytesField(b, field, p)
+}
+
+func zigzag(v int) uint32 {
+	return uint32((v << 1) ^ (v >> 63))
+}
+
+func command(id, count int) uint32 {
+	return uint32(id&7 | count<<3)
+}
+
+// geometry is delta coded from the cursor that carries over between parts
+func encodeGeometry(t GeomType, parts [][][2]int) []uint32 {
+	ret := []uint32{}
+	cx, cy := 0, 0
+	move := func(p [2]int) {
+		ret = append(ret, zigzag(p[0]-cx), zigzag(p[1]-cy))
+		cx, cy = p[0], p[1]
+	}
+	for _, part := range parts {
+		if len(part) == 0 {
+			continue
+		}
+		if t == Point {
+			ret = append(ret, command(1, len(part)))
+			for _, p := range part {
+				move(p)
+			}
+			continue
+		}
+		ret = append(ret, command(1, 1))
+		move(part[0])
+		if len(part) > 1 {
+			ret = append(ret, command(2, len(part)-1))
+			for _, p := range part[1:] {
+				move(p)
+			}
+		}
+		if t == Polygon {
+			ret = append(ret, command(7, 1))
+		}
+	}
+	return ret
+}
+
+// values are compared after conversion so 1 and int64(1) share an entry
+func normalizeValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case string, bool, float64, int64, uint64:
+		return v
+	case int:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case uint32:
+		return uint64(v)
+	case float32:
+		return float64(v)
+	}
+	return fmt.Sprint(v)
+}
+
+func encodeValue(v interface{}) []byte {
+	switch v := v.(type) {
+	case string:
+		return appendBytesField(nil, 1, []byte(v))
+	case float64:
+		return binary.LittleEndian.AppendUint64(appendKey(nil, 3, wireFixed64), math.Float64bits(v))
+	case int64:
+		if v < 0 {
+			return appendVarintField(nil, 6, uint64(v<<1^v>>63))
+		}
+		return appendVarintField(nil, 4, uint64(v))
+	case uint64:
+		return appendVarintField(nil, 5, v)
+	case bool:
+		if v {
+			return appendVarintField(nil, 7, 1)
+		}
+		return appendVarintField(nil, 7, 0)
+	}
+	return nil
+}
+
+func (l *Layer) encode() []byte {
+	keys := []string{}
+	keyIndex := map[string]uint32{}
+	values := []interface{}{}
+	valueIndex := map[interface{}]uint32{}
+	features := [][]byte{}
+	for _, f := range l.Features {
+		fb := []byte{}
+		if f.ID != 0 {
+			fb = appendVarintField(fb, 1, f.ID)
+		}
+		names := make([]string, 0, len(f.Properties))
+		for k := range f.Properties {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		tags := make([]uint32, 0, len(names)*2)
+		for _, k := range names {
+			ki, ok := keyIndex[k]
+			if !ok {
+				ki = uint32(len(keys))
+				keyIndex[k] = ki
+				keys = append(keys, k)
+			}
+			v := normalizeValue(f.Properties[k])
+			vi, ok := valueIndex[v]
+			if !ok {
+				vi = uint32(len(values))
+				valueIndex[v] = vi
+				values = append(values, v)
+			}
+			tags = append(tags, ki, vi)
+		}
+		if len(tags) > 0 {
+			fb = appendPacked(fb, 2, tags)
+		}
+		fb = appendVarintField(fb, 3, uint64(f.Type))
+		fb = appendPacked(fb, 4, encodeGeometry(f.Type, f.Geometry))
+		features = append(features, fb)
+	}
+	extent := l.Extent
+	if extent == 0 {
+		extent = DefaultExtent
+	}
+	b := appendVarintField(nil, 15, 2)
+	b = appendBytesField(b, 1, []byte(l.Name))
+	for _, f := range features {
+		b = appendBytesField(b, 2, f)
+	}
+	for _, k := range keys {
+		b = appendBytesField(b, 3, []byte(k))
+	}
+	for _, v := range values {
+		b = appendBytesField(b, 4, encodeValue(v))
+	}
+	return appendVarintField(b, 5, uint64(extent))
+}
+
+// Encode returns protobuf encoded tile of given layers
+func Encode(layers []Layer) []byte {
+	ret := []byte{}
+	for i := range layers {
+		ret = appendBytesField(ret, 3, layers[i].encode())
+	}
+	return ret
+}
@@ -0,0 +1,785 @@
+package webp
+
+import (
+	"errors"
+	"math"
+	"sort"
+)
+
+// lossless bitstream is described in
+// https://developers.google.com/speed/webp/docs/webp_lossless_bitstream_specification
+
+var ErrTooBig = errors.New("image dimensions are over 16384")
+
+const (
+	vp8lLiterals      = 256
+	vp8lLengthCodes   = 24
+	vp8lDistanceCodes = 40
+	vp8lMaxLength     = 4096
+	vp8lMinLength     = 3
+	vp8lWindow        = 1<<20 - 120
+	vp8lChainDepth    = 32
+	vp8lHashBits      = 16
+	vp8lPredictorBits = 4
+)
+
+// first 120 distance codes are offsets around current pixel, same table as in
+// the spec, each entry is yoffset<<4 | (8 - xoffset)
+var vp8lDistanceMap = [120]uint8{
+	0x18, 0x07, 0x17, 0x19, 0x28, 0x06, 0x27, 0x29, 0x16, 0x1a,
+	0x26, 0x2a, 0x38, 0x05, 0x37, 0x39, 0x15, 0x1b, 0x36, 0x3a,
+	0x25, 0x2b, 0x48, 0x04, 0x47, 0x49, 0x14, 0x1c, 0x35, 0x3b,
+	0x46, 0x4a, 0x24, 0x2c, 0x58, 0x45, 0x4b, 0x34, 0x3c, 0x03,
+	0x57, 0x59, 0x13, 0x1d, 0x56, 0x5a, 0x23, 0x2d, 0x44, 0x4c,
+	0x55, 0x5b, 0x33, 0x3d, 0x68, 0x02, 0x67, 0x69, 0x12, 0x1e,
+	0x66, 0x6a, 0x22, 0x2e, 0x54, 0x5c, 0x43, 0x4d, 0x65, 0x6b,
+	0x32, 0x3e, 0x78, 0x01, 0x77, 0x79, 0x53, 0x5d, 0x11, 0x1f,
+	0x64, 0x6c, 0x42, 0x4e, 0x76, 0x7a, 0x21, 0x2f, 0x75, 0x7b,
+	0x31, 0x3f, 0x63, 0x6d, 0x52, 0x5e, 0x00, 0x74, 0x7c, 0x41,
+	0x4f, 0x10, 0x20, 0x62, 0x6e, 0x30, 0x73, 0x7d, 0x51, 0x5f,
+	0x40, 0x72, 0x7e, 0x61, 0x6f, 0x50, 0x71, 0x7f, 0x60, 0x70,
+}
+
+var vp8lCodeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+type bitWriter struct {
+	buf   []byte
+	bits  uint64
+	nBits uint
+}
+
+func (w *bitWriter) write(v uint32, n uint) {
+	w.bits |= uint64(v) << w.nBits
+	w.nBits += n
+	for w.nBits >= 8 {
+		w.buf = append(w.buf, byte(w.bits))
+		w.bits >>= 8
+		w.nBits -= 8
+	}
+}
+
+func (w *bitWriter) bytes() []byte {
+	if w.nBits > 0 {
+		w.buf = append(w.buf, byte(w.bits))
+		w.bits, w.nBits = 0, 0
+	}
+	return w.buf
+}
+
+// encodeLossless returns VP8L chunk payload of argb pixels
+func encodeLossless(argb []uint32, width, height int, hasAlpha bool) ([]byte, error) {
+	if width > 1<<14 || height > 1<<14 {
+		return nil, ErrTooBig
+	}
+	w := &bitWriter{}
+	w.write(0x2f, 8)
+	w.write(uint32(width-1), 14)
+	w.write(uint32(height-1), 14)
+	if hasAlpha {
+		w.write(1, 1)
+	} else {
+		w.write(0, 1)
+	}
+	w.write(0, 3)
+	writeLosslessStream(w, argb, width, height)
+	return w.bytes(), nil
+}
+
+// writeLosslessStream writes transforms and pixels without the header, alone
+// it is also the format of lossless alpha chunk
+func writeLosslessStream(w *bitWriter, argb []uint32, width, height int) {
+	if palette, ok := collectPalette(argb); ok {
+		w.write(1, 1)
+		w.write(3, 2)
+		w.write(uint32(len(palette)-1), 8)
+		deltas := make([]uint32, len(palette))
+		prev := uint32(0)
+		for i, c := range palette {
+			deltas[i] = subPixels(c, prev)
+			prev = c
+		}
+		writeImageData(w, deltas, len(palette), 1, false)
+		argb, width = applyPalette(argb, width, height, palette)
+	} else {
+		argb = append([]uint32(nil), argb...)
+		for i, c := range argb {
+			g := (c >> 8) & 0xff
+			argb[i] = c&0xff00ff00 | ((c>>16-g)&0xff)<<16 | (c-g)&0xff
+		}
+		w.write(1, 1)
+		w.write(2, 2)
+		modes, tw, th := choosePredictors(argb, width, height, vp8lPredictorBits)
+		w.write(1, 1)
+		w.write(0, 2)
+		w.write(vp8lPredictorBits-2, 3)
+		writeImageData(w, modes, tw, th, false)
+		argb = applyPredictors(argb, width, height, modes, vp8lPredictorBits)
+	}
+	w.write(0, 1)
+	writeImageData(w, argb, width, height, true)
+}
+
+// palette is sorted so deltas between entries stay small
+func collectPalette(argb []uint32) ([]uint32, bool) {
+	seen := map[uint32]struct{}{}
+	for _, c := range argb {
+		if _, ok := seen[c]; ok {
+			continue
+		}
+		if len(seen) == 256 {
+			return nil, false
+		}
+		seen[c] = struct{}{}
+	}
+	ret := make([]uint32, 0, len(seen))
+	for c := range seen {
+		ret = append(ret, c)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i] < ret[j] })
+	return ret, true
+}
+
+// replaces pixels with palette indexes in green, bundling several per pixel
+// when palette is small enough
+func applyPalette(argb []uint32, width, height int, palette []uint32) ([]uint32, int) {
+	index := map[uint32]uint32{}
+	for i, c := range palette {
+		index[c] = uint32(i)
+	}
+	bits := uint(0)
+	switch {
+	case len(palette) <= 2:
+		bits = 3
+	case len(palette) <= 4:
+		bits = 2
+	case len(palette) <= 16:
+		bits = 1
+	}
+	packed := (width + 1<<bits - 1) >> bits
+	per := uint(8 >> bits)
+	ret := make([]uint32, packed*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := index[argb[y*width+x]]
+			ret[y*packed+x>>bits] |= i << (8 + uint(x&(1<<bits-1))*per)
+		}
+	}
+	return ret, packed
+}
+
+// per channel a-b, the added guard bits keep borrows inside their lanes
+func subPixels(a, b uint32) uint32 {
+	ag := 0x00ff00ff + a&0xff00ff00 - b&0xff00ff00
+	rb := 0xff00ff00 + a&0x00ff00ff - b&0x00ff00ff
+	return ag&0xff00ff00 | rb&0x00ff00ff
+}
+
+func avg2(a, b uint32) uint32 {
+	return (((a ^ b) & 0xfefefefe) >> 1) + (a & b)
+}
+
+func channel(c uint32, shift uint) int32 {
+	return int32((c >> shift) & 0xff)
+}
+
+func clampByte(v int32) uint32 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint32(v)
+}
+
+func absInt32(v int32) int32 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func predictPixel(mode uint32, l, t, tr, tl uint32) uint32 {
+	switch mode {
+	case 0:
+		return 0xff000000
+	case 1:
+		return l
+	case 2:
+		return t
+	case 3:
+		return tr
+	case 4:
+		return tl
+	case 5:
+		return avg2(avg2(l, tr), t)
+	case 6:
+		return avg2(l, tl)
+	case 7:
+		return avg2(l, t)
+	case 8:
+		return avg2(tl, t)
+	case 9:
+		return avg2(t, tr)
+	case 10:
+		return avg2(avg2(l, tl), avg2(t, tr))
+	case 11:
+		pl, pt := int32(0), int32(0)
+		for s := uint(0); s < 32; s += 8 {
+			pl += absInt32(channel(tl, s) - channel(t, s))
+			pt += absInt32(channel(tl, s) - channel(l, s))
+		}
+		if pl < pt {
+			return l
+		}
+		return t
+	case 12:
+		ret := uint32(0)
+		for s := uint(0); s < 32; s += 8 {
+			ret |= clampByte(channel(l, s)+channel(t, s)-channel(tl, s)) << s
+		}
+		return ret
+	default:
+		a := avg2(l, t)
+		ret := uint32(0)
+		for s := uint(0); s < 32; s += 8 {
+			ret |= clampByte(channel(a, s)+(channel(a, s)-channel(tl, s))/2) << s
+		}
+		return ret
+	}
+}
+
+// predictor of pixel, border pixels use fixed modes as decoder does
+func predictAt(argb []uint32, width, x, y int, mode uint32) uint32 {
+	p := y*width + x
+	switch {
+	case x == 0 && y == 0:
+		return 0xff000000
+	case y == 0:
+		return argb[p-1]
+	case x == 0:
+		return argb[p-width]
+	}
+	// top right of the last column wraps to the start of current row
+	return predictPixel(mode, argb[p-1], argb[p-width], argb[p-width+1], argb[p-width-1])
+}
+
+func residualCost(c uint32) int32 {
+	ret := int32(0)
+	for s := uint(0); s < 32; s += 8 {
+		ret += absInt32(int32(int8(c >> s)))
+	}
+	return ret
+}
+
+// picks predictor with smallest residuals for every tile, modes go in green
+func choosePredictors(argb []uint32, width, height int, bits uint) ([]uint32, int, int) {
+	tw, th := (width+1<<bits-1)>>bits, (height+1<<bits-1)>>bits
+	modes := make([]uint32, tw*th)
+	for ty := 0; ty < th; ty++ {
+		for tx := 0; tx < tw; tx++ {
+			best, bestCost := uint32(0), int32(math.MaxInt32)
+			for mode := uint32(0); mode < 14; mode++ {
+				cost := int32(0)
+				for y := ty << bits; y < (ty+1)<<bits && y < height; y++ {
+					for x := tx << bits; x < (tx+1)<<bits && x < width; x++ {
+						cost += residualCost(subPixels(argb[y*width+x], predictAt(argb, width, x, y, mode)))
+					}
+				}
+				if cost < bestCost {
+					best, bestCost = mode, cost
+				}
+			}
+			modes[ty*tw+tx] = 0xff000000 | best<<8
+		}
+	}
+	return modes, tw, th
+}
+
+func applyPredictors(argb []uint32, width, height int, modes []uint32, bits uint) []uint32 {
+	tw := (width + 1<<bits - 1) >> bits
+	ret := make([]uint32, len(argb))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			mode := (modes[(y>>bits)*tw+x>>bits] >> 8) & 0xff
+			ret[y*width+x] = subPixels(argb[y*width+x], predictAt(argb, width, x, y, mode))
+		}
+	}
+	return ret
+}
+
+// prefix coding of lengths and distances, returns symbol, extra bits count and value
+func prefixEncode(v int) (int, uint, uint32) {
+	v--
+	if v < 4 {
+		return v, 0, 0
+	}
+	h := 31
+	for v>>uint(h) == 0 {
+		h--
+	}
+	second := (v >> uint(h-1)) & 1
+	extra := uint(h - 1)
+	return 2*h + second, extra, uint32(v) & (1<<extra - 1)
+}
+
+type vp8lToken struct {
+	// literal argb, cache index or copy length
+	value uint32
+	dist  uint32
+	kind  uint8
+}
+
+const (
+	tokenLiteral = iota
+	tokenCache
+	tokenCopy
+)
+
+func pixelHash(a, b uint32) uint32 {
+	return ((a * 0x1e35a7bd) ^ (b * 0x9e3779b1)) >> (32 - vp8lHashBits) & (1<<vp8lHashBits - 1)
+}
+
+// greedy LZ77 with hash chains, pixel above and the previous pixel are
+// always tried since runs and repeated rows are what tiles have the most
+func findBackrefs(argb []uint32, width int) []vp8lToken {
+	n := len(argb)
+	head := make([]int32, 1<<vp8lHashBits)
+	for i := range head {
+		head[i] = -1
+	}
+	chain := make([]int32, n)
+	insert := func(i int) {
+		if i+1 >= n {
+			return
+		}
+		h := pixelHash(argb[i], argb[i+1])
+		chain[i] = head[h]
+		head[h] = int32(i)
+	}
+	matchLen := func(i, j int) int {
+		l := 0
+		for i+l < n && l < vp8lMaxLength && argb[i+l] == argb[j+l] {
+			l++
+		}
+		return l
+	}
+	ret := make([]vp8lToken, 0, n/2)
+	for i := 0; i < n; {
+		bestLen, bestDist := 0, 0
+		try := func(j int) {
+			d := i - j
+			if j < 0 || d <= 0 || d > vp8lWindow {
+				return
+			}
+			if l := matchLen(i, j); l > bestLen {
+				bestLen, bestDist = l, d
+			}
+		}
+		try(i - 1)
+		try(i - width)
+		if i+1 < n {
+			j := head[pixelHash(argb[i], argb[i+1])]
+			for depth := 0; j >= 0 && depth < vp8lChainDepth && bestLen < vp8lMaxLength; depth++ {
+				try(int(j))
+				j = chain[j]
+			}
+		}
+		if bestLen >= vp8lMinLength {
+			ret = append(ret, vp8lToken{kind: tokenCopy, value: uint32(bestLen), dist: uint32(bestDist)})
+			for k := 0; k < bestLen; k++ {
+				insert(i + k)
+			}
+			i += bestLen
+			continue
+		}
+		ret = append(ret, vp8lToken{kind: tokenLiteral, value: argb[i]})
+		insert(i)
+		i++
+	}
+	return ret
+}
+
+// plane codes are used for nearby offsets, others are shifted past them
+func distanceCodes(width int) map[uint32]uint32 {
+	ret := map[uint32]uint32{}
+	for i := len(vp8lDistanceMap) - 1; i >= 0; i-- {
+		yoff := int(vp8lDistanceMap[i] >> 4)
+		xoff := 8 - int(vp8lDistanceMap[i]&0xf)
+		d := yoff*width + xoff
+		if d < 1 {
+			d = 1
+		}
+		ret[uint32(d)] = uint32(i + 1)
+	}
+	return ret
+}
+
+// replaces literals that are in color cache with cache references
+func applyColorCache(tokens []vp8lToken, argb []uint32, cacheBits uint) []vp8lToken {
+	ret := make([]vp8lToken, len(tokens))
+	copy(ret, tokens)
+	if cacheBits == 0 {
+		return ret
+	}
+	cache := make([]uint32, 1<<cacheBits)
+	valid := make([]bool, 1<<cacheBits)
+	p := 0
+	for i, t := range ret {
+		if t.kind == tokenCopy {
+			for k := 0; k < int(t.value); k++ {
+				idx := (argb[p] * 0x1e35a7bd) >> (32 - cacheBits)
+				cache[idx], valid[idx] = argb[p], true
+				p++
+			}
+			continue
+		}
+		idx := (t.value * 0x1e35a7bd) >> (32 - cacheBits)
+		if valid[idx] && cache[idx] == t.value {
+			ret[i] = vp8lToken{kind: tokenCache, value: idx}
+		}
+		cache[idx], valid[idx] = t.value, true
+		p++
+	}
+	return ret
+}
+
+type vp8lHistograms [5][]uint32
+
+func buildHistograms(tokens []vp8lToken, cacheBits uint, dcodes map[uint32]uint32) vp8lHistograms {
+	var h vp8lHistograms
+	h[0] = make([]uint32, vp8lLiterals+vp8lLengthCodes+(1<<cacheBits)*boolToUint(cacheBits > 0))
+	h[1] = make([]uint32, vp8lLiterals)
+	h[2] = make([]uint32, vp8lLiterals)
+	h[3] = make([]uint32, vp8lLiterals)
+	h[4] = make([]uint32, vp8lDistanceCodes)
+	for _, t := range tokens {
+		switch t.kind {
+		case tokenLiteral:
+			h[0][(t.value>>8)&0xff]++
+			h[1][(t.value>>16)&0xff]++
+			h[2][t.value&0xff]++
+			h[3][t.value>>24]++
+		case tokenCache:
+			h[0][vp8lLiterals+vp8lLengthCodes+t.value]++
+		case tokenCopy:
+			sym, _, _ := prefixEncode(int(t.value))
+			h[0][vp8lLiterals+sym]++
+			sym, _, _ = prefixEncode(int(distanceCode(t.dist, dcodes)))
+			h[4][sym]++
+		}
+	}
+	return h
+}
+
+func distanceCode(d uint32, dcodes map[uint32]uint32) uint32 {
+	if c, ok := dcodes[d]; ok {
+		return c
+	}
+	return d + 120
+}
+
+func boolToUint(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// shannon estimate of the coded size, good enough to pick cache size
+func (h *vp8lHistograms) estimate(tokens []vp8lToken) float64 {
+	bits := 0.0
+	for _, hist := range h {
+		total := 0.0
+		for _, c := range hist {
+			total += float64(c)
+		}
+		for _, c := range hist {
+			if c > 0 {
+				bits -= float64(c) * math.Log2(float64(c)/total)
+			}
+		}
+	}
+	for _, t := range tokens {
+		if t.kind == tokenCopy {
+			_, e, _ := prefixEncode(int(t.value))
+			bits += float64(e)
+		}
+	}
+	return bits
+}
+
+// writeImageData writes color cache, huffman codes and pixels of an entropy
+// coded image, topLevel images also carry meta prefix flag
+func writeImageData(w *bitWriter, argb []uint32, width, height int, topLevel bool) {
+	tokens := findBackrefs(argb, width)
+	dcodes := distanceCodes(width)
+	var (
+		bestBits   uint
+		bestTokens []vp8lToken
+		bestHist   vp8lHistograms
+		bestCost   = math.Inf(1)
+	)
+	for _, bits := range []uint{0, 4, 6, 8, 10} {
+		if bits > 0 && len(argb) < 1<<bits {
+			break
+		}
+		t := applyColorCache(tokens, argb, bits)
+		h := buildHistograms(t, bits, dcodes)
+		cost := h.estimate(t)
+		if cost < bestCost {
+			bestBits, bestTokens, bestHist, bestCost = bits, t, h, cost
+		}
+	}
+	if bestBits > 0 {
+		w.write(1, 1)
+		w.write(uint32(bestBits), 4)
+	} else {
+		w.write(0, 1)
+	}
+	if topLevel {
+		w.write(0, 1)
+	}
+	var codes [5]huffmanCode
+	for i := range codes {
+		codes[i] = newHuffmanCode(bestHist[i], 15)
+		codes[i].writeHeader(w)
+	}
+	for _, t := range bestTokens {
+		switch t.kind {
+		case tokenLiteral:
+			codes[0].put(w, int((t.value>>8)&0xff))
+			codes[1].put(w, int((t.value>>16)&0xff))
+			codes[2].put(w, int(t.value&0xff))
+			codes[3].put(w, int(t.value>>24))
+		case tokenCache:
+			codes[0].put(w, vp8lLiterals+vp8lLengthCodes+int(t.value))
+		case tokenCopy:
+			sym, n, extra := prefixEncode(int(t.value))
+			codes[0].put(w, vp8lLiterals+sym)
+			w.write(extra, n)
+			sym, n, extra = prefixEncode(int(distanceCode(t.dist, dcodes)))
+			codes[4].put(w, sym)
+			w.write(extra, n)
+		}
+	}
+}
+
+type huffmanCode struct {
+	lengths []uint8
+	// bit reversed codes, as stream is read from least significant bit
+	codes []uint32
+	// code with a single symbol takes no bits at all
+	single bool
+}
+
+func newHuffmanCode(freq []uint32, maxLen int) huffmanCode {
+	ret := huffmanCode{
+		lengths: huffmanLengths(freq, maxLen),
+		codes:   make([]uint32, len(freq)),
+	}
+	used := 0
+	for _, l := range ret.lengths {
+		if l > 0 {
+			used++
+		}
+	}
+	ret.single = used <= 1
+	var count [16]uint32
+	for _, l := range ret.lengths {
+		count[l]++
+	}
+	count[0] = 0
+	var next [16]uint32
+	code := uint32(0)
+	for l := 1; l < 16; l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+	for s, l := range ret.lengths {
+		if l == 0 {
+			continue
+		}
+		c := next[l]
+		next[l]++
+		rev := uint32(0)
+		for i := uint8(0); i < l; i++ {
+			rev = rev<<1 | (c>>i)&1
+		}
+		ret.codes[s] = rev
+	}
+	return ret
+}
+
+func (h *huffmanCode) put(w *bitWriter, sym int) {
+	if h.single {
+		return
+	}
+	w.write(h.codes[sym], uint(h.lengths[sym]))
+}
+
+func (h *huffmanCode) writeHeader(w *bitWriter) {
+	syms := []int{}
+	for s, l := range h.lengths {
+		if l > 0 {
+			syms = append(syms, s)
+		}
+	}
+	if len(syms) == 0 {
+		syms = append(syms, 0)
+	}
+	if len(syms) <= 2 && syms[len(syms)-1] < 256 {
+		w.write(1, 1)
+		w.write(uint32(len(syms)-1), 1)
+		if syms[0] < 2 {
+			w.write(0, 1)
+			w.write(uint32(syms[0]), 1)
+		} else {
+			w.write(1, 1)
+			w.write(uint32(syms[0]), 8)
+		}
+		if len(syms) == 2 {
+			w.write(uint32(syms[1]), 8)
+		}
+		return
+	}
+	w.write(0, 1)
+	type rle struct {
+		sym   int
+		extra uint32
+	}
+	tokens := []rle{}
+	for i := 0; i < len(h.lengths); {
+		v := h.lengths[i]
+		run := 1
+		for i+run < len(h.lengths) && h.lengths[i+run] == v {
+			run++
+		}
+		i += run
+		if v == 0 {
+			for run >= 11 {
+				n := run
+				if n > 138 {
+					n = 138
+				}
+				tokens = append(tokens, rle{18, uint32(n - 11)})
+				run -= n
+			}
+			if run >= 3 {
+				tokens = append(tokens, rle{17, uint32(run - 3)})
+				run = 0
+			}
+		} else {
+			tokens = append(tokens, rle{int(v), 0})
+			run--
+			for run >= 3 {
+				n := run
+				if n > 6 {
+					n = 6
+				}
+				tokens = append(tokens, rle{16, uint32(n - 3)})
+				run -= n
+			}
+		}
+		for ; run > 0; run-- {
+			tokens = append(tokens, rle{int(v), 0})
+		}
+	}
+	freq := make([]uint32, 19)
+	for _, t := range tokens {
+		freq[t.sym]++
+	}
+	clc := newHuffmanCode(freq, 7)
+	n := 19
+	for n > 4 && clc.lengths[vp8lCodeLengthOrder[n-1]] == 0 {
+		n--
+	}
+	w.write(uint32(n-4), 4)
+	for _, s := range vp8lCodeLengthOrder[:n] {
+		w.write(uint32(clc.lengths[s]), 3)
+	}
+	w.write(0, 1)
+	for _, t := range tokens {
+		clc.put(w, t.sym)
+		switch t.sym {
+		case 16:
+			w.write(t.extra, 2)
+		case 17:
+			w.write(t.extra, 3)
+		case 18:
+			w.write(t.extra, 7)
+		}
+	}
+}
+
+// huffmanLengths builds code lengths no longer than maxLen, rare symbols get
+// their counts raised until the tree is shallow enough
+func huffmanLengths(freq []uint32, maxLen int) []uint8 {
+	ret := make([]uint8, len(freq))
+	syms := []int{}
+	for s, f := range freq {
+		if f > 0 {
+			syms = append(syms, s)
+		}
+	}
+	switch len(syms) {
+	case 0:
+		return ret
+	case 1:
+		ret[syms[0]] = 1
+		return ret
+	}
+	type node struct {
+		weight      uint64
+		left, right int
+	}
+	for floor := uint64(1); ; floor *= 2 {
+		nodes := make([]node, 0, 2*len(syms))
+		for _, s := range syms {
+			w := uint64(freq[s])
+			if w < floor {
+				w = floor
+			}
+			nodes = append(nodes, node{weight: w, left: -1, right: -1})
+		}
+		leaves := make([]int, len(syms))
+		for i := range leaves {
+			leaves[i] = i
+		}
+		sort.SliceStable(leaves, func(i, j int) bool { return nodes[leaves[i]].weight < nodes[leaves[j]].weight })
+		// two queue merge, internal nodes are created in order of weight
+		internal := []int{}
+		li, ii := 0, 0
+		pop := func() int {
+			if ii >= len(internal) || (li < len(leaves) && nodes[leaves[li]].weight <= nodes[internal[ii]].weight) {
+				li++
+				return leaves[li-1]
+			}
+			ii++
+			return internal[ii-1]
+		}
+		for (len(leaves)-li)+(len(internal)-ii) > 1 {
+			a, b := pop(), pop()
+			nodes = append(nodes, node{weight: nodes[a].weight + nodes[b].weight, left: a, right: b})
+			internal = append(internal, len(nodes)-1)
+		}
+		depth := make([]int, len(nodes))
+		deepest := 0
+		for i := len(nodes) - 1; i >= len(syms); i-- {
+			depth[nodes[i].left] = depth[i] + 1
+			depth[nodes[i].right] = depth[i] + 1
+		}
+		for i := range syms {
+			if depth[i] > deepest {
+				deepest = depth[i]
+			}
+		}
+		if deepest > maxLen {
+			continue
+		}
+		for i, s := range syms {
+			ret[s] = uint8(depth[i])
+		}
+		return ret
+	}
+}
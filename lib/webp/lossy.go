@@ -0,0 +1,806 @@
+package webp
+
+import (
+	"math"
+)
+
+// lossy bitstream is a single VP8 key frame as described in RFC 6386, only
+// whole macroblock prediction modes are used and the token probabilities
+// are adjusted to the image
+
+const (
+	predDC = iota
+	predVE
+	predHE
+	predTM
+)
+
+const (
+	planeY1WithY2 = iota
+	planeY2
+	planeUV
+)
+
+var (
+	vp8Zigzag  = [16]int{0, 1, 4, 8, 5, 2, 3, 6, 9, 12, 13, 10, 7, 11, 14, 15}
+	vp8Bands   = [17]int{0, 1, 2, 3, 6, 4, 5, 6, 6, 6, 6, 6, 6, 6, 6, 7, 0}
+	vp8Cat3to6 = [4][]uint8{
+		{173, 148, 140},
+		{176, 155, 140, 135},
+		{180, 157, 141, 134, 130},
+		{254, 254, 243, 230, 196, 177, 153, 140, 133, 130, 129},
+	}
+)
+
+type boolEncoder struct {
+	buf      []byte
+	rng      uint32
+	bottom   uint32
+	bitCount int
+}
+
+func newBoolEncoder() *boolEncoder {
+	return &boolEncoder{rng: 255, bitCount: 24}
+}
+
+func (e *boolEncoder) addOne() {
+	i := len(e.buf) - 1
+	for i >= 0 && e.buf[i] == 255 {
+		e.buf[i] = 0
+		i--
+	}
+	if i >= 0 {
+		e.buf[i]++
+	}
+}
+
+func (e *boolEncoder) put(b bool, prob uint8) {
+	split := 1 + (((e.rng - 1) * uint32(prob)) >> 8)
+	if b {
+		e.bottom += split
+		e.rng -= split
+	} else {
+		e.rng = split
+	}
+	for e.rng < 128 {
+		e.rng <<= 1
+		if e.bottom&(1<<31) != 0 {
+			e.addOne()
+		}
+		e.bottom <<= 1
+		e.bitCount--
+		if e.bitCount == 0 {
+			e.buf = append(e.buf, byte(e.bottom>>24))
+			e.bottom &= 1<<24 - 1
+			e.bitCount = 8
+		}
+	}
+}
+
+func (e *boolEncoder) putLiteral(v uint32, n int) {
+	for i := n - 1; i >= 0; i-- {
+		e.put((v>>uint(i))&1 != 0, 128)
+	}
+}
+
+func (e *boolEncoder) bytes() []byte {
+	c := e.bitCount
+	v := e.bottom
+	if v&(1<<uint(32-c)) != 0 {
+		e.addOne()
+	}
+	v <<= uint(c & 7)
+	for c >>= 3; c > 0; c-- {
+		v <<= 8
+	}
+	for i := 0; i < 4; i++ {
+		e.buf = append(e.buf, byte(v>>24))
+		v <<= 8
+	}
+	return e.buf
+}
+
+type vp8Quant struct {
+	y1, y2, uv [2]int32
+}
+
+func newVP8Quant(qi int) vp8Quant {
+	q := vp8Quant{
+		y1: [2]int32{vp8DCQuant[qi], vp8ACQuant[qi]},
+		y2: [2]int32{vp8DCQuant[qi] * 2, vp8ACQuant[qi] * 155 / 100},
+		uv: [2]int32{vp8DCQuant[qi], vp8ACQuant[qi]},
+	}
+	if q.y2[1] < 8 {
+		q.y2[1] = 8
+	}
+	if qi > 117 {
+		q.uv[0] = vp8DCQuant[117]
+	}
+	return q
+}
+
+type vp8Macroblock struct {
+	ymode, uvmode int
+	skip          bool
+	// 16 luma, 4 u, 4 v and the second order block, levels in raster order
+	levels [25][16]int16
+}
+
+type vp8Encoder struct {
+	mbw, mbh int
+	// source and reconstructed planes, padded to whole macroblocks
+	y, u, v    []uint8
+	ry, ru, rv []uint8
+	quant      vp8Quant
+	mbs        []vp8Macroblock
+	probs      [4][8][3][11]uint8
+}
+
+// encodeLossy returns VP8 chunk payload of planes sized in macroblocks
+func encodeLossy(y, u, v []uint8, width, height int, quality int) ([]byte, error) {
+	if width > 1<<14 || height > 1<<14 {
+		return nil, ErrTooBig
+	}
+	if quality < 0 {
+		quality = 0
+	}
+	if quality > 100 {
+		quality = 100
+	}
+	qi := (100 - quality) * 127 / 100
+	e := &vp8Encoder{
+		mbw:   (width + 15) / 16,
+		mbh:   (height + 15) / 16,
+		y:     y,
+		u:     u,
+		v:     v,
+		quant: newVP8Quant(qi),
+		probs: vp8DefaultTokenProbs,
+	}
+	e.ry = make([]uint8, len(y))
+	e.ru = make([]uint8, len(u))
+	e.rv = make([]uint8, len(v))
+	e.mbs = make([]vp8Macroblock, e.mbw*e.mbh)
+	for mby := 0; mby < e.mbh; mby++ {
+		for mbx := 0; mbx < e.mbw; mbx++ {
+			e.encodeMacroblock(mbx, mby)
+		}
+	}
+	stats := &tokenStats{}
+	e.writeTokens(stats)
+	updates := e.adjustProbs(stats)
+
+	fp := newBoolEncoder()
+	fp.put(false, 128) // color space
+	fp.put(false, 128) // clamping
+	fp.put(false, 128) // segmentation
+	fp.put(false, 128) // normal loop filter
+	fp.putLiteral(uint32(qi/3), 6)
+	fp.putLiteral(0, 3) // sharpness
+	fp.put(false, 128)  // loop filter deltas
+	fp.putLiteral(0, 2) // single token partition
+	fp.putLiteral(uint32(qi), 7)
+	for i := 0; i < 5; i++ {
+		fp.put(false, 128) // quantizer deltas
+	}
+	fp.put(false, 128) // refresh entropy probs
+	for i := range e.probs {
+		for j := range e.probs[i] {
+			for k := range e.probs[i][j] {
+				for l := range e.probs[i][j][k] {
+					fp.put(updates[i][j][k][l], vp8TokenUpdateProbs[i][j][k][l])
+					if updates[i][j][k][l] {
+						fp.putLiteral(uint32(e.probs[i][j][k][l]), 8)
+					}
+				}
+			}
+		}
+	}
+	skipped := 0
+	for i := range e.mbs {
+		if e.mbs[i].skip {
+			skipped++
+		}
+	}
+	skipProb := uint8(0)
+	if skipped > 0 {
+		p := (len(e.mbs) - skipped) * 256 / len(e.mbs)
+		if p < 1 {
+			p = 1
+		}
+		if p > 254 {
+			p = 254
+		}
+		skipProb = uint8(p)
+		fp.put(true, 128)
+		fp.putLiteral(uint32(skipProb), 8)
+	} else {
+		fp.put(false, 128)
+	}
+	for i := range e.mbs {
+		mb := &e.mbs[i]
+		if skipped > 0 {
+			fp.put(mb.skip, skipProb)
+		}
+		fp.put(true, 145) // not split into subblocks
+		switch mb.ymode {
+		case predDC:
+			fp.put(false, 156)
+			fp.put(false, 163)
+		case predVE:
+			fp.put(false, 156)
+			fp.put(true, 163)
+		case predHE:
+			fp.put(true, 156)
+			fp.put(false, 128)
+		case predTM:
+			fp.put(true, 156)
+			fp.put(true, 128)
+		}
+		switch mb.uvmode {
+		case predDC:
+			fp.put(false, 142)
+		case predVE:
+			fp.put(true, 142)
+			fp.put(false, 114)
+		case predHE:
+			fp.put(true, 142)
+			fp.put(true, 114)
+			fp.put(false, 183)
+		case predTM:
+			fp.put(true, 142)
+			fp.put(true, 114)
+			fp.put(true, 183)
+		}
+	}
+	first := fp.bytes()
+	tp := newBoolEncoder()
+	e.writeTokens(tokenWriter{boolEncoder: tp, probs: &e.probs})
+	tokens := tp.bytes()
+
+	ret := make([]byte, 10, 10+len(first)+len(tokens))
+	tag := uint32(len(first))<<5 | 1<<4 // key frame, version 0, shown
+	ret[0], ret[1], ret[2] = byte(tag), byte(tag>>8), byte(tag>>16)
+	ret[3], ret[4], ret[5] = 0x9d, 0x01, 0x2a
+	ret[6], ret[7] = byte(width), byte(width>>8)
+	ret[8], ret[9] = byte(height), byte(height>>8)
+	ret = append(ret, first...)
+	return append(ret, tokens...), nil
+}
+
+// edge pixels of a block in reconstructed plane, missing neighbours are
+// filled the same way decoder does
+func edges(plane []uint8, stride, x, y, size int) (top, left []int32, corner int32) {
+	top = make([]int32, size)
+	left = make([]int32, size)
+	for i := 0; i < size; i++ {
+		if y == 0 {
+			top[i] = 127
+		} else {
+			top[i] = int32(plane[(y-1)*stride+x+i])
+		}
+		if x == 0 {
+			left[i] = 129
+		} else {
+			left[i] = int32(plane[(y+i)*stride+x-1])
+		}
+	}
+	switch {
+	case y == 0:
+		corner = 127
+	case x == 0:
+		corner = 129
+	default:
+		corner = int32(plane[(y-1)*stride+x-1])
+	}
+	return
+}
+
+func predictBlock(mode int, top, left []int32, corner int32, x, y int) []int32 {
+	size := len(top)
+	ret := make([]int32, size*size)
+	dc := dcPrediction(top, left, x, y)
+	for j := 0; j < size; j++ {
+		for i := 0; i < size; i++ {
+			var p int32
+			switch mode {
+			case predDC:
+				p = dc
+			case predVE:
+				p = top[i]
+			case predHE:
+				p = left[j]
+			case predTM:
+				p = int32(clampByte(left[j] + top[i] - corner))
+			}
+			ret[j*size+i] = p
+		}
+	}
+	return ret
+}
+
+func dcPrediction(top, left []int32, x, y int) int32 {
+	size := int32(len(top))
+	shift := uint(3)
+	if size == 16 {
+		shift = 4
+	}
+	sum := int32(0)
+	switch {
+	case x == 0 && y == 0:
+		return 128
+	case x == 0:
+		for _, v := range top {
+			sum += v
+		}
+		return (sum + size/2) >> shift
+	case y == 0:
+		for _, v := range left {
+			sum += v
+		}
+		return (sum + size/2) >> shift
+	}
+	for i := range top {
+		sum += top[i] + left[i]
+	}
+	return (sum + size) >> (shift + 1)
+}
+
+// picks the mode with smallest squared error against the source
+func chooseMode(src []uint8, stride, x, y int, top, left []int32, corner int32) (int, []int32) {
+	size := len(top)
+	bestMode, bestErr := 0, int64(math.MaxInt64)
+	var best []int32
+	for mode := predDC; mode <= predTM; mode++ {
+		pred := predictBlock(mode, top, left, corner, x, y)
+		err := int64(0)
+		for j := 0; j < size; j++ {
+			for i := 0; i < size; i++ {
+				d := int64(src[(y+j)*stride+x+i]) - int64(pred[j*size+i])
+				err += d * d
+			}
+		}
+		if err < bestErr {
+			bestMode, bestErr, best = mode, err, pred
+		}
+	}
+	return bestMode, best
+}
+
+func (e *vp8Encoder) encodeMacroblock(mbx, mby int) {
+	mb := &e.mbs[mby*e.mbw+mbx]
+	ys, cs := e.mbw*16, e.mbw*8
+	x, y := mbx*16, mby*16
+	top, left, corner := edges(e.ry, ys, x, y, 16)
+	var pred []int32
+	mb.ymode, pred = chooseMode(e.y, ys, x, y, top, left, corner)
+	// luma goes through second order transform of the block dc
+	var coeffs [16][16]int32
+	var dcs [16]int32
+	for b := 0; b < 16; b++ {
+		bx, by := b%4*4, b/4*4
+		var res [16]int32
+		for j := 0; j < 4; j++ {
+			for i := 0; i < 4; i++ {
+				res[j*4+i] = int32(e.y[(y+by+j)*ys+x+bx+i]) - pred[(by+j)*16+bx+i]
+			}
+		}
+		coeffs[b] = forwardDCT(res)
+		dcs[b] = coeffs[b][0]
+	}
+	wht := forwardWHT(dcs)
+	nonzero := false
+	for i := 0; i < 16; i++ {
+		q := e.quant.y2[boolToIndex(i > 0)]
+		mb.levels[24][i] = quantize(wht[i], q, i == 0)
+		nonzero = nonzero || mb.levels[24][i] != 0
+	}
+	var deq [16]int32
+	for i := 0; i < 16; i++ {
+		deq[i] = int32(mb.levels[24][i]) * e.quant.y2[boolToIndex(i > 0)]
+	}
+	blockDC := inverseWHT(deq)
+	for b := 0; b < 16; b++ {
+		bx, by := b%4*4, b/4*4
+		var c [16]int32
+		c[0] = blockDC[b]
+		for i := 1; i < 16; i++ {
+			mb.levels[b][i] = quantize(coeffs[b][i], e.quant.y1[1], false)
+			nonzero = nonzero || mb.levels[b][i] != 0
+			c[i] = int32(mb.levels[b][i]) * e.quant.y1[1]
+		}
+		out := inverseDCT(c)
+		for j := 0; j < 4; j++ {
+			for i := 0; i < 4; i++ {
+				e.ry[(y+by+j)*ys+x+bx+i] = uint8(clampByte(pred[(by+j)*16+bx+i] + out[j*4+i]))
+			}
+		}
+	}
+	// both chroma planes share the mode
+	cx, cy := mbx*8, mby*8
+	utop, uleft, ucorner := edges(e.ru, cs, cx, cy, 8)
+	vtop, vleft, vcorner := edges(e.rv, cs, cx, cy, 8)
+	bestErr := int64(math.MaxInt64)
+	for mode := predDC; mode <= predTM; mode++ {
+		err := int64(0)
+		for p, src := range [2][]uint8{e.u, e.v} {
+			pr := predictBlock(mode, utop, uleft, ucorner, cx, cy)
+			if p == 1 {
+				pr = predictBlock(mode, vtop, vleft, vcorner, cx, cy)
+			}
+			for j := 0; j < 8; j++ {
+				for i := 0; i < 8; i++ {
+					d := int64(src[(cy+j)*cs+cx+i]) - int64(pr[j*8+i])
+					err += d * d
+				}
+			}
+		}
+		if err < bestErr {
+			mb.uvmode, bestErr = mode, err
+		}
+	}
+	for p := 0; p < 2; p++ {
+		src, rec := e.u, e.ru
+		t, l, c := utop, uleft, ucorner
+		if p == 1 {
+			src, rec = e.v, e.rv
+			t, l, c = vtop, vleft, vcorner
+		}
+		pr := predictBlock(mb.uvmode, t, l, c, cx, cy)
+		for b := 0; b < 4; b++ {
+			bx, by := b%2*4, b/2*4
+			var res [16]int32
+			for j := 0; j < 4; j++ {
+				for i := 0; i < 4; i++ {
+					res[j*4+i] = int32(src[(cy+by+j)*cs+cx+bx+i]) - pr[(by+j)*8+bx+i]
+				}
+			}
+			coeff := forwardDCT(res)
+			levels := &mb.levels[16+p*4+b]
+			var deq [16]int32
+			for i := 0; i < 16; i++ {
+				levels[i] = quantize(coeff[i], e.quant.uv[boolToIndex(i > 0)], i == 0)
+				nonzero = nonzero || levels[i] != 0
+				deq[i] = int32(levels[i]) * e.quant.uv[boolToIndex(i > 0)]
+			}
+			out := inverseDCT(deq)
+			for j := 0; j < 4; j++ {
+				for i := 0; i < 4; i++ {
+					rec[(cy+by+j)*cs+cx+bx+i] = uint8(clampByte(pr[(by+j)*8+bx+i] + out[j*4+i]))
+				}
+			}
+		}
+	}
+	mb.skip = !nonzero
+}
+
+func boolToIndex(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// dc is rounded to nearest, ac has a small dead zone
+func quantize(c, q int32, dc bool) int16 {
+	bias := q * 3 / 8
+	if dc {
+		bias = q / 2
+	}
+	neg := c < 0
+	if neg {
+		c = -c
+	}
+	l := (c + bias) / q
+	if l > 2048 {
+		l = 2048
+	}
+	if neg {
+		return int16(-l)
+	}
+	return int16(l)
+}
+
+func forwardDCT(in [16]int32) [16]int32 {
+	var tmp, out [16]int32
+	for i := 0; i < 4; i++ {
+		a := (in[i*4+0] + in[i*4+3]) * 8
+		b := (in[i*4+1] + in[i*4+2]) * 8
+		c := (in[i*4+1] - in[i*4+2]) * 8
+		d := (in[i*4+0] - in[i*4+3]) * 8
+		tmp[i*4+0] = a + b
+		tmp[i*4+2] = a - b
+		tmp[i*4+1] = (c*2217 + d*5352 + 14500) >> 12
+		tmp[i*4+3] = (d*2217 - c*5352 + 7500) >> 12
+	}
+	for i := 0; i < 4; i++ {
+		a := tmp[i] + tmp[12+i]
+		b := tmp[4+i] + tmp[8+i]
+		c := tmp[4+i] - tmp[8+i]
+		d := tmp[i] - tmp[12+i]
+		out[i] = (a + b + 7) >> 4
+		out[8+i] = (a - b + 7) >> 4
+		out[4+i] = (c*2217 + d*5352 + 12000) >> 16
+		if d != 0 {
+			out[4+i]++
+		}
+		out[12+i] = (d*2217 - c*5352 + 51000) >> 16
+	}
+	return out
+}
+
+// inverse transforms must match the decoder bit for bit, prediction of the
+// following blocks is made from their output
+func inverseDCT(in [16]int32) [16]int32 {
+	const (
+		c1 = 85627
+		c2 = 35468
+	)
+	var m [4][4]int32
+	for i := 0; i < 4; i++ {
+		a := in[i] + in[8+i]
+		b := in[i] - in[8+i]
+		c := (in[4+i]*c2)>>16 - (in[12+i]*c1)>>16
+		d := (in[4+i]*c1)>>16 + (in[12+i]*c2)>>16
+		m[i][0] = a + d
+		m[i][1] = b + c
+		m[i][2] = b - c
+		m[i][3] = a - d
+	}
+	var out [16]int32
+	for j := 0; j < 4; j++ {
+		dc := m[0][j] + 4
+		a := dc + m[2][j]
+		b := dc - m[2][j]
+		c := (m[1][j]*c2)>>16 - (m[3][j]*c1)>>16
+		d := (m[1][j]*c1)>>16 + (m[3][j]*c2)>>16
+		out[j*4+0] = (a + d) >> 3
+		out[j*4+1] = (b + c) >> 3
+		out[j*4+2] = (b - c) >> 3
+		out[j*4+3] = (a - d) >> 3
+	}
+	return out
+}
+
+func forwardWHT(in [16]int32) [16]int32 {
+	var tmp, out [16]int32
+	for i := 0; i < 4; i++ {
+		a := (in[i*4+0] + in[i*4+2]) * 4
+		d := (in[i*4+1] + in[i*4+3]) * 4
+		c := (in[i*4+1] - in[i*4+3]) * 4
+		b := (in[i*4+0] - in[i*4+2]) * 4
+		tmp[i*4+0] = a + d
+		if a != 0 {
+			tmp[i*4+0]++
+		}
+		tmp[i*4+1] = b + c
+		tmp[i*4+2] = b - c
+		tmp[i*4+3] = a - d
+	}
+	for i := 0; i < 4; i++ {
+		a := tmp[i] + tmp[8+i]
+		d := tmp[4+i] + tmp[12+i]
+		c := tmp[4+i] - tmp[12+i]
+		b := tmp[i] - tmp[8+i]
+		for k, v := range [4]int32{a + d, b + c, b - c, a - d} {
+			if v < 0 {
+				v++
+			}
+			out[k*4+i] = (v + 3) >> 3
+		}
+	}
+	return out
+}
+
+func inverseWHT(in [16]int32) [16]int32 {
+	var m, out [16]int32
+	for i := 0; i < 4; i++ {
+		a0 := in[i] + in[12+i]
+		a1 := in[4+i] + in[8+i]
+		a2 := in[4+i] - in[8+i]
+		a3 := in[i] - in[12+i]
+		m[i] = a0 + a1
+		m[8+i] = a0 - a1
+		m[4+i] = a3 + a2
+		m[12+i] = a3 - a2
+	}
+	for i := 0; i < 4; i++ {
+		dc := m[i*4] + 3
+		a0 := dc + m[i*4+3]
+		a1 := m[i*4+1] + m[i*4+2]
+		a2 := m[i*4+1] - m[i*4+2]
+		a3 := dc - m[i*4+3]
+		out[i*4+0] = (a0 + a1) >> 3
+		out[i*4+1] = (a3 + a2) >> 3
+		out[i*4+2] = (a0 - a1) >> 3
+		out[i*4+3] = (a3 - a2) >> 3
+	}
+	return out
+}
+
+// tokens are written twice, first pass only counts branches of the token
+// tree to pick probabilities
+type tokenSink interface {
+	token(b bool, plane, band, ctx, node int)
+	fixed(b bool, prob uint8)
+}
+
+type tokenStats [4][8][3][11][2]uint32
+
+func (s *tokenStats) token(b bool, plane, band, ctx, node int) {
+	s[plane][band][ctx][node][boolToIndex(b)]++
+}
+
+func (s *tokenStats) fixed(bool, uint8) {}
+
+type tokenWriter struct {
+	*boolEncoder
+	probs *[4][8][3][11]uint8
+}
+
+func (w tokenWriter) token(b bool, plane, band, ctx, node int) {
+	w.put(b, w.probs[plane][band][ctx][node])
+}
+
+func (w tokenWriter) fixed(b bool, prob uint8) {
+	w.put(b, prob)
+}
+
+var bitCost = func() (ret [256][2]float64) {
+	for p := 1; p < 256; p++ {
+		ret[p][0] = -math.Log2(float64(p) / 256)
+		ret[p][1] = -math.Log2(1 - float64(p)/256)
+	}
+	return
+}()
+
+// updates token probabilities where the saving pays for the update
+func (e *vp8Encoder) adjustProbs(s *tokenStats) (updates [4][8][3][11]bool) {
+	for i := range e.probs {
+		for j := range e.probs[i] {
+			for k := range e.probs[i][j] {
+				for l := range e.probs[i][j][k] {
+					c := s[i][j][k][l]
+					if c[0]+c[1] == 0 {
+						continue
+					}
+					p := int((uint64(c[0])*256 + uint64(c[0]+c[1])/2) / uint64(c[0]+c[1]))
+					if p < 1 {
+						p = 1
+					}
+					if p > 255 {
+						p = 255
+					}
+					old := e.probs[i][j][k][l]
+					u := vp8TokenUpdateProbs[i][j][k][l]
+					oldCost := float64(c[0])*bitCost[old][0] + float64(c[1])*bitCost[old][1] + bitCost[u][0]
+					newCost := float64(c[0])*bitCost[p][0] + float64(c[1])*bitCost[p][1] + bitCost[u][1] + 8
+					if newCost < oldCost {
+						e.probs[i][j][k][l] = uint8(p)
+						updates[i][j][k][l] = true
+					}
+				}
+			}
+		}
+	}
+	return
+}
+
+func (e *vp8Encoder) writeTokens(s tokenSink) {
+	// nonzero flags of blocks along the edges, 4 luma, 2 u, 2 v and y2
+	topNz := make([][9]int, e.mbw)
+	for mby := 0; mby < e.mbh; mby++ {
+		var leftNz [9]int
+		for mbx := 0; mbx < e.mbw; mbx++ {
+			mb := &e.mbs[mby*e.mbw+mbx]
+			tnz := &topNz[mbx]
+			if mb.skip {
+				*tnz = [9]int{}
+				leftNz = [9]int{}
+				continue
+			}
+			nz := writeBlock(s, planeY2, leftNz[8]+tnz[8], &mb.levels[24], 0)
+			leftNz[8], tnz[8] = nz, nz
+			for by := 0; by < 4; by++ {
+				for bx := 0; bx < 4; bx++ {
+					nz := writeBlock(s, planeY1WithY2, leftNz[by]+tnz[bx], &mb.levels[by*4+bx], 1)
+					leftNz[by], tnz[bx] = nz, nz
+				}
+			}
+			for p := 0; p < 2; p++ {
+				for by := 0; by < 2; by++ {
+					for bx := 0; bx < 2; bx++ {
+						nz := writeBlock(s, planeUV, leftNz[4+p*2+by]+tnz[4+p*2+bx], &mb.levels[16+p*4+by*2+bx], 0)
+						leftNz[4+p*2+by], tnz[4+p*2+bx] = nz, nz
+					}
+				}
+			}
+		}
+	}
+}
+
+// writeBlock writes coefficients starting from first and returns whether
+// any of them is not zero
+func writeBlock(s tokenSink, plane, ctx int, levels *[16]int16, first int) int {
+	last := -1
+	for i := first; i < 16; i++ {
+		if levels[vp8Zigzag[i]] != 0 {
+			last = i
+		}
+	}
+	if last < 0 {
+		s.token(false, plane, vp8Bands[first], ctx, 0)
+		return 0
+	}
+	afterZero := false
+	for i := first; i <= last; i++ {
+		band := vp8Bands[i]
+		if !afterZero {
+			s.token(true, plane, band, ctx, 0)
+		}
+		l := int(levels[vp8Zigzag[i]])
+		v := l
+		if v < 0 {
+			v = -v
+		}
+		if v == 0 {
+			s.token(false, plane, band, ctx, 1)
+			afterZero, ctx = true, 0
+			continue
+		}
+		s.token(true, plane, band, ctx, 1)
+		writeMagnitude(s, plane, band, ctx, v)
+		s.fixed(l < 0, 128)
+		afterZero, ctx = false, 2
+		if v == 1 {
+			ctx = 1
+		}
+	}
+	if last < 15 {
+		s.token(false, plane, vp8Bands[last+1], ctx, 0)
+	}
+	return 1
+}
+
+func writeMagnitude(s tokenSink, plane, band, ctx, v int) {
+	if v == 1 {
+		s.token(false, plane, band, ctx, 2)
+		return
+	}
+	s.token(true, plane, band, ctx, 2)
+	if v <= 4 {
+		s.token(false, plane, band, ctx, 3)
+		if v == 2 {
+			s.token(false, plane, band, ctx, 4)
+			return
+		}
+		s.token(true, plane, band, ctx, 4)
+		s.token(v == 4, plane, band, ctx, 5)
+		return
+	}
+	s.token(true, plane, band, ctx, 3)
+	if v <= 10 {
+		s.token(false, plane, band, ctx, 6)
+		if v <= 6 {
+			s.token(false, plane, band, ctx, 7)
+			s.fixed(v == 6, 159)
+			return
+		}
+		s.token(true, plane, band, ctx, 7)
+		s.fixed((v-7)&2 != 0, 165)
+		s.fixed((v-7)&1 != 0, 145)
+		return
+	}
+	s.token(true, plane, band, ctx, 6)
+	cat := 3
+	for cat > 0 && v < 3+(8<<uint(cat)) {
+		cat--
+	}
+	s.token(cat >= 2, plane, band, ctx, 8)
+	s.token(cat&1 != 0, plane, band, ctx, 9+cat>>1)
+	extra := v - (3 + (8 << uint(cat)))
+	probs := vp8Cat3to6[cat]
+	for i, p := range probs {
+		s.fixed((extra>>uint(len(probs)-1-i))&1 != 0, p)
+	}
+}
@@ -0,0 +1,193 @@
+package webp
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+)
+
+var ErrEmpty = errors.New("image is empty")
+
+const DefaultQuality = 75
+
+// Options of encoding, quality from 0 to 100 is used only for lossy images
+type Options struct {
+	Lossless bool
+	Quality  int
+}
+
+// Encode writes image in WebP format, nil options mean lossy at default quality
+func Encode(w io.Writer, m image.Image, o *Options) error {
+	if o == nil {
+		o = &Options{Quality: DefaultQuality}
+	}
+	b := m.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width < 1 || height < 1 {
+		return ErrEmpty
+	}
+	argb, hasAlpha := toARGB(m)
+	if o.Lossless {
+		data, err := encodeLossless(argb, width, height, hasAlpha)
+		if err != nil {
+			return err
+		}
+		return writeRIFF(w, riffChunk{"VP8L", data})
+	}
+	y, u, v := toYUV(argb, width, height)
+	data, err := encodeLossy(y, u, v, width, height, o.Quality)
+	if err != nil {
+		return err
+	}
+	if !hasAlpha {
+		return writeRIFF(w, riffChunk{"VP8 ", data})
+	}
+	// alpha goes losslessly in green channel of a headerless VP8L stream
+	alpha := make([]uint32, len(argb))
+	for i, c := range argb {
+		alpha[i] = c >> 24 << 8
+	}
+	bw := &bitWriter{buf: []byte{1}}
+	writeLosslessStream(bw, alpha, width, height)
+	vp8x := make([]byte, 10)
+	vp8x[0] = 0x10
+	put24(vp8x[4:], width-1)
+	put24(vp8x[7:], height-1)
+	return writeRIFF(w, riffChunk{"VP8X", vp8x}, riffChunk{"ALPH", bw.bytes()}, riffChunk{"VP8 ", data})
+}
+
+func put24(b []byte, v int) {
+	b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+}
+
+type riffChunk struct {
+	id   string
+	data []byte
+}
+
+func writeRIFF(w io.Writer, chunks ...riffChunk) error {
+	size := 4
+	for _, c := range chunks {
+		size += 8 + len(c.data) + len(c.data)&1
+	}
+	buf := make([]byte, 0, 8+size)
+	buf = append(buf, "RIFF"...)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(size))
+	buf = append(buf, "WEBP"...)
+	for _, c := range chunks {
+		buf = append(buf, c.id...)
+		buf = binary.LittleEndian.AppendUint32(buf, uint32(len(c.data)))
+		buf = append(buf, c.data...)
+		if len(c.data)&1 == 1 {
+			buf = append(buf, 0)
+		}
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// not premultiplied pixels, fully transparent ones are zeroed
+func toARGB(m image.Image) ([]uint32, bool) {
+	b := m.Bounds()
+	ret := make([]uint32, b.Dx()*b.Dy())
+	hasAlpha := false
+	i := 0
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			var c color.NRGBA
+			switch img := m.(type) {
+			case *image.RGBA:
+				p := img.Pix[img.PixOffset(x, y):]
+				c = unpremultiply(p[0], p[1], p[2], p[3])
+			case *image.NRGBA:
+				p := img.Pix[img.PixOffset(x, y):]
+				c = color.NRGBA{p[0], p[1], p[2], p[3]}
+			default:
+				c = color.NRGBAModel.Convert(m.At(x, y)).(color.NRGBA)
+			}
+			if c.A == 0 {
+				c = color.NRGBA{}
+			}
+			if c.A != 255 {
+				hasAlpha = true
+			}
+			ret[i] = uint32(c.A)<<24 | uint32(c.R)<<16 | uint32(c.G)<<8 | uint32(c.B)
+			i++
+		}
+	}
+	return ret, hasAlpha
+}
+
+func unpremultiply(r, g, b, a uint8) color.NRGBA {
+	switch a {
+	case 0:
+		return color.NRGBA{}
+	case 255:
+		return color.NRGBA{r, g, b, a}
+	}
+	f := func(c uint8) uint8 {
+		v := (uint32(c)*255 + uint32(a)/2) / uint32(a)
+		if v > 255 {
+			v = 255
+		}
+		return uint8(v)
+	}
+	return color.NRGBA{f(r), f(g), f(b), a}
+}
+
+// toYUV converts to 4:2:0 planes padded to whole macroblocks by repeating
+// edge pixels, colors under transparency are taken from the last visible
+// ones so they do not bleed into edges of what is visible
+func toYUV(argb []uint32, width, height int) (y, u, v []uint8) {
+	pw, ph := (width+15)/16*16, (height+15)/16*16
+	cw, ch := pw/2, ph/2
+	y = make([]uint8, pw*ph)
+	u = make([]uint8, cw*ch)
+	v = make([]uint8, cw*ch)
+	at := func(x, y int) uint32 {
+		if x >= width {
+			x = width - 1
+		}
+		if y >= height {
+			y = height - 1
+		}
+		return argb[y*width+x]
+	}
+	fill := [3]int32{}
+	for by := 0; by < ch; by++ {
+		for bx := 0; bx < cw; bx++ {
+			var px [4]uint32
+			var sum [3]int32
+			weight := int32(0)
+			for k := 0; k < 4; k++ {
+				px[k] = at(bx*2+k%2, by*2+k/2)
+				a := int32(px[k] >> 24)
+				sum[0] += int32((px[k]>>16)&0xff) * a
+				sum[1] += int32((px[k]>>8)&0xff) * a
+				sum[2] += int32(px[k]&0xff) * a
+				weight += a
+			}
+			avg := fill
+			if weight > 0 {
+				for c := range avg {
+					avg[c] = (sum[c] + weight/2) / weight
+				}
+				fill = avg
+			}
+			var r4, g4, b4 int32
+			for k := 0; k < 4; k++ {
+				r, g, b := int32((px[k]>>16)&0xff), int32((px[k]>>8)&0xff), int32(px[k]&0xff)
+				if px[k]>>24 == 0 {
+					r, g, b = avg[0], avg[1], avg[2]
+				}
+				r4, g4, b4 = r4+r, g4+g, b4+b
+				y[(by*2+k/2)*pw+bx*2+k%2] = uint8((16839*r + 33059*g + 6420*b + 1<<15 + 16<<16) >> 16)
+			}
+			u[by*cw+bx] = uint8(clampByte((-9719*r4 - 19081*g4 + 28800*b4 + 1<<17 + 128<<18) >> 18))
+			v[by*cw+bx] = uint8(clampByte((28800*r4 - 24116*g4 - 4684*b4 + 1<<17 + 128<<18) >> 18))
+		}
+	}
+	return
+}
@@ -0,0 +1,142 @@
+package webp
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math"
+	"testing"
+
+	xwebp "golang.org/x/image/webp"
+)
+
+var testSizes = [][2]int{{1, 1}, {16, 16}, {17, 33}, {100, 7}, {256, 256}}
+
+// smooth gradients with a few sharp edges like map tiles have
+func testImage(w, h int, alpha bool) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.NRGBA{uint8(x * 255 / w), uint8(y * 255 / h), uint8((x + y) * 2), 255}
+			if (x/8+y/8)%5 == 0 {
+				c = color.NRGBA{30, 90, 200, 255}
+			}
+			if alpha {
+				switch {
+				case x%7 == 0:
+					c = color.NRGBA{}
+				case y%3 == 0:
+					c.A = uint8(x * 255 / w)
+				}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func roundTrip(t *testing.T, img image.Image, o *Options) image.Image {
+	buf := bytes.Buffer{}
+	if err := Encode(&buf, img, o); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	ret, err := xwebp.Decode(&buf)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if ret.Bounds() != img.Bounds() {
+		t.Fatalf("bounds %v, want %v", ret.Bounds(), img.Bounds())
+	}
+	return ret
+}
+
+func TestLosslessRoundTrip(t *testing.T) {
+	for _, s := range testSizes {
+		for _, alpha := range []bool{false, true} {
+			img := testImage(s[0], s[1], alpha)
+			got := roundTrip(t, img, &Options{Lossless: true})
+			for y := 0; y < s[1]; y++ {
+				for x := 0; x < s[0]; x++ {
+					want := img.NRGBAAt(x, y)
+					if want.A == 0 {
+						want = color.NRGBA{}
+					}
+					c := color.NRGBAModel.Convert(got.At(x, y)).(color.NRGBA)
+					if c != want {
+						t.Fatalf("%dx%d alpha %v: pixel %d,%d is %v, want %v", s[0], s[1], alpha, x, y, c, want)
+					}
+				}
+			}
+		}
+	}
+}
+
+func psnr(se float64, n int) float64 {
+	if se == 0 {
+		return math.Inf(1)
+	}
+	return 10 * math.Log10(255*255/(se/float64(n)))
+}
+
+// x/image decodes to full range YCbCr while VP8 uses studio range, so planes
+// are compared with what encoder got instead of converting back to rgb,
+// alpha is stored losslessly even in lossy images
+func TestLossyRoundTrip(t *testing.T) {
+	for _, s := range testSizes {
+		for _, alpha := range []bool{false, true} {
+			w, h := s[0], s[1]
+			img := testImage(w, h, alpha)
+			got := roundTrip(t, img, nil)
+			var ycc *image.YCbCr
+			switch m := got.(type) {
+			case *image.YCbCr:
+				ycc = m
+			case *image.NYCbCrA:
+				ycc = &m.YCbCr
+				for y := 0; y < h; y++ {
+					for x := 0; x < w; x++ {
+						want := img.NRGBAAt(x, y).A
+						if a := m.A[m.AOffset(x, y)]; a != want {
+							t.Fatalf("%dx%d alpha %v: pixel %d,%d alpha is %d, want %d", w, h, alpha, x, y, a, want)
+						}
+					}
+				}
+			default:
+				t.Fatalf("%dx%d alpha %v: decoded to %T", w, h, alpha, got)
+			}
+			argb, _ := toARGB(img)
+			wy, wu, wv := toYUV(argb, w, h)
+			pw, cw := (w+15)/16*16, (w+15)/16*8
+			var sey, sec float64
+			var ny, nc int
+			for y := 0; y < h; y++ {
+				for x := 0; x < w; x++ {
+					d := float64(ycc.Y[ycc.YOffset(x, y)]) - float64(wy[y*pw+x])
+					sey += d * d
+					ny++
+				}
+			}
+			for y := 0; y < (h+1)/2; y++ {
+				for x := 0; x < (w+1)/2; x++ {
+					co := ycc.COffset(x*2, y*2)
+					du := float64(ycc.Cb[co]) - float64(wu[y*cw+x])
+					dv := float64(ycc.Cr[co]) - float64(wv[y*cw+x])
+					sec += du*du + dv*dv
+					nc += 2
+				}
+			}
+			if p := psnr(sey, ny); p < 35 {
+				t.Errorf("%dx%d alpha %v: luma psnr %.1f dB is too low", w, h, alpha, p)
+			}
+			if p := psnr(sec, nc); p < 35 {
+				t.Errorf("%dx%d alpha %v: chroma psnr %.1f dB is too low", w, h, alpha, p)
+			}
+		}
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	if err := Encode(&bytes.Buffer{}, image.NewNRGBA(image.Rect(0, 0, 0, 5)), nil); err != ErrEmpty {
+		t.Fatalf("got %v, want ErrEmpty", err)
+	}
+}
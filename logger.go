@@ -19,7 +19,7 @@ func customLogger(_ io.Writer, params handlers.LogFormatterParams) {
 		geo = "??"
 	}
 	ua := r.Header.Get("user-agent")
-	log.Println("["+geo+" "+ip+"]", r.Method, params.StatusCode, r.RequestURI, "["+ua+"]")
+	log.Println("["+geo+" "+ip+"]", r.Method, params.StatusCode, r.RequestURI, "["+ua+"]", requestID(r))
 }
 
 func createLogger() *lumberjack.Logger {
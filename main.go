@@ -30,9 +30,10 @@ import (
 	rpprof "runtime/pprof"
 	"syscall"
 
-	"github.com/maxsupermanhd/WebChunk/chunkStorage"
 	imagecache "github.com/maxsupermanhd/WebChunk/imageCache"
 	"github.com/maxsupermanhd/WebChunk/proxy"
+	"github.com/maxsupermanhd/WebChunk/records"
+	"github.com/maxsupermanhd/WebChunk/worldserver"
 )
 
 var (
@@ -43,9 +44,11 @@ var (
 )
 
 var (
-	ic            *imagecache.ImageCache
-	chunkChannel  = make(chan *proxy.ProxiedChunk, 12*12)
-	mainCtxCancel context.CancelFunc
+	ic                *imagecache.ImageCache
+	recs              *records.Store
+	chunkChannel      = make(chan *proxy.ProxiedChunk, 12*12)
+	proxyEventChannel = make(chan *proxy.ProxiedEvent, 1024)
+	mainCtxCancel     context.CancelFunc
 )
 
 func main() {
@@ -75,9 +78,43 @@ func main() {
 	if err := storagesInit(); err != nil && cfg.GetDSBool(false, "ignore_failed_storages") {
 		log.Fatal("Failed to initialize storages: ", err)
 	}
+	if err := loadBlockRegistry(); err != nil {
+		log.Fatal("Failed to load block registry: ", err)
+	}
 	if err := loadColors(cfg.GetDSString("./colors.gob", "colors_path")); err != nil {
 		log.Fatal(err)
 	}
+	if err := loadBiomeColors(cfg.GetDSString("./biomecolors.json", "biome_colors_path")); err != nil {
+		log.Fatal("Failed to load biome palette: ", err)
+	}
+	registerRenderers()
+	loadScriptLayers()
+	recs = records.NewStore(cfg.GetDSString("./records", "records_path"))
+
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		err := restoreCommand(os.Args[2:])
+		storages.Close()
+		if err != nil {
+			log.Fatal("Restore failed: ", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "registry" {
+		err := registryCommand(os.Args[2:])
+		storages.Close()
+		if err != nil {
+			log.Fatal("Registry generation failed: ", err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		err := importCommand(os.Args[2:])
+		storages.Close()
+		if err != nil {
+			log.Fatal("Import failed: ", err)
+		}
+		return
+	}
 
 	var ctx context.Context
 	ctx, mainCtxCancel = signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
@@ -86,6 +123,9 @@ func main() {
 	bgsEventRouter := startBackgroundRoutine("event router", globalEventRouter.Run)
 	bgsTemplateManager := startBackgroundRoutine("template manager", func(ec <-chan struct{}) { templateManager(ec, cfg.SubTree("web")) })
 	bgsChunkConsumer := startBackgroundRoutine("chunk consumer", chunkConsumer)
+	bgsProxyEventConsumer := startBackgroundRoutine("proxy event consumer", proxyEventConsumer)
+	bgsPlayerTracker := startBackgroundRoutine("player tracker", playerTrackerBroadcaster)
+	bgsScriptLayers := startBackgroundRoutine("script layers watcher", scriptLayersWatcher)
 	bgsImageCache := startBackgroundRoutine("image cache", func(c <-chan struct{}) {
 		imageCacheCtx, imageCacheCtxCancel := context.WithCancel(context.Background())
 		go func() {
@@ -96,15 +136,45 @@ func main() {
 		ic.WaitExit()
 	})
 
-	bgsProxy := startBackgroundRoutine("proxy", func(c <-chan struct{}) {
-		proxyCtx, proxyCtxCancel := context.WithCancel(context.Background())
+	noop := func() {}
+	bgsProxy, bgsBots, bgsWorldServer, bgsBackups, bgsSync, bgsWeb := noop, noop, noop, noop, noop, noop
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		// same pipeline as proxied sessions, without anything listening
 		go func() {
-			<-c
-			proxyCtxCancel()
+			if err := replayCommand(ctx, os.Args[2:]); err != nil {
+				log.Println("Replay failed: ", err)
+			}
+			mainCtxCancel()
 		}()
-		proxy.RunProxy(proxyCtx, cfg.SubTree("proxy"), chunkChannel)
-	})
-	bgsWeb := startBackgroundRoutine("web server", runWeb)
+	} else {
+		bgsProxy = startBackgroundRoutine("proxy", func(c <-chan struct{}) {
+			proxyCtx, proxyCtxCancel := context.WithCancel(context.Background())
+			go func() {
+				<-c
+				proxyCtxCancel()
+			}()
+			proxy.RunProxy(proxyCtx, cfg.SubTree("proxy"), chunkChannel, proxyEventChannel)
+		})
+		bgsBots = startBackgroundRoutine("bots", func(c <-chan struct{}) {
+			botsCtx, botsCtxCancel := context.WithCancel(context.Background())
+			go func() {
+				<-c
+				botsCtxCancel()
+			}()
+			proxy.RunBots(botsCtx, cfg.SubTree("proxy"), chunkChannel, proxyEventChannel)
+		})
+		bgsWorldServer = startBackgroundRoutine("world server", func(c <-chan struct{}) {
+			wsCtx, wsCtxCancel := context.WithCancel(context.Background())
+			go func() {
+				<-c
+				wsCtxCancel()
+			}()
+			worldserver.Run(wsCtx, cfg.SubTree("world_server"), storagesWorldSource{}, worldServerPlayerName)
+		})
+		bgsBackups = startBackgroundRoutine("backup scheduler", backupScheduler)
+		bgsSync = startBackgroundRoutine("sync scheduler", peerSyncScheduler)
+		bgsWeb = startBackgroundRoutine("web server", runWeb)
+	}
 
 	<-ctx.Done()
 	log.Println("Interrupt recieved, shutting down...")
@@ -113,16 +183,26 @@ func main() {
 	log.Println("Waiting for websocket clients to drop...")
 	wsClients.Wait()
 
+	bgsSync()
+	bgsBackups()
+	bgsWorldServer()
+	bgsBots()
 	bgsProxy()
 	bgsImageCache()
+	bgsScriptLayers()
+	bgsPlayerTracker()
+	bgsProxyEventConsumer()
 	bgsChunkConsumer()
 	bgsTemplateManager()
 	bgsEventRouter()
 	bgsMetrics()
 
 	log.Println("Shutting down storages...")
-	chunkStorage.CloseStorages(storages)
+	storages.Close()
 	log.Println("Storages closed.")
+	if err := recs.Close(); err != nil {
+		log.Println("Failed to close records: ", err)
+	}
 
 	if profileCPU {
 		log.Println("Stopping profiler...")
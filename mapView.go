@@ -0,0 +1,129 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"github.com/maxsupermanhd/lac"
+)
+
+// leaflet zoom levels of the dimension page, 0 is the most zoomed out
+const mapViewMaxZoom = 8
+
+// what dimension page opens at, empty layer means layers marked default,
+// x and z are block coordinates of the center
+type mapView struct {
+	Layer string  `json:"layer"`
+	Zoom  int     `json:"zoom"`
+	X     float64 `json:"x"`
+	Z     float64 `json:"z"`
+}
+
+var defaultMapView = mapView{Zoom: 3}
+
+func dimMapView(wname, dname string) mapView {
+	ret := defaultMapView
+	err := cfg.GetToStruct(&ret, "map_view", wname, dname)
+	if errors.Is(err, lac.ErrNoKey) {
+		ret = defaultMapView
+		err = cfg.GetToStruct(&ret, "map_view", wname, "default")
+	}
+	if err != nil && !errors.Is(err, lac.ErrNoKey) {
+		log.Printf("Failed to parse map view of [%s] [%s]: %s", wname, dname, err.Error())
+		return defaultMapView
+	}
+	return ret
+}
+
+func validateMapView(v mapView) error {
+	if v.Zoom < 0 || v.Zoom > mapViewMaxZoom {
+		return errors.New("zoom is out of range")
+	}
+	if v.Layer == "" {
+		return nil
+	}
+	for t := range ttypes.All() {
+		if t.Name == v.Layer {
+			return nil
+		}
+	}
+	return errors.New("unknown layer")
+}
+
+// layers dimension page starts with, base layer of the view replaces default
+// base layers and overlay is added to default ones, layers are already
+// filtered by access so view layer requester can not see is ignored
+func mapViewLayers(v mapView, layers []ttype) []string {
+	var view *ttype
+	for i := range layers {
+		if layers[i].Name == v.Layer {
+			view = &layers[i]
+		}
+	}
+	ret := []string{}
+	for _, l := range layers {
+		if !l.IsDefault || view != nil && l.Name == view.Name {
+			continue
+		}
+		if view != nil && !view.IsOverlay && !l.IsOverlay {
+			continue
+		}
+		ret = append(ret, l.Name)
+	}
+	if view != nil {
+		ret = append(ret, view.Name)
+	}
+	return ret
+}
+
+func apiGetMapView(w http.ResponseWriter, r *http.Request) (int, string) {
+	params := mux.Vars(r)
+	if _, code, err := lookupDim(params["world"], params["dim"]); err != nil {
+		return code, err.Error()
+	}
+	setContentTypeJson(w)
+	return marshalOrFail(200, dimMapView(params["world"], params["dim"]))
+}
+
+func apiSetMapView(w http.ResponseWriter, r *http.Request) (int, string) {
+	params := mux.Vars(r)
+	if _, code, err := lookupDim(params["world"], params["dim"]); err != nil {
+		return code, err.Error()
+	}
+	var v mapView
+	if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+		return bodyReadErrorStatus(err), "Bad view: " + err.Error()
+	}
+	if err := validateMapView(v); err != nil {
+		return 400, err.Error()
+	}
+	cfg.Set(map[string]any{"layer": v.Layer, "zoom": v.Zoom, "x": v.X, "z": v.Z}, "map_view", params["world"], params["dim"])
+	if err := saveConfig(); err != nil {
+		return 500, "Failed to save config: " + err.Error()
+	}
+	setContentTypeJson(w)
+	return marshalOrFail(200, v)
+}
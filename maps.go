@@ -0,0 +1,269 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/maxsupermanhd/WebChunk/proxy"
+)
+
+const mapItemSize = 128
+
+// base colors of vanilla map items, actual color index is base*4+shade
+var mapBaseColors = [...]uint32{
+	0x000000, 0x7FB238, 0xF7E9A3, 0xC7C7C7, 0xFF0000, 0xA0A0FF, 0xA7A7A7, 0x007C00,
+	0xFFFFFF, 0xA4A8B8, 0x976D4D, 0x707070, 0x4040FF, 0x8F7748, 0xFFFCF5, 0xD87F33,
+	0xB24CD8, 0x6699D8, 0xE5E533, 0x7FCC19, 0xF27FA5, 0x4C4C4C, 0x999999, 0x4C7F99,
+	0x7F3FB2, 0x334CB2, 0x664C33, 0x667F33, 0x993333, 0x191919, 0xFAEE4D, 0x5CDBD5,
+	0x4A80FF, 0x00D93A, 0x815631, 0x700200, 0xD1B1A1, 0x9F5224, 0x95576C, 0x706C8A,
+	0xBA8524, 0x677535, 0xA04D4E, 0x392923, 0x876B62, 0x575C5C, 0x7A4958, 0x4C3E5C,
+	0x4C3223, 0x4C522A, 0x8E3C2E, 0x251610, 0xBD3031, 0x943F61, 0x5C191D, 0x167E86,
+	0x3A8E8C, 0x562C3E, 0x14B485, 0x646464, 0xD8AF93, 0x7FA796,
+}
+
+var mapShadeMultipliers = [4]uint32{180, 220, 255, 135}
+
+func mapColor(idx byte) color.RGBA {
+	base := int(idx) / 4
+	if base == 0 || base >= len(mapBaseColors) {
+		return color.RGBA{}
+	}
+	c := mapBaseColors[base]
+	m := mapShadeMultipliers[idx%4]
+	return color.RGBA{
+		R: uint8((c >> 16 & 0xFF) * m / 255),
+		G: uint8((c >> 8 & 0xFF) * m / 255),
+		B: uint8((c & 0xFF) * m / 255),
+		A: 255,
+	}
+}
+
+// position is only known for imported maps, map packets do not carry it
+type mapItemMeta struct {
+	ID            int32
+	Scale         int8
+	Locked        bool
+	Updated       time.Time
+	Player        string
+	Dimension     string `json:",omitempty"`
+	XCenter       int    `json:",omitempty"`
+	ZCenter       int    `json:",omitempty"`
+	Georeferenced bool   `json:",omitempty"`
+}
+
+type mapItem struct {
+	mapItemMeta
+	Colors [mapItemSize * mapItemSize]byte
+}
+
+type mapItemKey struct {
+	world string
+	id    int32
+}
+
+// maps are updated in small patches, keeping whole canvas around
+// saves re-reading it from disk on every packet
+var (
+	mapItems     = map[mapItemKey]*mapItem{}
+	mapItemsLock sync.Mutex
+)
+
+func getMapsPath(wname string) (string, error) {
+	if wname == "" || wname == "." || wname == ".." || strings.ContainsAny(wname, `/\`) {
+		return "", errors.New("bad world name")
+	}
+	return filepath.Join(cfg.GetDSString("./maps", "maps_path"), wname), nil
+}
+
+// must be called with mapItemsLock held
+func loadMapItem(wname string, id int32) *mapItem {
+	k := mapItemKey{world: wname, id: id}
+	if m, ok := mapItems[k]; ok {
+		return m
+	}
+	m := &mapItem{mapItemMeta: mapItemMeta{ID: id}}
+	mapItems[k] = m
+	dir, err := getMapsPath(wname)
+	if err != nil {
+		return m
+	}
+	base := filepath.Join(dir, strconv.Itoa(int(id)))
+	if b, err := os.ReadFile(base + ".bin"); err == nil && len(b) == len(m.Colors) {
+		copy(m.Colors[:], b)
+	}
+	if b, err := os.ReadFile(base + ".json"); err == nil {
+		json.Unmarshal(b, &m.mapItemMeta)
+	}
+	return m
+}
+
+func (m *mapItem) toImage() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, mapItemSize, mapItemSize))
+	for i, c := range m.Colors {
+		img.SetRGBA(i%mapItemSize, i/mapItemSize, mapColor(c))
+	}
+	return img
+}
+
+func saveMapItem(wname string, m *mapItem) error {
+	dir, err := getMapsPath(wname)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(dir, 0764)
+	if err != nil {
+		return err
+	}
+	base := filepath.Join(dir, strconv.Itoa(int(m.ID)))
+	err = os.WriteFile(base+".bin", m.Colors[:], 0644)
+	if err != nil {
+		return err
+	}
+	meta, err := json.Marshal(m.mapItemMeta)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(base+".json", meta, 0644)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(base + ".png")
+	if err != nil {
+		return err
+	}
+	err = png.Encode(f, m.toImage())
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func mapDataReceived(e *proxy.ProxiedEvent, d proxy.EventMapData) {
+	mapItemsLock.Lock()
+	defer mapItemsLock.Unlock()
+	m := loadMapItem(e.Server, d.MapID)
+	m.Scale = d.Scale
+	m.Locked = d.Locked
+	m.Updated = e.Time
+	m.Player = e.Username
+	for row := 0; row < d.Rows; row++ {
+		for col := 0; col < d.Columns; col++ {
+			x, z := d.X+col, d.Z+row
+			i := row*d.Columns + col
+			if x >= mapItemSize || z >= mapItemSize || i >= len(d.Data) {
+				continue
+			}
+			m.Colors[z*mapItemSize+x] = d.Data[i]
+		}
+	}
+	if err := saveMapItem(e.Server, m); err != nil {
+		log.Printf("Failed to save map %d of %s: %s", d.MapID, e.Server, err.Error())
+	}
+}
+
+func listMapItems(wname string) ([]mapItemMeta, error) {
+	dir, err := getMapsPath(wname)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []mapItemMeta{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ret := []mapItemMeta{}
+	for _, e := range entries {
+		if filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		var m mapItemMeta
+		if json.Unmarshal(b, &m) == nil {
+			ret = append(ret, m)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].ID < ret[j].ID
+	})
+	return ret, nil
+}
+
+// who captured the map is shown same way as in the rest of the ui
+func nameMapItemPlayers(maps []mapItemMeta, names playerNamer) {
+	for i := range maps {
+		maps[i].Player = names(maps[i].Player)
+	}
+}
+
+func apiListMaps(w http.ResponseWriter, r *http.Request) (int, string) {
+	maps, err := listMapItems(mux.Vars(r)["world"])
+	if err != nil {
+		return 400, err.Error()
+	}
+	nameMapItemPlayers(maps, playerNamerFor(r))
+	setContentTypeJson(w)
+	return marshalOrFail(200, maps)
+}
+
+func mapsHandler(w http.ResponseWriter, r *http.Request) {
+	wname := mux.Vars(r)["world"]
+	maps, err := listMapItems(wname)
+	if err != nil {
+		plainmsg(w, r, plainmsgColorRed, "Failed to list maps: "+err.Error())
+		return
+	}
+	nameMapItemPlayers(maps, playerNamerFor(r))
+	templateRespond("maps", w, r, map[string]any{
+		"World": wname,
+		"Maps":  maps,
+	})
+}
+
+func mapImageHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	dir, err := getMapsPath(params["world"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	http.ServeFile(w, r, filepath.Join(dir, params["map"]+".png"))
+}
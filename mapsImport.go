@@ -0,0 +1,152 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/maxsupermanhd/go-vmc/v764/nbt"
+)
+
+// contents of data/map_N.dat, dimension was a byte before 1.16
+type mapDatFile struct {
+	Data struct {
+		Scale     int8           `nbt:"scale"`
+		Dimension nbt.RawMessage `nbt:"dimension"`
+		Locked    bool           `nbt:"locked"`
+		XCenter   int32          `nbt:"xCenter"`
+		ZCenter   int32          `nbt:"zCenter"`
+		Colors    []byte         `nbt:"colors"`
+	} `nbt:"data"`
+}
+
+func mapDatDimension(raw nbt.RawMessage) string {
+	var s string
+	if raw.Unmarshal(&s) == nil {
+		return strings.TrimPrefix(s, "minecraft:")
+	}
+	var i int32
+	if raw.Unmarshal(&i) != nil {
+		var b int8
+		if raw.Unmarshal(&b) != nil {
+			return ""
+		}
+		i = int32(b)
+	}
+	switch i {
+	case 0:
+		return "overworld"
+	case -1:
+		return "the_nether"
+	case 1:
+		return "the_end"
+	}
+	return ""
+}
+
+func parseMapDat(r io.Reader) (*mapItem, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+	var f mapDatFile
+	if _, err := nbt.NewDecoder(gr).Decode(&f); err != nil {
+		return nil, err
+	}
+	if len(f.Data.Colors) != mapItemSize*mapItemSize {
+		return nil, fmt.Errorf("map has %d colors instead of %d", len(f.Data.Colors), mapItemSize*mapItemSize)
+	}
+	m := &mapItem{mapItemMeta: mapItemMeta{
+		Scale:         f.Data.Scale,
+		Locked:        f.Data.Locked,
+		Dimension:     mapDatDimension(f.Data.Dimension),
+		XCenter:       int(f.Data.XCenter),
+		ZCenter:       int(f.Data.ZCenter),
+		Georeferenced: true,
+	}}
+	copy(m.Colors[:], f.Data.Colors)
+	return m, nil
+}
+
+// takes any number of map_N.dat files in "maps" multipart field,
+// id comes from the file name so whole data folder can be thrown at it
+func apiImportMaps(w http.ResponseWriter, r *http.Request) (int, string) {
+	wname := mux.Vars(r)["world"]
+	if _, err := getMapsPath(wname); err != nil {
+		return 400, err.Error()
+	}
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		return bodyReadErrorStatus(err), "Failed to parse form: " + err.Error()
+	}
+	type importResult struct {
+		File  string
+		ID    int32
+		Error string `json:",omitempty"`
+	}
+	ret := []importResult{}
+	for _, fh := range r.MultipartForm.File["maps"] {
+		name := filepath.Base(fh.Filename)
+		var id int32
+		if _, err := fmt.Sscanf(name, "map_%d.dat", &id); err != nil || name != fmt.Sprintf("map_%d.dat", id) {
+			continue
+		}
+		res := importResult{File: name, ID: id}
+		f, err := fh.Open()
+		if err == nil {
+			err = importMapDat(wname, id, f)
+			f.Close()
+		}
+		if err != nil {
+			res.Error = err.Error()
+			log.Printf("Failed to import %s to %s: %s", name, wname, err.Error())
+		}
+		ret = append(ret, res)
+	}
+	if len(ret) == 0 {
+		return 400, "No map_N.dat files found in upload"
+	}
+	setContentTypeJson(w)
+	return marshalOrFail(200, ret)
+}
+
+func importMapDat(wname string, id int32, r io.Reader) error {
+	m, err := parseMapDat(r)
+	if err != nil {
+		return err
+	}
+	m.ID = id
+	m.Updated = time.Now()
+	m.Player = "import"
+	mapItemsLock.Lock()
+	defer mapItemsLock.Unlock()
+	// imported file replaces whatever proxy captured with the same id
+	mapItems[mapItemKey{world: wname, id: id}] = m
+	return saveMapItem(wname, m)
+}
@@ -0,0 +1,130 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/maxsupermanhd/WebChunk/proxy"
+)
+
+// markers are kept same way as farms, last record with the name wins
+type markerRecord struct {
+	Name    string
+	X, Y, Z int
+	Author  string `json:",omitempty"`
+	Deleted bool   `json:",omitempty"`
+	Time    time.Time
+}
+
+func listMarkers(wname, dname string, names playerNamer) ([]markerRecord, error) {
+	markers := map[string]markerRecord{}
+	err := recs.Read(wname, dname, "markers", func(m json.RawMessage) error {
+		var r markerRecord
+		if json.Unmarshal(m, &r) != nil {
+			return nil
+		}
+		if r.Deleted {
+			delete(markers, r.Name)
+		} else {
+			r.Author = names(r.Author)
+			markers[r.Name] = r
+		}
+		return nil
+	})
+	ret := make([]markerRecord, 0, len(markers))
+	for _, m := range markers {
+		ret = append(ret, m)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	return ret, err
+}
+
+func markerReceived(e *proxy.ProxiedEvent, d proxy.EventMarker) {
+	err := recs.Append(e.Server, strings.TrimPrefix(e.Dimension, "minecraft:"), "markers", markerRecord{
+		Name:    d.Name,
+		X:       d.X,
+		Y:       d.Y,
+		Z:       d.Z,
+		Author:  e.Username,
+		Deleted: d.Remove,
+		Time:    e.Time,
+	})
+	if err != nil {
+		log.Printf("Failed to save marker %q of %s: %s", d.Name, e.Username, err.Error())
+	}
+}
+
+func apiListMarkers(w http.ResponseWriter, r *http.Request) (int, string) {
+	params := mux.Vars(r)
+	markers, err := listMarkers(params["world"], params["dim"], playerNamerFor(r))
+	if err != nil {
+		return 500, err.Error()
+	}
+	setContentTypeJson(w)
+	return marshalOrFail(200, markers)
+}
+
+func apiAddMarker(w http.ResponseWriter, r *http.Request) (int, string) {
+	params := mux.Vars(r)
+	name := r.FormValue("name")
+	if name == "" {
+		return 400, "Empty name"
+	}
+	q, err := parseFormInts(r, "x", "y", "z")
+	if err != nil {
+		return 400, err.Error()
+	}
+	m := markerRecord{
+		Name: name,
+		X:    q[0],
+		Y:    q[1],
+		Z:    q[2],
+		Time: time.Now(),
+	}
+	err = recs.Append(params["world"], params["dim"], "markers", m)
+	if err != nil {
+		return 500, err.Error()
+	}
+	setContentTypeJson(w)
+	return marshalOrFail(200, m)
+}
+
+func apiDeleteMarker(_ http.ResponseWriter, r *http.Request) (int, string) {
+	params := mux.Vars(r)
+	err := recs.Append(params["world"], params["dim"], "markers", markerRecord{
+		Name:    params["marker"],
+		Deleted: true,
+		Time:    time.Now(),
+	})
+	if err != nil {
+		return 500, err.Error()
+	}
+	return 200, "Marker deleted"
+}
@@ -0,0 +1,105 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/maxsupermanhd/WebChunk/proxy"
+)
+
+// prometheus text exposition of render timings and proxy traffic
+func metricsHandler(w http.ResponseWriter, _ *http.Request) {
+	var b strings.Builder
+	metricsLock.Lock()
+	painters := make([]string, 0, len(metrics))
+	for k := range metrics {
+		painters = append(painters, k)
+	}
+	sort.Strings(painters)
+	writeMetricHeader(&b, "webchunk_render_seconds_total", "counter", "Time spent painting chunks")
+	for _, k := range painters {
+		fmt.Fprintf(&b, "webchunk_render_seconds_total{painter=%q} %f\n", k, metrics[k].sum.Seconds())
+	}
+	writeMetricHeader(&b, "webchunk_render_chunks_total", "counter", "Chunks painted")
+	for _, k := range painters {
+		fmt.Fprintf(&b, "webchunk_render_chunks_total{painter=%q} %d\n", k, metrics[k].count)
+	}
+	metricsLock.Unlock()
+
+	m := proxy.GetMetrics()
+	writeMetricHeader(&b, "webchunk_proxy_connected_players", "gauge", "Live proxied sessions")
+	fmt.Fprintf(&b, "webchunk_proxy_connected_players %d\n", m.ConnectedPlayers)
+	writeMetricHeader(&b, "webchunk_proxy_wire_bytes_total", "counter", "Bytes between proxy and players on the wire")
+	fmt.Fprintf(&b, "webchunk_proxy_wire_bytes_total{direction=\"in\"} %d\n", m.ClientWireIn)
+	fmt.Fprintf(&b, "webchunk_proxy_wire_bytes_total{direction=\"out\"} %d\n", m.ClientWireOut)
+	writeMetricHeader(&b, "webchunk_proxy_payload_bytes_total", "counter", "Uncompressed packet bytes, in is server to client")
+	fmt.Fprintf(&b, "webchunk_proxy_payload_bytes_total{direction=\"in\"} %d\n", m.PayloadIn)
+	fmt.Fprintf(&b, "webchunk_proxy_payload_bytes_total{direction=\"out\"} %d\n", m.PayloadOut)
+	writeMetricHeader(&b, "webchunk_proxy_chunks_received_total", "counter", "Chunks received from servers")
+	fmt.Fprintf(&b, "webchunk_proxy_chunks_received_total %d\n", m.ChunksReceived)
+	writeMetricHeader(&b, "webchunk_proxy_chunks_captured_total", "counter", "Chunks passed capture filters and sent to storage")
+	fmt.Fprintf(&b, "webchunk_proxy_chunks_captured_total %d\n", m.ChunksCaptured)
+	writeMetricHeader(&b, "webchunk_proxy_chunks_captured_per_minute", "gauge", "Chunks captured during the last minute")
+	fmt.Fprintf(&b, "webchunk_proxy_chunks_captured_per_minute %d\n", m.ChunksCapturedPerMinute)
+	writeMetricHeader(&b, "webchunk_proxy_block_updates_total", "counter", "Block changes applied to captured chunks")
+	fmt.Fprintf(&b, "webchunk_proxy_block_updates_total %d\n", m.BlockUpdates)
+	writeMetricHeader(&b, "webchunk_proxy_packets_total", "counter", "Proxied packets by type")
+	writePacketCounts(&b, "in", m.PacketsIn)
+	writePacketCounts(&b, "out", m.PacketsOut)
+
+	writeMetricHeader(&b, "webchunk_proxy_session_wire_bytes", "gauge", "Bytes between proxy and player of live session")
+	for _, s := range m.Sessions {
+		fmt.Fprintf(&b, "webchunk_proxy_session_wire_bytes{session=\"%d\",player=%q,server=%q,direction=\"in\"} %d\n", s.ID, s.Username, s.Server, s.ClientWireIn)
+		fmt.Fprintf(&b, "webchunk_proxy_session_wire_bytes{session=\"%d\",player=%q,server=%q,direction=\"out\"} %d\n", s.ID, s.Username, s.Server, s.ClientWireOut)
+	}
+	writeMetricHeader(&b, "webchunk_proxy_session_chunks_captured", "gauge", "Chunks captured by live session")
+	for _, s := range m.Sessions {
+		fmt.Fprintf(&b, "webchunk_proxy_session_chunks_captured{session=\"%d\",player=%q,server=%q} %d\n", s.ID, s.Username, s.Server, s.ChunksForwarded)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(b.String()))
+}
+
+func writeMetricHeader(b *strings.Builder, name, kind, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writePacketCounts(b *strings.Builder, direction string, counts map[string]int64) {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(b, "webchunk_proxy_packets_total{direction=%q,packet=%q} %d\n", direction, k, counts[k])
+	}
+}
+
+func apiProxyMetrics(w http.ResponseWriter, _ *http.Request) (int, string) {
+	setContentTypeJson(w)
+	return marshalOrFail(200, proxy.GetMetrics())
+}
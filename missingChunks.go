@@ -0,0 +1,85 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"log"
+)
+
+const missingChunksDefaultColor = "#1e1e1eff"
+
+// how chunks that are not in the storage look on base layers,
+// one of transparent, checkerboard, solid or hatch
+func missingChunksStyle() (string, color.RGBA) {
+	style := cfg.GetDSString("transparent", "missing_chunks", "style")
+	switch style {
+	case "transparent", "checkerboard", "solid", "hatch":
+	default:
+		log.Printf("Unknown missing chunks style [%s], leaving them transparent", style)
+		return "transparent", color.RGBA{}
+	}
+	hex := cfg.GetDSString(missingChunksDefaultColor, "missing_chunks", "color")
+	c, err := ParseHexColor(hex)
+	if err != nil {
+		log.Printf("Bad missing chunks color [%s]: %s", hex, err.Error())
+		c, _ = ParseHexColor(missingChunksDefaultColor)
+	}
+	return style, color.RGBA{uint8(c.R >> 8), uint8(c.G >> 8), uint8(c.B >> 8), uint8(c.A >> 8)}
+}
+
+// overlays are drawn on top of other layers and always keep missing chunks empty
+func layerIsOverlay(variant string) bool {
+	name := layerBaseName(variant)
+	for t := range ttypes.All() {
+		if t.Name == name {
+			return t.IsOverlay
+		}
+	}
+	return false
+}
+
+// fills rectangle of the tile with the style, pattern is aligned to the
+// tile and not the rectangle so neighbouring missing chunks blend together
+func drawMissingChunk(img *image.RGBA, rect image.Rectangle, style string, c color.RGBA) {
+	switch style {
+	case "solid":
+		draw.Draw(img, rect, &image.Uniform{c}, image.Point{}, draw.Src)
+	case "checkerboard":
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				if (x/8+y/8)%2 == 0 {
+					img.SetRGBA(x, y, c)
+				}
+			}
+		}
+	case "hatch":
+		for y := rect.Min.Y; y < rect.Max.Y; y++ {
+			for x := rect.Min.X; x < rect.Max.X; x++ {
+				if (x+y)%8 < 2 {
+					img.SetRGBA(x, y, c)
+				}
+			}
+		}
+	}
+}
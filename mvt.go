@@ -0,0 +1,360 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/maxsupermanhd/WebChunk/chunkStorage"
+	"github.com/maxsupermanhd/WebChunk/lib/mvt"
+	"github.com/maxsupermanhd/WebChunk/primitives"
+	"github.com/maxsupermanhd/go-vmc/v764/save"
+)
+
+// structure starts referenced from the tile but lying outside of it are
+// loaded one by one, that many at most
+const mvtMaxReferencedStarts = 64
+
+type mvtStructureStart struct {
+	ID       string `nbt:"id"`
+	Children []struct {
+		BB []int32 `nbt:"BB"`
+	} `nbt:"Children"`
+}
+
+type mvtStructures struct {
+	Starts     map[string]mvtStructureStart `nbt:"starts"`
+	References map[string][]int64           `nbt:"References"`
+}
+
+type structureBox struct {
+	ID               string
+	MinX, MinY, MinZ int
+	MaxX, MaxY, MaxZ int
+}
+
+// bounding box of the structure is union of boxes of its pieces
+func (s mvtStructureStart) box() (structureBox, bool) {
+	ret := structureBox{ID: strings.TrimPrefix(s.ID, "minecraft:")}
+	found := false
+	for _, c := range s.Children {
+		if len(c.BB) != 6 {
+			continue
+		}
+		if !found {
+			ret.MinX, ret.MinY, ret.MinZ = int(c.BB[0]), int(c.BB[1]), int(c.BB[2])
+			ret.MaxX, ret.MaxY, ret.MaxZ = int(c.BB[3]), int(c.BB[4]), int(c.BB[5])
+			found = true
+			continue
+		}
+		ret.MinX, ret.MinY, ret.MinZ = minInt(ret.MinX, int(c.BB[0])), minInt(ret.MinY, int(c.BB[1])), minInt(ret.MinZ, int(c.BB[2]))
+		ret.MaxX, ret.MaxY, ret.MaxZ = maxInt(ret.MaxX, int(c.BB[3])), maxInt(ret.MaxY, int(c.BB[4])), maxInt(ret.MaxZ, int(c.BB[5]))
+	}
+	return ret, found && s.ID != "INVALID"
+}
+
+// structures are stored in the chunk they start at, chunks they reach into
+// only reference it so starts outside of the area are looked up too
+func listStructureBoxes(s chunkStorage.ChunkStorage, wname, dname string, cx0, cz0, cx1, cz1 int) ([]structureBox, error) {
+	chunks, err := s.GetChunksRegion(wname, dname, cx0, cz0, cx1, cz1)
+	if err != nil {
+		return nil, err
+	}
+	ret := []structureBox{}
+	visited := map[[2]int]bool{}
+	referenced := map[[2]int]bool{}
+	collect := func(c *save.Chunk, refs bool) {
+		var st mvtStructures
+		if len(c.Structures.Data) == 0 || c.Structures.Unmarshal(&st) != nil {
+			return
+		}
+		for _, start := range st.Starts {
+			if b, ok := start.box(); ok {
+				ret = append(ret, b)
+			}
+		}
+		if !refs {
+			return
+		}
+		for _, positions := range st.References {
+			for _, p := range positions {
+				referenced[[2]int{int(int32(p)), int(int32(p >> 32))}] = true
+			}
+		}
+	}
+	for _, c := range chunks {
+		chunk, ok := c.Data.(save.Chunk)
+		if !ok {
+			continue
+		}
+		visited[[2]int{c.X, c.Z}] = true
+		collect(&chunk, true)
+	}
+	loaded := 0
+	for pos := range referenced {
+		if visited[pos] || loaded >= mvtMaxReferencedStarts {
+			continue
+		}
+		loaded++
+		chunk, err := s.GetChunk(wname, dname, pos[0], pos[1])
+		if err != nil || chunk == nil {
+			continue
+		}
+		collect(chunk, false)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].MinX != ret[j].MinX {
+			return ret[i].MinX < ret[j].MinX
+		}
+		return ret[i].MinZ < ret[j].MinZ
+	})
+	return ret, nil
+}
+
+// tile projection, block coordinates are doubled so points can sit in block centers
+type mvtProjection struct {
+	x0, z0, size int
+}
+
+func (p mvtProjection) at(x2, z2 int) [2]int {
+	return [2]int{
+		floorDiv((x2-2*p.x0)*mvt.DefaultExtent, 2*p.size),
+		floorDiv((z2-2*p.z0)*mvt.DefaultExtent, 2*p.size),
+	}
+}
+
+func (p mvtProjection) point(x, z int) [][][2]int {
+	return [][][2]int{{p.at(2*x+1, 2*z+1)}}
+}
+
+// clockwise with y pointing down, x1 and z1 are exclusive
+func (p mvtProjection) rect(x0, z0, x1, z1 int) [][][2]int {
+	return [][][2]int{{p.at(2*x0, 2*z0), p.at(2*x1, 2*z0), p.at(2*x1, 2*z1), p.at(2*x0, 2*z1)}}
+}
+
+func (p mvtProjection) contains(x, z int) bool {
+	return x >= p.x0 && z >= p.z0 && x < p.x0+p.size && z < p.z0+p.size
+}
+
+func mvtChunksLayer(s chunkStorage.ChunkStorage, loc primitives.ImageLocation, p mvtProjection) (mvt.Layer, error) {
+	l := mvt.Layer{Name: "chunks"}
+	cx0, cz0, cx1, cz1 := tileChunkRange(loc)
+	chunks, err := s.GetChunksCountRegion(loc.World, loc.Dimension, cx0, cz0, cx1, cz1)
+	if err != nil {
+		return l, err
+	}
+	sort.Slice(chunks, func(i, j int) bool {
+		if chunks[i].Z != chunks[j].Z {
+			return chunks[i].Z < chunks[j].Z
+		}
+		return chunks[i].X < chunks[j].X
+	})
+	for _, c := range chunks {
+		l.Features = append(l.Features, mvt.Feature{
+			Type:       mvt.Polygon,
+			Geometry:   p.rect(c.X*16, c.Z*16, c.X*16+16, c.Z*16+16),
+			Properties: map[string]interface{}{"x": c.X, "z": c.Z},
+		})
+	}
+	return l, nil
+}
+
+func mvtStructuresLayer(s chunkStorage.ChunkStorage, loc primitives.ImageLocation, p mvtProjection) (mvt.Layer, error) {
+	l := mvt.Layer{Name: "structures"}
+	cx0, cz0, cx1, cz1 := tileChunkRange(loc)
+	boxes, err := listStructureBoxes(s, loc.World, loc.Dimension, cx0, cz0, cx1, cz1)
+	if err != nil {
+		return l, err
+	}
+	for _, b := range boxes {
+		l.Features = append(l.Features, mvt.Feature{
+			Type:     mvt.Polygon,
+			Geometry: p.rect(b.MinX, b.MinZ, b.MaxX+1, b.MaxZ+1),
+			Properties: map[string]interface{}{
+				"id":    b.ID,
+				"min_y": b.MinY,
+				"max_y": b.MaxY,
+			},
+		})
+	}
+	return l, nil
+}
+
+func mvtBlockMarkersLayer(loc primitives.ImageLocation, p mvtProjection) mvt.Layer {
+	l := mvt.Layer{Name: "blockmarkers"}
+	for _, m := range listBlockMarkers(loc.World, loc.Dimension, "", p.x0, p.z0, p.x0+p.size, p.z0+p.size) {
+		l.Features = append(l.Features, mvt.Feature{
+			Type:       mvt.Point,
+			Geometry:   p.point(m.X, m.Z),
+			Properties: map[string]interface{}{"kind": m.Kind, "y": m.Y},
+		})
+	}
+	return l
+}
+
+func mvtMarkersLayer(r *http.Request, loc primitives.ImageLocation, p mvtProjection) (mvt.Layer, error) {
+	l := mvt.Layer{Name: "markers"}
+	markers, err := listMarkers(loc.World, loc.Dimension, playerNamerFor(r))
+	for _, m := range markers {
+		if !p.contains(m.X, m.Z) {
+			continue
+		}
+		l.Features = append(l.Features, mvt.Feature{
+			Type:       mvt.Point,
+			Geometry:   p.point(m.X, m.Z),
+			Properties: map[string]interface{}{"name": m.Name, "y": m.Y},
+		})
+	}
+	return l, err
+}
+
+// only text of signs goes out, players who placed them are left for sign search
+func mvtSignsLayer(loc primitives.ImageLocation, p mvtProjection) mvt.Layer {
+	l := mvt.Layer{Name: "signs"}
+	signs := []signRecord{}
+	signIndexLock.Lock()
+	for pos, s := range getSignIndex(loc.World, loc.Dimension) {
+		if p.contains(pos[0], pos[2]) {
+			signs = append(signs, s)
+		}
+	}
+	signIndexLock.Unlock()
+	sort.Slice(signs, func(i, j int) bool {
+		if signs[i].X != signs[j].X {
+			return signs[i].X < signs[j].X
+		}
+		return signs[i].Z < signs[j].Z
+	})
+	for _, s := range signs {
+		props := map[string]interface{}{"text": strings.Join(s.Front, "\n"), "y": s.Y}
+		if len(s.Back) > 0 {
+			props["back"] = strings.Join(s.Back, "\n")
+		}
+		l.Features = append(l.Features, mvt.Feature{
+			Type:       mvt.Point,
+			Geometry:   p.point(s.X, s.Z),
+			Properties: props,
+		})
+	}
+	return l
+}
+
+// vector tiles share addressing with xyz raster tiles, every vector layer
+// follows layer access of the same name
+func apiMvtTile(w http.ResponseWriter, r *http.Request) (int, string) {
+	params := mux.Vars(r)
+	wname, dname := params["world"], params["dim"]
+	z, errz := strconv.Atoi(params["z"])
+	x, errx := strconv.Atoi(params["x"])
+	y, erry := strconv.Atoi(params["y"])
+	if errz != nil || errx != nil || erry != nil {
+		return 400, "Bad tile address"
+	}
+	cs, cx, cz, ok := xyzToTile(z, x, y)
+	if !ok {
+		return 204, ""
+	}
+	if _, code, err := lookupDim(wname, dname); err != nil {
+		return code, err.Error()
+	}
+	_, s, err := storages.World(wname)
+	if err != nil {
+		return 500, err.Error()
+	}
+	loc := primitives.ImageLocation{World: wname, Dimension: dname, S: cs, X: cx, Z: cz}
+	cx0, cz0, _, _ := tileChunkRange(loc)
+	p := mvtProjection{x0: cx0 * 16, z0: cz0 * 16, size: 16 << cs}
+	layers := []mvt.Layer{}
+	if cs <= cfg.GetDSInt(5, "mvt", "chunks_max_scale") && layerAllowed(r, wname, dname, "chunks") {
+		l, err := mvtChunksLayer(s, loc, p)
+		if err != nil {
+			return 500, err.Error()
+		}
+		layers = append(layers, l)
+	}
+	if cs <= cfg.GetDSInt(4, "mvt", "structures_max_scale") && layerAllowed(r, wname, dname, "structures") {
+		l, err := mvtStructuresLayer(s, loc, p)
+		if err != nil {
+			return 500, err.Error()
+		}
+		layers = append(layers, l)
+	}
+	if layerAllowed(r, wname, dname, "blockmarkers") {
+		layers = append(layers, mvtBlockMarkersLayer(loc, p))
+	}
+	if layerAllowed(r, wname, dname, "markers") {
+		l, err := mvtMarkersLayer(r, loc, p)
+		if err != nil {
+			return 500, err.Error()
+		}
+		layers = append(layers, l)
+	}
+	if layerAllowed(r, wname, dname, "signs") {
+		layers = append(layers, mvtSignsLayer(loc, p))
+	}
+	nonEmpty := layers[:0]
+	for _, l := range layers {
+		if len(l.Features) > 0 {
+			nonEmpty = append(nonEmpty, l)
+		}
+	}
+	if len(nonEmpty) == 0 {
+		return 204, ""
+	}
+	b := mvt.Encode(nonEmpty)
+	w.Header().Set("Content-Type", "application/vnd.mapbox-vector-tile")
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	w.WriteHeader(200)
+	w.Write(b)
+	return -1, ""
+}
+
+func apiMvtTileJSON(w http.ResponseWriter, r *http.Request) (int, string) {
+	params := mux.Vars(r)
+	wname, dname := params["world"], params["dim"]
+	if _, code, err := lookupDim(wname, dname); err != nil {
+		return code, err.Error()
+	}
+	ret := newTileJSON(r, "/api/v1/mvt/"+url.PathEscape(wname)+"/"+url.PathEscape(dname), "pbf")
+	ret.Name = wname + " " + dname
+	minZoom := func(maxScale int) int {
+		return maxInt(ret.MinZoom, ret.MaxZoom-maxScale)
+	}
+	layers := []tileJSONVectorLayer{
+		{ID: "chunks", Fields: map[string]string{"x": "Number", "z": "Number"}, MinZoom: minZoom(cfg.GetDSInt(5, "mvt", "chunks_max_scale"))},
+		{ID: "structures", Fields: map[string]string{"id": "String", "min_y": "Number", "max_y": "Number"}, MinZoom: minZoom(cfg.GetDSInt(4, "mvt", "structures_max_scale"))},
+		{ID: "blockmarkers", Fields: map[string]string{"kind": "String", "y": "Number"}},
+		{ID: "markers", Fields: map[string]string{"name": "String", "y": "Number"}},
+		{ID: "signs", Fields: map[string]string{"text": "String", "back": "String", "y": "Number"}},
+	}
+	for _, l := range layers {
+		if layerAllowed(r, wname, dname, l.ID) {
+			ret.VectorLayers = append(ret.VectorLayers, l)
+		}
+	}
+	setContentTypeJson(w)
+	return marshalOrFail(200, ret)
+}
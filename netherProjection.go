@@ -0,0 +1,116 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"image"
+	"image/draw"
+	"log"
+	"sync"
+
+	"github.com/maxsupermanhd/WebChunk/chunkStorage"
+	"github.com/maxsupermanhd/go-vmc/v764/save"
+	xdraw "golang.org/x/image/draw"
+)
+
+// every overworld chunk gets 2x2 blocks of nether chunk covering it
+type netherSlice struct {
+	chunk  *save.Chunk
+	ox, oz int
+}
+
+// nether dimension of the world is set in config or found by its coordinate scale
+func netherDimension(s chunkStorage.ChunkStorage, wname string) string {
+	if d := cfg.GetDSString("", "layers", "netherprojection", "dimension"); d != "" {
+		return d
+	}
+	dims, err := s.ListWorldDimensions(wname)
+	if err != nil {
+		log.Printf("Failed to list dimensions of world [%s]: %s", wname, err.Error())
+		return ""
+	}
+	for _, d := range dims {
+		if dimensionType(s, wname, d.Name).CoordinatesScale > 1 {
+			return d.Name
+		}
+	}
+	return ""
+}
+
+func netherProjectionProvider(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
+	getter := func(wname, dname string, cx0, cz0, cx1, cz1 int) ([]chunkStorage.ChunkData, error) {
+		ret := []chunkStorage.ChunkData{}
+		// projecting nether onto itself or the end makes no sense
+		if dimensionType(s, wname, dname).CoordinatesScale != 1 {
+			return ret, nil
+		}
+		nether := netherDimension(s, wname)
+		if nether == "" || nether == dname {
+			return ret, nil
+		}
+		nx0, nz0 := floorDiv(cx0, 8), floorDiv(cz0, 8)
+		nx1, nz1 := floorDiv(cx1-1, 8)+1, floorDiv(cz1-1, 8)+1
+		cc, err := s.GetChunksRegion(wname, nether, nx0, nz0, nx1, nz1)
+		if err != nil {
+			return ret, err
+		}
+		for _, c := range cc {
+			chunk, ok := c.Data.(save.Chunk)
+			if !ok {
+				continue
+			}
+			for x := maxInt(c.X*8, cx0); x < minInt(c.X*8+8, cx1); x++ {
+				for z := maxInt(c.Z*8, cz0); z < minInt(c.Z*8+8, cz1); z++ {
+					ret = append(ret, chunkStorage.ChunkData{X: x, Z: z, Data: netherSlice{chunk: &chunk, ox: (x - c.X*8) * 2, oz: (z - c.Z*8) * 2}})
+				}
+			}
+		}
+		return ret, nil
+	}
+	// slices of one nether chunk share its drawing
+	type paintedChunk struct {
+		once sync.Once
+		img  *image.RGBA
+	}
+	painted := map[*save.Chunk]*paintedChunk{}
+	paintedLock := sync.Mutex{}
+	painter := func(i interface{}) *image.RGBA {
+		d := i.(netherSlice)
+		paintedLock.Lock()
+		p, ok := painted[d.chunk]
+		if !ok {
+			p = &paintedChunk{}
+			painted[d.chunk] = p
+		}
+		paintedLock.Unlock()
+		p.once.Do(func() {
+			p.img = drawChunkBelowRoof(d.chunk, cfg.GetDSInt(127, "layers", "netherfloor", "roof_y"))
+		})
+		img := p.img
+		if img == nil {
+			return nil
+		}
+		ret := image.NewRGBA(image.Rect(0, 0, 16, 16))
+		xdraw.NearestNeighbor.Scale(ret, ret.Rect, img, image.Rect(d.ox, d.oz, d.ox+2, d.oz+2), draw.Src, nil)
+		return ret
+	}
+	return getter, painter
+}
@@ -0,0 +1,96 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"log"
+	"os"
+	"time"
+
+	"github.com/maxsupermanhd/go-vmc/v764/save"
+)
+
+// block light of the surface, taken from the block itself (light sources
+// store their own level) or air right above it, whichever is brighter
+func surfaceBlockLight(chunk *save.Chunk) (ret [16 * 16]int) {
+	sortSectionsTopDown(chunk)
+	var done [16 * 16]bool
+	for _, s := range chunk.Sections {
+		if len(s.BlockStates.Palette) == 0 {
+			continue
+		}
+		states := prepareSectionBlockstates(&s)
+		if states == nil {
+			if os.Getenv("REPORT_CHUNK_PROBLEMS") == "yes" || os.Getenv("REPORT_CHUNK_PROBLEMS") == "all" {
+				log.Printf("Chunk %d:%d section %d has broken pallete", chunk.XPos, chunk.YPos, s.Y)
+			}
+			continue
+		}
+		for y := 15; y >= 0; y-- {
+			for i := 16*16 - 1; i >= 0; i-- {
+				if done[i] {
+					continue
+				}
+				ii := y*16*16 + i
+				l := maxInt(sectionLight(s.BlockLight, ii), 0)
+				if isAirState(states.Get(ii)) {
+					ret[i] = l
+					continue
+				}
+				done[i] = true
+				ret[i] = maxInt(ret[i], l)
+			}
+		}
+	}
+	return
+}
+
+// terrain as it looks at night, dimmed and tinted blue where it is dark
+// and warm where block light reaches the surface
+func drawChunkNight(chunk *save.Chunk) (img *image.RGBA) {
+	t := time.Now()
+	img = drawChunk(chunk)
+	light := surfaceBlockLight(chunk)
+	dark := float64(clampInt(cfg.GetDSInt(25, "layers", "night", "brightness"), 0, 100)) / 100
+	for i, l := range light {
+		c := img.RGBAAt(i%16, i/16)
+		if c.A == 0 {
+			continue
+		}
+		lit := float64(l) / 15
+		lit *= lit
+		f := dark + (1-dark)*lit
+		scale := func(v uint8, tint float64) uint8 {
+			return uint8(clampInt(int(float64(v)*f*tint), 0, 255))
+		}
+		// moonlight is bluish, torches are yellowish
+		img.SetRGBA(i%16, i/16, color.RGBA{
+			R: scale(c.R, 0.8+0.3*lit),
+			G: scale(c.G, 0.85+0.15*lit),
+			B: scale(c.B, 1.2-0.3*lit),
+			A: c.A,
+		})
+	}
+	appendMetrics(time.Since(t), "night")
+	return img
+}
@@ -0,0 +1,89 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"errors"
+	"image"
+	"net/http"
+	"strings"
+
+	"github.com/maxsupermanhd/WebChunk/chunkStorage"
+	"github.com/maxsupermanhd/go-vmc/v764/level/block"
+	"github.com/maxsupermanhd/go-vmc/v764/save"
+)
+
+const oreDensityDefaultBlock = "diamond_ore"
+
+// block id without minecraft: prefix, empty if there is no such block
+func oreDensityBlock(id string) string {
+	id = strings.TrimPrefix(id, "minecraft:")
+	if _, ok := block.FromID["minecraft:"+id]; !ok {
+		return ""
+	}
+	return id
+}
+
+// every block is cached as its own variant
+func oreDensityVariant(r *http.Request) (string, error) {
+	q := r.URL.Query().Get("block")
+	if q == "" {
+		return "oredensity", nil
+	}
+	id := oreDensityBlock(q)
+	if id == "" {
+		return "", errors.New("unknown block")
+	}
+	return "oredensity_" + id, nil
+}
+
+func oreDensityVariantProvider(variant string) (ttypeProviderFunc, bool) {
+	if variant == "oredensity" {
+		return oreDensityProvider(""), true
+	}
+	id, ok := strings.CutPrefix(variant, "oredensity_")
+	if !ok || oreDensityBlock(id) == "" {
+		return nil, false
+	}
+	return oreDensityProvider(id), true
+}
+
+// empty id draws block from config
+func oreDensityProvider(id string) ttypeProviderFunc {
+	l := heatLayer{
+		data: chunkRegion,
+		value: func(i interface{}) float64 {
+			c := i.(save.Chunk)
+			b := id
+			if b == "" {
+				b = oreDensityBlock(cfg.GetDSString(oreDensityDefaultBlock, "layers", "oredensity", "block"))
+			}
+			return float64(countBlocks(&c, map[string]bool{"minecraft:" + b: true}))
+		},
+		palette: "heat",
+		max:     heatMax("oredensity", 16),
+	}
+	return func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
+		return l.data(s), func(i interface{}) *image.RGBA {
+			return drawHeat("oredensity", l, heatOptions{}, l.value(i))
+		}
+	}
+}
@@ -0,0 +1,77 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"context"
+	"runtime"
+	"sync"
+
+	"github.com/maxsupermanhd/WebChunk/chunkStorage"
+)
+
+func renderWorkers() int {
+	n := cfg.GetDSInt(runtime.NumCPU(), "render_workers")
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// runs fn for every chunk on a pool of render workers, chunks
+// always land in their own part of the tile so fn can draw without locking
+// stops handing out chunks once ctx is done
+func forEachChunkParallel(ctx context.Context, cc []chunkStorage.ChunkData, fn func(c chunkStorage.ChunkData)) {
+	workers := renderWorkers()
+	if workers > len(cc) {
+		workers = len(cc)
+	}
+	if workers <= 1 {
+		for _, c := range cc {
+			if ctx.Err() != nil {
+				return
+			}
+			fn(c)
+		}
+		return
+	}
+	queue := make(chan chunkStorage.ChunkData)
+	wg := sync.WaitGroup{}
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for c := range queue {
+				fn(c)
+			}
+		}()
+	}
+feed:
+	for _, c := range cc {
+		select {
+		case <-ctx.Done():
+			break feed
+		case queue <- c:
+		}
+	}
+	close(queue)
+	wg.Wait()
+}
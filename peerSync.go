@@ -0,0 +1,353 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/maxsupermanhd/WebChunk/chunkStorage"
+	"github.com/maxsupermanhd/WebChunk/client"
+)
+
+// how many chunks are requested from a peer at once
+const peerSyncBatch = 256
+
+type syncListEntry = client.SyncListEntry
+
+type syncChunk = client.SyncChunk
+
+type syncDimReport = client.SyncDimReport
+
+type syncPeer struct {
+	URL     string            `json:"url"`
+	Worlds  []string          `json:"worlds"`
+	Headers map[string]string `json:"headers"`
+}
+
+var (
+	// last modification time seen on peer per world and dimension,
+	// starts from zero after restart so first sync compares everything
+	syncCursors     = map[string]time.Time{}
+	syncCursorsLock sync.Mutex
+	syncLock        sync.Mutex
+)
+
+func chunkHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16])
+}
+
+// accepts both RFC3339 and unix seconds
+func parseSyncSince(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Unix(n, 0), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func listSyncChunks(wname, dname string, since time.Time, hashes bool) ([]syncListEntry, error) {
+	_, s, err := storages.World(wname)
+	if err != nil {
+		return nil, err
+	}
+	if s == nil {
+		return nil, chunkStorage.ErrNoWorld
+	}
+	mod, err := s.ListChunksModifiedSince(wname, dname, since)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]syncListEntry, 0, len(mod))
+	for _, c := range mod {
+		e := syncListEntry{X: c.X, Z: c.Z}
+		e.Modified, _ = c.Data.(time.Time)
+		if hashes {
+			raw, err := s.GetChunkRaw(wname, dname, c.X, c.Z)
+			if err != nil {
+				return nil, err
+			}
+			if len(raw) == 0 {
+				continue
+			}
+			e.Hash = chunkHash(raw)
+		}
+		ret = append(ret, e)
+	}
+	return ret, nil
+}
+
+func apiSyncListChunks(w http.ResponseWriter, r *http.Request) (int, string) {
+	params := mux.Vars(r)
+	since, err := parseSyncSince(r.FormValue("since"))
+	if err != nil {
+		return 400, "Bad since: " + err.Error()
+	}
+	list, err := listSyncChunks(params["world"], params["dim"], since, r.FormValue("hashes") != "false")
+	if errors.Is(err, chunkStorage.ErrNoWorld) || errors.Is(err, chunkStorage.ErrNoDim) {
+		return 404, err.Error()
+	}
+	if err != nil {
+		return 500, err.Error()
+	}
+	setContentTypeJson(w)
+	return marshalOrFail(200, list)
+}
+
+// body is a list of {x, z}, chunks that are not stored are left out of response
+func apiSyncGetChunks(w http.ResponseWriter, r *http.Request) (int, string) {
+	params := mux.Vars(r)
+	wname, dname := params["world"], params["dim"]
+	var req []syncListEntry
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return bodyReadErrorStatus(err), "Bad request: " + err.Error()
+	}
+	if len(req) > peerSyncBatch {
+		return 400, fmt.Sprintf("Too many chunks requested, limit is %d", peerSyncBatch)
+	}
+	_, s, err := storages.World(wname)
+	if err != nil {
+		return 500, err.Error()
+	}
+	if s == nil {
+		return 404, "World not found"
+	}
+	ret := []syncChunk{}
+	for _, c := range req {
+		raw, err := s.GetChunkRaw(wname, dname, c.X, c.Z)
+		if err != nil {
+			return 500, err.Error()
+		}
+		if len(raw) > 0 {
+			ret = append(ret, syncChunk{X: c.X, Z: c.Z, Data: raw})
+		}
+	}
+	setContentTypeJson(w)
+	return marshalOrFail(200, ret)
+}
+
+// storage to put pulled chunks to, world and dimension are created like submitted ones
+func syncLocalStorage(dim chunkStorage.SDim) (chunkStorage.ChunkStorage, error) {
+	world, s, err := storages.World(dim.World)
+	if err != nil {
+		return nil, err
+	}
+	if world == nil || s == nil {
+		pref := cfg.GetDSString("", "preferred_storage")
+		s = storages.Capable(pref)
+		if s == nil {
+			return nil, fmt.Errorf("no storage has world [%s] or can add it", dim.World)
+		}
+		err = s.AddWorld(chunkStorage.SWorld{
+			Name:       dim.World,
+			Alias:      dim.World,
+			CreatedAt:  time.Now(),
+			ModifiedAt: time.Now(),
+			Data:       chunkStorage.CreateDefaultLevelData(dim.World),
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	d, err := s.GetDimension(dim.World, dim.Name)
+	if err != nil && !errors.Is(err, chunkStorage.ErrNoDim) {
+		return nil, err
+	}
+	if d == nil {
+		dim.CreatedAt, dim.ModifiedAt = time.Now(), time.Now()
+		if err := s.AddDimension(dim.World, dim); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (p syncPeer) client() *client.Client {
+	c := client.New(p.URL)
+	for k, v := range p.Headers {
+		c.Headers[k] = v
+	}
+	return c
+}
+
+// pulls chunks that are missing here or changed on peer after local copy was stored,
+// equal hashes are skipped so two instances pulling from each other settle down
+func syncPullDim(peerName string, peer syncPeer, dim chunkStorage.SDim) syncDimReport {
+	rep := syncDimReport{World: dim.World, Dim: dim.Name}
+	fail := func(err error) syncDimReport {
+		rep.Error = err.Error()
+		return rep
+	}
+	cursorKey := peerName + "/" + dim.World + "/" + dim.Name
+	syncCursorsLock.Lock()
+	since := syncCursors[cursorKey]
+	syncCursorsLock.Unlock()
+	pc := peer.client()
+	list, err := pc.SyncList(context.Background(), dim.World, dim.Name, since, true)
+	if err != nil {
+		return fail(err)
+	}
+	rep.Listed = len(list)
+	s, err := syncLocalStorage(dim)
+	if err != nil {
+		return fail(err)
+	}
+	want := []syncListEntry{}
+	cursor := since
+	for _, c := range list {
+		if c.Modified.After(cursor) {
+			cursor = c.Modified
+		}
+		raw, err := s.GetChunkRaw(dim.World, dim.Name, c.X, c.Z)
+		if err != nil {
+			return fail(err)
+		}
+		if len(raw) > 0 {
+			if chunkHash(raw) == c.Hash {
+				rep.Skipped++
+				continue
+			}
+			mod, err := s.GetChunkModDate(dim.World, dim.Name, c.X, c.Z)
+			if err != nil {
+				return fail(err)
+			}
+			if mod != nil && !c.Modified.After(*mod) {
+				rep.Skipped++
+				continue
+			}
+		}
+		want = append(want, syncListEntry{X: c.X, Z: c.Z})
+	}
+	for len(want) > 0 {
+		batch := want[:minInt(len(want), peerSyncBatch)]
+		want = want[len(batch):]
+		chunks, err := pc.SyncChunks(context.Background(), dim.World, dim.Name, batch)
+		if err != nil {
+			return fail(err)
+		}
+		for _, c := range chunks {
+			if err := s.AddChunkRaw(dim.World, dim.Name, c.X, c.Z, c.Data); err != nil {
+				return fail(err)
+			}
+			rep.Pulled++
+		}
+	}
+	syncCursorsLock.Lock()
+	syncCursors[cursorKey] = cursor
+	syncCursorsLock.Unlock()
+	return rep
+}
+
+func syncPullPeer(name string, peer syncPeer) ([]syncDimReport, error) {
+	dims, err := peer.client().Dimensions(context.Background(), "")
+	if err != nil {
+		return nil, err
+	}
+	ret := []syncDimReport{}
+	for _, d := range dims {
+		if len(peer.Worlds) > 0 && !containsString(peer.Worlds, d.World) {
+			continue
+		}
+		rep := syncPullDim(name, peer, d)
+		if rep.Error != "" {
+			log.Printf("Sync of [%s] [%s] from peer [%s] failed: %s", d.World, d.Name, name, rep.Error)
+		} else if rep.Pulled > 0 {
+			log.Printf("Synced [%s] [%s] from peer [%s]: %d pulled, %d up to date", d.World, d.Name, name, rep.Pulled, rep.Skipped)
+		}
+		ret = append(ret, rep)
+	}
+	return ret, nil
+}
+
+func syncPeers() map[string]syncPeer {
+	peers := map[string]syncPeer{}
+	cfg.GetToStruct(&peers, "sync", "peers")
+	return peers
+}
+
+// only one pull runs at a time, scheduled or requested
+// reqID tags log lines of sync started from API
+func runPeerSync(only, reqID string) (map[string][]syncDimReport, error) {
+	if !syncLock.TryLock() {
+		return nil, errors.New("sync is already running")
+	}
+	defer syncLock.Unlock()
+	ret := map[string][]syncDimReport{}
+	for name, peer := range syncPeers() {
+		if only != "" && name != only {
+			continue
+		}
+		reps, err := syncPullPeer(name, peer)
+		if err != nil {
+			log.Printf("Sync from peer [%s] failed [%s]: %s", name, reqID, err.Error())
+			reps = []syncDimReport{{Error: err.Error()}}
+		}
+		ret[name] = reps
+	}
+	if only != "" && len(ret) == 0 {
+		return nil, fmt.Errorf("peer [%s] is not configured", only)
+	}
+	return ret, nil
+}
+
+func apiRunPeerSync(w http.ResponseWriter, r *http.Request) (int, string) {
+	rep, err := runPeerSync(r.FormValue("peer"), requestID(r))
+	if err != nil {
+		return 409, err.Error()
+	}
+	setContentTypeJson(w)
+	return marshalOrFail(200, rep)
+}
+
+// interval is re-read every minute so it can be changed without restart
+func peerSyncScheduler(exitchan <-chan struct{}) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-exitchan:
+			return
+		case <-ticker.C:
+			interval := time.Duration(cfg.GetDSInt(0, "sync", "interval")) * time.Minute
+			if interval <= 0 || time.Since(last) < interval {
+				continue
+			}
+			last = time.Now()
+			if _, err := runPeerSync("", "scheduled"); err != nil {
+				log.Printf("Scheduled sync failed: %s", err.Error())
+			}
+		}
+	}
+}
@@ -0,0 +1,215 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/maxsupermanhd/WebChunk/client"
+	"github.com/maxsupermanhd/WebChunk/proxy"
+)
+
+// uuid, gamemode and latency come from the tab list
+type trackedPlayer = client.Player
+
+var (
+	trackedPlayers      = map[string]trackedPlayer{}
+	trackedPlayersDirty = false
+	trackedPlayersLock  sync.Mutex
+	// tab list seen by each proxied player
+	sessionTabLists = map[string]map[uuid.UUID]proxy.TabListPlayer{}
+)
+
+func playerTrackerJoin(e *proxy.ProxiedEvent) {
+	trackedPlayersLock.Lock()
+	trackedPlayers[e.Username] = trackedPlayer{
+		World:      e.Server,
+		Dimension:  strings.TrimPrefix(e.Dimension, "minecraft:"),
+		LastUpdate: e.Time,
+		Gamemode:   -1,
+	}
+	sessionTabLists[e.Username] = map[uuid.UUID]proxy.TabListPlayer{}
+	trackedPlayersDirty = true
+	trackedPlayersLock.Unlock()
+	globalEventRouter.Broadcast(mapEvent{
+		Action: client.ActionMessage,
+		Data:   anonymizeName(e.Username) + " joined " + e.Server,
+	})
+}
+
+func playerTrackerLeave(e *proxy.ProxiedEvent) {
+	trackedPlayersLock.Lock()
+	delete(trackedPlayers, e.Username)
+	delete(sessionTabLists, e.Username)
+	trackedPlayersDirty = true
+	trackedPlayersLock.Unlock()
+	globalEventRouter.Broadcast(mapEvent{
+		Action: client.ActionMessage,
+		Data:   anonymizeName(e.Username) + " left " + e.Server,
+	})
+}
+
+func playerTrackerUpdate(e *proxy.ProxiedEvent, pos proxy.EventPlayerPosition) {
+	trackedPlayersLock.Lock()
+	p, ok := trackedPlayers[e.Username]
+	if !ok {
+		p.Gamemode = -1
+	}
+	p.X, p.Y, p.Z = pos.X, pos.Y, pos.Z
+	p.Yaw, p.Pitch = pos.Yaw, pos.Pitch
+	p.World = e.Server
+	p.Dimension = strings.TrimPrefix(e.Dimension, "minecraft:")
+	p.LastUpdate = e.Time
+	trackedPlayers[e.Username] = p
+	trackedPlayersDirty = true
+	trackedPlayersLock.Unlock()
+}
+
+func playerTrackerTabUpdate(e *proxy.ProxiedEvent, u proxy.EventTabListUpdate) {
+	trackedPlayersLock.Lock()
+	defer trackedPlayersLock.Unlock()
+	tab, ok := sessionTabLists[e.Username]
+	if !ok {
+		tab = map[uuid.UUID]proxy.TabListPlayer{}
+		sessionTabLists[e.Username] = tab
+	}
+	for _, t := range u.Players {
+		tab[t.UUID] = t
+		if t.Skin != "" {
+			rememberSkinTextures(t.UUID, t.Skin)
+		}
+		// proxied player is in the tab list like everyone else
+		if p, ok := trackedPlayers[e.Username]; ok && t.Name == e.Username {
+			p.UUID = t.UUID.String()
+			p.Gamemode = t.Gamemode
+			p.Latency = t.Latency
+			trackedPlayers[e.Username] = p
+			trackedPlayersDirty = true
+		}
+	}
+}
+
+func playerTrackerTabRemove(e *proxy.ProxiedEvent, r proxy.EventTabListRemove) {
+	trackedPlayersLock.Lock()
+	defer trackedPlayersLock.Unlock()
+	tab, ok := sessionTabLists[e.Username]
+	if !ok {
+		return
+	}
+	for _, id := range r.UUIDs {
+		delete(tab, id)
+	}
+}
+
+func playerTrackerSnapshot() map[string]trackedPlayer {
+	trackedPlayersLock.Lock()
+	defer trackedPlayersLock.Unlock()
+	ret := make(map[string]trackedPlayer, len(trackedPlayers))
+	for k, v := range trackedPlayers {
+		ret[k] = v
+	}
+	return ret
+}
+
+// batches position updates into single event to not flood websockets
+func playerTrackerBroadcaster(exitchan <-chan struct{}) {
+	ticker := time.NewTicker(time.Duration(cfg.GetDSInt(1000, "player_broadcast_interval")) * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-exitchan:
+			return
+		case <-ticker.C:
+			trackedPlayersLock.Lock()
+			dirty := trackedPlayersDirty
+			trackedPlayersDirty = false
+			trackedPlayersLock.Unlock()
+			if !dirty {
+				continue
+			}
+			globalEventRouter.Broadcast(mapEvent{
+				Action: client.ActionBulkPlayerUpdate,
+				Data:   playerTrackerSnapshot(),
+			})
+		}
+	}
+}
+
+// copy of the snapshot with names and uuids replaced
+func anonymizePlayers(ps map[string]trackedPlayer) map[string]trackedPlayer {
+	ret := make(map[string]trackedPlayer, len(ps))
+	for name, p := range ps {
+		if id, err := uuid.Parse(p.UUID); err == nil {
+			p.UUID = anonymizeUUID(id).String()
+		}
+		ret[anonymizeName(name)] = p
+	}
+	return ret
+}
+
+func apiListPlayers(w http.ResponseWriter, r *http.Request) (int, string) {
+	ps := playerTrackerSnapshot()
+	if privacyHides(r) {
+		ps = anonymizePlayers(ps)
+	}
+	setContentTypeJson(w)
+	return marshalOrFail(200, ps)
+}
+
+func apiPlayerTabList(w http.ResponseWriter, r *http.Request) (int, string) {
+	names := playerNamerFor(r)
+	player := mux.Vars(r)["player"]
+	trackedPlayersLock.Lock()
+	tab, ok := sessionTabLists[player]
+	if !ok && privacyHides(r) {
+		for name, t := range sessionTabLists {
+			if names(name) == player {
+				tab, ok = t, true
+				break
+			}
+		}
+	}
+	ret := make([]proxy.TabListPlayer, 0, len(tab))
+	for _, t := range tab {
+		if privacyHides(r) {
+			t.DisplayName = anonymizeText(t.DisplayName, t.Name, names)
+			t.Name = names(t.Name)
+			t.UUID = anonymizeUUID(t.UUID)
+			t.Skin = ""
+		}
+		ret = append(ret, t)
+	}
+	trackedPlayersLock.Unlock()
+	if !ok {
+		return 404, "Player is not connected"
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Name < ret[j].Name
+	})
+	setContentTypeJson(w)
+	return marshalOrFail(200, ret)
+}
@@ -0,0 +1,151 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// names and uuids are stored as they are and only replaced when shown,
+// that way the instance admin can still see who is who
+type playerNamer func(string) string
+
+var privacySecretLock sync.Mutex
+
+func privacyMode() string {
+	return cfg.GetDSString("", "privacy", "mode")
+}
+
+// key for hashes, generated once and saved right away so pseudonyms survive restarts
+func privacySecret() []byte {
+	privacySecretLock.Lock()
+	defer privacySecretLock.Unlock()
+	s := cfg.GetDSString("", "privacy", "secret")
+	if s == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			log.Printf("Failed to generate privacy secret: %s", err.Error())
+		}
+		s = hex.EncodeToString(b)
+		cfg.Set(s, "privacy", "secret")
+		if err := saveConfig(); err != nil {
+			log.Printf("Failed to save config with new privacy secret: %s", err.Error())
+		}
+	}
+	return []byte(s)
+}
+
+func privacyMAC(kind, v string) []byte {
+	m := hmac.New(sha256.New, privacySecret())
+	m.Write([]byte(kind + "\x00" + v))
+	return m.Sum(nil)
+}
+
+var (
+	pseudonymAdjectives = []string{"Amber", "Brave", "Calm", "Dusty", "Eager", "Fuzzy", "Gentle", "Hollow", "Icy", "Jolly", "Keen", "Lucky", "Mossy", "Nimble", "Odd", "Pale", "Quiet", "Rusty", "Sly", "Tiny", "Upbeat", "Vivid", "Wild", "Young"}
+	pseudonymNouns      = []string{"Axolotl", "Bee", "Cod", "Dolphin", "Enderman", "Fox", "Goat", "Hoglin", "Ocelot", "Llama", "Mooshroom", "Panda", "Parrot", "Rabbit", "Salmon", "Sniffer", "Strider", "Turtle", "Villager", "Wolf", "Allay", "Frog", "Camel", "Squid"}
+)
+
+// case of the name does not matter for the game so it does not here
+func anonymizeName(name string) string {
+	if name == "" {
+		return ""
+	}
+	mac := privacyMAC("name", strings.ToLower(name))
+	switch privacyMode() {
+	case "hash":
+		return "player-" + hex.EncodeToString(mac[:5])
+	case "pseudonym":
+		n := binary.BigEndian.Uint32(mac)
+		return pseudonymAdjectives[n%uint32(len(pseudonymAdjectives))] +
+			pseudonymNouns[(n>>8)%uint32(len(pseudonymNouns))] +
+			hex.EncodeToString(mac[4:6])[:3]
+	}
+	return name
+}
+
+// random looking v4 uuid derived from the real one
+func anonymizeUUID(u uuid.UUID) uuid.UUID {
+	if u == uuid.Nil || !privacyEnabled() {
+		return u
+	}
+	var ret uuid.UUID
+	copy(ret[:], privacyMAC("uuid", u.String()))
+	ret[6] = ret[6]&0x0f | 0x40
+	ret[8] = ret[8]&0x3f | 0x80
+	return ret
+}
+
+func privacyEnabled() bool {
+	m := privacyMode()
+	return m == "hash" || m == "pseudonym"
+}
+
+// reveal token can be sent as X-Reveal-Token header or reveal_token cookie,
+// not in query so it does not end up in access log
+func privacyCanReveal(r *http.Request) bool {
+	token := cfg.GetDSString("", "privacy", "reveal_token")
+	if token == "" || r == nil {
+		return false
+	}
+	given := r.Header.Get("X-Reveal-Token")
+	if given == "" {
+		if c, err := r.Cookie("reveal_token"); err == nil {
+			given = c.Value
+		}
+	}
+	return subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+func privacyHides(r *http.Request) bool {
+	return privacyEnabled() && !privacyCanReveal(r)
+}
+
+// namer for whoever made the request
+func playerNamerFor(r *http.Request) playerNamer {
+	if !privacyHides(r) {
+		return func(s string) string { return s }
+	}
+	return anonymizeName
+}
+
+// replaces player name inside of free text like death messages
+func anonymizeText(text, player string, names playerNamer) string {
+	if player == "" {
+		return text
+	}
+	anon := names(player)
+	if anon == player {
+		return text
+	}
+	return strings.ReplaceAll(text, player, anon)
+}
@@ -0,0 +1,156 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package proxy
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/maxsupermanhd/go-vmc/v764/chat"
+	"github.com/maxsupermanhd/lac"
+)
+
+// AccessList is list of player names or UUIDs that are allowed to
+// connect through the proxy, checked only when Enabled
+type AccessList struct {
+	Enabled bool     `json:"enabled" mapstructure:"enabled"`
+	Players []string `json:"players" mapstructure:"players"`
+}
+
+// names are not case sensitive in game so they are not here either,
+// uuids match both dashed and undashed forms
+func (a AccessList) Allows(name string, id uuid.UUID) bool {
+	if !a.Enabled {
+		return true
+	}
+	for _, p := range a.Players {
+		if strings.EqualFold(p, name) {
+			return true
+		}
+		if pid, err := uuid.Parse(p); err == nil && pid == id {
+			return true
+		}
+	}
+	return false
+}
+
+// read-modify-write of the list from api should not interleave
+var aclLock sync.Mutex
+
+// empty listener is the global list, others are kept by listen address
+func aclPath(listener string, k ...string) []string {
+	if listener == "" {
+		return append([]string{"acl"}, k...)
+	}
+	return append([]string{"acl", "listeners", listener}, k...)
+}
+
+func GetAccessList(cfg *lac.ConfSubtree, listener string) (AccessList, error) {
+	ret := AccessList{Players: []string{}}
+	err := cfg.GetToStruct(&ret, aclPath(listener)...)
+	if errors.Is(err, lac.ErrNoKey) {
+		err = nil
+	}
+	if ret.Players == nil {
+		ret.Players = []string{}
+	}
+	return ret, err
+}
+
+func setAccessList(cfg *lac.ConfSubtree, listener string, a AccessList) {
+	cfg.Set(a.Enabled, aclPath(listener, "enabled")...)
+	cfg.Set(a.Players, aclPath(listener, "players")...)
+}
+
+func SetAccessListEnabled(cfg *lac.ConfSubtree, listener string, enabled bool) (AccessList, error) {
+	aclLock.Lock()
+	defer aclLock.Unlock()
+	a, err := GetAccessList(cfg, listener)
+	if err != nil {
+		return a, err
+	}
+	a.Enabled = enabled
+	setAccessList(cfg, listener, a)
+	return a, nil
+}
+
+// returns false if player was already on the list
+func AddToAccessList(cfg *lac.ConfSubtree, listener, player string) (AccessList, bool, error) {
+	aclLock.Lock()
+	defer aclLock.Unlock()
+	a, err := GetAccessList(cfg, listener)
+	if err != nil {
+		return a, false, err
+	}
+	for _, p := range a.Players {
+		if strings.EqualFold(p, player) {
+			return a, false, nil
+		}
+	}
+	a.Players = append(a.Players, player)
+	setAccessList(cfg, listener, a)
+	return a, true, nil
+}
+
+// returns false if player was not on the list
+func RemoveFromAccessList(cfg *lac.ConfSubtree, listener, player string) (AccessList, bool, error) {
+	aclLock.Lock()
+	defer aclLock.Unlock()
+	a, err := GetAccessList(cfg, listener)
+	if err != nil {
+		return a, false, err
+	}
+	for i, p := range a.Players {
+		if strings.EqualFold(p, player) {
+			a.Players = append(a.Players[:i], a.Players[i+1:]...)
+			setAccessList(cfg, listener, a)
+			return a, true, nil
+		}
+	}
+	return a, false, nil
+}
+
+// login checker that reads the list from config on every login
+// so changes apply without restarting the listener, listeners that
+// have no list of their own use the global one
+type accessListChecker struct {
+	cfg      *lac.ConfSubtree
+	listener string
+}
+
+func (c accessListChecker) CheckPlayer(name string, id uuid.UUID, protocol int32) (bool, chat.Message) {
+	listener := c.listener
+	if _, ok := c.cfg.Get(aclPath(listener)...); !ok {
+		listener = ""
+	}
+	a, err := GetAccessList(c.cfg, listener)
+	if err != nil {
+		// broken config should not let everyone in
+		return false, chat.Text("Proxy access list is misconfigured")
+	}
+	if a.Allows(name, id) {
+		return true, chat.Message{}
+	}
+	msg := c.cfg.GetDSString("You are not allowed to use this proxy", aclPath("", "message")...)
+	return false, chat.Text(c.cfg.GetDString(msg, aclPath(listener, "message")...))
+}
@@ -0,0 +1,393 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package proxy
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maxsupermanhd/go-vmc/v764/bot"
+	"github.com/maxsupermanhd/go-vmc/v764/bot/basic"
+	"github.com/maxsupermanhd/go-vmc/v764/chat"
+	"github.com/maxsupermanhd/go-vmc/v764/data/packetid"
+	pk "github.com/maxsupermanhd/go-vmc/v764/net/packet"
+	"github.com/maxsupermanhd/lac"
+)
+
+// BotTask is an area that headless bot walks through collecting chunks
+// without anyone playing, waypoints are laid out in rows Step blocks apart
+type BotTask struct {
+	Username        string  `json:"username" mapstructure:"username"`
+	Server          string  `json:"server" mapstructure:"server"`
+	Offline         bool    `json:"offline" mapstructure:"offline"`
+	Mode            string  `json:"mode" mapstructure:"mode"`
+	TeleportCommand string  `json:"teleport_command" mapstructure:"teleport_command"`
+	Y               *int    `json:"y" mapstructure:"y"`
+	MinX            int     `json:"min_x" mapstructure:"min_x"`
+	MinZ            int     `json:"min_z" mapstructure:"min_z"`
+	MaxX            int     `json:"max_x" mapstructure:"max_x"`
+	MaxZ            int     `json:"max_z" mapstructure:"max_z"`
+	Step            int     `json:"step" mapstructure:"step"`
+	Dwell           int     `json:"dwell" mapstructure:"dwell"`
+	Speed           float64 `json:"speed" mapstructure:"speed"`
+	Loop            bool    `json:"loop" mapstructure:"loop"`
+	ReconnectDelay  int     `json:"reconnect_delay" mapstructure:"reconnect_delay"`
+}
+
+func (t *BotTask) setDefaults() {
+	if t.Mode == "" {
+		t.Mode = "teleport"
+	}
+	if t.TeleportCommand == "" {
+		t.TeleportCommand = "tp @s {x} {y} {z}"
+	}
+	if t.Step <= 0 {
+		t.Step = 128
+	}
+	if t.Dwell <= 0 {
+		t.Dwell = 3000
+	}
+	if t.Speed <= 0 {
+		t.Speed = 10
+	}
+	if t.ReconnectDelay <= 0 {
+		t.ReconnectDelay = 30
+	}
+}
+
+// snake through the area so bot never has to go back across it
+func (t BotTask) waypoints() [][2]int {
+	ret := [][2]int{}
+	row := 0
+	for z := t.MinZ; z <= t.MaxZ; z += t.Step {
+		xs := []int{}
+		for x := t.MinX; x <= t.MaxX; x += t.Step {
+			xs = append(xs, x)
+		}
+		if row%2 == 1 {
+			for i, j := 0, len(xs)-1; i < j; i, j = i+1, j-1 {
+				xs[i], xs[j] = xs[j], xs[i]
+			}
+		}
+		for _, x := range xs {
+			ret = append(ret, [2]int{x, z})
+		}
+		row++
+	}
+	return ret
+}
+
+// RunBots connects configured bots and feeds everything they see into
+// the same channels the proxy uses, returns when all tasks are done or ctx is cancelled
+func RunBots(ctx context.Context, cfg *lac.ConfSubtree, dump chan *ProxiedChunk, events chan *ProxiedEvent) {
+	tasks := []BotTask{}
+	err := cfg.GetToStruct(&tasks, "bots")
+	if err != nil && !errors.Is(err, lac.ErrNoKey) {
+		log.Println("Failed to parse bot tasks: ", err.Error())
+		return
+	}
+	if len(tasks) == 0 {
+		return
+	}
+	sp := SnifferProxy{
+		CredManager:  NewCredentialsManager(cfg),
+		SaveChannel:  dump,
+		EventChannel: events,
+		Conf:         cfg,
+		Ctx:          ctx,
+	}
+	var wg sync.WaitGroup
+	for _, t := range tasks {
+		t.setDefaults()
+		if t.Username == "" || t.Server == "" {
+			log.Println("Skipping bot task without username or server")
+			continue
+		}
+		wg.Add(1)
+		go func(t BotTask) {
+			sp.runBotTask(ctx, t)
+			wg.Done()
+		}(t)
+	}
+	wg.Wait()
+}
+
+func (sp SnifferProxy) runBotTask(ctx context.Context, t BotTask) {
+	for {
+		finished, err := sp.runBotSession(ctx, t)
+		if finished {
+			log.Printf("Bot [%s] finished walking area on [%s]", t.Username, t.Server)
+			return
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Bot [%s] disconnected from [%s]: %v, reconnecting in %ds", t.Username, t.Server, err, t.ReconnectDelay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(t.ReconnectDelay) * time.Second):
+		}
+	}
+}
+
+// position as server sees it, unknown until the first teleport from server
+type botPosition struct {
+	lock    sync.Mutex
+	x, y, z float64
+	ready   chan struct{}
+	once    sync.Once
+}
+
+func (p *botPosition) teleported(x, y, z float64, flags byte) {
+	p.lock.Lock()
+	// bits of flags mark relative coordinates
+	if flags&0x01 != 0 {
+		x += p.x
+	}
+	if flags&0x02 != 0 {
+		y += p.y
+	}
+	if flags&0x04 != 0 {
+		z += p.z
+	}
+	p.x, p.y, p.z = x, y, z
+	p.lock.Unlock()
+	p.once.Do(func() { close(p.ready) })
+}
+
+func (p *botPosition) get() (x, y, z float64) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.x, p.y, p.z
+}
+
+func (p *botPosition) set(x, y, z float64) {
+	p.lock.Lock()
+	p.x, p.y, p.z = x, y, z
+	p.lock.Unlock()
+}
+
+// packet processor wants somewhere to send action bar messages, bot has no screen
+type discardQueue struct{}
+
+func (discardQueue) Push(pk.Packet) bool     { return true }
+func (discardQueue) Pull() (pk.Packet, bool) { return pk.Packet{}, false }
+func (discardQueue) Close()                  {}
+
+func (sp SnifferProxy) runBotSession(ctx context.Context, t BotTask) (bool, error) {
+	c := bot.NewClient()
+	if t.Offline {
+		c.Auth = bot.Auth{Name: t.Username}
+	} else {
+		auth, err := sp.CredManager.GetAuthForUsername(t.Username)
+		if err != nil {
+			return false, err
+		}
+		if auth == nil {
+			return false, errors.New("auth is nil")
+		}
+		c.Auth = bot.Auth{
+			Name: auth.Name,
+			UUID: auth.UUID,
+			AsTk: auth.AsTk,
+		}
+	}
+	cl := clientinfo{
+		name:  t.Username,
+		dest:  t.Server,
+		state: &sessionState{},
+	}
+	pos := &botPosition{ready: make(chan struct{})}
+	var player *basic.Player
+	settings := basic.DefaultSettings
+	settings.Locale = "en_US"
+	player = basic.NewPlayer(c, settings, basic.EventsListener{
+		Teleported: func(x, y, z float64, yaw, pitch float32, flags byte, teleportID int32) error {
+			pos.teleported(x, y, z, flags)
+			return player.AcceptTeleportation(pk.VarInt(teleportID))
+		},
+		Death: func() error {
+			return player.Respawn()
+		},
+		Disconnect: func(reason chat.Message) error {
+			log.Printf("Bot [%s] kicked from [%s]: %s", t.Username, t.Server, reason.ClearString())
+			return nil
+		},
+	})
+
+	acceptorChannel := make(chan pk.Packet, 2048)
+	c.Events.AddGeneric(bot.PacketHandler{
+		Priority: 64,
+		F: func(p pk.Packet) error {
+			cl.stats.countIn(p)
+			for _, id := range collectPackets {
+				if id == packetid.ClientboundPacketID(p.ID) {
+					// packet buffer goes back to the pool after handlers are done
+					acceptorChannel <- pk.Packet{ID: p.ID, Data: append([]byte(nil), p.Data...)}
+					break
+				}
+			}
+			return nil
+		},
+	})
+
+	log.Printf("Bot [%s] connecting to [%s]...", t.Username, t.Server)
+	if err := c.JoinServerWithOptions(t.Server, bot.JoinOptions{NoPublicKey: true, Context: ctx}); err != nil {
+		return false, err
+	}
+	log.Printf("Bot [%s] joined [%s]", t.Username, t.Server)
+	cl.stats = newSessionStats(cl.name, cl.dest, sp.Conf.GetDSInt(100, "session_stats_retain"))
+	defer cl.stats.finish()
+	sp.sendEvent(cl, EventPlayerJoin{})
+	defer sp.sendEvent(cl, EventPlayerLeave{})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		sp.packetAcceptor(acceptorChannel, discardQueue{}, cl)
+		wg.Done()
+	}()
+
+	walkCtx, walkCancel := context.WithCancel(ctx)
+	walkDone := make(chan bool, 1)
+	go func() {
+		finished := sp.botWalk(walkCtx, c, cl, t, pos)
+		walkCancel()
+		walkDone <- finished
+	}()
+	go func() {
+		<-walkCtx.Done()
+		c.Conn.Close()
+	}()
+
+	err := c.HandleGame()
+	walkCancel()
+	finished := <-walkDone
+	close(acceptorChannel)
+	wg.Wait()
+	if finished {
+		err = nil
+	}
+	return finished, err
+}
+
+// returns true only when the whole area was visited and task is not looped
+func (sp SnifferProxy) botWalk(ctx context.Context, c *bot.Client, cl clientinfo, t BotTask, pos *botPosition) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-pos.ready:
+	}
+	points := t.waypoints()
+	for {
+		for i, wp := range points {
+			var err error
+			if t.Mode == "fly" {
+				err = botFlyTo(ctx, c, t, pos, float64(wp[0])+0.5, float64(wp[1])+0.5)
+			} else {
+				err = botTeleport(c, t, wp[0], wp[1])
+			}
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Printf("Bot [%s] failed to move: %v", t.Username, err)
+				}
+				return false
+			}
+			x, y, z := pos.get()
+			sp.sendEvent(cl, EventPlayerPosition{X: x, Y: y, Z: z})
+			log.Printf("Bot [%s] reached waypoint %d/%d (%d %d)", t.Username, i+1, len(points), wp[0], wp[1])
+			select {
+			case <-ctx.Done():
+				return false
+			case <-time.After(time.Duration(t.Dwell) * time.Millisecond):
+			}
+		}
+		if !t.Loop {
+			return true
+		}
+	}
+}
+
+// server has to allow the command, position is updated by the teleport it sends back
+func botTeleport(c *bot.Client, t BotTask, x, z int) error {
+	y := "~"
+	if t.Y != nil {
+		y = strconv.Itoa(*t.Y)
+	}
+	cmd := strings.NewReplacer("{x}", strconv.Itoa(x), "{y}", y, "{z}", strconv.Itoa(z)).Replace(t.TeleportCommand)
+	return c.Conn.WritePacket(pk.Marshal(
+		packetid.ServerboundChatCommand,
+		pk.String(strings.TrimPrefix(cmd, "/")),
+		pk.Long(time.Now().UnixMilli()),
+		pk.Long(rand.Int63()),
+		pk.VarInt(0), // no argument signatures
+		pk.VarInt(0), // message count
+		pk.NewFixedBitSet(20),
+	))
+}
+
+// straight line without any collision checks, meant for flying in creative or spectator
+func botFlyTo(ctx context.Context, c *bot.Client, t BotTask, pos *botPosition, tx, tz float64) error {
+	tick := time.NewTicker(time.Second / 20)
+	defer tick.Stop()
+	stepLen := t.Speed / 20
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tick.C:
+		}
+		x, y, z := pos.get()
+		if t.Y != nil {
+			y = float64(*t.Y)
+		}
+		dx, dz := tx-x, tz-z
+		dist := math.Hypot(dx, dz)
+		if dist <= stepLen {
+			x, z = tx, tz
+		} else {
+			x += dx / dist * stepLen
+			z += dz / dist * stepLen
+		}
+		pos.set(x, y, z)
+		err := c.Conn.WritePacket(pk.Marshal(
+			packetid.ServerboundMovePlayerPos,
+			pk.Double(x),
+			pk.Double(y),
+			pk.Double(z),
+			pk.Boolean(false),
+		))
+		if err != nil {
+			return err
+		}
+		if dist <= stepLen {
+			return nil
+		}
+	}
+}
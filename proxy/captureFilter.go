@@ -0,0 +1,93 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package proxy
+
+import (
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/maxsupermanhd/go-vmc/v764/level"
+	"github.com/maxsupermanhd/lac"
+)
+
+// CaptureFilter describes area of interest in block coordinates,
+// if radius is set it is used as a circle around center, bounding box otherwise
+type CaptureFilter struct {
+	Dimension string `json:"dimension" mapstructure:"dimension"`
+	CenterX   int    `json:"center_x" mapstructure:"center_x"`
+	CenterZ   int    `json:"center_z" mapstructure:"center_z"`
+	Radius    int    `json:"radius" mapstructure:"radius"`
+	MinX      int    `json:"min_x" mapstructure:"min_x"`
+	MinZ      int    `json:"min_z" mapstructure:"min_z"`
+	MaxX      int    `json:"max_x" mapstructure:"max_x"`
+	MaxZ      int    `json:"max_z" mapstructure:"max_z"`
+}
+
+func (f CaptureFilter) Matches(dim string, pos level.ChunkPos) bool {
+	if f.Dimension != "" && strings.TrimPrefix(f.Dimension, "minecraft:") != strings.TrimPrefix(dim, "minecraft:") {
+		return false
+	}
+	x0, z0 := int(pos[0])*16, int(pos[1])*16
+	x1, z1 := x0+15, z0+15
+	if f.Radius > 0 {
+		// closest point of the chunk to the center
+		dx := clampInt(f.CenterX, x0, x1) - f.CenterX
+		dz := clampInt(f.CenterZ, z0, z1) - f.CenterZ
+		return dx*dx+dz*dz <= f.Radius*f.Radius
+	}
+	return x1 >= f.MinX && x0 <= f.MaxX && z1 >= f.MinZ && z0 <= f.MaxZ
+}
+
+func clampInt(v, min, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+type captureFilters []CaptureFilter
+
+// empty filter list lets everything through
+func (ff captureFilters) Matches(dim string, pos level.ChunkPos) bool {
+	if len(ff) == 0 {
+		return true
+	}
+	for _, f := range ff {
+		if f.Matches(dim, pos) {
+			return true
+		}
+	}
+	return false
+}
+
+func loadCaptureFilters(cfg *lac.ConfSubtree, world string) captureFilters {
+	ret := captureFilters{}
+	err := cfg.GetToStruct(&ret, "capture_filters", world)
+	if err != nil && !errors.Is(err, lac.ErrNoKey) {
+		log.Printf("Failed to parse capture filters for world [%s], capturing everything: %s", world, err.Error())
+		return captureFilters{}
+	}
+	return ret
+}
@@ -0,0 +1,205 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package proxy
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	pk "github.com/maxsupermanhd/go-vmc/v764/net/packet"
+	"github.com/maxsupermanhd/lac"
+)
+
+// dump is gzipped json header line followed by packets as
+// unix nano time, packet id, data length and data
+type DumpHeader struct {
+	Version  int
+	Username string
+	Server   string
+	Started  time.Time
+	// set when session came through a listener storing data elsewhere
+	World      string            `json:",omitempty"`
+	Dimensions map[string]string `json:",omitempty"`
+}
+
+type dumpRecord struct {
+	Time   int64
+	ID     int32
+	Length uint32
+}
+
+type packetDumper struct {
+	f  *os.File
+	gz *gzip.Writer
+	w  *bufio.Writer
+}
+
+func dumpFileName(dir string, cl clientinfo, t time.Time) string {
+	server := strings.NewReplacer(":", "_", "/", "_", "\\", "_").Replace(cl.dest)
+	return filepath.Join(dir, fmt.Sprintf("%s_%s_%s.wcdump.gz", server, cl.name, t.Format("20060102-150405")))
+}
+
+// nil if dumps are disabled or file can not be created
+func newPacketDumper(cfg *lac.ConfSubtree, cl clientinfo) *packetDumper {
+	dir := cfg.GetDSString("", "dump_path")
+	if dir == "" || cl.replay {
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Printf("Failed to create packet dump directory: %s", err.Error())
+		return nil
+	}
+	now := time.Now()
+	f, err := os.Create(dumpFileName(dir, cl, now))
+	if err != nil {
+		log.Printf("Failed to create packet dump: %s", err.Error())
+		return nil
+	}
+	d := &packetDumper{f: f, gz: gzip.NewWriter(f)}
+	d.w = bufio.NewWriter(d.gz)
+	h, _ := json.Marshal(DumpHeader{Version: 1, Username: cl.name, Server: cl.dest, Started: now, World: cl.world, Dimensions: cl.dimensions})
+	d.w.Write(append(h, '\n'))
+	log.Printf("Dumping packets of [%s] on [%s] to %s", cl.name, cl.dest, f.Name())
+	return d
+}
+
+func (d *packetDumper) write(p pk.Packet) {
+	if d == nil || d.w == nil {
+		return
+	}
+	err := binary.Write(d.w, binary.BigEndian, dumpRecord{Time: time.Now().UnixNano(), ID: p.ID, Length: uint32(len(p.Data))})
+	if err == nil {
+		_, err = d.w.Write(p.Data)
+	}
+	if err != nil {
+		log.Printf("Failed to write packet dump, stopping it: %s", err.Error())
+		d.close()
+	}
+}
+
+func (d *packetDumper) close() {
+	if d == nil || d.w == nil {
+		return
+	}
+	d.w.Flush()
+	d.gz.Close()
+	d.f.Close()
+	d.w = nil
+}
+
+func ReadDumpHeader(r *bufio.Reader) (DumpHeader, error) {
+	var h DumpHeader
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		return h, err
+	}
+	if err := json.Unmarshal(line, &h); err != nil {
+		return h, err
+	}
+	if h.Version != 1 {
+		return h, fmt.Errorf("unsupported dump version %d", h.Version)
+	}
+	return h, nil
+}
+
+// ReplayDump feeds packets from the dump through the same path as live sessions,
+// server can be overridden to store chunks in another world
+func ReplayDump(ctx context.Context, cfg *lac.ConfSubtree, fname, server string, dump chan *ProxiedChunk, events chan *ProxiedEvent) error {
+	f, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(gz)
+	h, err := ReadDumpHeader(r)
+	if err != nil {
+		return fmt.Errorf("reading dump header: %w", err)
+	}
+	world, dimensions := h.World, h.Dimensions
+	if server == "" {
+		server = h.Server
+	} else {
+		world, dimensions = "", nil
+	}
+	log.Printf("Replaying packets of [%s] on [%s] recorded %s", h.Username, server, h.Started)
+	sp := SnifferProxy{
+		Conf:         cfg,
+		Ctx:          ctx,
+		SaveChannel:  dump,
+		EventChannel: events,
+	}
+	cl := clientinfo{
+		name:       h.Username,
+		dest:       server,
+		world:      world,
+		dimensions: dimensions,
+		state:      &sessionState{},
+		replay:     true,
+	}
+	cl.stats = newSessionStats(cl.name, cl.dest, cfg.GetDSInt(100, "session_stats_retain"))
+	defer cl.stats.finish()
+	acceptorChannel := make(chan pk.Packet, 2048)
+	done := make(chan struct{})
+	go func() {
+		sp.packetAcceptor(acceptorChannel, discardQueue{}, cl)
+		close(done)
+	}()
+	sp.sendEvent(cl, EventPlayerJoin{})
+	count := 0
+	for ctx.Err() == nil {
+		var rec dumpRecord
+		err = binary.Read(r, binary.BigEndian, &rec)
+		if err != nil {
+			break
+		}
+		p := pk.Packet{ID: rec.ID, Data: make([]byte, rec.Length)}
+		if _, err = io.ReadFull(r, p.Data); err != nil {
+			break
+		}
+		cl.stats.countIn(p)
+		acceptorChannel <- p
+		count++
+	}
+	close(acceptorChannel)
+	<-done
+	sp.sendEvent(cl, EventPlayerLeave{})
+	log.Printf("Replayed %d packets", count)
+	// dump of a session that was cut off ends in the middle of a record
+	if err == nil || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ctx.Err()
+	}
+	return err
+}
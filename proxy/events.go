@@ -0,0 +1,246 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/maxsupermanhd/go-vmc/v764/nbt"
+	pk "github.com/maxsupermanhd/go-vmc/v764/net/packet"
+)
+
+// ProxiedEvent is everything sniffed from the session that is not a chunk,
+// Data holds one of the Event* types
+type ProxiedEvent struct {
+	Username  string
+	Server    string
+	Dimension string
+	Time      time.Time
+	Data      any
+}
+
+type EventPlayerJoin struct{}
+
+type EventPlayerLeave struct{}
+
+type EventPlayerPosition struct {
+	X, Y, Z    float64
+	Yaw, Pitch float32
+}
+
+// entity ids are only unique per server connection
+type EventEntitySpawn struct {
+	EntityID int32
+	UUID     uuid.UUID
+	Type     int32
+	X, Y, Z  float64
+}
+
+type EventEntityRemove struct {
+	EntityIDs []int32
+}
+
+// block entity update sent outside of chunk data
+type EventBlockEntity struct {
+	X, Y, Z int
+	Type    int32
+	Data    nbt.RawMessage
+}
+
+// contents of the container block opened by the player,
+// items are summed up by item id
+type EventContainerContents struct {
+	X, Y, Z int
+	Items   map[int32]int
+}
+
+// patch of map item colors, Data is Columns*Rows palette indexes
+// starting at X:Z of the 128x128 map canvas
+type EventMapData struct {
+	MapID   int32
+	Scale   int8
+	Locked  bool
+	Columns int
+	Rows    int
+	X, Z    int
+	Data    []byte
+}
+
+// chat message as it was shown to the player, Kind is "player", "system" or "disguised"
+type EventChat struct {
+	Kind       string
+	Sender     string
+	SenderUUID uuid.UUID
+	Text       string
+}
+
+// marker placed or removed by player with a chat command at where player stands
+type EventMarker struct {
+	Name    string
+	X, Y, Z int
+	Remove  bool
+}
+
+// player died at last known position, Message is the death message
+type EventPlayerDeath struct {
+	X, Y, Z int
+	Message string
+}
+
+// entry of the tab list as proxy knows it after the update,
+// Skin is base64 encoded textures property of the profile
+type TabListPlayer struct {
+	UUID        uuid.UUID
+	Name        string
+	Skin        string
+	Gamemode    int32
+	Latency     int32
+	Listed      bool
+	DisplayName string
+}
+
+type EventTabListUpdate struct {
+	Players []TabListPlayer
+}
+
+type EventTabListRemove struct {
+	UUIDs []uuid.UUID
+}
+
+// objective created, changed or removed, DisplayName is plain text
+type EventObjective struct {
+	Name        string
+	DisplayName string
+	Removed     bool
+}
+
+// score removed with empty Objective is removed from all objectives
+type EventScore struct {
+	Objective string
+	Entity    string
+	Value     int32
+	Removed   bool
+}
+
+// boss bar as it is after the update, Color is the protocol color id
+type EventBossBar struct {
+	UUID    uuid.UUID
+	Title   string
+	Health  float32
+	Color   int32
+	Removed bool
+}
+
+// TimeOfDay is negative when daylight cycle is stopped
+type EventWorldTime struct {
+	Age       int64
+	TimeOfDay int64
+}
+
+// Rain and Thunder are levels from 0 to 1
+type EventWeather struct {
+	Raining bool
+	Rain    float32
+	Thunder float32
+}
+
+// state shared between packet pumps of a single proxied session
+type sessionState struct {
+	lock        sync.Mutex
+	dimension   string
+	lastPosEvt  time.Time
+	interaction *pk.Position
+	hasPos      bool
+	x, y, z     float64
+}
+
+func (s *sessionState) setDimension(dim string) {
+	s.lock.Lock()
+	s.dimension = dim
+	s.lastPosEvt = time.Time{}
+	s.lock.Unlock()
+}
+
+func (s *sessionState) getDimension() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.dimension
+}
+
+func (s *sessionState) setPosition(x, y, z float64) {
+	s.lock.Lock()
+	s.x, s.y, s.z = x, y, z
+	s.hasPos = true
+	s.lock.Unlock()
+}
+
+func (s *sessionState) getPosition() (x, y, z float64, ok bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.x, s.y, s.z, s.hasPos
+}
+
+// remembers block player clicked last to know where opened container is
+func (s *sessionState) setInteraction(pos *pk.Position) {
+	s.lock.Lock()
+	s.interaction = pos
+	s.lock.Unlock()
+}
+
+func (s *sessionState) takeInteraction() *pk.Position {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ret := s.interaction
+	s.interaction = nil
+	return ret
+}
+
+// movement packets come 20 times a second, no need to spam with all of them
+func (s *sessionState) shouldSendPosition(interval time.Duration) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if time.Since(s.lastPosEvt) < interval {
+		return false
+	}
+	s.lastPosEvt = time.Now()
+	return true
+}
+
+// events are not critical, if nobody reads them in time they are dropped
+// instead of stalling the session
+func (p SnifferProxy) sendEvent(cl clientinfo, data any) {
+	if p.EventChannel == nil {
+		return
+	}
+	e := &ProxiedEvent{
+		Username:  cl.name,
+		Server:    cl.storedWorld(),
+		Dimension: cl.storedDimension(cl.state.getDimension()),
+		Time:      time.Now(),
+		Data:      data,
+	}
+	select {
+	case p.EventChannel <- e:
+	default:
+	}
+}
@@ -0,0 +1,88 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package proxy
+
+import (
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/maxsupermanhd/lac"
+)
+
+// ProxyListener is an address proxy accepts players on, players joining
+// through one with Address set go there instead of their routes, captured
+// data goes to World with dimensions renamed by Dimensions
+type ProxyListener struct {
+	ListenAddr string            `json:"listen_addr" mapstructure:"listen_addr"`
+	Address    string            `json:"address" mapstructure:"address"`
+	World      string            `json:"world" mapstructure:"world"`
+	Dimensions map[string]string `json:"dimensions" mapstructure:"dimensions"`
+}
+
+// listen_addr is the listener with routes, listeners are added next to it
+func loadListeners(cfg *lac.ConfSubtree) []ProxyListener {
+	ret := []ProxyListener{}
+	if addr := cfg.GetDSString("localhost:25566", "listen_addr"); addr != "" {
+		ret = append(ret, ProxyListener{ListenAddr: addr})
+	}
+	extra := []ProxyListener{}
+	err := cfg.GetToStruct(&extra, "listeners")
+	if err != nil && !errors.Is(err, lac.ErrNoKey) {
+		log.Printf("Failed to parse proxy listeners: %s", err.Error())
+	}
+	for _, l := range extra {
+		if l.ListenAddr == "" {
+			log.Printf("Proxy listener for [%s] has no listen_addr, skipping", l.Address)
+			continue
+		}
+		ret = append(ret, l)
+	}
+	return ret
+}
+
+// HasListener tells if proxy has a listener on the address
+func HasListener(cfg *lac.ConfSubtree, listenAddr string) bool {
+	for _, l := range loadListeners(cfg) {
+		if l.ListenAddr == listenAddr {
+			return true
+		}
+	}
+	return false
+}
+
+func (cl clientinfo) storedWorld() string {
+	if cl.world != "" {
+		return cl.world
+	}
+	return cl.dest
+}
+
+// mapping is checked with and without namespace
+func (cl clientinfo) storedDimension(dim string) string {
+	if d, ok := cl.dimensions[dim]; ok {
+		return d
+	}
+	if d, ok := cl.dimensions[strings.TrimPrefix(dim, "minecraft:")]; ok {
+		return d
+	}
+	return dim
+}
@@ -0,0 +1,63 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package proxy
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/maxsupermanhd/lac"
+)
+
+// chat messages starting with command prefix are eaten by the proxy
+// instead of being sent to the server, ok is false for regular chat
+func parseMarkerCommand(cfg *lac.ConfSubtree, state *sessionState, msg string) (evt *EventMarker, reply string, ok bool) {
+	prefix := cfg.GetDSString("!", "command_prefix")
+	if prefix == "" || !strings.HasPrefix(msg, prefix) {
+		return nil, "", false
+	}
+	cmd, name, _ := strings.Cut(strings.TrimPrefix(msg, prefix), " ")
+	name = strings.TrimSpace(name)
+	switch cmd {
+	case "mark":
+		if name == "" {
+			return nil, "Usage: " + prefix + "mark <name>", true
+		}
+		x, y, z, known := state.getPosition()
+		if !known {
+			return nil, "Your position is not known yet, move a bit and try again", true
+		}
+		evt = &EventMarker{
+			Name: name,
+			X:    int(math.Floor(x)),
+			Y:    int(math.Floor(y)),
+			Z:    int(math.Floor(z)),
+		}
+		return evt, fmt.Sprintf("Marker %q placed at %d %d %d", name, evt.X, evt.Y, evt.Z), true
+	case "unmark":
+		if name == "" {
+			return nil, "Usage: " + prefix + "unmark <name>", true
+		}
+		return &EventMarker{Name: name, Remove: true}, fmt.Sprintf("Marker %q removed", name), true
+	}
+	return nil, "", false
+}
@@ -0,0 +1,124 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package proxy
+
+import (
+	"sync"
+	"time"
+)
+
+// ProxyMetrics is aggregate of all proxied sessions since start,
+// Sessions has only live ones
+type ProxyMetrics struct {
+	ConnectedPlayers        int
+	ClientWireIn            int64
+	ClientWireOut           int64
+	PayloadIn               int64
+	PayloadOut              int64
+	ChunksReceived          int64
+	ChunksCaptured          int64
+	ChunksCapturedPerMinute int64
+	BlockUpdates            int64
+	PacketsIn               map[string]int64
+	PacketsOut              map[string]int64
+	Sessions                []SessionStats
+}
+
+// finished sessions are folded in here when they end so totals
+// do not go down when old sessions are forgotten, guarded by statsLock
+var totalsFinished = ProxyMetrics{PacketsIn: map[string]int64{}, PacketsOut: map[string]int64{}}
+
+func addToMetrics(m *ProxyMetrics, s SessionStats) {
+	m.ClientWireIn += s.ClientWireIn
+	m.ClientWireOut += s.ClientWireOut
+	m.PayloadIn += s.PayloadIn
+	m.PayloadOut += s.PayloadOut
+	m.ChunksReceived += s.ChunksReceived
+	m.ChunksCaptured += s.ChunksForwarded
+	m.BlockUpdates += s.BlockUpdates
+	for k, v := range s.PacketsIn {
+		m.PacketsIn[k] += v
+	}
+	for k, v := range s.PacketsOut {
+		m.PacketsOut[k] += v
+	}
+}
+
+// captured chunks per second for the last minute
+var (
+	captureRateLock sync.Mutex
+	captureRate     [60]struct {
+		sec int64
+		n   int64
+	}
+)
+
+func countCapturedChunk() {
+	now := time.Now().Unix()
+	captureRateLock.Lock()
+	b := &captureRate[now%int64(len(captureRate))]
+	if b.sec != now {
+		b.sec = now
+		b.n = 0
+	}
+	b.n++
+	captureRateLock.Unlock()
+}
+
+func capturedLastMinute() int64 {
+	now := time.Now().Unix()
+	ret := int64(0)
+	captureRateLock.Lock()
+	for _, b := range captureRate {
+		if now-b.sec < int64(len(captureRate)) {
+			ret += b.n
+		}
+	}
+	captureRateLock.Unlock()
+	return ret
+}
+
+// GetMetrics returns totals over all proxied sessions and stats of live ones
+func GetMetrics() ProxyMetrics {
+	statsLock.Lock()
+	defer statsLock.Unlock()
+	ret := totalsFinished
+	ret.PacketsIn = make(map[string]int64, len(totalsFinished.PacketsIn))
+	ret.PacketsOut = make(map[string]int64, len(totalsFinished.PacketsOut))
+	for k, v := range totalsFinished.PacketsIn {
+		ret.PacketsIn[k] = v
+	}
+	for k, v := range totalsFinished.PacketsOut {
+		ret.PacketsOut[k] = v
+	}
+	ret.Sessions = []SessionStats{}
+	for _, s := range statsSessions {
+		if s.ended.Load() != nil {
+			continue
+		}
+		snap := s.snapshot(true)
+		addToMetrics(&ret, snap)
+		ret.Sessions = append(ret.Sessions, snap)
+	}
+	ret.ConnectedPlayers = len(ret.Sessions)
+	ret.ChunksCapturedPerMinute = capturedLastMinute()
+	return ret
+}
@@ -35,8 +35,8 @@ import (
 // 	log.Printf("Recieved chunk data without dimension?!")
 // 	continue
 // }
-// log.Printf("%s: % 4d % 4d % 4d", currentDim, dim.minY, dim.height, dim.totalHeight)
-// cc := *level.EmptyChunk(int(dim.totalHeight) / 16)
+// log.Printf("%s: % 4d % 4d % 4d", currentDim, dim.minY, dim.height, dim.maxY)
+// cc := *level.EmptyChunk(int(dim.height) / 16)
 // seems to be correct to do such calculation but oh well, it does not work this way...
 
 // cclen := 64 // MAGIC: theoretical maximum of world height
@@ -60,17 +60,18 @@ func deserializeChunkPacket(p pk.Packet, _ loadedDim) (level.ChunkPos, level.Chu
 		sectionsData pk.ByteArray
 		cc           level.Chunk
 		cpos         level.ChunkPos
+		light        = lightData{
+			SkyLightMask:   make(pk.BitSet, (16*16*16-1)>>6+1),
+			BlockLightMask: make(pk.BitSet, (16*16*16-1)>>6+1),
+			SkyLight:       []pk.ByteArray{},
+			BlockLight:     []pk.ByteArray{},
+		}
 	)
 	err := p.Scan(&cpos, &pk.Tuple{
 		pk.NBT(&heightmaps),
 		&sectionsData,
 		pk.Array(&cc.BlockEntity),
-		&lightData{
-			SkyLightMask:   make(pk.BitSet, (16*16*16-1)>>6+1),
-			BlockLightMask: make(pk.BitSet, (16*16*16-1)>>6+1),
-			SkyLight:       []pk.ByteArray{},
-			BlockLight:     []pk.ByteArray{},
-		},
+		&light,
 	})
 	if err != nil {
 		return cpos, cc, err
@@ -108,7 +109,8 @@ func deserializeChunkPacket(p pk.Packet, _ loadedDim) (level.ChunkPos, level.Chu
 		dl -= n
 		cc.Sections = append(cc.Sections, *ss)
 	}
-	// cc.HeightMaps.MotionBlocking = level.NewBitStorage(int(math.Log2(float64(dim.totalHeight+1))), len(heightmaps.MotionBlocking), heightmaps.MotionBlocking)
+	light.apply(cc.Sections)
+	// cc.HeightMaps.MotionBlocking = level.NewBitStorage(int(math.Log2(float64(dim.height+1))), len(heightmaps.MotionBlocking), heightmaps.MotionBlocking)
 	return cpos, cc, err
 }
 
@@ -119,6 +121,26 @@ type lightData struct {
 	BlockLight     []pk.ByteArray
 }
 
+// masks have one extra section below and above the world,
+// arrays are only sent for sections that have their bit set
+func (l *lightData) apply(sections []level.Section) {
+	sky, blk := 0, 0
+	for i := 0; i < len(sections)+2; i++ {
+		if i < l.SkyLightMask.Len() && l.SkyLightMask.Get(i) && sky < len(l.SkyLight) {
+			if i > 0 && i <= len(sections) && len(l.SkyLight[sky]) == 2048 {
+				sections[i-1].SkyLight = l.SkyLight[sky]
+			}
+			sky++
+		}
+		if i < l.BlockLightMask.Len() && l.BlockLightMask.Get(i) && blk < len(l.BlockLight) {
+			if i > 0 && i <= len(sections) && len(l.BlockLight[blk]) == 2048 {
+				sections[i-1].BlockLight = l.BlockLight[blk]
+			}
+			blk++
+		}
+	}
+}
+
 func bitSetRev(set pk.BitSet) pk.BitSet {
 	rev := make(pk.BitSet, len(set))
 	for i := range rev {
@@ -22,11 +22,17 @@ package proxy
 
 import (
 	"fmt"
+	"io"
 	"log"
+	"math"
+	"math/rand"
 	"strings"
 
 	"github.com/davecgh/go-spew/spew"
+	"github.com/google/uuid"
+	"github.com/maxsupermanhd/go-vmc/v764/bot/screen"
 	"github.com/maxsupermanhd/go-vmc/v764/chat"
+	"github.com/maxsupermanhd/go-vmc/v764/chat/sign"
 	"github.com/maxsupermanhd/go-vmc/v764/data/packetid"
 	"github.com/maxsupermanhd/go-vmc/v764/level"
 	"github.com/maxsupermanhd/go-vmc/v764/level/block"
@@ -35,6 +41,11 @@ import (
 	"github.com/maxsupermanhd/go-vmc/v764/server"
 )
 
+// short random id to tell sessions apart in logs
+func newSessionID() string {
+	return fmt.Sprintf("%08x", rand.Uint32())
+}
+
 // 4 bits for x, 4 bits for z
 // 1 byte: xxxxzzzz
 func compactBlockEntityPos(x, z int) int8 {
@@ -56,11 +67,24 @@ func uncompactBlockEntityPosPk(xz int8, y int16) pk.Position {
 	}
 }
 
+// height is number of blocks from minY, maxY is exclusive top of the world
 type loadedDim struct {
-	id          int32
-	minY        int32
-	height      int32
-	totalHeight int32
+	id     int32
+	minY   int32
+	height int32
+	maxY   int32
+}
+
+// map decorations are only read to get to the color data after them
+type mapIcon struct {
+	Type      pk.VarInt
+	X, Z      pk.Byte
+	Direction pk.Byte
+	Name      pk.Option[chat.Message, *chat.Message]
+}
+
+func (i *mapIcon) ReadFrom(r io.Reader) (int64, error) {
+	return pk.Tuple{&i.Type, &i.X, &i.Z, &i.Direction, &i.Name}.ReadFrom(r)
 }
 
 func (sp SnifferProxy) packetAcceptor(recv chan pk.Packet, conn server.PacketQueue, cl clientinfo) {
@@ -73,10 +97,39 @@ func (sp SnifferProxy) packetAcceptor(recv chan pk.Packet, conn server.PacketQue
 		tofind map[pk.Position]int32
 	}
 	c := map[cachePos]cacheChunk{}
+	// container windows opened by clicking on blocks
+	windows := map[int32]pk.Position{}
+	tab := map[uuid.UUID]*TabListPlayer{}
 	loadedDims := map[string]loadedDim{}
 	currentDim := ""
+	filters := loadCaptureFilters(sp.Conf, cl.storedWorld())
+	captureChat := sp.Conf.GetDSBool(false, "capture_chat")
+	captureScoreboard := sp.Conf.GetDSBool(false, "capture_scoreboard")
+	bossBars := map[uuid.UUID]*EventBossBar{}
+	weather := EventWeather{}
+	sessionID := newSessionID()
+	log.Printf("Chunks of [%s] on [%s] are tagged with session %s", cl.name, cl.storedWorld(), sessionID)
+	batches := 0
+	sendChunk := func(c *ProxiedChunk) {
+		if !filters.Matches(c.Dimension, c.Pos) {
+			return
+		}
+		c.BatchID = fmt.Sprintf("%s-%d", sessionID, batches)
+		if len(c.Changes) > 0 {
+			cl.stats.blockUpdates.Add(int64(len(c.Changes)))
+		} else {
+			cl.stats.chunksForwarded.Add(1)
+			countCapturedChunk()
+		}
+		sp.SaveChannel <- c
+	}
+	dumper := newPacketDumper(sp.Conf, cl)
+	defer dumper.close()
 	for p := range recv {
+		dumper.write(p)
 		switch {
+		case p.ID == int32(packetid.ClientboundChunkBatchStart):
+			batches++
 		case p.ID == int32(packetid.ClientboundLevelChunkWithLight):
 			if currentDim == "" {
 				log.Println("Recieved chunk without dimension")
@@ -92,6 +145,7 @@ func (sp SnifferProxy) packetAcceptor(recv chan pk.Packet, conn server.PacketQue
 				log.Printf("Failed to parse chunk data packet: %s", err.Error())
 				continue
 			}
+			cl.stats.chunksReceived.Add(1)
 			// verify all block entities are present
 			missingbe := map[pk.Position]int32{}
 			for _, sect := range cc.Sections {
@@ -166,15 +220,15 @@ func (sp SnifferProxy) packetAcceptor(recv chan pk.Packet, conn server.PacketQue
 			// 	log.Printf("Caching chunk %d:%d until missing %d block entities recieved or chunk unloaded", cpos[0], cpos[1], len(missingbe))
 			// } else {
 			// send directly to storage because ready
-			sp.SaveChannel <- &ProxiedChunk{
+			sendChunk(&ProxiedChunk{
 				Username:            cl.name,
-				Server:              cl.dest,
-				Dimension:           currentDim,
+				Server:              cl.storedWorld(),
+				Dimension:           cl.storedDimension(currentDim),
 				Pos:                 cpos,
 				Data:                cc,
 				DimensionLowestY:    dim.minY,
-				DimensionBuildLimit: int(dim.height),
-			}
+				DimensionBuildLimit: int(dim.maxY),
+			})
 			// }
 		case p.ID == int32(packetid.ClientboundBlockEntityData):
 			dim, ok := loadedDims[currentDim]
@@ -195,6 +249,13 @@ func (sp SnifferProxy) packetAcceptor(recv chan pk.Packet, conn server.PacketQue
 			if data.Type == 0x0 {
 				continue // block entity removed
 			}
+			sp.sendEvent(cl, EventBlockEntity{
+				X:    loc.X,
+				Y:    loc.Y,
+				Z:    loc.Z,
+				Type: int32(t),
+				Data: data,
+			})
 			cpos := level.ChunkPos{int32(loc.X / 16), int32(loc.Z / 16)}
 			cachedLevel, ok := c[cachePos{
 				pos: cpos, dim: currentDim,
@@ -222,15 +283,15 @@ func (sp SnifferProxy) packetAcceptor(recv chan pk.Packet, conn server.PacketQue
 			log.Printf("Recieved block entity %d at %v", t, loc)
 			if len(cachedLevel.tofind) == 0 {
 				log.Printf("Sending chunk %d:%d to storage because recieved all block entities", cpos[0], cpos[1])
-				sp.SaveChannel <- &ProxiedChunk{
+				sendChunk(&ProxiedChunk{
 					Username:            cl.name,
-					Server:              cl.dest,
-					Dimension:           currentDim,
+					Server:              cl.storedWorld(),
+					Dimension:           cl.storedDimension(currentDim),
 					Pos:                 cpos,
 					Data:                cachedLevel.chunk,
 					DimensionLowestY:    dim.minY,
-					DimensionBuildLimit: int(dim.height),
-				}
+					DimensionBuildLimit: int(dim.maxY),
+				})
 			}
 		case p.ID == int32(packetid.ClientboundForgetLevelChunk):
 			dim, ok := loadedDims[currentDim]
@@ -253,15 +314,321 @@ func (sp SnifferProxy) packetAcceptor(recv chan pk.Packet, conn server.PacketQue
 				continue
 			}
 			log.Printf("Server told to unload chunk %d:%d, sending chunk as it is to storage", x, z)
-			sp.SaveChannel <- &ProxiedChunk{
+			sendChunk(&ProxiedChunk{
 				Username:            cl.name,
-				Server:              cl.dest,
-				Dimension:           currentDim,
+				Server:              cl.storedWorld(),
+				Dimension:           cl.storedDimension(currentDim),
 				Pos:                 cpos,
 				Data:                cachedLevel.chunk,
 				DimensionLowestY:    dim.minY,
-				DimensionBuildLimit: int(dim.height),
+				DimensionBuildLimit: int(dim.maxY),
+			})
+		case p.ID == int32(packetid.ClientboundAddEntity):
+			var (
+				eid     pk.VarInt
+				euuid   pk.UUID
+				etype   pk.VarInt
+				x, y, z pk.Double
+			)
+			err := p.Scan(&eid, &euuid, &etype, &x, &y, &z)
+			if err != nil {
+				log.Printf("Failed to parse add entity packet: %s", err.Error())
+				continue
+			}
+			sp.sendEvent(cl, EventEntitySpawn{
+				EntityID: int32(eid),
+				UUID:     uuid.UUID(euuid),
+				Type:     int32(etype),
+				X:        float64(x),
+				Y:        float64(y),
+				Z:        float64(z),
+			})
+		case p.ID == int32(packetid.ClientboundRemoveEntities):
+			var eids []pk.VarInt
+			err := p.Scan(pk.Array(&eids))
+			if err != nil {
+				log.Printf("Failed to parse remove entities packet: %s", err.Error())
+				continue
+			}
+			ids := make([]int32, len(eids))
+			for i := range eids {
+				ids[i] = int32(eids[i])
+			}
+			sp.sendEvent(cl, EventEntityRemove{EntityIDs: ids})
+		case p.ID == int32(packetid.ClientboundOpenScreen):
+			var wid pk.VarInt
+			err := p.Scan(&wid)
+			if err != nil {
+				log.Printf("Failed to parse open screen packet: %s", err.Error())
+				continue
+			}
+			if pos := cl.state.takeInteraction(); pos != nil {
+				windows[int32(wid)] = *pos
+			} else {
+				delete(windows, int32(wid))
+			}
+		case p.ID == int32(packetid.ClientboundContainerSetContent):
+			var (
+				wid     pk.UnsignedByte
+				stateID pk.VarInt
+				slots   []screen.Slot
+				carried screen.Slot
+			)
+			err := p.Scan(&wid, &stateID, pk.Array(&slots), &carried)
+			if err != nil {
+				log.Printf("Failed to parse container content packet: %s", err.Error())
+				continue
+			}
+			pos, ok := windows[int32(wid)]
+			if !ok {
+				continue
+			}
+			// last 36 slots are always player's inventory
+			if len(slots) < 36 {
+				continue
+			}
+			items := map[int32]int{}
+			for _, s := range slots[:len(slots)-36] {
+				if s.Count > 0 {
+					items[int32(s.ID)] += int(s.Count)
+				}
+			}
+			sp.sendEvent(cl, EventContainerContents{
+				X:     pos.X,
+				Y:     pos.Y,
+				Z:     pos.Z,
+				Items: items,
+			})
+		case p.ID == int32(packetid.ClientboundBlockUpdate):
+			var (
+				loc   pk.Position
+				state pk.VarInt
+			)
+			err := p.Scan(&loc, &state)
+			if err != nil {
+				log.Printf("Failed to parse block update packet: %s", err.Error())
+				continue
+			}
+			sendChunk(&ProxiedChunk{
+				Username:  cl.name,
+				Server:    cl.storedWorld(),
+				Dimension: cl.storedDimension(currentDim),
+				Pos:       level.ChunkPos{int32(loc.X >> 4), int32(loc.Z >> 4)},
+				Changes:   []BlockChange{{X: loc.X, Y: loc.Y, Z: loc.Z, State: int32(state)}},
+			})
+		case p.ID == int32(packetid.ClientboundSectionBlocksUpdate):
+			var (
+				spos   pk.Long
+				blocks []pk.VarLong
+			)
+			err := p.Scan(&spos, pk.Array(&blocks))
+			if err != nil {
+				log.Printf("Failed to parse section blocks update packet: %s", err.Error())
+				continue
+			}
+			// section position is packed as 22 bits x, 22 bits z, 20 bits y
+			sx := int(int64(spos) >> 42)
+			sy := int(int64(spos) << 44 >> 44)
+			sz := int(int64(spos) << 22 >> 42)
+			changes := make([]BlockChange, len(blocks))
+			for i, b := range blocks {
+				// state id followed by 12 bits of xzy inside section
+				changes[i] = BlockChange{
+					X:     sx*16 + int(b>>8&15),
+					Y:     sy*16 + int(b&15),
+					Z:     sz*16 + int(b>>4&15),
+					State: int32(b >> 12),
+				}
+			}
+			sendChunk(&ProxiedChunk{
+				Username:  cl.name,
+				Server:    cl.storedWorld(),
+				Dimension: cl.storedDimension(currentDim),
+				Pos:       level.ChunkPos{int32(sx), int32(sz)},
+				Changes:   changes,
+			})
+		case p.ID == int32(packetid.ClientboundMapItemData):
+			var (
+				mapID    pk.VarInt
+				scale    pk.Byte
+				locked   pk.Boolean
+				hasIcons pk.Boolean
+				icons    []mapIcon
+				columns  pk.UnsignedByte
+				rows     pk.UnsignedByte
+				x, z     pk.UnsignedByte
+				data     pk.ByteArray
+			)
+			err := p.Scan(&mapID, &scale, &locked, &hasIcons,
+				pk.Opt{Has: &hasIcons, Field: pk.Array(&icons)},
+				&columns,
+				pk.Opt{Has: func() bool { return columns > 0 }, Field: pk.Tuple{&rows, &x, &z, &data}})
+			if err != nil {
+				log.Printf("Failed to parse map data packet: %s", err.Error())
+				continue
+			}
+			if columns == 0 {
+				continue // only icons changed
+			}
+			sp.sendEvent(cl, EventMapData{
+				MapID:   int32(mapID),
+				Scale:   int8(scale),
+				Locked:  bool(locked),
+				Columns: int(columns),
+				Rows:    int(rows),
+				X:       int(x),
+				Z:       int(z),
+				Data:    []byte(data),
+			})
+		case p.ID == int32(packetid.ClientboundPlayerChat) && captureChat:
+			var (
+				sender          pk.UUID
+				index           pk.VarInt
+				signature       pk.Option[sign.Signature, *sign.Signature]
+				body            sign.PackedMessageBody
+				unsignedContent pk.Option[chat.Message, *chat.Message]
+				filter          sign.FilterMask
+				chatType        chat.Type
+			)
+			err := p.Scan(&sender, &index, &signature, &body, &unsignedContent, &filter, &chatType)
+			if err != nil {
+				log.Printf("Failed to parse player chat packet: %s", err.Error())
+				continue
+			}
+			text := body.PlainMsg
+			if unsignedContent.Has {
+				text = unsignedContent.Val.ClearString()
+			}
+			sp.sendEvent(cl, EventChat{
+				Kind:       "player",
+				Sender:     chatType.SenderName.ClearString(),
+				SenderUUID: uuid.UUID(sender),
+				Text:       text,
+			})
+		case p.ID == int32(packetid.ClientboundSystemChat) && captureChat:
+			var (
+				msg     chat.Message
+				overlay pk.Boolean
+			)
+			err := p.Scan(&msg, &overlay)
+			if err != nil {
+				log.Printf("Failed to parse system chat packet: %s", err.Error())
+				continue
 			}
+			if overlay {
+				continue // action bar
+			}
+			sp.sendEvent(cl, EventChat{
+				Kind: "system",
+				Text: msg.ClearString(),
+			})
+		case p.ID == int32(packetid.ClientboundDisguisedChat) && captureChat:
+			var (
+				msg      chat.Message
+				chatType chat.Type
+			)
+			err := p.Scan(&msg, &chatType)
+			if err != nil {
+				log.Printf("Failed to parse disguised chat packet: %s", err.Error())
+				continue
+			}
+			sp.sendEvent(cl, EventChat{
+				Kind:   "disguised",
+				Sender: chatType.SenderName.ClearString(),
+				Text:   msg.ClearString(),
+			})
+		case p.ID == int32(packetid.ClientboundPlayerInfoUpdate):
+			players, err := readPlayerInfoUpdate(p, tab)
+			if err != nil {
+				log.Printf("Failed to parse player info update packet: %s", err.Error())
+			}
+			if len(players) > 0 {
+				sp.sendEvent(cl, EventTabListUpdate{Players: players})
+			}
+		case p.ID == int32(packetid.ClientboundPlayerInfoRemove):
+			ids, err := readPlayerInfoRemove(p, tab)
+			if err != nil {
+				log.Printf("Failed to parse player info remove packet: %s", err.Error())
+				continue
+			}
+			sp.sendEvent(cl, EventTabListRemove{UUIDs: ids})
+		case p.ID == int32(packetid.ClientboundSetObjective) && captureScoreboard:
+			o, err := readSetObjective(p)
+			if err != nil {
+				log.Printf("Failed to parse set objective packet: %s", err.Error())
+				continue
+			}
+			sp.sendEvent(cl, o)
+		case p.ID == int32(packetid.ClientboundSetScore) && captureScoreboard:
+			sc, err := readSetScore(p)
+			if err != nil {
+				log.Printf("Failed to parse set score packet: %s", err.Error())
+				continue
+			}
+			sp.sendEvent(cl, sc)
+		case p.ID == int32(packetid.ClientboundBossEvent) && captureScoreboard:
+			bar, err := readBossEvent(p, bossBars)
+			if err != nil {
+				log.Printf("Failed to parse boss event packet: %s", err.Error())
+				continue
+			}
+			if bar != nil {
+				sp.sendEvent(cl, *bar)
+			}
+		case p.ID == int32(packetid.ClientboundSetTime):
+			var age, timeOfDay pk.Long
+			if err := p.Scan(&age, &timeOfDay); err != nil {
+				log.Printf("Failed to parse set time packet: %s", err.Error())
+				continue
+			}
+			sp.sendEvent(cl, EventWorldTime{Age: int64(age), TimeOfDay: int64(timeOfDay)})
+		case p.ID == int32(packetid.ClientboundGameEvent):
+			var (
+				event pk.UnsignedByte
+				value pk.Float
+			)
+			if err := p.Scan(&event, &value); err != nil {
+				log.Printf("Failed to parse game event packet: %s", err.Error())
+				continue
+			}
+			w := weather
+			switch event {
+			case 1:
+				w.Raining = false
+			case 2:
+				w.Raining = true
+			case 7:
+				w.Rain = float32(value)
+			case 8:
+				w.Thunder = float32(value)
+			default:
+				continue
+			}
+			if w != weather {
+				weather = w
+				sp.sendEvent(cl, weather)
+			}
+		case p.ID == int32(packetid.ClientboundPlayerCombatKill):
+			var (
+				playerID pk.VarInt
+				msg      chat.Message
+			)
+			err := p.Scan(&playerID, &msg)
+			if err != nil {
+				log.Printf("Failed to parse combat kill packet: %s", err.Error())
+				continue
+			}
+			x, y, z, ok := cl.state.getPosition()
+			if !ok {
+				log.Printf("Player %s died before sending position", cl.name)
+				continue
+			}
+			sp.sendEvent(cl, EventPlayerDeath{
+				X:       int(math.Floor(x)),
+				Y:       int(math.Floor(y)),
+				Z:       int(math.Floor(z)),
+				Message: msg.ClearString(),
+			})
 		case p.ID == int32(packetid.ClientboundRespawn):
 			var (
 				dim        pk.Identifier
@@ -275,6 +642,9 @@ func (sp SnifferProxy) packetAcceptor(recv chan pk.Packet, conn server.PacketQue
 			}
 			log.Printf("respawn to %s (%s)", dimName, dim)
 			currentDim = string(dimName)
+			cl.state.setDimension(currentDim)
+			// server sends weather of the new dimension again
+			weather = EventWeather{}
 		case p.ID == int32(packetid.ClientboundLogin):
 			var (
 				eid              pk.Int
@@ -317,6 +687,8 @@ func (sp SnifferProxy) packetAcceptor(recv chan pk.Packet, conn server.PacketQue
 				continue
 			}
 			currentDim = string(dimName)
+			cl.state.setDimension(currentDim)
+			weather = EventWeather{}
 			cod := map[string]interface{}{}
 			err = dimCodec.Unmarshal(&cod)
 			if err != nil {
@@ -368,15 +740,15 @@ func (sp SnifferProxy) packetAcceptor(recv chan pk.Packet, conn server.PacketQue
 				}
 				height, ok := de["height"].(int32)
 				if !ok {
-					log.Println("Dimension registry value miny error")
+					log.Println("Dimension registry value height error")
 					spew.Dump(de)
 					continue
 				}
 				loadedDims[dimname] = loadedDim{
-					id:          dimid,
-					minY:        miny,
-					height:      height,
-					totalHeight: height - miny,
+					id:     dimid,
+					minY:   miny,
+					height: height,
+					maxY:   miny + height,
 				}
 			}
 		}
@@ -392,15 +764,15 @@ func (sp SnifferProxy) packetAcceptor(recv chan pk.Packet, conn server.PacketQue
 			log.Printf("Have no information about dimension [%s]", currentDim)
 			continue
 		}
-		sp.SaveChannel <- &ProxiedChunk{
+		sendChunk(&ProxiedChunk{
 			Username:            cl.name,
-			Server:              cl.dest,
-			Dimension:           currentDim,
+			Server:              cl.storedWorld(),
+			Dimension:           cl.storedDimension(currentDim),
 			Pos:                 i.pos,
 			Data:                j.chunk,
 			DimensionLowestY:    dim.minY,
-			DimensionBuildLimit: int(dim.height),
-		}
+			DimensionBuildLimit: int(dim.maxY),
+		})
 	}
 	log.Printf("Packet processor for player [%s] stopped", cl.name)
 }
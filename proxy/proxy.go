@@ -28,13 +28,11 @@ import (
 	"log"
 	"math/rand"
 	"os"
-	"strings"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/maxsupermanhd/WebChunk/credentials"
-	"github.com/maxsupermanhd/go-vmc/v764/bot"
 	"github.com/maxsupermanhd/go-vmc/v764/chat"
 	"github.com/maxsupermanhd/go-vmc/v764/chat/sign"
 	"github.com/maxsupermanhd/go-vmc/v764/data/packetid"
@@ -61,13 +59,24 @@ type ProxiedPacket struct {
 }
 
 type ProxiedChunk struct {
-	Username            string
-	Server              string
-	Dimension           string
+	Username  string
+	Server    string
+	Dimension string
+	// vertical extent of the dimension from registry, build limit is exclusive
 	DimensionLowestY    int32
 	DimensionBuildLimit int
 	Pos                 level.ChunkPos
 	Data                level.Chunk
+	// when not empty this is a partial update and Data is not set
+	Changes []BlockChange
+	// session id and number of chunk batch chunk came in, to find it in logs
+	BatchID string
+}
+
+// BlockChange is a single block set by server after chunk was sent, in absolute block coordinates
+type BlockChange struct {
+	X, Y, Z int
+	State   int32
 }
 
 type MessageFeedback struct {
@@ -82,11 +91,35 @@ var collectPackets = []packetid.ClientboundPacketID{
 	packetid.ClientboundForgetLevelChunk,
 	packetid.ClientboundLogin,
 	packetid.ClientboundRespawn,
+	packetid.ClientboundAddEntity,
+	packetid.ClientboundRemoveEntities,
+	packetid.ClientboundOpenScreen,
+	packetid.ClientboundContainerSetContent,
+	packetid.ClientboundMapItemData,
+	packetid.ClientboundBlockUpdate,
+	packetid.ClientboundSectionBlocksUpdate,
+	packetid.ClientboundPlayerChat,
+	packetid.ClientboundSystemChat,
+	packetid.ClientboundDisguisedChat,
+	packetid.ClientboundPlayerInfoUpdate,
+	packetid.ClientboundPlayerInfoRemove,
+	packetid.ClientboundPlayerCombatKill,
+	packetid.ClientboundSetObjective,
+	packetid.ClientboundSetScore,
+	packetid.ClientboundBossEvent,
+	packetid.ClientboundSetTime,
+	packetid.ClientboundGameEvent,
+	packetid.ClientboundChunkBatchStart,
+}
+
+// NewCredentialsManager reads accounts used to log in to upstream servers
+func NewCredentialsManager(cfg *lac.ConfSubtree) *credentials.MicrosoftCredentialsManager {
+	return credentials.NewMicrosoftCredentialsManager(cfg.GetDSString("./cmd/auth/", "credentials_path"), cfg.GetDSString(credentials.DefaultAppID, "credentials_app_id"))
 }
 
-func RunProxy(ctx context.Context, cfg *lac.ConfSubtree, dump chan *ProxiedChunk) {
-	listenAddr := cfg.GetDSString("localhost:25566", "listen_addr")
-	if listenAddr == "" {
+func RunProxy(ctx context.Context, cfg *lac.ConfSubtree, dump chan *ProxiedChunk, events chan *ProxiedEvent) {
+	listeners := loadListeners(cfg)
+	if len(listeners) == 0 {
 		log.Println("Proxy disabled")
 		return
 	}
@@ -104,7 +137,6 @@ func RunProxy(ctx context.Context, cfg *lac.ConfSubtree, dump chan *ProxiedChunk
 			f.Close()
 		}
 	}
-	playerList := server.NewPlayerList(cfg.GetDSInt(999, "max_players"))
 	var motd chat.Message
 	if err := cfg.GetToStruct(&motd, "motd"); err != nil {
 		log.Println("Using default MOTD because failed to parse one from config: ", err.Error())
@@ -112,30 +144,51 @@ func RunProxy(ctx context.Context, cfg *lac.ConfSubtree, dump chan *ProxiedChunk
 		cfg.Set(map[string]any{"text": "WebChunk proxy"}, "motd")
 	}
 	serverInfo := server.NewPingInfo(server.ProtocolName, server.ProtocolVersion, motd, icon)
-	s := server.Server{
-		ListPingHandler: struct {
-			*server.PlayerList
-			*server.PingInfo
-		}{playerList, serverInfo},
-		LoginHandler: &server.MojangLoginHandler{
-			OnlineMode:   cfg.GetDSBool(true, "online_mode"),
-			Threshold:    cfg.GetDSInt(-1, "compress_threshold"),
-			LoginChecker: nil,
-		},
-		GamePlay: SnifferProxy{
-			Routing: func(name string) string {
-				r, _ := cfg.GetString("routes", name)
-				return r
+	credManager := NewCredentialsManager(cfg)
+	var wg sync.WaitGroup
+	for _, l := range listeners {
+		l := l
+		s := server.Server{
+			ListPingHandler: &statusHandler{
+				cfg:    cfg,
+				local:  serverInfo,
+				max:    cfg.GetDSInt(999, "max_players"),
+				server: l.Address,
+			},
+			LoginHandler: &server.MojangLoginHandler{
+				OnlineMode:   cfg.GetDSBool(true, "online_mode"),
+				Threshold:    cfg.GetDSInt(-1, "compress_threshold"),
+				LoginChecker: accessListChecker{cfg: cfg, listener: l.ListenAddr},
 			},
-			CredManager: credentials.NewMicrosoftCredentialsManager(cfg.GetDSString("./cmd/auth/", "credentials_path"), "88650e7e-efee-4857-b9a9-cf580a00ef43"),
-			SaveChannel: dump,
-			Conf:        cfg,
-			Ctx:         ctx,
-		},
+			GamePlay: SnifferProxy{
+				Routing: func(name string) string {
+					if l.Address != "" {
+						return l.Address
+					}
+					r, _ := cfg.GetString("routes", name)
+					return r
+				},
+				CredManager:  credManager,
+				SaveChannel:  dump,
+				EventChannel: events,
+				Conf:         cfg,
+				Ctx:          ctx,
+				Listener:     l,
+			},
+		}
+		wg.Add(1)
+		go func() {
+			serveListener(ctx, l.ListenAddr, &s)
+			wg.Done()
+		}()
 	}
+	wg.Wait()
+}
+
+func serveListener(ctx context.Context, listenAddr string, s *server.Server) {
 	listener, err := net.ListenMC(listenAddr)
 	if err != nil {
-		log.Println("Proxy startup error: ", err)
+		log.Printf("Proxy startup error on [%s]: %v", listenAddr, err)
 		return
 	}
 	log.Println("Proxy started on " + listenAddr)
@@ -172,11 +225,13 @@ func RunProxy(ctx context.Context, cfg *lac.ConfSubtree, dump chan *ProxiedChunk
 }
 
 type SnifferProxy struct {
-	Routing     func(name string) string
-	CredManager *credentials.MicrosoftCredentialsManager
-	SaveChannel chan *ProxiedChunk
-	Conf        *lac.ConfSubtree
-	Ctx         context.Context
+	Routing      func(name string) string
+	CredManager  *credentials.MicrosoftCredentialsManager
+	SaveChannel  chan *ProxiedChunk
+	EventChannel chan *ProxiedEvent
+	Conf         *lac.ConfSubtree
+	Ctx          context.Context
+	Listener     ProxyListener
 }
 
 type clientinfo struct {
@@ -187,9 +242,20 @@ type clientinfo struct {
 	proto         int32
 	conn          *net.Conn
 	dest          string
+	// where captured data is stored, dest if empty
+	world      string
+	dimensions map[string]string
+	state      *sessionState
+	stats      *sessionStats
+	// packets come from a dump instead of a server
+	replay bool
 }
 
 func (p SnifferProxy) AcceptPlayer(name string, id uuid.UUID, profilePubKey *auth.PublicKey, properties []auth.Property, proto int32, conn *net.Conn) {
+	if target := p.spectateTarget(name); target != "" {
+		acceptSpectator(name, target, conn)
+		return
+	}
 	dest := p.Routing(name)
 	cl := clientinfo{
 		name:          name,
@@ -199,37 +265,40 @@ func (p SnifferProxy) AcceptPlayer(name string, id uuid.UUID, profilePubKey *aut
 		proto:         proto,
 		conn:          conn,
 		dest:          dest,
+		world:         p.Listener.World,
+		dimensions:    p.Listener.Dimensions,
+		state:         &sessionState{},
 	}
 	if cl.dest == "" {
 		log.Printf("Accepting new player [%s] (%s), protocol %v, unable to find route...", cl.name, cl.id.String(), cl.proto)
 		dissconnectWithMessage(conn, &chat.Message{Text: "Dissconnected before login: no defined route for specified username"})
 		return
 	}
-	log.Printf("Accepting new player [%s] (%s), protocol %v, routing to [%s], getting auth...", cl.name, cl.id.String(), cl.proto, dest)
-	auth, err := p.CredManager.GetAuthForUsername(name)
+	log.Printf("Accepting new player [%s] (%s), protocol %v, routing to [%s], dialing...", cl.name, cl.id.String(), cl.proto, dest)
+	c, err := p.dialUpstream(name, dest)
 	if err != nil {
-		log.Printf("Error preparing auth for player [%s]: %v", name, err)
-		dissconnectWithError(conn, err)
-		return
-	}
-	if auth == nil {
-		log.Printf("Error preparing auth for player [%s]: auth is nil", name)
-		dissconnectWithMessage(conn, &chat.Message{Text: "Dissconnected before login: Auth is nil"})
-		return
-	}
-	c := bot.NewClient()
-	c.Auth = bot.Auth{
-		Name: auth.Name,
-		UUID: auth.UUID,
-		AsTk: auth.AsTk,
-	}
-	log.Printf("Accepting new player [%s] (%s), dialing [%s]...", name, id.String(), dest)
-	if err := c.JoinServerWithOptions(dest, bot.JoinOptions{NoPublicKey: true}); err != nil {
 		log.Printf("Failed to accept new player [%s] (%s), error connecting to [%s]: %v", name, id.String(), dest, err)
-		dissconnectWithMessage(conn, &chat.Message{Text: strings.TrimPrefix(err.Error(), "bot: disconnect error: disconnect because: ")})
+		dissconnectWithMessage(conn, &chat.Message{Text: err.Error()})
 		return
 	}
 	log.Printf("Player [%s] accepted to [%s]", name, dest)
+	saveRegistries(p.Conf, &c.ConfigData.Registries)
+	up := newUpstreamConn(c)
+	var relay *sessionRelay
+	if p.Conf.GetDSBool(false, "spectator_relay") {
+		relay = openRelay(name, cl.state)
+		defer relay.close(name)
+	}
+	cl.stats = newSessionStats(name, dest, p.Conf.GetDSInt(100, "session_stats_retain"))
+	defer cl.stats.finish()
+	conn.Reader = countingReader{r: conn.Reader, n: &cl.stats.clientWireIn}
+	conn.Writer = countingWriter{w: conn.Writer, n: &cl.stats.clientWireOut}
+	p.sendEvent(cl, EventPlayerJoin{})
+	defer p.sendEvent(cl, EventPlayerLeave{})
+	reconnect := p.Conf.GetDSInt(0, "reconnect_attempts") > 0
+	positionInterval := time.Duration(p.Conf.GetDSInt(500, "position_update_interval")) * time.Millisecond
+	sendEvent := p.sendEvent
+	conf := p.Conf
 
 	var wg sync.WaitGroup
 
@@ -251,8 +320,7 @@ func (p SnifferProxy) AcceptPlayer(name string, id uuid.UUID, profilePubKey *aut
 		case <-p.Ctx.Done():
 		}
 		conn.Socket.SetDeadline(time.UnixMilli(0))
-		c.Conn.Close()
-		// c.Conn..SetDeadline(time.UnixMilli(0))
+		up.close()
 		connQueue.Close()
 		wg.Done()
 	}()
@@ -266,7 +334,46 @@ func (p SnifferProxy) AcceptPlayer(name string, id uuid.UUID, profilePubKey *aut
 			if err != nil {
 				break
 			}
+			cl.stats.countOut(p)
 			// log.Printf("c->s (pump) %x", pk.ID)
+			if p.ID == int32(packetid.ServerboundMovePlayerPos) || p.ID == int32(packetid.ServerboundMovePlayerPosRot) {
+				var (
+					x, y, z    pk.Double
+					yaw, pitch pk.Float
+				)
+				var err error
+				if p.ID == int32(packetid.ServerboundMovePlayerPosRot) {
+					err = p.Scan(&x, &y, &z, &yaw, &pitch)
+				} else {
+					err = p.Scan(&x, &y, &z)
+				}
+				if err != nil {
+					log.Println("Error scanning player position:", err)
+				} else {
+					cl.state.setPosition(float64(x), float64(y), float64(z))
+					if cl.state.shouldSendPosition(positionInterval) {
+						sendEvent(cl, EventPlayerPosition{
+							X:     float64(x),
+							Y:     float64(y),
+							Z:     float64(z),
+							Yaw:   float32(yaw),
+							Pitch: float32(pitch),
+						})
+					}
+				}
+			}
+			switch p.ID {
+			case int32(packetid.ServerboundUseItemOn):
+				var (
+					hand pk.VarInt
+					pos  pk.Position
+				)
+				if err := p.Scan(&hand, &pos); err == nil {
+					cl.state.setInteraction(&pos)
+				}
+			case int32(packetid.ServerboundInteract):
+				cl.state.setInteraction(nil)
+			}
 			if p.ID == int32(packetid.ServerboundChat) {
 				var (
 					msg pk.String
@@ -277,6 +384,17 @@ func (p SnifferProxy) AcceptPlayer(name string, id uuid.UUID, profilePubKey *aut
 				if err != nil {
 					log.Println("Error scanning message:", err)
 				}
+				if evt, reply, ok := parseMarkerCommand(conf, cl.state, string(msg)); ok {
+					if evt != nil {
+						sendEvent(cl, *evt)
+					}
+					connQueue.Push(pk.Marshal(
+						packetid.ClientboundSystemChat,
+						chat.Text(reply),
+						pk.Boolean(false),
+					))
+					continue
+				}
 				sendout := pk.Marshal(
 					packetid.ServerboundChat,
 					pk.String(msg),
@@ -289,13 +407,13 @@ func (p SnifferProxy) AcceptPlayer(name string, id uuid.UUID, profilePubKey *aut
 						Has: false,
 					},
 				)
-				err = c.Conn.WritePacket(sendout)
+				err = up.write(sendout)
 				if err != nil {
 					log.Println("Failed to unmarshal packet:", err)
 				}
 			} else {
-				err = c.Conn.WritePacket(p)
-				if err != nil {
+				err = up.write(p)
+				if err != nil && !reconnect {
 					break
 				}
 			}
@@ -310,12 +428,30 @@ func (p SnifferProxy) AcceptPlayer(name string, id uuid.UUID, profilePubKey *aut
 	wg.Add(1)
 	go func() {
 		var err error
+		c := c
+		rejoined := false
 		for {
 			var pack pk.Packet
 			err = c.Conn.ReadPacket(&pack)
+			if err == nil && reconnect && pack.ID == int32(packetid.ClientboundDisconnect) {
+				var reason chat.Message
+				pack.Scan(&reason)
+				err = kickError(reason)
+			}
 			if err != nil {
-				break
+				if !reconnect || up.isClosed() {
+					break
+				}
+				if c = p.reconnect(cl, up, connQueue, err); c == nil {
+					if !up.isClosed() {
+						connQueue.Push(pk.Marshal(packetid.ClientboundDisconnect, chat.Text("Failed to reconnect: "+err.Error())))
+					}
+					break
+				}
+				rejoined = true
+				continue
 			}
+			cl.stats.countIn(pack)
 			// topack := pk.Packet{
 			// 	ID:   pack.ID,
 			// 	Data: make([]byte, len(pack.Data)),
@@ -327,8 +463,27 @@ func (p SnifferProxy) AcceptPlayer(name string, id uuid.UUID, profilePubKey *aut
 					break
 				}
 			}
-			// log.Printf("s->c (queuePush) %x", pack.ID)
-			connQueue.Push(pack)
+			if pack.ID == int32(packetid.ClientboundLogin) {
+				var eid pk.Int
+				if err := pack.Scan(&eid); err == nil {
+					up.setEntityID(int32(eid), !rejoined)
+				}
+			}
+			out := []pk.Packet{up.remapClientbound(pack)}
+			if rejoined && pack.ID == int32(packetid.ClientboundLogin) {
+				rejoined = false
+				if out, err = loginToRespawn(pack); err != nil {
+					log.Printf("Failed to convert login of player [%s] after reconnect: %v", name, err)
+					break
+				}
+			}
+			for _, pack := range out {
+				if relay != nil {
+					relay.feed(pack)
+				}
+				// log.Printf("s->c (queuePush) %x", pack.ID)
+				connQueue.Push(pack)
+			}
 		}
 		if !errors.Is(err, os.ErrDeadlineExceeded) {
 			log.Printf("Player [%s] left from server [%s] (c->s): %v", name, dest, err)
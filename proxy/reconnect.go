@@ -0,0 +1,350 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maxsupermanhd/go-vmc/v764/bot"
+	"github.com/maxsupermanhd/go-vmc/v764/chat"
+	"github.com/maxsupermanhd/go-vmc/v764/data/packetid"
+	pk "github.com/maxsupermanhd/go-vmc/v764/net/packet"
+	"github.com/maxsupermanhd/go-vmc/v764/net/queue"
+)
+
+// upstream side of the session that can be swapped when proxy reconnects
+type upstreamConn struct {
+	lock   sync.Mutex
+	client *bot.Client
+	closed bool
+	done   chan struct{}
+	// keep alives proxy sent to the client on its own, replies must not reach the server
+	keepAlives map[int64]bool
+	// player entity id client got on first login and one current server gave,
+	// they differ after reconnect and get swapped in packets both ways
+	clientEID, serverEID int32
+}
+
+func newUpstreamConn(c *bot.Client) *upstreamConn {
+	return &upstreamConn{
+		client:     c,
+		done:       make(chan struct{}),
+		keepAlives: map[int64]bool{},
+	}
+}
+
+// false if session was closed in the meantime
+func (u *upstreamConn) set(c *bot.Client) bool {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if u.closed {
+		return false
+	}
+	u.client = c
+	return true
+}
+
+func (u *upstreamConn) drop() {
+	u.lock.Lock()
+	u.client = nil
+	u.lock.Unlock()
+}
+
+func (u *upstreamConn) close() {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if u.closed {
+		return
+	}
+	u.closed = true
+	close(u.done)
+	if u.client != nil {
+		u.client.Conn.Close()
+	}
+}
+
+func (u *upstreamConn) isClosed() bool {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	return u.closed
+}
+
+// packets sent while there is no server are dropped
+func (u *upstreamConn) write(p pk.Packet) error {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if p.ID == int32(packetid.ServerboundKeepAlive) {
+		var id pk.Long
+		if p.Scan(&id) == nil && u.keepAlives[int64(id)] {
+			delete(u.keepAlives, int64(id))
+			return nil
+		}
+	}
+	if u.client == nil {
+		return nil
+	}
+	return u.client.Conn.WritePacket(remapEntityIDs(p, serverboundEntityIDs, u.clientEID, u.serverEID))
+}
+
+// called with every login server sends, first one sets id client knows
+func (u *upstreamConn) setEntityID(id int32, first bool) {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if first {
+		u.clientEID = id
+	}
+	u.serverEID = id
+}
+
+func (u *upstreamConn) remapClientbound(p pk.Packet) pk.Packet {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	return remapEntityIDs(p, clientboundEntityIDs, u.clientEID, u.serverEID)
+}
+
+type eidField int
+
+const (
+	eidVarInt eidField = iota
+	eidInt
+	// varint count followed by that many varint ids
+	eidVarIntArray
+)
+
+// leading fields that hold entity ids, rest of the packet is copied as is
+var clientboundEntityIDs = map[int32][]eidField{
+	int32(packetid.ClientboundAnimate):          {eidVarInt},
+	int32(packetid.ClientboundDamageEvent):      {eidVarInt},
+	int32(packetid.ClientboundEntityEvent):      {eidInt},
+	int32(packetid.ClientboundHurtAnimation):    {eidVarInt},
+	int32(packetid.ClientboundMoveEntityPos):    {eidVarInt},
+	int32(packetid.ClientboundMoveEntityPosRot): {eidVarInt},
+	int32(packetid.ClientboundMoveEntityRot):    {eidVarInt},
+	int32(packetid.ClientboundPlayerCombatKill): {eidVarInt},
+	int32(packetid.ClientboundRemoveMobEffect):  {eidVarInt},
+	int32(packetid.ClientboundRotateHead):       {eidVarInt},
+	int32(packetid.ClientboundSetCamera):        {eidVarInt},
+	int32(packetid.ClientboundSetEntityData):    {eidVarInt},
+	int32(packetid.ClientboundSetEntityLink):    {eidInt, eidInt},
+	int32(packetid.ClientboundSetEntityMotion):  {eidVarInt},
+	int32(packetid.ClientboundSetEquipment):     {eidVarInt},
+	int32(packetid.ClientboundSetPassengers):    {eidVarInt, eidVarIntArray},
+	int32(packetid.ClientboundTakeItemEntity):   {eidVarInt, eidVarInt},
+	int32(packetid.ClientboundTeleportEntity):   {eidVarInt},
+	int32(packetid.ClientboundUpdateAttributes): {eidVarInt},
+	int32(packetid.ClientboundUpdateMobEffect):  {eidVarInt},
+}
+
+var serverboundEntityIDs = map[int32][]eidField{
+	int32(packetid.ServerboundInteract):      {eidVarInt},
+	int32(packetid.ServerboundPlayerCommand): {eidVarInt},
+}
+
+// swaps ids a and b, packet is returned unchanged if they are the same or it
+// fails to parse
+func remapEntityIDs(p pk.Packet, fields map[int32][]eidField, a, b int32) pk.Packet {
+	f, ok := fields[p.ID]
+	if !ok || a == b {
+		return p
+	}
+	swap := func(id int32) int32 {
+		switch id {
+		case a:
+			return b
+		case b:
+			return a
+		}
+		return id
+	}
+	r := bytes.NewReader(p.Data)
+	var buf bytes.Buffer
+	for _, k := range f {
+		var err error
+		switch k {
+		case eidVarInt:
+			err = remapVarInt(r, &buf, swap)
+		case eidInt:
+			var id pk.Int
+			if _, err = id.ReadFrom(r); err == nil {
+				pk.Int(swap(int32(id))).WriteTo(&buf)
+			}
+		case eidVarIntArray:
+			var n pk.VarInt
+			if _, err = n.ReadFrom(r); err != nil {
+				break
+			}
+			n.WriteTo(&buf)
+			for i := 0; i < int(n) && err == nil; i++ {
+				err = remapVarInt(r, &buf, swap)
+			}
+		}
+		if err != nil {
+			return p
+		}
+	}
+	buf.Write(p.Data[len(p.Data)-r.Len():])
+	return pk.Packet{ID: p.ID, Data: buf.Bytes()}
+}
+
+func remapVarInt(r *bytes.Reader, w *bytes.Buffer, swap func(int32) int32) error {
+	var id pk.VarInt
+	if _, err := id.ReadFrom(r); err != nil {
+		return err
+	}
+	pk.VarInt(swap(int32(id))).WriteTo(w)
+	return nil
+}
+
+func (u *upstreamConn) keepAlive() pk.Packet {
+	id := rand.Int63()
+	u.lock.Lock()
+	u.keepAlives[id] = true
+	u.lock.Unlock()
+	return pk.Marshal(packetid.ClientboundKeepAlive, pk.Long(id))
+}
+
+type kickError chat.Message
+
+func (k kickError) Error() string {
+	return "kicked: " + chat.Message(k).ClearString()
+}
+
+func (p SnifferProxy) dialUpstream(name, dest string) (*bot.Client, error) {
+	auth, err := p.CredManager.GetAuthForUsername(name)
+	if err != nil {
+		return nil, err
+	}
+	if auth == nil {
+		return nil, errors.New("auth is nil")
+	}
+	c := bot.NewClient()
+	c.Auth = bot.Auth{
+		Name: auth.Name,
+		UUID: auth.UUID,
+		AsTk: auth.AsTk,
+	}
+	if err := c.JoinServerWithOptions(dest, bot.JoinOptions{NoPublicKey: true}); err != nil {
+		return nil, errors.New(strings.TrimPrefix(err.Error(), "bot: disconnect error: disconnect because: "))
+	}
+	return c, nil
+}
+
+func notifyClient(out queue.Queue[pk.Packet], msg string) {
+	out.Push(pk.Marshal(
+		packetid.ClientboundSystemChat,
+		chat.Text(msg),
+		pk.Boolean(false),
+	))
+}
+
+// waits before next attempt while keeping client from timing out,
+// false if session ended
+func (u *upstreamConn) wait(out queue.Queue[pk.Packet], d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-u.done:
+			return false
+		case <-timer.C:
+			return true
+		case <-ticker.C:
+			out.Push(u.keepAlive())
+		}
+	}
+}
+
+// tries to get player back to the server with exponential backoff, nil if gave up
+func (p SnifferProxy) reconnect(cl clientinfo, up *upstreamConn, out queue.Queue[pk.Packet], cause error) *bot.Client {
+	attempts := p.Conf.GetDSInt(0, "reconnect_attempts")
+	delay := time.Duration(p.Conf.GetDSInt(5, "reconnect_delay")) * time.Second
+	maxDelay := time.Duration(p.Conf.GetDSInt(60, "reconnect_max_delay")) * time.Second
+	up.drop()
+	log.Printf("Player [%s] lost connection to [%s]: %v, reconnecting", cl.name, cl.dest, cause)
+	notifyClient(out, fmt.Sprintf("Lost connection to the server (%v), reconnecting...", cause))
+	for i := 1; i <= attempts; i++ {
+		if !up.wait(out, delay) {
+			return nil
+		}
+		c, err := p.dialUpstream(cl.name, cl.dest)
+		if err == nil {
+			if !up.set(c) {
+				c.Conn.Close()
+				return nil
+			}
+			log.Printf("Player [%s] reconnected to [%s] (attempt %d)", cl.name, cl.dest, i)
+			return c
+		}
+		log.Printf("Player [%s] failed to reconnect to [%s] (attempt %d of %d): %v", cl.name, cl.dest, i, attempts, err)
+		notifyClient(out, fmt.Sprintf("Reconnect attempt %d of %d failed: %v", i, attempts, err))
+		delay *= 2
+		if delay > maxDelay {
+			delay = maxDelay
+		}
+	}
+	return nil
+}
+
+// client is already in game so login of the new connection is turned into
+// respawns, first one to a made up dimension to make client drop the old world.
+// player entity id stays the one from the first login, upstreamConn swaps it
+// with the new one in packets.
+func loginToRespawn(p pk.Packet) ([]pk.Packet, error) {
+	var (
+		entityID                                  pk.Int
+		hardcore                                  pk.Boolean
+		dimensions                                []pk.Identifier
+		maxPlayers, viewDistance, simDistance     pk.VarInt
+		reducedDebug, respawnScreen, limitedCraft pk.Boolean
+		dimType, dimName                          pk.Identifier
+	)
+	r := bytes.NewReader(p.Data)
+	for _, f := range []pk.FieldDecoder{
+		&entityID, &hardcore, pk.Array(&dimensions),
+		&maxPlayers, &viewDistance, &simDistance,
+		&reducedDebug, &respawnScreen, &limitedCraft,
+		&dimType, &dimName,
+	} {
+		if _, err := f.ReadFrom(r); err != nil {
+			return nil, err
+		}
+	}
+	// seed, gamemodes, flags, death location and portal cooldown match respawn layout
+	rest := p.Data[len(p.Data)-r.Len():]
+	respawn := func(dim pk.Identifier) pk.Packet {
+		var buf bytes.Buffer
+		dimType.WriteTo(&buf)
+		dim.WriteTo(&buf)
+		buf.Write(rest)
+		pk.Byte(0).WriteTo(&buf)
+		return pk.Packet{ID: int32(packetid.ClientboundRespawn), Data: buf.Bytes()}
+	}
+	return []pk.Packet{respawn("webchunk:reconnect"), respawn(dimName)}, nil
+}
@@ -0,0 +1,51 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package proxy
+
+import (
+	"log"
+	"os"
+
+	"github.com/maxsupermanhd/go-vmc/v764/nbt"
+	"github.com/maxsupermanhd/go-vmc/v764/registry"
+	"github.com/maxsupermanhd/lac"
+)
+
+// registries sent by upstream during configuration, world server
+// needs them to let game clients in and WebChunk has no copy of its own
+func saveRegistries(cfg *lac.ConfSubtree, r *registry.NetworkCodec) {
+	path := cfg.GetDSString("./registry.nbt", "registry_path")
+	if path == "" || len(r.DimensionType.Value) == 0 {
+		return
+	}
+	b, err := nbt.Marshal(r)
+	if err != nil {
+		log.Printf("Failed to marshal registries: %s", err.Error())
+		return
+	}
+	if err := os.WriteFile(path+".tmp", b, 0644); err != nil {
+		log.Printf("Failed to save registries: %s", err.Error())
+		return
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		log.Printf("Failed to save registries: %s", err.Error())
+	}
+}
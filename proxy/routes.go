@@ -0,0 +1,81 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package proxy
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/maxsupermanhd/lac"
+)
+
+// routes are read on every login so changing them needs no restart,
+// lock only keeps read-modify-write from api consistent
+var routesLock sync.Mutex
+
+// player name to upstream server address
+func GetRoutes(cfg *lac.ConfSubtree) (map[string]string, error) {
+	ret := map[string]string{}
+	err := cfg.GetToStruct(&ret, "routes")
+	if errors.Is(err, lac.ErrNoKey) {
+		err = nil
+	}
+	return ret, err
+}
+
+// returns false if route was only changed
+func SetRoute(cfg *lac.ConfSubtree, player, address string) (map[string]string, bool, error) {
+	routesLock.Lock()
+	defer routesLock.Unlock()
+	routes, err := GetRoutes(cfg)
+	if err != nil {
+		return routes, false, err
+	}
+	existed := false
+	for p := range routes {
+		if strings.EqualFold(p, player) {
+			existed = true
+			delete(routes, p)
+		}
+	}
+	routes[player] = address
+	cfg.Set(routes, "routes")
+	return routes, !existed, nil
+}
+
+// returns false if there was no route for the player
+func RemoveRoute(cfg *lac.ConfSubtree, player string) (map[string]string, bool, error) {
+	routesLock.Lock()
+	defer routesLock.Unlock()
+	routes, err := GetRoutes(cfg)
+	if err != nil {
+		return routes, false, err
+	}
+	for p := range routes {
+		if strings.EqualFold(p, player) {
+			delete(routes, p)
+			cfg.Set(routes, "routes")
+			return routes, true, nil
+		}
+	}
+	return routes, false, nil
+}
@@ -0,0 +1,115 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package proxy
+
+import (
+	"github.com/google/uuid"
+	"github.com/maxsupermanhd/go-vmc/v764/chat"
+	pk "github.com/maxsupermanhd/go-vmc/v764/net/packet"
+)
+
+// modes are create, remove and update, render type after display name is not needed
+func readSetObjective(p pk.Packet) (EventObjective, error) {
+	var (
+		name    pk.String
+		mode    pk.Byte
+		display chat.Message
+	)
+	if err := p.Scan(&name, &mode); err != nil {
+		return EventObjective{}, err
+	}
+	ret := EventObjective{Name: string(name), Removed: mode == 1}
+	if mode == 0 || mode == 2 {
+		if err := p.Scan(&name, &mode, &display); err != nil {
+			return ret, err
+		}
+		ret.DisplayName = display.ClearString()
+	}
+	return ret, nil
+}
+
+// action 0 sets the value, 1 removes the score
+func readSetScore(p pk.Packet) (EventScore, error) {
+	var (
+		entity, objective pk.String
+		action, value     pk.VarInt
+	)
+	if err := p.Scan(&entity, &action, &objective); err != nil {
+		return EventScore{}, err
+	}
+	ret := EventScore{Objective: string(objective), Entity: string(entity), Removed: action == 1}
+	if action == 0 {
+		if err := p.Scan(&entity, &action, &objective, &value); err != nil {
+			return ret, err
+		}
+		ret.Value = int32(value)
+	}
+	return ret, nil
+}
+
+// updates carry only the changed part so bars are kept to report whole state,
+// actions are add, remove, health, title, style and flags
+func readBossEvent(p pk.Packet, bars map[uuid.UUID]*EventBossBar) (*EventBossBar, error) {
+	var (
+		id     pk.UUID
+		action pk.VarInt
+		title  chat.Message
+		health pk.Float
+		color  pk.VarInt
+	)
+	if err := p.Scan(&id, &action); err != nil {
+		return nil, err
+	}
+	bar, ok := bars[uuid.UUID(id)]
+	if !ok {
+		bar = &EventBossBar{UUID: uuid.UUID(id)}
+	}
+	switch action {
+	case 0:
+		if err := p.Scan(&id, &action, &title, &health, &color); err != nil {
+			return nil, err
+		}
+		bar.Title, bar.Health, bar.Color = title.ClearString(), float32(health), int32(color)
+	case 1:
+		delete(bars, bar.UUID)
+		bar.Removed = true
+		return bar, nil
+	case 2:
+		if err := p.Scan(&id, &action, &health); err != nil {
+			return nil, err
+		}
+		bar.Health = float32(health)
+	case 3:
+		if err := p.Scan(&id, &action, &title); err != nil {
+			return nil, err
+		}
+		bar.Title = title.ClearString()
+	case 4:
+		if err := p.Scan(&id, &action, &color); err != nil {
+			return nil, err
+		}
+		bar.Color = int32(color)
+	default:
+		return nil, nil
+	}
+	bars[bar.UUID] = bar
+	return bar, nil
+}
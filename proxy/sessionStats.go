@@ -0,0 +1,231 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package proxy
+
+import (
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/maxsupermanhd/go-vmc/v764/data/packetid"
+	pk "github.com/maxsupermanhd/go-vmc/v764/net/packet"
+)
+
+// sessionStats is collected for every proxied session, counters are updated
+// from packet pumps concurrently so everything is either atomic or locked
+type sessionStats struct {
+	id       int64
+	username string
+	server   string
+	started  time.Time
+	ended    atomic.Pointer[time.Time]
+
+	// bytes on the wire between proxy and player (compressed and encrypted),
+	// connection to the server is already read by the bot before session starts
+	clientWireIn, clientWireOut atomic.Int64
+	// uncompressed packet sizes
+	payloadIn, payloadOut atomic.Int64
+
+	chunksReceived, chunksForwarded, blockUpdates atomic.Int64
+
+	packetsLock sync.Mutex
+	packetsIn   map[int32]int64
+	packetsOut  map[int32]int64
+}
+
+// SessionStats is a snapshot of proxied session statistics, In is server to client direction
+type SessionStats struct {
+	ID               int64
+	Username         string
+	Server           string
+	Started          time.Time
+	Ended            *time.Time
+	ClientWireIn     int64
+	ClientWireOut    int64
+	PayloadIn        int64
+	PayloadOut       int64
+	CompressionRatio float64
+	ChunksReceived   int64
+	ChunksForwarded  int64
+	BlockUpdates     int64
+	PacketsIn        map[string]int64 `json:",omitempty"`
+	PacketsOut       map[string]int64 `json:",omitempty"`
+}
+
+var (
+	statsLock     sync.Mutex
+	statsLastID   int64
+	statsSessions []*sessionStats
+)
+
+func newSessionStats(username, server string, retain int) *sessionStats {
+	statsLock.Lock()
+	defer statsLock.Unlock()
+	statsLastID++
+	s := &sessionStats{
+		id:         statsLastID,
+		username:   username,
+		server:     server,
+		started:    time.Now(),
+		packetsIn:  map[int32]int64{},
+		packetsOut: map[int32]int64{},
+	}
+	statsSessions = append(statsSessions, s)
+	// drop oldest finished sessions, live ones are always kept
+	finished := 0
+	for _, v := range statsSessions {
+		if v.ended.Load() != nil {
+			finished++
+		}
+	}
+	kept := statsSessions[:0]
+	for _, v := range statsSessions {
+		if finished > retain && v.ended.Load() != nil {
+			finished--
+			continue
+		}
+		kept = append(kept, v)
+	}
+	statsSessions = kept
+	return s
+}
+
+func liveSessionsCount() int {
+	statsLock.Lock()
+	defer statsLock.Unlock()
+	ret := 0
+	for _, v := range statsSessions {
+		if v.ended.Load() == nil {
+			ret++
+		}
+	}
+	return ret
+}
+
+func (s *sessionStats) finish() {
+	t := time.Now()
+	statsLock.Lock()
+	s.ended.Store(&t)
+	addToMetrics(&totalsFinished, s.snapshot(true))
+	statsLock.Unlock()
+}
+
+func varIntLen(v int32) int64 {
+	n, _ := pk.VarInt(v).WriteTo(io.Discard)
+	return n
+}
+
+func (s *sessionStats) countIn(p pk.Packet) {
+	s.payloadIn.Add(int64(len(p.Data)) + varIntLen(p.ID))
+	s.packetsLock.Lock()
+	s.packetsIn[p.ID]++
+	s.packetsLock.Unlock()
+}
+
+func (s *sessionStats) countOut(p pk.Packet) {
+	s.payloadOut.Add(int64(len(p.Data)) + varIntLen(p.ID))
+	s.packetsLock.Lock()
+	s.packetsOut[p.ID]++
+	s.packetsLock.Unlock()
+}
+
+func (s *sessionStats) snapshot(withPackets bool) SessionStats {
+	ret := SessionStats{
+		ID:              s.id,
+		Username:        s.username,
+		Server:          s.server,
+		Started:         s.started,
+		Ended:           s.ended.Load(),
+		ClientWireIn:    s.clientWireIn.Load(),
+		ClientWireOut:   s.clientWireOut.Load(),
+		PayloadIn:       s.payloadIn.Load(),
+		PayloadOut:      s.payloadOut.Load(),
+		ChunksReceived:  s.chunksReceived.Load(),
+		ChunksForwarded: s.chunksForwarded.Load(),
+		BlockUpdates:    s.blockUpdates.Load(),
+	}
+	if ret.ClientWireOut > 0 {
+		ret.CompressionRatio = float64(ret.PayloadIn) / float64(ret.ClientWireOut)
+	}
+	if withPackets {
+		ret.PacketsIn = map[string]int64{}
+		ret.PacketsOut = map[string]int64{}
+		s.packetsLock.Lock()
+		for id, c := range s.packetsIn {
+			ret.PacketsIn[packetid.ClientboundPacketID(id).String()] = c
+		}
+		for id, c := range s.packetsOut {
+			ret.PacketsOut[packetid.ServerboundPacketID(id).String()] = c
+		}
+		s.packetsLock.Unlock()
+	}
+	return ret
+}
+
+// ListSessionStats returns summaries of live and retained finished sessions, newest first
+func ListSessionStats() []SessionStats {
+	statsLock.Lock()
+	defer statsLock.Unlock()
+	ret := make([]SessionStats, 0, len(statsSessions))
+	for _, s := range statsSessions {
+		ret = append(ret, s.snapshot(false))
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].ID > ret[j].ID
+	})
+	return ret
+}
+
+// GetSessionStats returns full statistics of the session including packet counts by type
+func GetSessionStats(id int64) (SessionStats, bool) {
+	statsLock.Lock()
+	defer statsLock.Unlock()
+	for _, s := range statsSessions {
+		if s.id == id {
+			return s.snapshot(true), true
+		}
+	}
+	return SessionStats{}, false
+}
+
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,253 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package proxy
+
+import (
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/maxsupermanhd/go-vmc/v764/chat"
+	"github.com/maxsupermanhd/go-vmc/v764/data/packetid"
+	"github.com/maxsupermanhd/go-vmc/v764/level"
+	"github.com/maxsupermanhd/go-vmc/v764/net"
+	pk "github.com/maxsupermanhd/go-vmc/v764/net/packet"
+	"github.com/maxsupermanhd/go-vmc/v764/net/queue"
+)
+
+// packets that only make sense for the proxied player itself,
+// watchers fly on their own so they do not get them
+var relaySkipPackets = map[packetid.ClientboundPacketID]bool{
+	packetid.ClientboundPlayerPosition:          true,
+	packetid.ClientboundPlayerAbilities:         true,
+	packetid.ClientboundPlayerLookAt:            true,
+	packetid.ClientboundSetHealth:               true,
+	packetid.ClientboundSetExperience:           true,
+	packetid.ClientboundSetCarriedItem:          true,
+	packetid.ClientboundSetCamera:               true,
+	packetid.ClientboundPlayerCombatKill:        true,
+	packetid.ClientboundContainerClose:          true,
+	packetid.ClientboundContainerSetContent:     true,
+	packetid.ClientboundContainerSetData:        true,
+	packetid.ClientboundContainerSetSlot:        true,
+	packetid.ClientboundCooldown:                true,
+	packetid.ClientboundHorseScreenOpen:         true,
+	packetid.ClientboundMerchantOffers:          true,
+	packetid.ClientboundOpenBook:                true,
+	packetid.ClientboundOpenScreen:              true,
+	packetid.ClientboundOpenSignEditor:          true,
+	packetid.ClientboundPlaceGhostRecipe:        true,
+	packetid.ClientboundRecipe:                  true,
+	packetid.ClientboundDisconnect:              true,
+	packetid.ClientboundStartConfiguration:      true,
+	packetid.ClientboundSetDefaultSpawnPosition: true,
+}
+
+const relayWatcherQueue = 4096
+
+type relayWatcher struct {
+	name  string
+	queue queue.Queue[pk.Packet]
+}
+
+// what a watcher needs to catch up with the session: last login,
+// respawn after it and chunks that are currently loaded
+type sessionRelay struct {
+	state    *sessionState
+	lock     sync.Mutex
+	login    *pk.Packet
+	respawn  *pk.Packet
+	chunks   map[level.ChunkPos]pk.Packet
+	watchers map[*relayWatcher]bool
+}
+
+var (
+	relays     = map[string]*sessionRelay{}
+	relaysLock sync.Mutex
+)
+
+func openRelay(player string, state *sessionState) *sessionRelay {
+	r := &sessionRelay{
+		state:    state,
+		chunks:   map[level.ChunkPos]pk.Packet{},
+		watchers: map[*relayWatcher]bool{},
+	}
+	relaysLock.Lock()
+	relays[strings.ToLower(player)] = r
+	relaysLock.Unlock()
+	return r
+}
+
+func (r *sessionRelay) close(player string) {
+	relaysLock.Lock()
+	if relays[strings.ToLower(player)] == r {
+		delete(relays, strings.ToLower(player))
+	}
+	relaysLock.Unlock()
+	r.lock.Lock()
+	for w := range r.watchers {
+		delete(r.watchers, w)
+		w.queue.Close()
+	}
+	r.lock.Unlock()
+}
+
+func findRelay(player string) *sessionRelay {
+	relaysLock.Lock()
+	defer relaysLock.Unlock()
+	return relays[strings.ToLower(player)]
+}
+
+// packets that set the gamemode reset whatever it was before
+func spectatorGamemode() pk.Packet {
+	return pk.Marshal(packetid.ClientboundGameEvent, pk.UnsignedByte(3), pk.Float(3))
+}
+
+// called for every packet upstream sends to the proxied player
+func (r *sessionRelay) feed(p pk.Packet) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	id := packetid.ClientboundPacketID(p.ID)
+	switch id {
+	case packetid.ClientboundLogin:
+		r.login, r.respawn = &p, nil
+		r.chunks = map[level.ChunkPos]pk.Packet{}
+	case packetid.ClientboundRespawn:
+		r.respawn = &p
+		r.chunks = map[level.ChunkPos]pk.Packet{}
+	case packetid.ClientboundLevelChunkWithLight:
+		var pos level.ChunkPos
+		if p.Scan(&pos) == nil {
+			r.chunks[pos] = p
+		}
+	case packetid.ClientboundForgetLevelChunk:
+		// chunk position is a single long with z in the high half
+		var x, z pk.Int
+		if p.Scan(&z, &x) == nil {
+			delete(r.chunks, level.ChunkPos{int32(x), int32(z)})
+		}
+	case packetid.ClientboundGameEvent:
+		if len(p.Data) > 0 && p.Data[0] == 3 {
+			return
+		}
+	}
+	if relaySkipPackets[id] {
+		return
+	}
+	r.push(p)
+	if id == packetid.ClientboundLogin || id == packetid.ClientboundRespawn {
+		r.push(spectatorGamemode())
+	}
+}
+
+// must be called with the lock held, watchers that can not keep up are dropped
+func (r *sessionRelay) push(p pk.Packet) {
+	for w := range r.watchers {
+		if !w.queue.Push(p) {
+			log.Printf("Spectator [%s] is too slow, dropping", w.name)
+			delete(r.watchers, w)
+			w.queue.Close()
+		}
+	}
+}
+
+// false if session did not get to the game yet
+func (r *sessionRelay) attach(w *relayWatcher) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.login == nil {
+		return false
+	}
+	initial := []pk.Packet{*r.login}
+	if r.respawn != nil {
+		initial = append(initial, *r.respawn)
+	}
+	initial = append(initial, spectatorGamemode())
+	for _, c := range r.chunks {
+		initial = append(initial, c)
+	}
+	if x, y, z, ok := r.state.getPosition(); ok {
+		initial = append(initial, pk.Marshal(
+			packetid.ClientboundPlayerPosition,
+			pk.Double(x), pk.Double(y), pk.Double(z),
+			pk.Float(0), pk.Float(0),
+			pk.Byte(0),
+			pk.VarInt(0),
+		))
+	}
+	w.queue = queue.NewChannelQueue[pk.Packet](len(initial) + relayWatcherQueue)
+	for _, p := range initial {
+		w.queue.Push(p)
+	}
+	r.watchers[w] = true
+	return true
+}
+
+func (r *sessionRelay) detach(w *relayWatcher) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.watchers[w] {
+		delete(r.watchers, w)
+		w.queue.Close()
+	}
+}
+
+// spectators are configured as viewer name to proxied player name
+func (p SnifferProxy) spectateTarget(name string) string {
+	spectators := map[string]string{}
+	if err := p.Conf.GetToStruct(&spectators, "spectators"); err != nil {
+		return ""
+	}
+	for viewer, target := range spectators {
+		if strings.EqualFold(viewer, name) {
+			return target
+		}
+	}
+	return ""
+}
+
+// watcher gets everything the proxied player gets, nothing it sends goes anywhere
+func acceptSpectator(name, target string, conn *net.Conn) {
+	r := findRelay(target)
+	w := &relayWatcher{name: name}
+	if r == nil || !r.attach(w) {
+		dissconnectWithMessage(conn, &chat.Message{Text: "Player " + target + " is not playing through the proxy right now"})
+		return
+	}
+	defer r.detach(w)
+	log.Printf("Spectator [%s] is watching [%s]", name, target)
+	go func() {
+		var p pk.Packet
+		for conn.ReadPacket(&p) == nil {
+		}
+		r.detach(w)
+	}()
+	for {
+		p, ok := w.queue.Pull()
+		if !ok {
+			break
+		}
+		if err := conn.WritePacket(p); err != nil {
+			break
+		}
+	}
+	log.Printf("Spectator [%s] stopped watching [%s]", name, target)
+}
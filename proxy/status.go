@@ -0,0 +1,156 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package proxy
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/maxsupermanhd/go-vmc/v764/bot"
+	"github.com/maxsupermanhd/go-vmc/v764/chat"
+	"github.com/maxsupermanhd/go-vmc/v764/server"
+	"github.com/maxsupermanhd/lac"
+)
+
+// upstream status as it came in server list ping response
+type upstreamStatus struct {
+	Players struct {
+		Max    int                   `json:"max"`
+		Online int                   `json:"online"`
+		Sample []server.PlayerSample `json:"sample"`
+	} `json:"players"`
+	Description chat.Message `json:"description"`
+	FavIcon     string       `json:"favicon"`
+}
+
+// statusHandler answers server list pings with configured values ("local" mode)
+// or with what upstream answers ("passthrough" mode), version is always the one
+// proxy speaks because it is what clients will have to connect with
+type statusHandler struct {
+	cfg   *lac.ConfSubtree
+	local *server.PingInfo
+	max   int
+	// upstream of the listener, routes are used if empty
+	server string
+
+	lock     sync.Mutex
+	upstream *upstreamStatus
+	fetched  time.Time
+}
+
+func (h *statusHandler) Name() string {
+	return h.local.Name()
+}
+
+func (h *statusHandler) Protocol(clientProtocol int32) int {
+	return h.local.Protocol(clientProtocol)
+}
+
+// passthrough server is the configured one or the only one routes lead to
+func (h *statusHandler) upstreamAddr() string {
+	if h.server != "" {
+		return h.server
+	}
+	if addr := h.cfg.GetDSString("", "status", "server"); addr != "" {
+		return addr
+	}
+	routes := map[string]string{}
+	if err := h.cfg.GetToStruct(&routes, "routes"); err != nil {
+		return ""
+	}
+	ret := ""
+	for _, addr := range routes {
+		if ret != "" && addr != ret {
+			return ""
+		}
+		ret = addr
+	}
+	return ret
+}
+
+// listResp asks for every field separately, upstream is asked once
+// and reused for status.cache milliseconds so one answer is consistent
+func (h *statusHandler) current() *upstreamStatus {
+	if h.cfg.GetDSString("local", "status", "mode") != "passthrough" {
+		return nil
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if time.Since(h.fetched) < time.Duration(h.cfg.GetDSInt(5000, "status", "cache"))*time.Millisecond {
+		return h.upstream
+	}
+	h.fetched = time.Now()
+	h.upstream = nil
+	addr := h.upstreamAddr()
+	if addr == "" {
+		log.Println("Status passthrough has no server to ask, set proxy.status.server")
+		return nil
+	}
+	resp, _, err := bot.PingAndListTimeout(addr, 5*time.Second)
+	if err != nil {
+		log.Printf("Failed to get status of [%s], answering with local one: %s", addr, err.Error())
+		return nil
+	}
+	var s upstreamStatus
+	if err := json.Unmarshal(resp, &s); err != nil {
+		log.Printf("Failed to parse status of [%s], answering with local one: %s", addr, err.Error())
+		return nil
+	}
+	h.upstream = &s
+	return h.upstream
+}
+
+func (h *statusHandler) MaxPlayer() int {
+	if s := h.current(); s != nil {
+		return s.Players.Max
+	}
+	return h.max
+}
+
+func (h *statusHandler) OnlinePlayer() int {
+	if s := h.current(); s != nil {
+		return s.Players.Online
+	}
+	return liveSessionsCount()
+}
+
+func (h *statusHandler) PlayerSamples() []server.PlayerSample {
+	if s := h.current(); s != nil {
+		return s.Players.Sample
+	}
+	return []server.PlayerSample{}
+}
+
+func (h *statusHandler) Description() *chat.Message {
+	if s := h.current(); s != nil {
+		return &s.Description
+	}
+	return h.local.Description()
+}
+
+func (h *statusHandler) FavIcon() string {
+	if s := h.current(); s != nil && s.FavIcon != "" {
+		return s.FavIcon
+	}
+	return h.local.FavIcon()
+}
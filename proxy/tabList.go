@@ -0,0 +1,124 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package proxy
+
+import (
+	"bytes"
+
+	"github.com/google/uuid"
+	"github.com/maxsupermanhd/go-vmc/v764/chat"
+	"github.com/maxsupermanhd/go-vmc/v764/chat/sign"
+	pk "github.com/maxsupermanhd/go-vmc/v764/net/packet"
+	"github.com/maxsupermanhd/go-vmc/v764/yggdrasil/user"
+)
+
+// applies player info update to the tab list and returns changed entries,
+// actions are add player, initialize chat, gamemode, listed, latency and display name
+func readPlayerInfoUpdate(p pk.Packet, tab map[uuid.UUID]*TabListPlayer) ([]TabListPlayer, error) {
+	r := bytes.NewReader(p.Data)
+	actions := pk.NewFixedBitSet(6)
+	if _, err := actions.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	var count pk.VarInt
+	if _, err := count.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	ret := []TabListPlayer{}
+	for i := 0; i < int(count); i++ {
+		var id pk.UUID
+		if _, err := id.ReadFrom(r); err != nil {
+			return ret, err
+		}
+		t, ok := tab[uuid.UUID(id)]
+		if !ok {
+			t = &TabListPlayer{UUID: uuid.UUID(id)}
+			tab[t.UUID] = t
+		}
+		if actions.Get(0) {
+			var (
+				name  pk.String
+				props []user.Property
+			)
+			if _, err := (pk.Tuple{&name, pk.Array(&props)}).ReadFrom(r); err != nil {
+				return ret, err
+			}
+			t.Name = string(name)
+			for _, prop := range props {
+				if prop.Name == "textures" {
+					t.Skin = prop.Value
+				}
+			}
+		}
+		if actions.Get(1) {
+			var session pk.Option[sign.Session, *sign.Session]
+			if _, err := session.ReadFrom(r); err != nil {
+				return ret, err
+			}
+		}
+		if actions.Get(2) {
+			var gamemode pk.VarInt
+			if _, err := gamemode.ReadFrom(r); err != nil {
+				return ret, err
+			}
+			t.Gamemode = int32(gamemode)
+		}
+		if actions.Get(3) {
+			var listed pk.Boolean
+			if _, err := listed.ReadFrom(r); err != nil {
+				return ret, err
+			}
+			t.Listed = bool(listed)
+		}
+		if actions.Get(4) {
+			var latency pk.VarInt
+			if _, err := latency.ReadFrom(r); err != nil {
+				return ret, err
+			}
+			t.Latency = int32(latency)
+		}
+		if actions.Get(5) {
+			var displayName pk.Option[chat.Message, *chat.Message]
+			if _, err := displayName.ReadFrom(r); err != nil {
+				return ret, err
+			}
+			t.DisplayName = ""
+			if displayName.Has {
+				t.DisplayName = displayName.Val.ClearString()
+			}
+		}
+		ret = append(ret, *t)
+	}
+	return ret, nil
+}
+
+func readPlayerInfoRemove(p pk.Packet, tab map[uuid.UUID]*TabListPlayer) ([]uuid.UUID, error) {
+	var ids []pk.UUID
+	if err := p.Scan(pk.Array(&ids)); err != nil {
+		return nil, err
+	}
+	ret := make([]uuid.UUID, len(ids))
+	for i := range ids {
+		ret[i] = uuid.UUID(ids[i])
+		delete(tab, ret[i])
+	}
+	return ret, nil
+}
@@ -0,0 +1,76 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"github.com/maxsupermanhd/WebChunk/proxy"
+)
+
+func proxyEventConsumer(exitchan <-chan struct{}) {
+	for {
+		select {
+		case <-exitchan:
+			return
+		case e := <-proxyEventChannel:
+			switch d := e.Data.(type) {
+			case proxy.EventPlayerJoin:
+				playerTrackerJoin(e)
+			case proxy.EventPlayerLeave:
+				playerTrackerLeave(e)
+				entitiesForgetPlayer(e)
+				trailsForgetPlayer(e)
+			case proxy.EventPlayerPosition:
+				playerTrackerUpdate(e, d)
+				trailPositionReceived(e, d)
+			case proxy.EventEntitySpawn:
+				entitySpawned(e, d)
+			case proxy.EventEntityRemove:
+				entitiesRemoved(e, d)
+			case proxy.EventContainerContents:
+				containerSampled(e, d)
+			case proxy.EventBlockEntity:
+				captureSign(e.Server, e.Dimension, e.Username, d.X, d.Y, d.Z, d.Type, d.Data)
+			case proxy.EventMapData:
+				mapDataReceived(e, d)
+			case proxy.EventChat:
+				chatReceived(e, d)
+			case proxy.EventMarker:
+				markerReceived(e, d)
+			case proxy.EventPlayerDeath:
+				deathReceived(e, d)
+			case proxy.EventTabListUpdate:
+				playerTrackerTabUpdate(e, d)
+			case proxy.EventTabListRemove:
+				playerTrackerTabRemove(e, d)
+			case proxy.EventObjective:
+				objectiveReceived(e, d)
+			case proxy.EventScore:
+				scoreReceived(e, d)
+			case proxy.EventBossBar:
+				bossBarReceived(e, d)
+			case proxy.EventWorldTime:
+				worldTimeReceived(e, d)
+			case proxy.EventWeather:
+				weatherReceived(e, d)
+			}
+		}
+	}
+}
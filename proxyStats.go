@@ -0,0 +1,192 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/maxsupermanhd/WebChunk/proxy"
+)
+
+func apiListProxySessions(w http.ResponseWriter, r *http.Request) (int, string) {
+	ret := proxy.ListSessionStats()
+	names := playerNamerFor(r)
+	for i := range ret {
+		ret[i].Username = names(ret[i].Username)
+	}
+	setContentTypeJson(w)
+	return marshalOrFail(200, ret)
+}
+
+func apiGetProxySession(w http.ResponseWriter, r *http.Request) (int, string) {
+	id, err := strconv.ParseInt(mux.Vars(r)["session"], 10, 64)
+	if err != nil {
+		return 400, "Bad session id: " + err.Error()
+	}
+	stats, ok := proxy.GetSessionStats(id)
+	if !ok {
+		return 404, "Session not found"
+	}
+	stats.Username = playerNamerFor(r)(stats.Username)
+	setContentTypeJson(w)
+	return marshalOrFail(200, stats)
+}
+
+// listener is listen address of the proxy listener, global list is used without it
+func proxyACLListener(r *http.Request) (string, bool) {
+	l := r.FormValue("listener")
+	return l, l == "" || proxy.HasListener(cfg.SubTree("proxy"), l)
+}
+
+// access list is what keeps proxy closed so it is guarded same as accounts
+func apiGetProxyACL(w http.ResponseWriter, r *http.Request) (int, string) {
+	if !accountsAllowed(r) {
+		return http.StatusForbidden, "Reveal token required"
+	}
+	listener, ok := proxyACLListener(r)
+	if !ok {
+		return 404, "Listener not found"
+	}
+	a, err := proxy.GetAccessList(cfg.SubTree("proxy"), listener)
+	if err != nil {
+		return 500, "Failed to read access list: " + err.Error()
+	}
+	setContentTypeJson(w)
+	return marshalOrFail(200, a)
+}
+
+// takes either player to add or enabled to toggle checks
+func apiUpdateProxyACL(w http.ResponseWriter, r *http.Request) (int, string) {
+	if !accountsAllowed(r) {
+		return http.StatusForbidden, "Reveal token required"
+	}
+	listener, ok := proxyACLListener(r)
+	if !ok {
+		return 404, "Listener not found"
+	}
+	var a proxy.AccessList
+	var err error
+	switch {
+	case r.FormValue("player") != "":
+		player := strings.TrimSpace(r.FormValue("player"))
+		if player == "" || strings.ContainsAny(player, " /") {
+			return 400, "Bad player name or UUID"
+		}
+		a, _, err = proxy.AddToAccessList(cfg.SubTree("proxy"), listener, player)
+	case r.FormValue("enabled") != "":
+		enabled, perr := strconv.ParseBool(r.FormValue("enabled"))
+		if perr != nil {
+			return 400, "Bad enabled value: " + perr.Error()
+		}
+		a, err = proxy.SetAccessListEnabled(cfg.SubTree("proxy"), listener, enabled)
+	default:
+		return 400, "Either player or enabled must be set"
+	}
+	if err != nil {
+		return 500, "Failed to update access list: " + err.Error()
+	}
+	if err := saveConfig(); err != nil {
+		return 500, "Failed to save config: " + err.Error()
+	}
+	setContentTypeJson(w)
+	return marshalOrFail(200, a)
+}
+
+func apiRemoveFromProxyACL(w http.ResponseWriter, r *http.Request) (int, string) {
+	if !accountsAllowed(r) {
+		return http.StatusForbidden, "Reveal token required"
+	}
+	listener, ok := proxyACLListener(r)
+	if !ok {
+		return 404, "Listener not found"
+	}
+	a, found, err := proxy.RemoveFromAccessList(cfg.SubTree("proxy"), listener, mux.Vars(r)["player"])
+	if err != nil {
+		return 500, "Failed to update access list: " + err.Error()
+	}
+	if !found {
+		return 404, "Player is not on the access list"
+	}
+	if err := saveConfig(); err != nil {
+		return 500, "Failed to save config: " + err.Error()
+	}
+	setContentTypeJson(w)
+	return marshalOrFail(200, a)
+}
+
+// routes send proxied sessions, logged in with stored accounts, to servers
+// so they are guarded same as accounts
+func apiListProxyRoutes(w http.ResponseWriter, r *http.Request) (int, string) {
+	if !accountsAllowed(r) {
+		return http.StatusForbidden, "Reveal token required"
+	}
+	routes, err := proxy.GetRoutes(cfg.SubTree("proxy"))
+	if err != nil {
+		return 500, "Failed to read routes: " + err.Error()
+	}
+	setContentTypeJson(w)
+	return marshalOrFail(200, routes)
+}
+
+// creates or changes route of the player to address
+func apiSetProxyRoute(w http.ResponseWriter, r *http.Request) (int, string) {
+	if !accountsAllowed(r) {
+		return http.StatusForbidden, "Reveal token required"
+	}
+	player := mux.Vars(r)["player"]
+	address := strings.TrimSpace(r.FormValue("address"))
+	if address == "" || strings.ContainsAny(address, " /") {
+		return 400, "Bad server address"
+	}
+	routes, created, err := proxy.SetRoute(cfg.SubTree("proxy"), player, address)
+	if err != nil {
+		return 500, "Failed to update routes: " + err.Error()
+	}
+	if err := saveConfig(); err != nil {
+		return 500, "Failed to save config: " + err.Error()
+	}
+	setContentTypeJson(w)
+	if created {
+		return marshalOrFail(201, routes)
+	}
+	return marshalOrFail(200, routes)
+}
+
+func apiRemoveProxyRoute(w http.ResponseWriter, r *http.Request) (int, string) {
+	if !accountsAllowed(r) {
+		return http.StatusForbidden, "Reveal token required"
+	}
+	routes, found, err := proxy.RemoveRoute(cfg.SubTree("proxy"), mux.Vars(r)["player"])
+	if err != nil {
+		return 500, "Failed to update routes: " + err.Error()
+	}
+	if !found {
+		return 404, "Player has no route"
+	}
+	if err := saveConfig(); err != nil {
+		return 500, "Failed to save config: " + err.Error()
+	}
+	setContentTypeJson(w)
+	return marshalOrFail(200, routes)
+}
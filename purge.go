@@ -0,0 +1,318 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/maxsupermanhd/WebChunk/client"
+)
+
+// how records of each kind are purged: field holding the player name and
+// whether record is dropped or only has the name cleared, records that are
+// someone else's (chat messages seen by the player, signs and such) are kept,
+// chunk is set for kinds that store chunk coordinates instead of block ones
+var purgeKinds = []struct {
+	kind   string
+	fields []string
+	drop   bool
+	chunk  bool
+}{
+	{"trails", []string{"Player"}, true, false},
+	{"deaths", []string{"Player"}, true, false},
+	{"markers", []string{"Author"}, true, false},
+	{"discoveries", []string{"Player"}, true, true},
+	{"chat", []string{"Sender"}, true, false},
+	{"chat", []string{"Player"}, false, false},
+	{"signs", []string{"Player"}, false, false},
+	{"entities", []string{"Player"}, false, false},
+	{"containers", []string{"Player"}, false, false},
+	{"scoreboard", []string{"Entity"}, true, false},
+	{"scoreboard", []string{"Player"}, false, false},
+	{"worldtime", []string{"Player"}, false, false},
+}
+
+// block coordinates of affected records that have them
+type purgeBounds struct {
+	MinX, MinZ, MaxX, MaxZ int
+}
+
+func (b *purgeBounds) add(x, z int) *purgeBounds {
+	if b == nil {
+		return &purgeBounds{MinX: x, MinZ: z, MaxX: x, MaxZ: z}
+	}
+	b.MinX, b.MinZ = minInt(b.MinX, x), minInt(b.MinZ, z)
+	b.MaxX, b.MaxZ = maxInt(b.MaxX, x), maxInt(b.MaxZ, z)
+	return b
+}
+
+func (b *purgeBounds) merge(o *purgeBounds) *purgeBounds {
+	if o == nil {
+		return b
+	}
+	return b.add(o.MinX, o.MinZ).add(o.MaxX, o.MaxZ)
+}
+
+type purgeReportEntry struct {
+	World      string
+	Dimension  string `json:",omitempty"`
+	Kind       string
+	Removed    int
+	Anonymized int
+	Bytes      int
+	Bounds     *purgeBounds `json:",omitempty"`
+}
+
+// Bytes is how much smaller record logs get, with DryRun nothing is changed
+// and report tells what would happen
+type purgeReport struct {
+	Player     string
+	Time       time.Time
+	DryRun     bool `json:",omitempty"`
+	Entries    []purgeReportEntry
+	Removed    int
+	Anonymized int
+	Bytes      int
+	Bounds     *purgeBounds `json:",omitempty"`
+}
+
+type signedPurgeReport = client.SignedPurgeReport
+
+var (
+	purgeLock    sync.Mutex
+	purgeKeyLock sync.Mutex
+)
+
+// ed25519 seed is kept in its own file readable only by the owner and never
+// in config since /cfg and config backups would give it away, it is written
+// right when generated because recipients pin its public part
+func purgeSigningKey() (ed25519.PrivateKey, error) {
+	purgeKeyLock.Lock()
+	defer purgeKeyLock.Unlock()
+	path := cfg.GetDSString("./purge.key", "purge", "signing_key_path")
+	b, err := os.ReadFile(path)
+	if err == nil {
+		seed, err := hex.DecodeString(string(bytes.TrimSpace(b)))
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("bad purge signing key in %s", path)
+		}
+		return ed25519.NewKeyFromSeed(seed), nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	seed := make([]byte, ed25519.SeedSize)
+	if _, err := rand.Read(seed); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(seed)+"\n"), 0600); err != nil {
+		return nil, err
+	}
+	log.Printf("Generated purge signing key %s", path)
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+func purgeRecord(m json.RawMessage, player string, fields []string, drop bool) (json.RawMessage, error) {
+	var rec map[string]any
+	if json.Unmarshal(m, &rec) != nil {
+		return m, nil
+	}
+	found := false
+	for _, f := range fields {
+		if v, ok := rec[f].(string); ok && strings.EqualFold(v, player) {
+			found = true
+			delete(rec, f)
+		}
+	}
+	if !found {
+		return m, nil
+	}
+	if drop {
+		return nil, nil
+	}
+	return json.Marshal(rec)
+}
+
+func recordPosition(m json.RawMessage, chunk bool) (x, z int, ok bool) {
+	var pos struct {
+		X, Z *float64
+	}
+	if json.Unmarshal(m, &pos) != nil || pos.X == nil || pos.Z == nil {
+		return 0, 0, false
+	}
+	x, z = int(math.Floor(*pos.X)), int(math.Floor(*pos.Z))
+	if chunk {
+		x, z = x*16, z*16
+	}
+	return x, z, true
+}
+
+// dry run goes through the same records with the same rules, only reading them
+func purgePlayerData(player string, dryRun bool) (purgeReport, error) {
+	purgeLock.Lock()
+	defer purgeLock.Unlock()
+	report := purgeReport{Player: player, Time: time.Now(), DryRun: dryRun, Entries: []purgeReportEntry{}}
+	for _, k := range purgeKinds {
+		locs, err := recs.Locations(k.kind)
+		if err != nil {
+			return report, err
+		}
+		for _, l := range locs {
+			e := purgeReportEntry{World: l[0], Dimension: l[1], Kind: k.kind}
+			purge := func(m json.RawMessage) (json.RawMessage, error) {
+				r, err := purgeRecord(m, player, k.fields, k.drop)
+				if err != nil || bytes.Equal(r, m) {
+					return r, err
+				}
+				if r == nil {
+					e.Removed++
+				} else {
+					e.Anonymized++
+				}
+				e.Bytes += len(m) - len(r)
+				if x, z, ok := recordPosition(m, k.chunk); ok {
+					e.Bounds = e.Bounds.add(x, z)
+				}
+				return r, nil
+			}
+			if dryRun {
+				err = recs.Read(l[0], l[1], k.kind, func(m json.RawMessage) error {
+					_, err := purge(m)
+					return err
+				})
+			} else {
+				_, _, err = recs.Rewrite(l[0], l[1], k.kind, purge)
+			}
+			if err != nil {
+				return report, err
+			}
+			if e.Removed+e.Anonymized == 0 {
+				continue
+			}
+			report.Entries = append(report.Entries, e)
+			report.Removed += e.Removed
+			report.Anonymized += e.Anonymized
+			report.Bytes += e.Bytes
+			report.Bounds = report.Bounds.merge(e.Bounds)
+			if !dryRun {
+				purgeForgetIndexes(l[0], l[1])
+			}
+		}
+	}
+	return report, nil
+}
+
+// indexes are loaded again from rewritten logs when needed
+func purgeForgetIndexes(wname, dname string) {
+	discoveriesLock.Lock()
+	delete(discoveries, entityDensityKey{world: wname, dimension: dname})
+	discoveriesLock.Unlock()
+	signIndexLock.Lock()
+	delete(signIndex, signIndexKey{world: wname, dimension: dname})
+	signIndexLock.Unlock()
+	scoreboardsLock.Lock()
+	delete(scoreboards, wname)
+	scoreboardsLock.Unlock()
+	worldTimesLock.Lock()
+	delete(worldTimes, entityDensityKey{world: wname, dimension: dname})
+	delete(worldTimesSaved, entityDensityKey{world: wname, dimension: dname})
+	worldTimesLock.Unlock()
+}
+
+func signPurgeReport(key ed25519.PrivateKey, r purgeReport) (signedPurgeReport, error) {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return signedPurgeReport{}, err
+	}
+	return signedPurgeReport{
+		Report:    b,
+		PublicKey: hex.EncodeToString(key.Public().(ed25519.PublicKey)),
+		Signature: hex.EncodeToString(ed25519.Sign(key, b)),
+	}, nil
+}
+
+// report carries the public key too but only the one from here proves
+// who signed it, recipients have to pin it
+func apiPurgeKey(w http.ResponseWriter, _ *http.Request) (int, string) {
+	key, err := purgeSigningKey()
+	if err != nil {
+		return 500, "Failed to load signing key: " + err.Error()
+	}
+	setContentTypeJson(w)
+	return marshalOrFail(200, map[string]string{
+		"PublicKey": hex.EncodeToString(key.Public().(ed25519.PublicKey)),
+	})
+}
+
+// removes everything stored about the player and answers with signed report,
+// it can not be undone so it needs reveal token to be configured and given,
+// dry_run only reports what would be removed
+func apiPurgePlayer(w http.ResponseWriter, r *http.Request) (int, string) {
+	token := cfg.GetDSString("", "privacy", "reveal_token")
+	dryRun := isDryRun(r)
+	if !dryRun && token == "" {
+		return http.StatusForbidden, "Purge requires privacy.reveal_token to be configured"
+	}
+	if token != "" && !privacyCanReveal(r) {
+		return http.StatusForbidden, "Reveal token required"
+	}
+	// key is loaded first so data is not removed without a report to show for it
+	var key ed25519.PrivateKey
+	if !dryRun {
+		var err error
+		if key, err = purgeSigningKey(); err != nil {
+			return 500, "Failed to load signing key: " + err.Error()
+		}
+	}
+	player := mux.Vars(r)["player"]
+	report, err := purgePlayerData(player, dryRun)
+	if err != nil {
+		if dryRun {
+			return 500, "Purge dry run failed: " + err.Error()
+		}
+		return 500, "Purge failed, part of data may be already removed: " + err.Error()
+	}
+	if dryRun {
+		setContentTypeJson(w)
+		return marshalOrFail(200, report)
+	}
+	log.Printf("Purged data of a player [%s]: %d records removed, %d anonymized", requestID(r), report.Removed, report.Anonymized)
+	signed, err := signPurgeReport(key, report)
+	if err != nil {
+		return 500, err.Error()
+	}
+	setContentTypeJson(w)
+	return marshalOrFail(200, signed)
+}
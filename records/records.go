@@ -0,0 +1,226 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+// Package records keeps append-only logs of things captured alongside chunks
+// (entities, chat, signs and such) as JSON lines grouped by world and dimension
+package records
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+var ErrBadName = errors.New("bad record location name")
+
+type Store struct {
+	root  string
+	lock  sync.Mutex
+	files map[string]*os.File
+}
+
+func NewStore(root string) *Store {
+	return &Store{
+		root:  root,
+		files: map[string]*os.File{},
+	}
+}
+
+func validName(n string) bool {
+	return n != "." && n != ".." && !strings.ContainsAny(n, "/\\\x00")
+}
+
+// empty dimension means record belongs to the whole world
+func (s *Store) filePath(world, dimension, kind string) (string, error) {
+	if !validName(world) || world == "" || !validName(kind) || kind == "" || !validName(dimension) {
+		return "", ErrBadName
+	}
+	return path.Join(s.root, world, dimension, kind+".jsonl"), nil
+}
+
+func (s *Store) Append(world, dimension, kind string, v any) error {
+	p, err := s.filePath(world, dimension, kind)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	f, ok := s.files[p]
+	if !ok {
+		err = os.MkdirAll(path.Dir(p), 0764)
+		if err != nil {
+			return err
+		}
+		f, err = os.OpenFile(p, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0664)
+		if err != nil {
+			return err
+		}
+		s.files[p] = f
+	}
+	_, err = f.Write(append(b, '\n'))
+	return err
+}
+
+// Read calls f for each stored record in order they were appended,
+// message is only valid until f returns, missing log just has no records
+func (s *Store) Read(world, dimension, kind string, f func(json.RawMessage) error) error {
+	p, err := s.filePath(world, dimension, kind)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		err = f(json.RawMessage(scanner.Bytes()))
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// Rewrite replaces every record of the log with what f returns for it, nil drops the record.
+// Appends wait until it is done, returns number of dropped and changed records
+func (s *Store) Rewrite(world, dimension, kind string, f func(json.RawMessage) (json.RawMessage, error)) (dropped, changed int, err error) {
+	p, err := s.filePath(world, dimension, kind)
+	if err != nil {
+		return 0, 0, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if af, ok := s.files[p]; ok {
+		af.Close()
+		delete(s.files, p)
+	}
+	in, err := os.Open(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	defer in.Close()
+	out, err := os.CreateTemp(path.Dir(p), kind+".*.tmp")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer os.Remove(out.Name())
+	w := bufio.NewWriter(out)
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		r, err := f(json.RawMessage(scanner.Bytes()))
+		if err != nil {
+			out.Close()
+			return 0, 0, err
+		}
+		if r == nil {
+			dropped++
+			continue
+		}
+		if !bytes.Equal(r, scanner.Bytes()) {
+			changed++
+		}
+		w.Write(r)
+		w.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		out.Close()
+		return 0, 0, err
+	}
+	if err := w.Flush(); err != nil {
+		out.Close()
+		return 0, 0, err
+	}
+	if err := out.Close(); err != nil {
+		return 0, 0, err
+	}
+	return dropped, changed, os.Rename(out.Name(), p)
+}
+
+// Locations lists world and dimension pairs that have log of the kind,
+// world-wide logs have empty dimension
+func (s *Store) Locations(kind string) ([][2]string, error) {
+	ret := [][2]string{}
+	worlds, err := os.ReadDir(s.root)
+	if errors.Is(err, os.ErrNotExist) {
+		return ret, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range worlds {
+		if !w.IsDir() {
+			continue
+		}
+		if _, err := os.Stat(path.Join(s.root, w.Name(), kind+".jsonl")); err == nil {
+			ret = append(ret, [2]string{w.Name(), ""})
+		}
+		dims, err := os.ReadDir(path.Join(s.root, w.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range dims {
+			if !d.IsDir() {
+				continue
+			}
+			if _, err := os.Stat(path.Join(s.root, w.Name(), d.Name(), kind+".jsonl")); err == nil {
+				ret = append(ret, [2]string{w.Name(), d.Name()})
+			}
+		}
+	}
+	return ret, nil
+}
+
+func (s *Store) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var ret error
+	for p, f := range s.files {
+		if err := f.Close(); err != nil {
+			ret = err
+		}
+		delete(s.files, p)
+	}
+	return ret
+}
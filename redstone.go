@@ -0,0 +1,99 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"time"
+
+	"github.com/maxsupermanhd/go-vmc/v764/save"
+)
+
+// components are grouped so map does not turn into confetti
+var redstoneComponents = map[string]string{
+	"redstone_wire":       "dust",
+	"redstone_torch":      "dust",
+	"redstone_wall_torch": "dust",
+	"redstone_block":      "dust",
+	"repeater":            "logic",
+	"comparator":          "logic",
+	"observer":            "logic",
+	"target":              "logic",
+	"daylight_detector":   "logic",
+	"lever":               "logic",
+	"piston":              "piston",
+	"sticky_piston":       "piston",
+	"piston_head":         "piston",
+	"moving_piston":       "piston",
+	"hopper":              "transport",
+	"dropper":             "transport",
+	"dispenser":           "transport",
+	"crafter":             "transport",
+}
+
+var redstoneColors = map[string]color.RGBA{
+	"dust":      {255, 0, 0, 255},
+	"logic":     {255, 160, 0, 255},
+	"piston":    {60, 200, 60, 255},
+	"transport": {80, 80, 255, 255},
+}
+
+func redstoneComponent(b save.BlockState) (string, bool) {
+	id, ok := strings.CutPrefix(b.Name, "minecraft:")
+	if !ok {
+		id = b.Name
+	}
+	_, ok = redstoneComponents[id]
+	return id, ok
+}
+
+// count of every component found in the chunk by block id without prefix
+func countRedstone(chunk *save.Chunk) map[string]int {
+	ret := map[string]int{}
+	scanChunkBlocks(chunk, redstoneComponent, func(_, _, _ int, id string) {
+		ret[id]++
+	})
+	return ret
+}
+
+// topmost component of every column colored by its group
+func drawChunkRedstone(chunk *save.Chunk) (img *image.RGBA) {
+	t := time.Now()
+	img = image.NewRGBA(image.Rect(0, 0, 16, 16))
+	var top [16 * 16]int
+	var found [16 * 16]string
+	scanChunkBlocks(chunk, redstoneComponent, func(x, y, z int, id string) {
+		i := (z-int(chunk.ZPos)*16)*16 + x - int(chunk.XPos)*16
+		if found[i] == "" || y > top[i] {
+			top[i] = y
+			found[i] = id
+		}
+	})
+	for i, id := range found {
+		if id != "" {
+			img.SetRGBA(i%16, i/16, redstoneColors[redstoneComponents[id]])
+		}
+	}
+	appendMetrics(time.Since(t), "redstone")
+	return img
+}
@@ -0,0 +1,96 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// keeps answer under a megabyte or so
+const regionDensityMaxCells = 256 * 256
+
+// Counts and Coverage go row by row from X0:Z0, coverage is part of 1024 chunks
+// of the region that are stored, Ranked has [x, z, count] of non-empty regions densest first
+type regionDensity struct {
+	X0, Z0        int
+	Width, Height int
+	Counts        []int
+	Coverage      []float64
+	Total         int
+	Ranked        [][3]int `json:",omitempty"`
+}
+
+func getRegionDensity(wname, dname string, rx0, rz0, rx1, rz1 int, ranked bool) (*regionDensity, error) {
+	_, s, err := storages.World(wname)
+	if err != nil {
+		return nil, err
+	}
+	ret := &regionDensity{X0: rx0, Z0: rz0, Width: rx1 - rx0, Height: rz1 - rz0}
+	ret.Counts = make([]int, ret.Width*ret.Height)
+	ret.Coverage = make([]float64, ret.Width*ret.Height)
+	if s == nil {
+		return ret, nil
+	}
+	regions, err := s.GetRegionsChunksCount(wname, dname, rx0, rz0, rx1, rz1)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range regions {
+		c, ok := r.Data.(int)
+		if !ok || r.X < rx0 || r.X >= rx1 || r.Z < rz0 || r.Z >= rz1 {
+			continue
+		}
+		i := (r.Z-rz0)*ret.Width + r.X - rx0
+		ret.Counts[i] = c
+		ret.Coverage[i] = math.Round(float64(c)/1024*1000) / 1000
+		ret.Total += c
+		if ranked && c > 0 {
+			ret.Ranked = append(ret.Ranked, [3]int{r.X, r.Z, c})
+		}
+	}
+	sort.SliceStable(ret.Ranked, func(i, j int) bool { return ret.Ranked[i][2] > ret.Ranked[j][2] })
+	return ret, nil
+}
+
+// bbox is in region coordinates with exclusive upper bound
+func apiRegionDensity(w http.ResponseWriter, r *http.Request) (int, string) {
+	b, err := parseFormInts(r, "rx0", "rz0", "rx1", "rz1")
+	if err != nil {
+		return 400, err.Error()
+	}
+	rx0, rz0, rx1, rz1 := minInt(b[0], b[2]), minInt(b[1], b[3]), maxInt(b[0], b[2]), maxInt(b[1], b[3])
+	if (rx1-rx0)*(rz1-rz0) > regionDensityMaxCells {
+		return 400, "Area is too big, at most " + strconv.Itoa(regionDensityMaxCells) + " regions per request"
+	}
+	ranked, _ := strconv.ParseBool(r.FormValue("ranked"))
+	params := mux.Vars(r)
+	d, err := getRegionDensity(params["world"], params["dim"], rx0, rz0, rx1, rz1, ranked)
+	if err != nil {
+		return 500, err.Error()
+	}
+	setContentTypeJson(w)
+	return marshalOrFail(200, d)
+}
@@ -0,0 +1,198 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/maxsupermanhd/WebChunk/backup"
+	"github.com/maxsupermanhd/WebChunk/chunkStorage"
+	"github.com/maxsupermanhd/go-vmc/v764/save/region"
+)
+
+// region files are read whole into memory one at a time,
+// nothing is written to local disk except the storage itself
+type memRegionFile struct {
+	*bytes.Reader
+}
+
+func (memRegionFile) Write([]byte) (int, error) {
+	return 0, errors.New("region is read-only")
+}
+
+func openImportSource(dir string) (backup.Target, error) {
+	if dir != "" {
+		return backup.OpenTarget(backup.TargetConfig{Type: "dir", Path: dir})
+	}
+	c := backup.TargetConfig{}
+	if err := cfg.GetToStruct(&c, "import", "source"); err != nil {
+		return nil, fmt.Errorf("reading import source: %w", err)
+	}
+	return backup.OpenTarget(c)
+}
+
+func importEnsureWorldDim(wname, dname, sname string) (chunkStorage.ChunkStorage, error) {
+	_, s, err := storages.World(wname)
+	if err != nil {
+		return nil, err
+	}
+	if s == nil {
+		st, ok := storages.Get(sname)
+		if !ok || st.Driver == nil {
+			return nil, fmt.Errorf("world %q does not exist and storage %q to create it in is not found", wname, sname)
+		}
+		s = st.Driver
+		err := s.AddWorld(chunkStorage.SWorld{
+			Name:       wname,
+			Alias:      wname,
+			CreatedAt:  time.Now(),
+			ModifiedAt: time.Now(),
+			Data:       chunkStorage.CreateDefaultLevelData(wname),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("creating world %q: %w", wname, err)
+		}
+	}
+	d, err := s.GetDimension(wname, dname)
+	if err != nil && !errors.Is(err, chunkStorage.ErrNoDim) {
+		return nil, err
+	}
+	if d == nil {
+		err := s.AddDimension(wname, chunkStorage.SDim{
+			Name:       dname,
+			World:      wname,
+			CreatedAt:  time.Now(),
+			ModifiedAt: time.Now(),
+			Data:       chunkStorage.GuessDimTypeFromName(dname),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("creating dimension %q of %q: %w", dname, wname, err)
+		}
+	}
+	return s, nil
+}
+
+func importRegion(s chunkStorage.ChunkStorage, src backup.Target, wname, dname, name string, rx, rz int) (int, error) {
+	r, err := src.Get(name)
+	if err != nil {
+		return 0, err
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return 0, err
+	}
+	if len(data) < 8192 {
+		return 0, nil // empty regions are sometimes left with no header
+	}
+	reg, err := region.Load(memRegionFile{bytes.NewReader(data)})
+	if err != nil {
+		return 0, err
+	}
+	imported := 0
+	for x := 0; x < 32; x++ {
+		for z := 0; z < 32; z++ {
+			if !reg.ExistSector(x, z) {
+				continue
+			}
+			d, err := reg.ReadSector(x, z)
+			if err != nil {
+				log.Printf("Failed to read chunk %d:%d of %s: %s", x, z, name, err.Error())
+				continue
+			}
+			// pre-1.13 chunks are stored converted, broken ones are stored as is
+			d, _, err = chunkStorage.UpgradeLegacyChunkRaw(d)
+			if err != nil {
+				log.Printf("Failed to check format of chunk %d:%d of %s: %s", x, z, name, err.Error())
+			}
+			if err := s.AddChunkRaw(wname, dname, rx*32+x, rz*32+z, d); err != nil {
+				return imported, err
+			}
+			imported++
+		}
+	}
+	return imported, nil
+}
+
+// webchunk import -world name -dim name [-path region] [-dir local/path] [-storage name] [-cubic]
+func importCommand(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	world := fs.String("world", "", "world to import into")
+	dim := fs.String("dim", "overworld", "dimension to import into")
+	rpath := fs.String("path", "region", "directory with region files inside of the source")
+	dir := fs.String("dir", "", "local directory to use as source instead of import.source")
+	storage := fs.String("storage", cfg.GetDSString("", "preferred_storage"), "storage to create missing world in")
+	cubic := fs.Bool("cubic", false, "source is a Cubic Chunks world, path defaults to region3d")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *world == "" {
+		return errors.New("world is not set")
+	}
+	src, err := openImportSource(*dir)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	lister, ok := src.(backup.Lister)
+	if !ok {
+		return errors.New("import source can not list directories")
+	}
+	s, err := importEnsureWorldDim(*world, *dim, *storage)
+	if err != nil {
+		return err
+	}
+	if *cubic {
+		if *rpath == "region" {
+			*rpath = "region3d"
+		}
+		total, err := importCubic(s, src, lister, *world, *dim, *rpath)
+		log.Printf("Imported %d chunks in total", total)
+		return err
+	}
+	names, err := lister.List(strings.Trim(*rpath, "/"))
+	if err != nil {
+		return fmt.Errorf("listing %q: %w", *rpath, err)
+	}
+	total := 0
+	for _, n := range names {
+		var rx, rz int
+		if _, err := fmt.Sscanf(n, "r.%d.%d.mca", &rx, &rz); err != nil || !strings.HasSuffix(n, ".mca") {
+			continue
+		}
+		c, err := importRegion(s, src, *world, *dim, path.Join(strings.Trim(*rpath, "/"), n), rx, rz)
+		total += c
+		if err != nil {
+			return fmt.Errorf("importing %s: %w", n, err)
+		}
+		log.Printf("Imported %d chunks from %s", c, n)
+	}
+	log.Printf("Imported %d chunks in total", total)
+	return nil
+}
@@ -20,14 +20,20 @@ type ChunkData interface {
 	GetWestNorth() *save.Chunk
 }
 
+// DataNeeds tells what has to be fetched along with the chunk,
+// neighbours are nil where there are no chunks
 type DataNeeds struct {
 	Dimension          bool
 	NeighborsBordering bool
 	NeighborsCorners   bool
 }
 
+// ChunkRenderer is a layer that draws every chunk on its own into 16x16 image,
+// Name is used in tile urls and must not clash with other layers
 type ChunkRenderer struct {
-	Name   string
-	Render func(ChunkData) *image.RGBA
+	Name        string
+	DisplayName string
+	Overlay     bool
+	Render      func(ChunkData) *image.RGBA
 	DataNeeds
 }
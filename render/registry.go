@@ -0,0 +1,74 @@
+package render
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"plugin"
+	"sort"
+	"sync"
+)
+
+var (
+	registryLock sync.Mutex
+	registry     = map[string]ChunkRenderer{}
+)
+
+// Register adds renderer to be served as a layer, meant to be called from
+// init of the package or plugin providing it
+func Register(r ChunkRenderer) error {
+	if r.Name == "" {
+		return errors.New("renderer has no name")
+	}
+	if r.Render == nil {
+		return fmt.Errorf("renderer %q has no render function", r.Name)
+	}
+	if r.DisplayName == "" {
+		r.DisplayName = r.Name
+	}
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if _, ok := registry[r.Name]; ok {
+		return fmt.Errorf("renderer %q is already registered", r.Name)
+	}
+	registry[r.Name] = r
+	return nil
+}
+
+// Registered lists all renderers sorted by name
+func Registered() []ChunkRenderer {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	ret := make([]ChunkRenderer, 0, len(registry))
+	for _, r := range registry {
+		ret = append(ret, r)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
+
+// LoadPlugins opens every .so Go plugin in the directory, plugins register
+// their renderers from init, missing directory is not an error
+func LoadPlugins(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	loaded := []string{}
+	var errs []error
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".so" {
+			continue
+		}
+		if _, err := plugin.Open(filepath.Join(dir, e.Name())); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", e.Name(), err))
+			continue
+		}
+		loaded = append(loaded, e.Name())
+	}
+	return loaded, errors.Join(errs...)
+}
@@ -0,0 +1,116 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"image"
+	"log"
+
+	"github.com/maxsupermanhd/WebChunk/chunkStorage"
+	"github.com/maxsupermanhd/WebChunk/render"
+	"github.com/maxsupermanhd/go-vmc/v764/save"
+)
+
+// what renderers from render package get, neighbours are only fetched
+// when renderer asked for any of them
+type rendererChunkData struct {
+	dname string
+	dim   *save.DimensionType
+	ContextedChunkData
+}
+
+func (c rendererChunkData) GetDimensionName() string          { return c.dname }
+func (c rendererChunkData) GetDimension() *save.DimensionType { return c.dim }
+func (c rendererChunkData) Get() *save.Chunk                  { return c.center }
+func (c rendererChunkData) GetNorth() *save.Chunk             { return c.top }
+func (c rendererChunkData) GetNorthEast() *save.Chunk         { return c.topRight }
+func (c rendererChunkData) GetEast() *save.Chunk              { return c.right }
+func (c rendererChunkData) GetEastSouth() *save.Chunk         { return c.bottomRight }
+func (c rendererChunkData) GetSouth() *save.Chunk             { return c.bottom }
+func (c rendererChunkData) GetSouthWest() *save.Chunk         { return c.bottomLeft }
+func (c rendererChunkData) GetWest() *save.Chunk              { return c.left }
+func (c rendererChunkData) GetWestNorth() *save.Chunk         { return c.topLeft }
+
+func rendererProvider(r render.ChunkRenderer) ttypeProviderFunc {
+	return func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
+		getter := s.GetChunksRegion
+		if r.NeighborsBordering || r.NeighborsCorners {
+			getter = getChunksRegionWithContextFN(s)
+		}
+		provider := func(wname, dname string, cx0, cz0, cx1, cz1 int) ([]chunkStorage.ChunkData, error) {
+			cc, err := getter(wname, dname, cx0, cz0, cx1, cz1)
+			if err != nil {
+				return cc, err
+			}
+			var dim *save.DimensionType
+			if r.Dimension {
+				dt := dimensionType(s, wname, dname)
+				dim = &dt
+			}
+			for i := range cc {
+				switch d := cc[i].Data.(type) {
+				case save.Chunk:
+					cc[i].Data = rendererChunkData{dname: dname, dim: dim, ContextedChunkData: ContextedChunkData{center: &d}}
+				case ContextedChunkData:
+					cc[i].Data = rendererChunkData{dname: dname, dim: dim, ContextedChunkData: d}
+				}
+			}
+			return cc, nil
+		}
+		return provider, func(i interface{}) *image.RGBA {
+			return r.Render(i.(rendererChunkData))
+		}
+	}
+}
+
+// loads renderer plugins and adds everything registered to layers,
+// names of built in layers can not be taken
+func registerRenderers() {
+	loaded, err := render.LoadPlugins(cfg.GetDSString("./renderers", "renderers_path"))
+	if err != nil {
+		log.Printf("Failed to load some renderer plugins: %s", err.Error())
+	}
+	for _, l := range loaded {
+		log.Printf("Loaded renderer plugin %s", l)
+	}
+	for _, r := range render.Registered() {
+		if layerNameTaken(r.Name) {
+			log.Printf("Renderer [%s] is not added, layer with that name already exists", r.Name)
+			continue
+		}
+		ttypes.Add(ttype{Name: r.Name, DisplayName: r.DisplayName, IsOverlay: r.Overlay}, rendererProvider(r))
+	}
+}
+
+func layerNameTaken(name string) bool {
+	if _, ok := tilePainters[name]; ok {
+		return true
+	}
+	if _, ok := paramLayers[name]; ok {
+		return true
+	}
+	for t := range ttypes.All() {
+		if t.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/maxsupermanhd/WebChunk/proxy"
+)
+
+// webchunk replay [-world name] dump...
+func replayCommand(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	world := fs.String("world", "", "store chunks in this world instead of the recorded server")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("no dump files given")
+	}
+	for _, f := range fs.Args() {
+		err := proxy.ReplayDump(ctx, cfg.SubTree("proxy"), f, *world, chunkChannel, proxyEventChannel)
+		if err != nil {
+			return fmt.Errorf("%s: %w", f, err)
+		}
+	}
+	// consumers finish current item on shutdown but leave the rest in channels
+	for len(chunkChannel) > 0 || len(proxyEventChannel) > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"context"
+	"image"
+	"image/draw"
+	"log"
+	"runtime/debug"
+
+	imagecache "github.com/maxsupermanhd/WebChunk/imageCache"
+	"github.com/maxsupermanhd/WebChunk/primitives"
+	xdraw "golang.org/x/image/draw"
+)
+
+// tiles this zoomed out are not rendered from every chunk
+func tileIsSampled(s int) bool {
+	minScale := cfg.GetDSInt(7, "sampling", "min_scale")
+	return minScale > 0 && s >= minScale && s > imagecache.StorageLevel
+}
+
+// renders huge tiles from cached storage level images where there are some
+// and from every Nth chunk elsewhere, nil if there was nothing to draw
+func renderSampledTile(ctx context.Context, loc primitives.ImageLocation, getter chunkDataProviderFunc, painter chunkPainterFunc) (*image.RGBA, error) {
+	// less than a pixel per region
+	if loc.S-imagecache.StorageLevel > 9 {
+		return nil, nil
+	}
+	img := image.NewRGBA(image.Rect(0, 0, 512, 512))
+	regions := 1 << (loc.S - imagecache.StorageLevel)
+	regionPx := 512 / regions
+	chunksPerRegion := 1 << imagecache.StorageLevel
+	// chunks between samples, each sample covers sample_size pixels
+	sampleSize := cfg.GetDSInt(16, "sampling", "sample_size")
+	stride := 1
+	for stride*regionPx < sampleSize*chunksPerRegion && stride < chunksPerRegion {
+		stride *= 2
+	}
+	samplePx := stride * regionPx / chunksPerRegion
+	useRegions := cfg.GetDSBool(true, "sampling", "use_regions")
+	downscale := layerDownscaler(loc.Variant)
+	drawn := false
+	for rz := 0; rz < regions; rz++ {
+		for rx := 0; rx < regions; rx++ {
+			if ctx.Err() != nil {
+				return img, nil
+			}
+			rloc := primitives.ImageLocation{
+				World:     loc.World,
+				Dimension: loc.Dimension,
+				Variant:   loc.Variant,
+				S:         imagecache.StorageLevel,
+				X:         loc.X*regions + rx,
+				Z:         loc.Z*regions + rz,
+			}
+			at := image.Rect(rx*regionPx, rz*regionPx, rx*regionPx+regionPx, rz*regionPx+regionPx)
+			if useRegions {
+				if cached := ic.GetCachedImageBlocking(rloc); cached != nil && cached.Img != nil {
+					xdraw.ApproxBiLinear.Scale(img, at, cached.Img, cached.Img.Bounds(), draw.Over, nil)
+					drawn = true
+					continue
+				}
+			}
+			if samplePx < 1 {
+				continue
+			}
+			for sz := 0; sz < chunksPerRegion; sz += stride {
+				for sx := 0; sx < chunksPerRegion; sx += stride {
+					cx, cz := rloc.X*chunksPerRegion+sx, rloc.Z*chunksPerRegion+sz
+					cc, err := getter(loc.World, loc.Dimension, cx, cz, cx+1, cz+1)
+					if err != nil {
+						return nil, err
+					}
+					for _, c := range cc {
+						chunk := paintSample(painter, c.Data)
+						if chunk == nil {
+							continue
+						}
+						px, pz := at.Min.X+sx*regionPx/chunksPerRegion, at.Min.Y+sz*regionPx/chunksPerRegion
+						downscale(img, image.Rect(px, pz, px+samplePx, pz+samplePx), chunk)
+						drawn = true
+					}
+				}
+			}
+		}
+	}
+	if !drawn {
+		return nil, nil
+	}
+	return img, nil
+}
+
+func paintSample(painter chunkPainterFunc, d interface{}) (ret *image.RGBA) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Println("Failed to paint sampled chunk:", err)
+			debug.PrintStack()
+			ret = nil
+		}
+	}()
+	return painter(d)
+}
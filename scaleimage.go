@@ -25,8 +25,8 @@ import (
 	"context"
 	_ "embed"
 	"errors"
+	"fmt"
 	"image"
-	"image/draw"
 	"image/jpeg"
 	"image/png"
 	"log"
@@ -35,35 +35,48 @@ import (
 	"sort"
 	"strconv"
 	"strings"
-	_ "sync"
+	"sync"
 
 	"github.com/gorilla/mux"
 	"github.com/maxsupermanhd/WebChunk/chunkStorage"
+	"github.com/maxsupermanhd/WebChunk/client"
+	imagecache "github.com/maxsupermanhd/WebChunk/imageCache"
+	"github.com/maxsupermanhd/WebChunk/lib/webp"
+	"github.com/maxsupermanhd/WebChunk/primitives"
 	"github.com/maxsupermanhd/go-vmc/v764/save"
-	"github.com/nfnt/resize"
 )
 
 type chunkDataProviderFunc = func(wname, dname string, cx0, cz0, cx1, cz1 int) ([]chunkStorage.ChunkData, error)
 type chunkPainterFunc = func(interface{}) *image.RGBA
 type ttypeProviderFunc = func(chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc)
 
-type ttype struct {
-	Name        string
-	DisplayName string
-	IsOverlay   bool
-	IsDefault   bool
-}
+// defined over client type so layer listings stay in sync with it
+type ttype client.Layer
 
-var ttypes = map[ttype]ttypeProviderFunc{
-	{"terrain", "Terrain", false, false}: func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
+// layers compiled in, renderers and script layers are added to registry on top
+var builtinLayers = map[ttype]ttypeProviderFunc{
+	{"terrain", "Terrain", false, false}: terrainProvider(yRangeMin, yRangeMax),
+	{"shadedterrain", "Shaded terrain", false, true}: func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
+		return getChunksRegionWithContextFN(s), func(i interface{}) *image.RGBA {
+			return drawShadedTerrain(i.(ContextedChunkData))
+		}
+	},
+	{"netherfloor", "Nether floor", false, false}: func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
 		return s.GetChunksRegion, func(i interface{}) *image.RGBA {
 			c := i.(save.Chunk)
-			return drawChunk(&c)
+			return drawChunkBelowRoof(&c, cfg.GetDSInt(127, "layers", "netherfloor", "roof_y"))
 		}
 	},
-	{"shadedterrain", "Shaded terrain", false, true}: func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
+	{"netherprojection", "Nether projection", true, false}: netherProjectionProvider,
+	{"hillshadedterrain", "Hillshaded terrain", false, true}: func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
 		return getChunksRegionWithContextFN(s), func(i interface{}) *image.RGBA {
-			return drawShadedTerrain(i.(ContextedChunkData))
+			return drawHillshadedTerrain(i.(ContextedChunkData))
+		}
+	},
+	{"night", "Night", false, false}: func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
+		return s.GetChunksRegion, func(i interface{}) *image.RGBA {
+			c := i.(save.Chunk)
+			return drawChunkNight(&c)
 		}
 	},
 	{"counttiles", "Chunk count", false, false}: func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
@@ -71,41 +84,52 @@ var ttypes = map[ttype]ttypeProviderFunc{
 			return drawNumberOfChunks(int(i.(int)))
 		}
 	},
-	{"counttilesheat", "Chunk count heatmap", true, false}: func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
-		return s.GetChunksCountRegion, func(i interface{}) *image.RGBA {
-			return drawHeatOfChunks(int(i.(int)))
+	{"counttilesheat", "Chunk count heatmap", true, false}: heatProvider("counttilesheat", heatOptions{}),
+	{"heightmap", "Heightmap", false, false}:               heightmapProvider(yRangeMin, yRangeMax),
+	{"xray", "Xray", true, false}:                          xrayProvider(yRangeMin, yRangeMax),
+	{"biomes", "Biomes", false, false}: func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
+		return s.GetChunksRegion, func(i interface{}) *image.RGBA {
+			c := i.(save.Chunk)
+			return drawChunkBiomes(&c)
 		}
 	},
-	{"heightmap", "Heightmap", false, false}: func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
+	{"temperature", "Temperature", true, false}: func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
 		return s.GetChunksRegion, func(i interface{}) *image.RGBA {
 			c := i.(save.Chunk)
-			return drawChunkHeightmap(&c)
+			return drawChunkTemperature(&c)
 		}
 	},
-	{"xray", "Xray", false, false}: func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
+	{"humidity", "Humidity", true, false}: func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
 		return s.GetChunksRegion, func(i interface{}) *image.RGBA {
 			c := i.(save.Chunk)
-			return drawChunkXray(&c)
+			return drawChunkHumidity(&c)
 		}
 	},
-	{"biomes", "Biomes", false, false}: func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
+	{"portalsheat", "Portals heatmap", true, false}: heatProvider("portalsheat", heatOptions{}),
+	{"chestheat", "Chest heatmap", true, false}:     heatProvider("chestheat", heatOptions{}),
+	{"chunkage", "Chunk age", true, false}:          heatProvider("chunkage", heatOptions{}),
+	{"oredensity", "Ore density", true, false}:      oreDensityProvider(""),
+	{"diff", "Changes", true, false}:                diffLatestProvider,
+	{"redstone", "Redstone", true, false}: func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
 		return s.GetChunksRegion, func(i interface{}) *image.RGBA {
 			c := i.(save.Chunk)
-			return drawChunkBiomes(&c)
+			return drawChunkRedstone(&c)
 		}
 	},
-	{"portalsheat", "Portals heatmap", true, false}: func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
+	{"crops", "Crops", true, false}: func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
 		return s.GetChunksRegion, func(i interface{}) *image.RGBA {
 			c := i.(save.Chunk)
-			return drawChunkPortalBlocksHeatmap(&c)
+			return drawChunkCrops(&c)
 		}
 	},
-	{"chestheat", "Chest heatmap", true, false}: func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
+	{"spawnable", "Mob spawnable", true, false}: func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
 		return s.GetChunksRegion, func(i interface{}) *image.RGBA {
 			c := i.(save.Chunk)
-			return drawChunkChestBlocksHeatmap(&c)
+			return drawChunkSpawnable(&c)
 		}
 	},
+	{"inhabited", "Inhabited time", true, false}: heatProvider("inhabited", heatOptions{}),
+	{"underground", "Underground", false, false}: undergroundProvider(undergroundDefaultY),
 	{"lavaage", "Lava age", false, false}: func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
 		return s.GetChunksRegion, func(i interface{}) *image.RGBA {
 			c := i.(save.Chunk)
@@ -118,16 +142,116 @@ var ttypes = map[ttype]ttypeProviderFunc{
 			return drawChunkLavaAge(&c, 128)
 		}
 	},
+	{"mobheat", "Mob density", true, false}: heatProvider("mobheat", heatOptions{}),
+	{"borders", "Biome and chunk borders", true, false}: func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
+		return getChunksRegionWithContextFN(s), func(i interface{}) *image.RGBA {
+			return drawChunkBorders(i.(ContextedChunkData))
+		}
+	},
+	{"hillshade", "Hillshade", true, false}: func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
+		return getChunksRegionWithContextFN(s), func(i interface{}) *image.RGBA {
+			return drawChunkHillshade(i.(ContextedChunkData))
+		}
+	},
+	{"contours", "Contour lines", true, false}: func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
+		return getChunksRegionWithContextFN(s), func(i interface{}) *image.RGBA {
+			return drawChunkContours(i.(ContextedChunkData))
+		}
+	},
 	{"shading", "Shading", true, false}: func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
 		return getChunksRegionWithContextFN(s), func(i interface{}) *image.RGBA {
 			return drawChunkShading(i.(ContextedChunkData))
 		}
 	},
+	{"discoverers", "Chunk discoverers", true, false}: func(_ chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
+		return getDiscoverersRegion, func(i interface{}) *image.RGBA {
+			return drawDiscoverer(i.(string))
+		}
+	},
+	{"annotations", "Review annotations", true, false}: func(_ chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
+		return getAnnotationsRegion, func(i interface{}) *image.RGBA {
+			return drawAnnotation(i.(string))
+		}
+	},
+	{"labels", "Labels", true, false}:              tilePainterLayer,
+	{"grid", "Chunk and region grid", true, false}: tilePainterLayer,
+	{"blockmarkers", "Block markers", true, false}: tilePainterLayer,
+}
+
+var ttypes = &layerRegistry{m: builtinLayers}
+
+// renderers and script layers register into it at startup while the web
+// server may already be serving, readers range over a copy
+type layerRegistry struct {
+	lock sync.RWMutex
+	m    map[ttype]ttypeProviderFunc
+}
+
+func (r *layerRegistry) All() map[ttype]ttypeProviderFunc {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	ret := make(map[ttype]ttypeProviderFunc, len(r.m))
+	for k, v := range r.m {
+		ret[k] = v
+	}
+	return ret
+}
+
+func (r *layerRegistry) Add(t ttype, p ttypeProviderFunc) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.m[t] = p
+}
+
+func (r *layerRegistry) Len() int {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return len(r.m)
+}
+
+// placeholder for layers from tilePainters
+func tilePainterLayer(_ chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
+	return func(_, _ string, _, _, _, _ int) ([]chunkStorage.ChunkData, error) {
+			return nil, nil
+		}, func(_ interface{}) *image.RGBA {
+			return nil
+		}
+}
+
+// layers that are drawn over the whole tile at once instead of chunk by chunk,
+// they depend only on config and records so they are not cached
+var tilePainters = map[string]func(loc primitives.ImageLocation) *image.RGBA{
+	"labels":       drawLabelsTile,
+	"grid":         drawGridTile,
+	"blockmarkers": drawBlockMarkersTile,
+}
+
+// layers that take query parameters, every set of parameters is cached as
+// its own variant that is named by variant and parsed back by provider
+type paramLayer struct {
+	variant  func(r *http.Request) (string, error)
+	provider func(variant string) (ttypeProviderFunc, bool)
+}
+
+var paramLayers = map[string]paramLayer{
+	"underground": {undergroundVariant, undergroundVariantProvider},
+	"xray":        yRangeParamLayer("xray", xrayProvider),
+	"terrain":     yRangeParamLayer("terrain", terrainProvider),
+	"heightmap":   yRangeParamLayer("heightmap", heightmapProvider),
+	"oredensity":  {oreDensityVariant, oreDensityVariantProvider},
+	"diff":        diffParamLayer,
+
+	"counttilesheat": heatParamLayer("counttilesheat"),
+	"portalsheat":    heatParamLayer("portalsheat"),
+	"chestheat":      heatParamLayer("chestheat"),
+	"chunkage":       heatParamLayer("chunkage"),
+	"inhabited":      heatParamLayer("inhabited"),
+	"mobheat":        heatParamLayer("mobheat"),
 }
 
 func listttypes() []ttype {
-	keys := make([]ttype, 0, len(ttypes))
-	for t := range ttypes {
+	keys := make([]ttype, 0, ttypes.Len())
+	for t := range ttypes.All() {
 		keys = append(keys, t)
 	}
 	sort.Slice(keys, func(i, j int) bool { return strings.Compare(keys[i].Name, keys[j].Name) > 0 })
@@ -141,51 +265,71 @@ func tileRouterHandler(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		return
 	}
+	if pl, ok := paramLayers[datatype]; ok {
+		datatype, err = pl.variant(r)
+		if err != nil {
+			plainmsg(w, r, plainmsgColorRed, err.Error())
+			return
+		}
+		params["ttype"] = datatype
+		r = mux.SetURLVars(r, params)
+	}
+	if !layerAllowed(r, wname, dname, datatype) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if tp, ok := tilePainters[datatype]; ok {
+		img := tp(primitives.ImageLocation{World: wname, Dimension: dname, Variant: datatype, S: cs, X: cx, Z: cz})
+		if img == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeImage(w, r, fname, img)
+		return
+	}
 	if !r.URL.Query().Has("cached") || r.URL.Query().Get("cached") == "true" {
-		img := imageCacheGetBlocking(wname, dname, datatype, cs, cx, cz)
-		if img != nil {
-			b := bytes.NewBuffer([]byte{})
-			err := png.Encode(b, img)
-			if err != nil {
-				log.Printf("Failed to enclode image: %v", err)
-			}
-			bytes := b.Bytes()
-			w.WriteHeader(http.StatusOK)
-			w.Header().Set("Content-Type", "image/png")
-			w.Header().Set("Content-Length", strconv.Itoa(len(bytes)))
-			if _, err := w.Write(bytes); err != nil {
-				log.Printf("Unable to write image: %s", err.Error())
+		loc := primitives.ImageLocation{World: wname, Dimension: dname, Variant: datatype, S: cs, X: cx, Z: cz}
+		cached := ic.GetCachedImageBlocking(loc)
+		if cached != nil && cached.Img != nil {
+			if tileIsStale(loc, cached.ModTime) {
+				if fresh := revalidateTile(loc, layerRenderTimeout(datatype)); fresh != nil {
+					writeImage(w, r, fname, fresh)
+					return
+				}
+				w.Header().Set("X-Tile-Stale", "true")
 			}
+			writeImage(w, r, fname, cached.Img)
 			return
 		}
+		// nothing cached, changes will be picked up by full render below
+		if cs <= imagecache.StorageLevel {
+			cx0, cz0, cx1, cz1 := tileChunkRange(loc)
+			takeDirtyChunks(wname, dname, datatype, cx0, cz0, cx1, cz1)
+		}
 	}
-	_, s, err := chunkStorage.GetWorldStorage(storages, wname)
+	_, s, err := storages.World(wname)
 	if err != nil {
 		return
 	}
-	var ff ttypeProviderFunc
-	ffound := false
-	for tt := range ttypes {
-		if tt.Name == datatype {
-			ff = ttypes[tt]
-			ffound = true
-		}
-	}
-	if !ffound {
+	ff := findTTypeProviderFunc(primitives.ImageLocation{Variant: datatype})
+	if ff == nil {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	g, p := ff(s)
+	g, p := (*ff)(s)
 	img := scaleImageryHandler(w, r, g, p)
 	if img == nil {
 		return
 	}
-	if r.Header.Get("Cache-Control") != "no-store" {
+	cacheable := w.Header().Get(errorTileHeader) == ""
+	if cacheable && r.Header.Get("Cache-Control") != "no-store" {
 		imageCacheSave(img, wname, dname, datatype, cs, cx, cz)
 	}
 	w.WriteHeader(http.StatusOK)
-	writeImage(w, fname, img)
-	imageCacheSave(img, wname, dname, datatype, cs, cx, cz)
+	writeImage(w, r, fname, img)
+	if cacheable {
+		imageCacheSave(img, wname, dname, datatype, cs, cx, cz)
+	}
 }
 
 func scaleImageryHandler(w http.ResponseWriter, r *http.Request, getter chunkDataProviderFunc, painter chunkPainterFunc) *image.RGBA {
@@ -194,6 +338,19 @@ func scaleImageryHandler(w http.ResponseWriter, r *http.Request, getter chunkDat
 	if err != nil {
 		return nil
 	}
+	if tileIsSampled(cs) {
+		loc := primitives.ImageLocation{World: wname, Dimension: dname, Variant: mux.Vars(r)["ttype"], S: cs, X: cx, Z: cz}
+		img, err := renderSampledTile(r.Context(), loc, getter, painter)
+		if err != nil {
+			plainmsg(w, r, plainmsgColorRed, "Error getting chunk data: "+err.Error())
+			log.Println("Error getting chunk data: ", err)
+			return nil
+		}
+		if img == nil {
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return img
+	}
 	scale := 1
 	if cs > 0 {
 		scale = int(2 << (cs - 1))
@@ -208,40 +365,85 @@ func scaleImageryHandler(w http.ResponseWriter, r *http.Request, getter chunkDat
 	offsety := cz * scale
 	cc, err := getter(wname, dname, cx*scale, cz*scale, cx*scale+scale, cz*scale+scale)
 	if err != nil {
-		plainmsg(w, r, plainmsgColorRed, "Error getting chunk data: "+err.Error())
 		log.Println("Error getting chunk data: ", err)
+		if errorTilesEnabled() {
+			w.Header().Set(errorTileHeader, "true")
+			drawErrorTile(img, img.Bounds(), "Error getting chunk data: "+err.Error())
+			return img
+		}
+		plainmsg(w, r, plainmsgColorRed, "Error getting chunk data: "+err.Error())
 		return nil
 	}
-	if len(cc) == 0 {
+	downscale := layerDownscaler(mux.Vars(r)["ttype"])
+	style, styleColor := missingChunksStyle()
+	if layerIsOverlay(mux.Vars(r)["ttype"]) || imagescale < 1 {
+		style = "transparent"
+	}
+	if len(cc) == 0 && style == "transparent" {
 		w.WriteHeader(http.StatusNoContent)
 		return nil
 	}
+	// which chunk slots got data, only tracked when there is something to fill
+	var present []bool
+	if style != "transparent" {
+		present = make([]bool, scale*scale)
+	}
 	for _, c := range cc {
-		if errors.Is(r.Context().Err(), context.Canceled) {
-			return img
+		placex := int(c.X - offsetx)
+		placey := int(c.Z - offsety)
+		if present != nil && placex >= 0 && placex < scale && placey >= 0 && placey < scale {
+			present[placey*scale+placex] = true
 		}
+	}
+	// header map is not safe to touch from several painters at once
+	headerLock := sync.Mutex{}
+	forEachChunkParallel(r.Context(), cc, func(c chunkStorage.ChunkData) {
 		placex := int(c.X - offsetx)
 		placey := int(c.Z - offsety)
+		at := image.Rect(placex*int(imagescale), placey*int(imagescale), placex*int(imagescale)+imagescale, placey*int(imagescale)+imagescale)
+		// storage may hand over error in place of chunk it could not decode
+		var failure interface{}
+		if derr, ok := c.Data.(error); ok {
+			failure = "decode: " + derr.Error()
+		}
 		var chunk *image.RGBA
-		chunk = func(d interface{}) *image.RGBA {
-			defer func() {
-				if err := recover(); err != nil {
-					log.Println(cx, cz, err)
-					debug.PrintStack()
-				}
-				chunk = nil
-			}()
-			var ret *image.RGBA
-			ret = nil
-			ret = painter(d)
-			return ret
-		}(c.Data)
+		if failure == nil {
+			chunk = func(d interface{}) (ret *image.RGBA) {
+				defer func() {
+					if err := recover(); err != nil {
+						log.Println(cx, cz, err)
+						debug.PrintStack()
+						failure = err
+						ret = nil
+					}
+				}()
+				return painter(d)
+			}(c.Data)
+		}
+		if failure != nil {
+			log.Printf("Failed to draw chunk %d:%d of %s:%s: %v", c.X, c.Z, wname, dname, failure)
+			if errorTilesEnabled() && imagescale > 0 {
+				headerLock.Lock()
+				w.Header().Set(errorTileHeader, "true")
+				headerLock.Unlock()
+				drawErrorTile(img, at, fmt.Sprint(failure))
+			}
+			return
+		}
 		if chunk == nil {
+			return
+		}
+		downscale(img, at, chunk)
+	})
+	if errors.Is(r.Context().Err(), context.Canceled) {
+		return img
+	}
+	for i, p := range present {
+		if p {
 			continue
 		}
-		tile := resize.Resize(uint(imagescale), uint(imagescale), chunk, resize.NearestNeighbor)
-		draw.Draw(img, image.Rect(placex*int(imagescale), placey*int(imagescale), placex*int(imagescale)+imagescale, placey*int(imagescale)+imagescale),
-			tile, image.Pt(0, 0), draw.Over)
+		x, y := i%scale*imagescale, i/scale*imagescale
+		drawMissingChunk(img, image.Rect(x, y, x+imagescale, y+imagescale), style, styleColor)
 	}
 	return img
 }
@@ -251,7 +453,22 @@ func tilingParams(w http.ResponseWriter, r *http.Request) (wname, dname, fname s
 	dname = params["dim"]
 	wname = params["world"]
 	fname = params["format"]
-	if fname != "jpeg" && fname != "png" {
+	if fname == "auto" {
+		fname = negotiateImageFormat(r)
+		w.Header().Set("Vary", "Accept")
+	}
+	switch fname {
+	case "jpeg", "png", "webp":
+	case "avif":
+		if !cfg.GetDSBool(false, "avif", "enabled") {
+			err = errors.New("avif encoding is not enabled")
+			plainmsg(w, r, plainmsgColorRed, "AVIF encoding is not enabled")
+			return
+		}
+		// fallback when encoders are busy depends on it
+		w.Header().Set("Vary", "Accept")
+	default:
+		err = errors.New("bad encoding")
 		plainmsg(w, r, plainmsgColorRed, "Bad encoding")
 		return
 	}
@@ -279,12 +496,16 @@ func tilingParams(w http.ResponseWriter, r *http.Request) (wname, dname, fname s
 	return
 }
 
-func writeImage(w http.ResponseWriter, format string, img *image.RGBA) {
+func writeImage(w http.ResponseWriter, r *http.Request, format string, img *image.RGBA) {
 	switch format {
 	case "jpeg":
 		writeImageJpeg(w, img)
 	case "png":
 		writeImagePng(w, img)
+	case "webp":
+		writeImageWebp(w, img)
+	case "avif":
+		writeImageAvif(w, r, img)
 	}
 }
 
@@ -300,6 +521,26 @@ func writeImageJpeg(w http.ResponseWriter, img *image.RGBA) {
 	}
 }
 
+// lossless keeps tiles exact, lossy ones are several times smaller than png,
+// png is sent if encoder fails same as with avif
+func writeImageWebp(w http.ResponseWriter, img *image.RGBA) {
+	buffer := new(bytes.Buffer)
+	opts := &webp.Options{
+		Lossless: cfg.GetDSBool(false, "webp", "lossless"),
+		Quality:  cfg.GetDSInt(webp.DefaultQuality, "webp", "quality"),
+	}
+	if err := webp.Encode(buffer, img, opts); err != nil {
+		log.Printf("Unable to encode image: %s", err.Error())
+		writeImagePng(w, img)
+		return
+	}
+	w.Header().Set("Content-Type", "image/webp")
+	w.Header().Set("Content-Length", strconv.Itoa(len(buffer.Bytes())))
+	if _, err := w.Write(buffer.Bytes()); err != nil {
+		log.Printf("Unable to write image: %s", err.Error())
+	}
+}
+
 func writeImagePng(w http.ResponseWriter, img *image.RGBA) {
 	buffer := new(bytes.Buffer)
 	if err := png.Encode(buffer, img); err != nil {
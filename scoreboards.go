@@ -0,0 +1,309 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/maxsupermanhd/WebChunk/proxy"
+)
+
+// Kind is "objective", "score" or "bossbar"
+type scoreboardRecord struct {
+	Time      time.Time
+	Player    string
+	Kind      string
+	Objective string `json:",omitempty"`
+	Entity    string `json:",omitempty"`
+	BossBar   string `json:",omitempty"`
+	Title     string `json:",omitempty"`
+	Value     int32
+	Health    float32 `json:",omitempty"`
+	Color     string  `json:",omitempty"`
+	Removed   bool    `json:",omitempty"`
+}
+
+type scoreboardObjective struct {
+	Name        string
+	DisplayName string
+	Scores      map[string]int32
+	Updated     time.Time
+}
+
+type scoreboardBossBar struct {
+	UUID    string
+	Title   string
+	Health  float32
+	Color   string
+	Updated time.Time
+}
+
+func (b scoreboardBossBar) Percent() int {
+	return int(math.Round(float64(b.Health) * 100))
+}
+
+type scoreboardState struct {
+	Objectives map[string]*scoreboardObjective
+	BossBars   map[string]*scoreboardBossBar
+}
+
+// state of every world is rebuilt from history on first use
+var (
+	scoreboards     = map[string]*scoreboardState{}
+	scoreboardsLock sync.Mutex
+)
+
+var bossBarColors = []string{"pink", "blue", "red", "green", "yellow", "purple", "white"}
+
+func bossBarColor(c int32) string {
+	if c < 0 || int(c) >= len(bossBarColors) {
+		return "white"
+	}
+	return bossBarColors[c]
+}
+
+// returns false if record does not change anything so that every proxied
+// player seeing the same scoreboard does not fill up the history
+func (s *scoreboardState) apply(r scoreboardRecord) bool {
+	switch r.Kind {
+	case "objective":
+		o, ok := s.Objectives[r.Objective]
+		if r.Removed {
+			delete(s.Objectives, r.Objective)
+			return ok
+		}
+		if ok && o.DisplayName == r.Title {
+			return false
+		}
+		if !ok {
+			o = &scoreboardObjective{Name: r.Objective, Scores: map[string]int32{}}
+			s.Objectives[r.Objective] = o
+		}
+		o.DisplayName = r.Title
+		o.Updated = r.Time
+	case "score":
+		if r.Removed && r.Objective == "" {
+			changed := false
+			for _, o := range s.Objectives {
+				if _, ok := o.Scores[r.Entity]; ok {
+					delete(o.Scores, r.Entity)
+					o.Updated = r.Time
+					changed = true
+				}
+			}
+			return changed
+		}
+		o, ok := s.Objectives[r.Objective]
+		if !ok {
+			if r.Removed {
+				return false
+			}
+			// scores can arrive before objective when player joins mid-update
+			o = &scoreboardObjective{Name: r.Objective, DisplayName: r.Objective, Scores: map[string]int32{}}
+			s.Objectives[r.Objective] = o
+		}
+		v, had := o.Scores[r.Entity]
+		if r.Removed {
+			if !had {
+				return false
+			}
+			delete(o.Scores, r.Entity)
+		} else {
+			if had && v == r.Value {
+				return false
+			}
+			o.Scores[r.Entity] = r.Value
+		}
+		o.Updated = r.Time
+	case "bossbar":
+		b, ok := s.BossBars[r.BossBar]
+		if r.Removed {
+			delete(s.BossBars, r.BossBar)
+			return ok
+		}
+		if ok && b.Title == r.Title && b.Health == r.Health && b.Color == r.Color {
+			return false
+		}
+		s.BossBars[r.BossBar] = &scoreboardBossBar{UUID: r.BossBar, Title: r.Title, Health: r.Health, Color: r.Color, Updated: r.Time}
+	default:
+		return false
+	}
+	return true
+}
+
+// must be called with scoreboardsLock held
+func getScoreboardState(wname string) *scoreboardState {
+	if s, ok := scoreboards[wname]; ok {
+		return s
+	}
+	s := &scoreboardState{
+		Objectives: map[string]*scoreboardObjective{},
+		BossBars:   map[string]*scoreboardBossBar{},
+	}
+	err := recs.Read(wname, "", "scoreboard", func(m json.RawMessage) error {
+		var r scoreboardRecord
+		if json.Unmarshal(m, &r) == nil {
+			s.apply(r)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to read scoreboard history of world [%s]: %s", wname, err.Error())
+	}
+	scoreboards[wname] = s
+	return s
+}
+
+func scoreboardRecordReceived(e *proxy.ProxiedEvent, r scoreboardRecord) {
+	r.Time = e.Time
+	r.Player = e.Username
+	scoreboardsLock.Lock()
+	changed := getScoreboardState(e.Server).apply(r)
+	scoreboardsLock.Unlock()
+	if !changed {
+		return
+	}
+	if err := recs.Append(e.Server, "", "scoreboard", r); err != nil {
+		log.Printf("Failed to record scoreboard update: %s", err.Error())
+	}
+}
+
+func objectiveReceived(e *proxy.ProxiedEvent, d proxy.EventObjective) {
+	scoreboardRecordReceived(e, scoreboardRecord{Kind: "objective", Objective: d.Name, Title: d.DisplayName, Removed: d.Removed})
+}
+
+func scoreReceived(e *proxy.ProxiedEvent, d proxy.EventScore) {
+	scoreboardRecordReceived(e, scoreboardRecord{Kind: "score", Objective: d.Objective, Entity: d.Entity, Value: d.Value, Removed: d.Removed})
+}
+
+// health changes smoothly on some servers, hundredths are enough to look at
+func bossBarReceived(e *proxy.ProxiedEvent, d proxy.EventBossBar) {
+	scoreboardRecordReceived(e, scoreboardRecord{
+		Kind:    "bossbar",
+		BossBar: d.UUID.String(),
+		Title:   d.Title,
+		Health:  float32(math.Round(float64(d.Health)*100) / 100),
+		Color:   bossBarColor(d.Color),
+		Removed: d.Removed,
+	})
+}
+
+type scoreboardScore struct {
+	Entity string
+	Value  int32
+}
+
+type scoreboardObjectiveView struct {
+	Name        string
+	DisplayName string
+	Updated     time.Time
+	Scores      []scoreboardScore
+}
+
+type scoreboardView struct {
+	Objectives []scoreboardObjectiveView
+	BossBars   []scoreboardBossBar
+}
+
+// scores are sorted highest first like the sidebar in game shows them
+func currentScoreboard(wname string, names playerNamer) scoreboardView {
+	scoreboardsLock.Lock()
+	defer scoreboardsLock.Unlock()
+	s := getScoreboardState(wname)
+	ret := scoreboardView{Objectives: []scoreboardObjectiveView{}, BossBars: []scoreboardBossBar{}}
+	for _, o := range s.Objectives {
+		v := scoreboardObjectiveView{Name: o.Name, DisplayName: o.DisplayName, Updated: o.Updated, Scores: []scoreboardScore{}}
+		for ent, val := range o.Scores {
+			v.Scores = append(v.Scores, scoreboardScore{Entity: names(ent), Value: val})
+		}
+		sort.Slice(v.Scores, func(i, j int) bool {
+			if v.Scores[i].Value == v.Scores[j].Value {
+				return v.Scores[i].Entity < v.Scores[j].Entity
+			}
+			return v.Scores[i].Value > v.Scores[j].Value
+		})
+		ret.Objectives = append(ret.Objectives, v)
+	}
+	sort.Slice(ret.Objectives, func(i, j int) bool { return ret.Objectives[i].Name < ret.Objectives[j].Name })
+	for _, b := range s.BossBars {
+		ret.BossBars = append(ret.BossBars, *b)
+	}
+	sort.Slice(ret.BossBars, func(i, j int) bool { return ret.BossBars[i].Updated.After(ret.BossBars[j].Updated) })
+	return ret
+}
+
+func apiScoreboard(w http.ResponseWriter, r *http.Request) (int, string) {
+	setContentTypeJson(w)
+	return marshalOrFail(200, currentScoreboard(mux.Vars(r)["world"], playerNamerFor(r)))
+}
+
+// newest first, entity filter is matched against names as they are shown
+func apiScoreboardHistory(w http.ResponseWriter, r *http.Request) (int, string) {
+	names := playerNamerFor(r)
+	kind := r.FormValue("kind")
+	objective := r.FormValue("objective")
+	entity := strings.ToLower(r.FormValue("entity"))
+	limit := 200
+	if l, err := strconv.Atoi(r.FormValue("limit")); err == nil && l > 0 {
+		limit = l
+	}
+	var since time.Time
+	if t, err := time.Parse(time.RFC3339, r.FormValue("since")); err == nil {
+		since = t
+	}
+	ret := []scoreboardRecord{}
+	err := recs.Read(mux.Vars(r)["world"], "", "scoreboard", func(m json.RawMessage) error {
+		var s scoreboardRecord
+		if json.Unmarshal(m, &s) != nil {
+			return nil
+		}
+		s.Player = names(s.Player)
+		if s.Entity != "" {
+			s.Entity = names(s.Entity)
+		}
+		if (kind != "" && s.Kind != kind) ||
+			(objective != "" && s.Objective != objective) ||
+			(entity != "" && !strings.Contains(strings.ToLower(s.Entity), entity)) ||
+			(!since.IsZero() && s.Time.Before(since)) {
+			return nil
+		}
+		ret = append(ret, s)
+		return nil
+	})
+	if err != nil {
+		return 500, err.Error()
+	}
+	sort.SliceStable(ret, func(i, j int) bool { return ret[i].Time.After(ret[j].Time) })
+	if len(ret) > limit {
+		ret = ret[:limit]
+	}
+	setContentTypeJson(w)
+	return marshalOrFail(200, ret)
+}
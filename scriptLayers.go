@@ -0,0 +1,481 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/maxsupermanhd/WebChunk/chunkStorage"
+	"github.com/maxsupermanhd/go-vmc/v764/level"
+	"github.com/maxsupermanhd/go-vmc/v764/level/block"
+	"github.com/maxsupermanhd/go-vmc/v764/save"
+	"go.starlark.net/starlark"
+)
+
+// custom layers written in Starlark, every <name>.star in the directory is
+// a layer with paint(chunk, x, z) returning color of the column or None
+type scriptLayer struct {
+	name        string
+	displayName string
+	overlay     bool
+	paint       starlark.Callable
+}
+
+var (
+	scriptLayersLock sync.RWMutex
+	scriptLayers     = map[string]*scriptLayer{}
+)
+
+var scriptLayerNameRegexp = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+func scriptLayersDir() string {
+	return cfg.GetDSString("./scriptlayers", "script_layers", "path")
+}
+
+func scriptLayerMaxSteps() uint64 {
+	return uint64(cfg.GetDSInt(1000000, "script_layers", "max_steps"))
+}
+
+var scriptPredeclared = starlark.StringDict{
+	"rgb": starlark.NewBuiltin("rgb", func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var r, g, bl int
+		a := 255
+		if err := starlark.UnpackArgs(b.Name(), args, kwargs, "r", &r, "g", &g, "b", &bl, "a?", &a); err != nil {
+			return nil, err
+		}
+		return starlark.Tuple{starlark.MakeInt(r), starlark.MakeInt(g), starlark.MakeInt(bl), starlark.MakeInt(a)}, nil
+	}),
+}
+
+func loadScriptLayer(path string) (*scriptLayer, error) {
+	name := strings.TrimSuffix(filepath.Base(path), ".star")
+	if !scriptLayerNameRegexp.MatchString(name) {
+		return nil, errors.New("layer name can only have lowercase letters, digits and underscores")
+	}
+	thread := &starlark.Thread{Name: "load " + name}
+	thread.SetMaxExecutionSteps(scriptLayerMaxSteps())
+	globals, err := starlark.ExecFile(thread, path, nil, scriptPredeclared)
+	if err != nil {
+		return nil, err
+	}
+	// frozen globals can be used by many painters at once
+	globals.Freeze()
+	ret := &scriptLayer{name: name, displayName: name}
+	paint, ok := globals["paint"].(starlark.Callable)
+	if !ok {
+		return nil, errors.New("script has no paint function")
+	}
+	ret.paint = paint
+	if v, ok := globals["display_name"].(starlark.String); ok {
+		ret.displayName = string(v)
+	}
+	if v, ok := globals["overlay"]; ok {
+		ret.overlay = bool(v.Truth())
+	}
+	return ret, nil
+}
+
+func getScriptLayer(name string) *scriptLayer {
+	scriptLayersLock.RLock()
+	defer scriptLayersLock.RUnlock()
+	return scriptLayers[name]
+}
+
+// scripts present at startup are listed along with other layers, ones added
+// later are only served by name until restart
+func loadScriptLayers() {
+	paths, err := filepath.Glob(filepath.Join(scriptLayersDir(), "*.star"))
+	if err != nil {
+		log.Printf("Failed to list script layers: %s", err.Error())
+		return
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		l := reloadScriptLayer(p)
+		if l == nil {
+			continue
+		}
+		if layerNameTaken(l.name) {
+			log.Printf("Script layer [%s] is not added, layer with that name already exists", l.name)
+			scriptLayersLock.Lock()
+			delete(scriptLayers, l.name)
+			scriptLayersLock.Unlock()
+			continue
+		}
+		ttypes.Add(ttype{Name: l.name, DisplayName: l.displayName, IsOverlay: l.overlay}, scriptProvider(l.name))
+	}
+}
+
+// broken script keeps previous version of the layer running
+func reloadScriptLayer(path string) *scriptLayer {
+	name := strings.TrimSuffix(filepath.Base(path), ".star")
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		scriptLayersLock.Lock()
+		delete(scriptLayers, name)
+		scriptLayersLock.Unlock()
+		forgetChunkMemo(name)
+		log.Printf("Script layer [%s] removed", name)
+		return nil
+	}
+	l, err := loadScriptLayer(path)
+	if err != nil {
+		log.Printf("Failed to load script layer [%s]: %s", name, err.Error())
+		return nil
+	}
+	scriptLayersLock.Lock()
+	scriptLayers[name] = l
+	scriptLayersLock.Unlock()
+	forgetChunkMemo(name)
+	log.Printf("Loaded script layer [%s]", name)
+	return l
+}
+
+func scriptLayersWatcher(exitchan <-chan struct{}) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Println("Failed to create script layers watcher: ", err)
+		<-exitchan
+		return
+	}
+	defer watcher.Close()
+	if err := watcher.Add(scriptLayersDir()); err != nil {
+		log.Println("Script layers are not watched: ", err)
+		<-exitchan
+		return
+	}
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Ext(event.Name) != ".star" || event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) == 0 {
+				continue
+			}
+			reloadScriptLayer(event.Name)
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Println("Script layers watcher error:", err)
+		case <-exitchan:
+			return
+		}
+	}
+}
+
+func scriptLayerProvider(name string) (ttypeProviderFunc, bool) {
+	if getScriptLayer(name) == nil {
+		return nil, false
+	}
+	return scriptProvider(name), true
+}
+
+func scriptProvider(name string) ttypeProviderFunc {
+	return func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
+		return s.GetChunksRegion, func(i interface{}) *image.RGBA {
+			l := getScriptLayer(name)
+			if l == nil {
+				return nil
+			}
+			c := i.(save.Chunk)
+			img, err := l.draw(&c)
+			if err != nil {
+				// shows up as error tile along with the message
+				panic(fmt.Errorf("script %s: %w", name, err))
+			}
+			return img
+		}
+	}
+}
+
+func (l *scriptLayer) draw(chunk *save.Chunk) (*image.RGBA, error) {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	thread := &starlark.Thread{Name: "paint " + l.name}
+	thread.SetMaxExecutionSteps(scriptLayerMaxSteps())
+	sc := newScriptChunk(chunk)
+	for z := 0; z < 16; z++ {
+		for x := 0; x < 16; x++ {
+			v, err := starlark.Call(thread, l.paint, starlark.Tuple{sc, starlark.MakeInt(x), starlark.MakeInt(z)}, nil)
+			if err != nil {
+				return nil, err
+			}
+			c, err := scriptColor(v)
+			if err != nil {
+				return nil, err
+			}
+			img.SetRGBA(x, z, c)
+		}
+	}
+	return img, nil
+}
+
+// None, "#rrggbb", "#rrggbbaa" or (r, g, b[, a]) as made by rgb()
+func scriptColor(v starlark.Value) (color.RGBA, error) {
+	switch v := v.(type) {
+	case starlark.NoneType:
+		return color.RGBA{}, nil
+	case starlark.String:
+		s := string(v)
+		if len(s) == 7 {
+			s += "ff"
+		}
+		c, err := ParseHexColor(s)
+		if err != nil {
+			return color.RGBA{}, fmt.Errorf("bad color %s: %w", v.String(), err)
+		}
+		return color.RGBA{uint8(c.R >> 8), uint8(c.G >> 8), uint8(c.B >> 8), uint8(c.A >> 8)}, nil
+	case starlark.Tuple:
+		if len(v) != 3 && len(v) != 4 {
+			return color.RGBA{}, fmt.Errorf("bad color %s", v.String())
+		}
+		ch := [4]int{0, 0, 0, 255}
+		for i := range v {
+			if err := starlark.AsInt(v[i], &ch[i]); err != nil {
+				return color.RGBA{}, fmt.Errorf("bad color %s: %w", v.String(), err)
+			}
+		}
+		return color.RGBA{uint8(ch[0]), uint8(ch[1]), uint8(ch[2]), uint8(ch[3])}, nil
+	}
+	return color.RGBA{}, fmt.Errorf("paint returned %s instead of color", v.Type())
+}
+
+// chunk as scripts see it, sections are decoded on first access
+type scriptChunk struct {
+	chunk    *save.Chunk
+	sections map[int]*save.Section
+	states   map[int]*level.PaletteContainer[block.StateID]
+	biomes   map[int]*level.PaletteContainer[level.BiomesState]
+	minY     int
+	maxY     int
+	heights  []int
+}
+
+func newScriptChunk(chunk *save.Chunk) *scriptChunk {
+	ret := &scriptChunk{
+		chunk:    chunk,
+		sections: map[int]*save.Section{},
+		states:   map[int]*level.PaletteContainer[block.StateID]{},
+		biomes:   map[int]*level.PaletteContainer[level.BiomesState]{},
+		minY:     1 << 30,
+		maxY:     -1 << 30,
+	}
+	for i := range chunk.Sections {
+		s := &chunk.Sections[i]
+		sy := int(int8(s.Y))
+		ret.sections[sy] = s
+		ret.minY = minInt(ret.minY, sy*16)
+		ret.maxY = maxInt(ret.maxY, sy*16+15)
+	}
+	return ret
+}
+
+var _ starlark.HasAttrs = (*scriptChunk)(nil)
+
+func (c *scriptChunk) String() string {
+	return fmt.Sprintf("chunk(%d, %d)", c.chunk.XPos, c.chunk.ZPos)
+}
+func (c *scriptChunk) Type() string          { return "chunk" }
+func (c *scriptChunk) Freeze()               {}
+func (c *scriptChunk) Truth() starlark.Bool  { return starlark.True }
+func (c *scriptChunk) Hash() (uint32, error) { return 0, errors.New("unhashable type: chunk") }
+
+func (c *scriptChunk) AttrNames() []string {
+	return []string{"biome", "block", "height", "max_y", "min_y", "palette", "top", "x", "z"}
+}
+
+func (c *scriptChunk) Attr(name string) (starlark.Value, error) {
+	switch name {
+	case "x":
+		return starlark.MakeInt(int(c.chunk.XPos)), nil
+	case "z":
+		return starlark.MakeInt(int(c.chunk.ZPos)), nil
+	case "min_y":
+		return starlark.MakeInt(c.minY), nil
+	case "max_y":
+		return starlark.MakeInt(c.maxY), nil
+	case "block":
+		return starlark.NewBuiltin(name, c.builtinBlock), nil
+	case "biome":
+		return starlark.NewBuiltin(name, c.builtinBiome), nil
+	case "height":
+		return starlark.NewBuiltin(name, c.builtinHeight), nil
+	case "top":
+		return starlark.NewBuiltin(name, c.builtinTop), nil
+	case "palette":
+		return starlark.NewBuiltin(name, c.builtinPalette), nil
+	}
+	return nil, nil
+}
+
+func (c *scriptChunk) sectionStates(sy int) *level.PaletteContainer[block.StateID] {
+	if st, ok := c.states[sy]; ok {
+		return st
+	}
+	var st *level.PaletteContainer[block.StateID]
+	if s, ok := c.sections[sy]; ok && len(s.BlockStates.Palette) > 0 {
+		st = prepareSectionBlockIDs(s)
+	}
+	c.states[sy] = st
+	return st
+}
+
+// air outside of stored sections
+func (c *scriptChunk) blockAt(x, y, z int) string {
+	sy := floorDiv(y, 16)
+	st := c.sectionStates(sy)
+	if st == nil {
+		return "minecraft:air"
+	}
+	return stateName(st.Get((y-sy*16)*16*16 + z*16 + x))
+}
+
+func (c *scriptChunk) heightAt(x, z int) (int, bool) {
+	if c.heights == nil {
+		c.heights = make([]int, 16*16)
+		for i := range c.heights {
+			c.heights[i] = c.minY - 1
+		}
+		for i := range c.heights {
+			for y := c.maxY; y >= c.minY; y-- {
+				if !isAirState(c.sectionStateAt(i%16, y, i/16)) {
+					c.heights[i] = y
+					break
+				}
+			}
+		}
+	}
+	h := c.heights[z*16+x]
+	return h, h >= c.minY
+}
+
+func (c *scriptChunk) sectionStateAt(x, y, z int) block.StateID {
+	sy := floorDiv(y, 16)
+	st := c.sectionStates(sy)
+	if st == nil {
+		return block.ToStateID[block.Air{}]
+	}
+	return st.Get((y-sy*16)*16*16 + z*16 + x)
+}
+
+func unpackColumn(b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple, withY bool) (x, y, z int, err error) {
+	if withY {
+		err = starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 3, &x, &y, &z)
+	} else {
+		err = starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 2, &x, &z)
+	}
+	if err == nil && (x < 0 || x > 15 || z < 0 || z > 15) {
+		err = fmt.Errorf("%s: x and z are chunk relative and go from 0 to 15", b.Name())
+	}
+	return
+}
+
+func (c *scriptChunk) builtinBlock(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	x, y, z, err := unpackColumn(b, args, kwargs, true)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.String(c.blockAt(x, y, z)), nil
+}
+
+func (c *scriptChunk) builtinHeight(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	x, _, z, err := unpackColumn(b, args, kwargs, false)
+	if err != nil {
+		return nil, err
+	}
+	h, ok := c.heightAt(x, z)
+	if !ok {
+		return starlark.None, nil
+	}
+	return starlark.MakeInt(h), nil
+}
+
+func (c *scriptChunk) builtinTop(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	x, _, z, err := unpackColumn(b, args, kwargs, false)
+	if err != nil {
+		return nil, err
+	}
+	h, ok := c.heightAt(x, z)
+	if !ok {
+		return starlark.None, nil
+	}
+	return starlark.String(c.blockAt(x, h, z)), nil
+}
+
+func (c *scriptChunk) builtinBiome(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	x, y, z, err := unpackColumn(b, args, kwargs, true)
+	if err != nil {
+		return nil, err
+	}
+	sy := floorDiv(y, 16)
+	s, ok := c.sections[sy]
+	if !ok || len(s.Biomes.Palette) == 0 {
+		return starlark.None, nil
+	}
+	bc, ok := c.biomes[sy]
+	if !ok {
+		// palette indexes instead of ids, names are taken right from the palette
+		idx := make([]level.BiomesState, len(s.Biomes.Palette))
+		for i := range idx {
+			idx[i] = level.BiomesState(i)
+		}
+		bc = level.NewBiomesPaletteContainerWithData(4*4*4, s.Biomes.Data, idx)
+		c.biomes[sy] = bc
+	}
+	i := int(bc.Get((y-sy*16)/4*16 + z/4*4 + x/4))
+	if i < 0 || i >= len(s.Biomes.Palette) {
+		return starlark.None, nil
+	}
+	return starlark.String(s.Biomes.Palette[i]), nil
+}
+
+// every block name in the chunk, handy to skip chunks without anything interesting
+func (c *scriptChunk) builtinPalette(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	ret := []starlark.Value{}
+	for _, s := range c.chunk.Sections {
+		for _, p := range s.BlockStates.Palette {
+			n := p.Name
+			if !strings.Contains(n, ":") {
+				n = "minecraft:" + n
+			}
+			if !seen[n] {
+				seen[n] = true
+				ret = append(ret, starlark.String(n))
+			}
+		}
+	}
+	return starlark.NewList(ret), nil
+}
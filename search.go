@@ -0,0 +1,141 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	coordsNamedRegexp  = regexp.MustCompile(`(?i)\b([xyz])\s*[=:]?\s*(-?\d+)`)
+	coordsNumberRegexp = regexp.MustCompile(`-?\d+`)
+)
+
+type coordSearchResult struct {
+	World          string
+	Dimension      string
+	ChunkX, ChunkZ int
+	LastSeen       time.Time
+	MapURL         string
+}
+
+// parseCoordsQuery accepts things people paste from chat,
+// like "x=1234 z=-567", "X: 1234 Z: -567", "1234 -567" or "1234 64 -567"
+func parseCoordsQuery(q string) (x, z int, err error) {
+	named := map[string]int{}
+	for _, m := range coordsNamedRegexp.FindAllStringSubmatch(q, -1) {
+		v, err := strconv.Atoi(m[2])
+		if err != nil {
+			return 0, 0, err
+		}
+		named[strings.ToLower(m[1])] = v
+	}
+	nx, okx := named["x"]
+	nz, okz := named["z"]
+	if okx && okz {
+		return nx, nz, nil
+	}
+	nums := coordsNumberRegexp.FindAllString(q, -1)
+	var ix, iz int
+	switch len(nums) {
+	case 2:
+		ix, iz = 0, 1
+	case 3:
+		ix, iz = 0, 2
+	default:
+		return 0, 0, errors.New("expected x and z coordinates")
+	}
+	x, err = strconv.Atoi(nums[ix])
+	if err != nil {
+		return 0, 0, err
+	}
+	z, err = strconv.Atoi(nums[iz])
+	return x, z, err
+}
+
+func searchChunkEverywhere(cx, cz int) []coordSearchResult {
+	ret := []coordSearchResult{}
+	for sn, s := range storages.Snapshot() {
+		if s.Driver == nil {
+			continue
+		}
+		dims, err := s.Driver.ListDimensions()
+		if err != nil {
+			log.Printf("Failed to list dims on storage %s: %s", sn, err.Error())
+			continue
+		}
+		for _, d := range dims {
+			t, err := s.Driver.GetChunkModDate(d.World, d.Name, cx, cz)
+			if err != nil {
+				log.Printf("Failed to get chunk date on storage %s: %s", sn, err.Error())
+				continue
+			}
+			if t == nil {
+				continue
+			}
+			ret = append(ret, coordSearchResult{
+				World:     d.World,
+				Dimension: d.Name,
+				ChunkX:    cx,
+				ChunkZ:    cz,
+				LastSeen:  *t,
+				MapURL: fmt.Sprintf("/view?world=%s&dim=%s&x=%d&z=%d",
+					url.QueryEscape(d.World), url.QueryEscape(d.Name), cx*16+8, cz*16+8),
+			})
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].LastSeen.After(ret[j].LastSeen)
+	})
+	return ret
+}
+
+func apiSearchCoords(w http.ResponseWriter, r *http.Request) (int, string) {
+	var x, z int
+	if q := r.FormValue("q"); q != "" {
+		var err error
+		x, z, err = parseCoordsQuery(q)
+		if err != nil {
+			return 400, "Failed to parse coordinates: " + err.Error()
+		}
+	} else {
+		q, err := parseFormInts(r, "x", "z")
+		if err != nil {
+			return 400, err.Error()
+		}
+		x, z = q[0], q[1]
+	}
+	setContentTypeJson(w)
+	return marshalOrFail(200, map[string]any{
+		"X":       x,
+		"Z":       z,
+		"Results": searchChunkEverywhere(x>>4, z>>4),
+	})
+}
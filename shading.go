@@ -0,0 +1,107 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"time"
+
+	"github.com/maxsupermanhd/go-vmc/v764/save"
+)
+
+// heights of the chunk with pad blocks around it taken from all 8 neighbours,
+// where neighbour is missing closest edge of the chunk itself is repeated
+type heightGrid struct {
+	pad int
+	h   []int
+}
+
+func (g heightGrid) at(x, z int) int {
+	w := 16 + 2*g.pad
+	return g.h[(z+g.pad)*w+x+g.pad]
+}
+
+func contextHeights(chunkContext ContextedChunkData, pad int) heightGrid {
+	pad = clampInt(pad, 0, 16)
+	w := 16 + 2*pad
+	g := heightGrid{pad: pad, h: make([]int, w*w)}
+	neighbours := [3][3]*save.Chunk{
+		{chunkContext.topLeft, chunkContext.top, chunkContext.topRight},
+		{chunkContext.left, chunkContext.center, chunkContext.right},
+		{chunkContext.bottomLeft, chunkContext.bottom, chunkContext.bottomRight},
+	}
+	var maps [3][3][]int
+	for nz := range neighbours {
+		for nx, c := range neighbours[nz] {
+			if c != nil && (pad > 0 || c == chunkContext.center) {
+				maps[nz][nx] = genHeightmap(c)
+			}
+		}
+	}
+	for z := -pad; z < 16+pad; z++ {
+		for x := -pad; x < 16+pad; x++ {
+			nx, nz := floorDiv(x, 16)+1, floorDiv(z, 16)+1
+			hm := maps[nz][nx]
+			lx, lz := x-(nx-1)*16, z-(nz-1)*16
+			if hm == nil {
+				hm = maps[1][1]
+				lx, lz = clampInt(x, 0, 15), clampInt(z, 0, 15)
+			}
+			g.h[(z+pad)*w+x+pad] = hm[lz*16+lx]
+		}
+	}
+	return g
+}
+
+// light comes from north-west, slopes facing away from it are darkened
+// and terrain higher than the sun line casts soft shadow that carries
+// over chunk borders
+func drawChunkShading(chunkContext ContextedChunkData) (img *image.RGBA) {
+	t := time.Now()
+	img = image.NewRGBA(image.Rect(0, 0, 16, 16))
+	length := clampInt(cfg.GetDSInt(8, "layers", "shading", "shadow_length"), 0, 16)
+	h := contextHeights(chunkContext, maxInt(length, 1))
+	for z := 0; z < 16; z++ {
+		for x := 0; x < 16; x++ {
+			hc := h.at(x, z)
+			// smoothed rise of the neighbours towards the light
+			d := 0.0
+			for _, n := range [][2]int{{-1, 0}, {0, -1}, {-1, -1}} {
+				if r := h.at(x+n[0], z+n[1]) - hc; r > 0 {
+					d += math.Min(float64(r), 4) * 6
+				}
+			}
+			// columns blocking the sun, closer ones cast darker shadow
+			shadow := 0.0
+			for k := 1; k <= length; k++ {
+				if h.at(x-k, z-k)-hc > k {
+					shadow = math.Max(shadow, 1-float64(k-1)/float64(length))
+				}
+			}
+			a := math.Min(d+shadow*56, 96)
+			img.Set(x, z, color.RGBA{0, 0, 0, uint8(a)})
+		}
+	}
+	appendMetrics(time.Since(t), "shading")
+	return img
+}
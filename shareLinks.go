@@ -0,0 +1,204 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// with web.access_token set instance is private, everything but static files
+// needs the token (header or cookie set by /login) or a signed link
+func accessMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := cfg.GetDSString("", "web", "access_token")
+		if token == "" || accessExempt(r.URL.Path) || hasAccessToken(r, token) || validShareLink(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		http.Error(w, "Access token or signed link required", http.StatusUnauthorized)
+	})
+}
+
+func accessExempt(p string) bool {
+	return p == "/login" || p == "/favicon.ico" || p == "/robots.txt" || strings.HasPrefix(p, "/static/")
+}
+
+func hasAccessToken(r *http.Request, token string) bool {
+	got, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if c, err := r.Cookie("webchunk_token"); got == "" && err == nil {
+		got = c.Value
+	}
+	return got != "" && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+func loginPageHandler(w http.ResponseWriter, r *http.Request) {
+	templateRespond("login", w, r, map[string]any{})
+}
+
+// puts the token into a cookie so browser can be used with private instance,
+// token is only taken from post body so it does not end up in access log
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	token := cfg.GetDSString("", "web", "access_token")
+	got := r.PostFormValue("token")
+	if token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+		plainmsg(w, r, plainmsgColorRed, "Wrong access token")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     "webchunk_token",
+		Value:    got,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		MaxAge:   cfg.GetDSInt(30*24*60*60, "web", "login_max_age"),
+	})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// only images can be shared, not pages or api
+var shareablePaths = []*regexp.Regexp{
+	regexp.MustCompile(`^/worlds/[^/]+/[^/]+/tiles/`),
+	regexp.MustCompile(`^/xyz/[^/]+/[^/]+/[^/]+/`),
+	regexp.MustCompile(`^/maps/[^/]+/[0-9]+\.png$`),
+}
+
+func shareable(p string) bool {
+	if strings.Contains(p, "/../") || strings.HasSuffix(p, "/..") {
+		return false
+	}
+	for _, re := range shareablePaths {
+		if re.MatchString(p) {
+			return true
+		}
+	}
+	return false
+}
+
+var shareSigningKeyLock sync.Mutex
+
+// changing the key revokes every link given out
+func shareSigningKey() []byte {
+	shareSigningKeyLock.Lock()
+	defer shareSigningKeyLock.Unlock()
+	key, err := hex.DecodeString(cfg.GetDSString("", "web", "signing_key"))
+	if err == nil && len(key) >= 32 {
+		return key
+	}
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		log.Printf("Failed to generate link signing key: %s", err.Error())
+	}
+	cfg.Set(hex.EncodeToString(key), "web", "signing_key")
+	if err := saveConfig(); err != nil {
+		log.Printf("Failed to save config with new link signing key: %s", err.Error())
+	}
+	return key
+}
+
+func shareSignature(scope, p string, expires int64) string {
+	m := hmac.New(sha256.New, shareSigningKey())
+	fmt.Fprintf(m, "%s\n%s\n%d", scope, p, expires)
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+// link is either for exact path or for everything under prefix ending with slash
+func validShareLink(r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	q := r.URL.Query()
+	sig := q.Get("share_sig")
+	expires, err := strconv.ParseInt(q.Get("share_expires"), 10, 64)
+	if sig == "" || err != nil || time.Now().Unix() > expires || !shareable(r.URL.Path) {
+		return false
+	}
+	scope, p := "path", r.URL.Path
+	if prefix := q.Get("share_prefix"); prefix != "" {
+		if !strings.HasSuffix(prefix, "/") || !strings.HasPrefix(r.URL.Path, prefix) {
+			return false
+		}
+		scope, p = "prefix", prefix
+	}
+	return hmac.Equal([]byte(sig), []byte(shareSignature(scope, p, expires)))
+}
+
+type shareLink struct {
+	URL     string
+	Expires time.Time
+}
+
+// signs path (or prefix=true for every tile under it) for ttl seconds
+func apiCreateShareLink(w http.ResponseWriter, r *http.Request) (int, string) {
+	p := r.FormValue("path")
+	if !strings.HasPrefix(p, "/") || !shareable(p) {
+		return 400, "Only tiles and map images can be shared"
+	}
+	ttl := 24 * 60 * 60
+	if v := r.FormValue("ttl"); v != "" {
+		var err error
+		ttl, err = strconv.Atoi(v)
+		if err != nil || ttl <= 0 {
+			return 400, "Bad ttl"
+		}
+	}
+	if maxTTL := cfg.GetDSInt(30*24*60*60, "web", "share_max_ttl"); ttl > maxTTL {
+		return 400, fmt.Sprintf("Links can not live longer than %d seconds", maxTTL)
+	}
+	expires := time.Now().Add(time.Duration(ttl) * time.Second).Truncate(time.Second)
+	q := url.Values{}
+	q.Set("share_expires", strconv.FormatInt(expires.Unix(), 10))
+	if prefix, _ := strconv.ParseBool(r.FormValue("prefix")); prefix {
+		if !strings.HasSuffix(p, "/") {
+			return 400, "Prefix has to end with a slash"
+		}
+		q.Set("share_prefix", p)
+		q.Set("share_sig", shareSignature("prefix", p, expires.Unix()))
+	} else {
+		q.Set("share_sig", shareSignature("path", p, expires.Unix()))
+	}
+	setContentTypeJson(w)
+	return marshalOrFail(200, shareLink{
+		URL:     requestBaseURL(r) + p + "?" + q.Encode(),
+		Expires: expires,
+	})
+}
+
+// scheme and host links given out in responses point to
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.URL.Scheme == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
@@ -0,0 +1,260 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/maxsupermanhd/WebChunk/proxy"
+	"github.com/maxsupermanhd/go-vmc/v764/chat"
+	"github.com/maxsupermanhd/go-vmc/v764/level/block"
+	"github.com/maxsupermanhd/go-vmc/v764/nbt"
+)
+
+type signRecord struct {
+	Time    time.Time
+	Player  string
+	X, Y, Z int
+	Front   []string
+	Back    []string `json:",omitempty"`
+}
+
+type signSearchResult struct {
+	signRecord
+	World     string
+	Dimension string
+	MapURL    string
+}
+
+type signIndexKey struct {
+	world, dimension string
+}
+
+// latest known text of every sign, used to not record same sign every time chunk is loaded
+var (
+	signIndex     = map[signIndexKey]map[[3]int]signRecord{}
+	signIndexLock sync.Mutex
+)
+
+type signNBTText struct {
+	Messages []string `nbt:"messages"`
+}
+
+type signNBT struct {
+	FrontText signNBTText `nbt:"front_text"`
+	BackText  signNBTText `nbt:"back_text"`
+	// before 1.20 there was only one side
+	Text1, Text2, Text3, Text4 string
+}
+
+func isSignBlockEntity(t int32) bool {
+	if t < 0 || int(t) >= len(block.EntityList) {
+		return false
+	}
+	switch block.EntityList[t].ID() {
+	case "minecraft:sign", "minecraft:hanging_sign":
+		return true
+	default:
+		return false
+	}
+}
+
+func signLinesToText(lines []string) []string {
+	ret := make([]string, len(lines))
+	for i, l := range lines {
+		var m chat.Message
+		if err := json.Unmarshal([]byte(l), &m); err != nil {
+			ret[i] = l
+		} else {
+			ret[i] = m.ClearString()
+		}
+	}
+	return ret
+}
+
+func signLinesEmpty(lines []string) bool {
+	for _, l := range lines {
+		if strings.TrimSpace(l) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func decodeSign(data nbt.RawMessage) (front, back []string, err error) {
+	var s signNBT
+	err = data.Unmarshal(&s)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(s.FrontText.Messages) == 0 {
+		return signLinesToText([]string{s.Text1, s.Text2, s.Text3, s.Text4}), nil, nil
+	}
+	front = signLinesToText(s.FrontText.Messages)
+	back = signLinesToText(s.BackText.Messages)
+	if signLinesEmpty(back) {
+		back = nil
+	}
+	return front, back, nil
+}
+
+func stringSlicesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// must be called with signIndexLock held
+func getSignIndex(wname, dname string) map[[3]int]signRecord {
+	k := signIndexKey{world: wname, dimension: dname}
+	idx, ok := signIndex[k]
+	if ok {
+		return idx
+	}
+	idx = map[[3]int]signRecord{}
+	err := recs.Read(wname, dname, "signs", func(m json.RawMessage) error {
+		var s signRecord
+		if json.Unmarshal(m, &s) == nil {
+			idx[[3]int{s.X, s.Y, s.Z}] = s
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to load sign records of %s %s: %s", wname, dname, err.Error())
+	}
+	signIndex[k] = idx
+	return idx
+}
+
+func captureSign(wname, dname, player string, x, y, z int, t int32, data nbt.RawMessage) {
+	if !isSignBlockEntity(t) {
+		return
+	}
+	front, back, err := decodeSign(data)
+	if err != nil {
+		log.Printf("Failed to decode sign at %d %d %d: %s", x, y, z, err.Error())
+		return
+	}
+	dname = strings.TrimPrefix(dname, "minecraft:")
+	rec := signRecord{
+		Time:   time.Now(),
+		Player: player,
+		X:      x,
+		Y:      y,
+		Z:      z,
+		Front:  front,
+		Back:   back,
+	}
+	signIndexLock.Lock()
+	defer signIndexLock.Unlock()
+	idx := getSignIndex(wname, dname)
+	pos := [3]int{x, y, z}
+	if prev, ok := idx[pos]; ok && stringSlicesEqual(prev.Front, front) && stringSlicesEqual(prev.Back, back) {
+		return
+	}
+	idx[pos] = rec
+	if err := recs.Append(wname, dname, "signs", rec); err != nil {
+		log.Printf("Failed to record sign: %s", err.Error())
+	}
+}
+
+func captureChunkSigns(r *proxy.ProxiedChunk) {
+	for _, be := range r.Data.BlockEntity {
+		x := int(r.Pos[0])*16 + int(uint8(be.XZ)>>4)
+		z := int(r.Pos[1])*16 + int(be.XZ&15)
+		captureSign(r.Server, r.Dimension, r.Username, x, int(be.Y), z, int32(be.Type), be.Data)
+	}
+}
+
+func searchSigns(wname, query string, names playerNamer) []signSearchResult {
+	terms := strings.Fields(strings.ToLower(query))
+	ret := []signSearchResult{}
+	dims := listNamesWnD()[wname]
+	signIndexLock.Lock()
+	defer signIndexLock.Unlock()
+	for _, dname := range dims {
+		for _, s := range getSignIndex(wname, dname) {
+			text := strings.ToLower(strings.Join(s.Front, " ") + " " + strings.Join(s.Back, " "))
+			matches := true
+			for _, t := range terms {
+				if !strings.Contains(text, t) {
+					matches = false
+					break
+				}
+			}
+			if !matches || strings.TrimSpace(text) == "" {
+				continue
+			}
+			s.Player = names(s.Player)
+			ret = append(ret, signSearchResult{
+				signRecord: s,
+				World:      wname,
+				Dimension:  dname,
+				MapURL:     "/view?world=" + url.QueryEscape(wname) + "&dim=" + url.QueryEscape(dname) + "&x=" + strconv.Itoa(s.X) + "&z=" + strconv.Itoa(s.Z),
+			})
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Time.After(ret[j].Time)
+	})
+	return ret
+}
+
+func apiSearchSigns(w http.ResponseWriter, r *http.Request) (int, string) {
+	params := mux.Vars(r)
+	setContentTypeJson(w)
+	return marshalOrFail(200, searchSigns(params["world"], r.FormValue("q"), playerNamerFor(r)))
+}
+
+func signsHandler(w http.ResponseWriter, r *http.Request) {
+	worlds := []string{}
+	for wname := range listNamesWnD() {
+		worlds = append(worlds, wname)
+	}
+	sort.Strings(worlds)
+	wname := r.FormValue("world")
+	query := r.FormValue("q")
+	var results []signSearchResult
+	if wname != "" {
+		results = searchSigns(wname, query, playerNamerFor(r))
+	}
+	templateRespond("signs", w, r, map[string]any{
+		"Worlds":  worlds,
+		"World":   wname,
+		"Query":   query,
+		"Results": results,
+	})
+}
@@ -0,0 +1,217 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+type cachedHead struct {
+	png     []byte
+	fetched time.Time
+}
+
+var (
+	skinTextures = map[uuid.UUID]string{}
+	skinHeads    = map[uuid.UUID]cachedHead{}
+	skinsLock    sync.Mutex
+	skinsClient  = http.Client{Timeout: 10 * time.Second}
+)
+
+func rememberSkinTextures(id uuid.UUID, textures string) {
+	skinsLock.Lock()
+	if skinTextures[id] != textures {
+		skinTextures[id] = textures
+		delete(skinHeads, id)
+	}
+	skinsLock.Unlock()
+}
+
+// textures property is base64 of {"textures": {"SKIN": {"url": "..."}}}
+func skinURLFromTextures(textures string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(textures)
+	if err != nil {
+		return "", err
+	}
+	var t struct {
+		Textures struct {
+			Skin struct {
+				URL string `json:"url"`
+			} `json:"SKIN"`
+		} `json:"textures"`
+	}
+	if err := json.Unmarshal(b, &t); err != nil {
+		return "", err
+	}
+	if t.Textures.Skin.URL == "" {
+		return "", errors.New("no skin in textures")
+	}
+	// only Mojang texture server, urls come from whatever server player is on
+	u, err := url.Parse(t.Textures.Skin.URL)
+	if err != nil {
+		return "", err
+	}
+	if u.Host != "textures.minecraft.net" {
+		return "", fmt.Errorf("skin is hosted on unknown host %q", u.Host)
+	}
+	return u.String(), nil
+}
+
+// profiles of players not seen in tab list are asked from session server
+func fetchProfileTextures(id uuid.UUID) (string, error) {
+	resp, err := skinsClient.Get("https://sessionserver.mojang.com/session/minecraft/profile/" + id.String())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("session server responded with %s", resp.Status)
+	}
+	var profile struct {
+		Properties []struct {
+			Name  string `json:"name"`
+			Value string `json:"value"`
+		} `json:"properties"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&profile); err != nil {
+		return "", err
+	}
+	for _, p := range profile.Properties {
+		if p.Name == "textures" {
+			return p.Value, nil
+		}
+	}
+	return "", errors.New("profile has no textures")
+}
+
+// face with hat layer on top scaled up 8 times
+func renderSkinHead(skin image.Image) []byte {
+	face := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	draw.Draw(face, face.Rect, skin, image.Pt(8, 8), draw.Src)
+	if skin.Bounds().Dx() >= 48 {
+		draw.Draw(face, face.Rect, skin, image.Pt(40, 8), draw.Over)
+	}
+	head := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			head.SetRGBA(x, y, face.RGBAAt(x/8, y/8))
+		}
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, head)
+	return buf.Bytes()
+}
+
+func fetchSkinHead(id uuid.UUID) ([]byte, error) {
+	skinsLock.Lock()
+	textures := skinTextures[id]
+	skinsLock.Unlock()
+	if textures == "" {
+		var err error
+		textures, err = fetchProfileTextures(id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	u, err := skinURLFromTextures(textures)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := skinsClient.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("texture server responded with %s", resp.Status)
+	}
+	skin, err := png.Decode(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if skin.Bounds().Dx() < 16 || skin.Bounds().Dy() < 16 {
+		return nil, errors.New("skin is too small")
+	}
+	return renderSkinHead(skin), nil
+}
+
+// failed fetches are cached too so offline servers do not hammer Mojang
+func getSkinHead(id uuid.UUID) []byte {
+	skinsLock.Lock()
+	h, ok := skinHeads[id]
+	skinsLock.Unlock()
+	if ok {
+		retry := time.Duration(cfg.GetDSInt(3600, "skins_refresh")) * time.Second
+		if h.png == nil {
+			retry = 5 * time.Minute
+		}
+		if time.Since(h.fetched) < retry {
+			return h.png
+		}
+	}
+	if !cfg.GetDSBool(true, "skins_fetch") {
+		return nil
+	}
+	data, err := fetchSkinHead(id)
+	if err != nil {
+		data = nil
+	}
+	skinsLock.Lock()
+	skinHeads[id] = cachedHead{png: data, fetched: time.Now()}
+	skinsLock.Unlock()
+	return data
+}
+
+func apiPlayerHead(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(mux.Vars(r)["uuid"])
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	// uuids are fake anyway, no need to ask mojang about them
+	if privacyHides(r) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	data := getSkinHead(id)
+	if data == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "max-age=3600")
+	w.Write(data)
+}
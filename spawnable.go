@@ -0,0 +1,156 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/maxsupermanhd/go-vmc/v764/level/block"
+	"github.com/maxsupermanhd/go-vmc/v764/save"
+)
+
+var (
+	spawnableAlways = color.RGBA{0xd0, 0x20, 0x20, 0xa0}
+	spawnableNight  = color.RGBA{0xe0, 0xa0, 0x20, 0x70}
+)
+
+// blocks mobs can not spawn on, this is an approximation since there is
+// no collision shape data, anything that is not a full block goes here
+var spawnUnsafeFloors = []string{
+	"glass", "leaves", "slab", "stairs", "fence", "wall", "carpet", "pane", "bars",
+	"door", "_bed", "chest", "sign", "banner", "button", "pressure_plate", "rail",
+	"torch", "lantern", "campfire", "farmland", "dirt_path", "magma_block", "barrier",
+	"piston", "hopper", "cauldron", "anvil", "scaffolding", "honey_block",
+	"powder_snow", "candle",
+}
+
+// blocks mob can spawn inside of
+func isSpawnPassable(state block.StateID) bool {
+	if isAirState(state) {
+		return true
+	}
+	switch stateBlock(state).(type) {
+	case block.Grass, block.TallGrass, block.Fern, block.LargeFern, block.DeadBush,
+		block.Snow, block.Vine, block.RedstoneWire, block.Rail, block.Lever:
+		return true
+	}
+	id := stateName(state)
+	return strings.HasSuffix(id, "_sapling") || strings.HasSuffix(id, "_button") ||
+		strings.HasSuffix(id, "_pressure_plate") || strings.HasSuffix(id, "_mushroom") ||
+		strings.HasSuffix(id, "_tulip") || isFlowerID(id)
+}
+
+func isFlowerID(id string) bool {
+	switch strings.TrimPrefix(id, "minecraft:") {
+	case "dandelion", "poppy", "blue_orchid", "allium", "azure_bluet", "oxeye_daisy",
+		"cornflower", "lily_of_the_valley", "wither_rose", "sunflower", "lilac",
+		"rose_bush", "peony", "torchflower", "pink_petals":
+		return true
+	}
+	return false
+}
+
+func isSpawnFloor(state block.StateID) bool {
+	switch stateBlock(state).(type) {
+	case block.Water, block.Lava, block.BubbleColumn:
+		return false
+	}
+	id := stateName(state)
+	for _, s := range spawnUnsafeFloors {
+		if strings.Contains(id, s) {
+			return false
+		}
+	}
+	return true
+}
+
+// light arrays are nibbles in the same order as block states
+func sectionLight(arr []byte, i int) int {
+	if len(arr) != 2048 {
+		return -1
+	}
+	return int(arr[i/2]>>((i%2)*4)) & 0xf
+}
+
+// surface where hostile mob can spawn with block light at 0, red if there is
+// no sky light either so it is dark all day, yellow if it only spawns at night
+func drawChunkSpawnable(chunk *save.Chunk) (img *image.RGBA) {
+	t := time.Now()
+	img = image.NewRGBA(image.Rect(0, 0, 16, 16))
+	hasLight := false
+	for _, s := range chunk.Sections {
+		if len(s.BlockLight) != 0 || len(s.SkyLight) != 0 {
+			hasLight = true
+			break
+		}
+	}
+	if !hasLight {
+		return img
+	}
+	sortSectionsTopDown(chunk)
+	var done [16 * 16]bool
+	var blockLight, skyLight [16 * 16]int
+	for i := range skyLight {
+		skyLight[i] = 15
+	}
+	for _, s := range chunk.Sections {
+		if len(s.BlockStates.Palette) == 0 {
+			continue
+		}
+		states := prepareSectionBlockstates(&s)
+		if states == nil {
+			if os.Getenv("REPORT_CHUNK_PROBLEMS") == "yes" || os.Getenv("REPORT_CHUNK_PROBLEMS") == "all" {
+				log.Printf("Chunk %d:%d section %d has broken pallete", chunk.XPos, chunk.YPos, s.Y)
+			}
+			continue
+		}
+		for y := 15; y >= 0; y-- {
+			for i := 16*16 - 1; i >= 0; i-- {
+				if done[i] {
+					continue
+				}
+				ii := y*16*16 + i
+				state := states.Get(ii)
+				if isSpawnPassable(state) {
+					blockLight[i] = maxInt(sectionLight(s.BlockLight, ii), 0)
+					skyLight[i] = maxInt(sectionLight(s.SkyLight, ii), 0)
+					continue
+				}
+				done[i] = true
+				if !isSpawnFloor(state) || blockLight[i] > 0 {
+					continue
+				}
+				if skyLight[i] == 0 {
+					img.Set(i%16, i/16, spawnableAlways)
+				} else {
+					img.Set(i%16, i/16, spawnableNight)
+				}
+			}
+		}
+	}
+	appendMetrics(time.Since(t), "spawnable")
+	return img
+}
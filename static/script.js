@@ -33,3 +33,15 @@ function addDimension(sendname, sendalias, sendminy, sendserver) {
 	XHR.open('POST', 'http://'+window.location.host+'/api/dims');
 	XHR.send(FD);
 }
+
+// pages fire webchunk:<name> events, hooks from frontend config listen to them
+var webchunkFrontend = {};
+function webchunkHook(name, detail) {
+	document.dispatchEvent(new CustomEvent('webchunk:' + name, {detail: detail}));
+}
+document.addEventListener('DOMContentLoaded', function() {
+	for (const [name, body] of Object.entries(webchunkFrontend.hooks || {})) {
+		const f = new Function('detail', body);
+		document.addEventListener('webchunk:' + name, function(e) { f(e.detail); });
+	}
+});
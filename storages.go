@@ -34,23 +34,72 @@ import (
 
 var (
 	errStorageTypeNotImplemented = errors.New("storage type not implemented")
-	storages                     map[string]chunkStorage.Storage
-	storagesLock                 sync.Mutex
+	storages                     = &storageRegistry{m: map[string]chunkStorage.Storage{}}
 )
 
+// storages can be added and reinitialized from the api while everything else
+// reads them, readers work on a copy so driver calls are not done under lock
+type storageRegistry struct {
+	lock sync.RWMutex
+	m    map[string]chunkStorage.Storage
+}
+
+func (r *storageRegistry) Snapshot() map[string]chunkStorage.Storage {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	ret := make(map[string]chunkStorage.Storage, len(r.m))
+	for k, v := range r.m {
+		ret[k] = v
+	}
+	return ret
+}
+
+func (r *storageRegistry) Get(name string) (chunkStorage.Storage, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	s, ok := r.m[name]
+	return s, ok
+}
+
+// f is called with registry locked and can change it in place
+func (r *storageRegistry) Modify(f func(m map[string]chunkStorage.Storage) error) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return f(r.m)
+}
+
+func (r *storageRegistry) World(wname string) (*chunkStorage.SWorld, chunkStorage.ChunkStorage, error) {
+	return chunkStorage.GetWorldStorage(r.Snapshot(), wname)
+}
+
+func (r *storageRegistry) Dimensions(wname string) ([]chunkStorage.SDim, error) {
+	return chunkStorage.ListDimensions(r.Snapshot(), wname)
+}
+
+func (r *storageRegistry) Capable(pref string) chunkStorage.ChunkStorage {
+	return findCapableStorage(r.Snapshot(), pref)
+}
+
+func (r *storageRegistry) Close() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	chunkStorage.CloseStorages(r.m)
+}
+
 func storagesInit() error {
 	log.Println("Initializing storages...")
-	err := cfg.GetToStruct(&storages, "storages")
+	loaded := map[string]chunkStorage.Storage{}
+	err := cfg.GetToStruct(&loaded, "storages")
 	if err != nil && !errors.Is(err, lac.ErrNoKey) {
 		return err
 	}
-	if len(storages) == 0 {
+	if len(loaded) == 0 {
 		log.Println("No storages to initialize")
 		cfg.Set(map[string]any{}, "storages")
 		return nil
 	}
-	for k, v := range storages {
-		d, err := newStorage(storages[k].Type, storages[k].Address)
+	for k, v := range loaded {
+		d, err := newStorage(v.Type, v.Address)
 		if err != nil {
 			log.Println("Failed to initialize storage: " + err.Error())
 			continue
@@ -61,10 +110,15 @@ func storagesInit() error {
 			continue
 		}
 		v.Driver = d
-		storages[k] = v
+		loaded[k] = v
 		log.Println("Storage initialized: " + ver)
 	}
-	return nil
+	return storages.Modify(func(m map[string]chunkStorage.Storage) error {
+		for k, v := range loaded {
+			m[k] = v
+		}
+		return nil
+	})
 }
 
 func newStorage(storageStype, address string) (driver chunkStorage.ChunkStorage, err error) {
@@ -105,9 +159,7 @@ func findCapableStorage(storages map[string]chunkStorage.Storage, pref string) c
 
 func listNamesWnD() map[string][]string {
 	worlds := map[string][]string{}
-	storagesLock.Lock()
-	defer storagesLock.Unlock()
-	for _, storage := range storages {
+	for _, storage := range storages.Snapshot() {
 		if storage.Driver == nil {
 			continue
 		}
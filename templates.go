@@ -72,6 +72,7 @@ var templatesFuncs = template.FuncMap{
 	"getTypeString": func(a any) string {
 		return reflect.TypeOf(a).String()
 	},
+	"frontend": getFrontendConfig,
 }
 
 func robotsHandler(w http.ResponseWriter, _ *http.Request) {
@@ -83,9 +84,10 @@ func faviconHandler(w http.ResponseWriter, r *http.Request) {
 
 func plainmsg(w http.ResponseWriter, r *http.Request, color int, msg string) {
 	templateRespond("plainmsg", w, r, map[string]interface{}{
-		"msgred":   color == plainmsgColorRed,
-		"msggreen": color == plainmsgColorGreen,
-		"msg":      msg})
+		"msgred":    color == plainmsgColorRed,
+		"msggreen":  color == plainmsgColorGreen,
+		"msg":       msg,
+		"requestid": requestID(r)})
 }
 
 func templateManager(exitchan <-chan struct{}, cfg *lac.ConfSubtree) {
@@ -24,6 +24,7 @@ import (
 	_ "embed"
 	"encoding/base64"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"html/template"
 	"image"
@@ -32,10 +33,9 @@ import (
 	"log"
 	"net/http"
 	"os"
-	"sort"
 	"strconv"
 	"strings"
-	_ "sync"
+	"sync"
 	"time"
 
 	"github.com/davecgh/go-spew/spew"
@@ -45,6 +45,7 @@ import (
 	"github.com/maxsupermanhd/go-vmc/v764/level"
 	"github.com/maxsupermanhd/go-vmc/v764/level/block"
 	"github.com/maxsupermanhd/go-vmc/v764/save"
+	"github.com/maxsupermanhd/lac"
 )
 
 type metricsCollect struct {
@@ -60,6 +61,7 @@ type metricsMeasure struct {
 var (
 	metricsSend = make(chan metricsCollect, 1024)
 	metrics     = map[string]metricsMeasure{}
+	metricsLock sync.Mutex
 )
 
 func metricsDispatcher(exitchan <-chan struct{}) {
@@ -72,6 +74,7 @@ func metricsDispatcher(exitchan <-chan struct{}) {
 				log.Println("Metrix send channel closed!")
 				return
 			}
+			metricsLock.Lock()
 			d, ok := metrics[m.m]
 			if ok {
 				d.count++
@@ -80,6 +83,7 @@ func metricsDispatcher(exitchan <-chan struct{}) {
 			} else {
 				metrics[m.m] = metricsMeasure{sum: m.t, count: 1}
 			}
+			metricsLock.Unlock()
 			if ok && d.count%200 == 0 {
 				log.Println("Chunk", m.m, "rendering metrics", time.Duration(d.sum.Nanoseconds()/d.count).String(), "per chunk (total", d.count, ")")
 			}
@@ -92,7 +96,7 @@ func appendMetrics(t time.Duration, m string) {
 }
 
 func isAirState(s block.StateID) bool {
-	switch block.StateList[s].(type) {
+	switch stateBlock(s).(type) {
 	case block.Air, block.CaveAir, block.VoidAir:
 		return true
 	default:
@@ -100,6 +104,17 @@ func isAirState(s block.StateID) bool {
 	}
 }
 
+func isAirPalette(p []save.BlockState) bool {
+	if len(p) != 1 {
+		return false
+	}
+	switch strings.TrimPrefix(p[0].Name, "minecraft:") {
+	case "air", "cave_air", "void_air":
+		return true
+	}
+	return false
+}
+
 func prepareSectionBlockstates(s *save.Section) *level.PaletteContainer[block.StateID] {
 	statePalette := s.BlockStates.Palette
 	stateRawPalette := make([]block.StateID, len(statePalette))
@@ -108,6 +123,10 @@ func prepareSectionBlockstates(s *save.Section) *level.PaletteContainer[block.St
 		if !ok {
 			b, ok = block.FromID["minecraft:"+v.Name]
 			if !ok {
+				if rs, ok := registryState(v.Name); ok {
+					stateRawPalette[i] = rs
+					continue
+				}
 				return nil
 			}
 		}
@@ -132,6 +151,10 @@ func prepareSectionBlockIDs(s *save.Section) *level.PaletteContainer[block.State
 		if !ok {
 			b, ok = block.FromID["minecraft:"+v.Name]
 			if !ok {
+				if rs, ok := registryState(v.Name); ok {
+					stateRawPalette[i] = rs
+					continue
+				}
 				// log.Printf("Can not find block from id [%v]", v.Name)
 				return nil
 			}
@@ -154,39 +177,80 @@ func prepareSectionBiomes(s *save.Section) *level.PaletteContainer[level.BiomesS
 	return level.NewBiomesPaletteContainerWithData(4*4*4, s.Biomes.Data, rawp)
 }
 
-func drawChunkBiomes(chunk *save.Chunk) (img *image.RGBA) {
-	img = image.NewRGBA(image.Rect(0, 0, 4, 4))
-	topY := 0
-	topI := 0
-	for i, v := range chunk.Sections {
-		if v.Y > int8(topY) {
-			topI = i
-			topY = int(v.Y)
+// 3d biomes are taken at the surface of every column, biome cells are 4 blocks wide,
+// -1 where biome is not known
+func surfaceBiomes(chunk *save.Chunk) []int {
+	ret := make([]int, 16*16)
+	for i := range ret {
+		ret[i] = -1
+	}
+	if len(chunk.Sections) == 0 {
+		return ret
+	}
+	heights := genHeightmap(chunk)
+	// sections are sorted top down by genHeightmap, top one is used above the surface
+	sections := map[int]*level.PaletteContainer[level.BiomesState]{}
+	getSection := func(y int) *level.PaletteContainer[level.BiomesState] {
+		if c, ok := sections[y]; ok {
+			return c
+		}
+		var c *level.PaletteContainer[level.BiomesState]
+		for i := range chunk.Sections {
+			if int(int8(chunk.Sections[i].Y)) == y && len(chunk.Sections[i].Biomes.Palette) > 0 {
+				c = prepareSectionBiomes(&chunk.Sections[i])
+				break
+			}
 		}
+		sections[y] = c
+		return c
 	}
-	s := chunk.Sections[topI]
-	c := prepareSectionBiomes(&s)
-	for i := 0; i < 4*4; i++ {
-		biomeid := int(c.Get(i))
-		if biomeid >= 0 && biomeid < len(biomes.BiomeColors) {
-			img.Set(i%4, i/4, biomes.BiomeColors[biomeid])
-		} else {
-			log.Println("Unknown biome!")
+	for i := 0; i < 16*16; i++ {
+		x, z := i%16, i/16
+		y := heights[i]
+		c := getSection(floorDiv(y, 16))
+		if c == nil {
+			y = int(int8(chunk.Sections[0].Y))*16 + 15
+			c = getSection(int(int8(chunk.Sections[0].Y)))
+			if c == nil {
+				continue
+			}
+		}
+		ret[i] = int(c.Get((y-floorDiv(y, 16)*16)/4*16 + z/4*4 + x/4))
+	}
+	return ret
+}
+
+func drawChunkBiomes(chunk *save.Chunk) (img *image.RGBA) {
+	img = image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for i, biomeid := range surfaceBiomes(chunk) {
+		if biomeid >= 0 && biomeid < len(biomeColors) {
+			img.Set(i%16, i/16, biomeColors[biomeid])
 		}
 	}
 	return img
 }
 
-func drawChunkHeightmap(chunk *save.Chunk) (img *image.RGBA) {
+func heightmapProvider(ymin, ymax int) ttypeProviderFunc {
+	return func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
+		return withHeightRange(s, s.GetChunksRegion), func(i interface{}) *image.RGBA {
+			c := i.(rangedChunk)
+			return drawChunkHeightmap(&c.chunk, c.hr, ymin, ymax)
+		}
+	}
+}
+
+// blocks outside of ymin..ymax are skipped, gradient still covers whole world height
+func drawChunkHeightmap(chunk *save.Chunk, hr heightRange, ymin, ymax int) (img *image.RGBA) {
 	t := time.Now()
+	gradient := getHeightGradient(hr)
 	img = image.NewRGBA(image.Rect(0, 0, 16, 16))
 	defaultColor := color.RGBA{0, 0, 0, 255}
 	draw.Draw(img, img.Bounds(), &image.Uniform{defaultColor}, image.Point{}, draw.Src)
-	sort.Slice(chunk.Sections, func(i, j int) bool {
-		return int8(chunk.Sections[i].Y) > int8(chunk.Sections[j].Y)
-	})
+	sortSectionsTopDown(chunk)
+	var done [16 * 16]bool
 	for _, s := range chunk.Sections {
-		if len(s.BlockStates.Data) == 0 {
+		sy := int(int8(s.Y)) * 16
+		if sy > ymax || sy+15 < ymin || len(s.BlockStates.Palette) == 0 || isAirPalette(s.BlockStates.Palette) {
 			continue
 		}
 		states := prepareSectionBlockstates(&s)
@@ -196,24 +260,14 @@ func drawChunkHeightmap(chunk *save.Chunk) (img *image.RGBA) {
 			}
 			continue
 		}
-		for y := 15; y >= 0; y-- {
-			layerImg := image.NewRGBA(image.Rect(0, 0, 16, 16))
+		for y := minInt(15, ymax-sy); y >= maxInt(0, ymin-sy); y-- {
 			for i := 16*16 - 1; i >= 0; i-- {
-				if img.At(i%16, i/16) != defaultColor {
+				if done[i] || isAirState(states.Get(y*16*16+i)) {
 					continue
 				}
-				state := states.Get(y*16*16 + i)
-				// block := block.StateList[state]
-				if !isAirState(state) {
-					absy := uint8(int(s.Y)*16 + y)
-					layerImg.Set(i%16, i/16, color.RGBA{absy, absy, 255, 255})
-				}
+				done[i] = true
+				img.Set(i%16, i/16, gradient.at(sy+y))
 			}
-			draw.Draw(
-				img, image.Rect(0, 0, 16, 16),
-				layerImg, image.Pt(0, 0),
-				draw.Over,
-			)
 		}
 	}
 	appendMetrics(time.Since(t), "heightmap")
@@ -227,58 +281,8 @@ func drawShadedTerrain(chunkContext ContextedChunkData) *image.RGBA {
 	return img
 }
 
-func drawChunkShading(chunkContext ContextedChunkData) (img *image.RGBA) {
-	t := time.Now()
-	img = image.NewRGBA(image.Rect(0, 0, 16, 16))
-	defaultColor := color.RGBA{0, 0, 0, 0}
-	draw.Draw(img, img.Bounds(), &image.Uniform{defaultColor}, image.Point{}, draw.Src)
-	// TODO: generating heightmap must be done on storage/proxy level, not here and 3 times per chunk
-	hmc := genHeightmap(chunkContext.center)
-	var hmr []int
-	if chunkContext.right != nil {
-		hmr = genHeightmap(chunkContext.right)
-	}
-	var hmt []int
-	if chunkContext.top != nil {
-		hmt = genHeightmap(chunkContext.top)
-	}
-	for i := 0; i < 16*16; i++ {
-		hc := hmc[i]
-		ht := -1
-		hr := -1
-		if i%16 == 15 {
-			if chunkContext.right != nil {
-				hr = hmr[i-15]
-			} else {
-				img.Set(i%16, i/16, color.RGBA{255, 0, 0, 255})
-			}
-		} else {
-			hr = hmc[i+1]
-		}
-		if i < 16 {
-			if chunkContext.top != nil {
-				ht = hmt[16*15+i]
-			} else {
-				img.Set(i%16, i/16, color.RGBA{0, 255, 0, 255})
-			}
-		} else {
-			ht = hmc[i-16]
-		}
-		d := 0
-		if ht > hc {
-			d += (ht - hc) * 16
-		}
-		if hr > hc {
-			d += (hr - hc) * 16
-		}
-		if d > 64 {
-			d = 64
-		}
-		img.Set(i%16, i/16, color.RGBA{0, 0, 0, uint8(d)})
-
-	}
-	appendMetrics(time.Since(t), "shading")
-	return img
+func tintColor(rgb uint32, a uint16) color.RGBA64 {
+	return color.RGBA64{R: uint16(rgb>>16&0xff) * 257, G: uint16(rgb>>8&0xff) * 257, B: uint16(rgb&0xff) * 257, A: a}
 }
 
 //lint:ignore U1000 for debugging
@@ -290,30 +294,75 @@ func printColor(c color.RGBA64) string {
 
 // }
 
+func terrainProvider(ymin, ymax int) ttypeProviderFunc {
+	return func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
+		return s.GetChunksRegion, func(i interface{}) *image.RGBA {
+			c := i.(save.Chunk)
+			return drawChunkSlab(&c, ymin, ymax)
+		}
+	}
+}
+
 func drawChunk(chunk *save.Chunk) (img *image.RGBA) {
+	return drawChunkBelowRoof(chunk, noRoofY)
+}
+
+// only blocks between ymin and ymax are drawn, as if everything above was cut off
+func drawChunkSlab(chunk *save.Chunk, ymin, ymax int) (img *image.RGBA) {
+	return drawChunkColumns(chunk, noRoofY, ymin, ymax)
+}
+
+// way above any build limit
+const noRoofY = 1 << 24
+
+// columns are scanned from roofY down and blocks are skipped until first
+// air below the roof, so nether bedrock ceiling and whatever lays on top
+// of it are not drawn, columns with no air below the roof stay transparent
+func drawChunkBelowRoof(chunk *save.Chunk, roofY int) (img *image.RGBA) {
+	return drawChunkColumns(chunk, roofY, yRangeMin, yRangeMax)
+}
+
+func drawChunkColumns(chunk *save.Chunk, roofY, ymin, ymax int) (img *image.RGBA) {
 	t := time.Now()
+	palette := colors.Get()
 	img = image.NewRGBA(image.Rect(0, 0, 16, 16))
 	defaultColor := color.RGBA{0, 0, 0, 0}
 	draw.Draw(img, img.Bounds(), &image.Uniform{defaultColor}, image.Point{}, draw.Src)
 	if chunk == nil || len(chunk.Sections) == 0 {
 		return img
 	}
-	sort.Slice(chunk.Sections, func(i, j int) bool {
-		return int8(chunk.Sections[i].Y) > int8(chunk.Sections[j].Y)
-	})
+	sortSectionsTopDown(chunk)
+	// translucent blocks are composited front to back, transmit is how
+	// much of what is below still shows through
 	type OutputBlock struct {
-		c []color.RGBA64
-		b []block.Block
+		r, g, b  float64
+		transmit float64
+		layers   int
 	}
 	outputs := make([]OutputBlock, 16*16)
+	for i := range outputs {
+		outputs[i].transmit = 1
+	}
+	maxBlend := cfg.GetDSInt(8, "layers", "terrain", "blend_depth")
 	failedState := 0
 	failedID := 0
 	colored := make([]bool, 32*32)
 	for i := range colored {
 		colored[i] = false
 	}
+	var underRoof [16 * 16]bool
+	var waterDepth [16 * 16]int
+	maxWaterDepth := cfg.GetDSInt(24, "layers", "terrain", "water_depth")
+	for i := range underRoof {
+		underRoof[i] = roofY == noRoofY
+	}
 	for _, s := range chunk.Sections {
-		if len(s.BlockStates.Data) == 0 {
+		sy := int(int8(s.Y)) * 16
+		if sy > roofY || sy > ymax || sy+15 < ymin || len(s.BlockStates.Palette) == 0 {
+			continue
+		}
+		// sections of only air only matter when looking for air under the roof
+		if len(s.BlockStates.Data) == 0 && roofY == noRoofY && isAirPalette(s.BlockStates.Palette) {
 			continue
 		}
 		states := prepareSectionBlockstates(&s)
@@ -321,115 +370,124 @@ func drawChunk(chunk *save.Chunk) (img *image.RGBA) {
 			log.Printf("Chunk %d:%d section %d has broken states pallete", chunk.XPos, chunk.YPos, s.Y)
 			continue
 		}
-		// biomes := prepareSectionBiomes(&s)
-		// if biomes == nil {
-		// 	log.Printf("Chunk %d:%d section %d has broken biome pallete", chunk.XPos, chunk.YPos, s.Y)
-		// 	continue
-		// }
-		for y := 15; y >= 0; y-- {
+		// without biome data plains colors are used
+		var sectionBiomes *level.PaletteContainer[level.BiomesState]
+		if len(s.Biomes.Palette) > 0 {
+			sectionBiomes = prepareSectionBiomes(&s)
+		}
+		for y := minInt(15, minInt(roofY, ymax)-sy); y >= maxInt(0, ymin-sy); y-- {
 			for i := 16*16 - 1; i >= 0; i-- {
 				if colored[i] {
 					continue
 				}
 				state := states.Get(y*16*16 + i)
-				blockState := block.StateList[state]
+				blockState := stateBlock(state)
 				if isAirState(state) {
+					underRoof[i] = true
+					continue
+				}
+				if !underRoof[i] {
 					continue
 				}
 				toColor := color.RGBA64{R: 0, G: 0, B: 0, A: 0}
 				isTransparent := false
 				isWater := false
+				tint := func() biomes.Tint {
+					if sectionBiomes == nil {
+						return biomes.Tints["plains"]
+					}
+					id := int(sectionBiomes.Get(y/4*16 + (i/16)/4*4 + (i%16)/4))
+					if id < 0 || id >= len(biomes.TintsByID) {
+						return biomes.DefaultTint
+					}
+					return biomes.TintsByID[id]
+				}
+				// registry blocks with own color skip tinting of blocks they render as
+				if registryColored(state) {
+					blockState = nil
+				}
 				switch blockState.(type) {
-				// Grass tint for plains
-				// TODO: actually grab correct tint from biome
 				case block.GrassBlock:
-					toColor = color.RGBA64{R: 0x91 * 257, G: 0xBD * 257, B: 0x59 * 257, A: 0xFF * 257}
-				case block.Grass:
-					toColor = color.RGBA64{R: 0x91 * 257, G: 0xBD * 257, B: 0x59 * 257, A: 0x7F * 257}
-					isTransparent = true
-				case block.TallGrass:
-					toColor = color.RGBA64{R: 0x91 * 257, G: 0xBD * 257, B: 0x59 * 257, A: 0x7F * 257}
-					isTransparent = true
-				case block.Fern:
-					toColor = color.RGBA64{R: 0x91 * 257, G: 0xBD * 257, B: 0x59 * 257, A: 0x7F * 257}
-					isTransparent = true
-				case block.LargeFern:
-					toColor = color.RGBA64{R: 0x91 * 257, G: 0xBD * 257, B: 0x59 * 257, A: 0x7F * 257}
-					isTransparent = true
-				case block.PottedFern:
-					toColor = color.RGBA64{R: 0x91 * 257, G: 0xBD * 257, B: 0x59 * 257, A: 0x7F * 257}
+					toColor = tintColor(tint().Grass, 0xFFFF)
+				case block.Grass, block.TallGrass, block.Fern, block.LargeFern, block.PottedFern, block.SugarCane:
+					toColor = tintColor(tint().Grass, 0x7F*257)
 					isTransparent = true
-				case block.SugarCane:
-					toColor = color.RGBA64{R: 0x91 * 257, G: 0xBD * 257, B: 0x59 * 257, A: 0x7F * 257}
+
+				// leaves have holes in them, ground shows through a bit
+				case block.OakLeaves, block.JungleLeaves, block.AcaciaLeaves, block.DarkOakLeaves, block.MangroveLeaves:
+					toColor = tintColor(tint().Foliage, 0xD0*257)
 					isTransparent = true
 
-				// Foliage tint for plains
-				// TODO: actually grab correct tint from biome
-				case block.OakLeaves:
-					toColor = color.RGBA64{R: 0x77 * 257, G: 0xAB * 257, B: 0x2F * 257, A: 0xFFFF}
-					// isTransparent = true
-				case block.JungleLeaves:
-					toColor = color.RGBA64{R: 0x77 * 257, G: 0xAB * 257, B: 0x2F * 257, A: 0xFFFF}
-					// isTransparent = true
-				case block.AcaciaLeaves:
-					toColor = color.RGBA64{R: 0x77 * 257, G: 0xAB * 257, B: 0x2F * 257, A: 0xFFFF}
-					// isTransparent = true
-				case block.DarkOakLeaves:
-					toColor = color.RGBA64{R: 0x77 * 257, G: 0xAB * 257, B: 0x2F * 257, A: 0xFFFF}
-					// isTransparent = true
+				// birch and spruce leaves are not tinted by biome
 				case block.BirchLeaves:
-					toColor = color.RGBA64{R: 0x80 * 257, G: 0xA7 * 257, B: 0x55 * 257, A: 0xFFFF}
-					// isTransparent = true
+					toColor = color.RGBA64{R: 0x80 * 257, G: 0xA7 * 257, B: 0x55 * 257, A: 0xD0 * 257}
+					isTransparent = true
 				case block.SpruceLeaves:
-					toColor = color.RGBA64{R: 0x61 * 257, G: 0x99 * 257, B: 0x61 * 257, A: 0xFFFF}
-					// isTransparent = true
+					toColor = color.RGBA64{R: 0x61 * 257, G: 0x99 * 257, B: 0x61 * 257, A: 0xD0 * 257}
+					isTransparent = true
 				case block.Vine:
-					toColor = color.RGBA64{R: 0x77 * 257, G: 0xAB * 257, B: 0x2F * 257, A: 0xFFFF}
-					// isTransparent = true
-
-				// Water tint for "most biomes" lmao
+					toColor = tintColor(tint().Foliage, 0x80*257)
+					isTransparent = true
 
-				case block.Water:
-					toColor = color.RGBA64{R: 0x3F * 257, G: 0x76 * 257, B: 0xE4 * 257, A: 0x30 * 257}
+				case block.Water, block.Seagrass, block.TallSeagrass, block.Kelp, block.KelpPlant, block.BubbleColumn:
+					toColor = tintColor(tint().Water, 0x30*257)
 					isTransparent = true
 					isWater = true
 				case block.WaterCauldron:
-					toColor = color.RGBA64{R: 0x3F * 257, G: 0x76 * 257, B: 0xE4 * 257, A: 0xFF * 257}
+					toColor = tintColor(tint().Water, 0xFFFF)
 				default:
-					toColor = colors[state]
+					if int(state) < len(palette) {
+						toColor = palette[state]
+					}
 				}
 
+				// glass and such have alpha in the palette
+				if toColor.A < 0xFFFF {
+					isTransparent = true
+				}
+				o := &outputs[i]
 				if !isTransparent {
-					if len(outputs[i].c) > 1 {
-						for c1 := 1; c1 < len(outputs[i].c); c1++ {
-							cvA := float64(outputs[i].c[c1].A) / 65535
-							outputs[i].c[0].R = uint16(float64(outputs[i].c[0].R)*(1-cvA) + float64(outputs[i].c[c1].R)*cvA)
-							outputs[i].c[0].G = uint16(float64(outputs[i].c[0].G)*(1-cvA) + float64(outputs[i].c[c1].G)*cvA)
-							outputs[i].c[0].B = uint16(float64(outputs[i].c[0].B)*(1-cvA) + float64(outputs[i].c[c1].B)*cvA)
-
-						}
-						toColor.R = uint16(float64(toColor.R)*0.3 + float64(outputs[i].c[0].R)*0.7)
-						toColor.G = uint16(float64(toColor.G)*0.3 + float64(outputs[i].c[0].G)*0.7)
-						toColor.B = uint16(float64(toColor.B)*0.3 + float64(outputs[i].c[0].B)*0.7)
+					// deeper water gets darker until floor is barely visible
+					k := 1.0
+					if waterDepth[i] > 0 && maxWaterDepth > 0 {
+						k = 1 - 0.6*float64(minInt(waterDepth[i], maxWaterDepth))/float64(maxWaterDepth)
 					}
-					toColor.A = 65535
-					// log.Printf("Painting %02d:%02d %v %#v %#v", i%16, i/16, toColor, blockState.ID(), outputs[i].b)
-					img.Set(i%16, i/16, toColor)
+					img.Set(i%16, i/16, color.RGBA64{
+						R: uint16(o.r + o.transmit*float64(toColor.R)*k),
+						G: uint16(o.g + o.transmit*float64(toColor.G)*k),
+						B: uint16(o.b + o.transmit*float64(toColor.B)*k),
+						A: 0xFFFF,
+					})
 					colored[i] = true
-				} else {
-					if isWater {
-						if len(outputs[i].b) < 2 {
-							outputs[i].c = append(outputs[i].c, toColor)
-							outputs[i].b = append(outputs[i].b, blockState)
-						}
-					} else {
-						outputs[i].c = append(outputs[i].c, toColor)
-						outputs[i].b = append(outputs[i].b, blockState)
+					continue
+				}
+				if isWater {
+					waterDepth[i]++
+					// only surface of the water is tinted, depth is shown by darkening
+					if waterDepth[i] > 2 {
+						continue
 					}
 				}
+				if o.layers >= maxBlend || toColor.A == 0 {
+					continue
+				}
+				a := float64(toColor.A) / 0xFFFF
+				o.r += o.transmit * a * float64(toColor.R)
+				o.g += o.transmit * a * float64(toColor.G)
+				o.b += o.transmit * a * float64(toColor.B)
+				o.transmit *= 1 - a
+				o.layers++
 			}
 		}
 	}
+	// nothing solid below, only translucent blocks are drawn
+	for i := range outputs {
+		o := outputs[i]
+		if colored[i] || o.layers == 0 {
+			continue
+		}
+		img.Set(i%16, i/16, color.RGBA64{R: uint16(o.r), G: uint16(o.g), B: uint16(o.b), A: uint16((1 - o.transmit) * 0xFFFF)})
+	}
 	if failedState != 0 {
 		log.Println("Failed to lookup", failedState, "block states")
 	}
@@ -471,7 +529,7 @@ func drawChunkLavaAge(chunk *save.Chunk, alpha uint8) (img *image.RGBA) {
 		for y := 15; y >= 0; y-- {
 			yadd := y * 16 * 16
 			if trycontinue != -1 {
-				if block.StateList[states.Get(int(trycontinue))].ID() == lavaid {
+				if stateName(states.Get(int(trycontinue))) == lavaid {
 					intensity++
 				} else {
 					trycontinue = -1
@@ -479,18 +537,18 @@ func drawChunkLavaAge(chunk *save.Chunk, alpha uint8) (img *image.RGBA) {
 			} else {
 				for i := 16*16 - 1; i >= 0; i-- {
 					ii := yadd + i
-					if block.StateList[states.Get(ii)].ID() == lavaid {
+					if stateName(states.Get(ii)) == lavaid {
 						nearcount := 0
-						if ii+1 >= 0 && ii+1 < 16*16 && block.StateList[states.Get(ii+1)].ID() == lavaid {
+						if ii+1 >= 0 && ii+1 < 16*16 && stateName(states.Get(ii+1)) == lavaid {
 							nearcount++
 						}
-						if ii-1 >= 0 && ii+1 < 16*16 && block.StateList[states.Get(ii-1)].ID() == lavaid {
+						if ii-1 >= 0 && ii+1 < 16*16 && stateName(states.Get(ii-1)) == lavaid {
 							nearcount++
 						}
-						if ii+16 >= 0 && ii+1 < 16*16 && block.StateList[states.Get(ii+16)].ID() == lavaid {
+						if ii+16 >= 0 && ii+1 < 16*16 && stateName(states.Get(ii+16)) == lavaid {
 							nearcount++
 						}
-						if ii-16 >= 0 && ii+1 < 16*16 && block.StateList[states.Get(ii-16)].ID() == lavaid {
+						if ii-16 >= 0 && ii+1 < 16*16 && stateName(states.Get(ii-16)) == lavaid {
 							nearcount++
 						}
 						if nearcount < 2 {
@@ -516,26 +574,9 @@ func drawChunkLavaAge(chunk *save.Chunk, alpha uint8) (img *image.RGBA) {
 	return img
 }
 
-func drawChunkXray(chunk *save.Chunk) (img *image.RGBA) {
+func countPortalBlocks(chunk *save.Chunk) int {
 	t := time.Now()
-	img = image.NewRGBA(image.Rect(0, 0, 16, 16))
-	defaultColor := color.RGBA{0, 0, 0, 0}
-	draw.Draw(img, img.Bounds(), &image.Uniform{defaultColor}, image.Point{}, draw.Src)
-	sort.Slice(chunk.Sections, func(i, j int) bool {
-		return int8(chunk.Sections[i].Y) > int8(chunk.Sections[j].Y)
-	})
-	type OutputBlock struct {
-		sR, sG, sB, sA uint64
-		c              uint64
-		b              []block.Block
-	}
-	outputs := make([]OutputBlock, 16*16)
-	failedState := 0
-	failedID := 0
-	colored := make([]bool, 32*32)
-	for i := range colored {
-		colored[i] = false
-	}
+	portalsDetected := 0
 	for _, s := range chunk.Sections {
 		if len(s.BlockStates.Data) == 0 {
 			continue
@@ -549,74 +590,69 @@ func drawChunkXray(chunk *save.Chunk) (img *image.RGBA) {
 		}
 		for y := 15; y >= 0; y-- {
 			for i := 16*16 - 1; i >= 0; i-- {
-				state := states.Get(y*16*16 + i)
-				toColor := colors[state]
-				outputs[i].sR += uint64(toColor.R)
-				outputs[i].sG += uint64(toColor.G)
-				outputs[i].sB += uint64(toColor.B)
-				outputs[i].sA += uint64(toColor.A)
-				outputs[i].c++
-				outputs[i].b = append(outputs[i].b, block.StateList[state])
+				if stateName(states.Get(y*16*16+i)) == "minecraft:nether_portal" {
+					portalsDetected++
+				}
 			}
 		}
 	}
-	for i := range outputs {
-		if outputs[i].c != 0 {
-			toColor := color.RGBA64{uint16(outputs[i].sR / outputs[i].c), uint16(outputs[i].sG / outputs[i].c), uint16(outputs[i].sB / outputs[i].c), 65535}
-			img.Set(i%16, i/16, toColor)
-			// log.Println("Final blend", fmt.Sprintf("% 3d %02d:%02d", outputs[i].c, i%16, i/16), printColor(colors[state]), printColor(backColor), printColor(frontColor), printColor(final))
-		}
+	appendMetrics(time.Since(t), "portal_heat")
+	return portalsDetected
+}
+
+var chestHeatDefaultBlocks = func() []string {
+	ret := []string{"chest", "trapped_chest", "barrel", "hopper", "shulker_box"}
+	for _, c := range []string{"white", "orange", "magenta", "light_blue", "yellow", "lime", "pink", "gray",
+		"light_gray", "cyan", "purple", "blue", "brown", "green", "red", "black"} {
+		ret = append(ret, c+"_shulker_box")
 	}
-	if failedState != 0 {
-		log.Println("Failed to lookup", failedState, "block states")
+	return ret
+}()
+
+// set of block ids counted on chest heatmap, ids may omit minecraft: prefix
+func getChestHeatBlocks() map[string]bool {
+	conf := []string{}
+	err := cfg.GetToStruct(&conf, "layers", "chestheat", "blocks")
+	if err != nil {
+		if !errors.Is(err, lac.ErrNoKey) {
+			log.Printf("Failed to parse chestheat blocks, using defaults: %s", err.Error())
+		}
+		conf = chestHeatDefaultBlocks
 	}
-	if failedID != 0 {
-		log.Println("Failed to lookup", failedID, "block IDS")
+	ret := map[string]bool{}
+	for _, id := range conf {
+		if !strings.Contains(id, ":") {
+			id = "minecraft:" + id
+		}
+		ret[id] = true
 	}
-	appendMetrics(time.Since(t), "xray")
-	return img
+	return ret
 }
 
-func drawChunkPortalBlocksHeatmap(chunk *save.Chunk) (img *image.RGBA) {
+func countChestBlocks(chunk *save.Chunk) int {
 	t := time.Now()
-	portalsDetected := 0
+	found := countBlocks(chunk, getChestHeatBlocks())
+	appendMetrics(time.Since(t), "chest_heat")
+	return found
+}
+
+// count of blocks with ids from targets, ids must have minecraft: prefix
+func countBlocks(chunk *save.Chunk, targets map[string]bool) int {
+	counted := map[block.StateID]bool{}
+	found := 0
 	for _, s := range chunk.Sections {
-		if len(s.BlockStates.Data) == 0 {
+		if len(s.BlockStates.Palette) == 0 {
 			continue
 		}
-		states := prepareSectionBlockstates(&s)
-		if states == nil {
-			if os.Getenv("REPORT_CHUNK_PROBLEMS") == "yes" || os.Getenv("REPORT_CHUNK_PROBLEMS") == "all" {
-				log.Printf("Chunk %d:%d section %d has broken pallete", chunk.XPos, chunk.YPos, s.Y)
-			}
-			continue
-		}
-		for y := 15; y >= 0; y-- {
-			for i := 16*16 - 1; i >= 0; i-- {
-				b := block.StateList[states.Get(y*16*16+i)]
-				if b.ID() == "nether_portal" {
-					portalsDetected++
-				}
+		// most sections do not have any, palette tells that without unpacking
+		has := false
+		for _, p := range s.BlockStates.Palette {
+			if targets[p.Name] || targets["minecraft:"+p.Name] {
+				has = true
+				break
 			}
 		}
-	}
-	img = image.NewRGBA(image.Rect(0, 0, 16, 16))
-	alpha := 0
-	if portalsDetected/6 > 255 {
-		alpha = 255
-	} else {
-		alpha = portalsDetected * 8
-	}
-	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{255, 0, 0, uint8(alpha)}}, image.Point{}, draw.Src)
-	appendMetrics(time.Since(t), "portal_heat")
-	return
-}
-
-func drawChunkChestBlocksHeatmap(chunk *save.Chunk) (img *image.RGBA) {
-	t := time.Now()
-	portalsDetected := 0
-	for _, s := range chunk.Sections {
-		if len(s.BlockStates.Data) == 0 {
+		if !has {
 			continue
 		}
 		states := prepareSectionBlockstates(&s)
@@ -626,32 +662,26 @@ func drawChunkChestBlocksHeatmap(chunk *save.Chunk) (img *image.RGBA) {
 			}
 			continue
 		}
-		for y := 15; y >= 0; y-- {
-			for i := 16*16 - 1; i >= 0; i-- {
-				b := block.StateList[states.Get(y*16*16+i)]
-				if b.ID() == "chest" {
-					portalsDetected++
-				}
+		for i := 16*16*16 - 1; i >= 0; i-- {
+			state := states.Get(i)
+			c, ok := counted[state]
+			if !ok {
+				c = targets[stateName(state)]
+				counted[state] = c
+			}
+			if c {
+				found++
 			}
 		}
 	}
-	img = image.NewRGBA(image.Rect(0, 0, 16, 16))
-	alpha := 0
-	if portalsDetected/6 > 255 {
-		alpha = 255
-	} else {
-		alpha = portalsDetected * 8
-	}
-	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{255, 0, 0, uint8(alpha)}}, image.Point{}, draw.Src)
-	appendMetrics(time.Since(t), "portal_heat")
-	return
+	return found
 }
 
 func terrainInfoHandler(w http.ResponseWriter, r *http.Request) {
 	params := mux.Vars(r)
 	wname := params["world"]
 	dname := params["dim"]
-	world, s, err := chunkStorage.GetWorldStorage(storages, wname)
+	world, s, err := storages.World(wname)
 	if err != nil {
 		plainmsg(w, r, plainmsgColorRed, "Error getting world: "+err.Error())
 		return
@@ -689,9 +719,7 @@ func terrainInfoHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	bedrockInfo := ""
 	if chunk != nil && chunk.Sections != nil {
-		sort.Slice(chunk.Sections, func(i, j int) bool {
-			return int8(chunk.Sections[i].Y) > int8(chunk.Sections[j].Y)
-		})
+		sortSectionsTopDown(chunk)
 		for _, s := range chunk.Sections {
 			if len(s.BlockStates.Data) == 0 {
 				continue
@@ -710,11 +738,11 @@ func terrainInfoHandler(w http.ResponseWriter, r *http.Request) {
 					for z := 0; z < 16; z++ {
 						for x := 0; x < 16; x++ {
 							state := states.Get(y*16*16 + z*16 + x)
-							block := block.StateList[state]
+							name := stateName(state)
 							ay := int(s.Y)*16 + y
 							ax := x + int(cx)*16
 							az := z + int(cz)*16
-							if block.ID() == "minecraft:bedrock" && (ay == 4 || ay == 123) {
+							if name == "minecraft:bedrock" && (ay == 4 || ay == 123) {
 								bedrockInfo += fmt.Sprintf("Block::new(%6d, %3d, %6d, BEDROCK),\n", ax, ay, az)
 							}
 						}
@@ -765,9 +793,3 @@ func drawNumberOfChunks(c int) *image.RGBA {
 	}
 	return layerImg
 }
-
-func drawHeatOfChunks(c int) *image.RGBA {
-	layerImg := image.NewRGBA(image.Rect(0, 0, 16, 16))
-	draw.Draw(layerImg, layerImg.Bounds(), &image.Uniform{color.RGBA{255, 0, 0, uint8(c * 30)}}, image.Point{}, draw.Src)
-	return layerImg
-}
@@ -0,0 +1,125 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"image"
+	"log"
+	"sync"
+	"time"
+
+	imagecache "github.com/maxsupermanhd/WebChunk/imageCache"
+	"github.com/maxsupermanhd/WebChunk/primitives"
+)
+
+// tiles being re-rendered in background, requests for them wait on the channel
+var (
+	revalidations     = map[primitives.ImageLocation]chan struct{}{}
+	revalidationsLock sync.Mutex
+)
+
+// layers that change with time alone go stale by default
+var defaultLayerStaleAfter = map[string]int{
+	"chunkage": 3600,
+}
+
+func layerStaleAfter(variant string) time.Duration {
+	return time.Duration(cfg.GetDSInt(defaultLayerStaleAfter[layerBaseName(variant)], "layers", variant, "stale_after")) * time.Second
+}
+
+func layerRenderTimeout(variant string) time.Duration {
+	return time.Duration(cfg.GetDSInt(0, "layers", variant, "render_timeout")) * time.Millisecond
+}
+
+func tileChunkRange(loc primitives.ImageLocation) (cx0, cz0, cx1, cz1 int) {
+	scale := 1 << loc.S
+	return loc.X * scale, loc.Z * scale, loc.X*scale + scale, loc.Z*scale + scale
+}
+
+func tileIsExpired(loc primitives.ImageLocation, modTime time.Time) bool {
+	staleAfter := layerStaleAfter(loc.Variant)
+	return staleAfter > 0 && !modTime.IsZero() && time.Since(modTime) > staleAfter
+}
+
+// cached tile is stale when chunks under it changed or it is older than layer allows
+func tileIsStale(loc primitives.ImageLocation, modTime time.Time) bool {
+	if loc.S > imagecache.StorageLevel {
+		return false
+	}
+	cx0, cz0, cx1, cz1 := tileChunkRange(loc)
+	return hasDirtyChunks(loc.World, loc.Dimension, loc.Variant, cx0, cz0, cx1, cz1) || tileIsExpired(loc, modTime)
+}
+
+// queues re-render of the tile and waits up to timeout for it to finish,
+// returns fresh image if it was done in time and nil otherwise
+func revalidateTile(loc primitives.ImageLocation, timeout time.Duration) *image.RGBA {
+	revalidationsLock.Lock()
+	done, ok := revalidations[loc]
+	if !ok {
+		done = make(chan struct{})
+		revalidations[loc] = done
+		go func() {
+			runTileRevalidation(loc)
+			revalidationsLock.Lock()
+			delete(revalidations, loc)
+			revalidationsLock.Unlock()
+			close(done)
+		}()
+	}
+	revalidationsLock.Unlock()
+	if timeout <= 0 {
+		return nil
+	}
+	select {
+	case <-done:
+		// cache can miss or time out, tile is rendered right here then
+		if cached := ic.GetCachedImageBlocking(loc); cached != nil && cached.Img != nil {
+			return cached.Img
+		}
+		img, err := imageGetSync(loc, true)
+		if err != nil {
+			log.Printf("Failed to render revalidated tile %s: %s", loc.String(), err.Error())
+			return nil
+		}
+		return img
+	case <-time.After(timeout):
+		return nil
+	}
+}
+
+func runTileRevalidation(loc primitives.ImageLocation) {
+	cached := ic.GetCachedImageBlocking(loc)
+	if cached == nil || !tileIsExpired(loc, cached.ModTime) {
+		cx0, cz0, cx1, cz1 := tileChunkRange(loc)
+		for _, k := range takeDirtyChunks(loc.World, loc.Dimension, loc.Variant, cx0, cz0, cx1, cz1) {
+			rerenderChunkTile(k, loc.Variant)
+		}
+		return
+	}
+	// cache keeps only storage level images, whole one has to be rendered again
+	rx, rz := imagecache.AT(loc.X<<loc.S, loc.Z<<loc.S)
+	region := primitives.ImageLocation{World: loc.World, Dimension: loc.Dimension, Variant: loc.Variant, S: imagecache.StorageLevel, X: rx, Z: rz}
+	cx0, cz0, cx1, cz1 := tileChunkRange(region)
+	takeDirtyChunks(loc.World, loc.Dimension, loc.Variant, cx0, cz0, cx1, cz1)
+	if _, err := imageGetSync(region, true); err != nil {
+		log.Printf("Failed to revalidate tile %s: %s", region.String(), err.Error())
+	}
+}
@@ -0,0 +1,262 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"io"
+	"net/http"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/maxsupermanhd/WebChunk/chunkStorage"
+	"github.com/maxsupermanhd/WebChunk/primitives"
+)
+
+// one timelapse at a time, they take a while and a lot of memory
+var timelapseLock sync.Mutex
+
+// storage as it was at given time, only chunk reads go to history
+type historyStorage struct {
+	chunkStorage.ChunkStorage
+	history chunkStorage.ChunkHistoryStorage
+	at      time.Time
+}
+
+func (s historyStorage) GetChunksRegion(wname, dname string, cx0, cz0, cx1, cz1 int) ([]chunkStorage.ChunkData, error) {
+	return s.history.GetChunksRegionAt(wname, dname, cx0, cz0, cx1, cz1, s.at)
+}
+
+type timelapseParams struct {
+	layer                string
+	cx0, cz0, cx1, cz1   int
+	from, to             time.Time
+	frames, delay, chunk int
+	format               string
+}
+
+func parseTimelapseParams(r *http.Request) (p timelapseParams, err error) {
+	q, err := parseFormInts(r, "cx0", "cz0", "cx1", "cz1")
+	if err != nil {
+		return p, err
+	}
+	p.cx0, p.cz0, p.cx1, p.cz1 = minInt(q[0], q[2]), minInt(q[1], q[3]), maxInt(q[0], q[2]), maxInt(q[1], q[3])
+	if p.cx0 == p.cx1 || p.cz0 == p.cz1 {
+		return p, errors.New("area is empty")
+	}
+	if (p.cx1-p.cx0)*(p.cz1-p.cz0) > cfg.GetDSInt(4096, "timelapse", "max_chunks") {
+		return p, errors.New("area is too big")
+	}
+	p.from, err = parseSyncSince(r.FormValue("from"))
+	if err != nil {
+		return p, errors.New("bad from: " + err.Error())
+	}
+	if p.from.IsZero() {
+		return p, errors.New("from is required")
+	}
+	p.to, err = parseSyncSince(r.FormValue("to"))
+	if err != nil {
+		return p, errors.New("bad to: " + err.Error())
+	}
+	if p.to.IsZero() {
+		p.to = time.Now()
+	}
+	if !p.to.After(p.from) {
+		return p, errors.New("to is not after from")
+	}
+	intParam := func(key string, def, min, max int) (int, error) {
+		s := r.FormValue(key)
+		if s == "" {
+			return def, nil
+		}
+		v, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, fmt.Errorf("bad %s: %w", key, err)
+		}
+		if v < min || v > max {
+			return 0, fmt.Errorf("%s must be from %d to %d", key, min, max)
+		}
+		return v, nil
+	}
+	if p.frames, err = intParam("frames", 24, 2, cfg.GetDSInt(120, "timelapse", "max_frames")); err != nil {
+		return p, err
+	}
+	if p.delay, err = intParam("delay", 200, 10, 10000); err != nil {
+		return p, err
+	}
+	// chunks shrink so the longer side fits into max_size
+	side := maxInt(p.cx1-p.cx0, p.cz1-p.cz0)
+	p.chunk = clampInt(cfg.GetDSInt(1024, "timelapse", "max_size")/side, 1, 16)
+	p.format = r.FormValue("format")
+	if p.format == "" {
+		p.format = "gif"
+	}
+	if p.format != "gif" && p.format != "mp4" {
+		return p, errors.New("format must be gif or mp4")
+	}
+	return p, nil
+}
+
+// frames are evenly spread from first to last moment, both included
+func (p timelapseParams) frameTime(i int) time.Time {
+	return p.from.Add(p.to.Sub(p.from) * time.Duration(i) / time.Duration(p.frames-1))
+}
+
+func renderTimelapseFrame(ctx context.Context, provider ttypeProviderFunc, s chunkStorage.ChunkStorage, wname, dname string, p timelapseParams, at time.Time) (*image.RGBA, error) {
+	hs, ok := s.(chunkStorage.ChunkHistoryStorage)
+	if !ok {
+		return nil, errors.New("storage does not keep chunk history")
+	}
+	getter, painter := provider(historyStorage{ChunkStorage: s, history: hs, at: at})
+	cc, err := getter(wname, dname, p.cx0, p.cz0, p.cx1, p.cz1)
+	if err != nil {
+		return nil, err
+	}
+	img := image.NewRGBA(image.Rect(0, 0, (p.cx1-p.cx0)*p.chunk, (p.cz1-p.cz0)*p.chunk))
+	// formats here do not do transparency well
+	draw.Draw(img, img.Rect, image.NewUniform(color.RGBA{R: 0x1e, G: 0x1e, B: 0x1e, A: 0xff}), image.Point{}, draw.Src)
+	downscale := layerDownscaler(p.layer)
+	forEachChunkParallel(ctx, cc, func(c chunkStorage.ChunkData) {
+		chunk := paintRecovering(painter, c.Data)
+		if chunk == nil {
+			return
+		}
+		x, z := (c.X-p.cx0)*p.chunk, (c.Z-p.cz0)*p.chunk
+		downscale(img, image.Rect(x, z, x+p.chunk, z+p.chunk), chunk)
+	})
+	return img, ctx.Err()
+}
+
+func encodeTimelapseGif(w io.Writer, frames []*image.RGBA, delay int) error {
+	anim := gif.GIF{}
+	for _, f := range frames {
+		pf := image.NewPaletted(f.Rect, palette.Plan9)
+		draw.FloydSteinberg.Draw(pf, f.Rect, f, image.Point{})
+		anim.Image = append(anim.Image, pf)
+		anim.Delay = append(anim.Delay, delay/10)
+	}
+	return gif.EncodeAll(w, &anim)
+}
+
+// mp4 is made by ffmpeg, frames are piped in as png
+func encodeTimelapseMp4(ctx context.Context, w io.Writer, frames []*image.RGBA, delay int) error {
+	cmd := exec.CommandContext(ctx, cfg.GetDSString("ffmpeg", "timelapse", "ffmpeg"),
+		"-hide_banner", "-loglevel", "error",
+		"-f", "image2pipe", "-framerate", strconv.FormatFloat(1000/float64(delay), 'f', 3, 64), "-i", "-",
+		"-vf", "pad=ceil(iw/2)*2:ceil(ih/2)*2", "-c:v", "libx264", "-pix_fmt", "yuv420p",
+		"-movflags", "frag_keyframe+empty_moov", "-f", "mp4", "-")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stderr := bytes.Buffer{}
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	var werr error
+	for _, f := range frames {
+		if werr = png.Encode(stdin, f); werr != nil {
+			break
+		}
+	}
+	stdin.Close()
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("ffmpeg: %w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+	return werr
+}
+
+func apiTimelapse(w http.ResponseWriter, r *http.Request) (int, string) {
+	params := mux.Vars(r)
+	wname := params["world"]
+	dname := params["dim"]
+	if _, code, err := lookupDim(wname, dname); err != nil {
+		return code, err.Error()
+	}
+	p, err := parseTimelapseParams(r)
+	if err != nil {
+		return 400, err.Error()
+	}
+	p.layer = r.FormValue("layer")
+	if p.layer == "" {
+		p.layer = dimMapView(wname, dname).Layer
+	}
+	if p.layer == "" {
+		p.layer = "terrain"
+	}
+	if !layerAllowed(r, wname, dname, p.layer) {
+		return 403, "Layer is not allowed"
+	}
+	provider := findTTypeProviderFunc(primitives.ImageLocation{World: wname, Dimension: dname, Variant: p.layer})
+	if provider == nil {
+		return 400, "Layer not found"
+	}
+	_, s, err := storages.World(wname)
+	if err != nil {
+		return 500, err.Error()
+	}
+	if !timelapseLock.TryLock() {
+		return 503, "Another timelapse is being rendered"
+	}
+	defer timelapseLock.Unlock()
+	frames := make([]*image.RGBA, 0, p.frames)
+	for i := 0; i < p.frames; i++ {
+		img, err := renderTimelapseFrame(r.Context(), *provider, s, wname, dname, p, p.frameTime(i))
+		if err != nil {
+			if r.Context().Err() != nil {
+				return -1, ""
+			}
+			return 500, err.Error()
+		}
+		frames = append(frames, img)
+	}
+	buf := bytes.Buffer{}
+	contentType := "image/gif"
+	if p.format == "mp4" {
+		contentType = "video/mp4"
+		err = encodeTimelapseMp4(r.Context(), &buf, frames, p.delay)
+	} else {
+		err = encodeTimelapseGif(&buf, frames, p.delay)
+	}
+	if err != nil {
+		return 500, "Failed to encode timelapse: " + err.Error()
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s_%s_timelapse.%s\"", wname, dname, p.format))
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+	return -1, ""
+}
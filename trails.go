@@ -0,0 +1,162 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/maxsupermanhd/WebChunk/proxy"
+)
+
+type trailPoint struct {
+	Player  string
+	X, Y, Z float64
+	Time    time.Time
+}
+
+// last recorded point of every player, only touched by proxy event consumer
+var trailsLast = map[string]trailPoint{}
+
+func trailKey(e *proxy.ProxiedEvent) string {
+	return e.Server + "\x00" + e.Dimension + "\x00" + e.Username
+}
+
+// positions come every position_update_interval, only ones that moved
+// far enough from the last recorded point are saved
+func trailPositionReceived(e *proxy.ProxiedEvent, d proxy.EventPlayerPosition) {
+	if !cfg.GetDSBool(true, "trails", "record") {
+		return
+	}
+	k := trailKey(e)
+	minDist := float64(cfg.GetDSInt(4, "trails", "min_distance"))
+	if l, ok := trailsLast[k]; ok {
+		dx, dy, dz := d.X-l.X, d.Y-l.Y, d.Z-l.Z
+		if dx*dx+dy*dy+dz*dz < minDist*minDist {
+			return
+		}
+	}
+	p := trailPoint{
+		Player: e.Username,
+		X:      math.Round(d.X*10) / 10,
+		Y:      math.Round(d.Y*10) / 10,
+		Z:      math.Round(d.Z*10) / 10,
+		Time:   e.Time,
+	}
+	trailsLast[k] = p
+	err := recs.Append(e.Server, strings.TrimPrefix(e.Dimension, "minecraft:"), "trails", p)
+	if err != nil {
+		log.Printf("Failed to save trail point of %s: %s", e.Username, err.Error())
+	}
+}
+
+func trailsForgetPlayer(e *proxy.ProxiedEvent) {
+	for k := range trailsLast {
+		if strings.HasSuffix(k, "\x00"+e.Username) {
+			delete(trailsLast, k)
+		}
+	}
+}
+
+type trailSegment struct {
+	Player string
+	Points [][3]float64 // x, z, unix time
+}
+
+// splits paths of every player where there is a pause or a teleport
+func listTrails(wname, dname, player string, since, until time.Time, names playerNamer) ([]trailSegment, error) {
+	byPlayer := map[string][]trailPoint{}
+	err := recs.Read(wname, dname, "trails", func(m json.RawMessage) error {
+		var p trailPoint
+		if json.Unmarshal(m, &p) != nil {
+			return nil
+		}
+		p.Player = names(p.Player)
+		if player != "" && !strings.EqualFold(p.Player, player) {
+			return nil
+		}
+		if p.Time.Before(since) || (!until.IsZero() && p.Time.After(until)) {
+			return nil
+		}
+		byPlayer[p.Player] = append(byPlayer[p.Player], p)
+		return nil
+	})
+	maxGap := time.Duration(cfg.GetDSInt(30, "trails", "max_gap")) * time.Second
+	maxJump := float64(cfg.GetDSInt(256, "trails", "max_jump"))
+	ret := []trailSegment{}
+	for name, points := range byPlayer {
+		sort.SliceStable(points, func(i, j int) bool {
+			return points[i].Time.Before(points[j].Time)
+		})
+		seg := trailSegment{Player: name}
+		for i, p := range points {
+			if i > 0 {
+				l := points[i-1]
+				dx, dz := p.X-l.X, p.Z-l.Z
+				if p.Time.Sub(l.Time) > maxGap || dx*dx+dz*dz > maxJump*maxJump {
+					if len(seg.Points) > 1 {
+						ret = append(ret, seg)
+					}
+					seg = trailSegment{Player: name}
+				}
+			}
+			seg.Points = append(seg.Points, [3]float64{p.X, p.Z, float64(p.Time.Unix())})
+		}
+		if len(seg.Points) > 1 {
+			ret = append(ret, seg)
+		}
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].Points[0][2] < ret[j].Points[0][2]
+	})
+	return ret, err
+}
+
+func apiListTrails(w http.ResponseWriter, r *http.Request) (int, string) {
+	params := mux.Vars(r)
+	var since, until time.Time
+	if s := r.FormValue("since"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return 400, "Bad since: " + err.Error()
+		}
+		since = t
+	}
+	if s := r.FormValue("until"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return 400, "Bad until: " + err.Error()
+		}
+		until = t
+	}
+	trails, err := listTrails(params["world"], params["dim"], r.FormValue("player"), since, until, playerNamerFor(r))
+	if err != nil {
+		return 500, err.Error()
+	}
+	setContentTypeJson(w)
+	return marshalOrFail(200, trails)
+}
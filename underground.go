@@ -0,0 +1,127 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maxsupermanhd/WebChunk/chunkStorage"
+	"github.com/maxsupermanhd/go-vmc/v764/save"
+)
+
+const undergroundDefaultY = 0
+
+// every slice height is cached as its own variant
+func undergroundVariant(r *http.Request) (string, error) {
+	ys := r.URL.Query().Get("y")
+	if ys == "" {
+		return "underground", nil
+	}
+	y, err := strconv.Atoi(ys)
+	if err != nil {
+		return "", errors.New("bad y: " + err.Error())
+	}
+	if y < -2048 || y > 2048 {
+		return "", errors.New("y is out of range")
+	}
+	return "underground_y" + strconv.Itoa(y), nil
+}
+
+func undergroundVariantProvider(variant string) (ttypeProviderFunc, bool) {
+	if variant == "underground" {
+		return undergroundProvider(undergroundDefaultY), true
+	}
+	ys, ok := strings.CutPrefix(variant, "underground_y")
+	if !ok {
+		return nil, false
+	}
+	y, err := strconv.Atoi(ys)
+	if err != nil {
+		return nil, false
+	}
+	return undergroundProvider(y), true
+}
+
+func undergroundProvider(y int) ttypeProviderFunc {
+	return func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
+		return s.GetChunksRegion, func(i interface{}) *image.RGBA {
+			c := i.(save.Chunk)
+			return drawChunkSlice(&c, y)
+		}
+	}
+}
+
+// blocks cut at y are dimmed, where slice hits air first block below
+// is shown getting darker the deeper it is
+func drawChunkSlice(chunk *save.Chunk, y int) (img *image.RGBA) {
+	t := time.Now()
+	palette := colors.Get()
+	img = image.NewRGBA(image.Rect(0, 0, 16, 16))
+	sortSectionsTopDown(chunk)
+	var done [16 * 16]bool
+	for _, s := range chunk.Sections {
+		sy := int(int8(s.Y)) * 16
+		if sy > y || len(s.BlockStates.Palette) == 0 {
+			continue
+		}
+		states := prepareSectionBlockstates(&s)
+		if states == nil {
+			if os.Getenv("REPORT_CHUNK_PROBLEMS") == "yes" || os.Getenv("REPORT_CHUNK_PROBLEMS") == "all" {
+				log.Printf("Chunk %d:%d section %d has broken pallete", chunk.XPos, chunk.YPos, s.Y)
+			}
+			continue
+		}
+		for by := minInt(15, y-sy); by >= 0; by-- {
+			depth := y - (sy + by)
+			for i := 16*16 - 1; i >= 0; i-- {
+				if done[i] {
+					continue
+				}
+				state := states.Get(by*16*16 + i)
+				if isAirState(state) {
+					continue
+				}
+				done[i] = true
+				c := palette[state]
+				shade := 0.5
+				if depth > 0 {
+					shade = 1 - float64(minInt(depth, 32))/40
+				}
+				img.Set(i%16, i/16, color.RGBA64{
+					R: uint16(float64(c.R) * shade),
+					G: uint16(float64(c.G) * shade),
+					B: uint16(float64(c.B) * shade),
+					A: 65535,
+				})
+			}
+		}
+	}
+	appendMetrics(time.Since(t), "underground")
+	return img
+}
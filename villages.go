@@ -0,0 +1,251 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/maxsupermanhd/WebChunk/chunkStorage"
+	"github.com/maxsupermanhd/go-vmc/v764/save"
+)
+
+var villageWorkstations = map[string]string{
+	"blast_furnace":        "armorer",
+	"smoker":               "butcher",
+	"cartography_table":    "cartographer",
+	"brewing_stand":        "cleric",
+	"composter":            "farmer",
+	"barrel":               "fisherman",
+	"fletching_table":      "fletcher",
+	"cauldron":             "leatherworker",
+	"water_cauldron":       "leatherworker",
+	"lava_cauldron":        "leatherworker",
+	"powder_snow_cauldron": "leatherworker",
+	"lectern":              "librarian",
+	"stonecutter":          "mason",
+	"loom":                 "shepherd",
+	"smithing_table":       "toolsmith",
+	"grindstone":           "weaponsmith",
+}
+
+const (
+	// points of interest closer than that are considered same village
+	villageLinkDistance = 32
+	// max side of the area (in chunks) that can be scanned in one request
+	villageMaxQueryChunks = 64
+	villageDetailRadius   = 12
+)
+
+type villagePOI struct {
+	X, Y, Z int
+	Kind    string // "bell", "bed" or profession of the workstation
+}
+
+type village struct {
+	CenterX, CenterZ       int
+	MinX, MinZ, MaxX, MaxZ int
+	Bells                  int
+	Beds                   int
+	Workstations           int
+	Jobs                   map[string]int
+	TradingHall            bool
+	POIs                   []villagePOI `json:",omitempty"`
+}
+
+func villagePOIMatch(b save.BlockState) (string, bool) {
+	name := strings.TrimPrefix(b.Name, "minecraft:")
+	if name == "bell" {
+		return "bell", true
+	}
+	if strings.HasSuffix(name, "_bed") {
+		// both halves are stored, only head is the actual poi
+		var props struct {
+			Part string `nbt:"part"`
+		}
+		if b.Properties.Data == nil || b.Properties.Unmarshal(&props) != nil {
+			return "", false
+		}
+		return "bed", props.Part == "head"
+	}
+	job, ok := villageWorkstations[name]
+	return job, ok
+}
+
+func findVillagePOIs(s chunkStorage.ChunkStorage, wname, dname string, cx0, cz0, cx1, cz1 int) ([]villagePOI, error) {
+	chunks, err := s.GetChunksRegion(wname, dname, cx0, cz0, cx1, cz1)
+	if err != nil {
+		return nil, err
+	}
+	pois := []villagePOI{}
+	for _, c := range chunks {
+		chunk, ok := c.Data.(save.Chunk)
+		if !ok {
+			continue
+		}
+		scanChunkBlocks(&chunk, villagePOIMatch, func(x, y, z int, kind string) {
+			pois = append(pois, villagePOI{X: x, Y: y, Z: z, Kind: kind})
+		})
+	}
+	return pois, nil
+}
+
+func clusterVillages(pois []villagePOI) []village {
+	sort.Slice(pois, func(i, j int) bool {
+		return pois[i].X < pois[j].X
+	})
+	parent := make([]int, len(pois))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for i := range pois {
+		for j := i + 1; j < len(pois) && pois[j].X-pois[i].X <= villageLinkDistance; j++ {
+			if absInt(pois[j].Z-pois[i].Z) <= villageLinkDistance && absInt(pois[j].Y-pois[i].Y) <= villageLinkDistance {
+				parent[find(j)] = find(i)
+			}
+		}
+	}
+	groups := map[int][]villagePOI{}
+	for i, p := range pois {
+		r := find(i)
+		groups[r] = append(groups[r], p)
+	}
+	ret := []village{}
+	for _, g := range groups {
+		v := village{
+			MinX: g[0].X, MinZ: g[0].Z, MaxX: g[0].X, MaxZ: g[0].Z,
+			Jobs: map[string]int{},
+			POIs: g,
+		}
+		sumX, sumZ := 0, 0
+		bellSet := false
+		for _, p := range g {
+			v.MinX = minInt(v.MinX, p.X)
+			v.MinZ = minInt(v.MinZ, p.Z)
+			v.MaxX = maxInt(v.MaxX, p.X)
+			v.MaxZ = maxInt(v.MaxZ, p.Z)
+			sumX += p.X
+			sumZ += p.Z
+			switch p.Kind {
+			case "bell":
+				v.Bells++
+				if !bellSet {
+					v.CenterX, v.CenterZ = p.X, p.Z
+					bellSet = true
+				}
+			case "bed":
+				v.Beds++
+			default:
+				v.Workstations++
+				v.Jobs[p.Kind]++
+			}
+		}
+		// lone beds are just someone's house
+		if v.Bells == 0 && v.Workstations == 0 {
+			continue
+		}
+		if !bellSet {
+			v.CenterX, v.CenterZ = sumX/len(g), sumZ/len(g)
+		}
+		// workstations packed tightly together are what trading halls look like
+		area := (v.MaxX - v.MinX + 1) * (v.MaxZ - v.MinZ + 1)
+		v.TradingHall = v.Workstations >= 6 && area <= v.Workstations*24
+		ret = append(ret, v)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].CenterX == ret[j].CenterX {
+			return ret[i].CenterZ < ret[j].CenterZ
+		}
+		return ret[i].CenterX < ret[j].CenterX
+	})
+	return ret
+}
+
+func apiListVillages(w http.ResponseWriter, r *http.Request) (int, string) {
+	params := mux.Vars(r)
+	wname := params["world"]
+	dname := params["dim"]
+	q, err := parseFormInts(r, "cx0", "cz0", "cx1", "cz1")
+	if err != nil {
+		return 400, err.Error()
+	}
+	cx0, cz0, cx1, cz1 := minInt(q[0], q[2]), minInt(q[1], q[3]), maxInt(q[0], q[2]), maxInt(q[1], q[3])
+	if cx1-cx0 > villageMaxQueryChunks || cz1-cz0 > villageMaxQueryChunks {
+		return 400, "Requested area is too big"
+	}
+	_, s, err := storages.World(wname)
+	if err != nil {
+		return 500, err.Error()
+	}
+	if s == nil {
+		return 404, "World not found"
+	}
+	pois, err := findVillagePOIs(s, wname, dname, cx0, cz0, cx1, cz1)
+	if err != nil {
+		return 500, err.Error()
+	}
+	villages := clusterVillages(pois)
+	for i := range villages {
+		villages[i].POIs = nil
+	}
+	setContentTypeJson(w)
+	return marshalOrFail(200, villages)
+}
+
+func apiGetVillage(w http.ResponseWriter, r *http.Request) (int, string) {
+	params := mux.Vars(r)
+	wname := params["world"]
+	dname := params["dim"]
+	q, err := parseFormInts(r, "x", "z")
+	if err != nil {
+		return 400, err.Error()
+	}
+	x, z := q[0], q[1]
+	_, s, err := storages.World(wname)
+	if err != nil {
+		return 500, err.Error()
+	}
+	if s == nil {
+		return 404, "World not found"
+	}
+	cx, cz := x>>4, z>>4
+	pois, err := findVillagePOIs(s, wname, dname, cx-villageDetailRadius, cz-villageDetailRadius, cx+villageDetailRadius+1, cz+villageDetailRadius+1)
+	if err != nil {
+		return 500, err.Error()
+	}
+	for _, v := range clusterVillages(pois) {
+		if x >= v.MinX-villageLinkDistance && x <= v.MaxX+villageLinkDistance &&
+			z >= v.MinZ-villageLinkDistance && z <= v.MaxZ+villageLinkDistance {
+			setContentTypeJson(w)
+			return marshalOrFail(200, v)
+		}
+	}
+	return 404, "No village found at given location"
+}
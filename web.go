@@ -18,6 +18,9 @@ func createRouter(exitchan <-chan struct{}) http.Handler {
 	router.PathPrefix("/static").Handler(http.StripPrefix("/static/", http.FileServer(hiddenFileSystem{http.Dir("./static")}))).Methods("GET")
 	router.HandleFunc("/favicon.ico", faviconHandler).Methods("GET")
 	router.HandleFunc("/robots.txt", robotsHandler).Methods("GET")
+	router.HandleFunc("/login", loginPageHandler).Methods("GET")
+	router.HandleFunc("/login", loginHandler).Methods("POST")
+	router.HandleFunc("/metrics", metricsHandler).Methods("GET")
 
 	router.HandleFunc("/", indexHandler).Methods("GET")
 	router.HandleFunc("/stop", func(w http.ResponseWriter, _ *http.Request) {
@@ -27,11 +30,17 @@ func createRouter(exitchan <-chan struct{}) http.Handler {
 	}).Methods("GET")
 	router.HandleFunc("/worlds/{world}/{dim}", dimensionHandler).Methods("GET")
 	router.HandleFunc("/worlds/{world}/{dim}/tiles/{ttype}/{cs:[0-9]+}/{cx:-?[0-9]+}/{cz:-?[0-9]+}/{format}", tileRouterHandler).Methods("GET")
+	router.HandleFunc("/xyz/{world}/{dim}/{ttype}/{z:[0-9]+}/{x:-?[0-9]+}/{y:-?[0-9]+}.{format}", xyzTileHandler).Methods("GET")
+	router.HandleFunc("/xyz/{world}/{dim}/{ttype}/tilejson.json", apiHandle(apiXyzTileJSON)).Methods("GET")
 	router.HandleFunc("/view", basicTemplateResponseHandler("view")).Methods("GET")
 	router.HandleFunc("/colors", colorsHandlerGET).Methods("GET")
 	router.HandleFunc("/colors", colorsHandlerPOST).Methods("POST")
 	router.HandleFunc("/colors/save", colorsSaveHandler).Methods("GET")
 	router.HandleFunc("/cfg", cfgHandler).Methods("GET")
+	router.HandleFunc("/signs", signsHandler).Methods("GET")
+	router.HandleFunc("/chat", chatHandler).Methods("GET")
+	router.HandleFunc("/maps/{world}", mapsHandler).Methods("GET")
+	router.HandleFunc("/maps/{world}/{map:[0-9]+}.png", mapImageHandler).Methods("GET")
 
 	router.HandleFunc("/api/v1/config/save", apiHandle(apiSaveConfig)).Methods("GET")
 
@@ -46,12 +55,78 @@ func createRouter(exitchan <-chan struct{}) http.Handler {
 
 	router.HandleFunc("/api/v1/worlds", apiHandle(apiAddWorld)).Methods("POST")
 	router.HandleFunc("/api/v1/worlds", apiHandle(apiListWorlds)).Methods("GET")
+	router.HandleFunc("/api/v1/worlds/{world}/metadata", apiHandle(apiGetWorldMetadata)).Methods("GET")
+	router.HandleFunc("/api/v1/worlds/{world}/metadata", apiHandle(apiSetWorldMetadata)).Methods("PUT")
 
 	router.HandleFunc("/api/v1/dims", apiHandle(apiAddDimension)).Methods("POST")
 	router.HandleFunc("/api/v1/dims", apiHandle(apiListDimensions)).Methods("GET")
+	router.HandleFunc("/api/v1/share", apiHandle(apiCreateShareLink)).Methods("POST")
+	router.HandleFunc("/api/v1/map/{world}/{dim}", apiHandle(apiMapDescriptor)).Methods("GET")
+	router.HandleFunc("/api/v1/map/{world}/{dim}/view", apiHandle(apiGetMapView)).Methods("GET")
+	router.HandleFunc("/api/v1/map/{world}/{dim}/view", apiHandle(apiSetMapView)).Methods("PUT")
+	router.HandleFunc("/api/v1/coords/{world}/{dim}", apiHandle(apiConvertCoords)).Methods("GET")
+	router.HandleFunc("/api/v1/density/{world}/{dim}", apiHandle(apiRegionDensity)).Methods("GET")
+	router.HandleFunc("/api/v1/timelapse/{world}/{dim}", apiHandle(apiTimelapse)).Methods("GET")
+
+	router.HandleFunc("/api/v1/players", apiHandle(apiListPlayers)).Methods("GET")
+	router.HandleFunc("/api/v1/players/{player}/tablist", apiHandle(apiPlayerTabList)).Methods("GET")
+	router.HandleFunc("/api/v1/players/{player}/data", apiHandle(apiPurgePlayer)).Methods("DELETE")
+	router.HandleFunc("/api/v1/purge/key", apiHandle(apiPurgeKey)).Methods("GET")
+	router.HandleFunc("/api/v1/skins/{uuid}/head.png", apiPlayerHead).Methods("GET")
+	router.HandleFunc("/api/v1/proxy/sessions", apiHandle(apiListProxySessions)).Methods("GET")
+	router.HandleFunc("/api/v1/proxy/sessions/{session:[0-9]+}", apiHandle(apiGetProxySession)).Methods("GET")
+	router.HandleFunc("/api/v1/proxy/metrics", apiHandle(apiProxyMetrics)).Methods("GET")
+	router.HandleFunc("/api/v1/layers/heightmap/gradient", apiHandle(apiGetHeightGradient)).Methods("GET")
+	router.HandleFunc("/api/v1/layers/heightmap/gradient", apiHandle(apiSetHeightGradient)).Methods("PUT")
+	router.HandleFunc("/api/v1/proxy/acl", apiHandle(apiGetProxyACL)).Methods("GET")
+	router.HandleFunc("/api/v1/proxy/acl", apiHandle(apiUpdateProxyACL)).Methods("POST")
+	router.HandleFunc("/api/v1/proxy/acl/{player}", apiHandle(apiRemoveFromProxyACL)).Methods("DELETE")
+	router.HandleFunc("/api/v1/proxy/routes", apiHandle(apiListProxyRoutes)).Methods("GET")
+	router.HandleFunc("/api/v1/proxy/routes/{player}", apiHandle(apiSetProxyRoute)).Methods("PUT")
+	router.HandleFunc("/api/v1/proxy/routes/{player}", apiHandle(apiRemoveProxyRoute)).Methods("DELETE")
+	router.HandleFunc("/api/v1/accounts", apiHandle(apiListAccounts)).Methods("GET")
+	router.HandleFunc("/api/v1/accounts/login", apiHandle(apiStartAccountLogin)).Methods("POST")
+	router.HandleFunc("/api/v1/accounts/login/{code}", apiHandle(apiAccountLoginStatus)).Methods("GET")
+	router.HandleFunc("/api/v1/accounts/{name}/refresh", apiHandle(apiRefreshAccount)).Methods("POST")
+	router.HandleFunc("/api/v1/accounts/{name}", apiHandle(apiRemoveAccount)).Methods("DELETE")
+
+	router.HandleFunc("/api/v1/search/coords", apiHandle(apiSearchCoords)).Methods("GET")
+	router.HandleFunc("/api/v1/signs/{world}", apiHandle(apiSearchSigns)).Methods("GET")
+	router.HandleFunc("/api/v1/maps/{world}", apiHandle(apiListMaps)).Methods("GET")
+	router.HandleFunc("/api/v1/maps/{world}", apiHandle(apiImportMaps)).Methods("POST")
+	router.HandleFunc("/api/v1/chat/{world}", apiHandle(apiSearchChat)).Methods("GET")
+	router.HandleFunc("/api/v1/scoreboard/{world}", apiHandle(apiScoreboard)).Methods("GET")
+	router.HandleFunc("/api/v1/scoreboard/{world}/history", apiHandle(apiScoreboardHistory)).Methods("GET")
+	router.HandleFunc("/api/v1/worldtime/{world}/{dim}", apiHandle(apiWorldTime)).Methods("GET")
+
+	router.HandleFunc("/api/v1/blockmarkers/{world}/{dim}", apiHandle(apiListBlockMarkers)).Methods("GET")
+	router.HandleFunc("/api/v1/mvt/{world}/{dim}/{z:[0-9]+}/{x:-?[0-9]+}/{y:-?[0-9]+}.pbf", apiHandle(apiMvtTile)).Methods("GET")
+	router.HandleFunc("/api/v1/mvt/{world}/{dim}/tilejson.json", apiHandle(apiMvtTileJSON)).Methods("GET")
+	router.HandleFunc("/api/v1/villages/{world}/{dim}", apiHandle(apiListVillages)).Methods("GET")
+	router.HandleFunc("/api/v1/villages/{world}/{dim}/at", apiHandle(apiGetVillage)).Methods("GET")
+
+	router.HandleFunc("/api/v1/farms/{world}/{dim}", apiHandle(apiListFarms)).Methods("GET")
+	router.HandleFunc("/api/v1/farms/{world}/{dim}", apiHandle(apiAddFarm)).Methods("POST")
+	router.HandleFunc("/api/v1/farms/{world}/{dim}/{farm}", apiHandle(apiDeleteFarm)).Methods("DELETE")
+	router.HandleFunc("/api/v1/farms/{world}/{dim}/{farm}/output", apiHandle(apiFarmOutput)).Methods("GET")
+	router.HandleFunc("/api/v1/markers/{world}/{dim}", apiHandle(apiListMarkers)).Methods("GET")
+	router.HandleFunc("/api/v1/markers/{world}/{dim}", apiHandle(apiAddMarker)).Methods("POST")
+	router.HandleFunc("/api/v1/markers/{world}/{dim}/{marker}", apiHandle(apiDeleteMarker)).Methods("DELETE")
+	router.HandleFunc("/api/v1/annotations/{world}/{dim}", apiHandle(apiListAnnotations)).Methods("GET")
+	router.HandleFunc("/api/v1/annotations/{world}/{dim}", apiHandle(apiSetAnnotation)).Methods("PUT")
+	router.HandleFunc("/api/v1/annotations/{world}/{dim}/{place:chunk|region}/{x:-?[0-9]+}/{z:-?[0-9]+}", apiHandle(apiDeleteAnnotation)).Methods("DELETE")
+	router.HandleFunc("/api/v1/deaths/{world}/{dim}", apiHandle(apiListDeaths)).Methods("GET")
+	router.HandleFunc("/api/v1/trails/{world}/{dim}", apiHandle(apiListTrails)).Methods("GET")
+	router.HandleFunc("/api/v1/discoverers/{world}/{dim}", apiHandle(apiListExplorationStats)).Methods("GET")
+	router.HandleFunc("/api/v1/backups", apiHandle(apiListBackups)).Methods("GET")
+	router.HandleFunc("/api/v1/backups", apiHandle(apiRunBackup)).Methods("POST")
+	router.HandleFunc("/api/v1/sync", apiHandle(apiRunPeerSync)).Methods("POST")
+	router.HandleFunc("/api/v1/sync/{world}/{dim}/chunks", apiHandle(apiSyncListChunks)).Methods("GET")
+	router.HandleFunc("/api/v1/sync/{world}/{dim}/chunks", apiHandle(apiSyncGetChunks)).Methods("POST")
 
 	router.HandleFunc("/api/v1/ws", wsClientHandlerWrapper(exitchan))
 
+	router.HandleFunc("/api/v1/chunks/{world}/{dim}/{cx:-?[0-9]+}/{cz:-?[0-9]+}/stats", apiHandle(apiChunkStats)).Methods("GET")
 	router.HandleFunc("/debug/chunk/{world}/{dim}/{cx:-?[0-9]+}/{cz:-?[0-9]+}", terrainInfoHandler).Methods("GET")
 	router.HandleFunc("/debug/pprof/", pprof.Index)
 	router.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
@@ -69,11 +144,14 @@ func createRouter(exitchan <-chan struct{}) http.Handler {
 		w.Write([]byte("ok"))
 	})
 
+	router.Use(accessMiddleware)
+	router.Use(bodyLimitMiddleware)
+
 	router1 := handlers.ProxyHeaders(router)
 	router2 := handlers.CompressHandler(router1)
 	router3 := handlers.CustomLoggingHandler(os.Stdout, router2, customLogger)
 	router4 := handlers.RecoveryHandler(handlers.PrintRecoveryStack(true))(router3)
-	return router4
+	return requestIDMiddleware(router4)
 }
 
 func runWeb(exitchan <-chan struct{}) {
@@ -86,6 +164,7 @@ func runWeb(exitchan <-chan struct{}) {
 		Addr:    addr,
 		Handler: createRouter(exitchan),
 	}
+	applyServerLimits(&websrv)
 	log.Println("Web server listens on " + addr)
 	go func() {
 		if err := websrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
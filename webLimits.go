@@ -0,0 +1,88 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// body size classes, first matching path prefix wins
+var bodyLimitClasses = []struct {
+	prefix string
+	class  string
+	def    int64
+}{
+	{"/api/v1/submit/", "submit", 16 << 20},
+	{"/api/v1/maps/", "upload", 64 << 20},
+	{"/", "default", 1 << 20},
+}
+
+func bodyLimitFor(path string) int64 {
+	for _, c := range bodyLimitClasses {
+		if strings.HasPrefix(path, c.prefix) {
+			return int64(cfg.GetDSInt(int(c.def), "web", "body_limits", c.class))
+		}
+	}
+	return -1
+}
+
+// caps request bodies so nobody can stream gigabytes into io.ReadAll,
+// limit of 0 or less lets everything through
+func bodyLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := bodyLimitFor(r.URL.Path)
+		if limit > 0 && r.Body != nil {
+			if r.ContentLength > limit {
+				w.Header().Set("Connection", "close")
+				http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// status for errors from reading the body
+func bodyReadErrorStatus(err error) int {
+	var mbe *http.MaxBytesError
+	if errors.As(err, &mbe) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+func secondsConf(def int, path ...string) time.Duration {
+	return time.Duration(cfg.GetDSInt(def, path...)) * time.Second
+}
+
+// timeouts keep slow clients from holding connections forever,
+// websockets are not affected since upgrade clears deadlines
+func applyServerLimits(srv *http.Server) {
+	srv.ReadHeaderTimeout = secondsConf(10, "web", "timeouts", "read_header")
+	srv.ReadTimeout = secondsConf(120, "web", "timeouts", "read")
+	srv.WriteTimeout = secondsConf(300, "web", "timeouts", "write")
+	srv.IdleTimeout = secondsConf(120, "web", "timeouts", "idle")
+	srv.MaxHeaderBytes = cfg.GetDSInt(64<<10, "web", "max_header_bytes")
+}
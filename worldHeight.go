@@ -0,0 +1,71 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"github.com/maxsupermanhd/WebChunk/chunkStorage"
+	"github.com/maxsupermanhd/go-vmc/v764/save"
+)
+
+// vertical extent of a dimension in blocks, top is exclusive
+type heightRange struct {
+	bottom, top int
+}
+
+var defaultHeightRange = heightRange{-64, 320}
+
+// dimension type stored with the dimension wins, guessed from name otherwise
+func dimensionType(s chunkStorage.ChunkStorage, wname, dname string) save.DimensionType {
+	if d, err := s.GetDimension(wname, dname); err == nil && d != nil && d.Data.Height > 0 {
+		return d.Data
+	}
+	return chunkStorage.GuessDimTypeFromName(dname)
+}
+
+func dimensionHeightRange(s chunkStorage.ChunkStorage, wname, dname string) heightRange {
+	dt := dimensionType(s, wname, dname)
+	if dt.Height <= 0 {
+		return defaultHeightRange
+	}
+	return heightRange{int(dt.MinY), int(dt.MinY + dt.Height)}
+}
+
+// chunk along with height of its dimension for painters that scale by it
+type rangedChunk struct {
+	chunk save.Chunk
+	hr    heightRange
+}
+
+func withHeightRange(s chunkStorage.ChunkStorage, getter chunkDataProviderFunc) chunkDataProviderFunc {
+	return func(wname, dname string, cx0, cz0, cx1, cz1 int) ([]chunkStorage.ChunkData, error) {
+		cc, err := getter(wname, dname, cx0, cz0, cx1, cz1)
+		if err != nil {
+			return cc, err
+		}
+		hr := dimensionHeightRange(s, wname, dname)
+		for i := range cc {
+			if c, ok := cc[i].Data.(save.Chunk); ok {
+				cc[i].Data = rangedChunk{chunk: c, hr: hr}
+			}
+		}
+		return cc, nil
+	}
+}
@@ -0,0 +1,138 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/maxsupermanhd/WebChunk/chunkStorage"
+)
+
+const worldMetadataMaxText = 8192
+
+// last record of a world is the current metadata
+var (
+	worldMetadataCache = map[string]chunkStorage.WorldMetadata{}
+	worldMetadataLock  sync.Mutex
+)
+
+func getWorldMetadata(wname string) (chunkStorage.WorldMetadata, bool) {
+	worldMetadataLock.Lock()
+	defer worldMetadataLock.Unlock()
+	if m, ok := worldMetadataCache[wname]; ok {
+		return m, !m.Updated.IsZero()
+	}
+	var m chunkStorage.WorldMetadata
+	err := recs.Read(wname, "", "worldmeta", func(raw json.RawMessage) error {
+		var r chunkStorage.WorldMetadata
+		if json.Unmarshal(raw, &r) == nil {
+			m = r
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to load metadata of world %s: %s", wname, err.Error())
+		return m, false
+	}
+	worldMetadataCache[wname] = m
+	return m, !m.Updated.IsZero()
+}
+
+func setWorldMetadata(wname string, m chunkStorage.WorldMetadata) error {
+	worldMetadataLock.Lock()
+	defer worldMetadataLock.Unlock()
+	if err := recs.Append(wname, "", "worldmeta", m); err != nil {
+		return err
+	}
+	worldMetadataCache[wname] = m
+	return nil
+}
+
+// for backup manifests, worlds without metadata are left out
+func worldsMetadata(worlds []chunkStorage.SWorld) map[string]chunkStorage.WorldMetadata {
+	ret := map[string]chunkStorage.WorldMetadata{}
+	for _, w := range worlds {
+		if m, ok := getWorldMetadata(w.Name); ok {
+			ret[w.Name] = m
+		}
+	}
+	return ret
+}
+
+func validateWorldMetadata(m chunkStorage.WorldMetadata) error {
+	for _, s := range []string{m.Description, m.CaptureRules, m.License, m.Attribution} {
+		if len(s) > worldMetadataMaxText {
+			return fmt.Errorf("text fields are limited to %d bytes", worldMetadataMaxText)
+		}
+	}
+	if len(m.Links) > 32 {
+		return fmt.Errorf("too many links")
+	}
+	for _, l := range m.Links {
+		u, err := url.Parse(l.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("link %q is not a http(s) url", l.URL)
+		}
+	}
+	return nil
+}
+
+func apiGetWorldMetadata(w http.ResponseWriter, r *http.Request) (int, string) {
+	wname := mux.Vars(r)["world"]
+	m, ok := getWorldMetadata(wname)
+	if !ok {
+		return 404, "World has no metadata"
+	}
+	setContentTypeJson(w)
+	return marshalOrFail(200, m)
+}
+
+// body is the whole metadata object, fields that are left out are cleared
+func apiSetWorldMetadata(w http.ResponseWriter, r *http.Request) (int, string) {
+	wname := mux.Vars(r)["world"]
+	world, _, err := storages.World(wname)
+	if err != nil {
+		return 500, err.Error()
+	}
+	if world == nil {
+		return 404, "World not found"
+	}
+	var m chunkStorage.WorldMetadata
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		return bodyReadErrorStatus(err), "Bad metadata: " + err.Error()
+	}
+	if err := validateWorldMetadata(m); err != nil {
+		return 400, err.Error()
+	}
+	m.Updated = time.Now()
+	if err := setWorldMetadata(wname, m); err != nil {
+		return 500, err.Error()
+	}
+	setContentTypeJson(w)
+	return marshalOrFail(200, m)
+}
@@ -0,0 +1,46 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"github.com/google/uuid"
+	"github.com/maxsupermanhd/go-vmc/v764/save"
+)
+
+// chunks for the world server straight from storages
+type storagesWorldSource struct{}
+
+func (storagesWorldSource) Worlds() map[string][]string {
+	return listNamesWnD()
+}
+
+func (storagesWorldSource) Chunk(wname, dname string, cx, cz int) (*save.Chunk, error) {
+	_, s, err := storages.World(wname)
+	if err != nil || s == nil {
+		return nil, err
+	}
+	return s.GetChunk(wname, dname, cx, cz)
+}
+
+// server list is public so it follows privacy mode
+func worldServerPlayerName(name string, id uuid.UUID) (string, uuid.UUID) {
+	return anonymizeName(name), anonymizeUUID(id)
+}
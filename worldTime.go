@@ -0,0 +1,209 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/maxsupermanhd/WebChunk/proxy"
+)
+
+type worldTimeRecord struct {
+	Time      time.Time
+	Player    string
+	Age       int64
+	TimeOfDay int64
+	Raining   bool
+	Rain      float32
+	Thunder   float32
+}
+
+// server sends time every second, it is only written down once in a while,
+// when weather changes or when time does not go as expected
+const (
+	worldTimeRecordInterval = 5 * time.Minute
+	worldTimeJumpTicks      = 200
+)
+
+var (
+	worldTimes      = map[entityDensityKey]*worldTimeRecord{}
+	worldTimesSaved = map[entityDensityKey]worldTimeRecord{}
+	worldTimesLock  sync.Mutex
+)
+
+// must be called with worldTimesLock held, nil when nothing was seen yet
+func getWorldTime(k entityDensityKey) *worldTimeRecord {
+	if t, ok := worldTimes[k]; ok {
+		return t
+	}
+	var last *worldTimeRecord
+	err := recs.Read(k.world, k.dimension, "worldtime", func(m json.RawMessage) error {
+		var r worldTimeRecord
+		if json.Unmarshal(m, &r) == nil {
+			last = &r
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to read time records of [%s] [%s]: %s", k.world, k.dimension, err.Error())
+	}
+	worldTimes[k] = last
+	if last != nil {
+		worldTimesSaved[k] = *last
+	}
+	return last
+}
+
+// ticks time of day would be at given moment if daylight cycle is running
+func (r worldTimeRecord) ticksAt(t time.Time) int64 {
+	if r.TimeOfDay < 0 {
+		return r.TimeOfDay
+	}
+	return r.TimeOfDay + int64(t.Sub(r.Time)/(50*time.Millisecond))
+}
+
+func worldTimeUpdate(e *proxy.ProxiedEvent, f func(r *worldTimeRecord)) {
+	k := entityDensityKey{world: e.Server, dimension: strings.TrimPrefix(e.Dimension, "minecraft:")}
+	worldTimesLock.Lock()
+	cur := getWorldTime(k)
+	if cur == nil {
+		cur = &worldTimeRecord{}
+		worldTimes[k] = cur
+	}
+	f(cur)
+	cur.Time = e.Time
+	cur.Player = e.Username
+	saved, ok := worldTimesSaved[k]
+	save := !ok || e.Time.Sub(saved.Time) > worldTimeRecordInterval ||
+		cur.Raining != saved.Raining || cur.Rain != saved.Rain || cur.Thunder != saved.Thunder ||
+		absInt64(cur.TimeOfDay-saved.ticksAt(e.Time)) > worldTimeJumpTicks
+	if save {
+		worldTimesSaved[k] = *cur
+	}
+	rec := *cur
+	worldTimesLock.Unlock()
+	if !save {
+		return
+	}
+	if err := recs.Append(k.world, k.dimension, "worldtime", rec); err != nil {
+		log.Printf("Failed to record world time: %s", err.Error())
+	}
+}
+
+func worldTimeReceived(e *proxy.ProxiedEvent, d proxy.EventWorldTime) {
+	worldTimeUpdate(e, func(r *worldTimeRecord) {
+		r.Age, r.TimeOfDay = d.Age, d.TimeOfDay
+	})
+}
+
+func weatherReceived(e *proxy.ProxiedEvent, d proxy.EventWeather) {
+	worldTimeUpdate(e, func(r *worldTimeRecord) {
+		r.Raining, r.Rain, r.Thunder = d.Raining, d.Rain, d.Thunder
+	})
+}
+
+func absInt64(a int64) int64 {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+// Clock is how in game clock would show it, tick 0 is 6 in the morning
+type worldTimeView struct {
+	Updated       time.Time
+	Day           int64
+	DayTime       int64
+	Clock         string
+	Phase         string
+	DaylightCycle bool
+	Weather       string
+	Rain          float32
+	Thunder       float32
+}
+
+func worldTimePhase(dayTime int64) string {
+	switch {
+	case dayTime < 12000:
+		return "day"
+	case dayTime < 13800:
+		return "sunset"
+	case dayTime < 22200:
+		return "night"
+	default:
+		return "sunrise"
+	}
+}
+
+// time is moved forward from when it was last received,
+// ok is false if nothing about the dimension was seen yet
+func currentWorldTime(wname, dname string) (worldTimeView, bool) {
+	k := entityDensityKey{world: wname, dimension: strings.TrimPrefix(dname, "minecraft:")}
+	worldTimesLock.Lock()
+	r := getWorldTime(k)
+	var rec worldTimeRecord
+	if r != nil {
+		rec = *r
+	}
+	worldTimesLock.Unlock()
+	if r == nil {
+		return worldTimeView{}, false
+	}
+	ticks := rec.ticksAt(time.Now())
+	if ticks < 0 {
+		ticks = -ticks
+	}
+	v := worldTimeView{
+		Updated:       rec.Time,
+		Day:           ticks / 24000,
+		DayTime:       ticks % 24000,
+		DaylightCycle: rec.TimeOfDay >= 0,
+		Weather:       "clear",
+		Rain:          rec.Rain,
+		Thunder:       rec.Thunder,
+	}
+	v.Clock = fmt.Sprintf("%02d:%02d", (v.DayTime/1000+6)%24, v.DayTime%1000*60/1000)
+	v.Phase = worldTimePhase(v.DayTime)
+	if rec.Raining {
+		v.Weather = "rain"
+		if rec.Thunder > 0.9 {
+			v.Weather = "thunder"
+		}
+	}
+	return v, true
+}
+
+func apiWorldTime(w http.ResponseWriter, r *http.Request) (int, string) {
+	params := mux.Vars(r)
+	v, ok := currentWorldTime(params["world"], params["dim"])
+	if !ok {
+		return 404, "Time of the dimension is not known yet"
+	}
+	setContentTypeJson(w)
+	return marshalOrFail(200, v)
+}
@@ -48,12 +48,8 @@ func apiAddWorld(w http.ResponseWriter, r *http.Request) (int, string) {
 	sname := r.FormValue("storage")
 	var driver chunkStorage.ChunkStorage
 	driver = nil
-	storagesLock.Lock()
-	defer storagesLock.Unlock()
-	for sn, s := range storages {
-		if sname == sn {
-			driver = s.Driver
-		}
+	if s, ok := storages.Get(sname); ok {
+		driver = s.Driver
 	}
 	if driver == nil {
 		return 400, "Storage not found or not initialized"
@@ -75,7 +71,7 @@ func apiAddWorld(w http.ResponseWriter, r *http.Request) (int, string) {
 }
 
 func apiListWorlds(w http.ResponseWriter, _ *http.Request) (int, string) {
-	worlds := chunkStorage.ListWorlds(storages)
+	worlds := chunkStorage.ListWorlds(storages.Snapshot())
 	setContentTypeJson(w)
 	return marshalOrFail(200, worlds)
 }
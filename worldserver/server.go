@@ -0,0 +1,233 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+// Package worldserver lets game clients join WebChunk and fly around
+// stored chunks in spectator mode, nothing they do is saved anywhere
+package worldserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/maxsupermanhd/go-vmc/v764/chat"
+	"github.com/maxsupermanhd/go-vmc/v764/nbt"
+	"github.com/maxsupermanhd/go-vmc/v764/net"
+	"github.com/maxsupermanhd/go-vmc/v764/registry"
+	"github.com/maxsupermanhd/go-vmc/v764/save"
+	"github.com/maxsupermanhd/go-vmc/v764/server"
+	"github.com/maxsupermanhd/go-vmc/v764/server/auth"
+	"github.com/maxsupermanhd/lac"
+)
+
+const (
+	protocolName    = "1.20.2"
+	protocolVersion = 764
+)
+
+// Source is where chunks come from, missing chunk is nil without error
+type Source interface {
+	Worlds() map[string][]string
+	Chunk(world, dim string, cx, cz int) (*save.Chunk, error)
+}
+
+type worldServer struct {
+	cfg        *lac.ConfSubtree
+	src        Source
+	names      func(string, uuid.UUID) (string, uuid.UUID)
+	ctx        context.Context
+	registries registry.NetworkCodec
+
+	lock    sync.Mutex
+	players map[uuid.UUID]string
+}
+
+func loadRegistries(path string) (ret registry.NetworkCodec, err error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ret, err
+	}
+	err = nbt.Unmarshal(b, &ret)
+	if err == nil && len(ret.DimensionType.Value) == 0 {
+		err = errors.New("no dimension types")
+	}
+	return ret, err
+}
+
+// names turns player names and uuids into what others are shown in server list
+func Run(ctx context.Context, cfg *lac.ConfSubtree, src Source, names func(string, uuid.UUID) (string, uuid.UUID)) {
+	listenAddr := cfg.GetDSString("", "listen_addr")
+	if listenAddr == "" {
+		return
+	}
+	regPath := cfg.GetDSString("./registry.nbt", "registry_path")
+	reg, err := loadRegistries(regPath)
+	if err != nil {
+		log.Printf("World server disabled, failed to load registries from [%s] (join any server through the proxy once to get them): %s", regPath, err.Error())
+		return
+	}
+	ws := &worldServer{
+		cfg:        cfg,
+		src:        src,
+		names:      names,
+		ctx:        ctx,
+		registries: reg,
+		players:    map[uuid.UUID]string{},
+	}
+	s := server.Server{
+		ListPingHandler: ws,
+		LoginHandler: &server.MojangLoginHandler{
+			OnlineMode: cfg.GetDSBool(true, "online_mode"),
+			Threshold:  cfg.GetDSInt(256, "compress_threshold"),
+		},
+		GamePlay: ws,
+	}
+	listener, err := net.ListenMC(listenAddr)
+	if err != nil {
+		log.Println("World server startup error: ", err)
+		return
+	}
+	log.Println("World server started on " + listenAddr)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Println("World server listener error: ", err)
+					continue
+				}
+				return
+			}
+			wg.Add(1)
+			go func() {
+				s.AcceptConn(&conn)
+				wg.Done()
+			}()
+		}
+	}()
+	<-ctx.Done()
+	if err := listener.Close(); err != nil {
+		log.Println("World server listener close error: ", err)
+	}
+	wg.Wait()
+}
+
+func (ws *worldServer) Name() string {
+	return protocolName
+}
+
+func (ws *worldServer) Protocol(int32) int {
+	return protocolVersion
+}
+
+func (ws *worldServer) MaxPlayer() int {
+	return ws.cfg.GetDSInt(20, "max_players")
+}
+
+func (ws *worldServer) OnlinePlayer() int {
+	ws.lock.Lock()
+	defer ws.lock.Unlock()
+	return len(ws.players)
+}
+
+func (ws *worldServer) PlayerSamples() []server.PlayerSample {
+	ws.lock.Lock()
+	defer ws.lock.Unlock()
+	ret := []server.PlayerSample{}
+	for id, name := range ws.players {
+		n, i := ws.names(name, id)
+		ret = append(ret, server.PlayerSample{Name: n, ID: i})
+	}
+	return ret
+}
+
+func (ws *worldServer) Description() *chat.Message {
+	m := chat.Text(ws.cfg.GetDSString("WebChunk world viewer", "motd"))
+	return &m
+}
+
+func (ws *worldServer) FavIcon() string {
+	return ""
+}
+
+// players see every stored chunk so only listed ones get in,
+// empty list keeps everyone out
+func (ws *worldServer) playerAllowed(name string, id uuid.UUID) bool {
+	allowed := []string{}
+	if err := ws.cfg.GetToStruct(&allowed, "allowed_players"); err != nil && !errors.Is(err, lac.ErrNoKey) {
+		log.Printf("Failed to parse world server allowed players: %s", err.Error())
+		return false
+	}
+	for _, a := range allowed {
+		if strings.EqualFold(a, name) || strings.EqualFold(a, id.String()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ws *worldServer) AcceptPlayer(name string, id uuid.UUID, _ *auth.PublicKey, _ []auth.Property, protocol int32, conn *net.Conn) {
+	s := ws.newSession(name, conn)
+	if protocol != protocolVersion {
+		log.Printf("World server rejected [%s]: protocol %d is not supported", name, protocol)
+		s.kick(fmt.Sprintf("Only %s clients are supported", protocolName))
+		return
+	}
+	if !ws.playerAllowed(name, id) {
+		log.Printf("World server rejected [%s] (%s): not in allowed players", name, id)
+		s.kick("You are not allowed to join this server")
+		return
+	}
+	ws.lock.Lock()
+	if len(ws.players) >= ws.MaxPlayer() {
+		ws.lock.Unlock()
+		s.kick("Server is full")
+		return
+	}
+	ws.players[id] = name
+	ws.lock.Unlock()
+	defer func() {
+		ws.lock.Lock()
+		delete(ws.players, id)
+		ws.lock.Unlock()
+	}()
+	log.Printf("World server player [%s] (%s) joined", name, id)
+	err := s.run()
+	log.Printf("World server player [%s] left: %v", name, err)
+}
+
+// fully qualified dimension name as registry has it
+func dimensionKey(dim string) string {
+	if dim == "" {
+		return "minecraft:overworld"
+	}
+	if strings.Contains(dim, ":") {
+		return dim
+	}
+	return "minecraft:" + dim
+}
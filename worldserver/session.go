@@ -0,0 +1,555 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package worldserver
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maxsupermanhd/go-vmc/v764/chat"
+	"github.com/maxsupermanhd/go-vmc/v764/data/packetid"
+	"github.com/maxsupermanhd/go-vmc/v764/level"
+	"github.com/maxsupermanhd/go-vmc/v764/net"
+	pk "github.com/maxsupermanhd/go-vmc/v764/net/packet"
+	"github.com/maxsupermanhd/go-vmc/v764/registry"
+	"github.com/maxsupermanhd/go-vmc/v764/save"
+)
+
+type session struct {
+	ws   *worldServer
+	name string
+	conn *net.Conn
+
+	world, dim   string
+	dimType      *registry.Dimension
+	x, y, z      float64
+	center       level.ChunkPos
+	loaded       map[level.ChunkPos]bool
+	viewDistance int
+	teleportID   int32
+}
+
+func (ws *worldServer) newSession(name string, conn *net.Conn) *session {
+	return &session{
+		ws:           ws,
+		name:         name,
+		conn:         conn,
+		loaded:       map[level.ChunkPos]bool{},
+		viewDistance: ws.cfg.GetDSInt(8, "view_distance"),
+	}
+}
+
+func (s *session) acknowledgeLogin() error {
+	var p pk.Packet
+	if err := s.conn.ReadPacket(&p); err != nil {
+		return err
+	}
+	if packetid.ServerboundPacketID(p.ID) != packetid.ServerboundLoginAcknowledged {
+		return fmt.Errorf("expected login acknowledgement, got packet %#02x", p.ID)
+	}
+	return nil
+}
+
+// disconnect before configuration started
+func (s *session) kick(msg string) {
+	if s.acknowledgeLogin() != nil {
+		return
+	}
+	_ = s.conn.WritePacket(pk.Marshal(packetid.ClientboundConfigDisconnect, chat.Text(msg)))
+}
+
+func (s *session) configure() error {
+	err := s.conn.WritePacket(pk.Marshal(packetid.ClientboundConfigRegistryData, pk.NBT(&s.ws.registries)))
+	if err != nil {
+		return err
+	}
+	err = s.conn.WritePacket(pk.Marshal(packetid.ClientboundConfigUpdateEnabledFeatures, pk.Array([]pk.Identifier{"minecraft:vanilla"})))
+	if err != nil {
+		return err
+	}
+	err = s.conn.WritePacket(pk.Marshal(packetid.ClientboundConfigFinishConfiguration))
+	if err != nil {
+		return err
+	}
+	for {
+		var p pk.Packet
+		if err := s.conn.ReadPacket(&p); err != nil {
+			return err
+		}
+		if packetid.ServerboundPacketID(p.ID) == packetid.ServerboundConfigFinishConfiguration {
+			return nil
+		}
+	}
+}
+
+// configured world and dimension if they exist, first stored ones otherwise
+func (s *session) pickSpawnWorld() bool {
+	worlds := s.ws.src.Worlds()
+	wname := s.ws.cfg.GetDSString("", "world")
+	if _, ok := worlds[wname]; !ok {
+		wname = ""
+		names := make([]string, 0, len(worlds))
+		for n := range worlds {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		for _, n := range names {
+			if len(worlds[n]) > 0 {
+				wname = n
+				break
+			}
+		}
+	}
+	if wname == "" {
+		return false
+	}
+	dname := s.ws.cfg.GetDSString("overworld", "dimension")
+	if !containsString(worlds[wname], dname) {
+		dname = worlds[wname][0]
+	}
+	s.setWorld(wname, dname)
+	s.x = float64(s.ws.cfg.GetDSInt(0, "spawn_x")) + 0.5
+	s.y = float64(s.ws.cfg.GetDSInt(100, "spawn_y"))
+	s.z = float64(s.ws.cfg.GetDSInt(0, "spawn_z")) + 0.5
+	return true
+}
+
+func containsString(arr []string, v string) bool {
+	for _, a := range arr {
+		if a == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *session) setWorld(wname, dname string) {
+	s.world, s.dim = wname, dname
+	_, s.dimType = s.ws.registries.DimensionType.Find(s.dimTypeKey())
+}
+
+// dimensions that are not in registry are shown as overworld
+func (s *session) dimTypeKey() string {
+	if id, _ := s.ws.registries.DimensionType.Find(dimensionKey(s.dim)); id >= 0 {
+		return dimensionKey(s.dim)
+	}
+	return s.ws.registries.DimensionType.Value[0].Name
+}
+
+func (s *session) dimensionNames() []pk.Identifier {
+	ret := []pk.Identifier{}
+	for _, d := range s.ws.src.Worlds()[s.world] {
+		ret = append(ret, pk.Identifier(dimensionKey(d)))
+	}
+	return ret
+}
+
+func (s *session) run() error {
+	if err := s.acknowledgeLogin(); err != nil {
+		return err
+	}
+	if err := s.configure(); err != nil {
+		return err
+	}
+	if !s.pickSpawnWorld() {
+		s.disconnect("There are no stored worlds to show")
+		return nil
+	}
+	err := s.conn.WritePacket(pk.Marshal(
+		packetid.ClientboundLogin,
+		pk.Int(1),
+		pk.Boolean(false),
+		pk.Array(s.dimensionNames()),
+		pk.VarInt(s.ws.MaxPlayer()),
+		pk.VarInt(s.viewDistance),
+		pk.VarInt(s.viewDistance),
+		pk.Boolean(false),
+		pk.Boolean(false),
+		pk.Boolean(false),
+		pk.Identifier(s.dimTypeKey()),
+		pk.Identifier(dimensionKey(s.dim)),
+		pk.Long(0),
+		pk.UnsignedByte(3), // spectator
+		pk.Byte(-1),
+		pk.Boolean(false),
+		pk.Boolean(false),
+		pk.Boolean(false),
+		pk.VarInt(0),
+	))
+	if err != nil {
+		return err
+	}
+	if err := s.spawn(); err != nil {
+		return err
+	}
+	s.message(fmt.Sprintf("Viewing world %s dimension %s, type /help for commands", s.world, s.dim))
+
+	packets := make(chan pk.Packet, 64)
+	readErr := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			var p pk.Packet
+			if err := s.conn.ReadPacket(&p); err != nil {
+				readErr <- err
+				close(packets)
+				return
+			}
+			select {
+			case packets <- p:
+			case <-done:
+				return
+			}
+		}
+	}()
+	keepAlive := time.NewTicker(10 * time.Second)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-s.ws.ctx.Done():
+			s.disconnect("WebChunk is shutting down")
+			return nil
+		case err := <-readErr:
+			return err
+		case t := <-keepAlive.C:
+			if err := s.conn.WritePacket(pk.Marshal(packetid.ClientboundKeepAlive, pk.Long(t.UnixMilli()))); err != nil {
+				return err
+			}
+		case p, ok := <-packets:
+			if !ok {
+				return <-readErr
+			}
+			if err := s.handle(p); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *session) disconnect(msg string) {
+	_ = s.conn.WritePacket(pk.Marshal(packetid.ClientboundDisconnect, chat.Text(msg)))
+}
+
+func (s *session) message(msg string) {
+	_ = s.conn.WritePacket(pk.Marshal(packetid.ClientboundSystemChat, chat.Text(msg), pk.Boolean(false)))
+}
+
+// position, abilities and chunks around, after login and respawn
+func (s *session) spawn() error {
+	s.loaded = map[level.ChunkPos]bool{}
+	s.center = level.ChunkPos{int32(math.Floor(s.x / 16)), int32(math.Floor(s.z / 16))}
+	err := s.conn.WritePacket(pk.Marshal(
+		packetid.ClientboundPlayerAbilities,
+		pk.Byte(0x07), // invulnerable, flying, may fly
+		pk.Float(0.05),
+		pk.Float(0.1),
+	))
+	if err != nil {
+		return err
+	}
+	err = s.conn.WritePacket(pk.Marshal(
+		packetid.ClientboundSetDefaultSpawnPosition,
+		pk.Position{X: int(s.x), Y: int(s.y), Z: int(s.z)},
+		pk.Float(0),
+	))
+	if err != nil {
+		return err
+	}
+	err = s.conn.WritePacket(pk.Marshal(packetid.ClientboundGameEvent, pk.UnsignedByte(13), pk.Float(0))) // start waiting for chunks
+	if err != nil {
+		return err
+	}
+	if err := s.teleport(); err != nil {
+		return err
+	}
+	return s.updateView(true)
+}
+
+func (s *session) teleport() error {
+	s.teleportID++
+	return s.conn.WritePacket(pk.Marshal(
+		packetid.ClientboundPlayerPosition,
+		pk.Double(s.x), pk.Double(s.y), pk.Double(s.z),
+		pk.Float(0), pk.Float(0),
+		pk.Byte(0),
+		pk.VarInt(s.teleportID),
+	))
+}
+
+func (s *session) handle(p pk.Packet) error {
+	switch packetid.ServerboundPacketID(p.ID) {
+	case packetid.ServerboundMovePlayerPos, packetid.ServerboundMovePlayerPosRot:
+		var x, y, z pk.Double
+		if err := p.Scan(&x, &y, &z); err != nil {
+			return err
+		}
+		s.x, s.y, s.z = float64(x), float64(y), float64(z)
+		return s.updateView(false)
+	case packetid.ServerboundChatCommand:
+		var cmd pk.String
+		if err := p.Scan(&cmd); err != nil {
+			return err
+		}
+		return s.command(strings.Fields(string(cmd)))
+	case packetid.ServerboundChat:
+		s.message("This server only shows stored chunks, type /help for commands")
+	}
+	return nil
+}
+
+func (s *session) command(args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+	switch args[0] {
+	case "worlds":
+		worlds := s.ws.src.Worlds()
+		names := make([]string, 0, len(worlds))
+		for n, dims := range worlds {
+			names = append(names, n+" ("+strings.Join(dims, ", ")+")")
+		}
+		sort.Strings(names)
+		s.message("Worlds: " + strings.Join(names, "; "))
+	case "world":
+		if len(args) < 2 {
+			s.message("Usage: /world <world> [dimension]")
+			return nil
+		}
+		dims, ok := s.ws.src.Worlds()[args[1]]
+		if !ok || len(dims) == 0 {
+			s.message("No such world, see /worlds")
+			return nil
+		}
+		dname := dims[0]
+		if len(args) > 2 {
+			if !containsString(dims, args[2]) {
+				s.message("No such dimension, see /worlds")
+				return nil
+			}
+			dname = args[2]
+		} else if containsString(dims, s.dim) {
+			dname = s.dim
+		}
+		return s.changeWorld(args[1], dname)
+	case "tp":
+		if len(args) < 3 {
+			s.message("Usage: /tp <x> <z> or /tp <x> <y> <z>")
+			return nil
+		}
+		coords := []float64{}
+		for _, a := range args[1:] {
+			v, err := strconv.ParseFloat(a, 64)
+			if err != nil {
+				s.message("Bad coordinate " + a)
+				return nil
+			}
+			coords = append(coords, v)
+		}
+		if len(coords) == 2 {
+			s.x, s.z = coords[0], coords[1]
+		} else {
+			s.x, s.y, s.z = coords[0], coords[1], coords[2]
+		}
+		if err := s.teleport(); err != nil {
+			return err
+		}
+		return s.updateView(false)
+	default:
+		s.message("Commands: /worlds, /world <world> [dimension], /tp <x> [y] <z>")
+	}
+	return nil
+}
+
+func (s *session) changeWorld(wname, dname string) error {
+	s.setWorld(wname, dname)
+	err := s.conn.WritePacket(pk.Marshal(
+		packetid.ClientboundRespawn,
+		pk.Identifier(s.dimTypeKey()),
+		pk.Identifier(dimensionKey(s.dim)),
+		pk.Long(0),
+		pk.UnsignedByte(3),
+		pk.Byte(-1),
+		pk.Boolean(false),
+		pk.Boolean(false),
+		pk.Boolean(false),
+		pk.VarInt(0),
+		pk.Byte(0),
+	))
+	if err != nil {
+		return err
+	}
+	if err := s.spawn(); err != nil {
+		return err
+	}
+	s.message(fmt.Sprintf("Viewing world %s dimension %s", s.world, s.dim))
+	return nil
+}
+
+// sends chunks that came into view distance closest first and unloads ones that left it
+func (s *session) updateView(force bool) error {
+	center := level.ChunkPos{int32(math.Floor(s.x / 16)), int32(math.Floor(s.z / 16))}
+	if center == s.center && !force {
+		return nil
+	}
+	s.center = center
+	err := s.conn.WritePacket(pk.Marshal(packetid.ClientboundSetChunkCacheCenter, pk.VarInt(center[0]), pk.VarInt(center[1])))
+	if err != nil {
+		return err
+	}
+	vd := int32(s.viewDistance)
+	for pos := range s.loaded {
+		if abs32(pos[0]-center[0]) > vd || abs32(pos[1]-center[1]) > vd {
+			delete(s.loaded, pos)
+			// chunk position goes as a single long with z in the high half
+			err := s.conn.WritePacket(pk.Marshal(packetid.ClientboundForgetLevelChunk, pk.Int(pos[1]), pk.Int(pos[0])))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	want := []level.ChunkPos{}
+	for x := center[0] - vd; x <= center[0]+vd; x++ {
+		for z := center[1] - vd; z <= center[1]+vd; z++ {
+			pos := level.ChunkPos{x, z}
+			if !s.loaded[pos] {
+				want = append(want, pos)
+			}
+		}
+	}
+	if len(want) == 0 {
+		return nil
+	}
+	sort.Slice(want, func(i, j int) bool {
+		return chunkDistance(want[i], center) < chunkDistance(want[j], center)
+	})
+	if err := s.conn.WritePacket(pk.Marshal(packetid.ClientboundChunkBatchStart)); err != nil {
+		return err
+	}
+	for _, pos := range want {
+		c, err := s.ws.src.Chunk(s.world, s.dim, int(pos[0]), int(pos[1]))
+		if err != nil {
+			log.Printf("World server failed to get chunk %v of %s/%s: %s", pos, s.world, s.dim, err.Error())
+		}
+		err = s.conn.WritePacket(pk.Marshal(packetid.ClientboundLevelChunkWithLight, pos, s.levelChunk(c)))
+		if err != nil {
+			return err
+		}
+		s.loaded[pos] = true
+	}
+	return s.conn.WritePacket(pk.Marshal(packetid.ClientboundChunkBatchFinished, pk.VarInt(len(want))))
+}
+
+func abs32(v int32) int32 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+func chunkDistance(a, b level.ChunkPos) int32 {
+	dx, dz := a[0]-b[0], a[1]-b[1]
+	return dx*dx + dz*dz
+}
+
+// stored chunks may miss sections or have been saved with another world height,
+// client only accepts exactly as many sections as dimension type says
+func (s *session) levelChunk(c *save.Chunk) *level.Chunk {
+	minY, height := int32(0), int32(256)
+	if s.dimType != nil {
+		minY, height = s.dimType.MinY, s.dimType.Height
+	}
+	secs := int(height / 16)
+	ret := level.EmptyChunk(secs)
+	if c != nil {
+		if lc := padChunk(c, minY, secs); lc != nil {
+			ret = lc
+		}
+	}
+	if s.ws.cfg.GetDSBool(true, "fullbright") {
+		for i := range ret.Sections {
+			ret.Sections[i].SkyLight = fullLight
+			ret.Sections[i].BlockLight = nil
+		}
+	}
+	return ret
+}
+
+var fullLight = func() []byte {
+	b := make([]byte, 2048)
+	for i := range b {
+		b[i] = 0xff
+	}
+	return b
+}()
+
+func padChunk(c *save.Chunk, minY int32, secs int) (ret *level.Chunk) {
+	defer func() {
+		// palette and heightmap sizes that do not add up make level panic
+		if r := recover(); r != nil {
+			ret = nil
+		}
+	}()
+	padded := *c
+	padded.YPos = minY >> 4
+	padded.Sections = make([]save.Section, secs)
+	have := map[int8]save.Section{}
+	for _, sec := range c.Sections {
+		have[sec.Y] = sec
+	}
+	for i := range padded.Sections {
+		y := int8(padded.YPos + int32(i))
+		sec, ok := have[y]
+		if !ok || len(sec.BlockStates.Palette) == 0 {
+			sec = save.Section{Y: y}
+			sec.BlockStates.Palette = []save.BlockState{{Name: "minecraft:air"}}
+		}
+		if len(sec.Biomes.Palette) == 0 {
+			sec.Biomes.Palette = []save.BiomeState{"minecraft:plains"}
+			sec.Biomes.Data = nil
+		}
+		padded.Sections[i] = sec
+	}
+	heightBits := bits.Len(uint(secs)*16 + 1)
+	perLong := 64 / heightBits
+	padded.Heightmaps = map[string][]uint64{}
+	for k, v := range c.Heightmaps {
+		if len(v) == (256+perLong-1)/perLong {
+			padded.Heightmaps[k] = v
+		}
+	}
+	lc, err := level.ChunkFromSave(&padded)
+	if err != nil {
+		// unknown block entities are not worth losing the whole chunk
+		padded.BlockEntities = nil
+		lc, err = level.ChunkFromSave(&padded)
+	}
+	if err != nil {
+		return nil
+	}
+	return lc
+}
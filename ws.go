@@ -34,6 +34,7 @@ import (
 	"time"
 
 	"github.com/gorilla/websocket"
+	"github.com/maxsupermanhd/WebChunk/client"
 	"github.com/maxsupermanhd/WebChunk/primitives"
 	"github.com/mitchellh/mapstructure"
 )
@@ -79,18 +80,25 @@ func wsClientHandler(w http.ResponseWriter, r *http.Request, exitchan <-chan str
 
 	log.Printf("Websocket %s connected", r.RemoteAddr)
 
+	hidePlayers := privacyHides(r)
+
 	pingTicker := time.NewTicker(2 * time.Second)
 
 	e := globalEventRouter.Connect()
 	defer globalEventRouter.Disconnect(e)
 
+	worlds := listNamesWnD()
+	e <- mapEvent{
+		Action: client.ActionUpdateLayers,
+		Data:   allowedLayersAnywhere(r, worlds, listttypes()),
+	}
 	e <- mapEvent{
-		Action: "updateLayers",
-		Data:   listttypes(),
+		Action: client.ActionUpdateWorldsAndDims,
+		Data:   worlds,
 	}
 	e <- mapEvent{
-		Action: "updateWorldsAndDims",
-		Data:   listNamesWnD(),
+		Action: client.ActionBulkPlayerUpdate,
+		Data:   playerTrackerSnapshot(),
 	}
 
 	eQ := make(chan error, 2)
@@ -115,10 +123,14 @@ func wsClientHandler(w http.ResponseWriter, r *http.Request, exitchan <-chan str
 		if loc.Dimension == "" || loc.World == "" {
 			return
 		}
+		// same as tile handler refuses, layer list does not stop anyone from asking
+		if !layerAllowed(r, loc.World, loc.Dimension, loc.Variant) {
+			return
+		}
 		img, err := imageGetSync(loc, false)
 		if err != nil {
 			b, _ := json.Marshal(map[string]any{
-				"Action": "message",
+				"Action": client.ActionMessage,
 				"Data":   fmt.Sprintf("Error rendering tile %s: %s", loc.String(), err),
 			})
 			wQ <- wsmessage{
@@ -147,6 +159,9 @@ clientLoop:
 			}
 		case m := <-e:
 			log.Printf("Websocket %s relaying message %#+v", r.RemoteAddr, m.Action)
+			if ps, ok := m.Data.(map[string]trackedPlayer); ok && hidePlayers {
+				m.Data = anonymizePlayers(ps)
+			}
 			b, err := json.Marshal(m)
 			if err != nil {
 				log.Printf("Failed to marshal progress: %v\n", err)
@@ -190,7 +205,7 @@ clientLoop:
 					log.Printf("Failed to decode websocket client %s message: %s", r.RemoteAddr, err.Error())
 				}
 				switch msg.Action {
-				case "tileSubscribe":
+				case client.ActionTileSubscribe:
 					var loc primitives.ImageLocation
 					err := mapstructure.Decode(msg.Data, &loc)
 					if err != nil {
@@ -205,7 +220,7 @@ clientLoop:
 						log.Printf("Websocket %s tileSub %s", r.RemoteAddr, loc)
 					}
 					go asyncTileRequestor(loc)
-				case "tileUnsubscribe":
+				case client.ActionTileUnsubscribe:
 					var loc primitives.ImageLocation
 					err := mapstructure.Decode(msg.Data, &loc)
 					if err != nil {
@@ -219,7 +234,7 @@ clientLoop:
 					} else {
 						log.Printf("Websocket %s tileUnsub does not exist %s", r.RemoteAddr, loc)
 					}
-				case "resubWorldDimension":
+				case client.ActionResubWorldDimension:
 					data, ok := msg.Data.(map[string]any)
 					if !ok {
 						log.Printf("Websocket %s sent malformed tile unsub: data not map", r.RemoteAddr)
@@ -265,7 +280,7 @@ var (
 
 func marshalBinaryTileUpdate(loc primitives.ImageLocation, img *image.RGBA) []byte {
 	buf := bytes.NewBuffer([]byte{})
-	binary.Write(buf, binary.BigEndian, uint8(0x01))
+	binary.Write(buf, binary.BigEndian, uint8(client.OpTileUpdate))
 	binary.Write(buf, binary.BigEndian, uint32(len(loc.World)))
 	buf.WriteString(loc.World)
 	binary.Write(buf, binary.BigEndian, uint32(len(loc.Dimension)))
@@ -0,0 +1,126 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/maxsupermanhd/WebChunk/chunkStorage"
+	"github.com/maxsupermanhd/go-vmc/v764/level/block"
+	"github.com/maxsupermanhd/go-vmc/v764/save"
+	"github.com/maxsupermanhd/lac"
+)
+
+var xrayDefaultBlocks = map[string]string{
+	"minecraft:diamond_ore":           "#5decf5ff",
+	"minecraft:deepslate_diamond_ore": "#5decf5ff",
+	"minecraft:ancient_debris":        "#a0522dff",
+	"minecraft:spawner":               "#ff00ffff",
+}
+
+// block ids to look for and their colors, ids may omit minecraft: prefix
+func getXrayBlocks() map[string]color.RGBA {
+	conf := map[string]string{}
+	err := cfg.GetToStruct(&conf, "layers", "xray", "blocks")
+	if err != nil {
+		if !errors.Is(err, lac.ErrNoKey) {
+			log.Printf("Failed to parse xray blocks, using defaults: %s", err.Error())
+		}
+		conf = xrayDefaultBlocks
+	}
+	ret := map[string]color.RGBA{}
+	for id, hex := range conf {
+		c, err := ParseHexColor(hex)
+		if err != nil {
+			log.Printf("Bad xray color of [%s]: %s", id, err.Error())
+			continue
+		}
+		if !strings.Contains(id, ":") {
+			id = "minecraft:" + id
+		}
+		ret[id] = color.RGBA{uint8(c.R >> 8), uint8(c.G >> 8), uint8(c.B >> 8), uint8(c.A >> 8)}
+	}
+	return ret
+}
+
+func xrayProvider(ymin, ymax int) ttypeProviderFunc {
+	return func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
+		return s.GetChunksRegion, func(i interface{}) *image.RGBA {
+			c := i.(save.Chunk)
+			return drawChunkXray(&c, ymin, ymax)
+		}
+	}
+}
+
+// topmost target block between ymin and ymax is drawn for every column,
+// columns without any are left transparent
+func drawChunkXray(chunk *save.Chunk, ymin, ymax int) (img *image.RGBA) {
+	t := time.Now()
+	targets := getXrayBlocks()
+	img = image.NewRGBA(image.Rect(0, 0, 16, 16))
+	sortSectionsTopDown(chunk)
+	// states repeat a lot, no need to look up id every time
+	stateColors := map[block.StateID]*color.RGBA{}
+	lookup := func(state block.StateID) *color.RGBA {
+		if c, ok := stateColors[state]; ok {
+			return c
+		}
+		var ret *color.RGBA
+		if c, ok := targets[stateName(state)]; ok {
+			ret = &c
+		}
+		stateColors[state] = ret
+		return ret
+	}
+	var done [16 * 16]bool
+	for _, s := range chunk.Sections {
+		sy := int(int8(s.Y)) * 16
+		if sy > ymax || sy+15 < ymin || len(s.BlockStates.Palette) == 0 {
+			continue
+		}
+		states := prepareSectionBlockstates(&s)
+		if states == nil {
+			if os.Getenv("REPORT_CHUNK_PROBLEMS") == "yes" || os.Getenv("REPORT_CHUNK_PROBLEMS") == "all" {
+				log.Printf("Chunk %d:%d section %d has broken pallete", chunk.XPos, chunk.YPos, s.Y)
+			}
+			continue
+		}
+		for y := minInt(15, ymax-sy); y >= 0 && sy+y >= ymin; y-- {
+			for i := 16*16 - 1; i >= 0; i-- {
+				if done[i] {
+					continue
+				}
+				if c := lookup(states.Get(y*16*16 + i)); c != nil {
+					done[i] = true
+					img.Set(i%16, i/16, *c)
+				}
+			}
+		}
+	}
+	appendMetrics(time.Since(t), "xray")
+	return img
+}
@@ -0,0 +1,170 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// slippy map indexes are never negative, without centering only positive
+// quarter of the world could be reached so it is on by default
+func xyzCentered() bool {
+	return cfg.GetDSBool(true, "web", "xyz", "center_origin")
+}
+
+// xyzToTile converts slippy map tile address into native one, at max_zoom one tile is one chunk
+// and every zoom level out doubles amount of chunks on the tile
+func xyzToTile(z, x, y int) (cs, cx, cz int, ok bool) {
+	maxZoom := cfg.GetDSInt(8, "web", "xyz", "max_zoom")
+	centered := xyzCentered()
+	flip := cfg.GetDSBool(false, "web", "xyz", "flip_y")
+	if z < 0 || z > maxZoom || z > 30 {
+		return 0, 0, 0, false
+	}
+	if centered && z == 0 {
+		return 0, 0, 0, false // origin would be in the middle of the only tile
+	}
+	if flip && !centered {
+		return 0, 0, 0, false // every row would be below origin
+	}
+	cs = maxZoom - z
+	if flip {
+		// TMS counts rows from the bottom
+		y = -y - 1
+		if centered {
+			y += 1 << z
+		}
+	}
+	if centered {
+		// tile indexes of slippy maps start at 0, shift world origin to the middle of the grid
+		x -= 1 << (z - 1)
+		y -= 1 << (z - 1)
+	}
+	return cs, x, y, true
+}
+
+func xyzTileHandler(w http.ResponseWriter, r *http.Request) {
+	params := mux.Vars(r)
+	z, errz := strconv.Atoi(params["z"])
+	x, errx := strconv.Atoi(params["x"])
+	y, erry := strconv.Atoi(params["y"])
+	if errz != nil || errx != nil || erry != nil {
+		plainmsg(w, r, plainmsgColorRed, "Bad tile address")
+		return
+	}
+	cs, cx, cz, ok := xyzToTile(z, x, y)
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	r = mux.SetURLVars(r, map[string]string{
+		"world":  params["world"],
+		"dim":    params["dim"],
+		"ttype":  params["ttype"],
+		"cs":     strconv.Itoa(cs),
+		"cx":     strconv.Itoa(cx),
+		"cz":     strconv.Itoa(cz),
+		"format": params["format"],
+	})
+	tileRouterHandler(w, r)
+}
+
+// tileJSON describes tile set to map libraries, see
+// https://github.com/mapbox/tilejson-spec/tree/master/3.0.0
+type tileJSON struct {
+	TileJSON     string                `json:"tilejson"`
+	Name         string                `json:"name,omitempty"`
+	Attribution  string                `json:"attribution,omitempty"`
+	Scheme       string                `json:"scheme"`
+	Tiles        []string              `json:"tiles"`
+	MinZoom      int                   `json:"minzoom"`
+	MaxZoom      int                   `json:"maxzoom"`
+	VectorLayers []tileJSONVectorLayer `json:"vector_layers,omitempty"`
+}
+
+type tileJSONVectorLayer struct {
+	ID      string            `json:"id"`
+	Fields  map[string]string `json:"fields"`
+	MinZoom int               `json:"minzoom,omitempty"`
+}
+
+// tile urls keep query of the descriptor request so param layers and
+// signed links carry over to tiles
+func newTileJSON(r *http.Request, prefix, ext string) tileJSON {
+	q := r.URL.Query()
+	q.Del("format")
+	tiles := requestBaseURL(r) + prefix + "/{z}/{x}/{y}." + ext
+	if len(q) > 0 {
+		tiles += "?" + q.Encode()
+	}
+	ret := tileJSON{
+		TileJSON:    "3.0.0",
+		Attribution: "WebChunk",
+		Scheme:      "xyz",
+		Tiles:       []string{tiles},
+		MaxZoom:     cfg.GetDSInt(8, "web", "xyz", "max_zoom"),
+	}
+	if cfg.GetDSBool(false, "web", "xyz", "flip_y") {
+		ret.Scheme = "tms"
+	}
+	if xyzCentered() {
+		ret.MinZoom = 1
+	}
+	return ret
+}
+
+func apiXyzTileJSON(w http.ResponseWriter, r *http.Request) (int, string) {
+	params := mux.Vars(r)
+	wname, dname, layer := params["world"], params["dim"], params["ttype"]
+	if _, code, err := lookupDim(wname, dname); err != nil {
+		return code, err.Error()
+	}
+	var found *ttype
+	for t := range ttypes.All() {
+		if t.Name == layer {
+			t := t
+			found = &t
+			break
+		}
+	}
+	if found == nil {
+		return 404, "Layer not found"
+	}
+	if !layerAllowed(r, wname, dname, layer) {
+		return 403, "Layer is not allowed"
+	}
+	format := r.URL.Query().Get("format")
+	switch format {
+	case "":
+		format = "png"
+	case "jpeg", "png", "webp", "avif", "auto":
+	default:
+		return 400, "Bad encoding"
+	}
+	ret := newTileJSON(r, "/xyz/"+url.PathEscape(wname)+"/"+url.PathEscape(dname)+"/"+url.PathEscape(layer), format)
+	ret.Name = wname + " " + dname + " " + found.DisplayName
+	setContentTypeJson(w)
+	return marshalOrFail(200, ret)
+}
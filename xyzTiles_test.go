@@ -0,0 +1,59 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import "testing"
+
+func TestXyzToTile(t *testing.T) {
+	defer cfg.Set(8, "web", "xyz", "max_zoom")
+	defer cfg.Set(true, "web", "xyz", "center_origin")
+	defer cfg.Set(false, "web", "xyz", "flip_y")
+	cfg.Set(8, "web", "xyz", "max_zoom")
+	for _, c := range []struct {
+		name           string
+		centered, flip bool
+		z, x, y        int
+		cs, cx, cz     int
+		ok             bool
+	}{
+		{"centered -x -z", true, false, 8, 127, 127, 0, -1, -1, true},
+		{"centered +x -z", true, false, 8, 128, 127, 0, 0, -1, true},
+		{"centered -x +z", true, false, 8, 127, 128, 0, -1, 0, true},
+		{"centered +x +z", true, false, 8, 128, 128, 0, 0, 0, true},
+		{"centered corner", true, false, 8, 0, 255, 0, -128, 127, true},
+		{"centered zoomed out", true, false, 1, 0, 1, 7, -1, 0, true},
+		{"centered zoom 0", true, false, 0, 0, 0, 0, 0, 0, false},
+		{"flipped -x -z", true, true, 8, 127, 128, 0, -1, -1, true},
+		{"flipped +x -z", true, true, 8, 128, 128, 0, 0, -1, true},
+		{"flipped -x +z", true, true, 8, 127, 127, 0, -1, 0, true},
+		{"flipped +x +z", true, true, 8, 128, 127, 0, 0, 0, true},
+		{"not centered", false, false, 8, 3, 5, 0, 3, 5, true},
+		{"flipped not centered", false, true, 8, 3, 5, 0, 0, 0, false},
+		{"zoom over max", true, false, 9, 0, 0, 0, 0, 0, false},
+	} {
+		cfg.Set(c.centered, "web", "xyz", "center_origin")
+		cfg.Set(c.flip, "web", "xyz", "flip_y")
+		cs, cx, cz, ok := xyzToTile(c.z, c.x, c.y)
+		if ok != c.ok || (ok && (cs != c.cs || cx != c.cx || cz != c.cz)) {
+			t.Errorf("%s: got %d %d %d %v, want %d %d %d %v", c.name, cs, cx, cz, ok, c.cs, c.cx, c.cz, c.ok)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// way past any build limit, used when range is not limited
+const (
+	yRangeMin = -2048
+	yRangeMax = 2048
+)
+
+// layers that can be cut down to a vertical slab with ?ymin= and ?ymax=,
+// every slab is cached as its own variant named <layer>_y<min>_<max>
+func yRangeParamLayer(layer string, provider func(ymin, ymax int) ttypeProviderFunc) paramLayer {
+	return paramLayer{
+		variant: func(r *http.Request) (string, error) {
+			ymin, ymax, err := yRangeParams(r)
+			if err != nil {
+				return "", err
+			}
+			if ymin == yRangeMin && ymax == yRangeMax {
+				return layer, nil
+			}
+			return layer + "_y" + strconv.Itoa(ymin) + "_" + strconv.Itoa(ymax), nil
+		},
+		provider: func(variant string) (ttypeProviderFunc, bool) {
+			if variant == layer {
+				return provider(yRangeMin, yRangeMax), true
+			}
+			ys, ok := strings.CutPrefix(variant, layer+"_y")
+			if !ok {
+				return nil, false
+			}
+			mins, maxs, ok := strings.Cut(ys, "_")
+			if !ok {
+				return nil, false
+			}
+			ymin, err := strconv.Atoi(mins)
+			if err != nil {
+				return nil, false
+			}
+			ymax, err := strconv.Atoi(maxs)
+			if err != nil {
+				return nil, false
+			}
+			return provider(ymin, ymax), true
+		},
+	}
+}
+
+func yRangeParams(r *http.Request) (ymin, ymax int, err error) {
+	parse := func(key string, def int) (int, error) {
+		s := r.URL.Query().Get(key)
+		if s == "" {
+			return def, nil
+		}
+		v, err := strconv.Atoi(s)
+		if err != nil {
+			return 0, errors.New("bad " + key + ": " + err.Error())
+		}
+		if v < yRangeMin || v > yRangeMax {
+			return 0, errors.New(key + " is out of range")
+		}
+		return v, nil
+	}
+	ymin, err = parse("ymin", yRangeMin)
+	if err != nil {
+		return
+	}
+	ymax, err = parse("ymax", yRangeMax)
+	if err != nil {
+		return
+	}
+	if ymin > ymax {
+		err = errors.New("ymin is above ymax")
+	}
+	return
+}